@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CachePurgeResponse is the body of DELETE /admin/cache.
+type CachePurgeResponse struct {
+	Status        string `json:"status" example:"purged"`
+	MemoryDeleted int    `json:"memory_deleted"`
+	RedisDeleted  int64  `json:"redis_deleted"`
+}
+
+// CacheDeleteResponse is the body of DELETE /admin/cache/:key.
+type CacheDeleteResponse struct {
+	Status string `json:"status" example:"deleted"`
+	Key    string `json:"key"`
+}
+
+// AdminAuthMiddleware protects operator endpoints with a static bearer token
+// from ADMIN_API_TOKEN. When the token is unset the admin API is disabled
+// and every request is rejected, so it can't be left open by accident.
+// Authenticated calls and wrong tokens are recorded in the audit log.
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv("ADMIN_API_TOKEN")
+		if token == "" {
+			abortWithProblem(c, newProblem(403, codeAdminDisabled, "Forbidden", "Admin API is disabled (ADMIN_API_TOKEN not set)"))
+			return
+		}
+
+		if !isAdminRequest(c) {
+			abortWithProblem(c, newProblem(401, codeUnauthorized, "Unauthorized", "Invalid or missing admin token"))
+			recordAdminAudit(c, auditAdminAuthFailed)
+			return
+		}
+
+		c.Next()
+		recordAdminAudit(c, auditAdminRequest)
+	}
+}
+
+// handlePurgeCache handles DELETE /admin/cache, removing every cached AI
+// response from the in-memory and Redis tiers.
+func handlePurgeCache(c *gin.Context) {
+	memoryDeleted, redisDeleted, err := purgeCache(c.Request.Context())
+	auditAdminAction(c, auditCachePurge, map[string]string{
+		"memory_deleted": strconv.Itoa(memoryDeleted), "redis_deleted": strconv.FormatInt(redisDeleted, 10),
+	})
+	if err != nil {
+		log.Printf("error purging cache: %v", err)
+		abortWithProblem(c, newProblem(500, codeCacheOperationFailed, "Failed to purge cache", err.Error()).
+			With("memory_deleted", memoryDeleted).
+			With("redis_deleted", redisDeleted))
+		return
+	}
+
+	log.Printf("Admin purged response cache (memory: %d, redis: %d)", memoryDeleted, redisDeleted)
+	c.JSON(200, CachePurgeResponse{Status: "purged", MemoryDeleted: memoryDeleted, RedisDeleted: redisDeleted})
+}
+
+// handleDeleteCacheKey handles DELETE /admin/cache/:key. The key may be given
+// in full or without its prefix (<tenant>:<model>:<prompt>:<hash>), which is
+// resolved against the current key version.
+func handleDeleteCacheKey(c *gin.Context) {
+	key := c.Param("key")
+	if !strings.HasPrefix(key, cacheKeyPrefix) {
+		key = currentCacheKeyPrefix() + key
+	}
+
+	found, err := deleteCachedResponse(c.Request.Context(), key)
+	auditAdminAction(c, auditCacheDelete, map[string]string{"key": key, "found": strconv.FormatBool(found)})
+	if err != nil {
+		log.Printf("error deleting cache key %s: %v", key, err)
+		abortWithProblem(c, newProblem(500, codeCacheOperationFailed, "Failed to delete cache entry", err.Error()))
+		return
+	}
+	if !found {
+		abortWithProblem(c, newProblem(404, codeCacheEntryNotFound, "Cache entry not found", "Entry may have expired or never existed").
+			With("key", key))
+		return
+	}
+
+	log.Printf("Admin deleted cache entry %s", key)
+	c.JSON(200, CacheDeleteResponse{Status: "deleted", Key: key})
+}
+
+// handleCacheStats handles GET /admin/cache/stats.
+func handleCacheStats(c *gin.Context) {
+	c.JSON(200, getCacheStats(c.Request.Context()))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupAdminRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	admin := r.Group("/admin", AdminListenerMiddleware(), AdminAuthMiddleware())
+	admin.GET("/cache/stats", handleCacheStats)
+	admin.DELETE("/cache", handlePurgeCache)
+	admin.DELETE("/cache/:key", handleDeleteCacheKey)
+	admin.GET("/abuse", handleAbuseReport)
+	admin.GET("/reputation", handleReputationReport)
+	admin.GET("/reputation/:wallet", handleWalletReputation)
+	admin.GET("/export/usage", handleExportUsage)
+	admin.GET("/reconciliation", handleReconciliationReport)
+	admin.GET("/stats", handleAdminStats)
+	admin.GET("/audit", handleAuditLog)
+	admin.GET("/audit/verify", handleAuditVerify)
+	admin.GET("/ip-acl", handleGetIPACL)
+	admin.PUT("/ip-acl", handlePutIPACL)
+	admin.GET("/promo-codes", handleListPromoCodes)
+	admin.PUT("/promo-codes/:code", handlePutPromoCode)
+	admin.DELETE("/promo-codes/:code", handleDeletePromoCode)
+	admin.GET("/tenants", handleListTenants)
+	admin.GET("/tenants/:id", handleGetTenant)
+	admin.PUT("/tenants/:id", handlePutTenant)
+	admin.DELETE("/tenants/:id", handleDeleteTenant)
+	admin.GET("/ratelimits", handleListRateLimits)
+	admin.PUT("/ratelimits/overrides", handlePutRateLimitOverride)
+	admin.DELETE("/ratelimits/overrides/:key", handleDeleteRateLimitOverride)
+	admin.POST("/refunds", handleCreateRefund)
+	admin.POST("/channels/:id/close", handleAdminCloseChannel)
+	return r
+}
+
+func adminRequest(r *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	r := setupAdminRouter()
+
+	t.Setenv("ADMIN_API_TOKEN", "")
+	if w := adminRequest(r, "DELETE", "/admin/cache", "anything"); w.Code != 403 {
+		t.Errorf("Expected 403 when admin API disabled, got %d", w.Code)
+	}
+
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	if w := adminRequest(r, "DELETE", "/admin/cache", ""); w.Code != 401 {
+		t.Errorf("Expected 401 without token, got %d", w.Code)
+	}
+	if w := adminRequest(r, "DELETE", "/admin/cache", "wrong"); w.Code != 401 {
+		t.Errorf("Expected 401 with wrong token, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteCacheKey(t *testing.T) {
+	setupTestRedis(t)
+	l1 := setupTestMemoryCache(t, 10, time.Minute)
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	ctx := context.Background()
+
+	key := getCacheKey(context.Background(), "m", "poisoned text", nil)
+	if err := setCachedResponse(ctx, key, "poisoned text", "bad summary"); err != nil {
+		t.Fatalf("setCachedResponse failed: %v", err)
+	}
+
+	// The key without its prefix is accepted
+	unprefixed := key[len(currentCacheKeyPrefix()):]
+	if w := adminRequest(r, "DELETE", "/admin/cache/"+unprefixed, "secret"); w.Code != 200 {
+		t.Fatalf("Expected 200, got %d; body=%s", w.Code, w.Body.String())
+	}
+
+	if _, ok := l1.Get(key); ok {
+		t.Error("Expected entry removed from memory cache")
+	}
+	if _, ok := getCachedResponse(ctx, key, "poisoned text"); ok {
+		t.Error("Expected entry removed from Redis")
+	}
+
+	if w := adminRequest(r, "DELETE", "/admin/cache/"+key, "secret"); w.Code != 404 {
+		t.Errorf("Expected 404 for already deleted key, got %d", w.Code)
+	}
+}
+
+func TestHandlePurgeCache(t *testing.T) {
+	mr := setupTestRedis(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	ctx := context.Background()
+
+	for _, text := range []string{"a", "b", "c"} {
+		if err := setCachedResponse(ctx, getCacheKey(context.Background(), "m", text, nil), text, "summary"); err != nil {
+			t.Fatalf("setCachedResponse failed: %v", err)
+		}
+	}
+	// Unrelated keys in a shared Redis must survive the purge
+	mr.Set("other:key", "keep")
+
+	w := adminRequest(r, "DELETE", "/admin/cache", "secret")
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d; body=%s", w.Code, w.Body.String())
+	}
+
+	if memoryCache.Len() != 0 {
+		t.Errorf("Expected memory cache empty, got %d entries", memoryCache.Len())
+	}
+	if keys := mr.Keys(); len(keys) != 1 || keys[0] != "other:key" {
+		t.Errorf("Expected only unrelated key to remain, got %v", keys)
+	}
+}
+
+func TestHandleCacheStats(t *testing.T) {
+	setupTestRedis(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	ctx := context.Background()
+
+	key := getCacheKey(context.Background(), "m", "stats text", nil)
+	if err := setCachedResponse(ctx, key, "stats text", "summary"); err != nil {
+		t.Fatalf("setCachedResponse failed: %v", err)
+	}
+	hitsBefore := cacheRequestsTotal.Value("hit")
+	getCachedResponse(ctx, key, "stats text")
+	getCachedResponse(ctx, getCacheKey(context.Background(), "m", "absent", nil), "absent")
+
+	w := adminRequest(r, "GET", "/admin/cache/stats", "secret")
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d; body=%s", w.Code, w.Body.String())
+	}
+
+	var stats CacheStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to parse stats JSON: %v", err)
+	}
+	if stats.Hits != hitsBefore+1 {
+		t.Errorf("Expected %v hits, got %v", hitsBefore+1, stats.Hits)
+	}
+	if stats.HitRate <= 0 || stats.HitRate >= 1 {
+		t.Errorf("Expected hit rate between 0 and 1, got %v", stats.HitRate)
+	}
+	if !stats.RedisEnabled || stats.RedisKeys != 1 || stats.MemoryKeys != 1 {
+		t.Errorf("Unexpected key counts: %+v", stats)
+	}
+	if stats.RedisPool == nil {
+		t.Error("Expected Redis pool stats")
+	}
+}
+
+func TestParseRedisInfo(t *testing.T) {
+	info := "# Memory\r\nused_memory:1024\r\nused_memory_human:1.00K\r\nother:x\r\n"
+	got := parseRedisInfo(info, "used_memory", "used_memory_human")
+	if got["used_memory"] != "1024" || got["used_memory_human"] != "1.00K" || len(got) != 2 {
+		t.Errorf("Unexpected parse result: %v", got)
+	}
+}
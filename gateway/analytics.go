@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// usageWindows are the time windows GET /v1/usage/:wallet accepts; "all"
+// covers the whole ledger.
+var usageWindows = map[string]time.Duration{
+	"1h": time.Hour, "24h": 24 * time.Hour, "7d": 7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour, "90d": 90 * 24 * time.Hour, "all": 0,
+}
+
+// WalletUsageResponse is the body of GET /v1/usage/:wallet.
+type WalletUsageResponse struct {
+	Wallet           string    `json:"wallet" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Window           string    `json:"window" example:"30d"`
+	Since            time.Time `json:"since,omitempty" doc:"Start of the window; absent for window=all"`
+	Until            time.Time `json:"until"`
+	Requests         int       `json:"requests" doc:"Paid requests in the window"`
+	CacheHits        int       `json:"cache_hits" doc:"Requests served from the response cache"`
+	Spend            string    `json:"spend" doc:"USDC charged" example:"0.042"`
+	Refunded         string    `json:"refunded,omitempty" doc:"USDC refunded; not deducted from spend" example:"0.001"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	ProviderCost     string    `json:"provider_cost,omitempty" doc:"Upstream AI cost in USD as reported by the provider"`
+	CacheSavings     string    `json:"cache_savings,omitempty" doc:"Estimated upstream cost in USD the cache hits avoided, at the window's average cost per AI call"`
+}
+
+// handleWalletUsage handles GET /v1/usage/:wallet, summarizing the wallet's
+// ledger entries over ?window= (default 30d). The caller must either hold
+// the admin token or sign the usage challenge with the wallet (see
+// checkWalletAuth).
+func handleWalletUsage(c *gin.Context) {
+	wallet, ok := resolveWalletParam(c, c.Param("wallet"))
+	if !ok {
+		return
+	}
+
+	windowName := c.DefaultQuery("window", "30d")
+	window, ok := usageWindows[windowName]
+	if !ok {
+		abortWithProblem(c, newProblem(400, codeInvalidWindow, "Invalid Window", fmt.Sprintf("Unknown window %q", windowName)).
+			With("windows", []string{"1h", "24h", "7d", "30d", "90d", "all"}))
+		return
+	}
+
+	if !isAdminRequest(c) && !checkWalletAuth(c, wallet) {
+		return
+	}
+	if usageLedger == nil {
+		abortWithProblem(c, newProblem(503, codeLedgerDisabled, "Usage Unavailable", "The usage ledger is not enabled (LEDGER_BACKEND)"))
+		return
+	}
+
+	now := time.Now().UTC()
+	q := ledgerQuery{Wallet: wallet, Until: now}
+	if window > 0 {
+		q.Since = now.Add(-window)
+	}
+	entries, err := usageLedger.Entries(c.Request.Context(), q)
+	if err != nil {
+		log.Printf("error reading ledger for %s: %v", wallet, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read usage ledger", ""))
+		return
+	}
+
+	resp := summarizeUsage(entries)
+	resp.Wallet, resp.Window, resp.Since, resp.Until = wallet, windowName, q.Since, now
+	c.JSON(200, resp)
+}
+
+// summarizeUsage totals ledger entries. Amounts are added exactly; cache
+// savings price each cache hit at the average reported cost of the entries
+// that made an AI call.
+func summarizeUsage(entries []LedgerEntry) WalletUsageResponse {
+	var resp WalletUsageResponse
+	spend, refunded, cost := new(big.Rat), new(big.Rat), new(big.Rat)
+	costed := 0
+	for _, e := range entries {
+		if e.Type == ledgerTypeSettlement {
+			continue
+		}
+		if e.Type == ledgerTypeRefund {
+			if amount, ok := new(big.Rat).SetString(e.Amount); ok {
+				refunded.Add(refunded, amount)
+			}
+			continue
+		}
+		resp.Requests++
+		if e.CacheHit {
+			resp.CacheHits++
+		}
+		if amount, ok := new(big.Rat).SetString(e.Amount); ok {
+			spend.Add(spend, amount)
+		}
+		resp.PromptTokens += e.PromptTokens
+		resp.CompletionTokens += e.CompletionTokens
+		if c, ok := new(big.Rat).SetString(e.ProviderCost); ok && e.ProviderCost != "" {
+			cost.Add(cost, c)
+			costed++
+		}
+	}
+	resp.Spend = formatAmount(spend)
+	if refunded.Sign() > 0 {
+		resp.Refunded = formatAmount(refunded)
+	}
+	if costed > 0 {
+		resp.ProviderCost = formatUSD(cost)
+		savings := new(big.Rat).Mul(cost, big.NewRat(int64(resp.CacheHits), int64(costed)))
+		resp.CacheSavings = formatUSD(savings)
+	}
+	return resp
+}
+
+// formatUSD formats a provider cost, which is often below a micro-dollar,
+// to 10 decimal places without trailing zeros.
+func formatUSD(r *big.Rat) string {
+	s := strings.TrimRight(r.FloatString(10), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// isAdminRequest reports whether the request carries the admin token.
+func isAdminRequest(c *gin.Context) bool {
+	token := os.Getenv("ADMIN_API_TOKEN")
+	provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// getUsageAuthMaxAge returns USAGE_AUTH_MAX_AGE_SECONDS (default 300), how
+// old a signed usage challenge may be.
+func getUsageAuthMaxAge() time.Duration {
+	return time.Duration(getEnvAsInt("USAGE_AUTH_MAX_AGE_SECONDS", 300)) * time.Second
+}
+
+// usageChallenge is the message a wallet signs (EIP-191 personal_sign) to
+// read its own usage.
+func usageChallenge(wallet string, timestamp int64) string {
+	return fmt.Sprintf("MicroAI-Paygate usage access for %s at %d", strings.ToLower(wallet), timestamp)
+}
+
+// checkWalletAuth verifies X-Wallet-Signature, the wallet's signature of
+// usageChallenge at X-Wallet-Timestamp. Without them it answers 401 with a
+// fresh challenge to sign, like the 402 flow of paid routes. It reports
+// whether the request may proceed and has aborted it otherwise.
+func checkWalletAuth(c *gin.Context, wallet string) bool {
+	return checkSignedChallenge(c, wallet, usageChallenge, getUsageAuthMaxAge(), ", or use the admin token")
+}
+
+// checkSignedChallenge verifies X-Wallet-Signature, the wallet's signature
+// of challenge at X-Wallet-Timestamp, no older than maxAge. The 401 for a
+// missing signature ends its detail with alternative.
+func checkSignedChallenge(c *gin.Context, wallet string, challenge func(string, int64) string, maxAge time.Duration, alternative string) bool {
+	signature := c.GetHeader("X-Wallet-Signature")
+	rawTimestamp := c.GetHeader("X-Wallet-Timestamp")
+	if signature == "" || rawTimestamp == "" {
+		now := time.Now().Unix()
+		abortWithProblem(c, newProblem(401, codeUnauthorized, "Unauthorized",
+			"Sign the challenge message with the wallet (personal_sign) and send X-Wallet-Signature and X-Wallet-Timestamp"+alternative).
+			With("challenge", challenge(wallet, now)).
+			With("timestamp", now))
+		return false
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	age := time.Since(time.Unix(timestamp, 0))
+	if err != nil || age > maxAge || age < -time.Minute {
+		abortWithProblem(c, newProblem(401, codeUnauthorized, "Unauthorized", "X-Wallet-Timestamp is missing, malformed or expired; request a new challenge"))
+		return false
+	}
+
+	signer, err := recoverPersonalSigner(challenge(wallet, timestamp), signature)
+	if err != nil || !strings.EqualFold(signer, wallet) {
+		abortWithProblem(c, newProblem(401, codeUnauthorized, "Unauthorized", "X-Wallet-Signature was not made by the wallet"))
+		return false
+	}
+	return true
+}
+
+// recoverPersonalSigner returns the address that signed message with
+// personal_sign, which prefixes it as EIP-191 requires.
+func recoverPersonalSigner(message, signature string) (string, error) {
+	return recoverSigner(crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message))), signature)
+}
+
+// recoverSigner returns the address whose key made signature, a
+// 0x-prefixed 65-byte signature with an Ethereum-style recovery id, over
+// digest.
+func recoverSigner(digest []byte, signature string) (string, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil || len(sig) != 65 {
+		return "", fmt.Errorf("invalid signature format")
+	}
+	sig = append([]byte(nil), sig...)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	pub, err := crypto.SigToPub(digest, sig)
+	if err != nil {
+		return "", err
+	}
+	return crypto.PubkeyToAddress(*pub).Hex(), nil
+}
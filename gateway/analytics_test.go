@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// personalSign signs message as a wallet's personal_sign would.
+func personalSign(t *testing.T, key *ecdsa.PrivateKey, message string) string {
+	t.Helper()
+	digest := crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message)) + message))
+	sig, err := crypto.Sign(digest, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[64] += 27
+	return hexutil.Encode(sig)
+}
+
+func TestSummarizeUsage(t *testing.T) {
+	got := summarizeUsage([]LedgerEntry{
+		{Amount: "0.001", PromptTokens: 100, CompletionTokens: 20, ProviderCost: "0.0002"},
+		{Amount: "0.002", PromptTokens: 300, CompletionTokens: 40, ProviderCost: "0.0004"},
+		{Amount: "0.001", CacheHit: true},
+	})
+	if got.Requests != 3 || got.CacheHits != 1 || got.Spend != "0.004" || got.PromptTokens != 400 || got.CompletionTokens != 60 {
+		t.Errorf("Unexpected totals %+v", got)
+	}
+	if got.ProviderCost != "0.0006" || got.CacheSavings != "0.0003" {
+		t.Errorf("Expected cost 0.0006 and savings at the 0.0003 average, got %s and %s", got.ProviderCost, got.CacheSavings)
+	}
+}
+
+func TestHandleWalletUsage(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	ledger := setupTestLedger(t)
+	r := setupVersionedRouter()
+
+	key, _ := crypto.GenerateKey()
+	wallet := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	now := time.Now().Unix()
+	sig := personalSign(t, key, usageChallenge(wallet, now))
+
+	ctx := context.Background()
+	ledger.Append(ctx, LedgerEntry{Time: time.Now().Add(-2 * time.Hour), Wallet: wallet, Amount: "0.001", PromptTokens: 10})
+	ledger.Append(ctx, LedgerEntry{Time: time.Now().Add(-time.Minute), Wallet: wallet, Amount: "0.002", CacheHit: true})
+	ledger.Append(ctx, LedgerEntry{Time: time.Now(), Wallet: "0x0000000000000000000000000000000000000001", Amount: "0.5"})
+
+	get := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/v1/usage/"+wallet, nil)
+	if w.Code != 401 {
+		t.Fatalf("Expected 401 without auth, got %d", w.Code)
+	}
+	if p := decodeProblem(t, w); p["challenge"] == nil {
+		t.Errorf("Expected a challenge to sign, got %v", p)
+	}
+
+	signed := map[string]string{"X-Wallet-Signature": sig, "X-Wallet-Timestamp": strconv.FormatInt(now, 10)}
+	w = get("/v1/usage/"+wallet+"?window=1h", signed)
+	var resp WalletUsageResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Requests != 1 || resp.CacheHits != 1 || resp.Spend != "0.002" {
+		t.Fatalf("Expected the last hour's single cache hit, got %d %+v", w.Code, resp)
+	}
+
+	// The signature is only good for the wallet that made it
+	otherKey, _ := crypto.GenerateKey()
+	otherSig := personalSign(t, otherKey, usageChallenge(wallet, now))
+	if w := get("/v1/usage/"+wallet, map[string]string{"X-Wallet-Signature": otherSig, "X-Wallet-Timestamp": strconv.FormatInt(now, 10)}); w.Code != 401 {
+		t.Errorf("Expected another wallet's signature to be rejected, got %d", w.Code)
+	}
+	stale := strconv.FormatInt(now-3600, 10)
+	if w := get("/v1/usage/"+wallet, map[string]string{"X-Wallet-Signature": sig, "X-Wallet-Timestamp": stale}); w.Code != 401 {
+		t.Errorf("Expected an expired challenge to be rejected, got %d", w.Code)
+	}
+
+	w = get("/api/usage/"+wallet+"?window=all", map[string]string{"Authorization": "Bearer admin-secret"})
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Requests != 2 || resp.Spend != "0.003" {
+		t.Errorf("Expected the admin to see both entries, got %d %+v", w.Code, resp)
+	}
+
+	if w := get("/v1/usage/not-a-wallet", nil); w.Code != 400 {
+		t.Errorf("Expected 400 for a malformed wallet, got %d", w.Code)
+	}
+	if w := get("/v1/usage/"+wallet+"?window=1y", nil); w.Code != 400 {
+		t.Errorf("Expected 400 for an unknown window, got %d", w.Code)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// receiptAnchor batches issued receipts into Merkle trees. It is nil unless
+// RECEIPT_ANCHOR_FILE is set.
+var receiptAnchor *anchorer
+
+var receiptAnchorsTotal = newCounter(
+	"gateway_receipt_anchors_total",
+	"Merkle roots of receipt batches, by outcome (queued, error).",
+	"outcome",
+)
+
+// Domain separation for the Merkle tree, so a leaf can never be passed off
+// as an inner node.
+var (
+	merkleLeafPrefix = []byte{0x00}
+	merkleNodePrefix = []byte{0x01}
+)
+
+// AnchorRecord is one batch root appended to RECEIPT_ANCHOR_FILE, for
+// whatever publishes it on-chain or to a public log.
+type AnchorRecord struct {
+	BatchID   string    `json:"batch_id" example:"anch_a1b2c3d4e5f6"`
+	Root      string    `json:"root" doc:"0x-prefixed Merkle root over the batch's receipts"`
+	Count     int       `json:"count" doc:"Receipts in the batch"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MerkleProofStep is one sibling on the path from a leaf to the root.
+type MerkleProofStep struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position" doc:"Side the sibling is on: left or right" example:"right"`
+}
+
+// ReceiptProofResponse is the body of GET /v1/receipts/:id/proof.
+type ReceiptProofResponse struct {
+	ReceiptID  string            `json:"receipt_id" example:"rcpt_a1b2c3d4e5f6"`
+	BatchID    string            `json:"batch_id" example:"anch_a1b2c3d4e5f6"`
+	Root       string            `json:"root" doc:"Merkle root published for the batch"`
+	Leaf       string            `json:"leaf" doc:"keccak256(0x00 || digest), where digest is the keccak256 of the canonical receipt"`
+	Index      int               `json:"index" doc:"Position of the leaf in the batch"`
+	Count      int               `json:"count" doc:"Receipts in the batch"`
+	Proof      []MerkleProofStep `json:"proof" doc:"Siblings from the leaf up; inner nodes are keccak256(0x01 || left || right)"`
+	AnchoredAt time.Time         `json:"anchored_at"`
+}
+
+// anchorBatch is a built tree, kept so proofs can be served from it.
+type anchorBatch struct {
+	record     AnchorRecord
+	receiptIDs []string
+	levels     [][]common.Hash // levels[0] holds the leaves
+}
+
+type anchorer struct {
+	queue     *jsonLinesQueue
+	interval  time.Duration
+	retention time.Duration
+
+	mu        sync.Mutex
+	pending   []string // receipt IDs, in issue order
+	leaves    []common.Hash
+	batches   []*anchorBatch
+	byReceipt map[string]receiptPosition
+	nextBuild time.Time
+}
+
+type receiptPosition struct {
+	batch *anchorBatch
+	index int
+}
+
+// initReceiptAnchor enables anchoring when RECEIPT_ANCHOR_FILE is set.
+// Batches are built every RECEIPT_ANCHOR_INTERVAL_SECONDS (default 3600)
+// and kept for proofs as long as receipts are.
+func initReceiptAnchor() *anchorer {
+	queue := openJSONLinesQueue("RECEIPT_ANCHOR_FILE", "receipt anchor roots")
+	if queue == nil {
+		return nil
+	}
+	return newAnchorer(queue, time.Duration(getEnvAsInt("RECEIPT_ANCHOR_INTERVAL_SECONDS", 3600))*time.Second, getReceiptTTL())
+}
+
+func newAnchorer(queue *jsonLinesQueue, interval, retention time.Duration) *anchorer {
+	return &anchorer{
+		queue:     queue,
+		interval:  interval,
+		retention: retention,
+		byReceipt: make(map[string]receiptPosition),
+		nextBuild: time.Now().Add(interval),
+	}
+}
+
+// start builds a batch every interval until ctx is done, then builds one
+// last batch from what is pending.
+func (a *anchorer) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.build(time.Now())
+				return
+			case now := <-ticker.C:
+				a.build(now)
+			}
+		}
+	}()
+}
+
+// anchorReceipt adds an issued receipt to the next batch, if anchoring is
+// enabled.
+func anchorReceipt(receipt *SignedReceipt) {
+	if receiptAnchor == nil {
+		return
+	}
+	_, digest, err := receiptDigest(receipt.Receipt)
+	if err != nil {
+		log.Printf("error anchoring receipt %s: %v", receipt.Receipt.ID, err)
+		return
+	}
+	receiptAnchor.add(receipt.Receipt.ID, digest)
+}
+
+func (a *anchorer) add(receiptID string, digest common.Hash) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = append(a.pending, receiptID)
+	a.leaves = append(a.leaves, merkleLeaf(digest))
+}
+
+// build turns the pending receipts into a batch and queues its root. An
+// empty interval produces no batch.
+func (a *anchorer) build(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextBuild = now.Add(a.interval)
+	a.prune(now)
+	if len(a.leaves) == 0 {
+		return
+	}
+
+	id, err := generateBatchID()
+	if err != nil {
+		log.Printf("error building receipt anchor batch: %v", err)
+		return
+	}
+	levels := merkleLevels(a.leaves)
+	batch := &anchorBatch{
+		record:     AnchorRecord{BatchID: id, Root: levels[len(levels)-1][0].Hex(), Count: len(a.leaves), CreatedAt: now.UTC()},
+		receiptIDs: a.pending,
+		levels:     levels,
+	}
+	// The batch is served even when queuing fails; the root can be
+	// republished from the log line below
+	if err := a.queue.push(batch.record); err != nil {
+		receiptAnchorsTotal.Inc("error")
+		log.Printf("error queuing receipt anchor %s (root %s): %v", id, batch.record.Root, err)
+	} else {
+		receiptAnchorsTotal.Inc("queued")
+	}
+	for i, receiptID := range a.pending {
+		a.byReceipt[receiptID] = receiptPosition{batch: batch, index: i}
+	}
+	a.batches = append(a.batches, batch)
+	a.pending, a.leaves = nil, nil
+}
+
+// prune drops batches older than the retention. Caller holds a.mu.
+func (a *anchorer) prune(now time.Time) {
+	cutoff := now.Add(-a.retention)
+	kept := a.batches[:0]
+	for _, batch := range a.batches {
+		if batch.record.CreatedAt.After(cutoff) {
+			kept = append(kept, batch)
+			continue
+		}
+		for _, id := range batch.receiptIDs {
+			delete(a.byReceipt, id)
+		}
+	}
+	a.batches = kept
+}
+
+// proof returns the inclusion proof of a receipt. pending is true when the
+// receipt is waiting for the next batch.
+func (a *anchorer) proof(receiptID string) (resp *ReceiptProofResponse, pending bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pos, ok := a.byReceipt[receiptID]
+	if !ok {
+		for _, id := range a.pending {
+			if id == receiptID {
+				return nil, true
+			}
+		}
+		return nil, false
+	}
+	return &ReceiptProofResponse{
+		ReceiptID:  receiptID,
+		BatchID:    pos.batch.record.BatchID,
+		Root:       pos.batch.record.Root,
+		Leaf:       pos.batch.levels[0][pos.index].Hex(),
+		Index:      pos.index,
+		Count:      pos.batch.record.Count,
+		Proof:      merkleProof(pos.batch.levels, pos.index),
+		AnchoredAt: pos.batch.record.CreatedAt,
+	}, false
+}
+
+// untilNextBuild is how long until pending receipts get a proof.
+func (a *anchorer) untilNextBuild() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Until(a.nextBuild)
+}
+
+func merkleLeaf(digest common.Hash) common.Hash {
+	return crypto.Keccak256Hash(merkleLeafPrefix, digest.Bytes())
+}
+
+// merkleLevels builds the tree bottom-up. A node without a sibling is
+// carried up unchanged.
+func merkleLevels(leaves []common.Hash) [][]common.Hash {
+	levels := [][]common.Hash{append([]common.Hash(nil), leaves...)}
+	for level := levels[0]; len(level) > 1; {
+		next := make([]common.Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, crypto.Keccak256Hash(merkleNodePrefix, level[i].Bytes(), level[i+1].Bytes()))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+func merkleProof(levels [][]common.Hash, index int) []MerkleProofStep {
+	proof := []MerkleProofStep{}
+	for _, level := range levels[:len(levels)-1] {
+		if index%2 == 1 {
+			proof = append(proof, MerkleProofStep{Hash: level[index-1].Hex(), Position: "left"})
+		} else if index+1 < len(level) {
+			proof = append(proof, MerkleProofStep{Hash: level[index+1].Hex(), Position: "right"})
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// generateBatchID returns a random batch ID with the "anch_" prefix.
+func generateBatchID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "anch_" + hex.EncodeToString(b), nil
+}
+
+// handleReceiptProof handles GET /v1/receipts/:id/proof.
+func handleReceiptProof(c *gin.Context) {
+	if receiptAnchor == nil {
+		abortWithProblem(c, newProblem(503, codeAnchoringDisabled, "Receipt anchoring disabled", "Set RECEIPT_ANCHOR_FILE to anchor receipts"))
+		return
+	}
+	proof, pending := receiptAnchor.proof(c.Param("id"))
+	if pending {
+		wait := int(receiptAnchor.untilNextBuild().Seconds()) + 1
+		c.Header("Retry-After", strconv.Itoa(wait))
+		abortWithProblem(c, newProblem(404, codeProofPending, "Proof not ready", "The receipt will be included in the next anchor batch").With("retry_after", wait))
+		return
+	}
+	if proof == nil {
+		abortWithProblem(c, newProblem(404, codeReceiptNotFound, "Receipt not found", "Receipt may have expired, never existed or was issued before anchoring was enabled"))
+		return
+	}
+	c.JSON(200, proof)
+}
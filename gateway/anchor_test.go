@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupTestAnchor enables anchoring with roots queued to a temp file.
+func setupTestAnchor(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "anchors.jsonl")
+	t.Setenv("RECEIPT_ANCHOR_FILE", path)
+	prev := receiptAnchor
+	receiptAnchor = initReceiptAnchor()
+	t.Cleanup(func() {
+		receiptAnchor.queue.f.Close()
+		receiptAnchor = prev
+	})
+	return path
+}
+
+func TestMerkleProofs(t *testing.T) {
+	ensureTestServerKey(t)
+	// Odd sizes exercise the carried-up nodes
+	for _, n := range []int{1, 2, 5, 8} {
+		a := newAnchorer(openTestQueue(t), time.Hour, time.Hour)
+		var receipts []*SignedReceipt
+		for i := 0; i < n; i++ {
+			receipt, err := GenerateReceipt(PaymentContext{Recipient: "0xabc", Token: "USDC", Amount: "0.001", Nonce: "n", ChainID: 8453}, "0xdef", "/v1/ai/summarize", []byte{byte(i)}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, digest, _ := receiptDigest(receipt.Receipt)
+			a.add(receipt.Receipt.ID, digest)
+			receipts = append(receipts, receipt)
+		}
+		a.build(time.Now())
+
+		for i, receipt := range receipts {
+			proof, pending := a.proof(receipt.Receipt.ID)
+			if pending || proof == nil || proof.Index != i || proof.Count != n {
+				t.Fatalf("n=%d: expected a proof for receipt %d, got %+v", n, i, proof)
+			}
+			var clientProof client.ReceiptProof
+			var clientReceipt client.SignedReceipt
+			roundTrip(t, proof, &clientProof)
+			roundTrip(t, receipt, &clientReceipt)
+			if err := clientProof.Verify(&clientReceipt); err != nil {
+				t.Errorf("n=%d: proof of receipt %d doesn't verify: %v", n, i, err)
+			}
+			clientReceipt.Receipt.Payment.Amount = "100"
+			if clientProof.Verify(&clientReceipt) == nil {
+				t.Errorf("n=%d: expected a tampered receipt to fail the proof", n)
+			}
+		}
+	}
+}
+
+func TestAnchorer_PrunesOldBatches(t *testing.T) {
+	a := newAnchorer(openTestQueue(t), time.Hour, time.Hour)
+	a.add("rcpt_old", [32]byte{1})
+	start := time.Now()
+	a.build(start)
+	if proof, _ := a.proof("rcpt_old"); proof == nil {
+		t.Fatal("Expected a proof right after the build")
+	}
+	a.build(start.Add(2 * time.Hour))
+	if proof, _ := a.proof("rcpt_old"); proof != nil || len(a.batches) != 0 {
+		t.Error("Expected the batch to be dropped after the retention")
+	}
+}
+
+func TestHandleReceiptProof(t *testing.T) {
+	ensureTestServerKey(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/v1/receipts/:id/proof", handleReceiptProof)
+	get := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/receipts/"+id+"/proof", nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("rcpt_any"); w.Code != 503 || !strings.Contains(w.Body.String(), codeAnchoringDisabled) {
+		t.Fatalf("Expected 503 anchoring_disabled, got %d %s", w.Code, w.Body.String())
+	}
+
+	path := setupTestAnchor(t)
+	receipt, err := GenerateReceipt(PaymentContext{Recipient: "0xabc", Token: "USDC", Amount: "0.001", Nonce: "n", ChainID: 8453}, "0xdef", "/v1/ai/summarize", []byte("in"), []byte("out"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchorReceipt(receipt)
+
+	w := get(receipt.Receipt.ID)
+	if w.Code != 404 || !strings.Contains(w.Body.String(), codeProofPending) || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected 404 proof_pending with Retry-After, got %d %s", w.Code, w.Body.String())
+	}
+
+	receiptAnchor.build(time.Now())
+	w = get(receipt.Receipt.ID)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var proof ReceiptProofResponse
+	json.Unmarshal(w.Body.Bytes(), &proof)
+
+	data, _ := os.ReadFile(path)
+	var record AnchorRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Expected one queued anchor record, got %q", data)
+	}
+	if record.Root != proof.Root || record.BatchID != proof.BatchID || record.Count != 1 {
+		t.Errorf("Queued record %+v doesn't match the proof %+v", record, proof)
+	}
+
+	if w := get("rcpt_unknown"); w.Code != 404 || !strings.Contains(w.Body.String(), codeReceiptNotFound) {
+		t.Errorf("Expected 404 receipt_not_found, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func openTestQueue(t *testing.T) *jsonLinesQueue {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "queue.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return &jsonLinesQueue{path: f.Name(), f: f}
+}
+
+// roundTrip copies v into out through JSON, as a client would see it.
+func roundTrip(t *testing.T, v, out interface{}) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
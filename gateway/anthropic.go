@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// anthropicVersion is the Messages API version the request shape follows.
+const anthropicVersion = "2023-06-01"
+
+// anthropicProvider calls the Anthropic Messages API directly. Like the
+// OpenAI provider it requests a non-streamed response.
+type anthropicProvider struct{}
+
+// getAnthropicBaseURL returns ANTHROPIC_BASE_URL (default
+// https://api.anthropic.com).
+func getAnthropicBaseURL() string {
+	if v := os.Getenv("ANTHROPIC_BASE_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return "https://api.anthropic.com"
+}
+
+// Complete runs prompt on model, or ANTHROPIC_MODEL (default
+// claude-3-5-haiku-latest) when model is empty. The Messages API requires an
+// output cap, taken from the request's max_tokens or ANTHROPIC_MAX_TOKENS
+// (default 1024). Anthropic accepts temperatures up to 1 only, so higher
+// ones are capped there. It authenticates with ANTHROPIC_API_KEY, or the
+// tenant's own key.
+func (anthropicProvider) Complete(ctx context.Context, model, prompt string) (string, error) {
+	if model == "" {
+		model = os.Getenv("ANTHROPIC_MODEL")
+	}
+	if model == "" {
+		model = "claude-3-5-haiku-latest"
+	}
+
+	body := map[string]interface{}{
+		"model":      model,
+		"max_tokens": getEnvAsInt("ANTHROPIC_MAX_TOKENS", 1024),
+		"messages":   []map[string]string{{"role": "user", "content": prompt}},
+	}
+	gen := generationParamsFrom(ctx)
+	if gen.Temperature != nil && *gen.Temperature > 1 {
+		capped := 1.0
+		gen.Temperature = &capped
+	}
+	gen.applyTo(body)
+	reqBody, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", getAnthropicBaseURL()+"/v1/messages", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Anthropic request: %w", err)
+	}
+	req.Header.Set("x-api-key", providerAPIKey(ctx, "anthropic"))
+	req.Header.Set("anthropic-version", anthropicVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", context.DeadlineExceeded
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	// 529 (overloaded) is a 5xx, so it fails over like any other outage.
+	if resp.StatusCode >= 300 {
+		return "", newProviderStatusError("anthropic", resp)
+	}
+
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode Anthropic response: %w", err)
+	}
+	var sb strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("invalid response from Anthropic: no text content")
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnthropicProvider_Complete(t *testing.T) {
+	var gotKey, gotVersion string
+	var gotBody map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		gotKey, gotVersion = r.Header.Get("x-api-key"), r.Header.Get("anthropic-version")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"type":"message","content":[{"type":"text","text":"claude "},{"type":"text","text":"summary"}]}`))
+	}))
+	defer srv.Close()
+	t.Setenv("ANTHROPIC_BASE_URL", srv.URL)
+	t.Setenv("ANTHROPIC_API_KEY", "ak-test")
+	t.Setenv("ANTHROPIC_MAX_TOKENS", "256")
+
+	reply, err := anthropicProvider{}.Complete(context.Background(), "claude-3-5-sonnet-latest", "prompt")
+	if err != nil || reply != "claude summary" {
+		t.Fatalf("Expected the text blocks joined, got %q, %v", reply, err)
+	}
+	if gotKey != "ak-test" || gotVersion != anthropicVersion {
+		t.Errorf("Unexpected auth headers %q, %q", gotKey, gotVersion)
+	}
+	if gotBody["model"] != "claude-3-5-sonnet-latest" || gotBody["max_tokens"] != float64(256) {
+		t.Errorf("Unexpected request body %v", gotBody)
+	}
+}
+
+func TestAnthropicProvider_OverloadedFailsOver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(529)
+		w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("ANTHROPIC_BASE_URL", srv.URL)
+
+	_, err := anthropicProvider{}.Complete(context.Background(), "", "prompt")
+	if !isFailoverError(context.Background(), err) {
+		t.Errorf("Expected 529 overloaded to be a failover error, got %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// auditLog records security-relevant actions for compliance review. It is
+// nil unless AUDIT_BACKEND is set.
+var auditLog auditStore
+
+var auditWriteErrorsTotal = newCounter(
+	"gateway_audit_write_errors_total",
+	"Actions that could not be written to the audit log.",
+)
+
+// Audit actions.
+const (
+	auditAdminRequest             = "admin_request"
+	auditAdminAuthFailed          = "admin_auth_failed"
+	auditCachePurge               = "cache_purge"
+	auditCacheDelete              = "cache_delete"
+	auditSecretsRotated           = "secrets_rotated"
+	auditIPACLUpdated             = "ip_acl_updated"
+	auditPromoCodeUpdated         = "promo_code_updated"
+	auditPromoCodeDeleted         = "promo_code_deleted"
+	auditTenantUpdated            = "tenant_updated"
+	auditTenantDeleted            = "tenant_deleted"
+	auditRateLimitOverrideUpdated = "rate_limit_override_updated"
+	auditRateLimitOverrideDeleted = "rate_limit_override_deleted"
+	auditRefundIssued             = "refund_issued"
+	auditChannelClosed            = "channel_closed"
+	auditGatewayStarted           = "gateway_started"
+	auditPrivacyErasure           = "privacy_erasure"
+)
+
+// AuditEntry is one action in the audit log. Each entry's Hash covers its
+// own fields and the previous entry's hash, so editing or removing an entry
+// breaks every hash after it.
+type AuditEntry struct {
+	Seq       int64             `json:"seq" doc:"Position in the log, from 1"`
+	Time      time.Time         `json:"time"`
+	Action    string            `json:"action" example:"cache_purge"`
+	Actor     string            `json:"actor" doc:"admin for admin API calls and erasures with the admin token, wallet for a wallet erasing its own data, system for the gateway itself" example:"admin"`
+	RemoteIP  string            `json:"remote_ip,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Status    int               `json:"status,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash" doc:"Hash of the previous entry; empty for the first"`
+	Hash      string            `json:"hash" doc:"Hex SHA-256 of this entry's JSON with hash empty"`
+}
+
+// computeHash returns the hash e should carry.
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chain links e after the entry with sequence seq and hash prev.
+func (e *AuditEntry) chain(seq int64, prev string) {
+	e.Seq, e.PrevHash = seq+1, prev
+	e.Hash = e.computeHash()
+}
+
+// auditStore is an append-only, hash-chained store of audit entries.
+type auditStore interface {
+	// Append chains e onto the log and stores it.
+	Append(ctx context.Context, e AuditEntry) error
+	// Entries returns the whole log, oldest first.
+	Entries(ctx context.Context) ([]AuditEntry, error)
+}
+
+// initAuditLog opens the log selected by AUDIT_BACKEND: "file" appends JSON
+// lines to AUDIT_FILE, "redis" adds to the AUDIT_REDIS_KEY stream (default
+// "audit:log"), shared by every instance. LoadConfig has already validated
+// the settings, so a failure here is only logged.
+func initAuditLog() auditStore {
+	switch strings.ToLower(os.Getenv("AUDIT_BACKEND")) {
+	case "file":
+		a, err := newFileAuditLog(os.Getenv("AUDIT_FILE"))
+		if err != nil {
+			log.Printf("Warning: Audit log disabled: %v", err)
+			return nil
+		}
+		log.Printf("Audit log writing to %s", a.path)
+		return a
+	case "redis":
+		if redisClient == nil {
+			log.Println("Warning: Audit log disabled: Redis is unavailable")
+			return nil
+		}
+		key := os.Getenv("AUDIT_REDIS_KEY")
+		if key == "" {
+			key = "audit:log"
+		}
+		log.Printf("Audit log writing to Redis stream %s", key)
+		return &redisAuditLog{client: redisClient, key: key}
+	default:
+		return nil
+	}
+}
+
+// recordAudit appends e to the audit log, if enabled, stamping its time.
+// A write failure is logged and counted; the action itself has already
+// happened.
+func recordAudit(ctx context.Context, e AuditEntry) {
+	if auditLog == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if err := auditLog.Append(context.WithoutCancel(ctx), e); err != nil {
+		auditWriteErrorsTotal.Inc()
+		log.Printf("error writing audit entry for %s: %v", e.Action, err)
+	}
+}
+
+// auditContextKey holds the action and details an admin handler attaches
+// to its audit entry.
+const auditContextKey = "audit"
+
+// auditAdminAction names the audit action for the current admin request,
+// replacing the generic admin_request, and adds details to its entry.
+func auditAdminAction(c *gin.Context, action string, details map[string]string) {
+	c.Set(auditContextKey, AuditEntry{Action: action, Details: details})
+}
+
+// recordAdminAudit records the admin request c once it has been handled.
+func recordAdminAudit(c *gin.Context, action string) {
+	e := AuditEntry{Action: action}
+	if v, ok := c.Get(auditContextKey); ok {
+		e = v.(AuditEntry)
+	}
+	e.Actor = "admin"
+	e.RemoteIP = c.ClientIP()
+	e.RequestID = c.GetString(requestIDKey)
+	e.Method = c.Request.Method
+	e.Path = c.Request.URL.RequestURI()
+	e.Status = c.Writer.Status()
+	recordAudit(c.Request.Context(), e)
+}
+
+// fileAuditLog appends one JSON object per line to a file opened for
+// appending only; protect it further with chattr +a or WORM storage.
+type fileAuditLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	seq  int64
+	head string
+}
+
+// newFileAuditLog opens path, resuming the chain from its last entry.
+func newFileAuditLog(path string) (*fileAuditLog, error) {
+	if path == "" {
+		return nil, fmt.Errorf("AUDIT_FILE is not set")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit file: %w", err)
+	}
+	a := &fileAuditLog{path: path, f: f}
+	entries, err := a.Entries(context.Background())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading audit file: %w", err)
+	}
+	if n := len(entries); n > 0 {
+		a.seq, a.head = entries[n-1].Seq, entries[n-1].Hash
+	}
+	return a, nil
+}
+
+func (a *fileAuditLog) Append(_ context.Context, e AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e.chain(a.seq, a.head)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.seq, a.head = e.Seq, e.Hash
+	return nil
+}
+
+// Entries returns every line; a line that doesn't parse is returned as an
+// empty entry so verification reports it rather than skipping it.
+func (a *fileAuditLog) Entries(_ context.Context) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		json.Unmarshal(scanner.Bytes(), &e)
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// redisAuditLog keeps entries in a stream, with the sequence and hash of
+// the last entry in <key>:head so instances extend one chain.
+type redisAuditLog struct {
+	client *redis.Client
+	key    string
+}
+
+func (a *redisAuditLog) Append(ctx context.Context, e AuditEntry) error {
+	headKey := a.key + ":head"
+	for attempt := 0; attempt < 10; attempt++ {
+		err := a.client.Watch(ctx, func(tx *redis.Tx) error {
+			var seq int64
+			var prev string
+			head, err := tx.Get(ctx, headKey).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			if rawSeq, hash, ok := strings.Cut(head, " "); ok {
+				seq, _ = strconv.ParseInt(rawSeq, 10, 64)
+				prev = hash
+			}
+			e.chain(seq, prev)
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.XAdd(ctx, &redis.XAddArgs{Stream: a.key, Values: map[string]interface{}{"entry": data}})
+				pipe.Set(ctx, headKey, fmt.Sprintf("%d %s", e.Seq, e.Hash), 0)
+				return nil
+			})
+			return err
+		}, headKey)
+		// Another instance appended first; chain onto its entry instead
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("audit log busy: too many concurrent appends")
+}
+
+func (a *redisAuditLog) Entries(ctx context.Context) ([]AuditEntry, error) {
+	msgs, err := a.client.XRange(ctx, a.key, "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		var e AuditEntry
+		if raw, ok := msg.Values["entry"].(string); ok {
+			json.Unmarshal([]byte(raw), &e)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// verifyAuditChain checks every entry's hash and link, returning the
+// sequence of the first entry that fails and why.
+func verifyAuditChain(entries []AuditEntry) (brokenAt int64, reason string) {
+	var prev string
+	for i, e := range entries {
+		want := int64(i + 1)
+		switch {
+		case e.Hash == "":
+			return want, "entry is unreadable"
+		case e.Seq != want:
+			return want, fmt.Sprintf("sequence is %d, entries are missing or out of order", e.Seq)
+		case e.PrevHash != prev:
+			return want, "prev_hash does not match the previous entry"
+		case e.Hash != e.computeHash():
+			return want, "hash does not match the entry; it was modified"
+		}
+		prev = e.Hash
+	}
+	return 0, ""
+}
+
+// AuditLogResponse is the body of GET /admin/audit.
+type AuditLogResponse struct {
+	Entries []AuditEntry `json:"entries" doc:"Matching entries, oldest first"`
+	Total   int          `json:"total" doc:"Entries matching before the limit was applied"`
+}
+
+// AuditVerifyResponse is the body of GET /admin/audit/verify.
+type AuditVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	HeadHash string `json:"head_hash,omitempty" doc:"Hash of the last entry; record it elsewhere to detect truncation"`
+	BrokenAt int64  `json:"broken_at,omitempty" doc:"Sequence of the first entry that failed verification"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// abortAuditDisabled answers 503 audit_disabled.
+func abortAuditDisabled(c *gin.Context) {
+	abortWithProblem(c, newProblem(503, codeAuditDisabled, "Audit Log Disabled", "The audit log is not enabled (AUDIT_BACKEND)"))
+}
+
+// handleAuditLog handles GET /admin/audit, filtering by action, actor and
+// time range and returning the most recent limit entries.
+func handleAuditLog(c *gin.Context) {
+	if auditLog == nil {
+		abortAuditDisabled(c)
+		return
+	}
+	from, err := parseExportTime(c.Query("from"))
+	if err != nil {
+		abortInvalidQuery(c, "from", err.Error())
+		return
+	}
+	to, err := parseExportTime(c.Query("to"))
+	if err != nil {
+		abortInvalidQuery(c, "to", err.Error())
+		return
+	}
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > 1000 {
+			abortInvalidQuery(c, "limit", "limit must be an integer from 1 to 1000")
+			return
+		}
+	}
+
+	entries, err := auditLog.Entries(c.Request.Context())
+	if err != nil {
+		log.Printf("error reading audit log: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read audit log", ""))
+		return
+	}
+	action, actor := c.Query("action"), c.Query("actor")
+	matched := []AuditEntry{}
+	for _, e := range entries {
+		if (action != "" && e.Action != action) || (actor != "" && e.Actor != actor) ||
+			(!from.IsZero() && e.Time.Before(from)) || (!to.IsZero() && !e.Time.Before(to)) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	resp := AuditLogResponse{Entries: matched, Total: len(matched)}
+	if len(matched) > limit {
+		resp.Entries = matched[len(matched)-limit:]
+	}
+	c.JSON(200, resp)
+}
+
+// handleAuditVerify handles GET /admin/audit/verify, recomputing the hash
+// chain over the whole log.
+func handleAuditVerify(c *gin.Context) {
+	if auditLog == nil {
+		abortAuditDisabled(c)
+		return
+	}
+	entries, err := auditLog.Entries(c.Request.Context())
+	if err != nil {
+		log.Printf("error reading audit log: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read audit log", ""))
+		return
+	}
+	resp := AuditVerifyResponse{Entries: len(entries)}
+	resp.BrokenAt, resp.Reason = verifyAuditChain(entries)
+	resp.Valid = resp.BrokenAt == 0
+	if n := len(entries); n > 0 {
+		resp.HeadHash = entries[n-1].Hash
+	}
+	c.JSON(200, resp)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupTestAuditLog installs a file audit log in a temp dir.
+func setupTestAuditLog(t *testing.T) *fileAuditLog {
+	t.Helper()
+	a, err := newFileAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := auditLog
+	auditLog = a
+	t.Cleanup(func() {
+		a.f.Close()
+		auditLog = prev
+	})
+	return a
+}
+
+// testAuditStores returns a file and a Redis audit log.
+func testAuditStores(t *testing.T) map[string]auditStore {
+	setupTestRedis(t)
+	file, err := newFileAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.f.Close() })
+	return map[string]auditStore{"file": file, "redis": &redisAuditLog{client: redisClient, key: "audit:test"}}
+}
+
+func TestAuditStores_Chain(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testAuditStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, action := range []string{auditGatewayStarted, auditCachePurge, auditAdminRequest} {
+				if err := store.Append(ctx, AuditEntry{Action: action, Actor: "system"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			entries, err := store.Entries(ctx)
+			if err != nil || len(entries) != 3 {
+				t.Fatalf("Expected 3 entries, got %d: %v", len(entries), err)
+			}
+			if entries[0].PrevHash != "" || entries[2].Seq != 3 || entries[2].PrevHash != entries[1].Hash {
+				t.Errorf("Entries aren't chained: %+v", entries)
+			}
+			if brokenAt, reason := verifyAuditChain(entries); brokenAt != 0 {
+				t.Errorf("Expected a valid chain, broken at %d: %s", brokenAt, reason)
+			}
+		})
+	}
+}
+
+func TestFileAuditLog_ResumesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, _ := newFileAuditLog(path)
+	a.Append(context.Background(), AuditEntry{Action: auditGatewayStarted})
+	a.f.Close()
+
+	b, err := newFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.f.Close()
+	b.Append(context.Background(), AuditEntry{Action: auditGatewayStarted})
+	entries, _ := b.Entries(context.Background())
+	if brokenAt, reason := verifyAuditChain(entries); len(entries) != 2 || brokenAt != 0 {
+		t.Errorf("Expected the reopened log to extend the chain, broken at %d: %s", brokenAt, reason)
+	}
+}
+
+func TestVerifyAuditChain_DetectsTampering(t *testing.T) {
+	var entries []AuditEntry
+	for i := 0; i < 4; i++ {
+		e := AuditEntry{Action: auditAdminRequest, Path: "/admin/stats"}
+		var prev string
+		if i > 0 {
+			prev = entries[i-1].Hash
+		}
+		e.chain(int64(i), prev)
+		entries = append(entries, e)
+	}
+
+	edited := append([]AuditEntry(nil), entries...)
+	edited[1].Path = "/admin/cache"
+	if brokenAt, _ := verifyAuditChain(edited); brokenAt != 2 {
+		t.Errorf("Expected an edited entry to be reported at 2, got %d", brokenAt)
+	}
+	removed := append(append([]AuditEntry(nil), entries[:2]...), entries[3:]...)
+	if brokenAt, _ := verifyAuditChain(removed); brokenAt != 3 {
+		t.Errorf("Expected a removed entry to be reported at 3, got %d", brokenAt)
+	}
+	rehashed := append([]AuditEntry(nil), entries...)
+	rehashed[1].Path = "/admin/cache"
+	rehashed[1].Hash = rehashed[1].computeHash()
+	if brokenAt, reason := verifyAuditChain(rehashed); brokenAt != 3 || !strings.Contains(reason, "prev_hash") {
+		t.Errorf("Expected a rehashed entry to break the next link, got %d %q", brokenAt, reason)
+	}
+}
+
+func TestAdminRequests_AreAudited(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	setupTestMemoryCache(t, 10, 0)
+	a := setupTestAuditLog(t)
+	r := setupAdminRouter()
+
+	adminRequest(r, "GET", "/admin/cache/stats", "secret")
+	adminRequest(r, "DELETE", "/admin/cache", "secret")
+	adminRequest(r, "DELETE", "/admin/cache", "wrong")
+
+	entries, _ := a.Entries(context.Background())
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 audit entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Action != auditAdminRequest || e.Method != "GET" || e.Path != "/admin/cache/stats" || e.Status != 200 || e.Actor != "admin" {
+		t.Errorf("Unexpected entry for the stats call %+v", e)
+	}
+	if e := entries[1]; e.Action != auditCachePurge || e.Details["memory_deleted"] != "0" {
+		t.Errorf("Unexpected entry for the purge %+v", e)
+	}
+	if e := entries[2]; e.Action != auditAdminAuthFailed || e.Status != 401 {
+		t.Errorf("Unexpected entry for the wrong token %+v", e)
+	}
+}
+
+func TestHandleAuditLog(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	if w := adminRequest(r, "GET", "/admin/audit", "secret"); w.Code != 503 || decodeProblem(t, w)["code"] != codeAuditDisabled {
+		t.Fatalf("Expected 503 audit_disabled without a log, got %d", w.Code)
+	}
+
+	setupTestAuditLog(t)
+	recordAudit(context.Background(), AuditEntry{Action: auditGatewayStarted, Actor: "system"})
+	adminRequest(r, "GET", "/admin/stats", "secret")
+
+	w := adminRequest(r, "GET", "/admin/audit?actor=system", "secret")
+	var resp AuditLogResponse
+	if w.Code != 200 || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Total != 1 || resp.Entries[0].Action != auditGatewayStarted {
+		t.Errorf("Expected the startup entry only, got %d %s", w.Code, w.Body.String())
+	}
+	w = adminRequest(r, "GET", "/admin/audit?limit=1", "secret")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Total != 3 || len(resp.Entries) != 1 || resp.Entries[0].Path != "/admin/audit?actor=system" {
+		t.Errorf("Expected the latest entry of 3, got %s", w.Body.String())
+	}
+	if w := adminRequest(r, "GET", "/admin/audit?limit=0", "secret"); w.Code != 400 {
+		t.Errorf("Expected 400 for limit=0, got %d", w.Code)
+	}
+}
+
+func TestHandleAuditVerify(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	a := setupTestAuditLog(t)
+	recordAudit(context.Background(), AuditEntry{Action: auditGatewayStarted, Actor: "system", Details: map[string]string{"port": "3000"}})
+	recordAudit(context.Background(), AuditEntry{Action: auditSecretsRotated, Actor: "system"})
+
+	var resp AuditVerifyResponse
+	w := adminRequest(r, "GET", "/admin/audit/verify", "secret")
+	if json.Unmarshal(w.Body.Bytes(), &resp) != nil || !resp.Valid || resp.Entries != 2 || resp.HeadHash == "" {
+		t.Fatalf("Expected a valid log, got %s", w.Body.String())
+	}
+
+	data, _ := os.ReadFile(a.path)
+	os.WriteFile(a.path, []byte(strings.Replace(string(data), `"3000"`, `"4000"`, 1)), 0o600)
+	w = adminRequest(r, "GET", "/admin/audit/verify", "secret")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Valid || resp.BrokenAt != 1 {
+		t.Errorf("Expected the edit to be detected at entry 1, got %s", w.Body.String())
+	}
+}
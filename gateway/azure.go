@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// azureOpenAIProvider calls an Azure OpenAI resource, for tenants that may
+// only send data to their own Azure endpoint. Azure addresses models by
+// deployment name, so the model it is given is a deployment: MODEL_ROUTES
+// maps gateway models to deployments ("openai/gpt-4o=azure:gpt4o-prod"),
+// and AZURE_OPENAI_DEPLOYMENT is used when azure is reached through the
+// chain.
+type azureOpenAIProvider struct{}
+
+// getAzureOpenAIAPIVersion returns AZURE_OPENAI_API_VERSION (default
+// 2024-10-21, the current GA version).
+func getAzureOpenAIAPIVersion() string {
+	if v := os.Getenv("AZURE_OPENAI_API_VERSION"); v != "" {
+		return v
+	}
+	return "2024-10-21"
+}
+
+// azureChatURL returns the chat completions URL for deployment on the
+// AZURE_OPENAI_ENDPOINT resource.
+func azureChatURL(deployment string) string {
+	endpoint := strings.TrimRight(os.Getenv("AZURE_OPENAI_ENDPOINT"), "/")
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		endpoint, url.PathEscape(deployment), url.QueryEscape(getAzureOpenAIAPIVersion()))
+}
+
+// Complete runs prompt on the deployment named by model, or
+// AZURE_OPENAI_DEPLOYMENT when model is empty, authenticating with
+// AZURE_OPENAI_API_KEY or the tenant's own key.
+func (azureOpenAIProvider) Complete(ctx context.Context, model, prompt string) (string, error) {
+	deployment := model
+	if deployment == "" {
+		deployment = os.Getenv("AZURE_OPENAI_DEPLOYMENT")
+	}
+	if deployment == "" {
+		return "", errors.New("no Azure OpenAI deployment: set AZURE_OPENAI_DEPLOYMENT or route the model in MODEL_ROUTES")
+	}
+
+	body := map[string]interface{}{
+		"messages": []map[string]string{{"role": "user", "content": prompt}},
+	}
+	generationParamsFrom(ctx).applyTo(body)
+	reqBody, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", azureChatURL(deployment), bytes.NewReader(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Azure OpenAI request: %w", err)
+	}
+	req.Header.Set("api-key", providerAPIKey(ctx, "azure"))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", context.DeadlineExceeded
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", newProviderStatusError("azure", resp)
+	}
+
+	var result chatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode Azure OpenAI response: %w", err)
+	}
+	return result.content(ctx, "Azure OpenAI")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureOpenAIProvider_Complete(t *testing.T) {
+	var gotPath, gotVersion, gotKey string
+	var gotBody map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotVersion, gotKey = r.URL.Path, r.URL.Query().Get("api-version"), r.Header.Get("api-key")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"azure summary"}}]}`))
+	}))
+	defer srv.Close()
+	t.Setenv("AZURE_OPENAI_ENDPOINT", srv.URL+"/")
+	t.Setenv("AZURE_OPENAI_API_KEY", "az-key")
+	t.Setenv("AZURE_OPENAI_API_VERSION", "")
+	t.Setenv("AZURE_OPENAI_DEPLOYMENT", "default-deploy")
+
+	reply, err := azureOpenAIProvider{}.Complete(context.Background(), "gpt4o-prod", "prompt")
+	if err != nil || reply != "azure summary" {
+		t.Fatalf("Expected azure summary, got %q, %v", reply, err)
+	}
+	if gotPath != "/openai/deployments/gpt4o-prod/chat/completions" || gotVersion != "2024-10-21" {
+		t.Errorf("Unexpected request %s?api-version=%s", gotPath, gotVersion)
+	}
+	if gotKey != "az-key" {
+		t.Errorf("Expected api-key header, got %q", gotKey)
+	}
+	if _, ok := gotBody["model"]; ok {
+		t.Errorf("Expected no model in the body, the deployment selects it: %v", gotBody)
+	}
+
+	if _, err := (azureOpenAIProvider{}).Complete(context.Background(), "", "prompt"); err != nil {
+		t.Fatalf("Complete with the default deployment failed: %v", err)
+	}
+	if gotPath != "/openai/deployments/default-deploy/chat/completions" {
+		t.Errorf("Expected AZURE_OPENAI_DEPLOYMENT to be used, got %s", gotPath)
+	}
+}
+
+func TestAzureOpenAIProvider_NoDeployment(t *testing.T) {
+	t.Setenv("AZURE_OPENAI_DEPLOYMENT", "")
+	if _, err := (azureOpenAIProvider{}).Complete(context.Background(), "", "prompt"); err == nil {
+		t.Error("Expected an error without a deployment")
+	}
+}
+
+func TestLoadConfig_AzureSettings(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test")
+	t.Setenv("AI_PROVIDER_CHAIN", "azure")
+	t.Setenv("MODEL_ROUTES", "")
+	t.Setenv("AZURE_OPENAI_API_KEY", "")
+	t.Setenv("AZURE_OPENAI_ENDPOINT", "http://contoso.openai.azure.com")
+	t.Setenv("AZURE_OPENAI_DEPLOYMENT", "")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("Expected LoadConfig to fail")
+	}
+	for _, want := range []string{"AZURE_OPENAI_API_KEY is required", "AZURE_OPENAI_ENDPOINT", "AZURE_OPENAI_DEPLOYMENT is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// aiBreakers stop calling AI providers that keep failing. It is nil unless
+// CIRCUIT_BREAKER=true.
+var aiBreakers *breakerSet
+
+var circuitBreakerTripsTotal = newCounter(
+	"gateway_circuit_breaker_trips_total",
+	"Times a provider's circuit breaker opened.",
+	"provider",
+)
+
+var brownoutRequestsTotal = newCounter(
+	"gateway_brownout_requests_total",
+	"Summarize requests seen while every provider for the model was tripped, by outcome (rejected, cached).",
+	"outcome",
+)
+
+// errCircuitOpen is returned by callAIPrompt when every provider for the
+// model is tripped.
+var errCircuitOpen = errors.New("AI providers temporarily unavailable (circuit open)")
+
+// breakerConfig holds the CIRCUIT_BREAKER_* settings.
+type breakerConfig struct {
+	window      int           // outcomes kept per provider
+	minRequests int           // outcomes needed before the error rate counts
+	errorRate   float64       // failure share that trips the breaker
+	slowCall    time.Duration // calls slower than this count as failures; 0 disables
+	openFor     time.Duration // wait before probing a tripped provider
+}
+
+// breakerSet holds one breaker per provider.
+type breakerSet struct {
+	cfg   breakerConfig
+	probe func(ctx context.Context, name string) error
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// breaker tracks one provider's recent outcomes. While open, callers skip
+// the provider and a background probe checks for recovery every openFor.
+type breaker struct {
+	outcomes []bool // true = failure, a ring of the last cfg.window calls
+	next     int
+	filled   int
+	failures int
+
+	open      bool
+	openUntil time.Time // when the next probe runs
+}
+
+// initCircuitBreakers builds the breakers from CIRCUIT_BREAKER (default
+// off), CIRCUIT_BREAKER_WINDOW (default 20), CIRCUIT_BREAKER_MIN_REQUESTS
+// (default 10), CIRCUIT_BREAKER_ERROR_RATE (default 0.5),
+// CIRCUIT_BREAKER_SLOW_CALL_MS (default 0, off) and
+// CIRCUIT_BREAKER_OPEN_SECONDS (default 30). LoadConfig has already
+// validated the settings.
+func initCircuitBreakers() *breakerSet {
+	if strings.ToLower(os.Getenv("CIRCUIT_BREAKER")) != "true" {
+		return nil
+	}
+	cfg := breakerConfig{
+		window:      getEnvAsInt("CIRCUIT_BREAKER_WINDOW", 20),
+		minRequests: getEnvAsInt("CIRCUIT_BREAKER_MIN_REQUESTS", 10),
+		errorRate:   getBreakerErrorRate(),
+		slowCall:    time.Duration(getEnvAsInt("CIRCUIT_BREAKER_SLOW_CALL_MS", 0)) * time.Millisecond,
+		openFor:     time.Duration(getEnvAsInt("CIRCUIT_BREAKER_OPEN_SECONDS", 30)) * time.Second,
+	}
+	log.Printf("AI circuit breaker enabled (trips at %.0f%% of the last %d calls, probes every %s, brownout %s)",
+		cfg.errorRate*100, cfg.window, cfg.openFor, getBrownoutMode())
+	return newBreakerSet(cfg, probeProvider)
+}
+
+func newBreakerSet(cfg breakerConfig, probe func(context.Context, string) error) *breakerSet {
+	cfg.window = max(cfg.window, 1)
+	cfg.minRequests = min(max(cfg.minRequests, 1), cfg.window)
+	return &breakerSet{cfg: cfg, probe: probe, breakers: make(map[string]*breaker)}
+}
+
+// getBreakerErrorRate returns CIRCUIT_BREAKER_ERROR_RATE, a share in (0, 1].
+func getBreakerErrorRate() float64 {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("CIRCUIT_BREAKER_ERROR_RATE")), 64); err == nil && v > 0 && v <= 1 {
+		return v
+	}
+	return 0.5
+}
+
+// getBrownoutMode returns BROWNOUT_MODE: "off" (default) rejects every
+// request while the providers are tripped, "cached-only" still serves
+// requests whose summary is cached.
+func getBrownoutMode() string {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("BROWNOUT_MODE"))) == "cached-only" {
+		return "cached-only"
+	}
+	return "off"
+}
+
+// allow reports whether name may be called, i.e. its breaker is closed.
+func (s *breakerSet) allow(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.breakers[name]
+	return !ok || !b.open
+}
+
+// record adds the outcome of a call to name and trips its breaker when the
+// failure share over the window reaches the threshold. Only provider faults
+// count: errors the caller caused (4xx, its own cancellation) are ignored.
+func (s *breakerSet) record(ctx context.Context, name string, err error, elapsed time.Duration) {
+	failed := err != nil && isFailoverError(ctx, err)
+	if err != nil && !failed {
+		return
+	}
+	if s.cfg.slowCall > 0 && elapsed > s.cfg.slowCall {
+		failed = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.breakers[name]
+	if !ok {
+		b = &breaker{outcomes: make([]bool, s.cfg.window)}
+		s.breakers[name] = b
+	}
+	if b.open {
+		return
+	}
+	if b.filled == len(b.outcomes) && b.outcomes[b.next] {
+		b.failures--
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+	b.filled = min(b.filled+1, len(b.outcomes))
+
+	if b.filled >= s.cfg.minRequests && float64(b.failures)/float64(b.filled) >= s.cfg.errorRate {
+		b.open = true
+		b.openUntil = time.Now().Add(s.cfg.openFor)
+		circuitBreakerTripsTotal.Inc(name)
+		log.Printf("Circuit breaker for AI provider %s opened (%d of the last %d calls failed)", name, b.failures, b.filled)
+		notifyWebhooks(ctx, webhookCircuitOpened, map[string]interface{}{
+			"provider": name, "failures": b.failures, "calls": b.filled, "open_for_seconds": int(s.cfg.openFor.Seconds()),
+		})
+		go s.probeUntilClosed(name)
+	}
+}
+
+// probeUntilClosed probes a tripped provider every openFor until it
+// answers, then closes its breaker with a clean window.
+func (s *breakerSet) probeUntilClosed(name string) {
+	for {
+		time.Sleep(s.cfg.openFor)
+		ctx, cancel := context.WithTimeout(context.Background(), getAITimeout())
+		err := s.probe(ctx, name)
+		cancel()
+
+		s.mu.Lock()
+		b := s.breakers[name]
+		if err == nil {
+			*b = breaker{outcomes: make([]bool, s.cfg.window)}
+			s.mu.Unlock()
+			log.Printf("Circuit breaker for AI provider %s closed, probe succeeded", name)
+			notifyWebhooks(context.Background(), webhookCircuitClosed, map[string]interface{}{"provider": name})
+			return
+		}
+		b.openUntil = time.Now().Add(s.cfg.openFor)
+		s.mu.Unlock()
+		log.Printf("AI provider %s still failing (%v), breaker stays open", name, err)
+	}
+}
+
+// available reports whether any provider for model is closed and, if none
+// is, how long until the first probe.
+func (s *breakerSet) available(model string) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var retryAfter time.Duration
+	for i, attempt := range providerAttempts(model) {
+		b, ok := s.breakers[attempt.provider]
+		if !ok || !b.open {
+			return true, 0
+		}
+		if wait := time.Until(b.openUntil); i == 0 || wait < retryAfter {
+			retryAfter = wait
+		}
+	}
+	return false, max(retryAfter, time.Second)
+}
+
+// filter drops the attempts whose provider is tripped.
+func (s *breakerSet) filter(attempts []providerAttempt) []providerAttempt {
+	var open []providerAttempt
+	for _, attempt := range attempts {
+		if s.allow(attempt.provider) {
+			open = append(open, attempt)
+		} else {
+			aiProviderRequestsTotal.Inc(attempt.provider, "circuit_open")
+		}
+	}
+	return open
+}
+
+// probeProvider sends a one-word prompt to name's default model.
+func probeProvider(ctx context.Context, name string) error {
+	_, err := aiProviders[name].Complete(ctx, "", "Reply with OK.")
+	return err
+}
+
+// abortAIUnavailable answers 503 ai_unavailable with Retry-After set to the
+// next probe. The handler checks the breakers before payment verification,
+// so in that case the nonce is not consumed and the client can retry with
+// the same signature.
+func abortAIUnavailable(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	abortWithProblem(c, newProblem(503, codeAIUnavailable, "AI Temporarily Unavailable",
+		"The AI providers are failing, please retry later").
+		With("retry_after", seconds))
+}
+
+// servableInBrownout reports whether BROWNOUT_MODE=cached-only lets the
+// request through because its summary is already cached. Cache bypass
+// requests need the AI, so they are not.
+func servableInBrownout(c *gin.Context, model, text string, opts summaryOptions) bool {
+	if getBrownoutMode() != "cached-only" || isCacheBypass(c) {
+		return false
+	}
+	_, _, ok := lookupCache(c.Request.Context(), getCacheKey(c.Request.Context(), model, text, opts.cacheParams()))
+	return ok
+}
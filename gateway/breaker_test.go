@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+var errUpstream = &providerStatusError{Provider: "openrouter", StatusCode: 503}
+
+func TestBreakerSet_TripsAndRecovers(t *testing.T) {
+	var healthy atomic.Bool
+	s := newBreakerSet(breakerConfig{window: 4, minRequests: 4, errorRate: 0.5, openFor: 20 * time.Millisecond},
+		func(context.Context, string) error {
+			if healthy.Load() {
+				return nil
+			}
+			return errUpstream
+		})
+	ctx := context.Background()
+
+	s.record(ctx, "openrouter", nil, 0)
+	s.record(ctx, "openrouter", errUpstream, 0)
+	s.record(ctx, "openrouter", &providerStatusError{StatusCode: 400}, 0) // the caller's fault, not counted
+	s.record(ctx, "openrouter", nil, 0)
+	if !s.allow("openrouter") {
+		t.Fatal("Expected the breaker to stay closed below the minimum request count")
+	}
+	s.record(ctx, "openrouter", errUpstream, 0)
+	if s.allow("openrouter") {
+		t.Fatal("Expected 2 failures out of 4 to trip the breaker")
+	}
+	if ok, retryAfter := s.available(""); ok || retryAfter < time.Second {
+		t.Errorf("Expected no provider available for at least 1s, got %v %s", ok, retryAfter)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if s.allow("openrouter") {
+		t.Fatal("Expected the breaker to stay open while the probe fails")
+	}
+	healthy.Store(true)
+	deadline := time.Now().Add(time.Second)
+	for !s.allow("openrouter") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !s.allow("openrouter") {
+		t.Error("Expected a successful probe to close the breaker")
+	}
+}
+
+func TestBreakerSet_SlowCallsCount(t *testing.T) {
+	s := newBreakerSet(breakerConfig{window: 2, minRequests: 2, errorRate: 1, slowCall: 100 * time.Millisecond, openFor: time.Minute},
+		func(context.Context, string) error { return errUpstream })
+	s.record(context.Background(), "ollama", nil, 200*time.Millisecond)
+	s.record(context.Background(), "ollama", nil, 300*time.Millisecond)
+	if s.allow("ollama") {
+		t.Error("Expected slow calls to trip the breaker")
+	}
+}
+
+// setupTrippedBreakers installs breakers with provider already open.
+func setupTrippedBreakers(t *testing.T, provider string) {
+	t.Helper()
+	prev := aiBreakers
+	aiBreakers = newBreakerSet(breakerConfig{window: 1, minRequests: 1, errorRate: 1, openFor: time.Minute},
+		func(context.Context, string) error { return errUpstream })
+	t.Cleanup(func() { aiBreakers = prev })
+	aiBreakers.record(context.Background(), provider, errUpstream, 0)
+}
+
+func TestCallAIPrompt_SkipsTrippedProviders(t *testing.T) {
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter,mock")
+	setupTrippedBreakers(t, "openrouter")
+
+	ctx, rec := withProviderRecorder(context.Background())
+	if _, err := callAIPrompt(ctx, "", "prompt"); err != nil || rec.Name() != "mock" {
+		t.Errorf("Expected mock to answer for the tripped openrouter, got %q, %v", rec.Name(), err)
+	}
+
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	if _, err := callAIPrompt(context.Background(), "", "prompt"); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("Expected errCircuitOpen, got %v", err)
+	}
+}
+
+func TestHandleSummarize_CircuitOpen(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	setupTrippedBreakers(t, "openrouter")
+	r := setupVersionedRouter()
+
+	send := func(text, nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"`+text+`"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("uncached text", "n-breaker-1")
+	if w.Code != 503 || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected 503 with Retry-After, got %d: %s", w.Code, w.Body.String())
+	}
+	if p := decodeProblem(t, w); p["code"] != codeAIUnavailable {
+		t.Errorf("Expected %s, got %v", codeAIUnavailable, p)
+	}
+	if verifier.Calls() != 0 || ai.Calls() != 0 {
+		t.Errorf("Expected neither the verifier nor the provider to be called, got %d and %d", verifier.Calls(), ai.Calls())
+	}
+
+	// Cached-only brownout serves what is already cached
+	t.Setenv("BROWNOUT_MODE", "cached-only")
+	key := getCacheKey(context.Background(), getDefaultModel(), "cached text", nil)
+	if err := setCachedResponse(context.Background(), key, "cached text", "cached summary"); err != nil {
+		t.Fatal(err)
+	}
+	if w := send("cached text", "n-breaker-2"); w.Code != 200 || !strings.Contains(w.Body.String(), "cached summary") {
+		t.Errorf("Expected the cached summary during brownout, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("uncached text", "n-breaker-3"); w.Code != 503 {
+		t.Errorf("Expected uncached text to still be refused, got %d", w.Code)
+	}
+}
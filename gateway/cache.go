@@ -0,0 +1,662 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/text/unicode/norm"
+)
+
+// cacheKeyPrefix namespaces AI response entries in Redis. Purges scan on it,
+// so they also remove entries written under older key versions.
+const cacheKeyPrefix = "ai:summary:"
+
+// cacheKeyVersion is bumped whenever the key derivation changes, so entries
+// from an older scheme are never read back and simply age out.
+const cacheKeyVersion = "v4"
+
+// currentCacheKeyPrefix returns the prefix of keys written by this build.
+func currentCacheKeyPrefix() string {
+	return cacheKeyPrefix + cacheKeyVersion + ":"
+}
+
+// redisClient backs the shared AI response cache. It is nil when REDIS_URL is
+// unset or Redis was unreachable at startup, in which case only the in-memory
+// L1 cache (see memory_cache.go) is used.
+var redisClient *redis.Client
+
+// aiCallGroup coalesces concurrent AI calls for the same cache key so a burst
+// of identical texts costs a single upstream request.
+var aiCallGroup singleflight.Group
+
+var (
+	cacheRequestsTotal = newCounter(
+		"gateway_cache_requests_total",
+		"AI response cache lookups by result (hit, stale, miss, bypass).",
+		"result",
+	)
+	cacheTierHitsTotal = newCounter(
+		"gateway_cache_tier_hits_total",
+		"AI response cache hits by the tier that served them.",
+		"tier",
+	)
+	cacheNormalizedHitsTotal = newCounter(
+		"gateway_cache_normalized_hits_total",
+		"Cache hits whose raw text differed from the text that populated the entry (hits gained by key normalization).",
+	)
+	aiSharedResultsTotal = newCounter(
+		"gateway_ai_shared_results_total",
+		"Requests whose AI result came from a call shared with concurrent identical requests.",
+	)
+)
+
+// CachedResponse is the value stored in the cache for a summarized text.
+type CachedResponse struct {
+	Result   string    `json:"result"`
+	CachedAt time.Time `json:"cached_at"`
+	// SourceHash is the hash of the raw (unnormalized) text that produced
+	// this entry. It lets us tell when a hit was only possible because of
+	// normalization.
+	SourceHash string `json:"source_hash,omitempty"`
+	// ExpiresAt is when the entry stops being fresh. With
+	// stale-while-revalidate enabled it is kept (and served as stale) for a
+	// while longer; zero means the entry predates this field.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// isStale reports whether the entry is past its freshness deadline.
+func (c *CachedResponse) isStale(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt)
+}
+
+// initRedis connects to REDIS_URL. It returns nil (leaving only the in-memory
+// cache) if the URL is unset, malformed, or the server does not answer a ping.
+func initRedis() *redis.Client {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		log.Println("REDIS_URL not set, using in-memory response cache only")
+		return nil
+	}
+
+	opts, err := redisOptionsFromEnv(redisURL)
+	if err != nil {
+		log.Printf("Warning: Invalid Redis configuration: %v, using in-memory response cache only", err)
+		return nil
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Printf("Warning: Redis unreachable: %v, using in-memory response cache only", err)
+		_ = client.Close()
+		return nil
+	}
+
+	log.Println("Redis connected, response caching enabled")
+	return client
+}
+
+// redisOptionsFromEnv parses redisURL (redis:// or rediss:// for TLS, with
+// optional user:password) and applies overrides for managed providers:
+// REDIS_USERNAME / REDIS_PASSWORD for ACL auth, REDIS_TLS=true to force TLS
+// on a redis:// URL, REDIS_TLS_CA_FILE for a custom CA bundle, and
+// REDIS_TLS_CERT_FILE / REDIS_TLS_KEY_FILE for client certificates.
+func redisOptionsFromEnv(redisURL string) (*redis.Options, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	if username := os.Getenv("REDIS_USERNAME"); username != "" {
+		opts.Username = username
+	}
+	if password := os.Getenv("REDIS_PASSWORD"); password != "" {
+		opts.Password = password
+		// Read per connection so a rotated secret is used for new connections
+		username := opts.Username
+		opts.CredentialsProvider = func() (string, string) {
+			return username, os.Getenv("REDIS_PASSWORD")
+		}
+	}
+
+	caFile := os.Getenv("REDIS_TLS_CA_FILE")
+	certFile := os.Getenv("REDIS_TLS_CERT_FILE")
+	keyFile := os.Getenv("REDIS_TLS_KEY_FILE")
+	forceTLS := strings.ToLower(os.Getenv("REDIS_TLS")) == "true"
+	if opts.TLSConfig == nil && (forceTLS || caFile != "" || certFile != "") {
+		host, _, _ := net.SplitHostPort(opts.Addr)
+		opts.TLSConfig = &tls.Config{ServerName: host}
+	}
+	if opts.TLSConfig == nil {
+		return opts, nil
+	}
+	opts.TLSConfig.MinVersion = tls.VersionTLS12
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA_FILE %s contains no PEM certificates", caFile)
+		}
+		opts.TLSConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading Redis client certificate: %w", err)
+		}
+		opts.TLSConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return opts, nil
+}
+
+// getCacheTTL returns the configured cache TTL or default 1h
+func getCacheTTL() time.Duration {
+	if appConfig != nil {
+		return appConfig.CacheTTL
+	}
+	ttlSeconds := getEnvAsInt("CACHE_TTL_SECONDS", 3600)
+	if ttlSeconds <= 0 {
+		ttlSeconds = 3600
+	}
+	return time.Duration(ttlSeconds) * time.Second
+}
+
+// getCacheStaleTTL returns how long an expired entry may still be served
+// while it is refreshed in the background, from
+// CACHE_STALE_WHILE_REVALIDATE_SECONDS. Zero (the default) disables
+// stale-while-revalidate.
+func getCacheStaleTTL() time.Duration {
+	staleSeconds := getEnvAsInt("CACHE_STALE_WHILE_REVALIDATE_SECONDS", 0)
+	if staleSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(staleSeconds) * time.Second
+}
+
+// cacheNormalization selects which text normalizations are applied before a
+// cache key is hashed.
+type cacheNormalization struct {
+	Whitespace  bool // collapse runs of whitespace and trim the ends
+	Unicode     bool // Unicode NFC normalization
+	Punctuation bool // trim trailing punctuation
+}
+
+// enabled reports whether any normalization is active.
+func (n cacheNormalization) enabled() bool {
+	return n.Whitespace || n.Unicode || n.Punctuation
+}
+
+// getCacheNormalization parses CACHE_KEY_NORMALIZATION, a comma-separated
+// list of "whitespace", "nfc" and "punctuation" (or "all"). Unset means no
+// normalization, so existing keys are unaffected unless an operator opts in.
+func getCacheNormalization() cacheNormalization {
+	var n cacheNormalization
+	for _, opt := range strings.Split(os.Getenv("CACHE_KEY_NORMALIZATION"), ",") {
+		switch strings.ToLower(strings.TrimSpace(opt)) {
+		case "":
+		case "all":
+			n = cacheNormalization{Whitespace: true, Unicode: true, Punctuation: true}
+		case "whitespace":
+			n.Whitespace = true
+		case "nfc", "unicode":
+			n.Unicode = true
+		case "punctuation":
+			n.Punctuation = true
+		default:
+			log.Printf("Warning: Unknown CACHE_KEY_NORMALIZATION option '%s', ignoring", opt)
+		}
+	}
+	return n
+}
+
+// normalizeCacheText applies the selected normalizations so trivially
+// different copies of the same text map to the same cache key.
+func normalizeCacheText(text string, n cacheNormalization) string {
+	if n.Unicode {
+		text = norm.NFC.String(text)
+	}
+	if n.Whitespace {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	if n.Punctuation {
+		text = strings.TrimRightFunc(text, func(r rune) bool {
+			return unicode.IsPunct(r) || unicode.IsSpace(r)
+		})
+	}
+	return text
+}
+
+// hashText returns the hex SHA-256 of text.
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// defaultCacheNamespace is the tenant segment of keys for requests outside
+// any tenant. Tenant IDs can't contain an underscore, so it never collides.
+const defaultCacheNamespace = "_"
+
+// cacheKeySegment keeps model names from adding separators to a key; the
+// hash still tells apart models that map to the same segment.
+var cacheKeySegment = strings.NewReplacer(":", "_", "/", "_")
+
+// cacheNamespace returns the key prefix of ctx's tenant's entries.
+func cacheNamespace(ctx context.Context) string {
+	id := tenantID(ctx)
+	if id == "" {
+		id = defaultCacheNamespace
+	}
+	return currentCacheKeyPrefix() + id + ":"
+}
+
+// getCacheKey derives the cache key for a summarize request, laid out as
+// <prefix><tenant>:<model>:<prompt@version>:<hash>. The model, summarize
+// prompt template and version, and generation params are all hashed so a
+// result is only reused for an identical upstream request; the tenant
+// segment keeps tenants from ever being served each other's entries.
+func getCacheKey(ctx context.Context, model, text string, params map[string]string) string {
+	prompt := promptFor("summarize").ID()
+	var sb strings.Builder
+	sb.WriteString(model)
+	sb.WriteString("\x00")
+	sb.WriteString(prompt)
+	sb.WriteString("\x00")
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteString("=")
+		sb.WriteString(params[name])
+		sb.WriteString("\x00")
+	}
+	sb.WriteString(normalizeCacheText(text, getCacheNormalization()))
+	return cacheNamespace(ctx) + cacheKeySegment.Replace(model) + ":" + prompt + ":" + hashText(sb.String())
+}
+
+// getCachedResponse looks up key in the in-memory L1 cache and then Redis.
+// Redis errors are logged and treated as a miss so a cache outage never fails
+// a paid request. rawText is the unnormalized request text, used to
+// attribute hits to normalization.
+func getCachedResponse(ctx context.Context, key, rawText string) (*CachedResponse, bool) {
+	if memoryCache == nil && redisClient == nil {
+		return nil, false
+	}
+
+	start := time.Now()
+	cached, tier, ok := lookupCache(ctx, key)
+	recordStage(ctx, stageCache, time.Since(start))
+	if !ok {
+		cacheRequestsTotal.Inc("miss")
+		return nil, false
+	}
+
+	if cached.isStale(time.Now()) {
+		if getCacheStaleTTL() == 0 {
+			// Left over from when stale-while-revalidate was enabled.
+			cacheRequestsTotal.Inc("miss")
+			return nil, false
+		}
+		cacheRequestsTotal.Inc("stale")
+	} else {
+		cacheRequestsTotal.Inc("hit")
+	}
+	cacheTierHitsTotal.Inc(tier)
+	if cached.SourceHash != "" && cached.SourceHash != hashText(rawText) {
+		cacheNormalizedHitsTotal.Inc()
+	}
+	return cached, true
+}
+
+// lookupCache returns the entry for key and the tier ("memory" or "redis")
+// that served it. Redis hits are promoted into the L1 cache.
+func lookupCache(ctx context.Context, key string) (*CachedResponse, string, bool) {
+	if memoryCache != nil {
+		if cached, ok := memoryCache.Get(key); ok {
+			return cached, "memory", true
+		}
+	}
+	if redisClient == nil {
+		return nil, "", false
+	}
+
+	data, err := redisClient.Get(ctx, key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("cache get failed for %s: %v", key, err)
+		}
+		return nil, "", false
+	}
+
+	cached, err := decodeCachedResponse(key, data)
+	if err != nil {
+		var decryptErr *cacheDecryptError
+		if errors.As(err, &decryptErr) {
+			cacheDecryptFailuresTotal.Inc(decryptErr.reason)
+		}
+		log.Printf("cache entry %s is corrupt: %v", key, err)
+		return nil, "", false
+	}
+
+	if memoryCache != nil {
+		memoryCache.Set(key, cached)
+	}
+	return cached, "redis", true
+}
+
+// setCachedResponse stores result under key in the L1 cache and, when
+// configured, in Redis for the TTL of ctx's tenant (see cacheTTLFor) plus
+// any stale-while-revalidate window, then holds the tenant to its
+// cache_max_entries.
+func setCachedResponse(ctx context.Context, key, rawText, result string) error {
+	if memoryCache == nil && redisClient == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	ttl := cacheTTLFor(ctx)
+	cached := &CachedResponse{
+		Result:     result,
+		CachedAt:   now,
+		SourceHash: hashText(rawText),
+		ExpiresAt:  now.Add(ttl),
+	}
+	if memoryCache != nil {
+		memoryCache.SetTTL(key, cached, ttl)
+	}
+	if redisClient == nil {
+		enforceCacheQuota(ctx, key, ttl)
+		return nil
+	}
+
+	data, err := encodeCachedResponse(key, cached)
+	if err != nil {
+		return err
+	}
+	if err := redisClient.Set(ctx, key, data, ttl+getCacheStaleTTL()).Err(); err != nil {
+		return err
+	}
+	enforceCacheQuota(ctx, key, ttl+getCacheStaleTTL())
+	return nil
+}
+
+// refreshCacheInBackground regenerates a stale entry without holding up the
+// request that found it. fetchSummary coalesces concurrent refreshes of the
+// same key and writes the new result back to the cache. The refresh runs
+// with the tenant of ctx's request.
+func refreshCacheInBackground(ctx context.Context, cacheKey, model, text string, opts summaryOptions) {
+	go func() {
+		if _, err := fetchSummary(withTenant(context.Background(), tenantFrom(ctx)), cacheKey, model, text, opts); err != nil {
+			log.Printf("background refresh of %s failed: %v", cacheKey, err)
+		}
+	}()
+}
+
+// aiCallResult is what a shared AI call hands to every waiting caller.
+type aiCallResult struct {
+	summary  string
+	provider string
+}
+
+// fetchSummary calls the AI provider for text and caches the result. Callers
+// with the same cache key that arrive while a call is in flight wait for and
+// share its result instead of making their own paid upstream call.
+//
+// The shared call runs on a context detached from any single caller (bounded
+// by the AI timeout) so one client disconnecting does not fail the others;
+// each caller still stops waiting when its own context ends.
+func fetchSummary(ctx context.Context, cacheKey, model, text string, opts summaryOptions) (string, error) {
+	// A micro-batched summary belongs to the wallet whose batch it came
+	// from, so only that wallet's callers share its call
+	flightKey := cacheKey
+	if aiBatcher != nil && aiBatcher.eligible(text) && opts.isZero() {
+		flightKey += "\x00" + payerFrom(ctx)
+	}
+	ch := aiCallGroup.DoChan(flightKey, func() (interface{}, error) {
+		callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), getAITimeout())
+		defer cancel()
+		callCtx, rec := withProviderRecorder(callCtx)
+		callCtx = withGenerationParams(callCtx, opts.Generation)
+
+		var summary string
+		var batched bool
+		var err error
+		if aiChunker != nil && aiChunker.needsChunking(text) {
+			summary, err = aiChunker.Summarize(callCtx, model, text, opts)
+		} else if aiBatcher != nil && aiBatcher.eligible(text) && opts.isZero() {
+			summary, batched, err = aiBatcher.Summarize(callCtx, model, text)
+		} else {
+			summary, err = callAI(callCtx, model, text, opts)
+		}
+		// Only the caller that made the call is billed for its usage; callers
+		// sharing the result cost nothing upstream
+		recordUsage(ctx, rec.Usage())
+		if err != nil {
+			return aiCallResult{}, err
+		}
+		// Withheld output is neither served nor cached
+		if outputModerator != nil {
+			if err := outputModerator.Check(callCtx, summary); err != nil {
+				return aiCallResult{}, err
+			}
+		}
+		// Never cache the mock provider's placeholder in place of a summary,
+		// nor a summary that shared its prompt with other texts: they could
+		// have steered it, and the cache serves it to every wallet
+		if rec.Name() != "mock" && !batched {
+			if err := setCachedResponse(callCtx, cacheKey, text, summary); err != nil {
+				log.Printf("error caching AI response: %v", err)
+			}
+		}
+		return aiCallResult{summary: summary, provider: rec.Name()}, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Shared {
+			aiSharedResultsTotal.Inc()
+		}
+		if res.Err != nil {
+			return "", res.Err
+		}
+		result := res.Val.(aiCallResult)
+		recordProvider(ctx, result.provider)
+		return result.summary, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// deleteCachedResponse removes key from both cache tiers and reports whether
+// it was present in either.
+func deleteCachedResponse(ctx context.Context, key string) (bool, error) {
+	found := false
+	if memoryCache != nil && memoryCache.Delete(key) {
+		found = true
+	}
+	if redisClient != nil {
+		n, err := redisClient.Del(ctx, key).Result()
+		if err != nil {
+			return found, err
+		}
+		found = found || n > 0
+	}
+	return found, nil
+}
+
+// purgeCache removes every AI response entry from both tiers and returns the
+// number of entries deleted from each. Redis keys are removed by SCAN over
+// the cache prefix rather than FLUSHDB, since the database may be shared.
+func purgeCache(ctx context.Context) (memoryDeleted int, redisDeleted int64, err error) {
+	if memoryCache != nil {
+		memoryDeleted = memoryCache.Clear()
+	}
+	if redisClient == nil {
+		return memoryDeleted, 0, nil
+	}
+
+	iter := redisClient.Scan(ctx, 0, cacheKeyPrefix+"*", 500).Iterator()
+	batch := make([]string, 0, 500)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := redisClient.Del(ctx, batch...).Result()
+		redisDeleted += n
+		batch = batch[:0]
+		return err
+	}
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return memoryDeleted, redisDeleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return memoryDeleted, redisDeleted, err
+	}
+	return memoryDeleted, redisDeleted, flush()
+}
+
+// purgeCacheBefore removes the entries cached before before from both tiers
+// and returns how many it removed. Unlike purgeCache it has to read each
+// Redis entry for its age.
+func purgeCacheBefore(ctx context.Context, before time.Time) (int64, error) {
+	var removed int64
+	if memoryCache != nil {
+		removed = int64(memoryCache.PurgeBefore(before))
+	}
+	if redisClient == nil {
+		return removed, nil
+	}
+	iter := redisClient.Scan(ctx, 0, cacheKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		data, err := redisClient.Get(ctx, iter.Val()).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		cached, err := decodeCachedResponse(iter.Val(), data)
+		if err != nil || !cached.CachedAt.Before(before) {
+			continue
+		}
+		n, err := redisClient.Del(ctx, iter.Val()).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, iter.Err()
+}
+
+// isCacheBypass reports whether the client asked to skip the response cache
+// with X-Cache-Bypass: true. The request is still paid for.
+func isCacheBypass(c *gin.Context) bool {
+	return strings.EqualFold(strings.TrimSpace(c.GetHeader("X-Cache-Bypass")), "true")
+}
+
+// CacheStats summarizes cache effectiveness for operators tuning the TTL.
+// Hit and miss counts are cumulative since process start.
+type CacheStats struct {
+	Hits         float64           `json:"hits"`
+	StaleHits    float64           `json:"stale_hits"`
+	Misses       float64           `json:"misses"`
+	Bypasses     float64           `json:"bypasses"`
+	HitRate      float64           `json:"hit_rate"`
+	TTLSeconds   int               `json:"ttl_seconds"`
+	MemoryKeys   int               `json:"memory_keys"`
+	RedisEnabled bool              `json:"redis_enabled"`
+	RedisKeys    int64             `json:"redis_keys"`
+	RedisMemory  map[string]string `json:"redis_memory,omitempty"`
+	RedisPool    *redis.PoolStats  `json:"redis_pool,omitempty"`
+	RedisError   string            `json:"redis_error,omitempty"`
+}
+
+// getCacheStats gathers hit/miss counters, key counts, Redis memory usage
+// (INFO memory) and connection pool stats. Redis problems are reported in
+// RedisError rather than failing the whole call.
+func getCacheStats(ctx context.Context) CacheStats {
+	stats := CacheStats{
+		Hits:       cacheRequestsTotal.Value("hit"),
+		StaleHits:  cacheRequestsTotal.Value("stale"),
+		Misses:     cacheRequestsTotal.Value("miss"),
+		Bypasses:   cacheRequestsTotal.Value("bypass"),
+		TTLSeconds: int(getCacheTTL() / time.Second),
+	}
+	if lookups := stats.Hits + stats.StaleHits + stats.Misses; lookups > 0 {
+		stats.HitRate = (stats.Hits + stats.StaleHits) / lookups
+	}
+	if memoryCache != nil {
+		stats.MemoryKeys = memoryCache.Len()
+	}
+	if redisClient == nil {
+		return stats
+	}
+
+	stats.RedisEnabled = true
+	stats.RedisPool = redisClient.PoolStats()
+
+	iter := redisClient.Scan(ctx, 0, cacheKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		stats.RedisKeys++
+	}
+	if err := iter.Err(); err != nil {
+		stats.RedisError = err.Error()
+		return stats
+	}
+
+	info, err := redisClient.Info(ctx, "memory").Result()
+	if err != nil {
+		stats.RedisError = err.Error()
+		return stats
+	}
+	stats.RedisMemory = parseRedisInfo(info, "used_memory", "used_memory_human", "used_memory_peak_human", "maxmemory_human", "maxmemory_policy")
+	return stats
+}
+
+// parseRedisInfo picks the requested fields out of an INFO reply.
+func parseRedisInfo(info string, fields ...string) map[string]string {
+	wanted := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		wanted[f] = true
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && wanted[name] {
+			values[name] = value
+		}
+	}
+	return values
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redis cache values are either plain JSON (always starting with '{', as
+// written by older gateways) or a format marker byte followed by the encoded
+// payload. Markers are non-printable so they can never collide with JSON.
+const (
+	cacheFormatGzipJSON byte = 0x01
+	// cacheFormatAESGCM is followed by the key ID's length (one byte), the
+	// key ID, the 12-byte nonce and the sealed value, itself plain JSON or
+	// gzip-marked. The Redis key is the additional data, so an entry can't
+	// be moved to another key.
+	cacheFormatAESGCM byte = 0x02
+)
+
+var cacheDecryptFailuresTotal = newCounter(
+	"gateway_cache_decrypt_failures_total",
+	"Redis cache entries that couldn't be read with CACHE_ENCRYPTION_KEYS, by reason (unknown_key, invalid, unencrypted).",
+	"reason",
+)
+
+// cacheDecryptError is why an entry couldn't be read with the keyring,
+// counted by lookupCache.
+type cacheDecryptError struct {
+	reason string // unknown_key, invalid or unencrypted
+	msg    string
+}
+
+func (e *cacheDecryptError) Error() string {
+	return e.msg
+}
+
+// getCacheCompressionThreshold returns the serialized size in bytes above
+// which entries are gzip-compressed, from CACHE_COMPRESSION_THRESHOLD_BYTES
+// (default 1024). Zero or negative disables compression.
+func getCacheCompressionThreshold() int {
+	return getEnvAsInt("CACHE_COMPRESSION_THRESHOLD_BYTES", 1024)
+}
+
+// cacheKeyring holds the AES-256-GCM keys of CACHE_ENCRYPTION_KEYS by ID.
+// New entries are sealed with the primary key; the others only open
+// entries written before a rotation.
+type cacheKeyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// cacheKeyID is what a key ID may look like: it is stored in every entry.
+var cacheKeyID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// parseCacheKeyring parses comma-separated id:key pairs, each key 32
+// base64-encoded bytes, the first being the primary.
+func parseCacheKeyring(raw string) (*cacheKeyring, error) {
+	ring := &cacheKeyring{keys: make(map[string]cipher.AEAD)}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok || !cacheKeyID.MatchString(id) {
+			return nil, fmt.Errorf("%q is not id:base64key with an ID of up to 32 letters, digits, '.', '_' or '-'", pair)
+		}
+		if _, dup := ring.keys[id]; dup {
+			return nil, fmt.Errorf("key ID %q is used twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, base64-encoded", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[id] = aead
+		if ring.primary == "" {
+			ring.primary = id
+		}
+	}
+	if ring.primary == "" {
+		return nil, errors.New("no keys")
+	}
+	return ring, nil
+}
+
+var (
+	cacheKeyringMu     sync.Mutex
+	cacheKeyringSource string
+	cacheKeyringParsed *cacheKeyring
+)
+
+// currentCacheKeyring returns the keyring from CACHE_ENCRYPTION_KEYS, or nil
+// when it is unset. The setting may be a secret:// reference to a KMS-held
+// key, so it is parsed again whenever secret rotation changes it.
+func currentCacheKeyring() (*cacheKeyring, error) {
+	raw := os.Getenv("CACHE_ENCRYPTION_KEYS")
+	if raw == "" {
+		return nil, nil
+	}
+	cacheKeyringMu.Lock()
+	defer cacheKeyringMu.Unlock()
+	if raw != cacheKeyringSource {
+		ring, err := parseCacheKeyring(raw)
+		if err != nil {
+			return nil, fmt.Errorf("CACHE_ENCRYPTION_KEYS: %w", err)
+		}
+		cacheKeyringSource, cacheKeyringParsed = raw, ring
+	}
+	return cacheKeyringParsed, nil
+}
+
+// encodeCachedResponse serializes an entry for Redis under key, compressing
+// it when it exceeds the configured threshold and encrypting it when
+// CACHE_ENCRYPTION_KEYS is set.
+func encodeCachedResponse(key string, cached *CachedResponse) ([]byte, error) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := getCacheCompressionThreshold()
+	if threshold > 0 && len(data) > threshold {
+		var buf bytes.Buffer
+		buf.WriteByte(cacheFormatGzipJSON)
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+
+	ring, err := currentCacheKeyring()
+	if err != nil || ring == nil {
+		return data, err
+	}
+	aead := ring.keys[ring.primary]
+	out := make([]byte, 0, 2+len(ring.primary)+aead.NonceSize()+len(data)+aead.Overhead())
+	out = append(out, cacheFormatAESGCM, byte(len(ring.primary)))
+	out = append(out, ring.primary...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, data, []byte(key)), nil
+}
+
+// decodeCachedResponse reverses encodeCachedResponse and also accepts the
+// plain JSON written before compression was introduced. With
+// CACHE_ENCRYPTION_KEYS set, only entries encrypted for key are accepted,
+// so one planted in Redis unencrypted is never served.
+func decodeCachedResponse(key string, data []byte) (*CachedResponse, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty cache entry")
+	}
+
+	ring, err := currentCacheKeyring()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ring != nil && data[0] != cacheFormatAESGCM:
+		return nil, &cacheDecryptError{"unencrypted", "cache entry is not encrypted"}
+	case ring == nil && data[0] == cacheFormatAESGCM:
+		return nil, fmt.Errorf("cache entry is encrypted but CACHE_ENCRYPTION_KEYS is not set")
+	case ring != nil:
+		if data, err = ring.open(key, data); err != nil {
+			return nil, err
+		}
+		if len(data) == 0 || data[0] == cacheFormatAESGCM {
+			return nil, &cacheDecryptError{"invalid", "invalid encrypted cache entry"}
+		}
+	}
+
+	switch data[0] {
+	case '{':
+		// Uncompressed JSON
+	case cacheFormatGzipJSON:
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip cache entry: %w", err)
+		}
+		defer zr.Close()
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("invalid gzip cache entry: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cache entry format 0x%02x", data[0])
+	}
+
+	var cached CachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+	return &cached, nil
+}
+
+// open decrypts an AES-GCM entry stored under key.
+func (r *cacheKeyring) open(key string, data []byte) ([]byte, error) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return nil, &cacheDecryptError{"invalid", "truncated encrypted cache entry"}
+	}
+	id := string(data[2 : 2+int(data[1])])
+	aead, ok := r.keys[id]
+	if !ok {
+		return nil, &cacheDecryptError{"unknown_key", fmt.Sprintf("cache entry is encrypted with unknown key %q", id)}
+	}
+	sealed := data[2+len(id):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, &cacheDecryptError{"invalid", "truncated encrypted cache entry"}
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, &cacheDecryptError{"invalid", fmt.Sprintf("cache entry failed authentication with key %q", id)}
+	}
+	return plain, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedResponseCodec_CompressesAboveThreshold(t *testing.T) {
+	t.Setenv("CACHE_COMPRESSION_THRESHOLD_BYTES", "256")
+
+	small := &CachedResponse{Result: "short", CachedAt: time.Now().UTC()}
+	data, err := encodeCachedResponse("k", small)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if data[0] != '{' {
+		t.Errorf("Expected small entry stored as plain JSON, got marker 0x%02x", data[0])
+	}
+
+	large := &CachedResponse{Result: strings.Repeat("long summary ", 500), CachedAt: time.Now().UTC()}
+	data, err = encodeCachedResponse("k", large)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if data[0] != cacheFormatGzipJSON {
+		t.Fatalf("Expected gzip marker, got 0x%02x", data[0])
+	}
+	if len(data) >= len(large.Result) {
+		t.Errorf("Expected compressed entry smaller than %d bytes, got %d", len(large.Result), len(data))
+	}
+
+	decoded, err := decodeCachedResponse("k", data)
+	if err != nil || decoded.Result != large.Result {
+		t.Errorf("Round trip failed: %v", err)
+	}
+}
+
+func TestDecodeCachedResponse_LegacyAndInvalid(t *testing.T) {
+	legacy := []byte(`{"result":"old entry","cached_at":"2024-01-01T00:00:00Z"}`)
+	decoded, err := decodeCachedResponse("k", legacy)
+	if err != nil || decoded.Result != "old entry" {
+		t.Errorf("Expected legacy JSON to decode, got %v, %v", decoded, err)
+	}
+
+	for _, data := range [][]byte{nil, {0x7f, 'x'}, {cacheFormatGzipJSON, 'x'}} {
+		if _, err := decodeCachedResponse("k", data); err == nil {
+			t.Errorf("Expected error decoding %q", data)
+		}
+	}
+}
+
+func TestCachedResponse_CompressedRedisRoundTrip(t *testing.T) {
+	mr := setupTestRedis(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	t.Setenv("CACHE_COMPRESSION_THRESHOLD_BYTES", "64")
+	ctx := context.Background()
+
+	result := strings.Repeat("compressible ", 100)
+	if err := setCachedResponse(ctx, "ai:summary:v2:big", "text", result); err != nil {
+		t.Fatalf("setCachedResponse failed: %v", err)
+	}
+	raw, _ := mr.Get("ai:summary:v2:big")
+	if raw == "" || raw[0] != cacheFormatGzipJSON {
+		t.Fatal("Expected compressed value in Redis")
+	}
+
+	memoryCache.Clear()
+	cached, ok := getCachedResponse(ctx, "ai:summary:v2:big", "text")
+	if !ok || cached.Result != result {
+		t.Error("Expected compressed entry to be served from Redis")
+	}
+}
+
+// testCacheKey returns a base64-encoded 32-byte key filled with b.
+func testCacheKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestCachedResponseCodec_Encrypted(t *testing.T) {
+	t.Setenv("CACHE_COMPRESSION_THRESHOLD_BYTES", "256")
+	t.Setenv("CACHE_ENCRYPTION_KEYS", "k1:"+testCacheKey(1))
+
+	for _, result := range []string{"a secret summary", strings.Repeat("a secret summary ", 100)} {
+		data, err := encodeCachedResponse("ai:summary:v3:a", &CachedResponse{Result: result})
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		if data[0] != cacheFormatAESGCM || string(data[2:2+data[1]]) != "k1" || bytes.Contains(data, []byte("secret")) {
+			t.Fatalf("Expected an entry sealed with k1, got %q", data)
+		}
+		decoded, err := decodeCachedResponse("ai:summary:v3:a", data)
+		if err != nil || decoded.Result != result {
+			t.Fatalf("Round trip failed: %v", err)
+		}
+		if _, err := decodeCachedResponse("ai:summary:v3:b", data); err == nil {
+			t.Error("Expected an entry moved to another key refused")
+		}
+	}
+
+	old, _ := encodeCachedResponse("key", &CachedResponse{Result: "old"})
+	// Rotation: entries sealed with the old key still read
+	t.Setenv("CACHE_ENCRYPTION_KEYS", "k2:"+testCacheKey(2)+",k1:"+testCacheKey(1))
+	if decoded, err := decodeCachedResponse("key", old); err != nil || decoded.Result != "old" {
+		t.Errorf("Expected an entry under the previous key to decode, got %v", err)
+	}
+	data, _ := encodeCachedResponse("key", &CachedResponse{Result: "new"})
+	if string(data[2:2+data[1]]) != "k2" {
+		t.Errorf("Expected new entries sealed with the primary key, got %q", data[2:2+data[1]])
+	}
+
+	t.Setenv("CACHE_ENCRYPTION_KEYS", "k2:"+testCacheKey(2))
+	if _, err := decodeCachedResponse("key", old); err == nil || err.(*cacheDecryptError).reason != "unknown_key" {
+		t.Errorf("Expected an entry under a retired key refused, got %v", err)
+	}
+	plain := []byte(`{"result":"planted"}`)
+	if _, err := decodeCachedResponse("key", plain); err == nil || err.(*cacheDecryptError).reason != "unencrypted" {
+		t.Errorf("Expected an unencrypted entry refused, got %v", err)
+	}
+	for _, data := range [][]byte{{cacheFormatAESGCM}, {cacheFormatAESGCM, 5, 'k'}, append([]byte{cacheFormatAESGCM, 2, 'k', '2'}, make([]byte, 40)...)} {
+		if _, err := decodeCachedResponse("key", data); err == nil {
+			t.Errorf("Expected error decoding %q", data)
+		}
+	}
+}
+
+func TestParseCacheKeyring(t *testing.T) {
+	ring, err := parseCacheKeyring(" new:" + testCacheKey(2) + ", old:" + testCacheKey(1))
+	if err != nil || ring.primary != "new" || len(ring.keys) != 2 {
+		t.Fatalf("Expected two keys with new primary, got %+v, %v", ring, err)
+	}
+	for _, raw := range []string{
+		"",
+		testCacheKey(1),
+		"k1:short",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("sixteen byte key")),
+		"bad id:" + testCacheKey(1),
+		"k1:" + testCacheKey(1) + ",k1:" + testCacheKey(2),
+	} {
+		if _, err := parseCacheKeyring(raw); err == nil {
+			t.Errorf("Expected %q refused", raw)
+		}
+	}
+}
+
+func TestCachedResponse_EncryptedRedisRoundTrip(t *testing.T) {
+	mr := setupTestRedis(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	t.Setenv("CACHE_ENCRYPTION_KEYS", "k1:"+testCacheKey(1))
+	ctx := context.Background()
+
+	if err := setCachedResponse(ctx, "ai:summary:v3:enc", "text", "private summary"); err != nil {
+		t.Fatalf("setCachedResponse failed: %v", err)
+	}
+	raw, _ := mr.Get("ai:summary:v3:enc")
+	if raw == "" || raw[0] != cacheFormatAESGCM || strings.Contains(raw, "private") {
+		t.Fatalf("Expected an encrypted value in Redis, got %q", raw)
+	}
+	memoryCache.Clear()
+	if cached, ok := getCachedResponse(ctx, "ai:summary:v3:enc", "text"); !ok || cached.Result != "private summary" {
+		t.Error("Expected the encrypted entry served from Redis")
+	}
+
+	mr.Set("ai:summary:v3:planted", `{"result":"planted","cached_at":"2030-01-01T00:00:00Z"}`)
+	before := cacheDecryptFailuresTotal.Value("unencrypted")
+	if _, ok := getCachedResponse(ctx, "ai:summary:v3:planted", "text"); ok {
+		t.Error("Expected an unencrypted entry treated as a miss")
+	}
+	if got := cacheDecryptFailuresTotal.Value("unencrypted") - before; got != 1 {
+		t.Errorf("Expected one unencrypted failure counted, got %v", got)
+	}
+}
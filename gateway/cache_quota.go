@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// cacheQuotaIndexPrefix keys a sorted set per tenant of its cached entries,
+// scored by when they were written. It sits outside cacheKeyPrefix so
+// purges and stats never mistake it for an entry.
+const cacheQuotaIndexPrefix = "ai:summary-index:"
+
+var cacheQuotaEvictionsTotal = newCounter(
+	"gateway_cache_quota_evictions_total",
+	"Cached summaries evicted to keep a tenant within its cache_max_entries quota.",
+	"tenant",
+)
+
+// cacheQuotaScript records key in a tenant's index, forgets entries that
+// have expired since, and pops the oldest entries beyond the quota, so
+// replicas writing at once agree on what to evict.
+var cacheQuotaScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+local excess = redis.call('ZCARD', KEYS[1]) - tonumber(ARGV[4])
+if excess <= 0 then
+  return {}
+end
+local evicted = redis.call('ZRANGE', KEYS[1], 0, excess - 1)
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, excess - 1)
+return evicted
+`)
+
+// enforceCacheQuota evicts ctx's tenant's oldest entries once it holds more
+// than its cache_max_entries, after key was written to be kept for keep.
+// Errors are logged: an entry over quota is better than a failed request.
+func enforceCacheQuota(ctx context.Context, key string, keep time.Duration) {
+	t := tenantFrom(ctx)
+	if t == nil || t.CacheMaxEntries <= 0 {
+		return
+	}
+
+	var evicted []string
+	if redisClient == nil {
+		if memoryCache != nil {
+			evicted = memoryCache.TrimPrefix(cacheNamespace(ctx), t.CacheMaxEntries)
+		}
+	} else {
+		now := time.Now()
+		var err error
+		evicted, err = cacheQuotaScript.Run(ctx, redisClient, []string{cacheQuotaIndexPrefix + t.ID},
+			key, now.UnixMilli(), now.Add(-keep).UnixMilli(), t.CacheMaxEntries, keep.Milliseconds()).StringSlice()
+		if err != nil {
+			log.Printf("cache quota of tenant %s not enforced: %v", t.ID, err)
+			return
+		}
+		for _, k := range evicted {
+			if _, err := deleteCachedResponse(ctx, k); err != nil {
+				log.Printf("cache quota eviction of %s failed: %v", k, err)
+			}
+		}
+	}
+	if len(evicted) > 0 {
+		cacheQuotaEvictionsTotal.Add(float64(len(evicted)), t.ID)
+	}
+}
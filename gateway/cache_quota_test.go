@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetCachedResponse_TenantQuotaInMemory(t *testing.T) {
+	l1 := setupTestMemoryCache(t, 10, time.Hour)
+	tenant := &Tenant{ID: "small", CacheMaxEntries: 2}
+	ctx := withTenant(context.Background(), tenant)
+	evictionsBefore := cacheQuotaEvictionsTotal.Value("small")
+
+	shared := getCacheKey(context.Background(), "m", "a", nil)
+	setCachedResponse(context.Background(), shared, "a", "summary")
+	keys := make([]string, 3)
+	for i, text := range []string{"a", "b", "c"} {
+		keys[i] = getCacheKey(ctx, "m", text, nil)
+		setCachedResponse(ctx, keys[i], text, "summary")
+	}
+
+	if _, ok := l1.Get(keys[0]); ok {
+		t.Error("Expected the tenant's oldest entry to be evicted")
+	}
+	for _, key := range []string{keys[1], keys[2], shared} {
+		if _, ok := l1.Get(key); !ok {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+	if n := cacheQuotaEvictionsTotal.Value("small") - evictionsBefore; n != 1 {
+		t.Errorf("Expected 1 eviction counted, got %v", n)
+	}
+}
+
+func TestSetCachedResponse_TenantQuotaInRedis(t *testing.T) {
+	mr := setupTestRedis(t)
+	setupTestMemoryCache(t, 10, time.Hour)
+	ctx := withTenant(context.Background(), &Tenant{ID: "small", CacheTTLSeconds: 60, CacheMaxEntries: 2})
+
+	keys := make([]string, 3)
+	for i, text := range []string{"a", "b", "c"} {
+		keys[i] = getCacheKey(ctx, "m", text, nil)
+		if err := setCachedResponse(ctx, keys[i], text, "summary"); err != nil {
+			t.Fatalf("setCachedResponse failed: %v", err)
+		}
+		// Entries written in the same millisecond would tie in the index
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if _, ok := getCachedResponse(ctx, keys[0], "a"); ok {
+		t.Error("Expected the tenant's oldest entry evicted from both tiers")
+	}
+	if _, ok := getCachedResponse(ctx, keys[2], "c"); !ok {
+		t.Error("Expected the newest entry to be kept")
+	}
+	if ttl := mr.TTL(keys[2]); ttl != 60*time.Second {
+		t.Errorf("Expected the tenant's TTL on its entries, got %v", ttl)
+	}
+	if members, _ := mr.ZMembers(cacheQuotaIndexPrefix + "small"); len(members) != 2 {
+		t.Errorf("Expected 2 entries indexed, got %v", members)
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Headers of a request paid from a payment channel: the channel, the
+// cumulative amount the client now owes on it and the sender's signature
+// of channelBalanceMessage.
+const (
+	channelHeader          = "X-402-Channel"
+	channelAmountHeader    = "X-402-Channel-Amount"
+	channelSignatureHeader = "X-402-Channel-Signature"
+	channelCloseSigHeader  = "X-Channel-Close-Signature"
+)
+
+// Channels live in Redis when REDIS_URL is set, so every instance sees the
+// same balance: one key per channel and a set of the open ones.
+const (
+	channelKeyPrefix = "channel:"
+	openChannelsKey  = "channels:open"
+)
+
+// Channel states.
+const (
+	channelOpen   = "open"
+	channelClosed = "closed"
+)
+
+// channelIDPattern is a bytes32, as the channel contract identifies them.
+var channelIDPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+var channelPaymentsTotal = newCounter(
+	"gateway_channel_payments_total",
+	"Requests paid from a payment channel, by outcome (accepted, rejected, reverted).",
+	"outcome",
+)
+
+// PaymentChannel is a unidirectional channel from a client wallet to the
+// gateway. The client deposits on-chain once, then signs a higher
+// cumulative Spent with each request; the gateway settles the last one when
+// the channel closes.
+type PaymentChannel struct {
+	ID        string    `json:"id" example:"0x5f1d3a0c9b7e2f4a6d8c0b1e3f5a7c9d2e4f6a8b0c1d3e5f7a9b2c4d6e8f0a1b"`
+	Sender    string    `json:"sender" doc:"Wallet that funded the channel and signs balance updates"`
+	Token     string    `json:"token" example:"USDC"`
+	ChainID   int       `json:"chain_id" example:"8453"`
+	Deposit   string    `json:"deposit" doc:"Amount deposited on-chain" example:"5"`
+	Spent     string    `json:"spent" doc:"Cumulative amount of the latest accepted balance update" example:"0.042"`
+	Signature string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at" doc:"When the channel closes and its balance is settled"`
+	TxHash    string    `json:"tx_hash,omitempty" doc:"Deposit transaction"`
+	Status    string    `json:"status" example:"open"`
+	ClosedAt  time.Time `json:"closed_at,omitempty"`
+}
+
+// ChannelResponse is the body of GET /v1/channels/:id and of the close
+// endpoints.
+type ChannelResponse struct {
+	PaymentChannel
+	Remaining string `json:"remaining" doc:"Deposit left to spend" example:"4.958"`
+}
+
+// ChannelDeposit is one line of CHANNEL_DEPOSITS_FILE, written by whatever
+// watches the channel contract for deposits.
+type ChannelDeposit struct {
+	ChannelID string    `json:"channel_id"`
+	Sender    string    `json:"sender"`
+	Token     string    `json:"token"`
+	ChainID   int       `json:"chain_id"`
+	Deposit   string    `json:"deposit"`
+	ExpiresAt time.Time `json:"expires_at"`
+	TxHash    string    `json:"tx_hash"`
+}
+
+// channelBalanceMessage is what the sender signs (EIP-191 personal_sign)
+// to owe amount in total on the channel.
+func channelBalanceMessage(id, amount string) string {
+	return fmt.Sprintf("MicroAI-Paygate channel %s balance %s", strings.ToLower(id), amount)
+}
+
+// channelCloseMessage is what the sender signs to close the channel early.
+func channelCloseMessage(id string) string {
+	return fmt.Sprintf("MicroAI-Paygate close channel %s", strings.ToLower(id))
+}
+
+// paymentChannelsEnabled reports whether CHANNEL_DEPOSITS_FILE is set.
+func paymentChannelsEnabled() bool {
+	return os.Getenv("CHANNEL_DEPOSITS_FILE") != ""
+}
+
+// findChannelDeposit looks id up in CHANNEL_DEPOSITS_FILE; the last line
+// for a channel wins. It returns nil when the deposit isn't there.
+func findChannelDeposit(id string) (*ChannelDeposit, error) {
+	f, err := os.Open(os.Getenv("CHANNEL_DEPOSITS_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var found *ChannelDeposit
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d ChannelDeposit
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			continue
+		}
+		if strings.EqualFold(d.ChannelID, id) {
+			found = &d
+		}
+	}
+	return found, scanner.Err()
+}
+
+// errChannelNotFound is returned by channelStore.update for an unknown
+// channel.
+var errChannelNotFound = errors.New("channel not found")
+
+// channelStore keeps channel balances.
+type channelStore interface {
+	// get returns the channel, or nil when it isn't known.
+	get(ctx context.Context, id string) (*PaymentChannel, error)
+	// create adds a channel unless one with its ID exists.
+	create(ctx context.Context, ch PaymentChannel) error
+	// update applies fn to the channel atomically; fn's error aborts it.
+	update(ctx context.Context, id string, fn func(*PaymentChannel) error) (*PaymentChannel, error)
+	// openIDs lists the channels that haven't been closed.
+	openIDs(ctx context.Context) ([]string, error)
+}
+
+func currentChannelStore() channelStore {
+	if redisClient != nil {
+		return redisChannelStore{client: redisClient}
+	}
+	return memoryChannels
+}
+
+type memoryChannelStore struct {
+	mu       sync.Mutex
+	channels map[string]PaymentChannel
+}
+
+var memoryChannels = &memoryChannelStore{channels: make(map[string]PaymentChannel)}
+
+func (s *memoryChannelStore) get(_ context.Context, id string) (*PaymentChannel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.channels[id]; ok {
+		return &ch, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryChannelStore) create(_ context.Context, ch PaymentChannel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.channels[ch.ID]; !ok {
+		s.channels[ch.ID] = ch
+	}
+	return nil
+}
+
+func (s *memoryChannelStore) update(_ context.Context, id string, fn func(*PaymentChannel) error) (*PaymentChannel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[id]
+	if !ok {
+		return nil, errChannelNotFound
+	}
+	if err := fn(&ch); err != nil {
+		return nil, err
+	}
+	s.channels[id] = ch
+	return &ch, nil
+}
+
+func (s *memoryChannelStore) openIDs(context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, ch := range s.channels {
+		if ch.Status == channelOpen {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+type redisChannelStore struct {
+	client *redis.Client
+}
+
+// storedChannel adds the signature, which PaymentChannel keeps out of API
+// responses, to what is stored.
+type storedChannel struct {
+	PaymentChannel
+	Signature string `json:"signature,omitempty"`
+}
+
+func decodeChannel(raw []byte) (*PaymentChannel, error) {
+	var stored storedChannel
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil, err
+	}
+	stored.PaymentChannel.Signature = stored.Signature
+	return &stored.PaymentChannel, nil
+}
+
+func encodeChannel(ch PaymentChannel) ([]byte, error) {
+	return json.Marshal(storedChannel{PaymentChannel: ch, Signature: ch.Signature})
+}
+
+func (s redisChannelStore) get(ctx context.Context, id string) (*PaymentChannel, error) {
+	raw, err := s.client.Get(ctx, channelKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeChannel(raw)
+}
+
+func (s redisChannelStore) create(ctx context.Context, ch PaymentChannel) error {
+	data, err := encodeChannel(ch)
+	if err != nil {
+		return err
+	}
+	created, err := s.client.SetNX(ctx, channelKeyPrefix+ch.ID, data, 0).Result()
+	if err != nil || !created {
+		return err
+	}
+	return s.client.SAdd(ctx, openChannelsKey, ch.ID).Err()
+}
+
+// update retries when another instance changed the channel in between, so
+// two requests can never spend the same balance.
+func (s redisChannelStore) update(ctx context.Context, id string, fn func(*PaymentChannel) error) (*PaymentChannel, error) {
+	key := channelKeyPrefix + id
+	var updated *PaymentChannel
+	for attempt := 0; attempt < 10; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			raw, err := tx.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				return errChannelNotFound
+			}
+			if err != nil {
+				return err
+			}
+			ch, err := decodeChannel(raw)
+			if err != nil {
+				return err
+			}
+			if err := fn(ch); err != nil {
+				return err
+			}
+			data, err := encodeChannel(*ch)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 0)
+				if ch.Status != channelOpen {
+					pipe.SRem(ctx, openChannelsKey, id)
+				}
+				return nil
+			})
+			updated = ch
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return updated, err
+		}
+	}
+	return nil, errors.New("channel update kept conflicting")
+}
+
+func (s redisChannelStore) openIDs(ctx context.Context) ([]string, error) {
+	return s.client.SMembers(ctx, openChannelsKey).Result()
+}
+
+// loadChannel returns the channel, registering it from its deposit the
+// first time it is used.
+func loadChannel(ctx context.Context, id string) (*PaymentChannel, error) {
+	store := currentChannelStore()
+	ch, err := store.get(ctx, id)
+	if err != nil || ch != nil {
+		return ch, err
+	}
+	deposit, err := findChannelDeposit(id)
+	if err != nil || deposit == nil {
+		return nil, err
+	}
+	if err := store.create(ctx, PaymentChannel{
+		ID: strings.ToLower(id), Sender: deposit.Sender, Token: deposit.Token, ChainID: deposit.ChainID,
+		Deposit: deposit.Deposit, Spent: "0", ExpiresAt: deposit.ExpiresAt, TxHash: deposit.TxHash, Status: channelOpen,
+	}); err != nil {
+		return nil, err
+	}
+	return store.get(ctx, id)
+}
+
+// channelCharge is a balance update accepted for one request.
+type channelCharge struct {
+	channelID string
+	sender    string
+	previous  string // Spent before the update
+	// previousSignature is the sender's signature of previous, which is
+	// still owed if the update is reverted
+	previousSignature string
+	amount            string // Spent after it
+}
+
+// nonce identifies the request's payment in receipts and the ledger, where
+// per-request payments have their nonce.
+func (ch *channelCharge) nonce() string {
+	return ch.channelID + ":" + ch.amount
+}
+
+// channelProblem is a balance update that was refused.
+type channelProblem struct{ problem *Problem }
+
+func (e *channelProblem) Error() string { return e.problem.Detail }
+
+// chargeChannel accepts the request's balance update on the channel named
+// by X-402-Channel when it covers price: the new cumulative amount must be
+// at least the spent amount plus the price, within the deposit, and signed
+// by the sender. No verifier call is made. It has aborted the request when
+// it returns nil.
+func chargeChannel(c *gin.Context, paymentCtx PaymentContext) *channelCharge {
+	id := strings.ToLower(c.GetHeader(channelHeader))
+	amount := c.GetHeader(channelAmountHeader)
+	signature := c.GetHeader(channelSignatureHeader)
+	reject := func(p *Problem) *channelCharge {
+		channelPaymentsTotal.Inc("rejected")
+		abortWithProblem(c, p)
+		return nil
+	}
+	if !paymentChannelsEnabled() {
+		return reject(newProblem(400, codeChannelNotFound, "Bad Request", "Payment channels are not enabled (CHANNEL_DEPOSITS_FILE)"))
+	}
+	if !channelIDPattern.MatchString(id) || amount == "" || signature == "" {
+		return reject(newProblem(400, codeInvalidPaymentHeader, "Bad Request",
+			channelHeader+" must be a 0x-prefixed bytes32, with "+channelAmountHeader+" and "+channelSignatureHeader))
+	}
+	owed, ok := parseDecimal(amount)
+	if !ok || owed.Sign() <= 0 {
+		return reject(newProblem(400, codeInvalidPaymentHeader, "Bad Request", channelAmountHeader+" must be a positive decimal"))
+	}
+	signer, err := recoverPersonalSigner(channelBalanceMessage(id, amount), signature)
+	if err != nil {
+		return reject(newProblem(403, codeInvalidSignature, "Invalid Signature", "X-402-Channel-Signature is not a valid signature"))
+	}
+
+	ctx := c.Request.Context()
+	ch, err := loadChannel(ctx, id)
+	if err != nil {
+		log.Printf("error loading channel %s: %v", id, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to load the channel", ""))
+		return nil
+	}
+	if ch == nil {
+		return reject(newProblem(404, codeChannelNotFound, "Channel not found", "No deposit is known for this channel yet"))
+	}
+
+	price, _ := new(big.Rat).SetString(paymentCtx.Amount)
+	charge := &channelCharge{channelID: id, amount: amount}
+	_, err = currentChannelStore().update(ctx, id, func(ch *PaymentChannel) error {
+		switch {
+		case ch.Status != channelOpen || !time.Now().Before(ch.ExpiresAt):
+			return &channelProblem{newProblem(409, codeChannelClosed, "Conflict", "The channel is closed or has expired")}
+		case !strings.EqualFold(signer, ch.Sender):
+			return &channelProblem{newProblem(403, codeInvalidSignature, "Invalid Signature", "The balance update was not signed by the channel's sender")}
+		case ch.Token != paymentCtx.Token || ch.ChainID != paymentCtx.ChainID:
+			return &channelProblem{newProblem(402, codePaymentMismatch, "Payment Required", "The channel's token or chain is not the one this gateway accepts")}
+		}
+		spent, _ := new(big.Rat).SetString(ch.Spent)
+		deposit, _ := new(big.Rat).SetString(ch.Deposit)
+		required := new(big.Rat).Add(spent, price)
+		if owed.Cmp(deposit) > 0 || required.Cmp(deposit) > 0 {
+			return &channelProblem{newProblem(402, codeChannelExhausted, "Payment Required", "The channel's deposit does not cover this request").
+				With("spent", ch.Spent).With("deposit", ch.Deposit)}
+		}
+		if owed.Cmp(required) < 0 {
+			return &channelProblem{newProblem(402, codeChannelUnderpaid, "Payment Required", "Sign a balance of at least the spent amount plus the price").
+				With("spent", ch.Spent).With("required", formatAmount(required))}
+		}
+		charge.sender, charge.previous, charge.previousSignature = ch.Sender, ch.Spent, ch.Signature
+		ch.Spent, ch.Signature = amount, signature
+		return nil
+	})
+	var refused *channelProblem
+	if errors.As(err, &refused) {
+		return reject(refused.problem)
+	}
+	if err != nil {
+		log.Printf("error charging channel %s: %v", id, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to charge the channel", ""))
+		return nil
+	}
+	channelPaymentsTotal.Inc("accepted")
+	return charge
+}
+
+// revertChannelChargeIfFailed takes back a balance update when the request
+// failed, so the client can sign the same amount again; the sender's
+// signature of the previous balance is restored so it can still be
+// settled. A later update
+// that was accepted meanwhile is left alone. Run it deferred after
+// chargeChannel.
+func revertChannelChargeIfFailed(c *gin.Context, charge *channelCharge) {
+	if c.Writer.Status() == 200 && c.Request.Context().Err() == nil {
+		return
+	}
+	_, err := currentChannelStore().update(context.WithoutCancel(c.Request.Context()), charge.channelID, func(ch *PaymentChannel) error {
+		if ch.Spent != charge.amount {
+			return errors.New("channel was charged again")
+		}
+		ch.Spent, ch.Signature = charge.previous, charge.previousSignature
+		return nil
+	})
+	if err != nil {
+		log.Printf("channel %s: not reverting the charge to %s: %v", charge.channelID, charge.amount, err)
+		return
+	}
+	channelPaymentsTotal.Inc("reverted")
+}
+
+// closeChannel marks the channel closed and hands its final balance to the
+// settlement queue, with the sender's signature of it. Closing a closed
+// channel changes nothing.
+func closeChannel(ctx context.Context, id string) (*PaymentChannel, error) {
+	var settle bool
+	ch, err := currentChannelStore().update(ctx, id, func(ch *PaymentChannel) error {
+		settle = ch.Status == channelOpen
+		if settle {
+			ch.Status, ch.ClosedAt = channelClosed, time.Now().UTC()
+		}
+		return nil
+	})
+	if err != nil || !settle {
+		return ch, err
+	}
+	if spent, _ := new(big.Rat).SetString(ch.Spent); spent.Sign() > 0 {
+		submitSettlement(SettlementRequest{
+			Nonce: ch.ID, Wallet: strings.ToLower(ch.Sender), Signature: ch.Signature, ChannelID: ch.ID,
+			PaymentContext: PaymentContext{Recipient: getRecipientAddress(), Token: ch.Token, Amount: ch.Spent, Nonce: ch.ID, ChainID: ch.ChainID},
+		})
+	}
+	log.Printf("Closed channel %s with %s %s spent", ch.ID, ch.Spent, ch.Token)
+	return ch, nil
+}
+
+// startChannelExpiry closes expired channels every minute until ctx is
+// done, so their balance gets settled.
+func startChannelExpiry(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				closeExpiredChannels(ctx, time.Now())
+			}
+		}
+	}()
+}
+
+func closeExpiredChannels(ctx context.Context, now time.Time) {
+	store := currentChannelStore()
+	ids, err := store.openIDs(ctx)
+	if err != nil {
+		log.Printf("error listing payment channels: %v", err)
+		return
+	}
+	for _, id := range ids {
+		ch, err := store.get(ctx, id)
+		if err != nil || ch == nil || now.Before(ch.ExpiresAt) {
+			continue
+		}
+		if _, err := closeChannel(ctx, id); err != nil {
+			log.Printf("error closing expired channel %s: %v", id, err)
+		}
+	}
+}
+
+func channelResponse(ch *PaymentChannel) ChannelResponse {
+	deposit, _ := new(big.Rat).SetString(ch.Deposit)
+	spent, _ := new(big.Rat).SetString(ch.Spent)
+	return ChannelResponse{PaymentChannel: *ch, Remaining: formatAmount(new(big.Rat).Sub(deposit, spent))}
+}
+
+// handleGetChannel handles GET /v1/channels/:id.
+func handleGetChannel(c *gin.Context) {
+	if !paymentChannelsEnabled() {
+		abortWithProblem(c, newProblem(404, codeChannelNotFound, "Channel not found", "Payment channels are not enabled"))
+		return
+	}
+	id := strings.ToLower(c.Param("id"))
+	ch, err := loadChannel(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("error loading channel %s: %v", id, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to load the channel", ""))
+		return
+	}
+	if ch == nil {
+		abortWithProblem(c, newProblem(404, codeChannelNotFound, "Channel not found", "No deposit is known for this channel yet"))
+		return
+	}
+	c.JSON(200, channelResponse(ch))
+}
+
+// handleCloseChannel handles POST /v1/channels/:id/close, which the sender
+// authorizes by signing channelCloseMessage in X-Channel-Close-Signature.
+func handleCloseChannel(c *gin.Context) {
+	closeChannelRequest(c, false)
+}
+
+// handleAdminCloseChannel handles POST /admin/channels/:id/close.
+func handleAdminCloseChannel(c *gin.Context) {
+	closeChannelRequest(c, true)
+}
+
+func closeChannelRequest(c *gin.Context, admin bool) {
+	id := strings.ToLower(c.Param("id"))
+	ch, err := loadChannel(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("error loading channel %s: %v", id, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to load the channel", ""))
+		return
+	}
+	if ch == nil {
+		abortWithProblem(c, newProblem(404, codeChannelNotFound, "Channel not found", "No deposit is known for this channel yet"))
+		return
+	}
+	if !admin {
+		signer, err := recoverPersonalSigner(channelCloseMessage(id), c.GetHeader(channelCloseSigHeader))
+		if err != nil || !strings.EqualFold(signer, ch.Sender) {
+			abortWithProblem(c, newProblem(401, codeUnauthorized, "Unauthorized", "Sign the close message with the channel's sender and send it in "+channelCloseSigHeader).
+				With("message", channelCloseMessage(id)))
+			return
+		}
+	}
+	ch, err = closeChannel(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("error closing channel %s: %v", id, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to close the channel", ""))
+		return
+	}
+	if admin {
+		auditAdminAction(c, auditChannelClosed, map[string]string{"channel": id, "spent": ch.Spent})
+	}
+	c.JSON(200, channelResponse(ch))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testChannelID = "0x5f1d3a0c9b7e2f4a6d8c0b1e3f5a7c9d2e4f6a8b0c1d3e5f7a9b2c4d6e8f0a1b"
+
+// setupTestChannel writes a deposit of 0.005 USDC into testChannelID from a
+// new wallet to CHANNEL_DEPOSITS_FILE, and returns the wallet's key.
+func setupTestChannel(t *testing.T, expiresAt time.Time) *ecdsa.PrivateKey {
+	t.Helper()
+	key, _ := crypto.GenerateKey()
+	deposit, _ := json.Marshal(ChannelDeposit{
+		ChannelID: testChannelID, Sender: crypto.PubkeyToAddress(key.PublicKey).Hex(), Token: "USDC",
+		ChainID: getChainID(), Deposit: "0.005", ExpiresAt: expiresAt, TxHash: "0xdeposit",
+	})
+	path := filepath.Join(t.TempDir(), "deposits.jsonl")
+	if err := os.WriteFile(path, append(deposit, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CHANNEL_DEPOSITS_FILE", path)
+	prev := memoryChannels
+	memoryChannels = &memoryChannelStore{channels: make(map[string]PaymentChannel)}
+	t.Cleanup(func() { memoryChannels = prev })
+	return key
+}
+
+func sendChannelPayment(t *testing.T, r http.Handler, key *ecdsa.PrivateKey, amount string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"text paid from a channel"}`))
+	req.Header.Set(channelHeader, testChannelID)
+	req.Header.Set(channelAmountHeader, amount)
+	req.Header.Set(channelSignatureHeader, personalSign(t, key, channelBalanceMessage(testChannelID, amount)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestChannel_PaysRequestsWithBalanceUpdates(t *testing.T) {
+	ai, _, queued := setupSettlementTest(t, settlementImmediate)
+	key := setupTestChannel(t, time.Now().Add(time.Hour))
+	ai.Reply("a summary")
+	r := setupVersionedRouter()
+
+	for _, amount := range []string{"0.001", "0.0025"} {
+		if w := sendChannelPayment(t, r, key, amount); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for balance %s, got %d: %s", amount, w.Code, w.Body.String())
+		}
+	}
+	if len(queued()) != 0 {
+		t.Error("Expected channel payments to wait for the channel to close")
+	}
+
+	ch, _ := currentChannelStore().get(context.Background(), testChannelID)
+	if ch == nil || ch.Spent != "0.0025" || !strings.EqualFold(ch.Sender, crypto.PubkeyToAddress(key.PublicKey).Hex()) {
+		t.Fatalf("Expected the channel at 0.0025 spent, got %+v", ch)
+	}
+}
+
+func TestChannel_RejectsBadUpdates(t *testing.T) {
+	ai, _, _ := setupSettlementTest(t, settlementImmediate)
+	key := setupTestChannel(t, time.Now().Add(time.Hour))
+	ai.Reply("a summary")
+	r := setupVersionedRouter()
+
+	if w := sendChannelPayment(t, r, key, "0.002"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	tests := []struct {
+		name   string
+		amount string
+		key    *ecdsa.PrivateKey
+		status int
+		code   string
+	}{
+		{"replayed balance", "0.002", key, 402, codeChannelUnderpaid},
+		{"less than the price", "0.0025", key, 402, codeChannelUnderpaid},
+		{"beyond the deposit", "0.006", key, 402, codeChannelExhausted},
+		{"another signer", "0.003", mustGenerateKey(t), 403, codeInvalidSignature},
+		{"hex amount", "0x10", key, 400, codeInvalidPaymentHeader},
+		{"binary amount", "0b1", key, 400, codeInvalidPaymentHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := sendChannelPayment(t, r, tt.key, tt.amount)
+			if p := decodeProblem(t, w); w.Code != tt.status || p["code"] != tt.code {
+				t.Errorf("Expected %d %s, got %d %v", tt.status, tt.code, w.Code, p)
+			}
+		})
+	}
+	if w := sendChannelPayment(t, r, key, "0.004"); w.Code != http.StatusOK {
+		t.Errorf("Expected the next valid balance to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChannel_RevertsFailedRequests(t *testing.T) {
+	ai, _, _ := setupSettlementTest(t, settlementImmediate)
+	key := setupTestChannel(t, time.Now().Add(time.Hour))
+	ai.FailWith(500, `{"error":{"message":"boom"}}`)
+	r := setupVersionedRouter()
+
+	if w := sendChannelPayment(t, r, key, "0.001"); w.Code == http.StatusOK {
+		t.Fatal("Expected the AI failure to fail the request")
+	}
+	ch, _ := currentChannelStore().get(context.Background(), testChannelID)
+	if ch.Spent != "0" {
+		t.Errorf("Expected the failed request's charge reverted, got %s spent", ch.Spent)
+	}
+
+	// The same balance can be signed again
+	ai.Reply("a summary")
+	if w := sendChannelPayment(t, r, key, "0.001"); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Reverting a later update keeps the signature of the balance still owed
+	signed, _ := currentChannelStore().get(context.Background(), testChannelID)
+	ai.FailWith(500, `{"error":{"message":"boom"}}`)
+	sendChannelPayment(t, r, key, "0.002")
+	ch, _ = currentChannelStore().get(context.Background(), testChannelID)
+	if ch.Spent != "0.001" || ch.Signature == "" || ch.Signature != signed.Signature {
+		t.Errorf("Expected the 0.001 balance and its signature restored, got %s spent, signature %q", ch.Spent, ch.Signature)
+	}
+}
+
+func TestChannel_UnknownAndExpired(t *testing.T) {
+	ai, _, _ := setupSettlementTest(t, settlementImmediate)
+	key := setupTestChannel(t, time.Now().Add(-time.Minute))
+	ai.Reply("a summary")
+	r := setupVersionedRouter()
+
+	w := sendChannelPayment(t, r, key, "0.001")
+	if p := decodeProblem(t, w); w.Code != http.StatusConflict || p["code"] != codeChannelClosed {
+		t.Errorf("Expected 409 channel_closed, got %d %v", w.Code, p)
+	}
+
+	t.Setenv("CHANNEL_DEPOSITS_FILE", filepath.Join(t.TempDir(), "empty.jsonl"))
+	os.WriteFile(os.Getenv("CHANNEL_DEPOSITS_FILE"), nil, 0o600)
+	memoryChannels = &memoryChannelStore{channels: make(map[string]PaymentChannel)}
+	w = sendChannelPayment(t, r, key, "0.001")
+	if p := decodeProblem(t, w); w.Code != http.StatusNotFound || p["code"] != codeChannelNotFound {
+		t.Errorf("Expected 404 channel_not_found, got %d %v", w.Code, p)
+	}
+}
+
+func TestChannel_CloseSettlesFinalBalance(t *testing.T) {
+	ai, _, queued := setupSettlementTest(t, settlementImmediate)
+	key := setupTestChannel(t, time.Now().Add(time.Hour))
+	ai.Reply("a summary")
+	r := setupVersionedRouter()
+
+	if w := sendChannelPayment(t, r, key, "0.0015"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	close := func(signature string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/channels/"+testChannelID+"/close", nil)
+		req.Header.Set(channelCloseSigHeader, signature)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := close(personalSign(t, mustGenerateKey(t), channelCloseMessage(testChannelID))); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a close not signed by the sender, got %d", w.Code)
+	}
+	w := close(personalSign(t, key, channelCloseMessage(testChannelID)))
+	var resp ChannelResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Status != channelClosed || resp.Remaining != "0.0035" {
+		t.Fatalf("Expected the closed channel, got %d: %s", w.Code, w.Body.String())
+	}
+
+	settled := queued()
+	if len(settled) != 1 || settled[0].ChannelID != testChannelID || settled[0].PaymentContext.Amount != "0.0015" ||
+		settled[0].Signature != personalSign(t, key, channelBalanceMessage(testChannelID, "0.0015")) {
+		t.Fatalf("Expected one settlement of the final balance, got %+v", settled)
+	}
+
+	// Closing again settles nothing more, and the channel takes no payments
+	close(personalSign(t, key, channelCloseMessage(testChannelID)))
+	if len(queued()) != 1 {
+		t.Error("Expected a second close not to settle again")
+	}
+	if w := sendChannelPayment(t, r, key, "0.003"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 on a closed channel, got %d", w.Code)
+	}
+}
+
+func TestCloseExpiredChannels(t *testing.T) {
+	_, _, queued := setupSettlementTest(t, settlementImmediate)
+	setupTestChannel(t, time.Now().Add(time.Hour))
+	ctx := context.Background()
+	if ch, err := loadChannel(ctx, testChannelID); err != nil || ch == nil {
+		t.Fatalf("Expected the channel to load from its deposit, got %v", err)
+	}
+
+	closeExpiredChannels(ctx, time.Now())
+	if ch, _ := currentChannelStore().get(ctx, testChannelID); ch.Status != channelOpen {
+		t.Fatal("Expected an unexpired channel to stay open")
+	}
+	closeExpiredChannels(ctx, time.Now().Add(2*time.Hour))
+	if ch, _ := currentChannelStore().get(ctx, testChannelID); ch.Status != channelClosed {
+		t.Error("Expected the expired channel closed")
+	}
+	if len(queued()) != 0 {
+		t.Error("Expected nothing to settle for a channel that spent nothing")
+	}
+}
+
+func TestAdminCloseChannel(t *testing.T) {
+	setupSettlementTest(t, settlementImmediate)
+	setupTestChannel(t, time.Now().Add(time.Hour))
+	t.Setenv("ADMIN_API_TOKEN", "test-admin-token")
+	r := setupAdminRouter()
+
+	req, _ := http.NewRequest("POST", "/admin/channels/"+testChannelID+"/close", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"closed"`) {
+		t.Errorf("Expected 200 with the closed channel, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func mustGenerateKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
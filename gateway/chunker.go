@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// aiChunker map-reduces texts too long for one upstream call. It is nil
+// unless CHUNKING_ENABLED=true.
+var aiChunker *chunker
+
+var chunkedSummariesTotal = newCounter(
+	"gateway_chunked_summaries_total",
+	"Summaries produced by map-reduce over chunks of a long text.",
+)
+
+var chunkCallsTotal = newCounter(
+	"gateway_chunk_calls_total",
+	"Upstream calls made for chunked summaries, by stage (map, reduce).",
+	"stage",
+)
+
+// chunker splits texts longer than maxChars into chunks, summarizes them in
+// parallel (at most concurrency calls at once) and then summarizes the
+// summaries.
+type chunker struct {
+	maxChars    int
+	concurrency int
+}
+
+// initChunker builds the chunker from CHUNKING_ENABLED, CHUNK_MAX_CHARS
+// (default 24000, roughly 6k tokens) and CHUNK_CONCURRENCY (default 4).
+func initChunker() *chunker {
+	if strings.ToLower(os.Getenv("CHUNKING_ENABLED")) != "true" {
+		return nil
+	}
+	c := newChunker(getEnvAsInt("CHUNK_MAX_CHARS", 24000), getEnvAsInt("CHUNK_CONCURRENCY", 4))
+	log.Printf("Chunking enabled (chunks of <=%d chars, %d in parallel)", c.maxChars, c.concurrency)
+	return c
+}
+
+func newChunker(maxChars, concurrency int) *chunker {
+	if maxChars < 100 {
+		maxChars = 100
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &chunker{maxChars: maxChars, concurrency: concurrency}
+}
+
+// needsChunking reports whether text is too long for a single call.
+func (ch *chunker) needsChunking(text string) bool {
+	return utf8.RuneCountInString(text) > ch.maxChars
+}
+
+// Summarize summarizes each chunk of text, then reduces the chunk summaries
+// to the final summary. If the joined summaries are themselves still too
+// long they are chunked again, so arbitrarily long inputs converge. opts
+// apply to the final reduce step; chunks are summarized in their own
+// language.
+func (ch *chunker) Summarize(ctx context.Context, model, text string, opts summaryOptions) (string, error) {
+	chunkedSummariesTotal.Inc()
+	for ch.needsChunking(text) {
+		chunks := splitIntoChunks(text, ch.maxChars)
+		summaries, err := ch.summarizeChunks(ctx, model, chunks)
+		if err != nil {
+			return "", err
+		}
+		reduced := strings.Join(summaries, "\n\n")
+		if len(reduced) >= len(text) {
+			return "", fmt.Errorf("chunk summaries (%d bytes) are no shorter than their input", len(reduced))
+		}
+		text = reduced
+	}
+	chunkCallsTotal.Inc("reduce")
+	return callAI(ctx, model, text, opts)
+}
+
+// summarizeChunks runs the map stage. The first failure cancels the
+// remaining calls since the summary would be incomplete without it.
+func (ch *chunker) summarizeChunks(ctx context.Context, model string, chunks []string) ([]string, error) {
+	summaries := make([]string, len(chunks))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(ch.concurrency)
+	for i, chunk := range chunks {
+		g.Go(func() error {
+			chunkCallsTotal.Inc("map")
+			prompt := promptFor("chunk").Render(promptData{Text: chunk, Part: i + 1, Parts: len(chunks)})
+			summary, err := callAIPrompt(gctx, model, prompt)
+			if err != nil {
+				return fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+			}
+			summaries[i] = strings.TrimSpace(summary)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		// Report a deadline as such so the handler still answers 504.
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// splitIntoChunks splits text into pieces of at most maxChars characters,
+// preferring paragraph breaks, then sentence ends, then whitespace, and
+// only cutting mid-word when the second half of a chunk has no whitespace.
+func splitIntoChunks(text string, maxChars int) []string {
+	var chunks []string
+	rest := strings.TrimSpace(text)
+	for utf8.RuneCountInString(rest) > maxChars {
+		// Byte offset of the first maxChars runes.
+		limit := len(rest)
+		n := 0
+		for i := range rest {
+			if n == maxChars {
+				limit = i
+				break
+			}
+			n++
+		}
+		window := rest[:limit]
+
+		cut := -1
+		for _, sep := range []string{"\n\n", ". ", "\n", " "} {
+			// Ignore separators in the first half so chunks stay reasonably full.
+			if i := strings.LastIndex(window, sep); i > len(window)/2 {
+				cut = i + len(sep)
+				break
+			}
+		}
+		if cut < 0 {
+			cut = limit
+		}
+		if chunk := strings.TrimSpace(rest[:cut]); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		rest = strings.TrimSpace(rest[cut:])
+	}
+	if rest != "" {
+		chunks = append(chunks, rest)
+	}
+	return chunks
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gateway/testsupport"
+)
+
+func TestSplitIntoChunks(t *testing.T) {
+	paragraph := strings.Repeat("word ", 30) // 150 chars
+	text := paragraph + "\n\n" + paragraph + "\n\n" + paragraph
+
+	chunks := splitIntoChunks(text, 200)
+	if len(chunks) != 3 {
+		t.Fatalf("Expected one chunk per paragraph, got %d: %q", len(chunks), chunks)
+	}
+	for _, c := range chunks {
+		if c != strings.TrimSpace(paragraph) {
+			t.Errorf("Expected chunks to break at paragraphs, got %q", c)
+		}
+	}
+
+	// No whitespace at all: hard cuts, without splitting multi-byte runes
+	long := strings.Repeat("é", 250)
+	chunks = splitIntoChunks(long, 100)
+	if len(chunks) != 3 || strings.Join(chunks, "") != long {
+		t.Fatalf("Expected 3 chunks reassembling the input, got %d", len(chunks))
+	}
+	for _, c := range chunks {
+		if n := utf8.RuneCountInString(c); n > 100 || !utf8.ValidString(c) {
+			t.Errorf("Chunk of %d runes (valid UTF-8: %v)", n, utf8.ValidString(c))
+		}
+	}
+
+	if chunks := splitIntoChunks("short text", 100); len(chunks) != 1 {
+		t.Errorf("Expected short text to stay whole, got %d chunks", len(chunks))
+	}
+}
+
+func TestChunker_MapReduce(t *testing.T) {
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.ReplyFunc(func(req testsupport.ChatRequest) (string, error) {
+		prompt := req.Prompt()
+		var part, total int
+		if _, err := fmt.Sscanf(prompt, "This is part %d of %d", &part, &total); err == nil {
+			return fmt.Sprintf("summary %d", part), nil
+		}
+		return "final", nil
+	})
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+
+	ch := newChunker(200, 2)
+	text := strings.Repeat(strings.Repeat("x", 150)+"\n\n", 5)
+	if !ch.needsChunking(text) {
+		t.Fatal("Expected text to need chunking")
+	}
+
+	summary, err := ch.Summarize(context.Background(), "test-model", text, summaryOptions{})
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if summary != "final" {
+		t.Errorf("Expected the reduced summary, got %q", summary)
+	}
+
+	reqs := ai.Requests()
+	if len(reqs) != 6 {
+		t.Fatalf("Expected 5 map calls and 1 reduce call, got %d", len(reqs))
+	}
+	reduce := reqs[len(reqs)-1].Prompt()
+	for i := 1; i <= 5; i++ {
+		if !strings.Contains(reduce, fmt.Sprintf("summary %d", i)) {
+			t.Errorf("Expected reduce prompt to contain summary %d in order, got %q", i, reduce)
+		}
+	}
+	if strings.Index(reduce, "summary 1") > strings.Index(reduce, "summary 5") {
+		t.Errorf("Expected chunk summaries in document order, got %q", reduce)
+	}
+}
+
+func TestChunker_FailsWhenAChunkFails(t *testing.T) {
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.FailWith(500, `{"error":{"message":"boom"}}`)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+
+	ch := newChunker(100, 4)
+	if _, err := ch.Summarize(context.Background(), "", strings.Repeat("word ", 100), summaryOptions{}); err == nil {
+		t.Error("Expected an error when a chunk cannot be summarized")
+	}
+}
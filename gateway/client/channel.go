@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenDecimals is how many decimals channel amounts are rounded up to, as
+// the gateway prices in USDC.
+const tokenDecimals = 6
+
+// Channel pays requests from a payment channel the wallet has deposited
+// into on-chain. Each paid request signs the channel's new cumulative
+// balance, which the gateway checks itself instead of verifying a payment;
+// the final balance is settled when the channel closes. A Channel is safe
+// for concurrent use, but only one Channel should pay from a channel ID.
+type Channel struct {
+	ID     string
+	signer MessageSigner
+
+	mu    sync.Mutex
+	spent *big.Rat
+}
+
+// NewChannel returns a channel paying from id, signed with signer, that has
+// already spent spent ("0" for a new channel; see ChannelState.Spent).
+func NewChannel(id string, signer MessageSigner, spent string) (*Channel, error) {
+	amount, ok := new(big.Rat).SetString(spent)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid spent amount %q", spent)
+	}
+	return &Channel{ID: strings.ToLower(id), signer: signer, spent: amount}, nil
+}
+
+// WithChannel pays for requests from ch instead of signing each payment.
+func WithChannel(ch *Channel) Option {
+	return func(c *Client) { c.channel = ch }
+}
+
+// Spent returns the cumulative amount signed so far.
+func (ch *Channel) Spent() string {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return formatTokenAmount(ch.spent)
+}
+
+// ChannelBalanceMessage is the message signed to owe amount in total on
+// channel id.
+func ChannelBalanceMessage(id, amount string) string {
+	return fmt.Sprintf("MicroAI-Paygate channel %s balance %s", strings.ToLower(id), amount)
+}
+
+// ChannelCloseMessage is the message signed to close channel id.
+func ChannelCloseMessage(id string) string {
+	return fmt.Sprintf("MicroAI-Paygate close channel %s", strings.ToLower(id))
+}
+
+// reserve adds price to the spent amount and returns the new balance.
+func (ch *Channel) reserve(price *big.Rat) (previous, amount *big.Rat) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	previous = ch.spent
+	ch.spent = new(big.Rat).Add(ch.spent, price)
+	return previous, ch.spent
+}
+
+// release undoes a reservation the gateway didn't accept, unless another
+// request has been paid since.
+func (ch *Channel) release(previous, amount *big.Rat) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.spent == amount {
+		ch.spent = previous
+	}
+}
+
+// resync takes the gateway's spent amount when it is ahead of ours.
+func (ch *Channel) resync(spent string) bool {
+	amount, ok := new(big.Rat).SetString(spent)
+	if !ok {
+		return false
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if amount.Cmp(ch.spent) <= 0 {
+		return false
+	}
+	ch.spent = amount
+	return true
+}
+
+// payFromChannel sends the request paying price from the channel. When the
+// gateway has seen a higher balance than this Channel (channel_underpaid),
+// it catches up and retries once.
+func (c *Client) payFromChannel(ctx context.Context, path string, header http.Header, body []byte, price string) (*http.Response, []byte, error) {
+	amount, ok := new(big.Rat).SetString(price)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid price %q", price)
+	}
+	for attempt := 0; ; attempt++ {
+		previous, balance := c.channel.reserve(amount)
+		owed := formatTokenAmount(balance)
+		signature, err := c.channel.signer.SignMessage(ctx, ChannelBalanceMessage(c.channel.ID, owed))
+		if err != nil {
+			c.channel.release(previous, balance)
+			return nil, nil, fmt.Errorf("sign channel balance: %w", err)
+		}
+		paid := header.Clone()
+		if paid == nil {
+			paid = http.Header{}
+		}
+		paid.Set("X-402-Channel", c.channel.ID)
+		paid.Set("X-402-Channel-Amount", owed)
+		paid.Set("X-402-Channel-Signature", signature)
+		resp, respBody, err := c.send(ctx, "POST", path, paid, body)
+		if err != nil {
+			c.channel.release(previous, balance)
+			return nil, nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return resp, respBody, nil
+		}
+		c.channel.release(previous, balance)
+		apiErr := apiErrorFrom(resp, respBody)
+		var spent string
+		if attempt == 0 && apiErr.Code == CodeChannelUnderpaid && json.Unmarshal(apiErr.Extensions["spent"], &spent) == nil && c.channel.resync(spent) {
+			continue
+		}
+		return resp, respBody, nil
+	}
+}
+
+// formatTokenAmount renders amount rounded up to tokenDecimals, without
+// trailing zeros, as the gateway formats prices.
+func formatTokenAmount(amount *big.Rat) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(tokenDecimals), nil)
+	scaled := new(big.Rat).Mul(amount, new(big.Rat).SetInt(scale))
+	units, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() > 0 {
+		units.Add(units, big.NewInt(1))
+	}
+	s := new(big.Rat).SetFrac(units, scale).FloatString(tokenDecimals)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// ChannelState is a payment channel as the gateway sees it.
+type ChannelState struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Token     string    `json:"token"`
+	ChainID   int       `json:"chain_id"`
+	Deposit   string    `json:"deposit"`
+	Spent     string    `json:"spent"`
+	Remaining string    `json:"remaining"`
+	ExpiresAt time.Time `json:"expires_at"`
+	TxHash    string    `json:"tx_hash,omitempty"`
+	Status    string    `json:"status"`
+	ClosedAt  time.Time `json:"closed_at,omitempty"`
+}
+
+// GetChannel calls GET /v1/channels/{id}.
+func (c *Client) GetChannel(ctx context.Context, id string) (*ChannelState, error) {
+	return c.channelRequest(ctx, "GET", "/v1/channels/"+url.PathEscape(id), nil)
+}
+
+// CloseChannel calls POST /v1/channels/{id}/close for the channel set with
+// WithChannel, so the gateway settles its balance now.
+func (c *Client) CloseChannel(ctx context.Context) (*ChannelState, error) {
+	if c.channel == nil {
+		return nil, errors.New("no payment channel configured")
+	}
+	signature, err := c.channel.signer.SignMessage(ctx, ChannelCloseMessage(c.channel.ID))
+	if err != nil {
+		return nil, fmt.Errorf("sign channel close: %w", err)
+	}
+	header := http.Header{"X-Channel-Close-Signature": {signature}}
+	return c.channelRequest(ctx, "POST", "/v1/channels/"+url.PathEscape(c.channel.ID)+"/close", header)
+}
+
+func (c *Client) channelRequest(ctx context.Context, method, path string, header http.Header) (*ChannelState, error) {
+	resp, body, err := c.send(ctx, method, path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiErrorFrom(resp, body)
+	}
+	var state ChannelState
+	if err := json.Unmarshal(body, &state); err != nil {
+		return nil, fmt.Errorf("decode channel: %w", err)
+	}
+	return &state, nil
+}
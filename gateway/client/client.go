@@ -0,0 +1,450 @@
+// Package client calls MicroAI Paygate endpoints. It handles the x402
+// payment flow: the first request is answered with 402 and a payment
+// context, which is signed with the configured Signer and sent again with
+// the X-402-Signature and X-402-Nonce headers, or paid from a payment
+// channel set with WithChannel or a prepaid balance set with WithPrepaid.
+// Gateway errors are returned as *APIError.
+//
+//	signer, _ := client.NewPrivateKeySigner(os.Getenv("WALLET_KEY"))
+//	c := client.New("https://paygate.example.com", client.WithSigner(signer))
+//	res, err := c.Summarize(ctx, client.SummarizeRequest{Text: text})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes bounds how much of a response body is read.
+const maxResponseBytes = 10 << 20
+
+// Client is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	signer     Signer
+	channel    *Channel
+	prepaid    *Prepaid
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests (default: one with
+// a 60s timeout).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithSigner sets the signer used to pay for requests. Without one, paid
+// endpoints fail with ErrNoSigner.
+func WithSigner(s Signer) Option {
+	return func(c *Client) { c.signer = s }
+}
+
+// New returns a client for the gateway at baseURL, e.g. http://localhost:3000.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// PaidResponse is the successful response to a paid request.
+type PaidResponse struct {
+	StatusCode int
+	Header     http.Header
+	// Payment is the context that was signed; nil if the gateway did not ask
+	// for payment.
+	Payment *PaymentContext
+	// Receipt is decoded from the X-402-Receipt header when present.
+	Receipt *SignedReceipt
+	// Settlement is decoded from the X-PAYMENT-RESPONSE header, set when
+	// the gateway settles through an x402 facilitator.
+	Settlement *Settlement
+}
+
+// Post sends in as JSON to the paid endpoint at path, paying if the gateway
+// answers 402, and decodes the successful response into out (if non-nil).
+func (c *Client) Post(ctx context.Context, path string, header http.Header, in, out interface{}) (*PaidResponse, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	resp, respBody, err := c.send(ctx, "POST", path, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var payment *PaymentContext
+	if resp.StatusCode == http.StatusPaymentRequired {
+		payment, err = paymentContextFrom(resp, respBody)
+		if err != nil {
+			return nil, err
+		}
+		if c.channel != nil {
+			resp, respBody, err = c.payFromChannel(ctx, path, header, body, payment.Amount)
+		} else if c.prepaid != nil {
+			resp, respBody, err = c.payFromPrepaid(ctx, path, header, body, payment)
+		} else {
+			resp, respBody, err = c.payWithSigner(ctx, path, header, body, payment)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, apiErrorFrom(resp, respBody)
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+	}
+
+	result := &PaidResponse{StatusCode: resp.StatusCode, Header: resp.Header, Payment: payment}
+	if encoded := resp.Header.Get("X-402-Receipt"); encoded != "" {
+		result.Receipt, err = decodeReceiptHeader(encoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if encoded := resp.Header.Get("X-PAYMENT-RESPONSE"); encoded != "" {
+		result.Settlement, err = decodeSettlementHeader(encoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// payWithSigner sends the request again with payment signed by the Signer.
+func (c *Client) payWithSigner(ctx context.Context, path string, header http.Header, body []byte, payment *PaymentContext) (*http.Response, []byte, error) {
+	if c.signer == nil {
+		return nil, nil, ErrNoSigner
+	}
+	signature, err := c.signer.SignPayment(ctx, *payment)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sign payment: %w", err)
+	}
+
+	paid := header.Clone()
+	if paid == nil {
+		paid = http.Header{}
+	}
+	paid.Set("X-402-Signature", signature)
+	paid.Set("X-402-Nonce", payment.Nonce)
+	// Tell the gateway what was signed, so a mismatch comes back as a
+	// clear 402 rather than a signature that recovers to another wallet
+	signed, err := json.Marshal(payment)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode payment: %w", err)
+	}
+	paid.Set("X-402-Payment", base64.StdEncoding.EncodeToString(signed))
+	return c.send(ctx, "POST", path, paid, body)
+}
+
+// SummarizeRequest is the input to Summarize.
+type SummarizeRequest struct {
+	Text  string `json:"text"`
+	Model string `json:"model,omitempty"`
+	// OutputLanguage is an ISO 639-1 code to write the summary in.
+	OutputLanguage string `json:"output_language,omitempty"`
+	// Temperature, TopP and MaxTokens are passed to the provider after the
+	// gateway clamps them to its configured ranges; nil leaves the default.
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	// BypassCache asks the gateway to skip its response cache.
+	BypassCache bool `json:"-"`
+}
+
+// SummarizeResult is a paid summary and its receipt.
+type SummarizeResult struct {
+	Summary string
+	Receipt *SignedReceipt
+	// Stale is true when the summary came from an expired cache entry.
+	Stale bool
+	// Cache is the X-Cache header: HIT, STALE, MISS or BYPASS.
+	Cache     string
+	RequestID string
+	// Provider is the AI provider that produced the summary (X-AI-Provider);
+	// empty for cache hits.
+	Provider string
+	// Generation holds the sampling parameters the gateway actually used,
+	// or nil when the request set none.
+	Generation *GenerationParams
+	// Settlement is set when the gateway settled through a facilitator.
+	Settlement *Settlement
+}
+
+// GenerationParams are the effective sampling parameters of a summary.
+type GenerationParams struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+}
+
+// Summarize calls POST /v1/ai/summarize.
+func (c *Client) Summarize(ctx context.Context, req SummarizeRequest) (*SummarizeResult, error) {
+	var header http.Header
+	if req.BypassCache {
+		header = http.Header{"X-Cache-Bypass": {"true"}}
+	}
+
+	var body struct {
+		Result     string            `json:"result"`
+		Receipt    *SignedReceipt    `json:"receipt"`
+		Stale      bool              `json:"stale"`
+		Generation *GenerationParams `json:"generation"`
+	}
+	resp, err := c.Post(ctx, "/v1/ai/summarize", header, req, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := resp.Receipt
+	if receipt == nil {
+		receipt = body.Receipt
+	}
+	return &SummarizeResult{
+		Summary:    body.Result,
+		Receipt:    receipt,
+		Stale:      body.Stale,
+		Cache:      resp.Header.Get("X-Cache"),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+		Provider:   resp.Header.Get("X-AI-Provider"),
+		Generation: body.Generation,
+		Settlement: resp.Settlement,
+	}, nil
+}
+
+// Quote is the price of a summarize request and the payment to sign for it.
+type Quote struct {
+	Price          string        `json:"price"`
+	Token          string        `json:"token"`
+	Tokens         int           `json:"tokens"`
+	Model          string        `json:"model"`
+	OutputLanguage string        `json:"output_language,omitempty"`
+	Pricing        string        `json:"pricing"`
+	LoadMultiplier string        `json:"load_multiplier,omitempty"`
+	Chains         []ChainOption `json:"chains"`
+	// PaymentContext holds the price until ExpiresAt.
+	PaymentContext PaymentContext `json:"paymentContext"`
+	ExpiresAt      time.Time      `json:"expires_at"`
+}
+
+// ChainOption is a chain the gateway accepts payments on.
+type ChainOption struct {
+	ChainID   int    `json:"chain_id"`
+	Network   string `json:"network"`
+	Token     string `json:"token"`
+	Asset     string `json:"asset,omitempty"`
+	Recipient string `json:"recipient"`
+}
+
+// Quote calls POST /v1/ai/summarize/quote, which prices req without paying
+// for it, so the price can be shown before anything is signed.
+func (c *Client) Quote(ctx context.Context, req SummarizeRequest) (*Quote, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := c.send(ctx, "POST", "/v1/ai/summarize/quote", nil, in)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiErrorFrom(resp, body)
+	}
+	var quote Quote
+	if err := json.Unmarshal(body, &quote); err != nil {
+		return nil, fmt.Errorf("decode quote: %w", err)
+	}
+	return &quote, nil
+}
+
+// GetReceipt calls GET /v1/receipts/{id}.
+func (c *Client) GetReceipt(ctx context.Context, id string) (*SignedReceipt, error) {
+	resp, body, err := c.send(ctx, "GET", "/v1/receipts/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiErrorFrom(resp, body)
+	}
+	var receipt SignedReceipt
+	if err := json.Unmarshal(body, &receipt); err != nil {
+		return nil, fmt.Errorf("decode receipt: %w", err)
+	}
+	return &receipt, nil
+}
+
+// VerifyReceipt calls GET /v1/receipts/{id}/verify, which has the gateway
+// check the receipt's signature. Use the package-level VerifyReceipt to
+// check a receipt without trusting the gateway's answer.
+func (c *Client) VerifyReceipt(ctx context.Context, id string) (*ReceiptVerification, error) {
+	resp, body, err := c.send(ctx, "GET", "/v1/receipts/"+url.PathEscape(id)+"/verify", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiErrorFrom(resp, body)
+	}
+	var verification ReceiptVerification
+	if err := json.Unmarshal(body, &verification); err != nil {
+		return nil, fmt.Errorf("decode receipt verification: %w", err)
+	}
+	return &verification, nil
+}
+
+// GetReceiptProof calls GET /v1/receipts/{id}/proof. Until the receipt's
+// batch is built the error has code CodeProofPending and a RetryAfter.
+func (c *Client) GetReceiptProof(ctx context.Context, id string) (*ReceiptProof, error) {
+	resp, body, err := c.send(ctx, "GET", "/v1/receipts/"+url.PathEscape(id)+"/proof", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiErrorFrom(resp, body)
+	}
+	var proof ReceiptProof
+	if err := json.Unmarshal(body, &proof); err != nil {
+		return nil, fmt.Errorf("decode receipt proof: %w", err)
+	}
+	return &proof, nil
+}
+
+// PaymentStatus is what became of a payment: authorized, pending, settled,
+// confirmed, finalized, failed or refunded.
+type PaymentStatus struct {
+	Nonce     string `json:"nonce"`
+	State     string `json:"state"`
+	Verified  bool   `json:"verified"`
+	Wallet    string `json:"wallet,omitempty"`
+	Amount    string `json:"amount,omitempty"`
+	Token     string `json:"token,omitempty"`
+	ChainID   int    `json:"chain_id,omitempty"`
+	ReceiptID string `json:"receipt_id,omitempty"`
+	TxHash    string `json:"tx_hash,omitempty"`
+	// Confirmations is nil when the gateway doesn't check the chain.
+	Confirmations *uint64 `json:"confirmations,omitempty"`
+	// FailureReason is reverted or dropped when the settlement failed.
+	FailureReason  string `json:"failure_reason,omitempty"`
+	RefundID       string `json:"refund_id,omitempty"`
+	RefundedAmount string `json:"refunded_amount,omitempty"`
+}
+
+// GetPayment calls GET /v1/payments/{nonce}, to confirm the gateway
+// collected a payment.
+func (c *Client) GetPayment(ctx context.Context, nonce string) (*PaymentStatus, error) {
+	resp, body, err := c.send(ctx, "GET", "/v1/payments/"+url.PathEscape(nonce), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiErrorFrom(resp, body)
+	}
+	var status PaymentStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("decode payment status: %w", err)
+	}
+	return &status, nil
+}
+
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, application/problem+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read response: %w", err)
+	}
+	return resp, respBody, nil
+}
+
+// paymentContextFrom extracts the payment context from a 402 response.
+func paymentContextFrom(resp *http.Response, body []byte) (*PaymentContext, error) {
+	apiErr := apiErrorFrom(resp, body)
+	raw, ok := apiErr.Extensions["paymentContext"]
+	if !ok {
+		return nil, fmt.Errorf("402 response has no paymentContext: %w", apiErr)
+	}
+	var payment PaymentContext
+	if err := json.Unmarshal(raw, &payment); err != nil {
+		return nil, fmt.Errorf("decode paymentContext: %w", err)
+	}
+	if payment.Nonce == "" {
+		return nil, errors.New("402 paymentContext has no nonce")
+	}
+	return &payment, nil
+}
+
+func apiErrorFrom(resp *http.Response, body []byte) *APIError {
+	apiErr := parseAPIError(resp.StatusCode, body)
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = retryAfter
+	}
+	return apiErr
+}
+
+func decodeReceiptHeader(encoded string) (*SignedReceipt, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode X-402-Receipt: %w", err)
+	}
+	var receipt SignedReceipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return nil, fmt.Errorf("decode X-402-Receipt: %w", err)
+	}
+	return &receipt, nil
+}
+
+func decodeSettlementHeader(encoded string) (*Settlement, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode X-PAYMENT-RESPONSE: %w", err)
+	}
+	var settlement Settlement
+	if err := json.Unmarshal(data, &settlement); err != nil {
+		return nil, fmt.Errorf("decode X-PAYMENT-RESPONSE: %w", err)
+	}
+	return &settlement, nil
+}
@@ -0,0 +1,407 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var testPayment = PaymentContext{
+	Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+	Token:     "USDC",
+	Amount:    "0.001",
+	Nonce:     "nonce-1",
+	ChainID:   8453,
+}
+
+// signedTestReceipt returns a receipt signed like the gateway signs them.
+func signedTestReceipt(t *testing.T) *SignedReceipt {
+	t.Helper()
+	key, _ := crypto.GenerateKey()
+	receipt := Receipt{
+		ID:        "rcpt_test",
+		Version:   "1.0",
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+		Payment:   PaymentDetails{Payer: "0xabc", Recipient: testPayment.Recipient, Amount: "0.001", Token: "USDC", ChainID: 8453, Nonce: "nonce-1"},
+		Service:   ServiceDetails{Endpoint: "/v1/ai/summarize", RequestHash: "0x01", ResponseHash: "0x02"},
+	}
+	data, _ := json.Marshal(receipt)
+	sig, _ := crypto.Sign(crypto.Keccak256(data), key)
+	return &SignedReceipt{Receipt: receipt, Signature: hexutil.Encode(sig), ServerPublicKey: hexutil.Encode(crypto.FromECDSAPub(&key.PublicKey))}
+}
+
+// fakeGateway answers 402 until it receives a signature that recovers to
+// payer, then returns a summary with a receipt.
+func fakeGateway(t *testing.T, payer string, receipt *SignedReceipt) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature := r.Header.Get("X-402-Signature")
+		if signature == "" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(402)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"type": "urn:microai-paygate:problem:payment_required", "code": "payment_required",
+				"title": "Payment Required", "status": 402, "paymentContext": testPayment,
+			})
+			return
+		}
+
+		sig, _ := hexutil.Decode(signature)
+		digest, _ := PaymentDigest(testPayment)
+		sig[64] -= 27
+		pub, err := crypto.SigToPub(digest, sig)
+		if err != nil || crypto.PubkeyToAddress(*pub).Hex() != payer || r.Header.Get("X-402-Nonce") != testPayment.Nonce {
+			w.Header().Set("X-Request-ID", "req-403")
+			w.WriteHeader(403)
+			w.Write([]byte(`{"type":"urn:microai-paygate:problem:invalid_signature","code":"invalid_signature","title":"Invalid Signature","status":403}`))
+			return
+		}
+
+		var req SummarizeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		receiptJSON, _ := json.Marshal(receipt)
+		w.Header().Set("X-402-Receipt", base64.StdEncoding.EncodeToString(receiptJSON))
+		w.Header().Set("X-Cache", r.Header.Get("X-Cache-Bypass"))
+		w.Header().Set("X-PAYMENT-RESPONSE", base64.StdEncoding.EncodeToString([]byte(`{"success":true,"transaction":"0xtx","network":"base"}`)))
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": "summary of " + req.Text, "receipt": receipt})
+	}))
+}
+
+func TestClient_Summarize_PaysAndRetries(t *testing.T) {
+	signer, _ := NewPrivateKeySigner("380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc")
+	receipt := signedTestReceipt(t)
+	srv := fakeGateway(t, signer.Address(), receipt)
+	defer srv.Close()
+
+	c := New(srv.URL, WithSigner(signer))
+	res, err := c.Summarize(context.Background(), SummarizeRequest{Text: "hello", BypassCache: true})
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if res.Summary != "summary of hello" || res.Cache != "true" {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if res.Receipt == nil || res.Receipt.Receipt.ID != "rcpt_test" {
+		t.Fatalf("Expected the receipt from X-402-Receipt, got %+v", res.Receipt)
+	}
+	if err := res.Receipt.Verify(); err != nil {
+		t.Errorf("Expected receipt to verify: %v", err)
+	}
+	if res.Settlement == nil || res.Settlement.Transaction != "0xtx" {
+		t.Errorf("Expected the settlement from X-PAYMENT-RESPONSE, got %+v", res.Settlement)
+	}
+}
+
+func TestClient_NoSigner(t *testing.T) {
+	srv := fakeGateway(t, "", signedTestReceipt(t))
+	defer srv.Close()
+
+	_, err := New(srv.URL).Summarize(context.Background(), SummarizeRequest{Text: "hello"})
+	if !errors.Is(err, ErrNoSigner) {
+		t.Errorf("Expected ErrNoSigner, got %v", err)
+	}
+}
+
+func TestClient_TypedErrors(t *testing.T) {
+	signer, _ := NewPrivateKeySigner("380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc")
+	srv := fakeGateway(t, "0x0000000000000000000000000000000000000001", signedTestReceipt(t))
+	defer srv.Close()
+
+	_, err := New(srv.URL, WithSigner(signer)).Summarize(context.Background(), SummarizeRequest{Text: "hello"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *APIError, got %v", err)
+	}
+	if apiErr.Status != 403 || apiErr.Code != CodeInvalidSignature || apiErr.RequestID != "req-403" || ErrorCode(err) != CodeInvalidSignature {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+	if apiErr.Retryable() {
+		t.Error("Expected invalid_signature not to be retryable")
+	}
+}
+
+func TestParseAPIError(t *testing.T) {
+	apiErr := parseAPIError(429, []byte(`{"type":"t","code":"rate_limited","title":"Too Many Requests","status":429,"detail":"slow down","retry_after":3}`))
+	if apiErr.Code != CodeRateLimited || apiErr.Detail != "slow down" || string(apiErr.Extensions["retry_after"]) != "3" {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+	if _, ok := apiErr.Extensions["code"]; ok {
+		t.Error("Expected standard members to be removed from Extensions")
+	}
+	if !apiErr.Retryable() || !strings.Contains(apiErr.Error(), "rate_limited") {
+		t.Errorf("Unexpected Retryable/Error: %v", apiErr)
+	}
+
+	// Non-problem bodies still produce an error
+	apiErr = parseAPIError(502, []byte("<html>bad gateway</html>"))
+	if apiErr.Status != 502 || apiErr.Title != "HTTP 502" || apiErr.Code != "" {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+}
+
+func TestClient_GetReceipt(t *testing.T) {
+	receipt := signedTestReceipt(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/receipts/rcpt_test" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"code":"receipt_not_found","title":"Receipt not found","status":404}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"receipt": receipt.Receipt, "signature": receipt.Signature, "server_public_key": receipt.ServerPublicKey, "status": "valid",
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	got, err := c.GetReceipt(context.Background(), "rcpt_test")
+	if err != nil || got.Verify() != nil {
+		t.Fatalf("Expected a verifiable receipt, got %v", err)
+	}
+
+	got.Receipt.Payment.Amount = "100"
+	if got.Verify() == nil {
+		t.Error("Expected a tampered receipt to fail verification")
+	}
+
+	if _, err := c.GetReceipt(context.Background(), "missing"); ErrorCode(err) != CodeReceiptNotFound {
+		t.Errorf("Expected receipt_not_found, got %v", err)
+	}
+}
+
+func TestClient_GetPayment(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/payments/n1.123.abc.def" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"code":"payment_not_found","title":"Payment not found","status":404}`))
+			return
+		}
+		w.Write([]byte(`{"nonce":"n1.123.abc.def","state":"settled","verified":true,"tx_hash":"0xabc","confirmations":3}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	got, err := c.GetPayment(context.Background(), "n1.123.abc.def")
+	if err != nil || got.State != "settled" || got.Confirmations == nil || *got.Confirmations != 3 {
+		t.Fatalf("Expected a settled payment with 3 confirmations, got %+v, %v", got, err)
+	}
+	if _, err := c.GetPayment(context.Background(), "missing"); ErrorCode(err) != CodePaymentNotFound {
+		t.Errorf("Expected payment_not_found, got %v", err)
+	}
+}
+
+func TestVerifyReceipt(t *testing.T) {
+	receipt := signedTestReceipt(t)
+	pubBytes, _ := hexutil.Decode(receipt.ServerPublicKey)
+	pub, _ := crypto.UnmarshalPubkey(pubBytes)
+	address := crypto.PubkeyToAddress(*pub).Hex()
+
+	if err := VerifyReceipt(receipt, receipt.ServerPublicKey); err != nil {
+		t.Errorf("Expected the receipt to verify against its key, got %v", err)
+	}
+	if err := VerifyReceipt(receipt, strings.ToLower(address)); err != nil {
+		t.Errorf("Expected the receipt to verify against its address, got %v", err)
+	}
+
+	other := signedTestReceipt(t)
+	if err := VerifyReceipt(receipt, other.ServerPublicKey); err == nil {
+		t.Error("Expected a receipt from another key to fail verification")
+	}
+
+	receipt.Receipt.Payment.Amount = "100"
+	if err := VerifyReceipt(receipt, address); err == nil {
+		t.Error("Expected a tampered receipt to fail verification")
+	}
+}
+
+func TestClient_VerifyReceipt(t *testing.T) {
+	receipt := signedTestReceipt(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/receipts/rcpt_test/verify" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"code":"receipt_not_found","title":"Receipt not found","status":404}`))
+			return
+		}
+		canonical, _ := json.Marshal(receipt.Receipt)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"valid": true, "canonical": string(canonical), "digest": hexutil.Encode(crypto.Keccak256(canonical)),
+			"receipt": receipt.Receipt, "signature": receipt.Signature, "server_public_key": receipt.ServerPublicKey,
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	got, err := c.VerifyReceipt(context.Background(), "rcpt_test")
+	if err != nil || !got.Valid {
+		t.Fatalf("Expected a valid verification, got %+v %v", got, err)
+	}
+	if err := VerifyReceipt(got.SignedReceipt(), receipt.ServerPublicKey); err != nil {
+		t.Errorf("Expected the returned receipt to verify offline, got %v", err)
+	}
+
+	if _, err := c.VerifyReceipt(context.Background(), "missing"); ErrorCode(err) != CodeReceiptNotFound {
+		t.Errorf("Expected receipt_not_found, got %v", err)
+	}
+}
+
+func TestClient_GetReceiptProof(t *testing.T) {
+	receipt := signedTestReceipt(t)
+	data, _ := json.Marshal(receipt.Receipt)
+	leaf := crypto.Keccak256([]byte{0x00}, crypto.Keccak256(data))
+	sibling := crypto.Keccak256([]byte("other receipt"))
+	root := crypto.Keccak256([]byte{0x01}, leaf, sibling)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/receipts/rcpt_test/proof" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(404)
+			w.Write([]byte(`{"code":"proof_pending","title":"Proof not ready","status":404}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"receipt_id": "rcpt_test", "batch_id": "anch_1", "root": hexutil.Encode(root), "leaf": hexutil.Encode(leaf),
+			"index": 0, "count": 2, "proof": []map[string]string{{"hash": hexutil.Encode(sibling), "position": "right"}},
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	proof, err := c.GetReceiptProof(context.Background(), "rcpt_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proof.Verify(receipt); err != nil {
+		t.Errorf("Expected the proof to verify, got %v", err)
+	}
+	proof.Proof[0].Position = "left"
+	if proof.Verify(receipt) == nil {
+		t.Error("Expected a proof with the wrong order to fail")
+	}
+
+	var apiErr *APIError
+	if _, err := c.GetReceiptProof(context.Background(), "rcpt_new"); !errors.As(err, &apiErr) || apiErr.Code != CodeProofPending || apiErr.RetryAfter != 30 {
+		t.Errorf("Expected proof_pending with Retry-After, got %v", err)
+	}
+}
+
+func TestClient_PaysFromChannel(t *testing.T) {
+	signer, _ := NewPrivateKeySigner("0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	const channelID = "0x5f1d3a0c9b7e2f4a6d8c0b1e3f5a7c9d2e4f6a8b0c1d3e5f7a9b2c4d6e8f0a1b"
+	var amounts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		if r.Header.Get("X-402-Channel") == "" {
+			w.WriteHeader(402)
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": "payment_required", "status": 402, "paymentContext": testPayment})
+			return
+		}
+		amount := r.Header.Get("X-402-Channel-Amount")
+		amounts = append(amounts, amount)
+		message := ChannelBalanceMessage(channelID, amount)
+		sig, _ := hexutil.Decode(r.Header.Get("X-402-Channel-Signature"))
+		sig[64] -= 27
+		pub, err := crypto.SigToPub(crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n"+strconv.Itoa(len(message))+message)), sig)
+		if err != nil || crypto.PubkeyToAddress(*pub).Hex() != signer.Address() {
+			w.WriteHeader(403)
+			return
+		}
+		// The channel already paid 0.002 from elsewhere
+		if amount != "0.003" {
+			w.WriteHeader(402)
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": "channel_underpaid", "status": 402, "spent": "0.002", "required": "0.003"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"result": "a summary"})
+	}))
+	defer srv.Close()
+
+	ch, err := NewChannel(channelID, signer, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := New(srv.URL, WithChannel(ch)).Summarize(context.Background(), SummarizeRequest{Text: "hello"})
+	if err != nil || res.Summary != "a summary" {
+		t.Fatalf("Expected the summary, got %+v, %v", res, err)
+	}
+	if len(amounts) != 2 || amounts[0] != "0.001" || amounts[1] != "0.003" {
+		t.Errorf("Expected 0.001 then, after catching up, 0.003; got %v", amounts)
+	}
+	if ch.Spent() != "0.003" {
+		t.Errorf("Expected 0.003 spent, got %s", ch.Spent())
+	}
+}
+
+func TestClient_PaysFromPrepaid(t *testing.T) {
+	signer, _ := NewPrivateKeySigner("0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	var signatures []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wallet := r.Header.Get("X-402-Prepaid")
+		if wallet == "" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(402)
+			payment := testPayment
+			payment.Nonce = "nonce-" + strconv.Itoa(len(signatures))
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": "payment_required", "status": 402, "paymentContext": payment})
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		message := PrepaidPaymentMessage(wallet, r.Header.Get("X-402-Nonce"), BodyHash(string(body)))
+		sig, _ := hexutil.Decode(r.Header.Get("X-Wallet-Signature"))
+		sig[64] -= 27
+		pub, err := crypto.SigToPub(crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n"+strconv.Itoa(len(message))+message)), sig)
+		if err != nil || !strings.EqualFold(crypto.PubkeyToAddress(*pub).Hex(), wallet) {
+			w.WriteHeader(401)
+			return
+		}
+		signatures = append(signatures, r.Header.Get("X-Wallet-Signature"))
+		json.NewEncoder(w).Encode(map[string]string{"result": "a summary"})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithPrepaid(NewPrepaid(signer.Address(), signer)))
+	for i := 0; i < 2; i++ {
+		if res, err := c.Summarize(context.Background(), SummarizeRequest{Text: "hello"}); err != nil || res.Summary != "a summary" {
+			t.Fatalf("Expected the summary, got %+v, %v", res, err)
+		}
+	}
+	if len(signatures) != 2 || signatures[0] == signatures[1] {
+		t.Errorf("Expected each request signed afresh, got %v", signatures)
+	}
+}
+
+func TestClient_Quote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SummarizeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v1/ai/summarize/quote" || req.Text == "" {
+			w.WriteHeader(422)
+			w.Write([]byte(`{"code":"invalid_text","title":"Invalid Text","status":422}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"price": "0.002", "token": "USDC", "tokens": 412, "model": "openai/gpt-4o-mini", "pricing": "per_1k_tokens",
+			"chains":         []map[string]interface{}{{"chain_id": 8453, "network": "base", "token": "USDC", "recipient": "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"}},
+			"paymentContext": map[string]interface{}{"amount": "0.002", "token": "USDC", "nonce": "n1.x", "chainId": 8453},
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	q, err := c.Quote(context.Background(), SummarizeRequest{Text: "some text"})
+	if err != nil || q.Price != "0.002" || q.Tokens != 412 || len(q.Chains) != 1 || q.PaymentContext.Nonce != "n1.x" {
+		t.Fatalf("Expected the quote, got %+v, %v", q, err)
+	}
+	if _, err := c.Quote(context.Background(), SummarizeRequest{}); ErrorCode(err) != CodeInvalidText {
+		t.Errorf("Expected invalid_text, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Stable error codes returned by the gateway in APIError.Code.
+const (
+	CodePaymentRequired       = "payment_required"
+	CodeInsufficientPayment   = "insufficient_payment"
+	CodePaymentMismatch       = "payment_mismatch"
+	CodeInvalidSignature      = "invalid_signature"
+	CodeInvalidNonce          = "invalid_nonce"
+	CodeInvalidPromoCode      = "invalid_promo_code"
+	CodePaymentHeld           = "payment_held"
+	CodeChannelNotFound       = "channel_not_found"
+	CodeChannelClosed         = "channel_closed"
+	CodeChannelUnderpaid      = "channel_underpaid"
+	CodeChannelExhausted      = "channel_exhausted"
+	CodePrepaidInsufficient   = "prepaid_insufficient"
+	CodePrepaidDisabled       = "prepaid_disabled"
+	CodePriceUnavailable      = "price_unavailable"
+	CodeFacilitatorRejected   = "facilitator_rejected"
+	CodeFacilitatorError      = "facilitator_error"
+	CodeModelNotEntitled      = "model_not_entitled"
+	CodeModelNotAllowed       = "model_not_allowed"
+	CodeUnsupportedLanguage   = "unsupported_language"
+	CodeModelResolutionFailed = "model_resolution_failed"
+	CodeInvalidRequestBody    = "invalid_request_body"
+	CodeInvalidText           = "invalid_text"
+	CodePromptInjection       = "prompt_injection"
+	CodePayloadTooLarge       = "payload_too_large"
+	CodeVerifierUnavailable   = "verifier_unavailable"
+	CodeVerifierTimeout       = "verifier_timeout"
+	CodeVerifierError         = "verifier_error"
+	CodeAITimeout             = "ai_timeout"
+	CodeAIServiceFailed       = "ai_service_failed"
+	CodeAIUnavailable         = "ai_unavailable"
+	CodeOutputFlagged         = "output_flagged"
+	CodeModerationUnavailable = "moderation_unavailable"
+	CodeReceiptFailed         = "receipt_failed"
+	CodeReceiptNotFound       = "receipt_not_found"
+	CodePaymentNotFound       = "payment_not_found"
+	CodeProofPending          = "proof_pending"
+	CodeAnchoringDisabled     = "anchoring_disabled"
+	CodeRateLimited           = "rate_limited"
+	CodeRequestTimeout        = "request_timeout"
+	CodeUnauthorized          = "unauthorized"
+	CodeInvalidWallet         = "invalid_wallet"
+	CodeInvalidWindow         = "invalid_window"
+	CodeLedgerDisabled        = "ledger_disabled"
+	CodeInternalError         = "internal_error"
+)
+
+// ErrNoSigner is returned when a paid endpoint answers 402 and the client
+// was created without a Signer.
+var ErrNoSigner = errors.New("payment required but no signer configured")
+
+// APIError is an RFC 7807 problem returned by the gateway. Extensions holds
+// problem-specific members such as allowed_models or retry_after.
+type APIError struct {
+	Status     int
+	Type       string
+	Code       string
+	Title      string
+	Detail     string
+	RequestID  string
+	RetryAfter int // seconds, from the Retry-After header when present
+	Extensions map[string]json.RawMessage
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("paygate: %d %s", e.Status, e.Title)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += " [request " + e.RequestID + "]"
+	}
+	return msg
+}
+
+// Retryable reports whether the same request may succeed later without
+// changes: rate limiting, an unavailable verifier or moderation provider,
+// and timeouts.
+func (e *APIError) Retryable() bool {
+	switch e.Code {
+	case CodeRateLimited, CodeVerifierUnavailable, CodeModerationUnavailable, CodeAIUnavailable, CodeVerifierTimeout, CodeAITimeout, CodeRequestTimeout:
+		return true
+	}
+	return false
+}
+
+// ErrorCode returns the gateway error code of err, or "" if err is not an
+// *APIError.
+func ErrorCode(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
+
+// parseAPIError decodes a problem+json body. Bodies that aren't problems
+// (e.g. from a proxy in front of the gateway) still yield an APIError with
+// the status and raw body as detail.
+func parseAPIError(status int, body []byte) *APIError {
+	apiErr := &APIError{Status: status}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		apiErr.Title = fmt.Sprintf("HTTP %d", status)
+		apiErr.Detail = string(body)
+		return apiErr
+	}
+
+	str := func(key string) string {
+		var s string
+		json.Unmarshal(fields[key], &s)
+		delete(fields, key)
+		return s
+	}
+	apiErr.Type = str("type")
+	apiErr.Code = str("code")
+	apiErr.Title = str("title")
+	apiErr.Detail = str("detail")
+	apiErr.RequestID = str("request_id")
+	delete(fields, "status")
+	if apiErr.Title == "" {
+		apiErr.Title = fmt.Sprintf("HTTP %d", status)
+	}
+	if len(fields) > 0 {
+		apiErr.Extensions = fields
+	}
+	return apiErr
+}
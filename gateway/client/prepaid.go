@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prepaidSignatureTTL is how long a Prepaid reuses its access signature to
+// read the balance, well within the gateway's PREPAID_AUTH_MAX_AGE_SECONDS
+// default.
+const prepaidSignatureTTL = 10 * time.Minute
+
+// Prepaid pays requests from the wallet's prepaid balance, topped up by
+// transferring the token to the gateway with the deposit memo. Each
+// request is paid by a personal_sign of the gateway's nonce and the body,
+// which needs no typed-data signer. A Prepaid is safe for concurrent use.
+type Prepaid struct {
+	Wallet string
+	signer MessageSigner
+
+	mu        sync.Mutex
+	signature string
+	timestamp int64
+}
+
+// NewPrepaid returns a Prepaid spending wallet's balance, signed with
+// signer.
+func NewPrepaid(wallet string, signer MessageSigner) *Prepaid {
+	return &Prepaid{Wallet: strings.ToLower(wallet), signer: signer}
+}
+
+// WithPrepaid pays for requests from p instead of signing each payment.
+func WithPrepaid(p *Prepaid) Option {
+	return func(c *Client) { c.prepaid = p }
+}
+
+// PrepaidAccessMessage is the message signed to read wallet's prepaid
+// balance at timestamp.
+func PrepaidAccessMessage(wallet string, timestamp int64) string {
+	return fmt.Sprintf("MicroAI-Paygate prepaid access for %s at %d", strings.ToLower(wallet), timestamp)
+}
+
+// PrepaidPaymentMessage is the message signed to pay for one request from
+// wallet's prepaid balance: nonce is the one the gateway issued in its 402
+// answer and bodyHash the BodyHash of the request body.
+func PrepaidPaymentMessage(wallet, nonce, bodyHash string) string {
+	return fmt.Sprintf("MicroAI-Paygate prepaid payment from %s nonce %s body %s", strings.ToLower(wallet), nonce, bodyHash)
+}
+
+// authorize adds the wallet's access signature to header, signing a new
+// one when the last is too old.
+func (p *Prepaid) authorize(ctx context.Context, header http.Header) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.signature == "" || time.Since(time.Unix(p.timestamp, 0)) > prepaidSignatureTTL {
+		now := time.Now().Unix()
+		signature, err := p.signer.SignMessage(ctx, PrepaidAccessMessage(p.Wallet, now))
+		if err != nil {
+			return fmt.Errorf("sign prepaid access: %w", err)
+		}
+		p.signature, p.timestamp = signature, now
+	}
+	header.Set("X-Wallet-Signature", p.signature)
+	header.Set("X-Wallet-Timestamp", strconv.FormatInt(p.timestamp, 10))
+	return nil
+}
+
+// payFromPrepaid sends the request paid from the prepaid balance with the
+// nonce from payment.
+func (c *Client) payFromPrepaid(ctx context.Context, path string, header http.Header, body []byte, payment *PaymentContext) (*http.Response, []byte, error) {
+	signature, err := c.prepaid.signer.SignMessage(ctx, PrepaidPaymentMessage(c.prepaid.Wallet, payment.Nonce, BodyHash(string(body))))
+	if err != nil {
+		return nil, nil, fmt.Errorf("sign prepaid payment: %w", err)
+	}
+	paid := header.Clone()
+	if paid == nil {
+		paid = http.Header{}
+	}
+	paid.Set("X-402-Prepaid", c.prepaid.Wallet)
+	paid.Set("X-402-Nonce", payment.Nonce)
+	paid.Set("X-Wallet-Signature", signature)
+	return c.send(ctx, "POST", path, paid, body)
+}
+
+// PrepaidBalance is a wallet's prepaid balance and how to top it up.
+type PrepaidBalance struct {
+	Wallet         string `json:"wallet"`
+	Balance        string `json:"balance"`
+	Token          string `json:"token"`
+	ChainID        int    `json:"chain_id"`
+	DepositAddress string `json:"deposit_address"`
+	// Memo goes at the end of the transfer's calldata.
+	Memo string `json:"memo"`
+}
+
+// GetPrepaidBalance calls GET /v1/prepaid/{wallet} for the wallet set with
+// WithPrepaid.
+func (c *Client) GetPrepaidBalance(ctx context.Context) (*PrepaidBalance, error) {
+	if c.prepaid == nil {
+		return nil, errors.New("no prepaid wallet configured")
+	}
+	header := http.Header{}
+	if err := c.prepaid.authorize(ctx, header); err != nil {
+		return nil, err
+	}
+	resp, body, err := c.send(ctx, "GET", "/v1/prepaid/"+url.PathEscape(c.prepaid.Wallet), header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiErrorFrom(resp, body)
+	}
+	var balance PrepaidBalance
+	if err := json.Unmarshal(body, &balance); err != nil {
+		return nil, fmt.Errorf("decode prepaid balance: %w", err)
+	}
+	return &balance, nil
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signer signs a payment context, returning the 0x-prefixed 65-byte EIP-712
+// signature the gateway expects in X-402-Signature.
+type Signer interface {
+	SignPayment(ctx context.Context, payment PaymentContext) (string, error)
+}
+
+// MessageSigner signs a text message with personal_sign (EIP-191), as
+// payment channel balance updates are signed.
+type MessageSigner interface {
+	SignMessage(ctx context.Context, message string) (string, error)
+}
+
+// EIP-712 domain shared with the verifier and the web client.
+const (
+	eip712DomainName    = "MicroAI Paygate"
+	eip712DomainVersion = "1"
+)
+
+var (
+	domainTypeHash  = crypto.Keccak256([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
+	paymentTypeHash = crypto.Keccak256([]byte("Payment(address recipient,string token,string amount,string nonce)"))
+	// Payments bound to a request body carry its hash as a fifth field
+	boundPaymentTypeHash = crypto.Keccak256([]byte("Payment(address recipient,string token,string amount,string nonce,bytes32 bodyHash)"))
+)
+
+// BodyHash returns the 0x-prefixed keccak256 of text, the bodyHash a
+// gateway with PAYMENT_BIND_BODY expects when the 402 challenge was
+// requested without the text.
+func BodyHash(text string) string {
+	return hexutil.Encode(crypto.Keccak256([]byte(text)))
+}
+
+// PrivateKeySigner signs payments with a local secp256k1 key.
+type PrivateKeySigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewPrivateKeySigner parses a hex private key, with or without 0x.
+func NewPrivateKeySigner(hexKey string) (*PrivateKeySigner, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return &PrivateKeySigner{key: key}, nil
+}
+
+// Address returns the wallet address payments are signed from.
+func (s *PrivateKeySigner) Address() string {
+	return crypto.PubkeyToAddress(s.key.PublicKey).Hex()
+}
+
+func (s *PrivateKeySigner) SignPayment(_ context.Context, payment PaymentContext) (string, error) {
+	digest, err := PaymentDigest(payment)
+	if err != nil {
+		return "", err
+	}
+	sig, err := crypto.Sign(digest, s.key)
+	if err != nil {
+		return "", err
+	}
+	sig[64] += 27 // Ethereum-style recovery id
+	return hexutil.Encode(sig), nil
+}
+
+func (s *PrivateKeySigner) SignMessage(_ context.Context, message string) (string, error) {
+	digest := crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message)))
+	sig, err := crypto.Sign(digest, s.key)
+	if err != nil {
+		return "", err
+	}
+	sig[64] += 27
+	return hexutil.Encode(sig), nil
+}
+
+// PaymentDigest returns the EIP-712 hash of payment that wallets sign, for
+// Signer implementations backed by a remote key or hardware wallet.
+func PaymentDigest(payment PaymentContext) ([]byte, error) {
+	if !common.IsHexAddress(payment.Recipient) {
+		return nil, fmt.Errorf("invalid recipient address %q", payment.Recipient)
+	}
+	domain := hashStruct(domainTypeHash,
+		hashString(eip712DomainName),
+		hashString(eip712DomainVersion),
+		encodeUint(big.NewInt(int64(payment.ChainID))),
+		encodeAddress(common.Address{}),
+	)
+	fields := [][]byte{
+		encodeAddress(common.HexToAddress(payment.Recipient)),
+		hashString(payment.Token),
+		hashString(payment.Amount),
+		hashString(payment.Nonce),
+	}
+	typeHash := paymentTypeHash
+	if payment.BodyHash != "" {
+		bodyHash, err := hexutil.Decode(payment.BodyHash)
+		if err != nil || len(bodyHash) != 32 {
+			return nil, fmt.Errorf("invalid body hash %q", payment.BodyHash)
+		}
+		typeHash = boundPaymentTypeHash
+		fields = append(fields, bodyHash)
+	}
+	return typedDataDigest(domain, hashStruct(typeHash, fields...)), nil
+}
+
+// typedDataDigest is keccak256("\x19\x01" ‖ domainSeparator ‖ hashStruct(message)).
+func typedDataDigest(domainSeparator, messageHash []byte) []byte {
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator, messageHash)
+}
+
+func hashStruct(typeHash []byte, fields ...[]byte) []byte {
+	return crypto.Keccak256(append([][]byte{typeHash}, fields...)...)
+}
+
+func hashString(s string) []byte {
+	return crypto.Keccak256([]byte(s))
+}
+
+func encodeAddress(addr common.Address) []byte {
+	return common.LeftPadBytes(addr.Bytes(), 32)
+}
+
+func encodeUint(n *big.Int) []byte {
+	return common.LeftPadBytes(n.Bytes(), 32)
+}
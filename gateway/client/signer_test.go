@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestTypedDataDigest_EIP712Example checks the encoding helpers against the
+// "Ether Mail" example from the EIP-712 specification.
+func TestTypedDataDigest_EIP712Example(t *testing.T) {
+	domainType := crypto.Keccak256([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
+	personType := crypto.Keccak256([]byte("Person(string name,address wallet)"))
+	mailType := crypto.Keccak256([]byte("Mail(Person from,Person to,string contents)Person(string name,address wallet)"))
+
+	domain := hashStruct(domainType,
+		hashString("Ether Mail"),
+		hashString("1"),
+		encodeUint(big.NewInt(1)),
+		encodeAddress(common.HexToAddress("0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC")),
+	)
+	from := hashStruct(personType, hashString("Cow"), encodeAddress(common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826")))
+	to := hashStruct(personType, hashString("Bob"), encodeAddress(common.HexToAddress("0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB")))
+	mail := hashStruct(mailType, from, to, hashString("Hello, Bob!"))
+
+	digest := typedDataDigest(domain, mail)
+	if got := hex.EncodeToString(digest); got != "be609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2" {
+		t.Fatalf("Unexpected digest %s", got)
+	}
+
+	key, _ := crypto.ToECDSA(crypto.Keccak256([]byte("cow")))
+	sig, _ := crypto.Sign(digest, key)
+	if r := hex.EncodeToString(sig[:32]); r != "4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d" {
+		t.Errorf("Unexpected r %s", r)
+	}
+	if s := hex.EncodeToString(sig[32:64]); s != "07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b91562" {
+		t.Errorf("Unexpected s %s", s)
+	}
+}
+
+func TestPrivateKeySigner_SignPayment(t *testing.T) {
+	signer, err := NewPrivateKeySigner("0x380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := PaymentContext{
+		Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Token:     "USDC",
+		Amount:    "0.001",
+		Nonce:     "550e8400-e29b-41d4-a716-446655440000",
+		ChainID:   8453,
+	}
+
+	signature, err := signer.SignPayment(context.Background(), payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := hexutil.Decode(signature)
+	if len(sig) != 65 || (sig[64] != 27 && sig[64] != 28) {
+		t.Fatalf("Expected a 65-byte signature with v of 27/28, got %s", signature)
+	}
+
+	digest, _ := PaymentDigest(payment)
+	sig[64] -= 27
+	pub, err := crypto.SigToPub(digest, sig)
+	if err != nil || !strings.EqualFold(crypto.PubkeyToAddress(*pub).Hex(), signer.Address()) {
+		t.Errorf("Signature does not recover to the signer address")
+	}
+
+	// The chain ID is part of the domain, so the same payment on another chain differs
+	other := payment
+	other.ChainID = 84532
+	otherDigest, _ := PaymentDigest(other)
+	if hex.EncodeToString(otherDigest) == hex.EncodeToString(digest) {
+		t.Error("Expected the chain ID to change the digest")
+	}
+}
+
+func TestPaymentDigest_BodyHash(t *testing.T) {
+	payment := PaymentContext{
+		Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219",
+		Token:     "USDC",
+		Amount:    "0.001",
+		Nonce:     "550e8400-e29b-41d4-a716-446655440000",
+		ChainID:   8453,
+	}
+	unbound, _ := PaymentDigest(payment)
+
+	payment.BodyHash = BodyHash("some text")
+	bound, err := PaymentDigest(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment.BodyHash = BodyHash("other text")
+	other, _ := PaymentDigest(payment)
+	if hex.EncodeToString(bound) == hex.EncodeToString(unbound) || hex.EncodeToString(bound) == hex.EncodeToString(other) {
+		t.Error("Expected the body hash to change the digest")
+	}
+
+	payment.BodyHash = "0x1234"
+	if _, err := PaymentDigest(payment); err == nil {
+		t.Error("Expected an error for a body hash that isn't 32 bytes")
+	}
+}
+
+func TestPaymentDigest_InvalidRecipient(t *testing.T) {
+	if _, err := PaymentDigest(PaymentContext{Recipient: "not-an-address"}); err == nil {
+		t.Error("Expected an error for an invalid recipient")
+	}
+	if _, err := NewPrivateKeySigner("zz"); err == nil {
+		t.Error("Expected an error for an invalid key")
+	}
+}
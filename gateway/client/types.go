@@ -0,0 +1,190 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// PaymentContext is the payment the gateway asks the client to sign in a
+// 402 response.
+type PaymentContext struct {
+	Recipient string `json:"recipient"`
+	Token     string `json:"token"`
+	Amount    string `json:"amount"`
+	Nonce     string `json:"nonce"`
+	ChainID   int    `json:"chainId"`
+	// BodyHash is the keccak256 of the request text (see BodyHash), set
+	// when the gateway binds payments to the request body.
+	BodyHash string `json:"bodyHash,omitempty"`
+}
+
+// Settlement is the on-chain settlement of a payment by an x402
+// facilitator, from the X-PAYMENT-RESPONSE header.
+type Settlement struct {
+	Success     bool   `json:"success"`
+	Transaction string `json:"transaction"`
+	Network     string `json:"network"`
+	Payer       string `json:"payer,omitempty"`
+}
+
+// Receipt, PaymentDetails and ServiceDetails mirror the gateway's receipt
+// format. Field order matters: the signature covers their JSON encoding.
+type Receipt struct {
+	ID        string         `json:"id"`
+	Version   string         `json:"version"`
+	Timestamp time.Time      `json:"timestamp"`
+	Payment   PaymentDetails `json:"payment"`
+	Service   ServiceDetails `json:"service"`
+}
+
+type PaymentDetails struct {
+	Payer     string `json:"payer"`
+	Recipient string `json:"recipient"`
+	Amount    string `json:"amount"`
+	Token     string `json:"token"`
+	ChainID   int    `json:"chainId"`
+	Nonce     string `json:"nonce"`
+}
+
+type ServiceDetails struct {
+	Endpoint     string        `json:"endpoint"`
+	RequestHash  string        `json:"request_hash"`
+	ResponseHash string        `json:"response_hash"`
+	Usage        *UsageDetails `json:"usage,omitempty"`
+}
+
+// UsageDetails is the provider-reported usage behind a receipt; nil for
+// cache hits.
+type UsageDetails struct {
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Cost             string `json:"cost,omitempty"`
+}
+
+// SignedReceipt is a receipt with the gateway's signature over it.
+type SignedReceipt struct {
+	Receipt         Receipt `json:"receipt"`
+	Signature       string  `json:"signature"`
+	ServerPublicKey string  `json:"server_public_key"`
+}
+
+// Verify checks that Signature was made by ServerPublicKey over Receipt.
+// Callers should also check ServerPublicKey is the gateway key they trust.
+func (r *SignedReceipt) Verify() error {
+	data, err := json.Marshal(r.Receipt)
+	if err != nil {
+		return err
+	}
+	sig, err := hexutil.Decode(r.Signature)
+	if err != nil || len(sig) != 65 {
+		return errors.New("receipt signature is not 65 hex bytes")
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(data), sig)
+	if err != nil {
+		return fmt.Errorf("recover receipt signer: %w", err)
+	}
+	if got := hexutil.Encode(crypto.FromECDSAPub(pub)); !strings.EqualFold(got, r.ServerPublicKey) {
+		return errors.New("receipt signature does not match server_public_key")
+	}
+	return nil
+}
+
+// VerifyReceipt checks receipt without calling the gateway: its signature
+// must be valid and made by trusted, the gateway's public key (0x-prefixed,
+// uncompressed) or its address.
+func VerifyReceipt(receipt *SignedReceipt, trusted string) error {
+	if receipt == nil {
+		return errors.New("receipt is nil")
+	}
+	if err := receipt.Verify(); err != nil {
+		return err
+	}
+	if strings.EqualFold(receipt.ServerPublicKey, trusted) {
+		return nil
+	}
+	if common.IsHexAddress(trusted) {
+		pubBytes, err := hexutil.Decode(receipt.ServerPublicKey)
+		if err == nil {
+			if pub, err := crypto.UnmarshalPubkey(pubBytes); err == nil && crypto.PubkeyToAddress(*pub) == common.HexToAddress(trusted) {
+				return nil
+			}
+		}
+	}
+	return errors.New("receipt was not signed by the trusted gateway key")
+}
+
+// ReceiptVerification is the gateway's check of a stored receipt, from
+// GET /v1/receipts/{id}/verify.
+type ReceiptVerification struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+	// Canonical is the JSON the signature covers and Digest its keccak256.
+	Canonical       string  `json:"canonical"`
+	Digest          string  `json:"digest"`
+	Receipt         Receipt `json:"receipt"`
+	Signature       string  `json:"signature"`
+	ServerPublicKey string  `json:"server_public_key"`
+	SignerAddress   string  `json:"signer_address,omitempty"`
+}
+
+// SignedReceipt returns the receipt and signature the verification covers.
+func (v *ReceiptVerification) SignedReceipt() *SignedReceipt {
+	return &SignedReceipt{Receipt: v.Receipt, Signature: v.Signature, ServerPublicKey: v.ServerPublicKey}
+}
+
+// ReceiptProof is a Merkle inclusion proof of a receipt in an anchored
+// batch, from GET /v1/receipts/{id}/proof.
+type ReceiptProof struct {
+	ReceiptID  string      `json:"receipt_id"`
+	BatchID    string      `json:"batch_id"`
+	Root       string      `json:"root"`
+	Leaf       string      `json:"leaf"`
+	Index      int         `json:"index"`
+	Count      int         `json:"count"`
+	Proof      []ProofStep `json:"proof"`
+	AnchoredAt time.Time   `json:"anchored_at"`
+}
+
+// ProofStep is a sibling hash and the side it is on (left or right).
+type ProofStep struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position"`
+}
+
+// Verify checks that receipt hashes to a leaf which the proof links to
+// Root. Callers should compare Root with the one published for BatchID.
+func (p *ReceiptProof) Verify(receipt *SignedReceipt) error {
+	data, err := json.Marshal(receipt.Receipt)
+	if err != nil {
+		return err
+	}
+	node := crypto.Keccak256([]byte{0x00}, crypto.Keccak256(data))
+	if hexutil.Encode(node) != strings.ToLower(p.Leaf) {
+		return errors.New("receipt does not match the proof's leaf")
+	}
+	for _, step := range p.Proof {
+		sibling, err := hexutil.Decode(step.Hash)
+		if err != nil {
+			return fmt.Errorf("bad proof hash %q: %w", step.Hash, err)
+		}
+		switch step.Position {
+		case "left":
+			node = crypto.Keccak256([]byte{0x01}, sibling, node)
+		case "right":
+			node = crypto.Keccak256([]byte{0x01}, node, sibling)
+		default:
+			return fmt.Errorf("bad proof position %q", step.Position)
+		}
+	}
+	if hexutil.Encode(node) != strings.ToLower(p.Root) {
+		return errors.New("proof does not lead to the batch root")
+	}
+	return nil
+}
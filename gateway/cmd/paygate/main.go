@@ -0,0 +1,236 @@
+// Command paygate calls MicroAI Paygate endpoints from the command line,
+// paying for them with a local private key:
+//
+//	paygate summarize --file doc.txt --key $PK
+//	echo "some text" | paygate summarize
+//	paygate receipt rcpt_a1b2c3d4e5f6
+//
+// The gateway URL and key default to PAYGATE_URL and PAYGATE_PRIVATE_KEY.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"gateway/client"
+)
+
+const usage = `Usage: paygate <command> [flags]
+
+Commands:
+  summarize   Pay for and print a summary (text from --text, --file or stdin)
+  receipt     Look up a receipt by ID
+
+Run "paygate <command> -h" for the command's flags.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the CLI and returns the process exit code: 0 on success, 1
+// when the request fails and 2 for usage errors.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "summarize":
+		err = runSummarize(ctx, args[1:], stdin, stdout, stderr)
+	case "receipt":
+		err = runReceipt(ctx, args[1:], stdout, stderr)
+	case "-h", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "paygate: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "paygate:") { // *client.APIError already has it
+		msg = "paygate: " + msg
+	}
+	fmt.Fprintln(stderr, msg)
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		return 2
+	}
+	return 1
+}
+
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+// commonFlags are shared by every command.
+type commonFlags struct {
+	url     string
+	timeout time.Duration
+	json    bool
+}
+
+func newFlagSet(name string, stderr io.Writer, common *commonFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("paygate "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&common.url, "url", envOr("PAYGATE_URL", "http://localhost:3000"), "gateway base URL (env PAYGATE_URL)")
+	fs.DurationVar(&common.timeout, "timeout", 60*time.Second, "request timeout")
+	fs.BoolVar(&common.json, "json", false, "print the raw result as JSON")
+	return fs
+}
+
+func runSummarize(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var common commonFlags
+	fs := newFlagSet("summarize", stderr, &common)
+	key := fs.String("key", os.Getenv("PAYGATE_PRIVATE_KEY"), "hex private key used to sign the payment (env PAYGATE_PRIVATE_KEY)")
+	file := fs.String("file", "", "read the text from this file (- for stdin)")
+	text := fs.String("text", "", "text to summarize")
+	model := fs.String("model", "", "model to request (default: the gateway's)")
+	noCache := fs.Bool("no-cache", false, "bypass the gateway's response cache")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	input, err := readInput(*text, *file, fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	if *key == "" {
+		return usageError{"a private key is required (--key or PAYGATE_PRIVATE_KEY)"}
+	}
+	signer, err := client.NewPrivateKeySigner(*key)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, common.timeout)
+	defer cancel()
+	c := client.New(common.url, client.WithSigner(signer))
+	res, err := c.Summarize(ctx, client.SummarizeRequest{Text: input, Model: *model, BypassCache: *noCache})
+	if err != nil {
+		return err
+	}
+
+	if common.json {
+		return printJSON(stdout, map[string]interface{}{
+			"result":     res.Summary,
+			"receipt":    res.Receipt,
+			"stale":      res.Stale,
+			"cache":      res.Cache,
+			"request_id": res.RequestID,
+		})
+	}
+	fmt.Fprintln(stdout, res.Summary)
+	fmt.Fprintln(stdout)
+	printReceipt(stdout, res.Receipt)
+	if res.Cache != "" {
+		fmt.Fprintf(stdout, "Cache:     %s\n", res.Cache)
+	}
+	return nil
+}
+
+func runReceipt(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var common commonFlags
+	fs := newFlagSet("receipt", stderr, &common)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{"usage: paygate receipt [flags] <receipt-id>"}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, common.timeout)
+	defer cancel()
+	receipt, err := client.New(common.url).GetReceipt(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if common.json {
+		return printJSON(stdout, receipt)
+	}
+	printReceipt(stdout, receipt)
+	return nil
+}
+
+// readInput picks the text from --text, --file, a positional argument or
+// stdin, in that order.
+func readInput(text, file string, args []string, stdin io.Reader) (string, error) {
+	switch {
+	case text != "":
+		return text, nil
+	case file == "-":
+		return readAll(stdin)
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case len(args) > 0:
+		return strings.Join(args, " "), nil
+	}
+	if f, ok := stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return "", usageError{"no text given (use --text, --file or pipe it on stdin)"}
+		}
+	}
+	return readAll(stdin)
+}
+
+func readAll(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", usageError{"input text is empty"}
+	}
+	return string(data), nil
+}
+
+func printReceipt(w io.Writer, receipt *client.SignedReceipt) {
+	if receipt == nil {
+		fmt.Fprintln(w, "Receipt:   (none returned)")
+		return
+	}
+	verified := "signature valid"
+	if err := receipt.Verify(); err != nil {
+		verified = "INVALID: " + err.Error()
+	}
+	r := receipt.Receipt
+	fmt.Fprintf(w, "Receipt:   %s (%s)\n", r.ID, verified)
+	fmt.Fprintf(w, "Paid:      %s %s on chain %d\n", r.Payment.Amount, r.Payment.Token, r.Payment.ChainID)
+	fmt.Fprintf(w, "Payer:     %s\n", r.Payment.Payer)
+	fmt.Fprintf(w, "Recipient: %s\n", r.Payment.Recipient)
+	fmt.Fprintf(w, "Issued:    %s\n", r.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(w, "Server:    %s\n", receipt.ServerPublicKey)
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
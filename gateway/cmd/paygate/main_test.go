@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKey = "380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc"
+
+// fakeGateway answers 402 with a payment context until the request carries
+// payment headers, then echoes the text it was sent.
+func fakeGateway(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/receipts/rcpt_missing":
+			w.WriteHeader(404)
+			w.Write([]byte(`{"code":"receipt_not_found","title":"Receipt not found","status":404}`))
+		case r.Header.Get("X-402-Signature") == "":
+			w.WriteHeader(402)
+			w.Write([]byte(`{"code":"payment_required","title":"Payment Required","status":402,"paymentContext":{"recipient":"0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219","token":"USDC","amount":"0.001","nonce":"n1","chainId":8453}}`))
+		default:
+			var req struct{ Text string }
+			json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("X-Cache", "MISS")
+			json.NewEncoder(w).Encode(map[string]string{"result": "summary: " + req.Text})
+		}
+	}))
+}
+
+func runCLI(args []string, stdin string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestSummarize_FromFile(t *testing.T) {
+	srv := fakeGateway(t)
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "doc.txt")
+	os.WriteFile(path, []byte("file contents"), 0o644)
+
+	code, stdout, stderr := runCLI([]string{"summarize", "--url", srv.URL, "--file", path, "--key", testKey}, "")
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "summary: file contents") || !strings.Contains(stdout, "Cache:     MISS") {
+		t.Errorf("Unexpected output:\n%s", stdout)
+	}
+}
+
+func TestSummarize_StdinAndJSON(t *testing.T) {
+	srv := fakeGateway(t)
+	defer srv.Close()
+	t.Setenv("PAYGATE_URL", srv.URL)
+	t.Setenv("PAYGATE_PRIVATE_KEY", "0x"+testKey)
+
+	code, stdout, stderr := runCLI([]string{"summarize", "--json"}, "piped text")
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil || out["result"] != "summary: piped text" {
+		t.Errorf("Unexpected JSON output %q: %v", stdout, err)
+	}
+}
+
+func TestSummarize_UsageErrors(t *testing.T) {
+	t.Setenv("PAYGATE_PRIVATE_KEY", "")
+
+	if code, _, stderr := runCLI([]string{"summarize", "--text", "hi"}, ""); code != 2 || !strings.Contains(stderr, "private key") {
+		t.Errorf("Expected missing key usage error, got %d: %s", code, stderr)
+	}
+	if code, _, _ := runCLI([]string{"summarize", "--key", testKey}, "   "); code != 2 {
+		t.Errorf("Expected empty input usage error, got %d", code)
+	}
+	if code, _, _ := runCLI([]string{"bogus"}, ""); code != 2 {
+		t.Errorf("Expected unknown command usage error, got %d", code)
+	}
+	if code, _, _ := runCLI(nil, ""); code != 2 {
+		t.Errorf("Expected usage error without a command, got %d", code)
+	}
+}
+
+func TestReceipt_NotFound(t *testing.T) {
+	srv := fakeGateway(t)
+	defer srv.Close()
+
+	code, _, stderr := runCLI([]string{"receipt", "--url", srv.URL, "rcpt_missing"}, "")
+	if code != 1 || !strings.Contains(stderr, "receipt_not_found") || strings.Count(stderr, "paygate:") != 1 {
+		t.Errorf("Expected receipt_not_found failure, got %d: %s", code, stderr)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var walletConcurrencyRejectionsTotal = newCounter(
+	"gateway_wallet_concurrency_rejections_total",
+	"Paid requests refused because their wallet already had WALLET_MAX_CONCURRENT requests in flight.",
+)
+
+// getWalletMaxConcurrent returns WALLET_MAX_CONCURRENT, the paid requests
+// one wallet may have in flight on an instance (default 3; 0 disables).
+func getWalletMaxConcurrent() int {
+	return getEnvAsInt("WALLET_MAX_CONCURRENT", 3)
+}
+
+// walletSlots counts the paid requests in flight per wallet.
+type walletSlots struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+var walletConcurrency = &walletSlots{inFlight: make(map[string]int)}
+
+// acquire reserves one of max slots for wallet. It returns the function
+// that frees the slot, or false when all are taken.
+func (s *walletSlots) acquire(wallet string, max int) (func(), bool) {
+	if max <= 0 {
+		return func() {}, true
+	}
+	wallet = strings.ToLower(wallet)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight[wallet] >= max {
+		return nil, false
+	}
+	s.inFlight[wallet]++
+	return sync.OnceFunc(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.inFlight[wallet]--; s.inFlight[wallet] <= 0 {
+			delete(s.inFlight, wallet)
+		}
+	}), true
+}
+
+// abortWalletBusy answers 429 concurrency_limited. The verifier doesn't
+// consume nonces, so the client can retry with the same signature once one
+// of its requests finishes.
+func abortWalletBusy(c *gin.Context, max int) {
+	walletConcurrencyRejectionsTotal.Inc()
+	c.Header("Retry-After", "1")
+	abortWithProblem(c, newProblem(429, codeConcurrencyLimited, "Too Many Requests",
+		"This wallet already has the maximum number of requests in flight; retry when one completes").
+		With("max_concurrent", max).
+		With("retry_after", 1))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func TestWalletSlots(t *testing.T) {
+	s := &walletSlots{inFlight: make(map[string]int)}
+	release, ok := s.acquire("0xAB", 2)
+	if !ok {
+		t.Fatal("Expected the first slot")
+	}
+	if _, ok := s.acquire("0xab", 2); !ok {
+		t.Fatal("Expected the second slot")
+	}
+	if _, ok := s.acquire("0xAb", 2); ok {
+		t.Error("Expected the third request to be refused, wallets are case-insensitive")
+	}
+	if _, ok := s.acquire("0xcd", 2); !ok {
+		t.Error("Expected other wallets to be unaffected")
+	}
+	release()
+	release()
+	if s.inFlight["0xab"] != 1 {
+		t.Errorf("Expected a release to free exactly one slot, got %d", s.inFlight["0xab"])
+	}
+	if _, ok := s.acquire("0xab", 0); !ok {
+		t.Error("Expected 0 to disable the limit")
+	}
+}
+
+func TestHandleSummarize_WalletConcurrencyLimit(t *testing.T) {
+	setupTestReputation(t)
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Delay(500 * time.Millisecond)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("WALLET_MAX_CONCURRENT", "1")
+	r := setupVersionedRouter()
+
+	send := func(text, nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", bytes.NewBufferString(`{"text":"`+text+`"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- send("a slow first request", "n-busy-1") }()
+	deadline := time.Now().Add(2 * time.Second)
+	for ai.Calls() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rejected := walletConcurrencyRejectionsTotal.Value()
+	w := send("a second request", "n-busy-2")
+	p := decodeProblem(t, w)
+	if w.Code != http.StatusTooManyRequests || p["code"] != codeConcurrencyLimited || p["max_concurrent"] != float64(1) {
+		t.Fatalf("Expected 429 concurrency_limited, got %d %v", w.Code, p)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After")
+	}
+	if got := walletConcurrencyRejectionsTotal.Value() - rejected; got != 1 {
+		t.Errorf("Expected 1 rejection counted, got %v", got)
+	}
+
+	if w := <-first; w.Code != http.StatusOK {
+		t.Fatalf("Expected the first request to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	// The same signature goes through once the slot is free
+	if w := send("a second request", "n-busy-2"); w.Code != http.StatusOK {
+		t.Errorf("Expected the retry to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -1,6 +1,20 @@
 package main
 
-import "time"
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
 
 // getPositiveTimeout returns the configured timeout in seconds, but ensures a
 // sensible default if the provided value is non-positive.
@@ -13,9 +27,704 @@ func getPositiveTimeout(envKey string, defaultSeconds int) time.Duration {
 }
 
 // Timeout helpers (configurable via env vars)
-func getRequestTimeout() time.Duration  { return getPositiveTimeout("REQUEST_TIMEOUT_SECONDS", 60) }
-func getAITimeout() time.Duration       { return getPositiveTimeout("AI_REQUEST_TIMEOUT_SECONDS", 30) }
-func getVerifierTimeout() time.Duration { return getPositiveTimeout("VERIFIER_TIMEOUT_SECONDS", 2) }
+func getRequestTimeout() time.Duration {
+	if appConfig != nil {
+		return appConfig.RequestTimeout
+	}
+	return getPositiveTimeout("REQUEST_TIMEOUT_SECONDS", 60)
+}
+
+func getAITimeout() time.Duration {
+	if appConfig != nil {
+		return appConfig.AITimeout
+	}
+	return getPositiveTimeout("AI_REQUEST_TIMEOUT_SECONDS", 30)
+}
+
+func getVerifierTimeout() time.Duration {
+	if appConfig != nil {
+		return appConfig.VerifierTimeout
+	}
+	return getPositiveTimeout("VERIFIER_TIMEOUT_SECONDS", 2)
+}
+
 func getHealthCheckTimeout() time.Duration {
+	if appConfig != nil {
+		return appConfig.HealthCheckTimeout
+	}
 	return getPositiveTimeout("HEALTH_CHECK_TIMEOUT_SECONDS", 2)
 }
+
+// getVerifierURL returns VERIFIER_URL or the local verifier default.
+func getVerifierURL() string {
+	if appConfig != nil {
+		return appConfig.VerifierURL
+	}
+	if v := os.Getenv("VERIFIER_URL"); v != "" {
+		return v
+	}
+	return "http://127.0.0.1:3002"
+}
+
+// getOpenRouterURL returns OPENROUTER_URL or the public OpenRouter endpoint.
+func getOpenRouterURL() string {
+	if appConfig != nil {
+		return appConfig.OpenRouterURL
+	}
+	if v := os.Getenv("OPENROUTER_URL"); v != "" {
+		return v
+	}
+	return "https://openrouter.ai/api/v1/chat/completions"
+}
+
+// Config is the gateway's typed configuration, loaded and validated once at
+// startup by LoadConfig. The get* helpers return values from appConfig once
+// it is set and only fall back to reading the environment before that (e.g.
+// in tests that configure the gateway with t.Setenv).
+type Config struct {
+	Port string
+
+	OpenRouterAPIKey string
+	OpenRouterURL    string
+	OpenRouterModel  string
+	AIProviderChain  []string
+	ModelRoutes      map[string]modelRoute
+	PromptTemplates  *promptTemplateSet
+	VerifierURL      string
+
+	RecipientAddress   string
+	PaymentAmount      string
+	PricePer1KTokens   string
+	ModelPrices        map[string]string
+	SizeBands          []SizeBand
+	SupportedLanguages []string
+	ChainID            int
+
+	RequestTimeout     time.Duration
+	AITimeout          time.Duration
+	VerifierTimeout    time.Duration
+	HealthCheckTimeout time.Duration
+
+	RateLimitEnabled bool
+	RedisURL         string
+	CacheTTL         time.Duration
+	ReceiptTTL       time.Duration
+
+	LegacyAPISunset time.Time
+}
+
+// appConfig is the validated startup configuration; nil until main loads it.
+var appConfig *Config
+
+// ConfigError lists every problem found by LoadConfig so operators can fix
+// them all in one pass.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// printConfigProblems writes title followed by one line per problem in err.
+func printConfigProblems(title string, err error) {
+	fmt.Println(title)
+	var cfgErr *ConfigError
+	if errors.As(err, &cfgErr) {
+		for _, problem := range cfgErr.Problems {
+			fmt.Println("  -", problem)
+		}
+		return
+	}
+	fmt.Println("  -", err.Error())
+}
+
+// intSetting is an integer environment variable validated at startup. Its
+// value is still read by the getter next to the feature that uses it.
+type intSetting struct {
+	key string
+	min int
+}
+
+// validatedIntSettings are checked for typos (non-integers, out-of-range
+// values) so they fail startup instead of silently falling back to defaults.
+var validatedIntSettings = []intSetting{
+	{"RATE_LIMIT_ANONYMOUS_RPM", 1}, {"RATE_LIMIT_ANONYMOUS_BURST", 1},
+	{"RATE_LIMIT_STANDARD_RPM", 1}, {"RATE_LIMIT_STANDARD_BURST", 1},
+	{"RATE_LIMIT_VERIFIED_RPM", 1}, {"RATE_LIMIT_VERIFIED_BURST", 1},
+	{"RATE_LIMIT_CLEANUP_INTERVAL", 1},
+	{"TIER_PROMOTION_PAYMENTS", 0}, {"TIER_DEMOTION_ABUSE_SCORE", 1}, {"TIER_CACHE_TTL_SECONDS", 1},
+	{"REPUTATION_LOW_SCORE", 0}, {"REPUTATION_SURCHARGE_PERCENT", 0}, {"REPUTATION_WINDOW_HOURS", 1},
+	{"POW_SOFT_REMAINING", 0}, {"POW_CHALLENGE_TTL_SECONDS", 1},
+	{"TLS_RELOAD_INTERVAL_SECONDS", 1}, {"HTTP2_MAX_CONCURRENT_STREAMS", 1},
+	{"IP_ACL_RELOAD_INTERVAL_SECONDS", 1}, {"TENANT_RELOAD_INTERVAL_SECONDS", 1}, {"RATE_LIMIT_OVERRIDE_RELOAD_INTERVAL_SECONDS", 1}, {"WALLET_MAX_CONCURRENT", 0}, {"IDEMPOTENCY_TTL_SECONDS", 0}, {"NONCE_TTL_SECONDS", 1},
+	{"HTTP_READ_HEADER_TIMEOUT_SECONDS", 1}, {"HTTP_READ_TIMEOUT_SECONDS", 1}, {"HTTP_IDLE_TIMEOUT_SECONDS", 1},
+	{"HTTP_MAX_HEADER_BYTES", 4096}, {"HTTP_MAX_CONNECTIONS", 0}, {"HTTP_MAX_CONNECTIONS_PER_IP", 0},
+	{"CACHE_MEMORY_MAX_ENTRIES", 0}, {"CACHE_MEMORY_TTL_SECONDS", 1},
+	{"CACHE_STALE_WHILE_REVALIDATE_SECONDS", 0},
+	{"CACHE_COMPRESSION_THRESHOLD_BYTES", 0},
+	{"MICROBATCH_WINDOW_MS", 1}, {"MICROBATCH_MAX_SIZE", 2}, {"MICROBATCH_MAX_TEXT_CHARS", 1},
+	{"SHUTDOWN_TIMEOUT_SECONDS", 1}, {"SECRETS_REFRESH_INTERVAL_SECONDS", 0}, {"WAIT_FOR_DEPS_TIMEOUT_SECONDS", 1},
+	{"VERIFIER_HEALTH_POLL_INTERVAL_SECONDS", 0}, {"VERIFIER_HEALTH_FAILURE_THRESHOLD", 1},
+	{"MAX_TEXT_CHARS", 0}, {"MAX_TEXT_TOKENS", 0},
+	{"CHUNK_MAX_CHARS", 100}, {"CHUNK_CONCURRENCY", 1},
+	{"OLLAMA_NUM_CTX", 0}, {"ANTHROPIC_MAX_TOKENS", 1}, {"MAX_OUTPUT_TOKENS", 1},
+	{"AI_MAX_RETRIES", 0}, {"AI_RETRY_BASE_DELAY_MS", 0}, {"AI_RETRY_MAX_DELAY_MS", 0},
+	{"CIRCUIT_BREAKER_WINDOW", 1}, {"CIRCUIT_BREAKER_MIN_REQUESTS", 1},
+	{"CIRCUIT_BREAKER_SLOW_CALL_MS", 0}, {"CIRCUIT_BREAKER_OPEN_SECONDS", 1},
+	{"USAGE_AUTH_MAX_AGE_SECONDS", 1},
+	{"EVENTS_BUFFER", 1}, {"WEBHOOK_MAX_ATTEMPTS", 1},
+	{"LOG_FILE_MAX_SIZE_MB", 1}, {"LOG_FILE_MAX_AGE_DAYS", 0}, {"LOG_FILE_MAX_BACKUPS", 0}, {"LOG_DEBUG_SAMPLE", 1},
+	{"LOG_SLOW_REQUEST_MS", 0}, {"LOG_LARGE_PAYLOAD_BYTES", 0},
+	{"RECONCILE_INTERVAL_SECONDS", 1}, {"RECONCILE_WINDOW_HOURS", 1}, {"RECONCILE_GRACE_SECONDS", 0},
+	{"RETENTION_REQUEST_HASHES_HOURS", 0}, {"RETENTION_RECEIPTS_HOURS", 0}, {"RETENTION_USAGE_HOURS", 0},
+	{"RETENTION_CACHE_HOURS", 0}, {"RETENTION_PURGE_INTERVAL_SECONDS", 1},
+}
+
+// LoadConfig reads and validates the configuration from the environment.
+// Unlike the individual getters it never substitutes a default for a value
+// that is set but malformed; every such problem is reported in a
+// *ConfigError.
+func LoadConfig() (*Config, error) {
+	l := &configLoader{}
+	cfg := &Config{
+		Port:            l.str("PORT", "3000"),
+		OpenRouterURL:   l.url("OPENROUTER_URL", "https://openrouter.ai/api/v1/chat/completions", "http", "https"),
+		OpenRouterModel: l.str("OPENROUTER_MODEL", defaultOpenRouterModel),
+		VerifierURL:     l.url("VERIFIER_URL", "http://127.0.0.1:3002", "http", "https"),
+
+		RecipientAddress: l.recipient("RECIPIENT_ADDRESS", defaultRecipientAddress),
+		PaymentAmount:    l.amount("PAYMENT_AMOUNT", "0.001"),
+		ChainID:          l.integer("CHAIN_ID", 8453, 1),
+
+		RequestTimeout:     l.seconds("REQUEST_TIMEOUT_SECONDS", 60),
+		AITimeout:          l.seconds("AI_REQUEST_TIMEOUT_SECONDS", 30),
+		VerifierTimeout:    l.seconds("VERIFIER_TIMEOUT_SECONDS", 2),
+		HealthCheckTimeout: l.seconds("HEALTH_CHECK_TIMEOUT_SECONDS", 2),
+
+		RateLimitEnabled: l.boolean("RATE_LIMIT_ENABLED"),
+		RedisURL:         l.url("REDIS_URL", "", "redis", "rediss"),
+		CacheTTL:         l.seconds("CACHE_TTL_SECONDS", 3600),
+		ReceiptTTL:       l.seconds("RECEIPT_TTL", 86400),
+	}
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		l.addf("PORT: %q is not a valid TCP port", cfg.Port)
+	}
+	if raw := l.str("GRPC_PORT", ""); raw != "" {
+		if port, err := strconv.Atoi(raw); err != nil || port < 1 || port > 65535 {
+			l.addf("GRPC_PORT: %q is not a valid TCP port", raw)
+		} else if raw == cfg.Port {
+			l.addf("GRPC_PORT: must differ from PORT")
+		}
+	}
+	l.boolean("PAYMENT_BIND_BODY")
+	l.boolean("NONCE_REQUIRE_ISSUED")
+	l.boolean("WAIT_FOR_DEPS")
+	l.boolean("MULTI_TENANT")
+	l.boolean("POW_CHALLENGE")
+	if l.integer("POW_DIFFICULTY", 18, 1) > 32 {
+		l.addf("POW_DIFFICULTY: must be at most 32")
+	}
+	if secret := l.str("NONCE_SECRET", ""); secret != "" && len(secret) < 32 {
+		l.addf("NONCE_SECRET: must be at least 32 characters")
+	}
+	if l.str("PRICE_PER_1K_TOKENS", "") != "" {
+		cfg.PricePer1KTokens = l.amount("PRICE_PER_1K_TOKENS", "")
+	}
+	if chain, err := parseProviderChain(os.Getenv("AI_PROVIDER_CHAIN")); err != nil {
+		l.addf("AI_PROVIDER_CHAIN: %v (known: %s)", err, strings.Join(slices.Sorted(maps.Keys(aiProviders)), ", "))
+	} else {
+		cfg.AIProviderChain = chain
+	}
+	if routes, err := parseModelRoutes(os.Getenv("MODEL_ROUTES")); err != nil {
+		l.addf("MODEL_ROUTES: %v", err)
+	} else {
+		cfg.ModelRoutes = routes
+	}
+	// Only providers that are actually used need credentials, so e.g. a
+	// self-hosted deployment that only uses Ollama needs no OpenRouter key.
+	used := append([]string{}, cfg.AIProviderChain...)
+	if cfg.AIProviderChain == nil {
+		used = append(used, "openrouter")
+	}
+	for _, route := range cfg.ModelRoutes {
+		used = append(used, route.Provider)
+	}
+	switch mode := strings.ToLower(l.str("INJECTION_DETECTION", "off")); mode {
+	case "off", "flag", "reject":
+	default:
+		l.addf("INJECTION_DETECTION: %q must be off, flag or reject", mode)
+	}
+	if codes, err := parseSupportedLanguages(os.Getenv("SUPPORTED_OUTPUT_LANGUAGES")); err != nil {
+		l.addf("SUPPORTED_OUTPUT_LANGUAGES: %v", err)
+	} else {
+		cfg.SupportedLanguages = codes
+	}
+	if l.str("OUTPUT_LANGUAGE_SURCHARGE", "") != "" {
+		l.amount("OUTPUT_LANGUAGE_SURCHARGE", "")
+	}
+	if raw := l.str("MAX_TEMPERATURE", ""); raw != "" {
+		if v, err := strconv.ParseFloat(raw, 64); err != nil || v < 0 || v > 2 {
+			l.addf("MAX_TEMPERATURE: %q must be a number between 0 and 2", raw)
+		}
+	}
+	if raw := l.str("CIRCUIT_BREAKER_ERROR_RATE", ""); raw != "" {
+		if v, err := strconv.ParseFloat(raw, 64); err != nil || v <= 0 || v > 1 {
+			l.addf("CIRCUIT_BREAKER_ERROR_RATE: %q must be a share above 0 and at most 1", raw)
+		}
+	}
+	if raw := l.str("CACHE_ENCRYPTION_KEYS", ""); raw != "" {
+		if _, err := parseCacheKeyring(raw); err != nil {
+			l.addf("CACHE_ENCRYPTION_KEYS: %v", err)
+		}
+	}
+	switch backend := strings.ToLower(l.str("LEDGER_BACKEND", "")); backend {
+	case "", "off":
+	case "file":
+		l.required("LEDGER_FILE")
+	case "redis":
+		if os.Getenv("REDIS_URL") == "" {
+			l.addf("LEDGER_BACKEND: redis requires REDIS_URL")
+		}
+	case "sql":
+		if os.Getenv("DATABASE_URL") == "" {
+			l.addf("LEDGER_BACKEND: sql requires DATABASE_URL")
+		}
+	default:
+		l.addf("LEDGER_BACKEND: %q must be file, redis or sql", backend)
+	}
+	if databaseURL := l.str("DATABASE_URL", ""); databaseURL != "" {
+		if dialect, _, err := parseDatabaseURL(databaseURL); err != nil {
+			l.addf("DATABASE_URL: %v", err)
+		} else if dialect == dialectSQLite && sqliteDriver == "" {
+			l.addf("DATABASE_URL: SQLite needs a gateway built with cgo; use Postgres")
+		}
+		l.boolean("DATABASE_AUTO_MIGRATE")
+	}
+	switch protocol := strings.ToLower(l.str("VERIFIER_PROTOCOL", "http")); protocol {
+	case "http":
+	case "grpc":
+		l.url("VERIFIER_GRPC_URL", "http://127.0.0.1:50051", "http", "https")
+	default:
+		l.addf("VERIFIER_PROTOCOL: %q must be http or grpc", protocol)
+	}
+	switch level := strings.ToLower(l.str("LOG_LEVEL", "info")); level {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		l.addf("LOG_LEVEL: %q must be debug, info, warn or error", level)
+	}
+	switch format := strings.ToLower(l.str("LOG_FORMAT", "json")); format {
+	case "json", "console":
+	default:
+		l.addf("LOG_FORMAT: %q must be json or console", format)
+	}
+	l.boolean("LOG_CALLER")
+	l.boolean("LOG_FILE_COMPRESS")
+	l.boolean("LOG_REDACT")
+	if stdout := l.str("LOG_STDOUT", ""); stdout != "" && !l.boolean("LOG_STDOUT") && l.str("LOG_FILE", "") == "" {
+		l.addf("LOG_STDOUT: false requires LOG_FILE, or nothing would be logged")
+	}
+	switch backend := strings.ToLower(l.str("AUDIT_BACKEND", "")); backend {
+	case "", "off":
+	case "file":
+		l.required("AUDIT_FILE")
+	case "redis":
+		if os.Getenv("REDIS_URL") == "" {
+			l.addf("AUDIT_BACKEND: redis requires REDIS_URL")
+		}
+	default:
+		l.addf("AUDIT_BACKEND: %q must be file or redis", backend)
+	}
+	switch backend := strings.ToLower(l.str("EVENTS_BACKEND", "")); backend {
+	case "", "off":
+	case "nats":
+		if raw := l.required("NATS_URL"); raw != "" {
+			if _, err := newNATSSink(raw, ""); err != nil {
+				l.addf("%v", err)
+			}
+		}
+	case "kafka":
+		l.required("KAFKA_REST_URL")
+	default:
+		l.addf("EVENTS_BACKEND: %q must be nats or kafka", backend)
+	}
+	for _, raw := range splitList(l.str("WEBHOOK_URLS", "")) {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			l.addf("WEBHOOK_URLS: %q must be an http or https URL", raw)
+		}
+	}
+	if _, err := parseWebhookEvents(l.str("WEBHOOK_EVENTS", "")); err != nil {
+		l.addf("%v", err)
+	}
+	if backend := strings.ToLower(l.str("LEDGER_BACKEND", "")); strings.ToLower(l.str("RECONCILIATION", "")) == "true" && (backend == "" || backend == "off") {
+		l.addf("RECONCILIATION: requires the usage ledger (LEDGER_BACKEND)")
+	}
+	if l.boolean("SETTLEMENT_TRACKING") {
+		if l.str("ETH_RPC_URL", "") == "" {
+			l.addf("SETTLEMENT_TRACKING: requires ETH_RPC_URL, to follow settlement transactions")
+		}
+		if backend := strings.ToLower(l.str("LEDGER_BACKEND", "")); backend == "" || backend == "off" {
+			l.addf("SETTLEMENT_TRACKING: requires the usage ledger (LEDGER_BACKEND), where states are recorded")
+		}
+		l.integer("SETTLEMENT_CONFIRMATIONS", 12, 1)
+		l.integer("SETTLEMENT_DROP_SECONDS", 900, 1)
+		l.integer("SETTLEMENT_MAX_RESUBMITS", 1, 0)
+		l.integer("SETTLEMENT_TRACK_INTERVAL_SECONDS", 30, 1)
+	}
+	// The reconciler and the settlement tracker read back through the
+	// ledger, so a purge mustn't reach into their windows
+	if usageHours, err := strconv.Atoi(l.str("RETENTION_USAGE_HOURS", "")); err == nil && usageHours > 0 {
+		if strings.ToLower(l.str("RECONCILIATION", "")) == "true" {
+			if window, err := strconv.Atoi(l.str("RECONCILE_WINDOW_HOURS", "24")); err == nil && usageHours <= window {
+				l.addf("RETENTION_USAGE_HOURS: must exceed RECONCILE_WINDOW_HOURS (%d), or reconciliation loses served requests", window)
+			}
+		}
+		if l.boolean("SETTLEMENT_TRACKING") && usageHours <= int(trackWindow/time.Hour) {
+			l.addf("RETENTION_USAGE_HOURS: must exceed %d with SETTLEMENT_TRACKING, which follows settlements that long", int(trackWindow/time.Hour))
+		}
+	}
+	switch mode := strings.ToLower(l.str("SETTLEMENT_MODE", settlementImmediate)); mode {
+	case settlementImmediate:
+	case settlementEscrow:
+		if l.str("SETTLEMENT_QUEUE_FILE", "") == "" {
+			l.addf("SETTLEMENT_MODE: escrow requires SETTLEMENT_QUEUE_FILE, where settled payments are handed over")
+		}
+	case settlementFacilitator:
+		if l.url("FACILITATOR_URL", "", "http", "https") == "" {
+			l.addf("SETTLEMENT_MODE: facilitator requires FACILITATOR_URL")
+		}
+		l.integer("FACILITATOR_TIMEOUT_SECONDS", 30, 1)
+	default:
+		l.addf("SETTLEMENT_MODE: %q must be immediate, escrow or facilitator", mode)
+	}
+	l.url("ETH_RPC_URL", "", "http", "https")
+	l.integer("ENS_REFRESH_SECONDS", 3600, 1)
+	l.integer("PAYMENT_TOKEN_DECIMALS", defaultTokenDecimals, 0)
+	if l.boolean("LOAD_PRICING") {
+		l.integer("LOAD_PRICING_TARGET_IN_FLIGHT", 50, 1)
+		l.integer("LOAD_PRICING_TARGET_LATENCY_MS", 5000, 1)
+		l.integer("LOAD_PRICING_QUOTE_SECONDS", 30, 1)
+		if raw := l.str("LOAD_PRICING_MAX_MULTIPLIER", ""); raw != "" {
+			if v, err := strconv.ParseFloat(raw, 64); err != nil || v < 1 {
+				l.addf("LOAD_PRICING_MAX_MULTIPLIER: %q must be a number of at least 1", raw)
+			}
+		}
+	}
+	switch currency := strings.ToLower(l.str("PRICE_CURRENCY", priceCurrencyToken)); currency {
+	case priceCurrencyToken:
+	case priceCurrencyUSD:
+		switch oracle := strings.ToLower(l.str("PRICE_ORACLE", priceOracleChainlink)); oracle {
+		case priceOracleChainlink:
+			if l.str("PRICE_FEED_ADDRESS", "") == "" {
+				l.addf("PRICE_ORACLE: chainlink requires PRICE_FEED_ADDRESS, the TOKEN / USD aggregator")
+			} else {
+				l.address("PRICE_FEED_ADDRESS", "")
+			}
+			if l.url("PRICE_ORACLE_URL", "", "http", "https") == "" && l.str("ETH_RPC_URL", "") == "" {
+				l.addf("PRICE_ORACLE: chainlink requires PRICE_ORACLE_URL or ETH_RPC_URL")
+			}
+		case priceOracleHTTP:
+			if l.url("PRICE_ORACLE_URL", "", "http", "https") == "" {
+				l.addf("PRICE_ORACLE: http requires PRICE_ORACLE_URL")
+			}
+		default:
+			l.addf("PRICE_ORACLE: %q must be chainlink or http", oracle)
+		}
+		l.integer("PRICE_CACHE_SECONDS", 60, 1)
+		l.integer("PRICE_MAX_AGE_SECONDS", 3600, 1)
+	default:
+		l.addf("PRICE_CURRENCY: %q must be token or usd", currency)
+	}
+	if l.str("CHANNEL_DEPOSITS_FILE", "") != "" && l.str("SETTLEMENT_QUEUE_FILE", "") == "" {
+		l.addf("CHANNEL_DEPOSITS_FILE: payment channels require SETTLEMENT_QUEUE_FILE, where closed channels are handed over")
+	}
+	if l.boolean("PREPAID_DEPOSITS") {
+		if l.str("ETH_RPC_URL", "") == "" {
+			l.addf("PREPAID_DEPOSITS: requires ETH_RPC_URL, to watch for deposits")
+		}
+		if _, asset := chainNetwork(cfg.ChainID); asset == "" {
+			l.addf("PREPAID_DEPOSITS: no token contract known for CHAIN_ID (set FACILITATOR_ASSET)")
+		}
+		l.integer("PREPAID_CONFIRMATIONS", 5, 0)
+		l.integer("PREPAID_POLL_SECONDS", 15, 1)
+		l.integer("PREPAID_AUTH_MAX_AGE_SECONDS", 3600, 1)
+		l.integer("PREPAID_START_BLOCK", 0, 0)
+	}
+	switch mode := strings.ToLower(l.str("BROWNOUT_MODE", "off")); mode {
+	case "off", "cached-only":
+	default:
+		l.addf("BROWNOUT_MODE: %q must be off or cached-only", mode)
+	}
+	if templates, err := loadPromptTemplates(); err != nil {
+		l.addf("%v", err)
+	} else {
+		cfg.PromptTemplates = templates
+	}
+	if _, err := newRedactorFromEnv(); err != nil {
+		l.addf("%v", err)
+	}
+	if path := os.Getenv("INJECTION_RULES_FILE"); path != "" {
+		if _, err := loadInjectionRules(path); err != nil {
+			l.addf("%v", err)
+		}
+	}
+	if m, err := newModeratorFromEnv(); err != nil {
+		l.addf("%v", err)
+	} else if m != nil && m.provider == "openai" {
+		used = append(used, "openai")
+	}
+	for _, provider := range slices.Sorted(maps.Keys(providerAPIKeys)) {
+		if !slices.Contains(used, provider) {
+			continue
+		}
+		if v := l.required(providerAPIKeys[provider]); provider == "openrouter" {
+			cfg.OpenRouterAPIKey = v
+		}
+	}
+	if slices.Contains(used, "azure") {
+		if l.required("AZURE_OPENAI_ENDPOINT") != "" {
+			l.url("AZURE_OPENAI_ENDPOINT", "", "https")
+		}
+		if slices.Contains(cfg.AIProviderChain, "azure") {
+			l.required("AZURE_OPENAI_DEPLOYMENT")
+		}
+	}
+	l.url("OLLAMA_URL", "http://localhost:11434", "http", "https")
+	if _, err := parseKeepAlive(os.Getenv("OLLAMA_KEEP_ALIVE")); err != nil {
+		l.addf("OLLAMA_KEEP_ALIVE: %v", err)
+	}
+	if prices, err := parseModelPrices(os.Getenv("ALLOWED_MODELS")); err != nil {
+		l.addf("ALLOWED_MODELS: %v", err)
+	} else {
+		cfg.ModelPrices = prices
+	}
+	if bands, err := parseSizeBands(os.Getenv("PRICE_SIZE_BANDS")); err != nil {
+		l.addf("PRICE_SIZE_BANDS: %v", err)
+	} else {
+		cfg.SizeBands = bands
+	}
+	if sunset, err := parseSunset(os.Getenv("LEGACY_API_SUNSET")); err != nil {
+		l.addf("LEGACY_API_SUNSET: %q is not a date (2006-01-02) or RFC 3339 timestamp", os.Getenv("LEGACY_API_SUNSET"))
+	} else {
+		cfg.LegacyAPISunset = sunset
+	}
+	for _, key := range []string{"LISTEN_ADDRS", "ADMIN_LISTEN_ADDRS"} {
+		if _, err := parseListenAddrs(os.Getenv(key), false); err != nil {
+			l.addf("%s: %v", key, err)
+		}
+	}
+	verifierCA, verifierCert, verifierKey := l.str("VERIFIER_TLS_CA_FILE", ""), l.str("VERIFIER_TLS_CERT_FILE", ""), l.str("VERIFIER_TLS_KEY_FILE", "")
+	if verifierCA != "" {
+		if _, err := loadCertPool(verifierCA); err != nil {
+			l.addf("VERIFIER_TLS_CA_FILE: %v", err)
+		}
+	}
+	if (verifierCert == "") != (verifierKey == "") {
+		l.addf("VERIFIER_TLS_CERT_FILE and VERIFIER_TLS_KEY_FILE must be set together")
+	} else if verifierCert != "" {
+		if _, err := tls.LoadX509KeyPair(verifierCert, verifierKey); err != nil {
+			l.addf("VERIFIER_TLS_CERT_FILE: %v", err)
+		}
+	}
+	if verifierCA != "" || verifierCert != "" {
+		verifierURLs := []string{"VERIFIER_URL"}
+		if strings.ToLower(os.Getenv("VERIFIER_PROTOCOL")) == "grpc" {
+			verifierURLs = append(verifierURLs, "VERIFIER_GRPC_URL")
+		}
+		for _, key := range verifierURLs {
+			if !strings.HasPrefix(os.Getenv(key), "https://") {
+				l.addf("%s: must be an https URL when verifier TLS is configured", key)
+			}
+		}
+	}
+	http2On := l.str("HTTP2_ENABLED", "") == "" || l.boolean("HTTP2_ENABLED")
+	if l.boolean("H2C") && !http2On {
+		l.addf("H2C: requires HTTP2_ENABLED")
+	}
+	if _, err := parseCIDRs(l.str("H2C_TRUSTED_CIDRS", "")); err != nil {
+		l.addf("H2C_TRUSTED_CIDRS: %v", err)
+	}
+	certFile, keyFile := l.str("TLS_CERT_FILE", ""), l.str("TLS_KEY_FILE", "")
+	switch {
+	case (certFile == "") != (keyFile == ""):
+		l.addf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case certFile != "" && l.str("TLS_AUTOCERT_DOMAINS", "") != "":
+		l.addf("TLS_AUTOCERT_DOMAINS: can't be combined with TLS_CERT_FILE")
+	case certFile != "":
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			l.addf("TLS_CERT_FILE: %v", err)
+		}
+	}
+	if l.str("HTTP_WRITE_TIMEOUT_SECONDS", "") != "" {
+		if write := l.seconds("HTTP_WRITE_TIMEOUT_SECONDS", 0); write <= cfg.RequestTimeout {
+			l.addf("HTTP_WRITE_TIMEOUT_SECONDS: must exceed REQUEST_TIMEOUT_SECONDS (%d), or timed-out requests get no response", int(cfg.RequestTimeout.Seconds()))
+		}
+	}
+	for _, key := range []string{"IP_ALLOWLIST", "IP_DENYLIST"} {
+		if _, err := parseIPRanges(splitList(l.str(key, ""))); err != nil {
+			l.addf("%s: %v", key, err)
+		}
+	}
+	if _, err := parseIPRanges(splitList(l.str("TRUSTED_PROXIES", ""))); err != nil {
+		l.addf("TRUSTED_PROXIES: %v", err)
+	}
+	if path := l.str("IP_ACL_FILE", ""); path != "" {
+		if _, err := loadIPACLFile(path); err != nil {
+			l.addf("%v", err)
+		}
+	}
+	for _, prefix := range []string{"", "ADMIN_"} {
+		key := prefix + "CORS_ALLOWED_ORIGINS"
+		raw := l.str(key, "")
+		if prefix == "ADMIN_" && strings.EqualFold(raw, "none") {
+			continue
+		}
+		allowAll, _, err := parseCORSOrigins(raw)
+		if err != nil {
+			l.addf("%s: %v", key, err)
+		}
+		l.boolean(prefix + "CORS_ALLOW_CREDENTIALS")
+		if allowAll && corsAllowCredentials(prefix) {
+			l.addf("%s: \"*\" requires %sCORS_ALLOW_CREDENTIALS=false", key, prefix)
+		}
+	}
+	if raw := l.str("LISTEN_SOCKET_MODE", ""); raw != "" {
+		if mode, err := strconv.ParseUint(raw, 8, 32); err != nil || mode > 0o777 {
+			l.addf("LISTEN_SOCKET_MODE: %q must be octal permissions such as 0660", raw)
+		}
+	}
+	if debugPort := os.Getenv("DEBUG_PORT"); debugPort != "" {
+		if port, err := strconv.Atoi(debugPort); err != nil || port < 1 || port > 65535 {
+			l.addf("DEBUG_PORT: %q is not a valid TCP port", debugPort)
+		} else if debugPort == cfg.Port {
+			l.addf("DEBUG_PORT: must differ from PORT (%s)", cfg.Port)
+		}
+	}
+	if addr := os.Getenv("DEBUG_BIND_ADDR"); addr != "" && addr != "localhost" && net.ParseIP(addr) == nil {
+		l.addf("DEBUG_BIND_ADDR: %q is not an IP address", addr)
+	}
+	for _, s := range validatedIntSettings {
+		l.integer(s.key, s.min, s.min)
+	}
+
+	if len(l.problems) > 0 {
+		return nil, &ConfigError{Problems: l.problems}
+	}
+	return cfg, nil
+}
+
+// configLoader accumulates validation problems while reading settings.
+type configLoader struct {
+	problems []string
+}
+
+func (l *configLoader) addf(format string, args ...interface{}) {
+	l.problems = append(l.problems, fmt.Sprintf(format, args...))
+}
+
+func (l *configLoader) str(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func (l *configLoader) required(key string) string {
+	v := l.str(key, "")
+	if v == "" {
+		l.addf("%s is required", key)
+	}
+	return v
+}
+
+func (l *configLoader) integer(key string, def, min int) int {
+	raw := l.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		l.addf("%s: %q is not an integer", key, raw)
+		return def
+	}
+	if v < min {
+		l.addf("%s: must be at least %d, got %d", key, min, v)
+		return def
+	}
+	return v
+}
+
+func (l *configLoader) seconds(key string, def int) time.Duration {
+	return time.Duration(l.integer(key, def, 1)) * time.Second
+}
+
+func (l *configLoader) boolean(key string) bool {
+	raw := l.str(key, "")
+	if raw == "" {
+		return false
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		l.addf("%s: %q is not a boolean", key, raw)
+	}
+	return v
+}
+
+func (l *configLoader) url(key, def string, schemes ...string) string {
+	raw := l.str(key, def)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		l.addf("%s: %q is not a valid URL: %v", key, raw, err)
+		return raw
+	}
+	if u.Host == "" || !slices.Contains(schemes, u.Scheme) {
+		l.addf("%s: %q must be an absolute %s URL", key, raw, strings.Join(schemes, "/"))
+	}
+	return raw
+}
+
+// address accepts a 0x-prefixed 20-byte hex address. Mixed-case addresses
+// must carry a valid EIP-55 checksum, which catches most copy/paste typos.
+func (l *configLoader) address(key, def string) string {
+	raw := l.str(key, def)
+	if !strings.HasPrefix(raw, "0x") || !common.IsHexAddress(raw) {
+		l.addf("%s: %q is not a 0x-prefixed 20-byte hex address", key, raw)
+		return raw
+	}
+	hexPart := raw[2:]
+	mixedCase := strings.ToLower(hexPart) != hexPart && strings.ToUpper(hexPart) != hexPart
+	if mixedCase && common.HexToAddress(raw).Hex() != raw {
+		l.addf("%s: %q has an invalid EIP-55 checksum", key, raw)
+	}
+	return raw
+}
+
+// recipient accepts an address, or an ENS name when ETH_RPC_URL is set to
+// resolve it with.
+func (l *configLoader) recipient(key, def string) string {
+	raw := l.str(key, def)
+	if !isENSName(raw) {
+		return l.address(key, def)
+	}
+	if l.str("ETH_RPC_URL", "") == "" {
+		l.addf("%s: the ENS name %q requires ETH_RPC_URL", key, raw)
+	}
+	return raw
+}
+
+// amount accepts a positive decimal token amount such as "0.001".
+func (l *configLoader) amount(key, def string) string {
+	raw := l.str(key, def)
+	r, ok := parseDecimal(raw)
+	if !ok {
+		l.addf("%s: %q is not a decimal amount", key, raw)
+		return raw
+	}
+	if r.Sign() <= 0 {
+		l.addf("%s: must be positive, got %s", key, raw)
+	}
+	return raw
+}
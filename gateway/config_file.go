@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// loadConfigFile reads a YAML (.yaml/.yml) or TOML (.toml) settings file and
+// flattens it into environment-variable form, so every existing getter picks
+// the values up unchanged. Nested keys are joined with underscores and
+// upper-cased:
+//
+//	rate_limit:
+//	  standard_rpm: 120     # -> RATE_LIMIT_STANDARD_RPM=120
+//
+// Lists are joined with commas.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	if err := flattenConfig("", raw, values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// flattenConfig walks value, writing scalars into out under their
+// environment-variable name.
+func flattenConfig(prefix string, value interface{}, out map[string]string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if err := flattenConfig(configEnvName(prefix, key), child, out); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				return fmt.Errorf("%s: lists may only contain plain values", prefix)
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+		// An empty key leaves the setting at its default
+	default:
+		if prefix == "" {
+			return fmt.Errorf("top level must be a mapping")
+		}
+		out[prefix] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// configEnvName joins a config path segment onto prefix in env-var form.
+func configEnvName(prefix, key string) string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// applyConfigFile loads path and exports each setting that is not already
+// present in the environment, so environment variables (including .env)
+// always take precedence over the file. It returns the names it applied.
+func applyConfigFile(path string) ([]string, error) {
+	values, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for name, value := range values {
+		if _, set := os.LookupEnv(name); set {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return applied, err
+		}
+		applied = append(applied, name)
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile_YAMLAndTOMLAgree(t *testing.T) {
+	yamlPath := writeConfigFile(t, "gateway.yaml", `
+openrouter:
+  model: z-ai/glm-4.5-air:free
+rate_limit:
+  enabled: true
+  standard_rpm: 120
+cache:
+  key-normalization: [whitespace, nfc]
+chain_id: 8453
+redis_url:
+`)
+	tomlPath := writeConfigFile(t, "gateway.toml", `
+chain_id = 8453
+
+[openrouter]
+model = "z-ai/glm-4.5-air:free"
+
+[rate_limit]
+enabled = true
+standard_rpm = 120
+
+[cache]
+key-normalization = ["whitespace", "nfc"]
+`)
+
+	want := map[string]string{
+		"OPENROUTER_MODEL":        "z-ai/glm-4.5-air:free",
+		"RATE_LIMIT_ENABLED":      "true",
+		"RATE_LIMIT_STANDARD_RPM": "120",
+		"CACHE_KEY_NORMALIZATION": "whitespace,nfc",
+		"CHAIN_ID":                "8453",
+	}
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		got, err := loadConfigFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %d settings, got %v", filepath.Base(path), len(want), got)
+		}
+		for name, value := range want {
+			if got[name] != value {
+				t.Errorf("%s: expected %s=%q, got %q", filepath.Base(path), name, value, got[name])
+			}
+		}
+	}
+}
+
+func TestLoadConfigFile_Errors(t *testing.T) {
+	if _, err := loadConfigFile(writeConfigFile(t, "gateway.json", `{}`)); err == nil {
+		t.Error("Expected error for unsupported extension")
+	}
+	if _, err := loadConfigFile(writeConfigFile(t, "bad.yaml", "a: [b: {c}")); err == nil {
+		t.Error("Expected error for malformed YAML")
+	}
+	if _, err := loadConfigFile(writeConfigFile(t, "nested.yaml", "plans:\n  - name: pro\n")); err == nil {
+		t.Error("Expected error for list of mappings")
+	}
+}
+
+func TestApplyConfigFile_EnvTakesPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "gateway.yaml", "cache:\n  ttl_seconds: 60\nchain_id: 1\n")
+	t.Setenv("CHAIN_ID", "8453")
+	t.Setenv("CACHE_TTL_SECONDS", "")
+	os.Unsetenv("CACHE_TTL_SECONDS")
+
+	applied, err := applyConfigFile(path)
+	if err != nil {
+		t.Fatalf("applyConfigFile failed: %v", err)
+	}
+	if len(applied) != 1 || applied[0] != "CACHE_TTL_SECONDS" {
+		t.Errorf("Expected only CACHE_TTL_SECONDS applied, got %v", applied)
+	}
+	if os.Getenv("CHAIN_ID") != "8453" {
+		t.Errorf("Expected environment CHAIN_ID to win, got %s", os.Getenv("CHAIN_ID"))
+	}
+	if getCacheTTL().Seconds() != 60 {
+		t.Errorf("Expected file TTL to be used, got %v", getCacheTTL())
+	}
+}
+
+func TestLoadConfigFile_Example(t *testing.T) {
+	values, err := loadConfigFile("gateway.example.yaml")
+	if err != nil {
+		t.Fatalf("example config failed to load: %v", err)
+	}
+	if values["RATE_LIMIT_STANDARD_RPM"] != "60" || values["OPENROUTER_MODEL"] == "" {
+		t.Errorf("Unexpected example values: %v", values)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -63,3 +65,80 @@ func TestTimeoutConfigHelpers(t *testing.T) {
 		t.Fatalf("expected request timeout to fall back to 60s on non-positive value, got %v", getRequestTimeout())
 	}
 }
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	for _, key := range []string{"PORT", "CHAIN_ID", "RECIPIENT_ADDRESS", "PAYMENT_AMOUNT", "VERIFIER_URL", "OPENROUTER_URL", "REDIS_URL"} {
+		t.Setenv(key, "")
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("expected defaults to be valid, got: %v", err)
+	}
+	if cfg.Port != "3000" || cfg.ChainID != 8453 || cfg.PaymentAmount != "0.001" {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+	if cfg.RecipientAddress != defaultRecipientAddress || cfg.VerifierURL != "http://127.0.0.1:3002" {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadConfig_ReportsEveryProblem(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "")
+	t.Setenv("PORT", "http")
+	t.Setenv("CHAIN_ID", "base")
+	t.Setenv("RECIPIENT_ADDRESS", "")
+	t.Setenv("PAYMENT_AMOUNT", "-1")
+	t.Setenv("VERIFIER_URL", "localhost:3002")
+	t.Setenv("REDIS_URL", "")
+	t.Setenv("RATE_LIMIT_STANDARD_RPM", "6O")
+
+	_, err := LoadConfig()
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected *ConfigError, got %v", err)
+	}
+
+	for _, key := range []string{"OPENROUTER_API_KEY", "PORT", "CHAIN_ID", "PAYMENT_AMOUNT", "VERIFIER_URL", "RATE_LIMIT_STANDARD_RPM"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected a problem reported for %s, got:\n%v", key, err)
+		}
+	}
+	if len(cfgErr.Problems) != 6 {
+		t.Errorf("expected 6 problems, got %d:\n%v", len(cfgErr.Problems), err)
+	}
+}
+
+func TestConfigLoader_Address(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", false},
+		{"0x2caf48b4ba1c58721a85dfada5ac01c2dfa62219", false},
+		{"0x2CAF48B4BA1C58721A85DFADA5AC01C2DFA62219", false},
+		{"0x2CaF48b4BA1C58721a85dFADa5aC01C2DFa62219", true}, // checksum broken by one case flip
+		{"2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", true},
+		{"0x1234", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_ADDRESS", tt.addr)
+		l := &configLoader{}
+		l.address("TEST_ADDRESS", "")
+		if (len(l.problems) > 0) != tt.wantErr {
+			t.Errorf("address(%s): wantErr=%v, problems=%v", tt.addr, tt.wantErr, l.problems)
+		}
+	}
+}
+
+func TestGetters_UseLoadedConfig(t *testing.T) {
+	prev := appConfig
+	appConfig = &Config{ChainID: 84532, PaymentAmount: "0.5", VerifierURL: "http://verifier:3002", AITimeout: 7 * time.Second}
+	t.Cleanup(func() { appConfig = prev })
+
+	t.Setenv("CHAIN_ID", "1")
+	if getChainID() != 84532 || getPaymentAmount() != "0.5" || getVerifierURL() != "http://verifier:3002" || getAITimeout() != 7*time.Second {
+		t.Error("expected getters to return values from the loaded config")
+	}
+}
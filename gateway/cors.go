@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// corsDefaults are the settings used when a policy's variables are unset.
+// The API policy allows the bundled web app; the admin policy only applies
+// once ADMIN_CORS_ALLOWED_ORIGINS is set.
+var corsDefaults = map[string]struct{ origins, methods, headers string }{
+	"": {
+		origins: "http://localhost:3001",
+		methods: "GET,POST,DELETE,OPTIONS",
+		headers: "Origin,Content-Type,Content-Encoding,X-402-Signature,X-402-Nonce,X-402-Payment,X-402-Channel,X-402-Channel-Amount,X-402-Channel-Signature,X-402-Prepaid,X-Channel-Close-Signature,X-Promo-Code,X-Cache-Bypass,X-Request-ID,X-Wallet-Signature,X-Wallet-Timestamp,X-PoW-Challenge,X-PoW-Solution,Idempotency-Key",
+	},
+	"ADMIN_": {
+		methods: "GET,POST,PUT,DELETE,OPTIONS",
+		headers: "Origin,Content-Type,Authorization,X-Request-ID",
+	},
+}
+
+// corsExposeHeaders are the response headers browsers may read.
+var corsExposeHeaders = []string{"Content-Length", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-402-Receipt", "X-Input-Tokens", "X-AI-Provider", "X-Cache", "X-Cache-Age", "X-Request-ID", "Deprecation", "Sunset", "Link", "Idempotent-Replayed", "X-PAYMENT-RESPONSE", "X-Token-Price-USD", "X-Prepaid-Balance"}
+
+// parseCORSOrigins parses a comma-separated list of allowed origins. Each
+// is an exact origin such as "https://app.example.com", a subdomain pattern
+// such as "https://*.example.com" (any depth of subdomain, but not
+// example.com itself), or "*" alone for any origin.
+func parseCORSOrigins(raw string) (allowAll bool, match func(origin string) bool, err error) {
+	exact := make(map[string]bool)
+	var wildcards [][2]string // scheme prefix and host suffix
+	for _, entry := range splitList(strings.ToLower(raw)) {
+		if entry == "*" {
+			allowAll = true
+			continue
+		}
+		scheme, host, ok := strings.Cut(entry, "://")
+		if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+			return false, nil, fmt.Errorf("%q must be scheme://host[:port]", entry)
+		}
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return false, nil, fmt.Errorf("%q: the wildcard must be followed by a domain", entry)
+			}
+			wildcards = append(wildcards, [2]string{scheme + "://", "." + suffix})
+			continue
+		}
+		if strings.Contains(host, "*") {
+			return false, nil, fmt.Errorf("%q: only a leading \"*.\" wildcard is supported", entry)
+		}
+		exact[entry] = true
+	}
+	if allowAll && (len(exact) > 0 || len(wildcards) > 0) {
+		return false, nil, fmt.Errorf("\"*\" can't be combined with other origins")
+	}
+	match = func(origin string) bool {
+		origin = strings.ToLower(origin)
+		if exact[origin] {
+			return true
+		}
+		for _, w := range wildcards {
+			rest, ok := strings.CutPrefix(origin, w[0])
+			if !ok {
+				continue
+			}
+			sub, ok := strings.CutSuffix(rest, w[1])
+			if ok && sub != "" && strings.Trim(sub, "abcdefghijklmnopqrstuvwxyz0123456789-.") == "" {
+				return true
+			}
+		}
+		return false
+	}
+	return allowAll, match, nil
+}
+
+// corsAllowCredentials reads <prefix>CORS_ALLOW_CREDENTIALS, which defaults
+// to true for the API (the web app sends cookies) and false for admin
+// (which uses a bearer token).
+func corsAllowCredentials(prefix string) bool {
+	v, err := strconv.ParseBool(os.Getenv(prefix + "CORS_ALLOW_CREDENTIALS"))
+	if err != nil {
+		return prefix == ""
+	}
+	return v
+}
+
+// corsSetting reads <prefix>CORS_<name>, falling back to the policy default.
+func corsSetting(prefix, name, def string) string {
+	if v := os.Getenv(prefix + "CORS_" + name); v != "" {
+		return v
+	}
+	return def
+}
+
+// newCORSHandler builds the policy read from the <prefix>CORS_* settings.
+// LoadConfig has already validated them.
+func newCORSHandler(prefix string) gin.HandlerFunc {
+	defaults := corsDefaults[prefix]
+	allowAll, match, _ := parseCORSOrigins(corsSetting(prefix, "ALLOWED_ORIGINS", defaults.origins))
+	cfg := cors.Config{
+		AllowMethods:     splitList(strings.ToUpper(corsSetting(prefix, "ALLOWED_METHODS", defaults.methods))),
+		AllowHeaders:     splitList(corsSetting(prefix, "ALLOWED_HEADERS", defaults.headers)),
+		ExposeHeaders:    corsExposeHeaders,
+		AllowCredentials: corsAllowCredentials(prefix),
+	}
+	if allowAll {
+		cfg.AllowAllOrigins = true
+	} else {
+		cfg.AllowOriginFunc = match
+	}
+	return cors.New(cfg)
+}
+
+// CORSMiddleware applies the CORS policy from CORS_ALLOWED_ORIGINS,
+// CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS and CORS_ALLOW_CREDENTIALS.
+// Setting ADMIN_CORS_ALLOWED_ORIGINS gives /admin its own policy from the
+// ADMIN_CORS_* equivalents; "none" allows no cross-origin admin calls at
+// all. It runs before routing so preflight requests are answered for every
+// route.
+func CORSMiddleware() gin.HandlerFunc {
+	api := newCORSHandler("")
+	admin := api
+	switch strings.ToLower(os.Getenv("ADMIN_CORS_ALLOWED_ORIGINS")) {
+	case "":
+	case "none":
+		admin = func(c *gin.Context) { c.Next() }
+	default:
+		admin = newCORSHandler("ADMIN_")
+	}
+	return func(c *gin.Context) {
+		if path := c.Request.URL.Path; path == "/admin" || strings.HasPrefix(path, "/admin/") {
+			admin(c)
+			return
+		}
+		api(c)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupCORSRouter serves the CORS policy in front of one API and one admin
+// route.
+func setupCORSRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORSMiddleware())
+	r.POST("/v1/ai/summarize", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.DELETE("/admin/cache", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+// preflight sends an OPTIONS request from origin and returns the response.
+func preflight(r http.Handler, path, origin, method string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("OPTIONS", path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseCORSOrigins(t *testing.T) {
+	_, match, err := parseCORSOrigins("http://localhost:3001, https://*.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, want := range map[string]bool{
+		"http://localhost:3001":         true,
+		"https://app.example.com":       true,
+		"https://a.b.EXAMPLE.com":       true,
+		"https://example.com":           false,
+		"http://app.example.com":        false,
+		"https://evilexample.com":       false,
+		"https://app.example.com:8443":  false,
+		"https://evil.com/.example.com": false,
+		"http://localhost:3000":         false,
+	} {
+		if got := match(origin); got != want {
+			t.Errorf("match(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	if allowAll, _, err := parseCORSOrigins("*"); err != nil || !allowAll {
+		t.Errorf("Expected * to allow every origin, got %v %v", allowAll, err)
+	}
+	for _, raw := range []string{"localhost:3001", "https://app.example.com/path", "https://app.*.com", "https://*.", "*,https://example.com"} {
+		if _, _, err := parseCORSOrigins(raw); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestCORSMiddleware_DefaultPolicy(t *testing.T) {
+	r := setupCORSRouter()
+
+	w := preflight(r, "/v1/ai/summarize", "http://localhost:3001", "POST")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3001" {
+		t.Fatalf("Expected the web app to be allowed, got %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" || !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "X-402-Signature") {
+		t.Errorf("Expected credentials and the payment headers to be allowed, got %v", w.Header())
+	}
+	if w := preflight(r, "/v1/ai/summarize", "https://evil.example", "POST"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected other origins to be refused, got %d", w.Code)
+	}
+	// Without an admin policy /admin shares the API one
+	if w := preflight(r, "/admin/cache", "http://localhost:3001", "DELETE"); w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3001" {
+		t.Errorf("Expected /admin to use the API policy, got %v", w.Header())
+	}
+}
+
+func TestCORSMiddleware_ConfiguredPolicies(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://*.example.com")
+	t.Setenv("CORS_ALLOWED_METHODS", "post")
+	t.Setenv("ADMIN_CORS_ALLOWED_ORIGINS", "https://ops.internal")
+	r := setupCORSRouter()
+
+	w := preflight(r, "/v1/ai/summarize", "https://app.example.com", "POST")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || w.Header().Get("Access-Control-Allow-Methods") != "POST" {
+		t.Errorf("Expected the subdomain pattern and methods to apply, got %v", w.Header())
+	}
+	if w := preflight(r, "/admin/cache", "https://app.example.com", "DELETE"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the API origins to be refused on /admin, got %d", w.Code)
+	}
+	w = preflight(r, "/admin/cache", "https://ops.internal", "DELETE")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://ops.internal" || !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Errorf("Expected the admin policy with Authorization, got %v", w.Header())
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Expected no credentials on /admin by default, got %v", w.Header())
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") != "GET,POST,PUT,DELETE,OPTIONS" {
+		t.Errorf("Expected the admin write methods allowed by default, got %v", w.Header())
+	}
+
+	t.Setenv("ADMIN_CORS_ALLOWED_ORIGINS", "none")
+	r = setupCORSRouter()
+	if w := preflight(r, "/admin/cache", "https://app.example.com", "DELETE"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS on /admin, got %v", w.Header())
+	}
+}
+
+func TestLoadConfig_CORSSettings(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+	t.Setenv("ADMIN_CORS_ALLOWED_ORIGINS", "ops.internal")
+	t.Setenv("ADMIN_CORS_ALLOW_CREDENTIALS", "sometimes")
+
+	_, err := LoadConfig()
+	for _, key := range []string{"CORS_ALLOWED_ORIGINS: \"*\" requires", "ADMIN_CORS_ALLOWED_ORIGINS:", "ADMIN_CORS_ALLOW_CREDENTIALS:"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected a %s problem, got %v", key, err)
+		}
+	}
+
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "false")
+	t.Setenv("ADMIN_CORS_ALLOWED_ORIGINS", "none")
+	t.Setenv("ADMIN_CORS_ALLOW_CREDENTIALS", "")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("Expected * without credentials to be valid, got %v", err)
+	}
+}
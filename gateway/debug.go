@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+	expvar.Publish("in_flight_requests", expvar.Func(func() interface{} { return InFlightRequestCount() }))
+}
+
+// debugEndpointsEnabled reports whether DEBUG_ENDPOINTS=true. pprof and
+// expvar expose internals (and CPU profiling is expensive), so they are off
+// by default.
+func debugEndpointsEnabled() bool {
+	return strings.ToLower(os.Getenv("DEBUG_ENDPOINTS")) == "true"
+}
+
+// getDebugBindAddr returns DEBUG_BIND_ADDR, the interface the DEBUG_PORT
+// listener binds (default 127.0.0.1). It has no authentication, so only
+// bind a wider interface where the network keeps it private.
+func getDebugBindAddr() string {
+	if addr := os.Getenv("DEBUG_BIND_ADDR"); addr != "" {
+		return addr
+	}
+	return "127.0.0.1"
+}
+
+// newDebugHandler serves net/http/pprof under /debug/pprof/ and expvar
+// (memstats, goroutine count, in-flight requests) at /debug/vars.
+func newDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// setupDebugEndpoints mounts the debug endpoints when DEBUG_ENDPOINTS=true.
+// With DEBUG_PORT set they get their own unauthenticated listener on
+// DEBUG_BIND_ADDR, loopback unless configured otherwise, and the returned
+// server must be shut down by the caller. Otherwise they are mounted on r
+// behind the admin token.
+func setupDebugEndpoints(r *gin.Engine) *http.Server {
+	if !debugEndpointsEnabled() {
+		return nil
+	}
+
+	port := os.Getenv("DEBUG_PORT")
+	if port == "" {
+		r.Any("/debug/*path", AdminListenerMiddleware(), AdminAuthMiddleware(), gin.WrapH(newDebugHandler()))
+		log.Println("Debug endpoints enabled under /debug (admin token required)")
+		return nil
+	}
+
+	srv := &http.Server{Addr: net.JoinHostPort(getDebugBindAddr(), port), Handler: newDebugHandler()}
+	configureHeaderLimits(srv)
+	go func() {
+		log.Printf("Debug endpoints listening on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("debug server error: %v", err)
+		}
+	}()
+	return srv
+}
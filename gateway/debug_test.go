@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func debugRequest(r *gin.Engine, path, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSetupDebugEndpoints_DisabledByDefault(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("DEBUG_ENDPOINTS", "")
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+
+	r := gin.New()
+	if srv := setupDebugEndpoints(r); srv != nil {
+		t.Fatal("Expected no debug server when disabled")
+	}
+	if w := debugRequest(r, "/debug/vars", "secret"); w.Code != 404 {
+		t.Errorf("Expected 404 when debug endpoints are disabled, got %d", w.Code)
+	}
+}
+
+func TestSetupDebugEndpoints_MainRouterRequiresAdminToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("DEBUG_ENDPOINTS", "true")
+	t.Setenv("DEBUG_PORT", "")
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+
+	r := gin.New()
+	if srv := setupDebugEndpoints(r); srv != nil {
+		t.Fatal("Expected debug endpoints on the main router without DEBUG_PORT")
+	}
+
+	if w := debugRequest(r, "/debug/vars", ""); w.Code != 401 {
+		t.Errorf("Expected 401 without admin token, got %d", w.Code)
+	}
+
+	w := debugRequest(r, "/debug/vars", "secret")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"goroutines"`) || !strings.Contains(w.Body.String(), `"memstats"`) {
+		t.Errorf("Expected expvar output, got %d: %.200s", w.Code, w.Body.String())
+	}
+
+	w = debugRequest(r, "/debug/pprof/goroutine?debug=1", "secret")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Errorf("Expected goroutine profile, got %d: %.200s", w.Code, w.Body.String())
+	}
+}
+
+func TestLoadConfig_DebugPort(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("PORT", "3000")
+
+	for _, tt := range []struct {
+		port    string
+		wantErr bool
+	}{
+		{"", false},
+		{"6060", false},
+		{"pprof", true},
+		{"3000", true},
+	} {
+		t.Setenv("DEBUG_PORT", tt.port)
+		_, err := LoadConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DEBUG_PORT=%q: wantErr=%v, got %v", tt.port, tt.wantErr, err)
+		}
+	}
+}
+
+func TestSetupDebugEndpoints_SeparatePortBindsLoopback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("DEBUG_ENDPOINTS", "true")
+	t.Setenv("DEBUG_PORT", "0")
+	t.Setenv("DEBUG_BIND_ADDR", "")
+
+	srv := setupDebugEndpoints(gin.New())
+	if srv == nil {
+		t.Fatal("Expected a debug server with DEBUG_PORT")
+	}
+	defer srv.Close()
+	if srv.Addr != "127.0.0.1:0" {
+		t.Errorf("Expected the unauthenticated listener on loopback, got %s", srv.Addr)
+	}
+}
+
+func TestLoadConfig_DebugBindAddr(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	for _, tt := range []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"localhost", false},
+		{"10.0.0.5:6060", true},
+	} {
+		t.Setenv("DEBUG_BIND_ADDR", tt.addr)
+		_, err := LoadConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DEBUG_BIND_ADDR=%q: wantErr=%v, got %v", tt.addr, tt.wantErr, err)
+		}
+	}
+}
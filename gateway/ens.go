@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// ensRegistryAddress is the ENS registry, at the same address on mainnet
+// and the testnets.
+const ensRegistryAddress = "0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e"
+
+// Selectors of the two calls a lookup makes: registry.resolver(node) and
+// resolver.addr(node).
+var (
+	ensResolverSelector = crypto.Keccak256([]byte("resolver(bytes32)"))[:4]
+	ensAddrSelector     = crypto.Keccak256([]byte("addr(bytes32)"))[:4]
+)
+
+// ensNamePattern accepts dotted names such as "paygate.eth" or
+// "pay.example.xyz"; anything starting with 0x is taken for an address.
+var ensNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+(\.[\p{L}\p{N}_-]+)+$`)
+
+var ensLookupsTotal = newCounter(
+	"gateway_ens_lookups_total",
+	"ENS name lookups, by outcome (resolved, cached, stale, failed).",
+	"outcome",
+)
+
+// errENSNotFound means the name has no resolver or no address record.
+var errENSNotFound = errors.New("ENS name has no address")
+
+// ensNames resolves ENS names through ETH_RPC_URL. It is nil unless
+// ETH_RPC_URL is set.
+var ensNames *ensResolver
+
+// recipientENS keeps RECIPIENT_ADDRESS resolved when it is an ENS name.
+var recipientENS *ensRecipient
+
+// isENSName reports whether s is an ENS name rather than a hex address.
+func isENSName(s string) bool {
+	return !strings.HasPrefix(s, "0x") && ensNamePattern.MatchString(s)
+}
+
+// ensNamehash computes the EIP-137 namehash of name.
+func ensNamehash(name string) common.Hash {
+	var node common.Hash
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(strings.ToLower(name), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = crypto.Keccak256Hash(node.Bytes(), crypto.Keccak256([]byte(labels[i])))
+	}
+	return node
+}
+
+type ensEntry struct {
+	address    string
+	resolvedAt time.Time
+}
+
+type ensResolver struct {
+	rpcURL     string
+	ttl        time.Duration
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]ensEntry
+}
+
+// initENSResolver enables ENS names when ETH_RPC_URL is set. Resolved
+// addresses are cached for ENS_REFRESH_SECONDS (default 3600).
+func initENSResolver() *ensResolver {
+	rpcURL := os.Getenv("ETH_RPC_URL")
+	if rpcURL == "" {
+		return nil
+	}
+	return newENSResolver(rpcURL, getENSRefreshInterval())
+}
+
+func newENSResolver(rpcURL string, ttl time.Duration) *ensResolver {
+	return &ensResolver{
+		rpcURL:     rpcURL,
+		ttl:        ttl,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		cache:      make(map[string]ensEntry),
+	}
+}
+
+// getENSRefreshInterval returns ENS_REFRESH_SECONDS (default 3600), how
+// long a resolved name is trusted before it is looked up again.
+func getENSRefreshInterval() time.Duration {
+	return time.Duration(getEnvAsInt("ENS_REFRESH_SECONDS", 3600)) * time.Second
+}
+
+// resolve returns the checksummed address name points to, from the cache
+// while it is fresh. When a lookup fails, the last address known for the
+// name is returned with the error logged, so an RPC outage doesn't stop
+// payments.
+func (r *ensResolver) resolve(ctx context.Context, name string) (string, error) {
+	name = strings.ToLower(name)
+	r.mu.Lock()
+	entry, ok := r.cache[name]
+	r.mu.Unlock()
+	if ok && time.Since(entry.resolvedAt) < r.ttl {
+		ensLookupsTotal.Inc("cached")
+		return entry.address, nil
+	}
+	address, err := r.lookup(ctx, name)
+	if err != nil {
+		if ok && !errors.Is(err, errENSNotFound) {
+			ensLookupsTotal.Inc("stale")
+			log.Printf("ENS lookup of %s failed, keeping %s: %v", name, entry.address, err)
+			return entry.address, nil
+		}
+		ensLookupsTotal.Inc("failed")
+		return "", err
+	}
+	ensLookupsTotal.Inc("resolved")
+	r.mu.Lock()
+	r.cache[name] = ensEntry{address: address, resolvedAt: time.Now()}
+	r.mu.Unlock()
+	return address, nil
+}
+
+// lookup asks the registry for the name's resolver, then the resolver for
+// its address.
+func (r *ensResolver) lookup(ctx context.Context, name string) (string, error) {
+	node := ensNamehash(name)
+	out, err := ethCall(ctx, r.httpClient, r.rpcURL, ensRegistryAddress, append(append([]byte(nil), ensResolverSelector...), node.Bytes()...))
+	if err != nil {
+		return "", fmt.Errorf("registry: %w", err)
+	}
+	resolver := common.BytesToAddress(out)
+	if len(out) < 32 || resolver == (common.Address{}) {
+		return "", fmt.Errorf("%w: %s has no resolver", errENSNotFound, name)
+	}
+	out, err = ethCall(ctx, r.httpClient, r.rpcURL, resolver.Hex(), append(append([]byte(nil), ensAddrSelector...), node.Bytes()...))
+	if err != nil {
+		return "", fmt.Errorf("resolver: %w", err)
+	}
+	address := common.BytesToAddress(out)
+	if len(out) < 32 || address == (common.Address{}) {
+		return "", fmt.Errorf("%w: %s has no address record", errENSNotFound, name)
+	}
+	return address.Hex(), nil
+}
+
+// ensRecipient is RECIPIENT_ADDRESS given as an ENS name, with the address
+// it currently points to.
+type ensRecipient struct {
+	name string
+
+	mu      sync.RWMutex
+	address string
+}
+
+// resolveRecipient resolves RECIPIENT_ADDRESS given as an ENS name. The
+// gateway doesn't start without an address to be paid at.
+func resolveRecipient(ctx context.Context, resolver *ensResolver, name string) (*ensRecipient, error) {
+	if resolver == nil {
+		return nil, errors.New("ETH_RPC_URL is required to resolve ENS names")
+	}
+	address, err := resolver.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &ensRecipient{name: strings.ToLower(name), address: address}, nil
+}
+
+func (r *ensRecipient) current() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.address
+}
+
+// start re-resolves the name every ENS refresh interval until ctx is done,
+// so payments follow the name when its address record changes.
+func (r *ensRecipient) start(ctx context.Context, resolver *ensResolver) {
+	go func() {
+		ticker := time.NewTicker(resolver.ttl)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.refresh(ctx, resolver)
+			}
+		}
+	}()
+}
+
+func (r *ensRecipient) refresh(ctx context.Context, resolver *ensResolver) {
+	address, err := resolver.resolve(ctx, r.name)
+	if err != nil {
+		log.Printf("error re-resolving RECIPIENT_ADDRESS %s, keeping %s: %v", r.name, r.current(), err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if address != r.address {
+		log.Printf("RECIPIENT_ADDRESS %s now resolves to %s (was %s)", r.name, address, r.address)
+		r.address = address
+	}
+}
+
+// getRecipientName returns the ENS name payments are addressed to, or ""
+// when RECIPIENT_ADDRESS is a plain address.
+func getRecipientName() string {
+	if recipientENS == nil {
+		return ""
+	}
+	return recipientENS.name
+}
+
+// resolveWalletParam accepts a wallet given as a hex address or, with
+// ETH_RPC_URL set, an ENS name, and returns the lowercase address. It has
+// answered 400 invalid_wallet when it returns false.
+func resolveWalletParam(c *gin.Context, wallet string) (string, bool) {
+	if common.IsHexAddress(wallet) {
+		return strings.ToLower(wallet), true
+	}
+	if !isENSName(wallet) || ensNames == nil {
+		abortWithProblem(c, newProblem(400, codeInvalidWallet, "Invalid Wallet", "Expected a 0x-prefixed 20-byte hex address").
+			With("wallet", wallet))
+		return "", false
+	}
+	address, err := ensNames.resolve(c.Request.Context(), wallet)
+	if err != nil {
+		log.Printf("error resolving %s: %v", wallet, err)
+		abortWithProblem(c, newProblem(400, codeInvalidWallet, "Invalid Wallet", "The ENS name could not be resolved to an address").
+			With("wallet", wallet))
+		return "", false
+	}
+	return strings.ToLower(address), true
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gin-gonic/gin"
+)
+
+const testENSResolver = "0x4976fb03C32e5B8cfe2b6cCB31c09Ba78EBaBa41"
+
+// fakeENSRPC answers eth_call like a node with one ENS name: the registry
+// points it at testENSResolver, which returns address.
+type fakeENSRPC struct {
+	*httptest.Server
+	mu      sync.Mutex
+	name    string
+	address string
+	calls   int
+	down    bool
+}
+
+func newFakeENSRPC(t *testing.T, name, address string) *fakeENSRPC {
+	f := &fakeENSRPC{name: name, address: address}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var call struct{ To, Data string }
+		json.Unmarshal(req.Params[0], &call)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls++
+		if f.down {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		data, _ := hexutil.Decode(call.Data)
+		var result common.Hash
+		if common.BytesToHash(data[4:]) == ensNamehash(f.name) {
+			switch {
+			case strings.EqualFold(call.To, ensRegistryAddress):
+				result = common.BytesToHash(common.HexToAddress(testENSResolver).Bytes())
+			case strings.EqualFold(call.To, testENSResolver):
+				result = common.BytesToHash(common.HexToAddress(f.address).Bytes())
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result.Hex()})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeENSRPC) set(address string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.address, f.down = address, down
+}
+
+func TestENSNamehash(t *testing.T) {
+	tests := map[string]string{
+		"":        "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"eth":     "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae",
+		"foo.eth": "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+	}
+	for name, want := range tests {
+		if got := ensNamehash(name).Hex(); got != want {
+			t.Errorf("namehash(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestIsENSName(t *testing.T) {
+	for s, want := range map[string]bool{
+		"paygate.eth":      true,
+		"pay.example.xyz":  true,
+		"paygate":          false,
+		"0x2cAF48b4BA1C58": false,
+		"0xabc.eth":        false,
+		"bad name.eth":     false,
+	} {
+		if got := isENSName(s); got != want {
+			t.Errorf("isENSName(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestENSResolver_CachesAndKeepsLastAddress(t *testing.T) {
+	rpc := newFakeENSRPC(t, "paygate.eth", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e")
+	r := newENSResolver(rpc.URL, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		address, err := r.resolve(ctx, "PayGate.eth")
+		if err != nil || address != "0x742d35Cc6634C0532925a3b844Bc454e4438f44e" {
+			t.Fatalf("Expected the resolved address, got %q, %v", address, err)
+		}
+	}
+	if rpc.calls != 2 {
+		t.Errorf("Expected one lookup (two calls) for both resolutions, got %d calls", rpc.calls)
+	}
+
+	// Past the TTL a failing RPC keeps the last address
+	r.ttl = 0
+	rpc.set("", true)
+	if address, err := r.resolve(ctx, "paygate.eth"); err != nil || address != "0x742d35Cc6634C0532925a3b844Bc454e4438f44e" {
+		t.Errorf("Expected the last address while the RPC is down, got %q, %v", address, err)
+	}
+
+	if _, err := r.resolve(ctx, "unknown.eth"); err == nil {
+		t.Error("Expected an error for a name without a resolver")
+	}
+}
+
+func TestENSRecipient_FollowsTheName(t *testing.T) {
+	rpc := newFakeENSRPC(t, "paygate.eth", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e")
+	resolver := newENSResolver(rpc.URL, 0)
+	recipient, err := resolveRecipient(context.Background(), resolver, "paygate.eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := recipientENS
+	recipientENS = recipient
+	defer func() { recipientENS = prev }()
+	if getRecipientAddress() != "0x742d35Cc6634C0532925a3b844Bc454e4438f44e" || getRecipientName() != "paygate.eth" {
+		t.Fatalf("Expected the resolved recipient, got %s (%s)", getRecipientAddress(), getRecipientName())
+	}
+
+	rpc.set("0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", false)
+	recipient.refresh(context.Background(), resolver)
+	if getRecipientAddress() != "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219" {
+		t.Errorf("Expected the new address after a refresh, got %s", getRecipientAddress())
+	}
+
+	// The challenge says which name the recipient came from
+	r := setupVersionedRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/payment/challenge", nil)
+	r.ServeHTTP(w, req)
+	var challenge PaymentChallenge
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	if challenge.RecipientName != "paygate.eth" || challenge.PaymentContext.Recipient != "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219" {
+		t.Errorf("Expected the ENS name in the challenge, got %+v", challenge)
+	}
+
+	if _, err := resolveRecipient(context.Background(), nil, "paygate.eth"); err == nil {
+		t.Error("Expected an ENS recipient to require ETH_RPC_URL")
+	}
+}
+
+func TestResolveWalletParam(t *testing.T) {
+	rpc := newFakeENSRPC(t, "alice.eth", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e")
+	prev := ensNames
+	defer func() { ensNames = prev }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:wallet", func(c *gin.Context) {
+		if wallet, ok := resolveWalletParam(c, c.Param("wallet")); ok {
+			c.String(200, wallet)
+		}
+	})
+	get := func(wallet string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+wallet, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	ensNames = nil
+	if w := get("alice.eth"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an ENS name without ETH_RPC_URL, got %d", w.Code)
+	}
+	ensNames = newENSResolver(rpc.URL, time.Hour)
+	if w := get("alice.eth"); w.Code != http.StatusOK || w.Body.String() != "0x742d35cc6634c0532925a3b844bc454e4438f44e" {
+		t.Errorf("Expected the resolved address, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get("bob.eth"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unresolvable name, got %d", w.Code)
+	}
+	if w := get("0x742d35Cc6634C0532925a3b844Bc454e4438f44e"); w.Body.String() != "0x742d35cc6634c0532925a3b844bc454e4438f44e" {
+		t.Errorf("Expected a hex address to pass through, got %s", w.Body.String())
+	}
+}
+
+func TestLoadConfig_ENSRecipient(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("RECIPIENT_ADDRESS", "paygate.eth")
+	t.Setenv("ETH_RPC_URL", "")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "ETH_RPC_URL") {
+		t.Errorf("Expected an ENS recipient to require ETH_RPC_URL, got %v", err)
+	}
+	t.Setenv("ETH_RPC_URL", "https://rpc.example.com")
+	if cfg, err := LoadConfig(); err != nil || cfg.RecipientAddress != "paygate.eth" {
+		t.Errorf("Expected the ENS name accepted, got %v", err)
+	}
+}
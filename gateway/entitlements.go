@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultOpenRouterModel is used when OPENROUTER_MODEL is unset.
+const defaultOpenRouterModel = "z-ai/glm-4.5-air:free"
+
+// getDefaultModel returns OPENROUTER_MODEL or defaultOpenRouterModel.
+func getDefaultModel() string {
+	if appConfig != nil {
+		return appConfig.OpenRouterModel
+	}
+	model := os.Getenv("OPENROUTER_MODEL")
+	if model == "" {
+		return defaultOpenRouterModel
+	}
+	return model
+}
+
+// modelNotEntitledError is returned when a wallet's plan does not include
+// the requested model.
+type modelNotEntitledError struct {
+	Model   string
+	Plan    string
+	Allowed []string
+}
+
+func (e *modelNotEntitledError) Error() string {
+	return fmt.Sprintf("model %q is not included in plan %q", e.Model, e.Plan)
+}
+
+// getPlanModels parses MODEL_ENTITLEMENTS into plan -> allowed models.
+// Format: "free:model-a,model-b;pro:*" where "*" allows any model. An empty
+// result means entitlements are not enforced.
+func getPlanModels() map[string][]string {
+	plans := make(map[string][]string)
+	for _, entry := range strings.Split(os.Getenv("MODEL_ENTITLEMENTS"), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		plan, models, ok := strings.Cut(entry, ":")
+		plan = strings.TrimSpace(plan)
+		if !ok || plan == "" {
+			log.Printf("Warning: Invalid MODEL_ENTITLEMENTS entry '%s', ignoring", entry)
+			continue
+		}
+		for _, m := range strings.Split(models, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				plans[plan] = append(plans[plan], m)
+			}
+		}
+	}
+	return plans
+}
+
+// getWalletPlan returns the plan assigned to wallet via WALLET_PLANS
+// ("0xabc...:pro,0xdef...:pro"), falling back to DEFAULT_PLAN (default
+// "free"). Addresses are compared case-insensitively.
+func getWalletPlan(wallet string) string {
+	for _, entry := range strings.Split(os.Getenv("WALLET_PLANS"), ",") {
+		addr, plan, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(addr), wallet) {
+			return strings.TrimSpace(plan)
+		}
+	}
+	if plan := os.Getenv("DEFAULT_PLAN"); plan != "" {
+		return plan
+	}
+	return "free"
+}
+
+// resolveModel picks the model for a request (the requested one, or the
+// default) and checks that the paying wallet's plan is entitled to it.
+func resolveModel(requested, wallet string) (string, error) {
+	model := requested
+	if model == "" {
+		model = getDefaultModel()
+	}
+
+	plans := getPlanModels()
+	if len(plans) == 0 {
+		return model, nil
+	}
+
+	plan := getWalletPlan(wallet)
+	allowed := plans[plan]
+	for _, m := range allowed {
+		if m == "*" || m == model {
+			return model, nil
+		}
+	}
+
+	sorted := append([]string{}, allowed...)
+	sort.Strings(sorted)
+	return "", &modelNotEntitledError{Model: model, Plan: plan, Allowed: sorted}
+}
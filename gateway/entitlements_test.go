@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResolveModel_NoEntitlementsConfigured(t *testing.T) {
+	t.Setenv("MODEL_ENTITLEMENTS", "")
+	t.Setenv("OPENROUTER_MODEL", "default/model")
+
+	model, err := resolveModel("", "0xabc")
+	if err != nil || model != "default/model" {
+		t.Errorf("Expected default model without error, got %q, %v", model, err)
+	}
+
+	model, err = resolveModel("any/model", "0xabc")
+	if err != nil || model != "any/model" {
+		t.Errorf("Expected requested model without error, got %q, %v", model, err)
+	}
+}
+
+func TestResolveModel_EnforcesPlans(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "free/model")
+	t.Setenv("MODEL_ENTITLEMENTS", "free: free/model, other/free ; pro:*")
+	t.Setenv("WALLET_PLANS", "0xPRO:pro")
+	t.Setenv("DEFAULT_PLAN", "")
+
+	tests := []struct {
+		name      string
+		requested string
+		wallet    string
+		wantModel string
+		wantErr   bool
+	}{
+		{"Free wallet, default model", "", "0xfree", "free/model", false},
+		{"Free wallet, free model", "other/free", "0xfree", "other/free", false},
+		{"Free wallet, premium model", "premium/model", "0xfree", "", true},
+		{"Pro wallet, premium model", "premium/model", "0xpro", "premium/model", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := resolveModel(tt.requested, tt.wallet)
+			if tt.wantErr {
+				var notEntitled *modelNotEntitledError
+				if !errors.As(err, &notEntitled) {
+					t.Fatalf("Expected modelNotEntitledError, got %v", err)
+				}
+				if notEntitled.Plan != "free" {
+					t.Errorf("Expected plan 'free', got %q", notEntitled.Plan)
+				}
+				if strings.Join(notEntitled.Allowed, ",") != "free/model,other/free" {
+					t.Errorf("Unexpected allowed models: %v", notEntitled.Allowed)
+				}
+				return
+			}
+			if err != nil || model != tt.wantModel {
+				t.Errorf("Expected %q without error, got %q, %v", tt.wantModel, model, err)
+			}
+		})
+	}
+}
+
+func TestGetWalletPlan_DefaultPlan(t *testing.T) {
+	t.Setenv("WALLET_PLANS", "")
+	t.Setenv("DEFAULT_PLAN", "")
+	if plan := getWalletPlan("0xabc"); plan != "free" {
+		t.Errorf("Expected default plan 'free', got %q", plan)
+	}
+
+	t.Setenv("DEFAULT_PLAN", "trial")
+	if plan := getWalletPlan("0xabc"); plan != "trial" {
+		t.Errorf("Expected DEFAULT_PLAN 'trial', got %q", plan)
+	}
+}
+
+func TestHandleSummarize_UnentitledModelReturns403(t *testing.T) {
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"is_valid":true,"recovered_address":"0xabc","error":""}`))
+	}))
+	defer verifier.Close()
+
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("MODEL_ENTITLEMENTS", "free:free/model")
+	t.Setenv("WALLET_PLANS", "")
+	t.Setenv("DEFAULT_PLAN", "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/ai/summarize", handleSummarize)
+
+	req, _ := http.NewRequest("POST", "/api/ai/summarize", strings.NewReader(`{"text":"hi","model":"premium/model"}`))
+	req.Header.Set("X-402-Signature", "sig")
+	req.Header.Set("X-402-Nonce", "nonce")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 403 {
+		t.Fatalf("Expected 403, got %d; body=%s", w.Code, w.Body.String())
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response JSON: %v", err)
+	}
+	if response["code"] != "model_not_entitled" {
+		t.Errorf("Expected code 'model_not_entitled', got %v", response["code"])
+	}
+	allowed, ok := response["allowed_models"].([]interface{})
+	if !ok || len(allowed) != 1 || allowed[0] != "free/model" {
+		t.Errorf("Expected allowed_models [free/model], got %v", response["allowed_models"])
+	}
+}
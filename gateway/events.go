@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// eventBus publishes payment and request events for downstream fraud and
+// analytics pipelines. It is nil unless EVENTS_BACKEND is set.
+var eventBus *eventPublisher
+
+var eventsPublishedTotal = newCounter(
+	"gateway_events_published_total",
+	"Events sent to the event stream, by type and outcome (success, error, dropped).",
+	"type", "outcome",
+)
+
+// Event types.
+const (
+	eventPaymentVerified     = "payment_verified"
+	eventPaymentRejected     = "payment_rejected"
+	eventResponseServed      = "response_served"
+	eventCacheHit            = "cache_hit"
+	eventSettlementCompleted = "settlement_completed"
+	eventSettlementFinalized = "settlement_finalized"
+	eventSettlementFailed    = "settlement_failed"
+	eventRefundIssued        = "refund_issued"
+	eventPrepaidCredited     = "prepaid_credited"
+)
+
+// Event is one entry in the event stream. Fields that don't apply to the
+// type are omitted.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Nonce     string    `json:"nonce,omitempty"`
+	Wallet    string    `json:"wallet,omitempty"`
+	Amount    string    `json:"amount,omitempty"`
+	Token     string    `json:"token,omitempty"`
+	ChainID   int       `json:"chain_id,omitempty"`
+	Route     string    `json:"route,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	ReceiptID string    `json:"receipt_id,omitempty"`
+	TxHash    string    `json:"tx_hash,omitempty"`
+	Reason    string    `json:"reason,omitempty" doc:"Why a payment was rejected, or its settlement failed"`
+	LatencyMS int64     `json:"latency_ms,omitempty"`
+}
+
+// eventSink delivers one encoded event. key groups related events (the
+// wallet) where the backend supports it.
+type eventSink interface {
+	Send(ctx context.Context, e Event, key string, payload []byte) error
+	Close() error
+}
+
+// eventPublisher queues events and sends them from a background worker, so
+// a slow or unreachable broker never delays a response. When the queue is
+// full, events are dropped and counted.
+type eventPublisher struct {
+	sink  eventSink
+	queue chan Event
+	done  chan struct{}
+}
+
+// initEventBus connects the sink selected by EVENTS_BACKEND: "nats" publishes
+// to NATS_URL on the subject EVENTS_TOPIC.<type>, "kafka" posts to the
+// EVENTS_TOPIC topic through the Kafka REST proxy at KAFKA_REST_URL.
+// EVENTS_TOPIC defaults to "paygate.events" and EVENTS_BUFFER (default
+// 1000) bounds the queue. LoadConfig has already validated the settings.
+func initEventBus() *eventPublisher {
+	topic := os.Getenv("EVENTS_TOPIC")
+	if topic == "" {
+		topic = "paygate.events"
+	}
+	var sink eventSink
+	switch backend := strings.ToLower(os.Getenv("EVENTS_BACKEND")); backend {
+	case "nats":
+		s, err := newNATSSink(os.Getenv("NATS_URL"), topic)
+		if err != nil {
+			log.Printf("Warning: Event stream disabled: %v", err)
+			return nil
+		}
+		sink = s
+	case "kafka":
+		sink = &kafkaRESTSink{baseURL: strings.TrimRight(os.Getenv("KAFKA_REST_URL"), "/"), topic: topic}
+	default:
+		return nil
+	}
+	log.Printf("Publishing events to %s topic %s", os.Getenv("EVENTS_BACKEND"), topic)
+	return newEventPublisher(sink, getEnvAsInt("EVENTS_BUFFER", 1000))
+}
+
+func newEventPublisher(sink eventSink, buffer int) *eventPublisher {
+	p := &eventPublisher{sink: sink, queue: make(chan Event, max(buffer, 1)), done: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+func (p *eventPublisher) run() {
+	defer close(p.done)
+	for e := range p.queue {
+		payload, _ := json.Marshal(e)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := p.sink.Send(ctx, e, e.Wallet, payload)
+		cancel()
+		if err != nil {
+			eventsPublishedTotal.Inc(e.Type, "error")
+			log.Printf("error publishing %s event %s: %v", e.Type, e.ID, err)
+			continue
+		}
+		eventsPublishedTotal.Inc(e.Type, "success")
+	}
+}
+
+// publish queues e, stamping its ID and time.
+func (p *eventPublisher) publish(e Event) {
+	e.ID = uuid.New().String()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	select {
+	case p.queue <- e:
+	default:
+		eventsPublishedTotal.Inc(e.Type, "dropped")
+	}
+}
+
+// close stops accepting events and waits until the queue is drained or ctx
+// is done.
+func (p *eventPublisher) close(ctx context.Context) {
+	close(p.queue)
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		log.Printf("Shutdown deadline reached with %d events unpublished", len(p.queue))
+	}
+	p.sink.Close()
+}
+
+// emitEvent publishes e if the event stream is enabled.
+func emitEvent(e Event) {
+	if eventBus != nil {
+		eventBus.publish(e)
+	}
+}
+
+// kafkaRESTSink produces to Kafka through a REST proxy (Confluent REST Proxy
+// v2 API, also served by Redpanda), which keeps the Kafka wire protocol out
+// of the gateway.
+type kafkaRESTSink struct {
+	baseURL string
+	topic   string
+}
+
+func (s *kafkaRESTSink) Send(ctx context.Context, _ Event, key string, payload []byte) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": key, "value": json.RawMessage(payload)}},
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/topics/"+url.PathEscape(s.topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newProviderStatusError("kafka-rest", resp)
+	}
+	return nil
+}
+
+func (s *kafkaRESTSink) Close() error { return nil }
+
+// natsSink publishes with the NATS core text protocol (CONNECT, PUB,
+// PING/PONG) over a single connection, redialled on failure. TLS is not
+// supported.
+type natsSink struct {
+	addr    string
+	connect []byte // CONNECT line with the URL's credentials
+	subject string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newNATSSink parses a nats://[user:pass@|token@]host:port URL.
+func newNATSSink(rawURL, subject string) (*natsSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("NATS_URL %q must be nats://host:port", rawURL)
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "microai-paygate"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &natsSink{addr: addr, connect: append(append([]byte("CONNECT "), connect...), "\r\n"...), subject: subject}, nil
+}
+
+func (s *natsSink) Send(ctx context.Context, e Event, _ string, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	msg := fmt.Appendf(nil, "PUB %s.%s %d\r\n", s.subject, e.Type, len(payload))
+	msg = append(append(msg, payload...), "\r\n"...)
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	if _, err := s.conn.Write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects and authenticates, waiting for the PONG that confirms the
+// server accepted CONNECT. Called with s.mu held.
+func (s *natsSink) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", strings.TrimSpace(line), err)
+	}
+	if _, err := conn.Write(append(s.connect, "PING\r\n"...)); err != nil {
+		conn.Close()
+		return err
+	}
+	if line, err := r.ReadString('\n'); err != nil || strings.TrimSpace(line) != "PONG" {
+		conn.Close()
+		return fmt.Errorf("NATS rejected the connection: %q: %v", strings.TrimSpace(line), err)
+	}
+	conn.SetDeadline(time.Time{})
+	s.conn = conn
+	go s.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers the server's keepalive PINGs and drops the connection on
+// errors, so the next Send redials.
+func (s *natsSink) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err == nil && strings.HasPrefix(line, "-ERR") {
+			err = fmt.Errorf("server error: %s", strings.TrimSpace(line))
+		}
+		if err != nil {
+			s.mu.Lock()
+			if s.conn == conn {
+				log.Printf("NATS connection closed: %v", err)
+				s.conn = nil
+			}
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		if strings.TrimSpace(line) == "PING" {
+			s.mu.Lock()
+			conn.Write([]byte("PONG\r\n"))
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// recordingSink is an eventSink that keeps what it was sent.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordingSink) Send(_ context.Context, e Event, _ string, _ []byte) error {
+	s.mu.Lock()
+	s.events = append(s.events, e)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func (s *recordingSink) types() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var types []string
+	for _, e := range s.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// setupTestEventBus installs an event bus that records into the returned
+// sink. Call flush before checking it.
+func setupTestEventBus(t *testing.T) (sink *recordingSink, flush func()) {
+	t.Helper()
+	sink = &recordingSink{}
+	bus := newEventPublisher(sink, 100)
+	prev := eventBus
+	eventBus = bus
+	closed := false
+	flush = func() {
+		if !closed {
+			closed = true
+			bus.close(context.Background())
+		}
+	}
+	t.Cleanup(func() {
+		flush()
+		eventBus = prev
+	})
+	return sink, flush
+}
+
+func TestEventPublisher_DropsWhenFull(t *testing.T) {
+	block := make(chan struct{})
+	sink := &blockingSink{release: block}
+	p := newEventPublisher(sink, 1)
+	before := eventsPublishedTotal.Value(eventCacheHit, "dropped")
+	for i := 0; i < 5; i++ {
+		p.publish(Event{Type: eventCacheHit})
+	}
+	close(block)
+	p.close(context.Background())
+	if got := eventsPublishedTotal.Value(eventCacheHit, "dropped") - before; got < 3 {
+		t.Errorf("Expected events beyond the queue to be dropped, %v were", got)
+	}
+}
+
+type blockingSink struct{ release chan struct{} }
+
+func (s *blockingSink) Send(context.Context, Event, string, []byte) error {
+	<-s.release
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestKafkaRESTSink(t *testing.T) {
+	var body map[string][]map[string]json.RawMessage
+	var path, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer srv.Close()
+
+	sink := &kafkaRESTSink{baseURL: srv.URL, topic: "paygate.events"}
+	payload, _ := json.Marshal(Event{Type: eventPaymentVerified, Nonce: "n-1"})
+	if err := sink.Send(context.Background(), Event{}, "0xaa", payload); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/topics/paygate.events" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("Unexpected request to %s with %s", path, contentType)
+	}
+	var e Event
+	if len(body["records"]) != 1 || string(body["records"][0]["key"]) != `"0xaa"` || json.Unmarshal(body["records"][0]["value"], &e) != nil || e.Nonce != "n-1" {
+		t.Errorf("Unexpected records %s", body["records"])
+	}
+}
+
+// fakeNATS accepts one connection, checks CONNECT and returns the PUB
+// messages it receives.
+func fakeNATS(t *testing.T) (url string, pubs chan [2]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	pubs = make(chan [2]string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch fields := strings.Fields(line); {
+			case len(fields) == 0:
+			case fields[0] == "CONNECT":
+				if !strings.Contains(line, `"auth_token":"s3cret"`) {
+					conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+					return
+				}
+			case fields[0] == "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case fields[0] == "PUB" && len(fields) == 3:
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				io.ReadFull(r, payload)
+				pubs <- [2]string{fields[1], string(payload[:n])}
+			}
+		}
+	}()
+	return "nats://s3cret@" + ln.Addr().String(), pubs
+}
+
+func TestNATSSink(t *testing.T) {
+	url, pubs := fakeNATS(t)
+	sink, err := newNATSSink(url, "paygate.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := sink.Send(ctx, Event{Type: eventPaymentRejected}, "", []byte(`{"type":"payment_rejected"}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case pub := <-pubs:
+		if pub[0] != "paygate.events.payment_rejected" || pub[1] != `{"type":"payment_rejected"}` {
+			t.Errorf("Unexpected PUB %v", pub)
+		}
+	case <-ctx.Done():
+		t.Fatal("No message published")
+	}
+}
+
+func TestNewNATSSink_RejectsBadURL(t *testing.T) {
+	for _, raw := range []string{"", "localhost:4222", "http://localhost:4222"} {
+		if _, err := newNATSSink(raw, "x"); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+	s, _ := newNATSSink("nats://user:pw@broker", "x")
+	if s.addr != "broker:4222" || !strings.Contains(string(s.connect), `"user":"user"`) {
+		t.Errorf("Unexpected address %s or CONNECT %s", s.addr, s.connect)
+	}
+}
+
+func TestHandleSummarize_EmitsEvents(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	sink, flush := setupTestEventBus(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+
+	summarize := func(nonce string) {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"event text"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	summarize("n-event-1")
+	summarize("n-event-2")
+	verifier.RejectAll("bad signature")
+	summarize("n-event-3")
+	flush()
+
+	want := []string{
+		eventPaymentVerified, eventResponseServed,
+		eventPaymentVerified, eventResponseServed, eventCacheHit,
+		eventPaymentRejected,
+	}
+	if got := sink.types(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+	served, rejected := sink.events[1], sink.events[5]
+	if served.Nonce != "n-event-1" || served.Wallet != strings.ToLower(testsupport.DefaultPayer) || served.Provider != "openrouter" || served.ReceiptID == "" {
+		t.Errorf("Unexpected response_served event %+v", served)
+	}
+	if rejected.Reason != "bad signature" || rejected.Wallet != "" {
+		t.Errorf("Unexpected payment_rejected event %+v", rejected)
+	}
+}
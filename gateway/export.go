@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultExportLimit = 1000
+	maxExportLimit     = 10000
+)
+
+// ledgerCSVHeader names the CSV columns, in the order ledgerCSVRecord writes
+// them; they match LedgerEntry's JSON fields.
+var ledgerCSVHeader = []string{
+	"receipt_id", "time", "wallet", "route", "nonce", "amount", "token", "chain_id", "model", "provider",
+	"prompt_tokens", "completion_tokens", "provider_cost", "latency_ms", "cache_hit", "promo_code", "discount",
+	"type", "refund_id", "reason", "tx_hash", "settlement_state", "block_number", "tenant",
+}
+
+func ledgerCSVRecord(e LedgerEntry) []string {
+	var blockNumber string
+	if e.BlockNumber > 0 {
+		blockNumber = strconv.FormatUint(e.BlockNumber, 10)
+	}
+	return []string{
+		e.ReceiptID, e.Time.UTC().Format(time.RFC3339Nano), e.Wallet, e.Route, e.Nonce, e.Amount, e.Token, strconv.Itoa(e.ChainID), e.Model, e.Provider,
+		strconv.Itoa(e.PromptTokens), strconv.Itoa(e.CompletionTokens), e.ProviderCost,
+		strconv.FormatInt(e.LatencyMS, 10), strconv.FormatBool(e.CacheHit), e.PromoCode, e.Discount,
+		e.Type, e.RefundID, e.Reason, e.TxHash, e.SettlementState, blockNumber, e.Tenant,
+	}
+}
+
+// handleExportUsage handles GET /admin/export/usage, writing one page of
+// ledger entries between ?from= and ?to= (RFC 3339 or YYYY-MM-DD; to is
+// exclusive) as CSV (default) or JSON Lines. Pages hold up to ?limit=
+// entries, oldest first; while more remain, X-Next-Cursor carries the
+// ?cursor= of the next page.
+func handleExportUsage(c *gin.Context) {
+	if usageLedger == nil {
+		abortWithProblem(c, newProblem(503, codeLedgerDisabled, "Usage Unavailable", "The usage ledger is not enabled (LEDGER_BACKEND)"))
+		return
+	}
+
+	var q ledgerQuery
+	var err error
+	if q.Since, err = parseExportTime(c.Query("from")); err != nil {
+		abortInvalidQuery(c, "from", err.Error())
+		return
+	}
+	if q.Until, err = parseExportTime(c.Query("to")); err != nil {
+		abortInvalidQuery(c, "to", err.Error())
+		return
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && !q.Until.After(q.Since) {
+		abortInvalidQuery(c, "to", "to must be after from")
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		abortInvalidQuery(c, "format", "format must be csv or jsonl")
+		return
+	}
+
+	limit := defaultExportLimit
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxExportLimit {
+			abortInvalidQuery(c, "limit", fmt.Sprintf("limit must be between 1 and %d", maxExportLimit))
+			return
+		}
+	}
+
+	var cursor string
+	if raw := c.Query("cursor"); raw != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			abortInvalidQuery(c, "cursor", "cursor is not one this endpoint returned")
+			return
+		}
+		cursor = string(decoded)
+	}
+
+	entries, next, err := usageLedger.Page(c.Request.Context(), q, cursor, limit)
+	if errors.Is(err, errInvalidCursor) {
+		abortInvalidQuery(c, "cursor", "cursor is not one this endpoint returned")
+		return
+	}
+	if err != nil {
+		log.Printf("error exporting ledger: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read usage ledger", ""))
+		return
+	}
+
+	if next != "" {
+		c.Header("X-Next-Cursor", base64.RawURLEncoding.EncodeToString([]byte(next)))
+	}
+	c.Status(200)
+	if format == "jsonl" {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="usage.jsonl"`)
+		enc := json.NewEncoder(c.Writer)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="usage.csv"`)
+	w := csv.NewWriter(c.Writer)
+	w.Write(ledgerCSVHeader)
+	for _, e := range entries {
+		w.Write(ledgerCSVRecord(e))
+	}
+	w.Flush()
+}
+
+// parseExportTime parses an RFC 3339 time or a YYYY-MM-DD date (midnight
+// UTC); "" is the zero time.
+func parseExportTime(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected an RFC 3339 time or YYYY-MM-DD, got %q", raw)
+}
+
+// abortInvalidQuery answers 400 invalid_query for a bad query parameter.
+func abortInvalidQuery(c *gin.Context, parameter, detail string) {
+	abortWithProblem(c, newProblem(400, codeInvalidQuery, "Invalid Query Parameter", detail).
+		With("parameter", parameter))
+}
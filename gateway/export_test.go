@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedgerStores_Page(t *testing.T) {
+	for name, store := range testLedgerStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 0; i < 5; i++ {
+				time.Sleep(2 * time.Millisecond)
+				wallet := "0xaa"
+				if i == 2 {
+					wallet = "0xbb"
+				}
+				store.Append(ctx, LedgerEntry{ReceiptID: "rcpt_" + string(rune('a'+i)), Time: time.Now().UTC(), Wallet: wallet})
+			}
+
+			var ids []string
+			cursor, pages := "", 0
+			for {
+				entries, next, err := store.Page(ctx, ledgerQuery{Wallet: "0xaa"}, cursor, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range entries {
+					ids = append(ids, e.ReceiptID)
+				}
+				pages++
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if strings.Join(ids, ",") != "rcpt_a,rcpt_b,rcpt_d,rcpt_e" {
+				t.Errorf("Expected every 0xaa entry once in order, got %v", ids)
+			}
+			if pages > 3 {
+				t.Errorf("Expected at most 3 pages of 2, got %d", pages)
+			}
+
+			if _, _, err := store.Page(ctx, ledgerQuery{}, "bogus", 2); err != errInvalidCursor {
+				t.Errorf("Expected errInvalidCursor, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleExportUsage(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	ledger := setupTestLedger(t)
+	r := setupAdminRouter()
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ledger.Append(context.Background(), LedgerEntry{
+			ReceiptID: "rcpt_" + string(rune('a'+i)), Time: day.AddDate(0, 0, i), Wallet: "0xaa",
+			Amount: "0.001", Model: "z-ai/glm-4.5-air:free", PromptTokens: 10, CacheHit: i == 1,
+		})
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		return adminRequest(r, "GET", "/admin/export/usage"+query, "admin-secret")
+	}
+
+	w := get("?from=2026-03-01&to=2026-03-03&limit=1")
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV page, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 2 || rows[0][0] != "receipt_id" || rows[1][0] != "rcpt_a" {
+		t.Fatalf("Expected the header and rcpt_a, got %v, %v", rows, err)
+	}
+	cursor := w.Header().Get("X-Next-Cursor")
+	if cursor == "" {
+		t.Fatal("Expected a cursor for the next page")
+	}
+
+	w = get("?from=2026-03-01&to=2026-03-03&format=jsonl&cursor=" + cursor)
+	var e LedgerEntry
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if w.Code != 200 || len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &e) != nil || e.ReceiptID != "rcpt_b" || !e.CacheHit {
+		t.Fatalf("Expected rcpt_b as JSON Lines, got %d %q", w.Code, w.Body.String())
+	}
+	if next := w.Header().Get("X-Next-Cursor"); next != "" {
+		t.Errorf("Expected no cursor after the last page, got %q", next)
+	}
+
+	for _, query := range []string{"?from=yesterday", "?format=xml", "?limit=0", "?cursor=!!", "?from=2026-03-02&to=2026-03-01"} {
+		if w := get(query); w.Code != 400 || decodeProblem(t, w)["code"] != codeInvalidQuery {
+			t.Errorf("Expected 400 invalid_query for %s, got %d", query, w.Code)
+		}
+	}
+}
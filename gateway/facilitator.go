@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// x402Version is the protocol version sent to the facilitator.
+const x402Version = 1
+
+// paymentResponseHeader carries the facilitator's settlement result back to
+// the client, base64-encoded JSON as in the x402 spec.
+const paymentResponseHeader = "X-PAYMENT-RESPONSE"
+
+var facilitatorCallsTotal = newCounter(
+	"gateway_facilitator_calls_total",
+	"Calls to the x402 facilitator, by endpoint (verify, settle) and outcome (success, rejected, error).",
+	"endpoint", "outcome",
+)
+
+// errFacilitatorRejected means the facilitator answered, but refused the
+// payment; the reason is in the error text.
+var errFacilitatorRejected = errors.New("payment rejected by facilitator")
+
+// USDC contracts on the chains the facilitator is usually run for; others
+// need FACILITATOR_ASSET.
+var facilitatorNetworks = map[int]struct{ network, asset string }{
+	8453:  {"base", "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913"},
+	84532: {"base-sepolia", "0x036CbD53842c5426634e7929541eC2318f3dCF7e"},
+}
+
+// facilitatorRequest is the body of the facilitator's POST /verify and
+// POST /settle.
+type facilitatorRequest struct {
+	X402Version         int                     `json:"x402Version"`
+	PaymentPayload      facilitatorPayload      `json:"paymentPayload"`
+	PaymentRequirements facilitatorRequirements `json:"paymentRequirements"`
+}
+
+type facilitatorPayload struct {
+	X402Version int    `json:"x402Version"`
+	Scheme      string `json:"scheme"`
+	Network     string `json:"network"`
+	Payload     struct {
+		Signature string         `json:"signature"`
+		From      string         `json:"from"`
+		Context   PaymentContext `json:"context"`
+	} `json:"payload"`
+}
+
+type facilitatorRequirements struct {
+	Scheme            string `json:"scheme"`
+	Network           string `json:"network"`
+	MaxAmountRequired string `json:"maxAmountRequired"` // atomic units
+	Resource          string `json:"resource"`
+	PayTo             string `json:"payTo"`
+	Asset             string `json:"asset"`
+	MaxTimeoutSeconds int    `json:"maxTimeoutSeconds"`
+}
+
+type facilitatorVerifyResponse struct {
+	IsValid       bool   `json:"isValid"`
+	InvalidReason string `json:"invalidReason,omitempty"`
+	Payer         string `json:"payer,omitempty"`
+}
+
+// PaymentResponse is the facilitator's settlement result, sent to the
+// client in the X-PAYMENT-RESPONSE header.
+type PaymentResponse struct {
+	Success     bool   `json:"success"`
+	ErrorReason string `json:"errorReason,omitempty"`
+	Transaction string `json:"transaction"`
+	Network     string `json:"network"`
+	Payer       string `json:"payer,omitempty"`
+}
+
+// facilitatorHTTPClient makes the calls to FACILITATOR_URL.
+var facilitatorHTTPClient = &http.Client{}
+
+// getFacilitatorURL returns FACILITATOR_URL without a trailing slash.
+func getFacilitatorURL() string {
+	return strings.TrimRight(os.Getenv("FACILITATOR_URL"), "/")
+}
+
+// getFacilitatorTimeout returns FACILITATOR_TIMEOUT_SECONDS (default 30);
+// settling waits for the transaction to be mined.
+func getFacilitatorTimeout() time.Duration {
+	return time.Duration(getEnvAsInt("FACILITATOR_TIMEOUT_SECONDS", 30)) * time.Second
+}
+
+// chainNetwork returns the x402 network name and token contract for
+// chainID: FACILITATOR_NETWORK and FACILITATOR_ASSET when set, else the
+// known ones, else "eip155:<chainID>" and no contract.
+func chainNetwork(chainID int) (network, asset string) {
+	network, asset = os.Getenv("FACILITATOR_NETWORK"), os.Getenv("FACILITATOR_ASSET")
+	if known, ok := facilitatorNetworks[chainID]; ok {
+		if network == "" {
+			network = known.network
+		}
+		if asset == "" {
+			asset = known.asset
+		}
+	}
+	if network == "" {
+		network = "eip155:" + strconv.Itoa(chainID)
+	}
+	return network, asset
+}
+
+// facilitatorRequestFor builds what the facilitator is asked to verify or
+// settle: the signed payment and what the route requires.
+func facilitatorRequestFor(r SettlementRequest, resource string) (facilitatorRequest, error) {
+	network, asset := chainNetwork(r.PaymentContext.ChainID)
+	if asset == "" {
+		return facilitatorRequest{}, fmt.Errorf("no token contract known for chain %d (set FACILITATOR_ASSET)", r.PaymentContext.ChainID)
+	}
+	units, err := atomicAmount(r.PaymentContext.Amount)
+	if err != nil {
+		return facilitatorRequest{}, err
+	}
+
+	req := facilitatorRequest{
+		X402Version: x402Version,
+		PaymentPayload: facilitatorPayload{
+			X402Version: x402Version,
+			Scheme:      "exact",
+			Network:     network,
+		},
+		PaymentRequirements: facilitatorRequirements{
+			Scheme:            "exact",
+			Network:           network,
+			MaxAmountRequired: units,
+			Resource:          resource,
+			PayTo:             r.PaymentContext.Recipient,
+			Asset:             asset,
+			MaxTimeoutSeconds: int(getFacilitatorTimeout().Seconds()),
+		},
+	}
+	req.PaymentPayload.Payload.Signature = r.Signature
+	req.PaymentPayload.Payload.From = r.Wallet
+	req.PaymentPayload.Payload.Context = r.PaymentContext
+	return req, nil
+}
+
+// atomicAmount converts a decimal token amount to the token's smallest
+// unit, as x402 amounts are given.
+func atomicAmount(amount string) (string, error) {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok || r.Sign() < 0 {
+		return "", fmt.Errorf("invalid amount %q", amount)
+	}
+	decimals := getTokenDecimals()
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	if !r.IsInt() {
+		return "", fmt.Errorf("amount %q has more than %d decimals", amount, decimals)
+	}
+	return r.Num().String(), nil
+}
+
+// callFacilitator posts req to the facilitator's endpoint and decodes the
+// answer into out. Statuses other than 200 and 400 are errors carrying the
+// body.
+func callFacilitator(ctx context.Context, endpoint string, req facilitatorRequest, out interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, getFacilitatorTimeout())
+	defer cancel()
+	hreq, err := http.NewRequestWithContext(ctx, "POST", getFacilitatorURL()+"/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if key := os.Getenv("FACILITATOR_API_KEY"); key != "" {
+		hreq.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := facilitatorHTTPClient.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	// A 400 still carries the verify or settle result with the reason
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("facilitator %s returned %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("facilitator %s: malformed response: %w", endpoint, err)
+	}
+	return nil
+}
+
+// facilitatorVerify asks the facilitator whether the payment can be
+// settled. The error wraps errFacilitatorRejected when it can't.
+func facilitatorVerify(ctx context.Context, r SettlementRequest, resource string) error {
+	req, err := facilitatorRequestFor(r, resource)
+	if err != nil {
+		return err
+	}
+	var resp facilitatorVerifyResponse
+	if err := callFacilitator(ctx, "verify", req, &resp); err != nil {
+		facilitatorCallsTotal.Inc("verify", "error")
+		return err
+	}
+	if !resp.IsValid {
+		facilitatorCallsTotal.Inc("verify", "rejected")
+		return fmt.Errorf("%w: %s", errFacilitatorRejected, resp.InvalidReason)
+	}
+	facilitatorCallsTotal.Inc("verify", "success")
+	return nil
+}
+
+// facilitatorSettle has the facilitator submit the payment on-chain. The
+// error wraps errFacilitatorRejected when the settlement failed.
+func facilitatorSettle(ctx context.Context, r SettlementRequest, resource string) (PaymentResponse, error) {
+	var resp PaymentResponse
+	req, err := facilitatorRequestFor(r, resource)
+	if err != nil {
+		return resp, err
+	}
+	if err := callFacilitator(ctx, "settle", req, &resp); err != nil {
+		facilitatorCallsTotal.Inc("settle", "error")
+		return resp, err
+	}
+	if !resp.Success {
+		facilitatorCallsTotal.Inc("settle", "rejected")
+		return resp, fmt.Errorf("%w: %s", errFacilitatorRejected, resp.ErrorReason)
+	}
+	facilitatorCallsTotal.Inc("settle", "success")
+	return resp, nil
+}
+
+// verifyWithFacilitator checks a verified payment with the facilitator
+// before any work is done, answering 402 facilitator_rejected when it refuses
+// the payment and 502 facilitator_error when it can't be reached.
+func verifyWithFacilitator(c *gin.Context, r SettlementRequest) bool {
+	start := time.Now()
+	err := facilitatorVerify(c.Request.Context(), r, c.Request.URL.Path)
+	recordStage(c.Request.Context(), stageVerification, time.Since(start))
+	if err == nil {
+		return true
+	}
+	abortFacilitatorError(c, r, err)
+	return false
+}
+
+// settleWithFacilitator settles the payment once the response is ready and
+// before it is sent, so the client is only charged for what it receives.
+// The result goes in the X-PAYMENT-RESPONSE header, and the transaction
+// hash is returned.
+func settleWithFacilitator(c *gin.Context, r SettlementRequest) (string, bool) {
+	// Settle even if the client hangs up now; the response is ready
+	resp, err := facilitatorSettle(context.WithoutCancel(c.Request.Context()), r, c.Request.URL.Path)
+	if err != nil {
+		abortFacilitatorError(c, r, err)
+		return "", false
+	}
+	if header, err := json.Marshal(resp); err == nil {
+		c.Header(paymentResponseHeader, base64.StdEncoding.EncodeToString(header))
+	}
+	emitEvent(Event{Type: eventSettlementCompleted, RequestID: c.GetString(requestIDKey), Nonce: r.Nonce, Wallet: r.Wallet,
+		Amount: r.PaymentContext.Amount, Token: r.PaymentContext.Token, ChainID: r.PaymentContext.ChainID, TxHash: resp.Transaction})
+	return resp.Transaction, true
+}
+
+func abortFacilitatorError(c *gin.Context, r SettlementRequest, err error) {
+	if errors.Is(err, errFacilitatorRejected) {
+		abortWithProblem(c, newProblem(402, codeFacilitatorRejected, "Payment Required",
+			strings.TrimPrefix(err.Error(), errFacilitatorRejected.Error()+": ")))
+		return
+	}
+	log.Printf("facilitator error for %s: %v", r.Nonce, err)
+	abortWithProblem(c, newProblem(502, codeFacilitatorError, "Bad Gateway", "The payment facilitator is unavailable"))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeFacilitator answers /verify and /settle, settling unless settleError
+// is set, and records the requests it got.
+type fakeFacilitator struct {
+	*httptest.Server
+	mu          sync.Mutex
+	calls       []string
+	requests    []facilitatorRequest
+	invalid     string
+	settleError string
+}
+
+func newFakeFacilitator(t *testing.T) *fakeFacilitator {
+	f := &fakeFacilitator{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req facilitatorRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls = append(f.calls, r.URL.Path)
+		f.requests = append(f.requests, req)
+		switch r.URL.Path {
+		case "/verify":
+			json.NewEncoder(w).Encode(facilitatorVerifyResponse{IsValid: f.invalid == "", InvalidReason: f.invalid})
+		case "/settle":
+			if f.settleError != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(PaymentResponse{ErrorReason: f.settleError, Network: req.PaymentRequirements.Network})
+				return
+			}
+			json.NewEncoder(w).Encode(PaymentResponse{Success: true, Transaction: "0xtx", Network: req.PaymentRequirements.Network, Payer: req.PaymentPayload.Payload.From})
+		}
+	}))
+	t.Cleanup(f.Close)
+	t.Setenv("FACILITATOR_URL", f.URL)
+	return f
+}
+
+func (f *fakeFacilitator) callPaths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func TestFacilitator_SettlesBeforeResponding(t *testing.T) {
+	ai, send, queued := setupSettlementTest(t, settlementFacilitator)
+	facilitator := newFakeFacilitator(t)
+	ai.Reply("a summary")
+
+	w := send("n-facilitator-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls := facilitator.callPaths(); len(calls) != 2 || calls[0] != "/verify" || calls[1] != "/settle" {
+		t.Fatalf("Expected verify then settle, got %v", calls)
+	}
+	req := facilitator.requests[1]
+	if req.PaymentRequirements.Network != "base" || req.PaymentRequirements.MaxAmountRequired != "1000" || req.PaymentPayload.Payload.Signature != "0xsig" {
+		t.Errorf("Unexpected settle request %+v", req)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(w.Header().Get(paymentResponseHeader))
+	var settled PaymentResponse
+	if err != nil || json.Unmarshal(raw, &settled) != nil || !settled.Success || settled.Transaction != "0xtx" {
+		t.Errorf("Expected the settlement in %s, got %q", paymentResponseHeader, w.Header().Get(paymentResponseHeader))
+	}
+	if len(queued()) != 0 {
+		t.Error("Expected nothing in the settlement queue in facilitator mode")
+	}
+}
+
+func TestFacilitator_Rejections(t *testing.T) {
+	setupTestReputation(t)
+	ai, send, _ := setupSettlementTest(t, settlementFacilitator)
+	facilitator := newFakeFacilitator(t)
+	ai.Reply("a summary")
+
+	// Refused at verification: no AI call, no settlement
+	facilitator.mu.Lock()
+	facilitator.invalid = "insufficient_funds"
+	facilitator.mu.Unlock()
+	w := send("n-facilitator-2")
+	if p := decodeProblem(t, w); w.Code != http.StatusPaymentRequired || p["code"] != codeFacilitatorRejected || p["detail"] != "insufficient_funds" {
+		t.Errorf("Expected 402 facilitator_rejected, got %d %v", w.Code, p)
+	}
+	if calls := facilitator.callPaths(); len(calls) != 1 {
+		t.Errorf("Expected only a verify call, got %v", calls)
+	}
+
+	// Refused at settlement: the summary is withheld
+	facilitator.mu.Lock()
+	facilitator.invalid, facilitator.settleError = "", "transaction_reverted"
+	facilitator.mu.Unlock()
+	w = send("n-facilitator-3")
+	if p := decodeProblem(t, w); w.Code != http.StatusPaymentRequired || p["code"] != codeFacilitatorRejected || w.Header().Get("X-402-Receipt") != "" {
+		t.Errorf("Expected 402 without a receipt, got %d %v", w.Code, p)
+	}
+
+	// Unreachable
+	facilitator.Close()
+	w = send("n-facilitator-4")
+	if p := decodeProblem(t, w); w.Code != http.StatusBadGateway || p["code"] != codeFacilitatorError {
+		t.Errorf("Expected 502 facilitator_error, got %d %v", w.Code, p)
+	}
+}
+
+func TestAtomicAmount(t *testing.T) {
+	tests := []struct {
+		amount, want string
+		ok           bool
+	}{
+		{"0.001", "1000", true},
+		{"1", "1000000", true},
+		{"0.0000001", "", false},
+		{"-1", "", false},
+		{"abc", "", false},
+	}
+	for _, tt := range tests {
+		got, err := atomicAmount(tt.amount)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("atomicAmount(%q) = %q, %v", tt.amount, got, err)
+		}
+	}
+}
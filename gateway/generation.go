@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// generationParams are the sampling settings a request may pass through to
+// the provider. A nil field leaves the provider's default.
+type generationParams struct {
+	Temperature *float64 `json:"temperature,omitempty" example:"0"`
+	TopP        *float64 `json:"top_p,omitempty" example:"1"`
+	MaxTokens   *int     `json:"max_tokens,omitempty" example:"256"`
+}
+
+// getMaxTemperature returns MAX_TEMPERATURE (default 1.5), the highest
+// temperature a request may ask for.
+func getMaxTemperature() float64 {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("MAX_TEMPERATURE")), 64); err == nil && v >= 0 {
+		return v
+	}
+	return 1.5
+}
+
+// getMaxOutputTokens returns MAX_OUTPUT_TOKENS (default 1024), the largest
+// max_tokens a request may ask for.
+func getMaxOutputTokens() int {
+	return getEnvAsInt("MAX_OUTPUT_TOKENS", 1024)
+}
+
+// clamp returns p with every value moved into its safe range: temperature
+// to [0, MAX_TEMPERATURE], top_p to [0, 1] and max_tokens to
+// [1, MAX_OUTPUT_TOKENS]. Out-of-range values are clamped rather than
+// rejected so a client tuned for another provider still gets an answer.
+func (p generationParams) clamp() generationParams {
+	var out generationParams
+	if p.Temperature != nil {
+		v := min(max(*p.Temperature, 0), getMaxTemperature())
+		out.Temperature = &v
+	}
+	if p.TopP != nil {
+		v := min(max(*p.TopP, 0), 1)
+		out.TopP = &v
+	}
+	if p.MaxTokens != nil {
+		v := min(max(*p.MaxTokens, 1), getMaxOutputTokens())
+		out.MaxTokens = &v
+	}
+	return out
+}
+
+// isZero reports whether no parameter is set.
+func (p generationParams) isZero() bool {
+	return p.Temperature == nil && p.TopP == nil && p.MaxTokens == nil
+}
+
+// addCacheParams adds the set values to params for getCacheKey.
+func (p generationParams) addCacheParams(params map[string]string) {
+	if p.Temperature != nil {
+		params["temperature"] = strconv.FormatFloat(*p.Temperature, 'f', -1, 64)
+	}
+	if p.TopP != nil {
+		params["top_p"] = strconv.FormatFloat(*p.TopP, 'f', -1, 64)
+	}
+	if p.MaxTokens != nil {
+		params["max_tokens"] = strconv.Itoa(*p.MaxTokens)
+	}
+}
+
+// applyTo sets the values on an OpenAI-style request body (OpenRouter,
+// OpenAI and Azure share the field names).
+func (p generationParams) applyTo(body map[string]interface{}) {
+	if p.Temperature != nil {
+		body["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		body["top_p"] = *p.TopP
+	}
+	if p.MaxTokens != nil {
+		body["max_tokens"] = *p.MaxTokens
+	}
+}
+
+type generationParamsKey struct{}
+
+// withGenerationParams returns a context whose AI calls use p. Like the
+// provider recorder, it saves threading the values through the cache,
+// chunker and every provider.
+func withGenerationParams(ctx context.Context, p generationParams) context.Context {
+	if p.isZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, generationParamsKey{}, p)
+}
+
+// generationParamsFrom returns the parameters set on ctx, if any.
+func generationParamsFrom(ctx context.Context) generationParams {
+	p, _ := ctx.Value(generationParamsKey{}).(generationParams)
+	return p
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestGenerationParams_Clamp(t *testing.T) {
+	t.Setenv("MAX_TEMPERATURE", "1.2")
+	t.Setenv("MAX_OUTPUT_TOKENS", "500")
+
+	got := generationParams{Temperature: ptr(1.9), TopP: ptr(-0.5), MaxTokens: ptr(4000)}.clamp()
+	if *got.Temperature != 1.2 || *got.TopP != 0 || *got.MaxTokens != 500 {
+		t.Errorf("Expected 1.2/0/500, got %v/%v/%v", *got.Temperature, *got.TopP, *got.MaxTokens)
+	}
+	got = generationParams{Temperature: ptr(0.0), MaxTokens: ptr(0)}.clamp()
+	if *got.Temperature != 0 || got.TopP != nil || *got.MaxTokens != 1 {
+		t.Errorf("Expected temperature 0 kept, top_p unset and max_tokens raised to 1, got %+v", got)
+	}
+	if !(generationParams{}).clamp().isZero() {
+		t.Error("Expected no parameters to stay unset")
+	}
+}
+
+func TestGetCacheKey_GenerationParams(t *testing.T) {
+	plain := getCacheKey(context.Background(), "m", "text", summaryOptions{}.cacheParams())
+	cold := getCacheKey(context.Background(), "m", "text", summaryOptions{Generation: generationParams{Temperature: ptr(0.0)}}.cacheParams())
+	warm := getCacheKey(context.Background(), "m", "text", summaryOptions{Generation: generationParams{Temperature: ptr(0.7)}}.cacheParams())
+	if plain == cold || cold == warm {
+		t.Error("Expected each temperature to get its own cache key")
+	}
+}
+
+func TestProviders_SendGenerationParams(t *testing.T) {
+	var anthropicBody, ollamaBody map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/chat" {
+			json.NewDecoder(r.Body).Decode(&ollamaBody)
+			w.Write([]byte(`{"message":{"content":"ok"}}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&anthropicBody)
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}]}`))
+	}))
+	defer srv.Close()
+	t.Setenv("ANTHROPIC_BASE_URL", srv.URL)
+	t.Setenv("OLLAMA_URL", srv.URL)
+	t.Setenv("OLLAMA_NUM_CTX", "")
+
+	ctx := withGenerationParams(context.Background(), generationParams{Temperature: ptr(1.4), TopP: ptr(0.9), MaxTokens: ptr(64)})
+	if _, err := (anthropicProvider{}).Complete(ctx, "claude", "p"); err != nil {
+		t.Fatal(err)
+	}
+	if anthropicBody["temperature"] != 1.0 || anthropicBody["top_p"] != 0.9 || anthropicBody["max_tokens"] != float64(64) {
+		t.Errorf("Expected temperature capped at 1 and max_tokens 64 for Anthropic, got %v", anthropicBody)
+	}
+	if _, err := (ollamaProvider{}).Complete(ctx, "llama", "p"); err != nil {
+		t.Fatal(err)
+	}
+	opts, _ := ollamaBody["options"].(map[string]interface{})
+	if opts["temperature"] != 1.4 || opts["top_p"] != 0.9 || opts["num_predict"] != float64(64) {
+		t.Errorf("Expected Ollama options to carry the parameters, got %v", ollamaBody["options"])
+	}
+}
+
+func TestHandleSummarize_GenerationParams(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Reply("Summary.")
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("MAX_TEMPERATURE", "1")
+	t.Setenv("MAX_OUTPUT_TOKENS", "200")
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize",
+		strings.NewReader(`{"text":"some text","temperature":1.8,"top_p":0.5,"max_tokens":900}`))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "n-gen-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp SummarizeResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if g := resp.Generation; g == nil || *g.Temperature != 1 || *g.TopP != 0.5 || *g.MaxTokens != 200 {
+		t.Errorf("Expected the clamped values echoed, got %+v", resp.Generation)
+	}
+	sent := ai.Requests()[0]
+	if *sent.Temperature != 1 || *sent.TopP != 0.5 || *sent.MaxTokens != 200 {
+		t.Errorf("Expected the clamped values sent upstream, got %v/%v/%v", *sent.Temperature, *sent.TopP, *sent.MaxTokens)
+	}
+}
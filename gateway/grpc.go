@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The gateway speaks gRPC without the gRPC runtime: calls are unary, so a
+// request or response is one length-prefixed protobuf message in an
+// HTTP/2 body, with the status in the grpc-status and grpc-message
+// trailers. Messages are encoded with protowire by hand from the .proto
+// files under proto/.
+
+// gRPC status codes used by the gateway.
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// errGRPCFrame means a body isn't a single uncompressed gRPC message.
+var errGRPCFrame = errors.New("expected one uncompressed gRPC message")
+
+// grpcFrame prefixes msg with the uncompressed-flag byte and its length.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// grpcUnframe returns the message in a body holding one gRPC frame.
+func grpcUnframe(body []byte) ([]byte, error) {
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return nil, errGRPCFrame
+	}
+	return body[5:], nil
+}
+
+// isGRPCRequest reports whether r is a gRPC call.
+func isGRPCRequest(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcCodeForHTTP maps a REST status to the closest gRPC status code.
+// 402 Payment Required becomes FAILED_PRECONDITION: the call can succeed
+// once the client has signed the payment context.
+func grpcCodeForHTTP(status int) int {
+	switch {
+	case status < 300:
+		return grpcOK
+	case status == http.StatusBadRequest, status == http.StatusRequestEntityTooLarge, status == http.StatusUnprocessableEntity:
+		return grpcInvalidArgument
+	case status == http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case status == http.StatusPaymentRequired:
+		return grpcFailedPrecondition
+	case status == http.StatusForbidden:
+		return grpcPermissionDenied
+	case status == http.StatusNotFound:
+		return grpcNotFound
+	case status == http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case status == http.StatusServiceUnavailable, status == http.StatusBadGateway:
+		return grpcUnavailable
+	case status == http.StatusGatewayTimeout, status == http.StatusRequestTimeout:
+		return grpcDeadlineExceeded
+	default:
+		return grpcInternal
+	}
+}
+
+// appendProtoString appends a string field, omitting it when empty as
+// proto3 does.
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendProtoBool appends a bool field, omitting it when false.
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+// rangeProtoFields calls fn with each field of a message; fn returns the
+// number of bytes of the value it consumed, or a negative protowire error.
+// Fields fn doesn't recognize (returning 0) are skipped.
+func rangeProtoFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = fn(num, typ, b)
+		if n == 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcSummarizeMethod is Paygate.Summarize in
+// proto/paygate/gateway/v1/gateway.proto.
+const grpcSummarizeMethod = "/paygate.gateway.v1.Paygate/Summarize"
+
+// grpcProblemTrailer carries the REST problem document of a failed call.
+const grpcProblemTrailer = "Paygate-Problem-Bin"
+
+// setupGRPCServer serves the gRPC API on GRPC_PORT, over HTTP/2 without
+// TLS (h2c), for internal services. Calls are translated into REST requests
+// and run through r, so payment checks, rate limits, caching and receipts
+// behave exactly as over REST. It returns nil when GRPC_PORT is unset;
+// otherwise the caller must shut the server down.
+func setupGRPCServer(r *gin.Engine) *http.Server {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		return nil
+	}
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{Addr: ":" + port, Handler: newGRPCHandler(r), Protocols: &protocols}
+	configureHeaderLimits(srv)
+	go func() {
+		log.Printf("gRPC API listening on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("gRPC server error: %v", err)
+		}
+	}()
+	return srv
+}
+
+// grpcMethod maps one gRPC method onto a REST route.
+type grpcMethod struct {
+	path string
+	// toJSON decodes the request message into the REST body
+	toJSON func(msg []byte) ([]byte, error)
+	// fromJSON encodes a successful REST body as the response message
+	fromJSON func(body []byte) ([]byte, error)
+}
+
+var grpcMethods = map[string]grpcMethod{
+	grpcSummarizeMethod: {path: apiV1Prefix + "/ai/summarize", toJSON: summarizeRequestToJSON, fromJSON: summarizeResponseFromJSON},
+}
+
+// newGRPCHandler answers gRPC calls by replaying them against api.
+func newGRPCHandler(api http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isGRPCRequest(r) {
+			http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message, "+grpcProblemTrailer)
+
+		method, ok := grpcMethods[r.URL.Path]
+		if !ok {
+			writeGRPCStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSummarizeBodyBytes+6))
+		if err != nil {
+			writeGRPCStatus(w, grpcInternal, "failed to read request")
+			return
+		}
+		if len(body) > maxSummarizeBodyBytes+5 {
+			writeGRPCStatus(w, grpcResourceExhausted, "request message exceeds 10MB")
+			return
+		}
+		msg, err := grpcUnframe(body)
+		if err != nil {
+			writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+		restBody, err := method.toJSON(msg)
+		if err != nil {
+			writeGRPCStatus(w, grpcInvalidArgument, "malformed request message: "+err.Error())
+			return
+		}
+
+		ctx := r.Context()
+		if timeout, ok := parseGRPCTimeout(r.Header.Get("Grpc-Timeout")); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		restReq, err := http.NewRequestWithContext(ctx, "POST", method.path, bytes.NewReader(restBody))
+		if err != nil {
+			writeGRPCStatus(w, grpcInternal, err.Error())
+			return
+		}
+		// Metadata becomes headers, so x-402-signature and friends arrive
+		// where the REST handlers look for them
+		for name, values := range r.Header {
+			if !strings.HasPrefix(name, "Grpc-") && name != "Te" && name != "Content-Type" {
+				restReq.Header[name] = values
+			}
+		}
+		restReq.Header.Set("Content-Type", "application/json")
+		restReq.RemoteAddr = r.RemoteAddr
+
+		rec := &grpcResponseRecorder{header: http.Header{}, status: http.StatusOK}
+		api.ServeHTTP(rec, restReq)
+
+		// REST response headers (X-Request-ID, Retry-After, ...) are sent
+		// as response metadata
+		for name, values := range rec.header {
+			if name != "Content-Type" && name != "Content-Length" {
+				w.Header()[name] = values
+			}
+		}
+		if code := grpcCodeForHTTP(rec.status); code != grpcOK {
+			w.Header().Set(grpcProblemTrailer, base64.RawStdEncoding.EncodeToString(rec.body.Bytes()))
+			writeGRPCStatus(w, code, problemMessage(rec.body.Bytes(), rec.status))
+			return
+		}
+		reply, err := method.fromJSON(rec.body.Bytes())
+		if err != nil {
+			writeGRPCStatus(w, grpcInternal, "failed to encode response: "+err.Error())
+			return
+		}
+		w.Write(grpcFrame(reply))
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+// writeGRPCStatus ends a call with a status and message. Headers set after
+// the body (or instead of one) are sent as trailers.
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// problemMessage summarizes a problem body as "title: detail".
+func problemMessage(body []byte, status int) string {
+	var p struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	if json.Unmarshal(body, &p) != nil || p.Title == "" {
+		return http.StatusText(status)
+	}
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+// parseGRPCTimeout parses a grpc-timeout header such as "1500m".
+func parseGRPCTimeout(raw string) (time.Duration, bool) {
+	if len(raw) < 2 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[raw[len(raw)-1]]
+	n, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// grpcResponseRecorder buffers the REST response to a replayed call.
+type grpcResponseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (r *grpcResponseRecorder) Header() http.Header { return r.header }
+
+func (r *grpcResponseRecorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status, r.wrote = status, true
+	}
+}
+
+func (r *grpcResponseRecorder) Write(b []byte) (int, error) {
+	r.wrote = true
+	return r.body.Write(b)
+}
+
+// summarizeRequestToJSON decodes a paygate.gateway.v1.SummarizeRequest.
+func summarizeRequestToJSON(msg []byte) ([]byte, error) {
+	var req SummarizeRequest
+	err := rangeProtoFields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num >= 1 && num <= 3 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			switch num {
+			case 1:
+				req.Text = s
+			case 2:
+				req.Model = s
+			case 3:
+				req.OutputLanguage = s
+			}
+			return n
+		case (num == 4 || num == 5) && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			f := math.Float64frombits(v)
+			if num == 4 {
+				req.Temperature = &f
+			} else {
+				req.TopP = &f
+			}
+			return n
+		case num == 6 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			maxTokens := int(int32(v))
+			req.MaxTokens = &maxTokens
+			return n
+		}
+		return 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(req)
+}
+
+// summarizeResponseFromJSON encodes a REST summary as a
+// paygate.gateway.v1.SummarizeResponse.
+func summarizeResponseFromJSON(body []byte) ([]byte, error) {
+	var resp struct {
+		Result         string          `json:"result"`
+		Provider       string          `json:"provider"`
+		OutputLanguage string          `json:"output_language"`
+		Stale          bool            `json:"stale"`
+		Receipt        json.RawMessage `json:"receipt"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	var b []byte
+	b = appendProtoString(b, 1, resp.Result)
+	b = appendProtoString(b, 2, resp.Provider)
+	b = appendProtoString(b, 3, resp.OutputLanguage)
+	b = appendProtoBool(b, 4, resp.Stale)
+	if len(resp.Receipt) > 0 && string(resp.Receipt) != "null" {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, resp.Receipt)
+	}
+	return b, nil
+}
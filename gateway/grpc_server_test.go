@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// startTestGRPCServer serves the gRPC API over h2c in front of the v1 routes.
+func startTestGRPCServer(t *testing.T) string {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	registerAPIRoutes(r.Group(apiV1Prefix))
+
+	srv := httptest.NewUnstartedServer(newGRPCHandler(r))
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv.Config.Protocols = &protocols
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// grpcTestResult is what a test call got back.
+type grpcTestResult struct {
+	code    int
+	message string
+	msg     []byte
+	md      http.Header
+}
+
+// callTestGRPC makes a unary call with msg and the given metadata.
+func callTestGRPC(t *testing.T, base, method string, msg []byte, md map[string]string) grpcTestResult {
+	t.Helper()
+	req, _ := http.NewRequest("POST", base+method, bytes.NewReader(grpcFrame(msg)))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	for k, v := range md {
+		req.Header.Set(k, v)
+	}
+	resp, err := grpcClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	res := grpcTestResult{md: resp.Header.Clone()}
+	for k, v := range resp.Trailer {
+		res.md[k] = v
+	}
+	res.code, _ = strconv.Atoi(res.md.Get("Grpc-Status"))
+	res.message, _ = url.PathUnescape(res.md.Get("Grpc-Message"))
+	if len(body) > 0 {
+		if res.msg, err = grpcUnframe(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res
+}
+
+func summarizeRequestProto(text string) []byte {
+	return appendProtoString(nil, 1, text)
+}
+
+func TestGRPCSummarize_PaymentRequired(t *testing.T) {
+	base := startTestGRPCServer(t)
+
+	res := callTestGRPC(t, base, grpcSummarizeMethod, summarizeRequestProto("hello"), nil)
+	if res.code != grpcFailedPrecondition || res.message != "Payment Required: Please sign the payment context" {
+		t.Fatalf("Expected FAILED_PRECONDITION, got %d %q", res.code, res.message)
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(res.md.Get(grpcProblemTrailer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problem map[string]interface{}
+	json.Unmarshal(raw, &problem)
+	if problem["code"] != codePaymentRequired || problem["paymentContext"] == nil || problem["tokens"] == nil {
+		t.Errorf("Expected the priced payment_required problem, got %s", raw)
+	}
+	if res.md.Get("X-Request-ID") == "" {
+		t.Error("Expected the request ID as response metadata")
+	}
+}
+
+func TestGRPCSummarize_Paid(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	base := startTestGRPCServer(t)
+
+	msg := summarizeRequestProto("Summarize me over gRPC")
+	msg = protowire.AppendTag(msg, 6, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, 64)
+	res := callTestGRPC(t, base, grpcSummarizeMethod, msg, map[string]string{"X-402-Signature": "0xsig", "X-402-Nonce": "n-grpc-api"})
+	if res.code != grpcOK {
+		t.Fatalf("Expected OK, got %d %q", res.code, res.message)
+	}
+
+	fields := map[protowire.Number][]byte{}
+	rangeProtoFields(res.msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		v, n := protowire.ConsumeBytes(b)
+		fields[num] = v
+		return n
+	})
+	if len(fields[1]) == 0 || string(fields[2]) != "openrouter" {
+		t.Errorf("Expected a summary from openrouter, got %v", fields)
+	}
+	var receipt SignedReceipt
+	if err := json.Unmarshal(fields[5], &receipt); err != nil || receipt.Signature == "" {
+		t.Errorf("Expected the signed receipt JSON, got %s", fields[5])
+	}
+	if reqs := verifier.Requests(); len(reqs) != 1 || reqs[0].Context.Nonce != "n-grpc-api" {
+		t.Errorf("Expected the payment metadata to reach the verifier, got %+v", reqs)
+	}
+}
+
+func TestGRPCHandler_Errors(t *testing.T) {
+	base := startTestGRPCServer(t)
+
+	if res := callTestGRPC(t, base, "/paygate.gateway.v1.Paygate/Translate", nil, nil); res.code != grpcUnimplemented {
+		t.Errorf("Expected UNIMPLEMENTED for an unknown method, got %d", res.code)
+	}
+	bad := protowire.AppendTag(nil, 1, protowire.BytesType)
+	if res := callTestGRPC(t, base, grpcSummarizeMethod, bad, nil); res.code != grpcInvalidArgument {
+		t.Errorf("Expected INVALID_ARGUMENT for a truncated message, got %d", res.code)
+	}
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	for raw, want := range map[string]time.Duration{"1500m": 1500 * time.Millisecond, "2S": 2 * time.Second, "1H": time.Hour} {
+		if got, ok := parseGRPCTimeout(raw); !ok || got != want {
+			t.Errorf("parseGRPCTimeout(%q) = %v, want %v", raw, got, want)
+		}
+	}
+	for _, raw := range []string{"", "m", "10x", "-1S"} {
+		if _, ok := parseGRPCTimeout(raw); ok {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
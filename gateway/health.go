@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dependencyStatus is one dependency's entry in the /readyz response.
+type dependencyStatus struct {
+	Status    string `json:"status" doc:"up, down or disabled"`
+	Critical  bool   `json:"critical"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthResponse is the body of GET /livez and /healthz.
+type HealthResponse struct {
+	Status  string `json:"status" example:"ok"`
+	Service string `json:"service" example:"gateway"`
+}
+
+// ReadinessResponse is the body of GET /readyz.
+type ReadinessResponse struct {
+	Status  string                      `json:"status" doc:"ready or not_ready" example:"ready"`
+	Service string                      `json:"service" example:"gateway"`
+	Checks  map[string]dependencyStatus `json:"checks"`
+}
+
+// dependencyCheck probes one dependency. A critical dependency being down
+// makes the gateway not ready.
+type dependencyCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error // nil means disabled
+}
+
+// readinessChecks returns the dependency checks for /readyz. The verifier is
+// critical since no paid request can succeed without it; when the background
+// poller is running its cached status is used. Redis is reported
+// but not critical because the cache falls back to memory. The OpenRouter
+// key check is opt-in via READINESS_CHECK_OPENROUTER=true. Ollama is checked
+// whenever it is in the provider chain, and is critical when it is the
+// primary provider.
+func readinessChecks() []dependencyCheck {
+	checks := []dependencyCheck{
+		{name: "verifier", critical: true, check: checkVerifierHealth},
+		{name: "redis", critical: false},
+		{name: "openrouter", critical: true},
+		{name: "ollama", critical: getProviderChain()[0] == "ollama"},
+	}
+	if verifierHealth != nil {
+		checks[0].check = checkCachedVerifierHealth
+	}
+	if redisClient != nil {
+		checks[1].check = func(ctx context.Context) error { return redisClient.Ping(ctx).Err() }
+	}
+	if strings.ToLower(os.Getenv("READINESS_CHECK_OPENROUTER")) == "true" && chainIncludes("openrouter") {
+		checks[2].check = checkOpenRouterAuth
+	}
+	if chainIncludes("ollama") {
+		checks[3].check = checkOllama
+	}
+	return checks
+}
+
+// checkVerifierHealth calls the verifier's /health endpoint.
+func checkVerifierHealth(ctx context.Context) error {
+	return probeHTTPWith(ctx, verifierHTTPClient, getVerifierURL()+"/health", nil)
+}
+
+// checkCachedVerifierHealth reports the background poller's view of the
+// verifier instead of probing it again.
+func checkCachedVerifierHealth(ctx context.Context) error {
+	health := verifierHealth.snapshot()
+	if health.Up {
+		return nil
+	}
+	return fmt.Errorf("%d consecutive health checks failed, last at %s: %s",
+		health.ConsecutiveFailures, health.LastChecked.UTC().Format(time.RFC3339), health.LastError)
+}
+
+// checkOpenRouterAuth confirms OPENROUTER_API_KEY is accepted by calling
+// OpenRouter's key endpoint (OPENROUTER_KEY_URL overrides it).
+func checkOpenRouterAuth(ctx context.Context) error {
+	keyURL := os.Getenv("OPENROUTER_KEY_URL")
+	if keyURL == "" {
+		keyURL = "https://openrouter.ai/api/v1/key"
+	}
+	return probeHTTP(ctx, keyURL, http.Header{"Authorization": {"Bearer " + os.Getenv("OPENROUTER_API_KEY")}})
+}
+
+// probeHTTP issues a GET and treats any non-2xx status as a failure.
+func probeHTTP(ctx context.Context, url string, header http.Header) error {
+	return probeHTTPWith(ctx, http.DefaultClient, url, header)
+}
+
+// probeHTTPWith is probeHTTP with a specific client.
+func probeHTTPWith(ctx context.Context, client *http.Client, url string, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runDependencyChecks runs checks concurrently, each bounded by the health
+// check timeout, and reports whether every critical dependency is up.
+func runDependencyChecks(ctx context.Context, checks []dependencyCheck) (map[string]dependencyStatus, bool) {
+	results := make(map[string]dependencyStatus, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, dc := range checks {
+		if dc.check == nil {
+			// Checks started earlier in the loop may be writing results
+			mu.Lock()
+			results[dc.name] = dependencyStatus{Status: "disabled", Critical: dc.critical}
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(dc dependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, getHealthCheckTimeout())
+			defer cancel()
+
+			start := time.Now()
+			err := dc.check(checkCtx)
+			status := dependencyStatus{Status: "up", Critical: dc.critical, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = "down"
+				status.Error = err.Error()
+			}
+			mu.Lock()
+			results[dc.name] = status
+			mu.Unlock()
+		}(dc)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, status := range results {
+		if status.Critical && status.Status == "down" {
+			ready = false
+		}
+	}
+	return results, ready
+}
+
+// handleLive handles GET /livez and the legacy GET /healthz. It only reports
+// that the process is up; dependency problems must not get a healthy pod
+// restarted.
+func handleLive(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok", Service: "gateway"})
+}
+
+// handleReady handles GET /readyz, returning 503 when a critical dependency
+// is down so load balancers stop routing traffic to this instance.
+func handleReady(c *gin.Context) {
+	checks, ready := runDependencyChecks(c.Request.Context(), readinessChecks())
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	c.JSON(code, ReadinessResponse{Status: status, Service: "gateway", Checks: checks})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func readyzRequest(t *testing.T) (int, map[string]dependencyStatus) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/readyz", handleReady)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	var body struct {
+		Checks map[string]dependencyStatus `json:"checks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to parse response JSON: %v", err)
+	}
+	return w.Code, body.Checks
+}
+
+func TestHandleReady_AllUp(t *testing.T) {
+	setupTestRedis(t)
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(404)
+		}
+	}))
+	defer verifier.Close()
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("READINESS_CHECK_OPENROUTER", "")
+
+	code, checks := readyzRequest(t)
+	if code != 200 {
+		t.Fatalf("Expected 200, got %d: %+v", code, checks)
+	}
+	if checks["verifier"].Status != "up" || checks["redis"].Status != "up" || checks["openrouter"].Status != "disabled" {
+		t.Errorf("Unexpected checks: %+v", checks)
+	}
+}
+
+func TestHandleReady_VerifierDownReturns503(t *testing.T) {
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer verifier.Close()
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("READINESS_CHECK_OPENROUTER", "")
+
+	code, checks := readyzRequest(t)
+	if code != 503 {
+		t.Fatalf("Expected 503, got %d", code)
+	}
+	if checks["verifier"].Status != "down" || checks["verifier"].Error == "" {
+		t.Errorf("Expected verifier reported down with error, got %+v", checks["verifier"])
+	}
+}
+
+func TestHandleReady_RedisDownIsNotCritical(t *testing.T) {
+	mr := setupTestRedis(t)
+	mr.Close()
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer verifier.Close()
+	t.Setenv("VERIFIER_URL", verifier.URL)
+
+	code, checks := readyzRequest(t)
+	if code != 200 || checks["redis"].Status != "down" {
+		t.Errorf("Expected ready with redis down, got %d: %+v", code, checks)
+	}
+}
+
+func TestHandleReady_OpenRouterAuth(t *testing.T) {
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer verifier.Close()
+	openRouter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-key" {
+			w.WriteHeader(401)
+		}
+	}))
+	defer openRouter.Close()
+
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("READINESS_CHECK_OPENROUTER", "true")
+	t.Setenv("OPENROUTER_KEY_URL", openRouter.URL)
+	t.Setenv("OPENROUTER_API_KEY", "bad-key")
+
+	if code, checks := readyzRequest(t); code != 503 || checks["openrouter"].Status != "down" {
+		t.Errorf("Expected 503 for rejected key, got %d: %+v", code, checks)
+	}
+
+	t.Setenv("OPENROUTER_API_KEY", "good-key")
+	if code, checks := readyzRequest(t); code != 200 || checks["openrouter"].Status != "up" {
+		t.Errorf("Expected 200 for accepted key, got %d: %+v", code, checks)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// defaultH2CTrustedCIDRs are the peers allowed to speak h2c when
+// H2C_TRUSTED_CIDRS is unset: a proxy on the same host.
+const defaultH2CTrustedCIDRs = "127.0.0.0/8,::1/128"
+
+// http2Enabled reports whether HTTP2_ENABLED allows HTTP/2 (default true).
+func http2Enabled() bool {
+	return strings.ToLower(os.Getenv("HTTP2_ENABLED")) != "false"
+}
+
+// parseCIDRs parses a comma-separated list of CIDR ranges.
+func parseCIDRs(raw string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range splitList(raw) {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR range", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// configureHTTP2 applies the HTTP/2 settings to srv:
+//
+//   - HTTP2_ENABLED: offer HTTP/2 to TLS clients through ALPN (default true;
+//     see setupTLS)
+//   - HTTP2_MAX_CONCURRENT_STREAMS: streams per connection (default 250)
+//   - H2C: also accept cleartext HTTP/2, by prior knowledge or
+//     "Upgrade: h2c", on plain listeners (default false). Only peers in
+//     H2C_TRUSTED_CIDRS (default loopback) and Unix socket clients may use
+//     it, since it's meant for a proxy in front of the gateway; others get
+//     HTTP/1.1
+//
+// LoadConfig has already validated the settings.
+func configureHTTP2(srv *http.Server) {
+	streams := getEnvAsInt("HTTP2_MAX_CONCURRENT_STREAMS", 250)
+	srv.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: streams}
+	if !http2Enabled() || strings.ToLower(os.Getenv("H2C")) != "true" {
+		return
+	}
+	raw := os.Getenv("H2C_TRUSTED_CIDRS")
+	if raw == "" {
+		raw = defaultH2CTrustedCIDRs
+	}
+	trusted, _ := parseCIDRs(raw)
+	srv.Handler = newH2CHandler(srv.Handler, trusted, uint32(streams))
+}
+
+// newH2CHandler serves h2c to trusted peers and passes everything else to
+// next unchanged.
+func newH2CHandler(next http.Handler, trusted []*net.IPNet, maxStreams uint32) http.Handler {
+	h2cHandler := h2c.NewHandler(next, &http2.Server{MaxConcurrentStreams: maxStreams})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil && h2cPeerTrusted(r.RemoteAddr, trusted) {
+			h2cHandler.ServeHTTP(w, r)
+			return
+		}
+		// An untrusted prior-knowledge preface; "Upgrade: h2c" is just
+		// ignored and answered over HTTP/1.1
+		if r.Method == "PRI" && r.URL.Path == "*" {
+			http.Error(w, "h2c is only accepted from trusted proxies", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// h2cPeerTrusted reports whether remoteAddr is in trusted. Unix socket
+// peers have no IP address and are always trusted.
+func h2cPeerTrusted(remoteAddr string, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	return slices.ContainsFunc(trusted, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
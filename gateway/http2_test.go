@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// startHTTP2TestServer serves r.Proto on a loopback listener configured by
+// configureHTTP2.
+func startHTTP2TestServer(t *testing.T) string {
+	t.Helper()
+	addrs := []listenAddr{{network: "tcp", address: "127.0.0.1:0"}}
+	listeners, err := openListeners(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Proto)) })}
+	configureHTTP2(srv)
+	serveListeners(srv, addrs, listeners, make(chan error, 1))
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return "http://" + listeners[0].Addr().String() + "/"
+}
+
+// h2cClient speaks cleartext HTTP/2 with prior knowledge.
+func h2cClient() *http.Client {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+}
+
+func TestConfigureHTTP2_H2CFromTrustedPeer(t *testing.T) {
+	t.Setenv("H2C", "true")
+	url := startHTTP2TestServer(t)
+
+	resp, err := h2cClient().Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected h2c from loopback, got %s", resp.Proto)
+	}
+}
+
+func TestConfigureHTTP2_H2CRefusedFromUntrustedPeer(t *testing.T) {
+	t.Setenv("H2C", "true")
+	t.Setenv("H2C_TRUSTED_CIDRS", "10.0.0.0/8")
+	url := startHTTP2TestServer(t)
+
+	if resp, err := h2cClient().Get(url); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected prior-knowledge h2c to be refused, got %s", resp.Proto)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 {
+		t.Errorf("Expected HTTP/1.1 to keep working, got %s", resp.Proto)
+	}
+}
+
+func TestConfigureHTTP2_H2COffByDefault(t *testing.T) {
+	url := startHTTP2TestServer(t)
+	if resp, err := h2cClient().Get(url); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected no h2c without H2C=true, got %s", resp.Proto)
+	}
+}
+
+func TestH2CPeerTrusted(t *testing.T) {
+	trusted, _ := parseCIDRs(defaultH2CTrustedCIDRs)
+	for addr, want := range map[string]bool{"127.0.0.1:5000": true, "[::1]:5000": true, "@": true, "": true, "10.1.2.3:5000": false} {
+		if got := h2cPeerTrusted(addr, trusted); got != want {
+			t.Errorf("h2cPeerTrusted(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestSetupTLS_HTTP2Disabled(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "gateway")
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	t.Setenv("HTTP2_ENABLED", "false")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg, _, err := setupTLS(ctx)
+	if err != nil || slices.Contains(cfg.NextProtos, "h2") {
+		t.Errorf("Expected HTTP/1.1 only, got %v %v", cfg.NextProtos, err)
+	}
+}
+
+func TestLoadConfig_HTTP2Settings(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("HTTP2_ENABLED", "false")
+	t.Setenv("H2C", "true")
+	t.Setenv("H2C_TRUSTED_CIDRS", "10.0.0.0/8,proxy")
+	t.Setenv("HTTP2_MAX_CONCURRENT_STREAMS", "0")
+
+	_, err := LoadConfig()
+	for _, key := range []string{"H2C:", "H2C_TRUSTED_CIDRS:", "HTTP2_MAX_CONCURRENT_STREAMS"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected a %s problem, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// idempotencyKeyPrefix namespaces stored responses in Redis.
+const idempotencyKeyPrefix = "idem:"
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// verifiedPayerKey is the gin context key handleSummarize sets to the
+// wallet whose payment it verified.
+const verifiedPayerKey = "verified_payer"
+
+var idempotentRequestsTotal = newCounter(
+	"gateway_idempotent_requests_total",
+	"Requests carrying an Idempotency-Key, by outcome (stored, replayed, in_progress, mismatch, not_stored).",
+	"outcome",
+)
+
+// getIdempotencyTTL returns IDEMPOTENCY_TTL_SECONDS, how long a response is
+// kept for replay (default 24h; 0 ignores the header).
+func getIdempotencyTTL() time.Duration {
+	return time.Duration(getEnvAsInt("IDEMPOTENCY_TTL_SECONDS", 86400)) * time.Second
+}
+
+// idempotencyRecord is a response kept for replay. Status is 0 while the
+// first request is still in flight.
+type idempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	// Payer identifies the payment the response was bought with, and Wallet
+	// the wallet that verified as paying it; only they can replay it.
+	Payer  string            `json:"payer,omitempty"`
+	Wallet string            `json:"wallet,omitempty"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   []byte            `json:"body,omitempty"`
+	// StoredAt is when the request was first seen, for the retention purge.
+	StoredAt time.Time `json:"stored_at"`
+}
+
+// idempotencyStore keeps records in Redis when REDIS_URL is set, so a retry
+// that lands on another instance still replays, and in memory otherwise.
+type idempotencyStore interface {
+	// claim stores rec under key unless the key is taken, in which case it
+	// returns the existing record.
+	claim(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) (*idempotencyRecord, error)
+	save(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) error
+	release(ctx context.Context, key string) error
+	// purge removes the records match selects and returns how many it
+	// removed.
+	purge(ctx context.Context, match func(idempotencyRecord) bool) (int64, error)
+}
+
+func currentIdempotencyStore() idempotencyStore {
+	if redisClient != nil {
+		return redisIdempotencyStore{client: redisClient}
+	}
+	return memoryIdempotency
+}
+
+type memoryIdempotencyEntry struct {
+	rec       idempotencyRecord
+	expiresAt time.Time
+}
+
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	lastSweep time.Time
+}
+
+var memoryIdempotency = &memoryIdempotencyStore{entries: make(map[string]memoryIdempotencyEntry)}
+
+func (s *memoryIdempotencyStore) claim(_ context.Context, key string, rec idempotencyRecord, ttl time.Duration) (*idempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if e, ok := s.entries[key]; ok && now.Before(e.expiresAt) {
+		return &e.rec, nil
+	}
+	s.entries[key] = memoryIdempotencyEntry{rec: rec, expiresAt: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) save(_ context.Context, key string, rec idempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryIdempotencyEntry{rec: rec, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) purge(_ context.Context, match func(idempotencyRecord) bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed int64
+	for k, e := range s.entries {
+		if match(e.rec) {
+			delete(s.entries, k)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+type redisIdempotencyStore struct {
+	client *redis.Client
+}
+
+func (s redisIdempotencyStore) claim(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) (*idempotencyRecord, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.client.SetNX(ctx, idempotencyKeyPrefix+key, data, ttl).Result()
+	if err != nil || ok {
+		return nil, err
+	}
+	raw, err := s.client.Get(ctx, idempotencyKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// Expired between the two calls; try once more
+		if ok, err := s.client.SetNX(ctx, idempotencyKeyPrefix+key, data, ttl).Result(); err != nil || ok {
+			return nil, err
+		}
+		return nil, errors.New("idempotency key claimed concurrently")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var existing idempotencyRecord
+	if err := json.Unmarshal(raw, &existing); err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (s redisIdempotencyStore) save(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, idempotencyKeyPrefix+key, data, ttl).Err()
+}
+
+func (s redisIdempotencyStore) release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, idempotencyKeyPrefix+key).Err()
+}
+
+// purge scans the idempotency keys, since Redis can't select records by
+// their contents. A record that can't be decoded is removed too.
+func (s redisIdempotencyStore) purge(ctx context.Context, match func(idempotencyRecord) bool) (int64, error) {
+	var removed int64
+	iter := s.client.Scan(ctx, 0, idempotencyKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		raw, err := s.client.Get(ctx, iter.Val()).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		var rec idempotencyRecord
+		if json.Unmarshal(raw, &rec) == nil && !match(rec) {
+			continue
+		}
+		n, err := s.client.Del(ctx, iter.Val()).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, iter.Err()
+}
+
+// validIdempotencyKey reports whether key is 1-255 printable ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyRecorder copies the response body as it is written.
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *idempotencyRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// paymentCredentials identifies the payment c carries, hashed: its signed
+// nonce, channel balance signature or prepaid payment signature. It is ""
+// for an unpaid request.
+func paymentCredentials(c *gin.Context) string {
+	var parts []string
+	switch {
+	case c.GetHeader(channelHeader) != "":
+		parts = []string{"channel", c.GetHeader(channelHeader), c.GetHeader(channelSignatureHeader)}
+	case c.GetHeader(prepaidHeader) != "":
+		parts = []string{"prepaid", c.GetHeader(prepaidHeader), c.GetHeader("X-Wallet-Signature"), c.GetHeader("X-402-Nonce")}
+	case c.GetHeader("X-402-Signature") != "" && c.GetHeader("X-402-Nonce") != "":
+		parts = []string{"x402", c.GetHeader("X-402-Signature"), c.GetHeader("X-402-Nonce")}
+	default:
+		return ""
+	}
+	return hashText(strings.Join(parts, "\x00"))
+}
+
+// IdempotencyMiddleware makes paid POSTs safe to retry. The first request
+// with a given Idempotency-Key runs normally and, if it succeeds, its
+// response is stored for IDEMPOTENCY_TTL_SECONDS; later requests with the
+// same key and body get that response back, marked Idempotent-Replayed,
+// without being verified or charged again. Only the payer can replay it:
+// the retry must carry the same payment headers, or an X-402-Payment with
+// a fresh nonce signed by the wallet that paid. Unpaid requests aren't
+// stored or replayed. A key reused with a different body or by another
+// payer is refused with 422, and one whose first request hasn't finished
+// with 409. Failed responses aren't stored, so the client can retry them.
+// Store errors are logged and the request proceeds without the guarantee.
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		ttl := getIdempotencyTTL()
+		if key == "" || ttl <= 0 {
+			c.Next()
+			return
+		}
+		if !validIdempotencyKey(key) {
+			abortWithProblem(c, newProblem(http.StatusBadRequest, codeInvalidIdempotencyKey, "Bad Request",
+				"Idempotency-Key must be 1-255 printable ASCII characters"))
+			return
+		}
+		// An unpaid request only gets the 402 challenge, which isn't worth
+		// storing, and must never be answered with a paid response
+		credentials := paymentCredentials(c)
+		if credentials == "" {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSummarizeBodyBytes))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				abortWithProblem(c, newProblem(413, codePayloadTooLarge, "Payload Too Large", "Request body exceeds 10MB").
+					With("max_size", "10MB"))
+			} else {
+				abortWithProblem(c, newProblem(http.StatusBadRequest, codeInvalidRequestBody, "Bad Request", "Failed to read request body"))
+			}
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.FullPath()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+		// Tenants' clients pick their keys independently
+		storeKey := hashText(key)
+		if id := tenantID(c.Request.Context()); id != "" {
+			storeKey = hashText(id + "\x00" + key)
+		}
+
+		ctx := context.WithoutCancel(c.Request.Context())
+		store := currentIdempotencyStore()
+		storedAt := time.Now().UTC()
+		existing, err := store.claim(ctx, storeKey, idempotencyRecord{Fingerprint: fingerprint, StoredAt: storedAt}, getWriteTimeout())
+		if err != nil {
+			log.Printf("idempotency: claim failed: %v", err)
+			c.Next()
+			return
+		}
+		if existing != nil {
+			replayIdempotent(c, existing, fingerprint, credentials)
+			return
+		}
+
+		// Headers already set by earlier middleware (request ID, rate
+		// limits) belong to this request, not the stored response.
+		before := c.Writer.Header().Clone()
+		rec := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+
+		status := rec.Status()
+		if status < 200 || status > 299 {
+			idempotentRequestsTotal.Inc("not_stored")
+			if err := store.release(ctx, storeKey); err != nil {
+				log.Printf("idempotency: release failed: %v", err)
+			}
+			return
+		}
+		stored := idempotencyRecord{Fingerprint: fingerprint, Status: status, Payer: credentials, Wallet: c.GetString(verifiedPayerKey),
+			Header: make(map[string]string), Body: rec.body.Bytes(), StoredAt: storedAt}
+		for name, values := range rec.Header() {
+			if len(values) > 0 && before.Get(name) != values[0] {
+				stored.Header[name] = values[0]
+			}
+		}
+		if err := store.save(ctx, storeKey, stored, ttl); err != nil {
+			log.Printf("idempotency: save failed: %v", err)
+			return
+		}
+		idempotentRequestsTotal.Inc("stored")
+	}
+}
+
+// samePayer reports whether c pays like the request rec was stored for:
+// with the same payment headers, or with an X-402-Payment signed by the
+// wallet that paid.
+func (rec *idempotencyRecord) samePayer(c *gin.Context, credentials string) bool {
+	if rec.Payer == credentials {
+		return true
+	}
+	return rec.Wallet != "" && strings.EqualFold(signedPayer(c), rec.Wallet)
+}
+
+// replayIdempotent answers a request whose key was already used.
+func replayIdempotent(c *gin.Context, rec *idempotencyRecord, fingerprint, credentials string) {
+	switch {
+	case rec.Fingerprint != fingerprint:
+		idempotentRequestsTotal.Inc("mismatch")
+		abortWithProblem(c, newProblem(http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "Unprocessable Entity",
+			"This Idempotency-Key was already used with a different request"))
+	case rec.Status != 0 && !rec.samePayer(c, credentials):
+		idempotentRequestsTotal.Inc("mismatch")
+		abortWithProblem(c, newProblem(http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "Unprocessable Entity",
+			"This Idempotency-Key was already used with a different payment"))
+	case rec.Status == 0:
+		idempotentRequestsTotal.Inc("in_progress")
+		c.Header("Retry-After", "1")
+		abortWithProblem(c, newProblem(http.StatusConflict, codeIdempotencyInProgress, "Conflict",
+			"A request with this Idempotency-Key is still in progress; retry when it completes").
+			With("retry_after", 1))
+	default:
+		idempotentRequestsTotal.Inc("replayed")
+		for name, value := range rec.Header {
+			c.Header(name, value)
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(rec.Status, c.Writer.Header().Get("Content-Type"), rec.Body)
+		c.Abort()
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// setupIdempotencyTest wires a summarize router to fake verifier and AI
+// backends and returns a sender for paid requests, taking extra headers as
+// name, value pairs.
+func setupIdempotencyTest(t *testing.T) (*testsupport.FakeVerifier, *testsupport.FakeOpenRouter, func(key, text, nonce string, header ...string) *httptest.ResponseRecorder) {
+	t.Helper()
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+
+	return verifier, ai, func(key, text, nonce string, header ...string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", bytes.NewBufferString(`{"text":"`+text+`"}`))
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+		}
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+}
+
+func TestIdempotency_ReplaysStoredResponse(t *testing.T) {
+	verifier, ai, send := setupIdempotencyTest(t)
+	key := "replay-" + t.Name()
+	text := "some text to summarize"
+	signed := func(nonce string) (string, []string) {
+		payment := PaymentContext{Recipient: defaultRecipientAddress, Token: "USDC", Amount: "0.001", Nonce: nonce, ChainID: 8453, BodyHash: paymentBodyHash(text)}
+		wallet, signature, declared := signTestPayment(t, testWalletKey, payment)
+		return wallet, []string{"X-402-Signature", signature, paymentHeader, declared}
+	}
+	wallet, header := signed("n-idem-1")
+	verifier.AcceptAll(wallet)
+
+	first := send(key, text, "n-idem-1", header...)
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", first.Code, first.Body.String())
+	}
+	verifierCalls, aiCalls := verifier.Calls(), ai.Calls()
+
+	// A retry with the same payment gets the original response back
+	replay := send(key, text, "n-idem-1", header...)
+	if replay.Code != http.StatusOK || replay.Body.String() != first.Body.String() {
+		t.Fatalf("Expected the stored response, got %d: %s", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("Expected only the replay to be marked Idempotent-Replayed")
+	}
+	if replay.Header().Get("X-402-Receipt") != first.Header().Get("X-402-Receipt") {
+		t.Error("Expected the original receipt to be replayed")
+	}
+
+	// So does one with a fresh nonce signed by the same wallet
+	_, header = signed("n-idem-2")
+	if w := send(key, text, "n-idem-2", header...); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the paying wallet's fresh signature to replay, got %d: %s", w.Code, w.Body.String())
+	}
+	if verifier.Calls() != verifierCalls || ai.Calls() != aiCalls {
+		t.Errorf("Expected the replays not to be verified or summarized again, got %d verifier and %d AI calls", verifier.Calls()-verifierCalls, ai.Calls()-aiCalls)
+	}
+
+	// Anyone else with the key and body gets nothing
+	if w := send(key, text, "n-idem-3"); w.Code != http.StatusUnprocessableEntity || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected another payment to be refused, got %d", w.Code)
+	}
+	if w := send(key, text, ""); w.Code != http.StatusPaymentRequired {
+		t.Errorf("Expected an unpaid request to get the 402, got %d", w.Code)
+	}
+}
+
+func TestIdempotency_BodyLimit(t *testing.T) {
+	_, _, send := setupIdempotencyTest(t)
+	w := send("big-"+t.Name(), strings.Repeat("a", maxSummarizeBodyBytes), "n-idem-big")
+	if p := decodeProblem(t, w); w.Code != http.StatusRequestEntityTooLarge || p["code"] != codePayloadTooLarge {
+		t.Errorf("Expected 413 payload_too_large, got %d %v", w.Code, p)
+	}
+}
+
+func TestIdempotency_KeyReusedWithDifferentBody(t *testing.T) {
+	_, _, send := setupIdempotencyTest(t)
+	key := "reuse-" + t.Name()
+
+	if w := send(key, "the first text", "n-reuse-1"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w := send(key, "a different text", "n-reuse-2")
+	if p := decodeProblem(t, w); w.Code != http.StatusUnprocessableEntity || p["code"] != codeIdempotencyKeyReused {
+		t.Errorf("Expected 422 idempotency_key_reused, got %d %v", w.Code, p)
+	}
+}
+
+func TestIdempotency_FailuresAreNotStored(t *testing.T) {
+	_, _, send := setupIdempotencyTest(t)
+	key := "unpaid-" + t.Name()
+
+	if w := send(key, "text to price", ""); w.Code != http.StatusPaymentRequired {
+		t.Fatalf("Expected 402, got %d", w.Code)
+	}
+	w := send(key, "text to price", "n-unpaid-1")
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected the paid retry to run, got %d replayed=%q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotency_InProgress(t *testing.T) {
+	_, ai, send := setupIdempotencyTest(t)
+	ai.Delay(500 * time.Millisecond)
+	key := "busy-" + t.Name()
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- send(key, "a slow request", "n-busy-idem-1") }()
+	deadline := time.Now().Add(2 * time.Second)
+	for ai.Calls() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w := send(key, "a slow request", "n-busy-idem-1")
+	if p := decodeProblem(t, w); w.Code != http.StatusConflict || p["code"] != codeIdempotencyInProgress || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 409 idempotency_in_progress with Retry-After, got %d %v", w.Code, p)
+	}
+	if w := <-first; w.Code != http.StatusOK {
+		t.Fatalf("Expected the first request to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send(key, "a slow request", "n-busy-idem-1"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected a replay once the first request finished, got %d", w.Code)
+	}
+}
+
+func TestIdempotency_InvalidKey(t *testing.T) {
+	_, _, send := setupIdempotencyTest(t)
+	for _, key := range []string{string(bytes.Repeat([]byte("k"), 256)), "tab\tkey"} {
+		w := send(key, "text", "n-invalid-key")
+		if p := decodeProblem(t, w); w.Code != http.StatusBadRequest || p["code"] != codeInvalidIdempotencyKey {
+			t.Errorf("Expected 400 invalid_idempotency_key for %q, got %d %v", key, w.Code, p)
+		}
+	}
+}
+
+func TestIdempotency_DisabledByZeroTTL(t *testing.T) {
+	verifier, _, send := setupIdempotencyTest(t)
+	t.Setenv("IDEMPOTENCY_TTL_SECONDS", "0")
+	key := "disabled-" + t.Name()
+
+	send(key, "text", "n-disabled-1")
+	if w := send(key, "text", "n-disabled-2"); w.Header().Get("Idempotent-Replayed") != "" || verifier.Calls() != 2 {
+		t.Errorf("Expected the header to be ignored, got %d verifier calls", verifier.Calls())
+	}
+}
+
+func TestIdempotency_RedisSharesAcrossInstances(t *testing.T) {
+	verifier, _, send := setupIdempotencyTest(t)
+	mr := setupTestRedis(t)
+	key := "redis-" + t.Name()
+
+	first := send(key, "shared text", "n-redis-1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", first.Code, first.Body.String())
+	}
+	if !mr.Exists(idempotencyKeyPrefix + hashText(key)) {
+		t.Fatal("Expected the response to be stored in Redis")
+	}
+	// Another instance has an empty memory store but shares Redis
+	memoryIdempotency.release(t.Context(), hashText(key))
+	calls := verifier.Calls()
+	if w := send(key, "shared text", "n-redis-1"); w.Body.String() != first.Body.String() || verifier.Calls() != calls {
+		t.Errorf("Expected the replay to come from Redis, got %d: %s", w.Code, w.Body.String())
+	}
+	if ttl := mr.TTL(idempotencyKeyPrefix + hashText(key)); ttl != 24*time.Hour {
+		t.Errorf("Expected the default 24h window, got %v", ttl)
+	}
+}
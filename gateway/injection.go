@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var injectionDetectionsTotal = newCounter(
+	"gateway_injection_detections_total",
+	"Summarize texts matching a prompt-injection rule, by action taken (flag, reject).",
+	"action",
+)
+
+// injectionRule is a named pattern of known prompt-injection or jailbreak
+// text. The name is what clients and the abuse report see.
+type injectionRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// builtinInjectionRules catch the common, low-effort attempts to make the
+// model ignore the summarize instruction. They are deliberately narrow:
+// texts that merely discuss prompt injection should still be summarizable.
+var builtinInjectionRules = []injectionRule{
+	{"ignore_instructions", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+)?(of\s+)?(the\s+|your\s+)?(previous|prior|above|earlier|preceding)\s+(instructions|prompts?|directions|rules)`)},
+	{"new_instructions", regexp.MustCompile(`(?i)\b(new|updated|real)\s+instructions\s*:`)},
+	{"system_prompt_leak", regexp.MustCompile(`(?i)\b(reveal|print|repeat|show|output)\s+(me\s+)?(your|the)\s+(system\s+prompt|initial\s+instructions|hidden\s+prompt)`)},
+	{"role_override", regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(DAN|in\s+developer\s+mode|an?\s+unrestricted|jailbroken)`)},
+	{"dan_jailbreak", regexp.MustCompile(`(?i)\bdo\s+anything\s+now\b`)},
+	{"chat_markup", regexp.MustCompile(`<\|(im_start|im_end|system|endoftext)\|>|\[/?INST\]|<<SYS>>`)},
+}
+
+// injectionMode returns INJECTION_DETECTION: "off" (default), "flag" or
+// "reject".
+func injectionMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("INJECTION_DETECTION"))); mode {
+	case "flag", "reject":
+		return mode
+	default:
+		return "off"
+	}
+}
+
+// customInjectionRules are loaded from INJECTION_RULES_FILE by
+// initInjectionRules and checked after the built-in rules.
+var customInjectionRules []injectionRule
+
+// initInjectionRules loads INJECTION_RULES_FILE, if set. LoadConfig has
+// already validated the file, so an error here is only logged.
+func initInjectionRules() []injectionRule {
+	path := os.Getenv("INJECTION_RULES_FILE")
+	if path == "" {
+		return nil
+	}
+	rules, err := loadInjectionRules(path)
+	if err != nil {
+		log.Printf("Warning: %v, using the built-in injection rules only", err)
+		return nil
+	}
+	return rules
+}
+
+// loadInjectionRules reads "name=regexp" lines from path ('#' starts a
+// comment); a line without a name is named after its line number.
+func loadInjectionRules(path string) ([]injectionRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("INJECTION_RULES_FILE: %w", err)
+	}
+	defer f.Close()
+
+	var rules []injectionRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, pattern, ok := strings.Cut(line, "=")
+		if !ok || strings.ContainsAny(name, " \t(\\[") {
+			name, pattern = fmt.Sprintf("custom_%d", n), line
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("INJECTION_RULES_FILE line %d: %w", n, err)
+		}
+		rules = append(rules, injectionRule{Name: name, Pattern: re})
+	}
+	return rules, scanner.Err()
+}
+
+// scanInjection returns the names of the rules text matches.
+func scanInjection(text string) []string {
+	var matched []string
+	for _, rule := range slices.Concat(builtinInjectionRules, customInjectionRules) {
+		if rule.Pattern.MatchString(text) {
+			matched = append(matched, rule.Name)
+		}
+	}
+	return matched
+}
+
+// checkInjection scans text for a paid request from wallet. Matches are
+// recorded against the wallet's abuse score; it reports whether the request
+// must be rejected (INJECTION_DETECTION=reject) and the matched rules. In
+// flag mode the request proceeds with X-Input-Flagged set.
+func checkInjection(c *gin.Context, wallet, text string) (reject bool, rules []string) {
+	mode := injectionMode()
+	if mode == "off" {
+		return false, nil
+	}
+	rules = scanInjection(text)
+	if len(rules) == 0 {
+		return false, nil
+	}
+	injectionDetectionsTotal.Inc(mode)
+	abuseScores.record(wallet, rules)
+	log.Printf("Prompt injection rules %v matched text from %s (%s)", rules, wallet, mode)
+	if mode == "reject" {
+		return true, rules
+	}
+	c.Header("X-Input-Flagged", "prompt_injection")
+	return false, rules
+}
+
+// maxAbuseRecords bounds the abuse table; the least recently seen wallet is
+// dropped first.
+const maxAbuseRecords = 10000
+
+// abuseScores tracks wallets that submitted flagged input. It is kept in
+// memory per gateway instance and resets on restart.
+var abuseScores = newAbuseTracker()
+
+// AbuseRecord is one wallet's entry in GET /admin/abuse.
+type AbuseRecord struct {
+	Wallet   string         `json:"wallet" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Score    int            `json:"score" doc:"Number of flagged rule matches"`
+	Rules    map[string]int `json:"rules" doc:"Matches per rule"`
+	LastSeen time.Time      `json:"last_seen"`
+}
+
+// AbuseReport is the body of GET /admin/abuse.
+type AbuseReport struct {
+	Wallets []AbuseRecord `json:"wallets"`
+}
+
+type abuseTracker struct {
+	mu      sync.Mutex
+	records map[string]*AbuseRecord
+}
+
+func newAbuseTracker() *abuseTracker {
+	return &abuseTracker{records: make(map[string]*AbuseRecord)}
+}
+
+// record adds one point per matched rule to wallet's score.
+func (t *abuseTracker) record(wallet string, rules []string) {
+	wallet = strings.ToLower(wallet)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec, ok := t.records[wallet]
+	if !ok {
+		if len(t.records) >= maxAbuseRecords {
+			t.evictOldestLocked()
+		}
+		rec = &AbuseRecord{Wallet: wallet, Rules: make(map[string]int)}
+		t.records[wallet] = rec
+	}
+	for _, rule := range rules {
+		rec.Rules[rule]++
+		rec.Score++
+	}
+	rec.LastSeen = time.Now()
+}
+
+func (t *abuseTracker) evictOldestLocked() {
+	var oldest *AbuseRecord
+	for _, rec := range t.records {
+		if oldest == nil || rec.LastSeen.Before(oldest.LastSeen) {
+			oldest = rec
+		}
+	}
+	if oldest != nil {
+		delete(t.records, oldest.Wallet)
+	}
+}
+
+// score returns wallet's abuse score, 0 when it has no record.
+func (t *abuseTracker) score(wallet string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec, ok := t.records[strings.ToLower(wallet)]; ok {
+		return rec.Score
+	}
+	return 0
+}
+
+// report returns copies of the records, highest score first.
+func (t *abuseTracker) report() []AbuseRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	records := make([]AbuseRecord, 0, len(t.records))
+	for _, rec := range t.records {
+		cp := *rec
+		cp.Rules = maps.Clone(rec.Rules)
+		records = append(records, cp)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Score != records[j].Score {
+			return records[i].Score > records[j].Score
+		}
+		return records[i].Wallet < records[j].Wallet
+	})
+	return records
+}
+
+// handleAbuseReport handles GET /admin/abuse, listing wallets that
+// submitted flagged input, highest score first.
+func handleAbuseReport(c *gin.Context) {
+	c.JSON(200, AbuseReport{Wallets: abuseScores.report()})
+}
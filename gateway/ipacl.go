@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ipACLBlockedTotal = newCounter(
+	"gateway_ip_acl_blocked_total",
+	"Requests refused by the IP access list, by reason (denylist, not_allowlisted).",
+	"reason",
+)
+
+// IPACLRules lists IP addresses and CIDR ranges to allow and deny.
+type IPACLRules struct {
+	Allow []string `json:"allow" doc:"When not empty, only these addresses may call the gateway" example:"10.0.0.0/8"`
+	Deny  []string `json:"deny" doc:"Addresses refused with 403; deny wins over allow" example:"203.0.113.0/24"`
+}
+
+// IPACLResponse is the body of GET and PUT /admin/ip-acl.
+type IPACLResponse struct {
+	Managed IPACLRules `json:"managed" doc:"Entries set through this API or IP_ACL_FILE"`
+	Env     IPACLRules `json:"env" doc:"Entries from IP_ALLOWLIST and IP_DENYLIST, fixed until restart"`
+	File    string     `json:"file,omitempty" doc:"IP_ACL_FILE, where managed entries are saved and reloaded from"`
+}
+
+// ipRanges is a parsed list of IP/CIDR entries.
+type ipRanges []*net.IPNet
+
+func (r ipRanges) contains(ip net.IP) bool {
+	return slices.ContainsFunc(r, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// parseIPRanges parses IP addresses and CIDR ranges; a bare address is
+// a range of one.
+func parseIPRanges(entries []string) (ipRanges, error) {
+	var ranges ipRanges
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			entry = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// ipAccessList decides which client addresses may call the gateway. The
+// entries from the environment are fixed; the managed ones can be replaced
+// through the admin API and, with a file, are saved to it and reloaded when
+// it changes, so every instance sharing the file follows.
+type ipAccessList struct {
+	path string
+	env  IPACLRules
+
+	mu          sync.RWMutex
+	managed     IPACLRules
+	allow, deny ipRanges // env and managed entries combined
+	modTime     time.Time
+}
+
+// ipAccess is the gateway's access list, replaced at startup by
+// initIPAccessList. The empty list lets every address through.
+var ipAccess = &ipAccessList{}
+
+// initIPAccessList builds the access list from IP_ALLOWLIST, IP_DENYLIST
+// and IP_ACL_FILE. LoadConfig has already validated them. It always returns
+// a list, even an empty one, so ranges can be blocked through the admin API
+// during an incident.
+func initIPAccessList() *ipAccessList {
+	a := &ipAccessList{
+		path: os.Getenv("IP_ACL_FILE"),
+		env:  IPACLRules{Allow: splitList(os.Getenv("IP_ALLOWLIST")), Deny: splitList(os.Getenv("IP_DENYLIST"))},
+	}
+	if err := a.set(IPACLRules{}); err != nil {
+		log.Printf("Warning: IP access list: %v", err)
+	}
+	if a.path != "" {
+		if _, err := a.reloadIfChanged(); err != nil {
+			log.Printf("Warning: IP access list: %v", err)
+		}
+	}
+	if len(a.allow) > 0 || len(a.deny) > 0 {
+		log.Printf("IP access list enabled (%d allowed, %d denied ranges)", len(a.allow), len(a.deny))
+	}
+	return a
+}
+
+// set replaces the managed entries.
+func (a *ipAccessList) set(managed IPACLRules) error {
+	allow, err := parseIPRanges(append(slices.Clone(a.env.Allow), managed.Allow...))
+	if err != nil {
+		return err
+	}
+	deny, err := parseIPRanges(append(slices.Clone(a.env.Deny), managed.Deny...))
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.managed, a.allow, a.deny = managed, allow, deny
+	a.mu.Unlock()
+	return nil
+}
+
+// check returns why ip is refused, or "" when it may pass.
+func (a *ipAccessList) check(ip net.IP) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	switch {
+	case a.deny.contains(ip):
+		return "denylist"
+	case len(a.allow) > 0 && !a.allow.contains(ip):
+		return "not_allowlisted"
+	}
+	return ""
+}
+
+// response describes the list for the admin API.
+func (a *ipAccessList) response() IPACLResponse {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return IPACLResponse{Managed: a.managed, Env: a.env, File: a.path}
+}
+
+// reloadIfChanged loads the file when it is newer than the entries in use,
+// reporting whether it did. A missing file means no managed entries; on any
+// other error the current entries stay.
+func (a *ipAccessList) reloadIfChanged() (bool, error) {
+	var modTime time.Time
+	info, err := os.Stat(a.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return false, err
+	default:
+		modTime = info.ModTime()
+	}
+	a.mu.RLock()
+	unchanged := modTime.Equal(a.modTime)
+	a.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	managed, err := loadIPACLFile(a.path)
+	if err != nil {
+		return false, err
+	}
+	if err := a.set(managed); err != nil {
+		return false, fmt.Errorf("IP_ACL_FILE: %w", err)
+	}
+	a.mu.Lock()
+	a.modTime = modTime
+	a.mu.Unlock()
+	return true, nil
+}
+
+// watch reloads the file every interval until ctx is done.
+func (a *ipAccessList) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if reloaded, err := a.reloadIfChanged(); err != nil {
+				log.Printf("Warning: IP access list reload failed, keeping the current entries: %v", err)
+			} else if reloaded {
+				log.Printf("Reloaded IP access list from %s", a.path)
+			}
+		}
+	}
+}
+
+// start watches IP_ACL_FILE for changes, every
+// IP_ACL_RELOAD_INTERVAL_SECONDS (default 10).
+func (a *ipAccessList) start(ctx context.Context) {
+	if a.path == "" {
+		return
+	}
+	go a.watch(ctx, time.Duration(getEnvAsInt("IP_ACL_RELOAD_INTERVAL_SECONDS", 10))*time.Second)
+}
+
+// replace sets the managed entries, which must already be valid, and saves
+// them to the file, if any.
+func (a *ipAccessList) replace(managed IPACLRules) error {
+	if a.path != "" {
+		if err := saveIPACLFile(a.path, managed); err != nil {
+			return err
+		}
+		// The watcher would load the same entries again; skip that
+		if info, err := os.Stat(a.path); err == nil {
+			a.mu.Lock()
+			a.modTime = info.ModTime()
+			a.mu.Unlock()
+		}
+	}
+	return a.set(managed)
+}
+
+// loadIPACLFile reads "allow <ip-or-cidr>" and "deny <ip-or-cidr>" lines
+// ('#' starts a comment). A missing file has no entries.
+func loadIPACLFile(path string) (IPACLRules, error) {
+	var rules IPACLRules
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rules, nil
+	}
+	if err != nil {
+		return rules, fmt.Errorf("IP_ACL_FILE: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || (fields[0] != "allow" && fields[0] != "deny") {
+			return rules, fmt.Errorf("IP_ACL_FILE line %d: expected \"allow <ip-or-cidr>\" or \"deny <ip-or-cidr>\"", n)
+		}
+		if _, err := parseIPRanges(fields[1:]); err != nil {
+			return rules, fmt.Errorf("IP_ACL_FILE line %d: %v", n, err)
+		}
+		if fields[0] == "allow" {
+			rules.Allow = append(rules.Allow, fields[1])
+		} else {
+			rules.Deny = append(rules.Deny, fields[1])
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// saveIPACLFile writes rules to path, replacing it atomically so a watcher
+// never reads half a file.
+func saveIPACLFile(path string, rules IPACLRules) error {
+	var sb strings.Builder
+	sb.WriteString("# Managed through /admin/ip-acl; edits here are picked up too\n")
+	for _, entry := range rules.Allow {
+		fmt.Fprintf(&sb, "allow %s\n", entry)
+	}
+	for _, entry := range rules.Deny {
+		fmt.Fprintf(&sb, "deny %s\n", entry)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ip-acl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(sb.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// getTrustedProxies returns TRUSTED_PROXIES, the addresses and CIDR ranges
+// of the proxies in front of the gateway. Only their X-Forwarded-For and
+// X-Real-IP headers name the client; anyone else could pick the address
+// the IP lists, rate limits and proof-of-work challenges see, so by
+// default none are trusted and the client is the peer itself.
+func getTrustedProxies() []string {
+	return splitList(os.Getenv("TRUSTED_PROXIES"))
+}
+
+// IPAccessMiddleware refuses clients whose address is on the denylist or,
+// when an allowlist is set, not on it, with 403. The address is the one
+// rate limiting uses (c.ClientIP), taken from forwarding headers only for
+// TRUSTED_PROXIES. Unix socket clients have no address and always pass.
+func IPAccessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.ClientIP())
+		if ip == nil {
+			c.Next()
+			return
+		}
+		if reason := ipAccess.check(ip); reason != "" {
+			ipACLBlockedTotal.Inc(reason)
+			abortWithProblem(c, newProblem(403, codeIPBlocked, "Forbidden", "Requests from this address are not allowed"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleGetIPACL handles GET /admin/ip-acl.
+func handleGetIPACL(c *gin.Context) {
+	c.JSON(200, ipAccess.response())
+}
+
+// handlePutIPACL handles PUT /admin/ip-acl, replacing the managed entries.
+// The environment entries stay.
+func handlePutIPACL(c *gin.Context) {
+	var rules IPACLRules
+	if err := json.NewDecoder(c.Request.Body).Decode(&rules); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	if _, err := parseIPRanges(slices.Concat(rules.Allow, rules.Deny)); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	if err := ipAccess.replace(rules); err != nil {
+		log.Printf("error saving the IP access list: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to save the IP access list", err.Error()))
+		return
+	}
+	auditAdminAction(c, auditIPACLUpdated, map[string]string{
+		"allow": strings.Join(rules.Allow, ","), "deny": strings.Join(rules.Deny, ","),
+	})
+	log.Printf("Admin updated the IP access list (%d allowed, %d denied)", len(rules.Allow), len(rules.Deny))
+	c.JSON(200, ipAccess.response())
+}
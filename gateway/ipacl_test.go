@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useIPAccessList installs a list built from the current environment for
+// the rest of the test.
+func useIPAccessList(t *testing.T) *ipAccessList {
+	previous := ipAccess
+	ipAccess = initIPAccessList()
+	t.Cleanup(func() { ipAccess = previous })
+	return ipAccess
+}
+
+func setupIPAccessRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.SetTrustedProxies(getTrustedProxies())
+	r.Use(IPAccessMiddleware())
+	r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func requestFrom(r http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	return forwardedRequestFrom(r, remoteAddr, "")
+}
+
+// forwardedRequestFrom sends a request from remoteAddr that claims to be
+// forwarded for client, unless it is empty.
+func forwardedRequestFrom(r http.Handler, remoteAddr, client string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	req.RemoteAddr = remoteAddr
+	if client != "" {
+		req.Header.Set("X-Forwarded-For", client)
+		req.Header.Set("X-Real-IP", client)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseIPRanges(t *testing.T) {
+	ranges, err := parseIPRanges([]string{"203.0.113.7", "10.0.0.0/8", "2001:db8::/32", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"203.0.113.7", "10.20.30.40", "2001:db8::5", "::1"} {
+		if !ranges.contains(net.ParseIP(ip)) {
+			t.Errorf("Expected %s to be in range", ip)
+		}
+	}
+	for _, ip := range []string{"203.0.113.8", "11.0.0.1", "2001:db9::1"} {
+		if ranges.contains(net.ParseIP(ip)) {
+			t.Errorf("Expected %s not to be in range", ip)
+		}
+	}
+	for _, entry := range []string{"example.com", "10.0.0.0/33", "10.0.0"} {
+		if _, err := parseIPRanges([]string{entry}); err == nil {
+			t.Errorf("Expected %q to be rejected", entry)
+		}
+	}
+}
+
+func TestIPAccessMiddleware(t *testing.T) {
+	t.Setenv("IP_ALLOWLIST", "10.0.0.0/8,127.0.0.1")
+	t.Setenv("IP_DENYLIST", "10.66.0.0/16")
+	useIPAccessList(t)
+	r := setupIPAccessRouter()
+	denied, notAllowed := ipACLBlockedTotal.Value("denylist"), ipACLBlockedTotal.Value("not_allowlisted")
+
+	if w := requestFrom(r, "10.1.2.3:4000"); w.Code != http.StatusOK {
+		t.Errorf("Expected an allowlisted address to pass, got %d", w.Code)
+	}
+	w := requestFrom(r, "10.66.1.1:4000")
+	if w.Code != http.StatusForbidden || decodeProblem(t, w)["code"] != codeIPBlocked {
+		t.Errorf("Expected the denylist to win over the allowlist, got %d %s", w.Code, w.Body.String())
+	}
+	if w := requestFrom(r, "198.51.100.1:4000"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected an address outside the allowlist to be refused, got %d", w.Code)
+	}
+	// Unix socket peers have no address
+	if w := requestFrom(r, "@"); w.Code != http.StatusOK {
+		t.Errorf("Expected a Unix socket client to pass, got %d", w.Code)
+	}
+	if got := ipACLBlockedTotal.Value("denylist") - denied; got != 1 {
+		t.Errorf("Expected 1 denylist block, got %v", got)
+	}
+	if got := ipACLBlockedTotal.Value("not_allowlisted") - notAllowed; got != 1 {
+		t.Errorf("Expected 1 not_allowlisted block, got %v", got)
+	}
+}
+
+func TestIPAccessMiddleware_TrustedProxies(t *testing.T) {
+	t.Setenv("IP_ALLOWLIST", "10.0.0.0/8,192.0.2.10")
+	t.Setenv("IP_DENYLIST", "203.0.113.0/24")
+	useIPAccessList(t)
+	r := setupIPAccessRouter()
+
+	// By default no peer is trusted to name the client
+	if w := forwardedRequestFrom(r, "203.0.113.7:4000", "10.1.2.3"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a spoofed X-Forwarded-For not to get past the denylist, got %d", w.Code)
+	}
+	if w := forwardedRequestFrom(r, "198.51.100.1:4000", "10.1.2.3"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a spoofed X-Forwarded-For not to get through the allowlist, got %d", w.Code)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "192.0.2.10")
+	r = setupIPAccessRouter()
+	if w := forwardedRequestFrom(r, "192.0.2.10:4000", "203.0.113.7"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the client named by a trusted proxy to be checked, got %d", w.Code)
+	}
+	if w := forwardedRequestFrom(r, "192.0.2.10:4000", "10.1.2.3"); w.Code != http.StatusOK {
+		t.Errorf("Expected an allowlisted client behind a trusted proxy to pass, got %d", w.Code)
+	}
+	if w := forwardedRequestFrom(r, "203.0.113.7:4000", "10.1.2.3"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected other peers' headers to stay ignored, got %d", w.Code)
+	}
+}
+
+func TestIPAccessList_FileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-acl")
+	os.WriteFile(path, []byte("# scrapers\ndeny 203.0.113.0/24\n"), 0o600)
+	t.Setenv("IP_ACL_FILE", path)
+	acl := useIPAccessList(t)
+	r := setupIPAccessRouter()
+
+	if w := requestFrom(r, "203.0.113.9:1"); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected the file's denylist to apply, got %d", w.Code)
+	}
+
+	os.WriteFile(path, []byte("deny 198.51.100.0/24\n"), 0o600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if reloaded, err := acl.reloadIfChanged(); err != nil || !reloaded {
+		t.Fatalf("Expected a reload, got %v %v", reloaded, err)
+	}
+	if w := requestFrom(r, "203.0.113.9:1"); w.Code != http.StatusOK {
+		t.Errorf("Expected the old range to be lifted, got %d", w.Code)
+	}
+	if w := requestFrom(r, "198.51.100.9:1"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the new range to be blocked, got %d", w.Code)
+	}
+
+	// A broken file keeps the entries in force
+	os.WriteFile(path, []byte("block 192.0.2.1\n"), 0o600)
+	future = future.Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if _, err := acl.reloadIfChanged(); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected a line error, got %v", err)
+	}
+	if w := requestFrom(r, "198.51.100.9:1"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the previous entries to stay, got %d", w.Code)
+	}
+}
+
+func TestAdminIPACL(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	t.Setenv("IP_DENYLIST", "192.0.2.1")
+	path := filepath.Join(t.TempDir(), "ip-acl")
+	t.Setenv("IP_ACL_FILE", path)
+	acl := useIPAccessList(t)
+	r := setupAdminRouter()
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/admin/ip-acl", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put(`{"deny":["203.0.113.0/24"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if reason := acl.check(net.ParseIP("203.0.113.50")); reason != "denylist" {
+		t.Errorf("Expected the new range to apply at once, got %q", reason)
+	}
+	if reason := acl.check(net.ParseIP("192.0.2.1")); reason != "denylist" {
+		t.Errorf("Expected the env entries to stay, got %q", reason)
+	}
+	saved, err := loadIPACLFile(path)
+	if err != nil || len(saved.Deny) != 1 || saved.Deny[0] != "203.0.113.0/24" {
+		t.Errorf("Expected the entries to be saved to IP_ACL_FILE, got %+v %v", saved, err)
+	}
+	if reloaded, _ := acl.reloadIfChanged(); reloaded {
+		t.Error("Expected the saved file not to be reloaded again")
+	}
+
+	w = adminRequest(r, "GET", "/admin/ip-acl", "secret")
+	var resp IPACLResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Managed.Deny) != 1 || len(resp.Env.Deny) != 1 || resp.File != path {
+		t.Errorf("Expected managed and env entries, got %+v", resp)
+	}
+
+	if w := put(`{"deny":["not-an-ip"]}`); w.Code != http.StatusBadRequest || decodeProblem(t, w)["code"] != codeInvalidRequestBody {
+		t.Errorf("Expected 400 for a bad entry, got %d", w.Code)
+	}
+	if reason := acl.check(net.ParseIP("203.0.113.50")); reason != "denylist" {
+		t.Errorf("Expected a rejected update to leave the list alone, got %q", reason)
+	}
+}
+
+func TestLoadConfig_IPAccessSettings(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("IP_ALLOWLIST", "10.0.0.0/8,office")
+	path := filepath.Join(t.TempDir(), "ip-acl")
+	os.WriteFile(path, []byte("deny 203.0.113.0/24\nallow\n"), 0o600)
+	t.Setenv("IP_ACL_FILE", path)
+	t.Setenv("IP_ACL_RELOAD_INTERVAL_SECONDS", "0")
+	t.Setenv("TRUSTED_PROXIES", "lb.internal")
+
+	_, err := LoadConfig()
+	for _, key := range []string{"IP_ALLOWLIST:", "IP_ACL_FILE line 2", "IP_ACL_RELOAD_INTERVAL_SECONDS", "TRUSTED_PROXIES:"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected a %s problem, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// outputLanguageNames are the languages output_language may name, by ISO
+// 639-1 code. The name is what the prompt asks the model to write in.
+var outputLanguageNames = map[string]string{
+	"ar": "Arabic", "bn": "Bengali", "de": "German", "en": "English",
+	"es": "Spanish", "fa": "Persian", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "id": "Indonesian", "it": "Italian", "ja": "Japanese",
+	"ko": "Korean", "nl": "Dutch", "pl": "Polish", "pt": "Portuguese",
+	"ru": "Russian", "sv": "Swedish", "sw": "Swahili", "ta": "Tamil",
+	"th": "Thai", "tr": "Turkish", "uk": "Ukrainian", "ur": "Urdu",
+	"vi": "Vietnamese", "zh": "Chinese",
+}
+
+// summaryOptions are the per-request settings that change the summary
+// itself, so they are part of the cache key and reach the prompt.
+type summaryOptions struct {
+	// OutputLanguage is an ISO 639-1 code, or "" for the source language.
+	OutputLanguage string
+	// Generation holds the clamped sampling parameters, if any were sent.
+	Generation generationParams
+}
+
+// cacheParams returns the options as getCacheKey params.
+func (o summaryOptions) cacheParams() map[string]string {
+	params := make(map[string]string)
+	if o.OutputLanguage != "" {
+		params["output_language"] = o.OutputLanguage
+	}
+	o.Generation.addCacheParams(params)
+	return params
+}
+
+// isZero reports whether o asks for nothing beyond a plain summary.
+func (o summaryOptions) isZero() bool {
+	return o == summaryOptions{}
+}
+
+// unsupportedLanguageError is returned for an output_language that is not
+// in SUPPORTED_OUTPUT_LANGUAGES.
+type unsupportedLanguageError struct {
+	Language  string
+	Supported []string
+}
+
+func (e *unsupportedLanguageError) Error() string {
+	return fmt.Sprintf("output_language %q is not supported", e.Language)
+}
+
+func unsupportedLanguageProblem(e *unsupportedLanguageError) *Problem {
+	return newProblem(422, codeUnsupportedLanguage, "Unsupported Output Language", e.Error()).
+		With("output_language", e.Language).
+		With("supported_languages", e.Supported)
+}
+
+// parseSupportedLanguages parses SUPPORTED_OUTPUT_LANGUAGES, a
+// comma-separated list of ISO 639-1 codes. Empty means every language in
+// outputLanguageNames.
+func parseSupportedLanguages(raw string) ([]string, error) {
+	var codes []string
+	for _, code := range strings.Split(raw, ",") {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if _, ok := outputLanguageNames[code]; !ok {
+			return nil, fmt.Errorf("unknown language code %q", code)
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		for code := range outputLanguageNames {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+// getSupportedLanguages returns the accepted output_language codes, sorted.
+func getSupportedLanguages() []string {
+	if appConfig != nil {
+		return appConfig.SupportedLanguages
+	}
+	codes, err := parseSupportedLanguages(os.Getenv("SUPPORTED_OUTPUT_LANGUAGES"))
+	if err != nil {
+		log.Printf("Warning: Invalid SUPPORTED_OUTPUT_LANGUAGES (%v), allowing all", err)
+		codes, _ = parseSupportedLanguages("")
+	}
+	return codes
+}
+
+// checkOutputLanguage normalizes a requested output_language ("PT" -> "pt")
+// and checks it is supported. "" means no translation.
+func checkOutputLanguage(requested string) (string, error) {
+	code := strings.ToLower(strings.TrimSpace(requested))
+	if code == "" {
+		return "", nil
+	}
+	supported := getSupportedLanguages()
+	if slices.Contains(supported, code) {
+		return code, nil
+	}
+	return "", &unsupportedLanguageError{Language: code, Supported: supported}
+}
+
+// getOutputLanguageSurcharge returns OUTPUT_LANGUAGE_SURCHARGE, the USDC
+// added to the price of a request that sets output_language, or "".
+func getOutputLanguageSurcharge() string {
+	return strings.TrimSpace(os.Getenv("OUTPUT_LANGUAGE_SURCHARGE"))
+}
+
+// withLanguageSurcharge adds OUTPUT_LANGUAGE_SURCHARGE to price when the
+// request asks for a translated summary.
+func withLanguageSurcharge(price string, opts summaryOptions) string {
+	surcharge := getOutputLanguageSurcharge()
+	if opts.OutputLanguage == "" || surcharge == "" {
+		return price
+	}
+	p, ok1 := new(big.Rat).SetString(price)
+	s, ok2 := new(big.Rat).SetString(surcharge)
+	if !ok1 || !ok2 {
+		return price
+	}
+	return formatAmount(p.Add(p, s))
+}
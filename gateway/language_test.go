@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func TestCheckOutputLanguage(t *testing.T) {
+	t.Setenv("SUPPORTED_OUTPUT_LANGUAGES", "es, PT,de")
+
+	if got, err := checkOutputLanguage(" PT "); err != nil || got != "pt" {
+		t.Errorf("Expected pt, got %q, %v", got, err)
+	}
+	if got, err := checkOutputLanguage(""); err != nil || got != "" {
+		t.Errorf("Expected no language, got %q, %v", got, err)
+	}
+	_, err := checkOutputLanguage("fr")
+	langErr, ok := err.(*unsupportedLanguageError)
+	if !ok || strings.Join(langErr.Supported, ",") != "de,es,pt" {
+		t.Errorf("Expected fr to be rejected with the sorted list, got %v", err)
+	}
+
+	if _, err := parseSupportedLanguages("es,xx"); err == nil {
+		t.Error("Expected an unknown code to be rejected")
+	}
+}
+
+func TestPriceFor_LanguageSurcharge(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_PER_1K_TOKENS", "")
+	t.Setenv("ALLOWED_MODELS", "")
+	t.Setenv("OUTPUT_LANGUAGE_SURCHARGE", "0.0005")
+
+	if got := priceFor(context.Background(), "", 0, 100, summaryOptions{}); got != "0.001" {
+		t.Errorf("Expected no surcharge without output_language, got %s", got)
+	}
+	if got := priceFor(context.Background(), "", 0, 100, summaryOptions{OutputLanguage: "es"}); got != "0.0015" {
+		t.Errorf("Expected 0.0015 with the surcharge, got %s", got)
+	}
+}
+
+func TestRender_LanguageInstruction(t *testing.T) {
+	t.Setenv("PROMPT_TEMPLATES_FILE", "")
+	got := promptFor("summarize").Render(promptData{Text: "Hello.", Language: "Spanish"})
+	if !strings.HasPrefix(got, "Summarize this text in 2 sentences: Hello.") || !strings.HasSuffix(got, "Write the summary in Spanish, whatever the language of the text.") {
+		t.Errorf("Unexpected prompt %q", got)
+	}
+
+	tmpl, err := newPromptTemplate("custom", 1, "{{if .Language}}In {{.Language}}: {{end}}{{.Text}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tmpl.Render(promptData{Text: "x", Language: "German"}); got != "In German: x" {
+		t.Errorf("Expected a template placing {{.Language}} itself to get no extra instruction, got %q", got)
+	}
+}
+
+func TestHandleSummarize_OutputLanguage(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.ReplyFunc(func(req testsupport.ChatRequest) (string, error) {
+		if strings.Contains(req.Prompt(), "in Spanish") {
+			return "Resumen.", nil
+		}
+		return "Summary.", nil
+	})
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("SUPPORTED_OUTPUT_LANGUAGES", "es")
+	r := setupVersionedRouter()
+
+	send := func(body, nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(body))
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(`{"text":"same text"}`, "n-lang-1"); w.Code != 200 || !strings.Contains(w.Body.String(), "Summary.") {
+		t.Fatalf("Expected the plain summary, got %d: %s", w.Code, w.Body.String())
+	}
+	w := send(`{"text":"same text","output_language":"ES"}`, "n-lang-2")
+	var resp SummarizeResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Result != "Resumen." || resp.OutputLanguage != "es" {
+		t.Errorf("Expected the Spanish summary, not the cached English one; got %d %+v", w.Code, resp)
+	}
+
+	w = send(`{"text":"same text","output_language":"fr"}`, "")
+	if w.Code != 422 {
+		t.Fatalf("Expected the quote to reject fr, got %d", w.Code)
+	}
+	if p := decodeProblem(t, w); p["code"] != codeUnsupportedLanguage {
+		t.Errorf("Expected %s, got %v", codeUnsupportedLanguage, p)
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// usageLedger records every paid request for billing reconciliation,
+// analytics and fraud review. It is nil unless LEDGER_BACKEND is set.
+var usageLedger ledgerStore
+
+var ledgerWriteErrorsTotal = newCounter(
+	"gateway_ledger_write_errors_total",
+	"Paid requests that could not be written to the usage ledger.",
+)
+
+// ledgerTypeRefund marks a ledger entry that returns a payment.
+const ledgerTypeRefund = "refund"
+
+// ledgerTypeSettlement marks a ledger entry recording a change in the state
+// of a payment's settlement transaction.
+const ledgerTypeSettlement = "settlement"
+
+// LedgerEntry is one paid request, the refund of one, or a change in the
+// state of its settlement.
+type LedgerEntry struct {
+	ReceiptID        string    `json:"receipt_id" example:"rcpt_a1b2c3d4e5f6"`
+	Time             time.Time `json:"time"`
+	Wallet           string    `json:"wallet" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Route            string    `json:"route" example:"/v1/ai/summarize"`
+	Nonce            string    `json:"nonce"`
+	Amount           string    `json:"amount" doc:"Amount charged, in Token" example:"0.001"`
+	Token            string    `json:"token,omitempty" example:"USDC"`
+	ChainID          int       `json:"chain_id,omitempty" example:"8453"`
+	Model            string    `json:"model,omitempty"`
+	Provider         string    `json:"provider,omitempty" doc:"AI provider that served the request; empty for cache hits"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	ProviderCost     string    `json:"provider_cost,omitempty" doc:"Upstream cost in USD, when the provider reports it"`
+	LatencyMS        int64     `json:"latency_ms"`
+	CacheHit         bool      `json:"cache_hit"`
+	PromoCode        string    `json:"promo_code,omitempty" doc:"Promo code redeemed by the request" example:"LAUNCH50"`
+	Discount         string    `json:"discount,omitempty" doc:"Amount the promo code took off the price, in Token" example:"0.0005"`
+	Type             string    `json:"type,omitempty" doc:"refund for a refund, in which case Amount is returned to Wallet; settlement for a settlement state change; empty for a paid request" example:"refund"`
+	RefundID         string    `json:"refund_id,omitempty" example:"rfnd_a1b2c3d4e5f6"`
+	Reason           string    `json:"reason,omitempty" doc:"Why the payment was refunded, or why its settlement changed state (reverted, dropped, reorg, resubmitted)" example:"ai_service_failed"`
+	TxHash           string    `json:"tx_hash,omitempty" doc:"Settlement transaction, when a facilitator settled the payment before the response or for a settlement entry"`
+	SettlementState  string    `json:"settlement_state,omitempty" doc:"pending, confirmed, finalized or failed, for a settlement entry" example:"confirmed"`
+	BlockNumber      uint64    `json:"block_number,omitempty" doc:"Block the settlement transaction was mined in, for a settlement entry"`
+	CacheKey         string    `json:"cache_key,omitempty" doc:"Cache entry the summary was read from or written to, so erasing the wallet's data can remove it"`
+	Tenant           string    `json:"tenant,omitempty" doc:"Tenant the request was served for, with MULTI_TENANT=true" example:"search"`
+}
+
+// anonymize removes what ties e to its wallet: the wallet itself, the
+// on-chain transaction and the cache key derived from the text. Amounts
+// stay, so revenue and provider spend still add up.
+func (e *LedgerEntry) anonymize() {
+	e.Wallet, e.TxHash, e.CacheKey = "", "", ""
+}
+
+// ledgerQuery selects entries. Zero fields don't filter; Limit keeps the
+// most recent entries.
+type ledgerQuery struct {
+	Wallet string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (q ledgerQuery) matches(e LedgerEntry) bool {
+	if q.Wallet != "" && !strings.EqualFold(q.Wallet, e.Wallet) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || e.Time.Before(q.Until)
+}
+
+// limit keeps the last q.Limit entries.
+func (q ledgerQuery) limit(entries []LedgerEntry) []LedgerEntry {
+	if q.Limit > 0 && len(entries) > q.Limit {
+		return entries[len(entries)-q.Limit:]
+	}
+	return entries
+}
+
+// errInvalidCursor is returned by ledgerStore.Page for a cursor it didn't
+// issue.
+var errInvalidCursor = errors.New("invalid ledger cursor")
+
+// ledgerStore is an append-only store of ledger entries, returned oldest
+// first. Entries are only ever removed by the retention purge.
+type ledgerStore interface {
+	Append(ctx context.Context, e LedgerEntry) error
+	Entries(ctx context.Context, q ledgerQuery) ([]LedgerEntry, error)
+	// Page returns up to limit entries matching q from the position cursor
+	// ("" for the start), with the cursor of the next page, or "" when
+	// the ledger is exhausted. q.Limit is ignored.
+	Page(ctx context.Context, q ledgerQuery, cursor string, limit int) ([]LedgerEntry, string, error)
+	// Purge removes the entries recorded before before and returns how
+	// many it removed.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	// Erase anonymizes wallet's entries and returns how many it changed.
+	Erase(ctx context.Context, wallet string) (int64, error)
+}
+
+// initLedger opens the ledger selected by LEDGER_BACKEND: "file" appends
+// JSON lines to LEDGER_FILE, "redis" adds to the LEDGER_REDIS_KEY stream
+// (default "ledger:entries"), "sql" inserts into the DATABASE_URL store.
+// LoadConfig has already validated the settings, so a failure here is only
+// logged.
+func initLedger() ledgerStore {
+	switch strings.ToLower(os.Getenv("LEDGER_BACKEND")) {
+	case "file":
+		l, err := newFileLedger(os.Getenv("LEDGER_FILE"))
+		if err != nil {
+			log.Printf("Warning: Usage ledger disabled: %v", err)
+			return nil
+		}
+		log.Printf("Usage ledger writing to %s", l.path)
+		return l
+	case "redis":
+		if redisClient == nil {
+			log.Println("Warning: Usage ledger disabled: Redis is unavailable")
+			return nil
+		}
+		key := os.Getenv("LEDGER_REDIS_KEY")
+		if key == "" {
+			key = "ledger:entries"
+		}
+		log.Printf("Usage ledger writing to Redis stream %s", key)
+		return &redisLedger{client: redisClient, key: key}
+	case "sql":
+		if dataStore == nil {
+			log.Println("Warning: Usage ledger disabled: the store (DATABASE_URL) is unavailable")
+			return nil
+		}
+		log.Println("Usage ledger writing to the store")
+		return dataStore
+	default:
+		return nil
+	}
+}
+
+// recordLedgerEntry appends e to the ledger, if enabled. The request has
+// already been paid for, so a write failure is logged and counted rather
+// than failing it.
+func recordLedgerEntry(ctx context.Context, e LedgerEntry) {
+	if usageLedger == nil {
+		return
+	}
+	if err := usageLedger.Append(context.WithoutCancel(ctx), e); err != nil {
+		ledgerWriteErrorsTotal.Inc()
+		log.Printf("error writing ledger entry for %s: %v", e.ReceiptID, err)
+	}
+}
+
+// fileLedger appends one JSON object per line to a file.
+type fileLedger struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func newFileLedger(path string) (*fileLedger, error) {
+	if path == "" {
+		return nil, fmt.Errorf("LEDGER_FILE is not set")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening ledger file: %w", err)
+	}
+	return &fileLedger{path: path, f: f}, nil
+}
+
+func (l *fileLedger) Append(_ context.Context, e LedgerEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.f.Write(append(line, '\n'))
+	return err
+}
+
+func (l *fileLedger) Entries(_ context.Context, q ledgerQuery) ([]LedgerEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A line torn by a crash mid-write; the rest is still usable
+			continue
+		}
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return q.limit(entries), scanner.Err()
+}
+
+// Page reads from the byte offset in cursor, so a page costs no more than
+// the lines it covers.
+func (l *fileLedger) Page(_ context.Context, q ledgerQuery, cursor string, limit int) ([]LedgerEntry, string, error) {
+	var offset int64
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.ParseInt(cursor, 10, 64); err != nil || offset < 0 {
+			return nil, "", errInvalidCursor
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	var entries []LedgerEntry
+	r := bufio.NewReader(f)
+	for len(entries) < limit {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Nothing left, or a line still being written
+			return entries, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		offset += int64(len(line))
+		var e LedgerEntry
+		if json.Unmarshal(line, &e) == nil && q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, strconv.FormatInt(offset, 10), nil
+}
+
+// Purge rewrites the file without the entries before before.
+func (l *fileLedger) Purge(_ context.Context, before time.Time) (int64, error) {
+	return l.rewrite(func(e *LedgerEntry) (bool, bool) {
+		return !e.Time.Before(before), false
+	})
+}
+
+// Erase rewrites the file with wallet's entries anonymized.
+func (l *fileLedger) Erase(_ context.Context, wallet string) (int64, error) {
+	return l.rewrite(func(e *LedgerEntry) (bool, bool) {
+		if !strings.EqualFold(e.Wallet, wallet) {
+			return true, false
+		}
+		e.anonymize()
+		return true, true
+	})
+}
+
+// rewrite passes every entry through edit, which reports whether to keep
+// it and whether it changed it, and swaps the result in with a rename when
+// anything was dropped or changed, returning how many entries were. Page
+// cursors are byte offsets, so one taken before a rewrite no longer lines
+// up. Torn lines are kept as they are.
+func (l *fileLedger) rewrite(edit func(e *LedgerEntry) (keep, changed bool)) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	src, err := os.Open(l.path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".rewrite-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	var affected int64
+	w := bufio.NewWriter(tmp)
+	r := bufio.NewReader(src)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var e LedgerEntry
+			if json.Unmarshal(line, &e) == nil {
+				keep, changed := edit(&e)
+				if !keep || changed {
+					affected++
+				}
+				if !keep {
+					line = nil
+				} else if changed {
+					data, merr := json.Marshal(e)
+					if merr != nil {
+						tmp.Close()
+						return 0, merr
+					}
+					line = append(data, '\n')
+				}
+			}
+			if _, werr := w.Write(line); werr != nil {
+				tmp.Close()
+				return 0, werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if affected == 0 {
+		tmp.Close()
+		return 0, nil
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return affected, fmt.Errorf("reopening ledger file: %w", err)
+	}
+	l.f.Close()
+	l.f = f
+	return affected, nil
+}
+
+// redisLedger adds entries to a Redis stream, whose IDs are ordered by
+// time, so time ranges map onto XRANGE. The stream is only trimmed by the
+// retention purge.
+type redisLedger struct {
+	client *redis.Client
+	key    string
+}
+
+func (l *redisLedger) Append(ctx context.Context, e LedgerEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return l.client.XAdd(ctx, &redis.XAddArgs{Stream: l.key, Values: map[string]interface{}{"entry": data}}).Err()
+}
+
+func (l *redisLedger) Entries(ctx context.Context, q ledgerQuery) ([]LedgerEntry, error) {
+	start, end := "-", "+"
+	if !q.Since.IsZero() {
+		start = strconv.FormatInt(q.Since.UnixMilli(), 10)
+	}
+	if !q.Until.IsZero() {
+		end = strconv.FormatInt(q.Until.UnixMilli(), 10)
+	}
+	msgs, err := l.client.XRange(ctx, l.key, start, end).Result()
+	if err != nil {
+		return nil, err
+	}
+	var entries []LedgerEntry
+	for _, msg := range msgs {
+		raw, _ := msg.Values["entry"].(string)
+		var e LedgerEntry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			log.Printf("ledger entry %s is corrupt: %v", msg.ID, err)
+			continue
+		}
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return q.limit(entries), nil
+}
+
+// redisStreamID matches the IDs Redis assigns to stream entries.
+var redisStreamID = regexp.MustCompile(`^\d+-\d+$`)
+
+// Page continues after the stream ID in cursor.
+func (l *redisLedger) Page(ctx context.Context, q ledgerQuery, cursor string, limit int) ([]LedgerEntry, string, error) {
+	start, end := "-", "+"
+	if !q.Since.IsZero() {
+		start = strconv.FormatInt(q.Since.UnixMilli(), 10)
+	}
+	if cursor != "" {
+		if !redisStreamID.MatchString(cursor) {
+			return nil, "", errInvalidCursor
+		}
+		start = "(" + cursor
+	}
+	if !q.Until.IsZero() {
+		end = strconv.FormatInt(q.Until.UnixMilli(), 10)
+	}
+
+	var entries []LedgerEntry
+	for len(entries) < limit {
+		want := limit - len(entries)
+		msgs, err := l.client.XRangeN(ctx, l.key, start, end, int64(want)).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, msg := range msgs {
+			raw, _ := msg.Values["entry"].(string)
+			var e LedgerEntry
+			if err := json.Unmarshal([]byte(raw), &e); err != nil {
+				log.Printf("ledger entry %s is corrupt: %v", msg.ID, err)
+			} else if q.matches(e) {
+				entries = append(entries, e)
+			}
+			start = "(" + msg.ID
+		}
+		if len(msgs) < want {
+			return entries, "", nil
+		}
+	}
+	return entries, strings.TrimPrefix(start, "("), nil
+}
+
+// Purge trims the stream by ID, which is the time each entry was added.
+func (l *redisLedger) Purge(ctx context.Context, before time.Time) (int64, error) {
+	return l.client.XTrimMinID(ctx, l.key, strconv.FormatInt(before.UnixMilli(), 10)).Result()
+}
+
+// Erase deletes wallet's entries: stream entries can't be changed, so
+// unlike the other ledgers the Redis one doesn't keep them anonymized.
+func (l *redisLedger) Erase(ctx context.Context, wallet string) (int64, error) {
+	msgs, err := l.client.XRange(ctx, l.key, "-", "+").Result()
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for _, msg := range msgs {
+		raw, _ := msg.Values["entry"].(string)
+		var e LedgerEntry
+		if json.Unmarshal([]byte(raw), &e) == nil && strings.EqualFold(e.Wallet, wallet) {
+			ids = append(ids, msg.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return l.client.XDel(ctx, l.key, ids...).Result()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// setupTestLedger installs a file ledger in a temp dir for the test.
+func setupTestLedger(t *testing.T) *fileLedger {
+	t.Helper()
+	l, err := newFileLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := usageLedger
+	usageLedger = l
+	t.Cleanup(func() {
+		usageLedger = prev
+		l.f.Close()
+	})
+	return l
+}
+
+func testLedgerStores(t *testing.T) map[string]ledgerStore {
+	setupTestRedis(t)
+	stores := map[string]ledgerStore{
+		"file":  setupTestLedger(t),
+		"redis": &redisLedger{client: redisClient, key: "ledger:test"},
+	}
+	if sqliteDriver != "" {
+		stores["sql"] = setupTestStore(t)
+	}
+	return stores
+}
+
+func TestLedgerStores_AppendAndQuery(t *testing.T) {
+	base := time.Now().UTC().Truncate(time.Millisecond)
+	for name, store := range testLedgerStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i, wallet := range []string{"0xaa", "0xbb", "0xaa"} {
+				// Redis stream IDs come from the wall clock, so the
+				// entries are written in time order
+				time.Sleep(2 * time.Millisecond)
+				e := LedgerEntry{ReceiptID: "rcpt_" + string(rune('a'+i)), Time: time.Now().UTC(), Wallet: wallet, Amount: "0.001"}
+				if err := store.Append(ctx, e); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			all, err := store.Entries(ctx, ledgerQuery{Since: base})
+			if err != nil || len(all) != 3 || all[0].ReceiptID != "rcpt_a" {
+				t.Fatalf("Expected 3 entries oldest first, got %+v, %v", all, err)
+			}
+			mine, _ := store.Entries(ctx, ledgerQuery{Wallet: "0xAA"})
+			if len(mine) != 2 {
+				t.Errorf("Expected 2 entries for 0xaa, got %d", len(mine))
+			}
+			last, _ := store.Entries(ctx, ledgerQuery{Limit: 1})
+			if len(last) != 1 || last[0].ReceiptID != "rcpt_c" {
+				t.Errorf("Expected the most recent entry, got %+v", last)
+			}
+			if none, _ := store.Entries(ctx, ledgerQuery{Since: time.Now().Add(time.Hour)}); len(none) != 0 {
+				t.Errorf("Expected no entries in the future, got %d", len(none))
+			}
+		})
+	}
+}
+
+func TestLedgerStores_Purge(t *testing.T) {
+	for name, store := range testLedgerStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store.Append(ctx, LedgerEntry{ReceiptID: "rcpt_old", Time: time.Now().UTC()})
+			time.Sleep(5 * time.Millisecond)
+			cutoff := time.Now()
+			time.Sleep(5 * time.Millisecond)
+			store.Append(ctx, LedgerEntry{ReceiptID: "rcpt_new", Time: time.Now().UTC()})
+
+			if n, err := store.Purge(ctx, cutoff); err != nil || n != 1 {
+				t.Fatalf("Expected 1 entry purged, got %d, %v", n, err)
+			}
+			// Appends carry on after the purge
+			store.Append(ctx, LedgerEntry{ReceiptID: "rcpt_next", Time: time.Now().UTC()})
+			entries, _ := store.Entries(ctx, ledgerQuery{})
+			if len(entries) != 2 || entries[0].ReceiptID != "rcpt_new" || entries[1].ReceiptID != "rcpt_next" {
+				t.Errorf("Expected the newer entries kept, got %+v", entries)
+			}
+		})
+	}
+}
+
+func TestLedgerStores_Erase(t *testing.T) {
+	for name, store := range testLedgerStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store.Append(ctx, LedgerEntry{ReceiptID: "rcpt_a", Time: time.Now().UTC(), Wallet: "0xaa", Amount: "0.001", TxHash: "0xtx"})
+			store.Append(ctx, LedgerEntry{ReceiptID: "rcpt_b", Time: time.Now().UTC(), Wallet: "0xbb", Amount: "0.002"})
+
+			if n, err := store.Erase(ctx, "0xAA"); err != nil || n != 1 {
+				t.Fatalf("Expected 1 entry erased, got %d, %v", n, err)
+			}
+			if mine, _ := store.Entries(ctx, ledgerQuery{Wallet: "0xaa"}); len(mine) != 0 {
+				t.Errorf("Expected nothing left for the wallet, got %+v", mine)
+			}
+			entries, _ := store.Entries(ctx, ledgerQuery{})
+			if name == "redis" {
+				// Stream entries can't be rewritten, only deleted
+				if len(entries) != 1 || entries[0].ReceiptID != "rcpt_b" {
+					t.Errorf("Expected only the other wallet's entry left, got %+v", entries)
+				}
+				return
+			}
+			if len(entries) != 2 || entries[0].Wallet != "" || entries[0].TxHash != "" || entries[0].Amount != "0.001" || entries[1].Wallet != "0xbb" {
+				t.Errorf("Expected the entry anonymized in place, got %+v", entries)
+			}
+		})
+	}
+}
+
+func TestHandleSummarize_WritesLedger(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	ledger := setupTestLedger(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Usage(40, 10, 0.00005)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+
+	for _, nonce := range []string{"n-ledger-1", "n-ledger-2"} {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"ledger text"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	entries, err := ledger.Entries(context.Background(), ledgerQuery{})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected 2 ledger entries, got %d, %v", len(entries), err)
+	}
+	miss, hit := entries[0], entries[1]
+	if miss.Nonce != "n-ledger-1" || miss.Wallet != strings.ToLower(testsupport.DefaultPayer) || miss.Route != "/v1/ai/summarize" ||
+		miss.Provider != "openrouter" || miss.PromptTokens != 40 || miss.ProviderCost != "0.00005" || miss.CacheHit {
+		t.Errorf("Unexpected entry for the AI call: %+v", miss)
+	}
+	if !hit.CacheHit || hit.Provider != "" || hit.PromptTokens != 0 || hit.Amount == "" {
+		t.Errorf("Unexpected entry for the cache hit: %+v", hit)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unixAddrPrefix marks a listen address as a Unix domain socket path.
+const unixAddrPrefix = "unix:"
+
+// listenAddr is one address the gateway listens on.
+type listenAddr struct {
+	network string // "tcp" or "unix"
+	address string
+	// admin listeners are the only ones serving /admin and /debug once any
+	// is configured
+	admin bool
+}
+
+func (a listenAddr) String() string {
+	if a.network == "unix" {
+		return unixAddrPrefix + a.address
+	}
+	return a.address
+}
+
+// parseListenAddrs parses a comma-separated list such as
+// ":3000,unix:/run/paygate/gateway.sock". TCP addresses are host:port with
+// an optional host.
+func parseListenAddrs(raw string, admin bool) ([]listenAddr, error) {
+	var addrs []listenAddr
+	for _, entry := range splitList(raw) {
+		if path, ok := strings.CutPrefix(entry, unixAddrPrefix); ok {
+			if path == "" {
+				return nil, fmt.Errorf("%q has no socket path", entry)
+			}
+			addrs = append(addrs, listenAddr{network: "unix", address: path, admin: admin})
+			continue
+		}
+		_, port, err := net.SplitHostPort(entry)
+		if n, perr := strconv.Atoi(port); err != nil || perr != nil || n < 0 || n > 65535 {
+			return nil, fmt.Errorf("%q must be host:port or unix:/path", entry)
+		}
+		addrs = append(addrs, listenAddr{network: "tcp", address: entry, admin: admin})
+	}
+	return addrs, nil
+}
+
+// getListenAddrs returns every address to listen on: LISTEN_ADDRS (default
+// ":" + port) plus ADMIN_LISTEN_ADDRS. LoadConfig has already validated
+// both.
+func getListenAddrs(port string) []listenAddr {
+	raw := os.Getenv("LISTEN_ADDRS")
+	if raw == "" {
+		raw = ":" + port
+	}
+	addrs, _ := parseListenAddrs(raw, false)
+	admin, _ := parseListenAddrs(os.Getenv("ADMIN_LISTEN_ADDRS"), true)
+	return append(addrs, admin...)
+}
+
+// getListenSocketMode returns LISTEN_SOCKET_MODE, the permissions given to
+// Unix sockets, as octal (default 0660 so a proxy in the same group can
+// connect).
+func getListenSocketMode() os.FileMode {
+	mode, err := strconv.ParseUint(os.Getenv("LISTEN_SOCKET_MODE"), 8, 32)
+	if err != nil {
+		return 0o660
+	}
+	return os.FileMode(mode)
+}
+
+// openListeners binds every address, closing the ones already opened if
+// any fails. A stale socket file left by a previous run is removed first.
+func openListeners(addrs []listenAddr) ([]net.Listener, error) {
+	var listeners []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+	for _, a := range addrs {
+		if a.network == "unix" {
+			if info, err := os.Lstat(a.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+				os.Remove(a.address)
+			}
+		}
+		l, err := net.Listen(a.network, a.address)
+		if err != nil {
+			return fail(fmt.Errorf("listen on %s: %w", a, err))
+		}
+		listeners = append(listeners, l)
+		if a.network == "unix" {
+			if err := os.Chmod(a.address, getListenSocketMode()); err != nil {
+				return fail(fmt.Errorf("chmod %s: %w", a.address, err))
+			}
+		}
+	}
+	return listeners, nil
+}
+
+// adminListenerKey marks the context of connections accepted on an admin
+// listener.
+type adminListenerKey struct{}
+
+// serveListeners serves srv on every listener, reporting the first failure
+// on errs. Connections on admin listeners are tagged so
+// AdminListenerMiddleware can tell them apart.
+func serveListeners(srv *http.Server, addrs []listenAddr, listeners []net.Listener, errs chan<- error) {
+	admin := make(map[net.Listener]bool)
+	for i, l := range listeners {
+		admin[l] = addrs[i].admin
+	}
+	srv.BaseContext = func(l net.Listener) context.Context {
+		return context.WithValue(context.Background(), adminListenerKey{}, admin[l])
+	}
+	for i, l := range listeners {
+		go func() {
+			log.Printf("Go Gateway listening on %s", addrs[i])
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+		}()
+	}
+}
+
+// AdminListenerMiddleware hides the operator endpoints on public listeners
+// once ADMIN_LISTEN_ADDRS is set: they answer 404 as if the route didn't
+// exist, and only the admin listeners serve them.
+func AdminListenerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv("ADMIN_LISTEN_ADDRS") == "" {
+			c.Next()
+			return
+		}
+		if admin, _ := c.Request.Context().Value(adminListenerKey{}).(bool); !admin {
+			handleNoRoute(c)
+			return
+		}
+		c.Next()
+	}
+}
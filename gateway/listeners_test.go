@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseListenAddrs(t *testing.T) {
+	addrs, err := parseListenAddrs(":3000, 127.0.0.1:3001,unix:/run/paygate.sock", false)
+	if err != nil || len(addrs) != 3 {
+		t.Fatalf("Expected 3 addresses, got %v %v", addrs, err)
+	}
+	if addrs[2].network != "unix" || addrs[2].address != "/run/paygate.sock" || addrs[1].String() != "127.0.0.1:3001" {
+		t.Errorf("Unexpected addresses %+v", addrs)
+	}
+	for _, raw := range []string{"3000", "localhost:http", "unix:", ":70000"} {
+		if _, err := parseListenAddrs(raw, false); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestOpenListeners_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+	t.Setenv("LISTEN_SOCKET_MODE", "0600")
+	// A socket left behind by a crashed run
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := openListeners([]listenAddr{{network: "unix", address: path}})
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced, got %v", err)
+	}
+	defer listeners[0].Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v %v", info.Mode(), err)
+	}
+
+	regular := filepath.Join(t.TempDir(), "not-a-socket")
+	os.WriteFile(regular, []byte("keep"), 0o600)
+	if _, err := openListeners([]listenAddr{{network: "unix", address: regular}}); err == nil {
+		t.Error("Expected a regular file not to be removed")
+	}
+}
+
+func TestServeListeners_AdminOnAdminListenerOnly(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	t.Setenv("ADMIN_LISTEN_ADDRS", "unix:"+filepath.Join(t.TempDir(), "admin.sock"))
+	addrs := []listenAddr{{network: "tcp", address: "127.0.0.1:0"}}
+	admin, _ := parseListenAddrs(os.Getenv("ADMIN_LISTEN_ADDRS"), true)
+	addrs = append(addrs, admin...)
+	listeners, err := openListeners(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: setupAdminRouter()}
+	errs := make(chan error, 1)
+	serveListeners(srv, addrs, listeners, errs)
+	defer srv.Shutdown(context.Background())
+
+	get := func(client *http.Client, url string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(http.DefaultClient, "http://"+listeners[0].Addr().String()+"/admin/stats"); code != 404 {
+		t.Errorf("Expected 404 for admin on the public listener, got %d", code)
+	}
+	socket := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", addrs[1].address)
+	}}}
+	if code := get(socket, "http://gateway/admin/stats"); code != 200 {
+		t.Errorf("Expected 200 for admin on the admin socket, got %d", code)
+	}
+
+	srv.Shutdown(context.Background())
+	if _, err := os.Stat(addrs[1].address); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the socket to be removed on shutdown, got %v", err)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("Unexpected serve error %v", err)
+	default:
+	}
+}
+
+func TestLoadConfig_ListenAddrs(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("LISTEN_ADDRS", ":3000,3001")
+	t.Setenv("ADMIN_LISTEN_ADDRS", "unix:")
+	t.Setenv("LISTEN_SOCKET_MODE", "rw")
+
+	_, err := LoadConfig()
+	for _, key := range []string{"LISTEN_ADDRS", "ADMIN_LISTEN_ADDRS", "LISTEN_SOCKET_MODE"} {
+		if err == nil || !strings.Contains(err.Error(), key+":") {
+			t.Errorf("Expected a %s problem, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencySampleTTL is how long the last provider latency counts towards
+// load; after that the providers are taken to be idle.
+const latencySampleTTL = time.Minute
+
+var loadPricedQuotesTotal = newCounter(
+	"gateway_load_priced_quotes_total",
+	"Payment contexts whose price was raised for the gateway's load, with LOAD_PRICING.",
+)
+
+// providerLatency keeps a moving average of successful AI call latency,
+// one of the load signals.
+var providerLatency = &latencyAverage{}
+
+type latencyAverage struct {
+	mu       sync.Mutex
+	average  time.Duration
+	sampleAt time.Time
+}
+
+// observe folds d into the average, weighting the newest call by a fifth.
+func (l *latencyAverage) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sampleAt.IsZero() || time.Since(l.sampleAt) > latencySampleTTL {
+		l.average = d
+	} else {
+		l.average += (d - l.average) / 5
+	}
+	l.sampleAt = time.Now()
+}
+
+// current returns the average, or 0 when no call finished recently.
+func (l *latencyAverage) current() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sampleAt.IsZero() || time.Since(l.sampleAt) > latencySampleTTL {
+		return 0
+	}
+	return l.average
+}
+
+// loadPricingEnabled reports whether LOAD_PRICING=true raises prices with
+// the gateway's load.
+func loadPricingEnabled() bool {
+	return strings.ToLower(os.Getenv("LOAD_PRICING")) == "true"
+}
+
+// getLoadQuoteTTL returns LOAD_PRICING_QUOTE_SECONDS (default 30), how long
+// a raised price is honoured.
+func getLoadQuoteTTL() time.Duration {
+	return time.Duration(getEnvAsInt("LOAD_PRICING_QUOTE_SECONDS", 30)) * time.Second
+}
+
+// getMaxLoadMultiplier returns LOAD_PRICING_MAX_MULTIPLIER (default 3), the
+// most the price is raised by.
+func getMaxLoadMultiplier() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("LOAD_PRICING_MAX_MULTIPLIER"), 64); err == nil && v >= 1 {
+		return v
+	}
+	return 3
+}
+
+// currentLoad returns the price multiplier for the gateway's load in
+// percent, 100 when LOAD_PRICING is off or the load is within its targets.
+// Load is the larger of the requests in flight over
+// LOAD_PRICING_TARGET_IN_FLIGHT (default 50) and the average provider
+// latency over LOAD_PRICING_TARGET_LATENCY_MS (default 5000); past 1 the
+// price is raised in proportion, in steps of 10%, up to
+// LOAD_PRICING_MAX_MULTIPLIER.
+func currentLoad() int {
+	if !loadPricingEnabled() {
+		return 100
+	}
+	load := float64(InFlightRequestCount()) / float64(getEnvAsInt("LOAD_PRICING_TARGET_IN_FLIGHT", 50))
+	targetLatency := time.Duration(getEnvAsInt("LOAD_PRICING_TARGET_LATENCY_MS", 5000)) * time.Millisecond
+	load = max(load, float64(providerLatency.current())/float64(targetLatency))
+	if load <= 1 {
+		return 100
+	}
+	return min(int(math.Ceil(load*10))*10, int(getMaxLoadMultiplier()*100))
+}
+
+// applyLoad raises amount by load percent, rounded up to the token's
+// decimals.
+func applyLoad(amount string, load int) string {
+	if load <= 100 {
+		return amount
+	}
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return amount
+	}
+	return formatAmount(r.Mul(r, big.NewRat(int64(load), 100)))
+}
+
+// formatLoad renders a load in percent as a multiplier ("1.5"), or "" when
+// the price isn't raised.
+func formatLoad(load int) string {
+	if load <= 100 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimRight(strconv.FormatFloat(float64(load)/100, 'f', 2, 64), "0"), ".")
+}
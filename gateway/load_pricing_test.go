@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// withInFlight pretends n more requests are in flight for the test.
+func withInFlight(t *testing.T, n int64) {
+	inFlightCount.Add(n)
+	t.Cleanup(func() { inFlightCount.Add(-n) })
+}
+
+func TestCurrentLoad(t *testing.T) {
+	t.Setenv("LOAD_PRICING_TARGET_IN_FLIGHT", "4")
+	t.Setenv("LOAD_PRICING_TARGET_LATENCY_MS", "1000")
+	t.Setenv("LOAD_PRICING_MAX_MULTIPLIER", "2.5")
+	prev := providerLatency
+	providerLatency = &latencyAverage{}
+	defer func() { providerLatency = prev }()
+	withInFlight(t, 5)
+
+	if load := currentLoad(); load != 100 {
+		t.Errorf("Expected no change with LOAD_PRICING off, got %d", load)
+	}
+	t.Setenv("LOAD_PRICING", "true")
+	if load := currentLoad(); load != 130 {
+		t.Errorf("Expected 5 of 4 in flight to round up to 130%%, got %d", load)
+	}
+	providerLatency.observe(2 * time.Second)
+	if load := currentLoad(); load != 200 {
+		t.Errorf("Expected double the target latency to double the price, got %d", load)
+	}
+	providerLatency.observe(10 * time.Second)
+	if load := currentLoad(); load != 250 {
+		t.Errorf("Expected the multiplier capped at LOAD_PRICING_MAX_MULTIPLIER, got %d", load)
+	}
+	providerLatency.sampleAt = time.Now().Add(-2 * latencySampleTTL)
+	if load := currentLoad(); load != 130 {
+		t.Errorf("Expected an old latency sample to be ignored, got %d", load)
+	}
+
+	if got := applyLoad("0.001", 150); got != "0.0015" {
+		t.Errorf("Expected 0.0015, got %s", got)
+	}
+	for load, want := range map[int]string{100: "", 130: "1.3", 200: "2"} {
+		if got := formatLoad(load); got != want {
+			t.Errorf("formatLoad(%d) = %q, want %q", load, got, want)
+		}
+	}
+}
+
+func TestLoadNonce(t *testing.T) {
+	t.Setenv("NONCE_SECRET", strings.Repeat("s", 32))
+	t.Setenv("LOAD_PRICING_QUOTE_SECONDS", "20")
+	nonce, expiresAt := issueNonce(summarizeRoute, 150)
+	if !strings.HasPrefix(nonce, loadNonceVersion+".") || time.Until(expiresAt) > 20*time.Second {
+		t.Fatalf("Expected a short-lived n2 nonce, got %s expiring %v", nonce, expiresAt)
+	}
+	if reason := checkNonce(nonce, summarizeRoute, time.Now()); reason != "" || nonceLoad(nonce) != 150 {
+		t.Fatalf("Expected the nonce accepted at 150%%, got %q, %d", reason, nonceLoad(nonce))
+	}
+
+	parts := strings.Split(nonce, ".")
+	parts[2] = "100" // claim the normal price
+	if reason := checkNonce(strings.Join(parts, "."), summarizeRoute, time.Now()); reason != "invalid" {
+		t.Errorf("Expected a tampered load to be invalid, got %q", reason)
+	}
+	if nonce, _ := issueNonce(summarizeRoute, 100); nonceLoad(nonce) != 100 || !strings.HasPrefix(nonce, nonceVersion+".") {
+		t.Errorf("Expected an n1 nonce without load, got %s", nonce)
+	}
+}
+
+func TestHandleSummarize_HonoursQuotedLoad(t *testing.T) {
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("LOAD_PRICING", "true")
+	t.Setenv("LOAD_PRICING_TARGET_IN_FLIGHT", "2")
+	r := setupVersionedRouter()
+
+	send := func(nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"priced under load"}`))
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	inFlightCount.Add(3)
+	p := decodeProblem(t, send(""))
+	inFlightCount.Add(-3)
+	payment, _ := p["paymentContext"].(map[string]interface{})
+	if p["load_multiplier"] != "1.5" || payment["amount"] != "0.0015" {
+		t.Fatalf("Expected the raised price advertised in the 402, got %v", p)
+	}
+
+	// The load has passed, but the quoted price holds for the nonce
+	if w := send(payment["nonce"].(string)); w.Code != http.StatusOK {
+		t.Fatalf("Expected the quoted nonce to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if reqs := verifier.Requests(); len(reqs) != 1 || reqs[0].Context.Amount != "0.0015" {
+		t.Errorf("Expected the payment verified at the quoted 0.0015, got %+v", reqs)
+	}
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// InitLogger makes every log line a JSON object ({"time", "level", "msg",
+// ...}) written to stdout and, with LOG_FILE, to a file rotated by size and
+// age, so deployments without a log shipper keep their logs across
+// container restarts. Settings:
+//
+//   - LOG_LEVEL: debug, info (default), warn or error
+//   - LOG_FORMAT: json (default) or console, readable lines for local
+//     development, colored when stdout is a terminal; the file stays JSON
+//   - LOG_CALLER: add the calling file and line (default true at debug level)
+//   - LOG_FILE: file to write; rotated files get a timestamp suffix
+//   - LOG_FILE_MAX_SIZE_MB: rotate when the file reaches this size (default 100)
+//   - LOG_FILE_MAX_AGE_DAYS: delete rotated files older than this (default 7; 0 keeps them)
+//   - LOG_FILE_MAX_BACKUPS: rotated files to keep (default 5; 0 keeps all)
+//   - LOG_FILE_COMPRESS: gzip rotated files (default false)
+//   - LOG_STDOUT: also write to stdout (default true; false needs LOG_FILE)
+//   - LOG_REDACT: mask signatures, API keys and request text (default true)
+//   - LOG_REDACT_KEYS: more attribute names whose values are masked
+//   - LOG_DEBUG_SAMPLE: log 1 in N of each debug message (default 1, all)
+//
+// Existing log.Printf calls go through the same handler; lines starting
+// with "Warning" are logged at warn and lines starting with "error" at
+// error. The returned closer, nil without LOG_FILE, closes the file on
+// shutdown. LoadConfig has already validated the settings.
+func InitLogger() io.Closer {
+	level := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	opts := &slog.HandlerOptions{Level: level, AddSource: logCaller(level)}
+	stdout := strings.ToLower(os.Getenv("LOG_STDOUT")) != "false"
+	console := stdout && strings.ToLower(os.Getenv("LOG_FORMAT")) == "console"
+
+	var sinks []io.Writer
+	if stdout && !console {
+		sinks = append(sinks, os.Stdout)
+	}
+	var file *lumberjack.Logger
+	if path := os.Getenv("LOG_FILE"); path != "" {
+		file = &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    getEnvAsInt("LOG_FILE_MAX_SIZE_MB", 100),
+			MaxAge:     getEnvAsInt("LOG_FILE_MAX_AGE_DAYS", 7),
+			MaxBackups: getEnvAsInt("LOG_FILE_MAX_BACKUPS", 5),
+			Compress:   strings.ToLower(os.Getenv("LOG_FILE_COMPRESS")) == "true",
+		}
+		sinks = append(sinks, file)
+	}
+
+	var handler slog.Handler
+	if len(sinks) > 0 {
+		handler = slog.NewJSONHandler(io.MultiWriter(sinks...), opts)
+	}
+	if console {
+		consoleHandler := newConsoleHandler(os.Stdout, opts, isColorTerminal(os.Stdout))
+		if handler != nil {
+			handler = teeHandler{consoleHandler, handler}
+		} else {
+			handler = consoleHandler
+		}
+	}
+	if every := getEnvAsInt("LOG_DEBUG_SAMPLE", 1); every > 1 {
+		handler = newSamplingHandler(handler, every)
+	}
+	if strings.ToLower(os.Getenv("LOG_REDACT")) != "false" {
+		handler = newRedactingHandler(handler, splitList(os.Getenv("LOG_REDACT_KEYS")))
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(stdLogBridge{logger})
+	if file != nil {
+		slog.Info("Logging to file", "path", file.Filename, "max_size_mb", file.MaxSize, "max_age_days", file.MaxAge, "max_backups", file.MaxBackups)
+		return file
+	}
+	return nil
+}
+
+// logCaller reports whether log lines carry their caller: LOG_CALLER when
+// set, otherwise only at debug level, where finding the line matters more
+// than the cost of looking it up.
+func logCaller(level slog.Level) bool {
+	if raw := os.Getenv("LOG_CALLER"); raw != "" {
+		return strings.ToLower(raw) == "true"
+	}
+	return level <= slog.LevelDebug
+}
+
+// parseLogLevel maps LOG_LEVEL to a level; anything unknown is info.
+func parseLogLevel(raw string) slog.Level {
+	switch strings.ToLower(raw) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// stdLogBridge sends lines written by the log package to the slog logger,
+// guessing the level from the conventional prefixes used in this codebase.
+type stdLogBridge struct {
+	logger *slog.Logger
+}
+
+func (b stdLogBridge) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := slog.LevelInfo
+	switch lower := strings.ToLower(msg); {
+	case strings.HasPrefix(lower, "warning"):
+		level = slog.LevelWarn
+	case strings.HasPrefix(lower, "error"):
+		level = slog.LevelError
+	}
+	ctx := context.Background()
+	if !b.logger.Enabled(ctx, level) {
+		return len(p), nil
+	}
+	// Attribute the line to the log.Printf caller rather than to this method
+	var pcs [8]uintptr
+	n := runtime.Callers(2, pcs[:])
+	var pc uintptr
+	for _, candidate := range pcs[:n] {
+		if frame, _ := runtime.CallersFrames([]uintptr{candidate}).Next(); !strings.HasPrefix(frame.Function, "log.") {
+			pc = candidate
+			break
+		}
+	}
+	b.logger.Handler().Handle(ctx, slog.NewRecord(time.Now(), level, msg, pc))
+	return len(p), nil
+}
+
+// RequestLogger logs one structured line per request, replacing gin's text
+// access log: method, path, status, latency, request and response sizes,
+// client IP and request ID, and the time spent in each stage of the request
+// (see stageTimings). 5xx responses are logged at error. Requests
+// slower than LOG_SLOW_REQUEST_MS get "slow": true, and those whose request
+// or response body exceeds LOG_LARGE_PAYLOAD_BYTES get "large": true; both
+// are logged at warn, so the prompts that run into the AI timeout can be
+// found without debug logging. Zero, the default, disables either.
+func RequestLogger() gin.HandlerFunc {
+	slowAfter := time.Duration(getEnvAsInt("LOG_SLOW_REQUEST_MS", 0)) * time.Millisecond
+	largeAbove := int64(getEnvAsInt("LOG_LARGE_PAYLOAD_BYTES", 0))
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		// Bodies sent without a length are counted as the handler reads them
+		declared := c.Request.ContentLength
+		var body *countingReadCloser
+		if declared < 0 && c.Request.Body != nil {
+			body = &countingReadCloser{ReadCloser: c.Request.Body}
+			c.Request.Body = body
+		}
+		ctx, stages := withStageTimings(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		latency := time.Since(start)
+		status := c.Writer.Status()
+		requestBytes := max(declared, 0)
+		if body != nil {
+			requestBytes = body.n
+		}
+		responseBytes := int64(max(c.Writer.Size(), 0))
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Int64("latency_ms", latency.Milliseconds()),
+		}
+		attrs = append(attrs, stages.finish()...)
+		attrs = append(attrs,
+			slog.Int64("request_bytes", requestBytes),
+			slog.Int64("bytes", responseBytes),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("request_id", c.GetString(requestIDKey)),
+		)
+		if id := tenantID(c.Request.Context()); id != "" {
+			attrs = append(attrs, slog.String("tenant", id))
+		}
+		level := slog.LevelInfo
+		if slowAfter > 0 && latency > slowAfter {
+			level = slog.LevelWarn
+			attrs = append(attrs, slog.Bool("slow", true))
+		}
+		if largeAbove > 0 && (requestBytes > largeAbove || responseBytes > largeAbove) {
+			level = slog.LevelWarn
+			attrs = append(attrs, slog.Bool("large", true))
+		}
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// redactedValue replaces masked values.
+const redactedValue = "[REDACTED]"
+
+// defaultRedactKeys are attribute names whose values are always masked.
+// A key matches when it equals one of these or ends in "_" plus one, so
+// "wallet_signature" is masked too.
+var defaultRedactKeys = []string{
+	"signature", "authorization", "api_key", "apikey", "secret", "password",
+	"private_key", "access_token", "auth_token", "text", "prompt",
+}
+
+// secretSettings are settings whose values are masked wherever they appear
+// in a log line.
+var secretSettings = []string{
+	"OPENROUTER_API_KEY", "OPENAI_API_KEY", "ANTHROPIC_API_KEY", "AZURE_OPENAI_API_KEY",
+	"ADMIN_API_TOKEN", "WEBHOOK_SECRET", "NONCE_SECRET", "SERVER_WALLET_PRIVATE_KEY", "REDIS_PASSWORD",
+}
+
+// redactPatterns mask secrets inside free-form strings: 65-byte payment
+// signatures, bearer tokens and provider API keys.
+var redactPatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`0x[0-9a-fA-F]{130}`), "0x" + redactedValue},
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}" + redactedValue},
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`), "sk-" + redactedValue},
+}
+
+// redactingHandler masks sensitive attributes and secrets in messages
+// before passing records on.
+type redactingHandler struct {
+	next slog.Handler
+	keys map[string]bool
+}
+
+func newRedactingHandler(next slog.Handler, extraKeys []string) *redactingHandler {
+	keys := make(map[string]bool)
+	for _, k := range append(defaultRedactKeys, extraKeys...) {
+		keys[strings.ToLower(k)] = true
+	}
+	return &redactingHandler{next: next, keys: keys}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted), keys: h.keys}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), keys: h.keys}
+}
+
+// sensitive reports whether values under key are masked.
+func (h *redactingHandler) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	if h.keys[key] {
+		return true
+	}
+	if i := strings.LastIndexByte(key, '_'); i >= 0 {
+		return h.keys[key[i+1:]]
+	}
+	return false
+}
+
+func (h *redactingHandler) redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if h.sensitive(a.Key) {
+		return slog.String(a.Key, redactedValue)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactString(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, g := range group {
+			redacted[i] = h.redactAttr(g)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(redactString(err.Error()))
+		}
+	}
+	return a
+}
+
+// redactString masks configured secrets and secret-shaped substrings in s.
+func redactString(s string) string {
+	for _, name := range secretSettings {
+		// Short values would mask unrelated text
+		if v := os.Getenv(name); len(v) >= 8 && !strings.HasPrefix(v, secretURIPrefix) {
+			s = strings.ReplaceAll(s, v, redactedValue)
+		}
+	}
+	for _, p := range redactPatterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// samplingHandler passes on only the first of every `every` debug records
+// with the same message, so per-request debug lines such as cache hits
+// don't swamp the logs. Info and above are never sampled.
+type samplingHandler struct {
+	next   slog.Handler
+	every  uint64
+	counts *sync.Map // message -> *atomic.Uint64
+}
+
+func newSamplingHandler(next slog.Handler, every int) *samplingHandler {
+	return &samplingHandler{next: next, every: uint64(every), counts: &sync.Map{}}
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelInfo {
+		v, _ := h.counts.LoadOrStore(r.Message, new(atomic.Uint64))
+		if (v.(*atomic.Uint64).Add(1)-1)%h.every != 0 {
+			return nil
+		}
+		r.AddAttrs(slog.Uint64("sample_rate", h.every))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), every: h.every, counts: h.counts}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), every: h.every, counts: h.counts}
+}
+
+// ANSI colors used by consoleHandler.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+	ansiGray   = "\x1b[90m"
+)
+
+// isColorTerminal reports whether f is a terminal that should get colors:
+// not when NO_COLOR is set or output is piped to a file or collector.
+func isColorTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// consoleHandler writes one human-readable line per record for
+// LOG_FORMAT=console:
+//
+//	10:04:05.123 INF logging.go:42 > request method=GET path=/v1/models status=200
+type consoleHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	opts   slog.HandlerOptions
+	color  bool
+	attrs  string // preformatted attributes from WithAttrs
+	prefix string // open groups, dotted, from WithGroup
+}
+
+func newConsoleHandler(w io.Writer, opts *slog.HandlerOptions, color bool) *consoleHandler {
+	h := &consoleHandler{w: w, mu: &sync.Mutex{}, color: color}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	h.paint(&b, ansiGray, r.Time.Format("15:04:05.000"))
+	b.WriteByte(' ')
+	switch {
+	case r.Level >= slog.LevelError:
+		h.paint(&b, ansiRed+ansiBold, "ERR")
+	case r.Level >= slog.LevelWarn:
+		h.paint(&b, ansiYellow, "WRN")
+	case r.Level >= slog.LevelInfo:
+		h.paint(&b, ansiGreen, "INF")
+	default:
+		h.paint(&b, ansiGray, "DBG")
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		b.WriteByte(' ')
+		h.paint(&b, ansiGray, filepath.Base(frame.File)+":"+strconv.Itoa(frame.Line))
+	}
+	b.WriteByte(' ')
+	h.paint(&b, ansiGray, ">")
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		h.appendAttr(&b, h.prefix, a)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		h.appendAttr(&b, h.prefix, a)
+	}
+	next := *h
+	next.attrs += b.String()
+	return &next
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.prefix += name + "."
+	return &next
+}
+
+// appendAttr writes a as " key=value", flattening groups into dotted keys
+// and quoting values that wouldn't read as one word.
+func (h *consoleHandler) appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range a.Value.Group() {
+			h.appendAttr(b, prefix, g)
+		}
+		return
+	}
+	b.WriteByte(' ')
+	h.paint(b, ansiCyan, prefix+a.Key+"=")
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") || !strconv.CanBackquote(value) {
+		value = strconv.Quote(value)
+	}
+	if _, isErr := a.Value.Any().(error); isErr && a.Value.Kind() == slog.KindAny {
+		h.paint(b, ansiRed, value)
+		return
+	}
+	b.WriteString(value)
+}
+
+// paint writes s in color when the handler uses colors.
+func (h *consoleHandler) paint(b *strings.Builder, color, s string) {
+	if !h.color {
+		b.WriteString(s)
+		return
+	}
+	b.WriteString(color)
+	b.WriteString(s)
+	b.WriteString(ansiReset)
+}
+
+// teeHandler passes every record to each of its handlers, so stdout can be
+// console-formatted while LOG_FILE stays JSON.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := make(teeHandler, len(t))
+	for i, h := range t {
+		next[i] = h.WithAttrs(attrs)
+	}
+	return next
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	next := make(teeHandler, len(t))
+	for i, h := range t {
+		next[i] = h.WithGroup(name)
+	}
+	return next
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// restoreLoggers undoes InitLogger when the test ends.
+func restoreLoggers(t *testing.T) {
+	t.Helper()
+	prevSlog, prevOut, prevFlags := slog.Default(), log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(prevSlog)
+		log.SetOutput(prevOut)
+		log.SetFlags(prevFlags)
+	})
+}
+
+// readLogLines decodes the JSON lines in path.
+func readLogLines(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Log line %q is not JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestInitLogger_WritesJSONToFile(t *testing.T) {
+	restoreLoggers(t)
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	t.Setenv("LOG_FILE", path)
+	t.Setenv("LOG_STDOUT", "false")
+	t.Setenv("LOG_LEVEL", "info")
+
+	closer := InitLogger()
+	if closer == nil {
+		t.Fatal("Expected a closer for the log file")
+	}
+	log.Printf("Warning: Redis unavailable")
+	log.Printf("error purging cache: boom")
+	slog.Debug("hidden below info")
+	slog.Info("cache hit", "key", "abc")
+	closer.Close()
+
+	lines := readLogLines(t, path)
+	if len(lines) != 4 {
+		t.Fatalf("Expected the file notice and 3 lines, got %v", lines)
+	}
+	want := []struct{ level, msg string }{
+		{"INFO", "Logging to file"}, {"WARN", "Warning: Redis unavailable"}, {"ERROR", "error purging cache: boom"}, {"INFO", "cache hit"},
+	}
+	for i, w := range want {
+		if lines[i]["level"] != w.level || lines[i]["msg"] != w.msg {
+			t.Errorf("Line %d: expected %s %q, got %v", i, w.level, w.msg, lines[i])
+		}
+	}
+	if lines[3]["key"] != "abc" || lines[3]["time"] == nil {
+		t.Errorf("Expected structured fields, got %v", lines[3])
+	}
+}
+
+func TestInitLogger_StdoutOnly(t *testing.T) {
+	restoreLoggers(t)
+	t.Setenv("LOG_FILE", "")
+	if closer := InitLogger(); closer != nil {
+		t.Errorf("Expected no closer without LOG_FILE, got %T", closer)
+	}
+}
+
+func TestInitLogger_CallerAtDebug(t *testing.T) {
+	restoreLoggers(t)
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	t.Setenv("LOG_FILE", path)
+	t.Setenv("LOG_STDOUT", "false")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	closer := InitLogger()
+	log.Printf("Warning: from the log package")
+	slog.Debug("from slog")
+	closer.Close()
+
+	lines := readLogLines(t, path)
+	for _, line := range lines[1:] {
+		source, _ := line["source"].(map[string]interface{})
+		if file, _ := source["file"].(string); filepath.Base(file) != "logging_test.go" {
+			t.Errorf("Expected %q attributed to its caller, got %v", line["msg"], line["source"])
+		}
+	}
+
+	t.Setenv("LOG_CALLER", "false")
+	closer = InitLogger()
+	slog.Debug("no caller")
+	closer.Close()
+	lines = readLogLines(t, path)
+	if last := lines[len(lines)-1]; last["msg"] != "no caller" || last["source"] != nil {
+		t.Errorf("Expected no caller with LOG_CALLER=false, got %v", last)
+	}
+}
+
+func TestConsoleHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newConsoleHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}, false))
+	logger.Debug("hidden")
+	logger.With("request_id", "req-1").WithGroup("req").Info("verifier call",
+		"status", 200, "path", "/v1/summarize", "note", "two words", "empty", "",
+		slog.Group("retry", "attempt", 2))
+	logger.Error("upstream failed", "err", errors.New("timeout"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	want := ` INF > verifier call request_id=req-1 req.status=200 req.path=/v1/summarize req.note="two words" req.empty="" req.retry.attempt=2`
+	if !strings.HasSuffix(lines[0], want) || len(lines[0]) != len("15:04:05.000")+len(want) {
+		t.Errorf("Unexpected console line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], " ERR > upstream failed err=timeout") || strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("Unexpected console line %q", lines[1])
+	}
+
+	buf.Reset()
+	logger = slog.New(newConsoleHandler(&buf, &slog.HandlerOptions{AddSource: true}, true))
+	logger.Warn("colored")
+	if !strings.Contains(buf.String(), ansiYellow+"WRN"+ansiReset) || !strings.Contains(buf.String(), "logging_test.go:") {
+		t.Errorf("Expected a colored level and the caller, got %q", buf.String())
+	}
+}
+
+func TestTeeHandler(t *testing.T) {
+	var console, file bytes.Buffer
+	logger := slog.New(teeHandler{
+		newConsoleHandler(&console, &slog.HandlerOptions{Level: slog.LevelWarn}, false),
+		slog.NewJSONHandler(&file, nil),
+	})
+	logger.With("k", "v").Info("to the file only")
+	logger.Warn("to both")
+
+	if strings.Contains(console.String(), "file only") || !strings.Contains(console.String(), "to both") {
+		t.Errorf("Expected the console handler to keep its own level, got %q", console.String())
+	}
+	if strings.Count(file.String(), "\n") != 2 || !strings.Contains(file.String(), `"k":"v"`) {
+		t.Errorf("Expected both lines as JSON, got %q", file.String())
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	restoreLoggers(t)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestLogger(), RequestIDMiddleware())
+	r.GET("/v1/models", func(c *gin.Context) { c.String(502, "bad gateway") })
+	req, _ := http.NewRequest("GET", "/v1/models?x=1", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON line, got %q", buf.String())
+	}
+	if line["level"] != "ERROR" || line["path"] != "/v1/models" || line["status"] != float64(502) ||
+		line["request_id"] != "req-1" || line["bytes"] != float64(len("bad gateway")) {
+		t.Errorf("Unexpected access log %v", line)
+	}
+}
+
+func TestRequestLogger_SlowAndLarge(t *testing.T) {
+	restoreLoggers(t)
+	t.Setenv("LOG_SLOW_REQUEST_MS", "20")
+	t.Setenv("LOG_LARGE_PAYLOAD_BYTES", "100")
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestLogger())
+	r.POST("/echo", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		if c.Query("sleep") != "" {
+			time.Sleep(30 * time.Millisecond)
+		}
+		c.String(200, "%d", len(body))
+	})
+
+	send := func(target string, body io.Reader, length int64) map[string]interface{} {
+		buf.Reset()
+		req, _ := http.NewRequest("POST", target, body)
+		req.ContentLength = length
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		var line map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("Expected one JSON line, got %q", buf.String())
+		}
+		return line
+	}
+
+	if line := send("/echo", strings.NewReader("short"), 5); line["level"] != "INFO" || line["request_bytes"] != float64(5) || line["slow"] != nil || line["large"] != nil {
+		t.Errorf("Expected a plain info line, got %v", line)
+	}
+	if line := send("/echo?sleep=1", strings.NewReader("short"), 5); line["level"] != "WARN" || line["slow"] != true || line["large"] != nil {
+		t.Errorf("Expected a slow warning, got %v", line)
+	}
+	// Sent without a length, the body is counted as it is read
+	large := strings.Repeat("x", 150)
+	if line := send("/echo", io.MultiReader(strings.NewReader(large)), -1); line["level"] != "WARN" || line["request_bytes"] != float64(150) || line["large"] != true {
+		t.Errorf("Expected a large-payload warning, got %v", line)
+	}
+}
+
+func TestLoadConfig_LogSettings(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("LOG_FILE", "")
+	t.Setenv("LOG_STDOUT", "false")
+	t.Setenv("LOG_LEVEL", "verbose")
+	t.Setenv("LOG_FORMAT", "pretty")
+
+	_, err := LoadConfig()
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 3 ||
+		!strings.Contains(err.Error(), "LOG_LEVEL") || !strings.Contains(err.Error(), "LOG_STDOUT") || !strings.Contains(err.Error(), "LOG_FORMAT") {
+		t.Fatalf("Expected LOG_LEVEL, LOG_FORMAT and LOG_STDOUT problems, got %v", err)
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "or-live-0123456789")
+	var buf bytes.Buffer
+	logger := slog.New(newRedactingHandler(slog.NewJSONHandler(&buf, nil), []string{"email"}))
+	sig := "0x" + strings.Repeat("ab", 65)
+
+	logger.With("x_402_signature", sig).Info("calling provider with key or-live-0123456789",
+		"text", "my confidential document",
+		"email", "a@example.com",
+		"header", "Authorization: Bearer abc.def.ghi",
+		"err", errors.New("verify failed for "+sig),
+		slog.Group("req", "wallet_signature", "0x1234", "nonce", "n-1"),
+		"key", "sk-or-v1-abcdefghijklmnopqrstuvwxyz",
+	)
+	out := buf.String()
+	for _, leaked := range []string{"or-live-0123456789", "confidential", "a@example.com", "abc.def.ghi", strings.Repeat("ab", 65), "0x1234", "abcdefghijklmnop"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("Expected %q to be redacted from %s", leaked, out)
+		}
+	}
+	var line map[string]interface{}
+	json.Unmarshal(buf.Bytes(), &line)
+	if line["text"] != redactedValue || line["req"].(map[string]interface{})["nonce"] != "n-1" || line["header"] != "Authorization: Bearer "+redactedValue {
+		t.Errorf("Unexpected redaction %s", out)
+	}
+}
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newSamplingHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), 3))
+	for i := 0; i < 7; i++ {
+		logger.Debug("cache hit")
+		logger.With("route", "/v1").Debug("verifying payment")
+	}
+	logger.Info("kept")
+	logger.Info("kept")
+
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &line)
+		counts[line["msg"].(string)]++
+	}
+	if counts["cache hit"] != 3 || counts["verifying payment"] != 3 || counts["kept"] != 2 {
+		t.Errorf("Expected 1 in 3 debug lines per message and every info line, got %v", counts)
+	}
+}
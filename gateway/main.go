@@ -12,28 +12,35 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
+// PaymentContext is what the client signs (EIP-712) to pay for a request.
+// The doc and example tags feed the generated OpenAPI spec.
 type PaymentContext struct {
-	Recipient string `json:"recipient"`
-	Token     string `json:"token"`
-	Amount    string `json:"amount"`
-	Nonce     string `json:"nonce"`
-	ChainID   int    `json:"chainId"`
+	Recipient string `json:"recipient" doc:"Ethereum address of payment recipient" example:"0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"`
+	Token     string `json:"token" doc:"Token symbol for payment" example:"USDC"`
+	Amount    string `json:"amount" doc:"Payment amount in token units" example:"0.001"`
+	Nonce     string `json:"nonce" doc:"Payment nonce issued by the gateway, bound to the route and valid until expires_at" example:"n1.1792123456.q3Z0rB8yQ2m6k1VJb7tT9w.Fh2xk0c6l0oYd7Yc9o2n8Jq3t5s1w4r6u8y0a2c4e6g"`
+	ChainID   int    `json:"chainId" doc:"Blockchain network ID" example:"8453"`
+	// BodyHash binds the signature to one text, so it can't be replayed
+	// against another
+	BodyHash string `json:"bodyHash,omitempty" doc:"0x-prefixed keccak256 of the UTF-8 text, signed as bytes32; present when PAYMENT_BIND_BODY is on and the challenge request carried the text" example:"0x876e635382407b2c2a5d2bdc6d78abe043a991249b2ae64c62150149e8e9bf41"`
 }
 
 type VerifyRequest struct {
@@ -48,25 +55,62 @@ type VerifyResponse struct {
 }
 
 type SummarizeRequest struct {
-	Text string `json:"text"`
+	Text  string `json:"text" doc:"Text to summarize" example:"Artificial intelligence is transforming software development."`
+	Model string `json:"model,omitempty" doc:"OpenRouter model id; defaults to OPENROUTER_MODEL and must be allowed by the wallet's plan"`
+	// OutputLanguage asks for the summary in another language than the text
+	OutputLanguage string `json:"output_language,omitempty" doc:"ISO 639-1 code of the language to write the summary in; defaults to the text's own language" example:"es"`
+	// Sampling parameters passed through to the provider after clamping
+	Temperature *float64 `json:"temperature,omitempty" doc:"Sampling temperature, clamped to [0, MAX_TEMPERATURE]; 0 gives reproducible output" example:"0"`
+	TopP        *float64 `json:"top_p,omitempty" doc:"Nucleus sampling cutoff, clamped to [0, 1]" example:"1"`
+	MaxTokens   *int     `json:"max_tokens,omitempty" doc:"Output token cap, clamped to [1, MAX_OUTPUT_TOKENS]" example:"256"`
+}
+
+// generationParams returns the request's sampling parameters as sent.
+func (r *SummarizeRequest) generationParams() generationParams {
+	return generationParams{Temperature: r.Temperature, TopP: r.TopP, MaxTokens: r.MaxTokens}
+}
+
+type SummarizeResponse struct {
+	Result   string         `json:"result" example:"AI is changing how software is built."`
+	Receipt  *SignedReceipt `json:"receipt"`
+	Stale    bool           `json:"stale,omitempty" doc:"Present and true when the summary came from an expired cache entry served under stale-while-revalidate"`
+	Provider string         `json:"provider,omitempty" doc:"AI provider that produced the summary (openrouter, openai, anthropic, azure or ollama); absent for cache hits" example:"openrouter"`
+	// OutputLanguage echoes the normalized output_language of the request
+	OutputLanguage string `json:"output_language,omitempty" example:"es"`
+	// Generation echoes the sampling parameters after clamping
+	Generation *generationParams `json:"generation,omitempty" doc:"Effective temperature, top_p and max_tokens; absent when the request set none"`
+}
+
+// ReceiptLookupResponse is the body of GET /v1/receipts/:id.
+type ReceiptLookupResponse struct {
+	Receipt         Receipt `json:"receipt"`
+	Signature       string  `json:"signature"`
+	ServerPublicKey string  `json:"server_public_key"`
+	Status          string  `json:"status" example:"valid"`
+}
+
+// ReceiptVerificationResponse is the body of GET /v1/receipts/:id/verify.
+type ReceiptVerificationResponse struct {
+	Valid  bool   `json:"valid" doc:"Whether the signature is valid and was made with this gateway's key"`
+	Reason string `json:"reason,omitempty" doc:"Why the receipt is not valid; absent when it is"`
+	// Canonical is the exact JSON the signature covers, so third parties
+	// can check it without re-encoding the receipt
+	Canonical       string  `json:"canonical" doc:"JSON encoding of receipt that was signed"`
+	Digest          string  `json:"digest" doc:"0x-prefixed keccak256 of canonical" example:"0x876e635382407b2c2a5d2bdc6d78abe043a991249b2ae64c62150149e8e9bf41"`
+	Receipt         Receipt `json:"receipt"`
+	Signature       string  `json:"signature"`
+	ServerPublicKey string  `json:"server_public_key"`
+	SignerAddress   string  `json:"signer_address,omitempty" doc:"Address the signature recovers to" example:"0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"`
 }
 
 func validateConfig() error {
-	required := []string{
-		"OPENROUTER_API_KEY",
-	}
-	var missing []string
-	for _, key := range required {
-		if os.Getenv(key) == "" {
-			missing = append(missing, key)
-		}
-	}
-	if len(missing) > 0 {
-		return fmt.Errorf("missing required environment variables: %v", missing)
-	}
-	return nil
+	_, err := LoadConfig()
+	return err
 }
 func main() {
+	configPath := flag.String("config", os.Getenv("GATEWAY_CONFIG"), "path to a YAML or TOML settings file (environment variables take precedence)")
+	flag.Parse()
+
 	// Try loading .env from current directory first, then fallback to parent
 	err := godotenv.Load(".env")
 	if err != nil {
@@ -76,74 +120,246 @@ func main() {
 			log.Println("Warning: Error loading .env file")
 		}
 	}
-	if err := validateConfig(); err != nil {
-		fmt.Println("[Error] Missing required environment variables:")
-		fmt.Println("  -", err.Error())
+	if *configPath != "" {
+		applied, err := applyConfigFile(*configPath)
+		if err != nil {
+			fmt.Println("[Error] Failed to load config file:")
+			fmt.Println("  -", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("[OK] Loaded %d settings from %s\n", len(applied), *configPath)
+	}
+	if err := resolveSecrets(context.Background()); err != nil {
+		printConfigProblems("[Error] Failed to resolve secrets:", err)
+		os.Exit(1)
+	}
+	if flag.Arg(0) == "migrate" {
+		os.Exit(runMigrate(flag.Args()[1:], os.Stdout))
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		printConfigProblems("[Error] Configuration is invalid:", err)
 		fmt.Println()
 		fmt.Println("Copy .env.example to .env and fill in the required values.")
 		fmt.Println("See README.md for more configuration details.")
 		os.Exit(1)
 	}
+	appConfig = cfg
+	if logFile := InitLogger(); logFile != nil {
+		defer logFile.Close()
+	}
 	fmt.Println("[OK] Configuration validated")
-	if port := os.Getenv("PORT"); port != "" {
-		fmt.Printf("    - Port: %s\n", port)
-	}
-	if model := os.Getenv("MODEL"); model != "" {
-		fmt.Printf("    - Model: %s\n", model)
-	}
-	if verifier := os.Getenv("VERIFIER_URL"); verifier != "" {
-		fmt.Printf("    - Verifier: %s\n", verifier)
-	}
-	if chainID := os.Getenv("CHAIN_ID"); chainID != "" {
-		fmt.Printf("    - Chain ID: %s\n", chainID)
-	}
-	if os.Getenv("PORT") == "" {
-		fmt.Println("[WARN] PORT not set, using default: 3000")
-	}
-	if os.Getenv("MODEL") == "" {
-		fmt.Println("[WARN] MODEL not set, using default model")
-	}
-	if os.Getenv("VERIFIER_URL") == "" {
-		fmt.Println("[WARN] VERIFIER_URL not set, using default verifier")
-	}
-	if os.Getenv("CHAIN_ID") == "" {
-		fmt.Println("[WARN] CHAIN_ID not set, using default: 8453(base)")
-	}
-
-	r := gin.Default()
-
-	r.StaticFile("/openapi.yaml", "openapi.yaml")
-
-	r.GET("/docs", func(c *gin.Context) {
-		c.Header("Content-Type", "text/html")
-		c.String(200, `
-<!DOCTYPE html>
-<html>
-<head>
-  <title>MicroAI Paygate Docs</title>
-  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5.11.0/swagger-ui.css" />
-</head>
-<body>
-  <div id="swagger-ui"></div>
-  <script src="https://unpkg.com/swagger-ui-dist@5.11.0/swagger-ui-bundle.js"></script>
-  <script>
-    SwaggerUIBundle({
-      url: '/openapi.yaml',
-      dom_id: '#swagger-ui'
-    });
-  </script>
-</body>
-</html>
-`)
-	})
+	fmt.Printf("    - Port: %s\n", cfg.Port)
+	fmt.Printf("    - Model: %s\n", cfg.OpenRouterModel)
+	fmt.Printf("    - Verifier: %s\n", cfg.VerifierURL)
+	fmt.Printf("    - Chain ID: %d\n", cfg.ChainID)
+	if os.Getenv("RECIPIENT_ADDRESS") == "" {
+		fmt.Println("[WARN] RECIPIENT_ADDRESS not set, using default recipient")
+	}
+	if os.Getenv("NONCE_SECRET") == "" {
+		fmt.Println("[WARN] NONCE_SECRET not set, payment nonces only work on this instance until it restarts")
+	}
+
+	// Background work stops with cleanupCtx on shutdown
+	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
+	defer func() {
+		cleanupCancel()
+		// Perform final cleanup on shutdown to prevent receipt leak
+		cleanupExpiredReceipts()
+		log.Println("Final receipt cleanup completed on shutdown")
+	}()
+	if err := initVerifierTLS(cleanupCtx); err != nil {
+		fmt.Println("[Error] Failed to set up verifier TLS:")
+		fmt.Println("  -", err.Error())
+		os.Exit(1)
+	}
+	// Under docker-compose the verifier and Redis may still be starting;
+	// Redis in particular is only tried once by initRedis
+	if waitForDepsEnabled() {
+		if err := waitForStartupDependencies(cleanupCtx); err != nil {
+			fmt.Println("[Error] Dependencies are not reachable:")
+			fmt.Println("  -", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("[OK] Dependencies reachable")
+	}
 
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3001"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "X-402-Signature", "X-402-Nonce"},
-		ExposeHeaders:    []string{"Content-Length", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-402-Receipt"},
-		AllowCredentials: true,
-	}))
+	// Response cache: in-memory L1, backed by Redis when REDIS_URL is set
+	memoryCache = initMemoryCache()
+	redisClient = initRedis()
+	ensNames = initENSResolver()
+	if isENSName(cfg.RecipientAddress) {
+		recipientENS, err = resolveRecipient(context.Background(), ensNames, cfg.RecipientAddress)
+		if err != nil {
+			fmt.Printf("[Error] Failed to resolve RECIPIENT_ADDRESS %s: %v\n", cfg.RecipientAddress, err)
+			os.Exit(1)
+		}
+		fmt.Printf("    - Recipient: %s (%s)\n", recipientENS.name, recipientENS.current())
+	}
+	priceOracle = initPriceOracle()
+	if priceOracle != nil {
+		if _, err := priceOracle.current(context.Background()); err != nil {
+			log.Printf("[WARN] No token price yet, priced routes answer 503 until one is read: %v", err)
+		}
+	}
+	aiBatcher = initMicroBatcher()
+	aiChunker = initChunker()
+	outputModerator = initModerator()
+	piiRedactor = initRedactor()
+	customInjectionRules = initInjectionRules()
+	aiBreakers = initCircuitBreakers()
+	store, err := initStore()
+	if err != nil {
+		fmt.Printf("[Error] Failed to open DATABASE_URL: %v\n", err)
+		os.Exit(1)
+	}
+	if store != nil {
+		dataStore = store
+	}
+	usageLedger = initLedger()
+	paymentReconciler = initReconciler()
+	settlementTracker = initConfirmationTracker()
+	retentionPurger = initRetention()
+	refundQueue = initRefundQueue()
+	settlementQueue = openJSONLinesQueue("SETTLEMENT_QUEUE_FILE", "payments for settlement")
+	receiptAnchor = initReceiptAnchor()
+	prepaidWatcher = initDepositWatcher()
+	switch getSettlementMode() {
+	case settlementEscrow:
+		log.Println("Escrow mode: payments are settled only after the response is delivered")
+	case settlementFacilitator:
+		log.Printf("Facilitator mode: payments are settled by %s before the response is sent", getFacilitatorURL())
+	}
+	eventBus = initEventBus()
+	operatorWebhooks = initWebhooks()
+	auditLog = initAuditLog()
+	ipAccess = initIPAccessList()
+	tenantDir = initTenants(context.Background())
+	if err := rateLimitOverrides.reload(context.Background()); err != nil {
+		log.Printf("Warning: failed to load rate limit overrides, starting with none: %v", err)
+	}
+	recordAudit(context.Background(), AuditEntry{Action: auditGatewayStarted, Actor: "system", Details: map[string]string{"config_file": *configPath, "port": cfg.Port}})
+
+	r := newRouter()
+
+	// pprof and expvar, only with DEBUG_ENDPOINTS=true
+	debugSrv := setupDebugEndpoints(r)
+	// gRPC API on its own h2c listener, only with GRPC_PORT
+	grpcSrv := setupGRPCServer(r)
+
+	go startReceiptCleanup(cleanupCtx)
+	log.Println("Receipt cleanup goroutine started")
+	startSecretRotation(cleanupCtx)
+	verifierHealth = startVerifierHealthPoller(cleanupCtx)
+	if paymentReconciler != nil {
+		paymentReconciler.start(cleanupCtx)
+	}
+	if operatorWebhooks != nil {
+		operatorWebhooks.start(cleanupCtx)
+	}
+	if receiptAnchor != nil {
+		receiptAnchor.start(cleanupCtx)
+	}
+	if paymentChannelsEnabled() {
+		startChannelExpiry(cleanupCtx)
+	}
+	if recipientENS != nil {
+		recipientENS.start(cleanupCtx, ensNames)
+	}
+	if priceOracle != nil {
+		priceOracle.start(cleanupCtx)
+	}
+	if prepaidWatcher != nil {
+		prepaidWatcher.start(cleanupCtx)
+	}
+	if settlementTracker != nil {
+		settlementTracker.start(cleanupCtx)
+	}
+	if retentionPurger != nil {
+		retentionPurger.start(cleanupCtx)
+	}
+	ipAccess.start(cleanupCtx)
+	rateLimitOverrides.start(cleanupCtx)
+	if tenantDir != nil {
+		tenantDir.start(cleanupCtx)
+	}
+
+	// One server for every listener (LISTEN_ADDRS, ADMIN_LISTEN_ADDRS), so
+	// Shutdown drains them all
+	addrs := getListenAddrs(cfg.Port)
+	listeners, err := openListeners(addrs)
+	if err != nil {
+		fmt.Println("[Error] Failed to listen:")
+		fmt.Println("  -", err.Error())
+		os.Exit(1)
+	}
+	limitConnections(listeners)
+	tlsConfig, acmeSrv, err := setupTLS(cleanupCtx)
+	if err != nil {
+		fmt.Println("[Error] Failed to set up TLS:")
+		fmt.Println("  -", err.Error())
+		os.Exit(1)
+	}
+	if tlsConfig != nil {
+		wrapTLS(tlsConfig, addrs, listeners)
+	}
+	srv := &http.Server{Handler: r}
+	configureServerLimits(srv)
+	configureHTTP2(srv)
+	serverErr := make(chan error, 1)
+	serveListeners(srv, addrs, listeners, serverErr)
+
+	// Wait for SIGTERM/SIGINT, then stop accepting connections and drain
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-serverErr:
+		log.Printf("server error: %v", err)
+	case <-signalCtx.Done():
+		log.Println("Shutdown signal received, draining in-flight requests")
+	}
+	if grpcSrv != nil {
+		// Stop taking calls; WaitForInFlightRequests in shutdownGateway also
+		// waits for the REST requests that running calls replay
+		go grpcSrv.Shutdown(context.Background())
+	}
+	shutdownGateway(srv, getShutdownTimeout())
+	if debugSrv != nil {
+		debugSrv.Close()
+	}
+	if acmeSrv != nil {
+		acmeSrv.Close()
+	}
+}
+
+// newRouter builds the gin engine with the gateway's middleware and routes.
+// The debug endpoints are added separately since they may need their own
+// listener.
+func newRouter() *gin.Engine {
+	r := gin.New()
+	// LoadConfig has validated TRUSTED_PROXIES
+	if err := r.SetTrustedProxies(getTrustedProxies()); err != nil {
+		log.Printf("error setting trusted proxies: %v", err)
+	}
+	r.Use(RequestLogger(), gin.CustomRecovery(recoverWithProblem))
+	r.Use(RequestIDMiddleware(), TrackInFlightRequests(), RecordAPITraffic())
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(handleNoRoute)
+	r.NoMethod(handleNoMethod)
+
+	// API documentation generated from the Go types (see openapi.go)
+	r.GET("/openapi.json", handleOpenAPIJSON)
+	r.GET("/openapi.yaml", handleOpenAPIYAML)
+	r.GET("/docs", handleDocs)
+
+	// IP allow/deny lists, ahead of CORS and rate limiting so blocked
+	// ranges cost as little as possible
+	r.Use(IPAccessMiddleware())
+	// The tenant is known before rate limiting, which it may configure
+	if multiTenantEnabled() {
+		r.Use(TenantMiddleware())
+	}
+	r.Use(CORSMiddleware())
 
 	// Initialize rate limiters if enabled
 	if getRateLimitEnabled() {
@@ -158,212 +374,644 @@ func main() {
 	// deadline when nested timeouts are present to avoid surprising behavior.
 	r.Use(RequestTimeoutMiddleware(getRequestTimeout()))
 
-	// Health check with shorter timeout (2s)
-	r.GET("/healthz", RequestTimeoutMiddleware(getHealthCheckTimeout()), handleHealth)
-
-	// AI endpoints with AI-specific timeout (30s)
-	aiGroup := r.Group("/api/ai")
-	aiGroup.Use(RequestTimeoutMiddleware(getAITimeout()))
-	aiGroup.POST("/summarize", handleSummarize)
+	// Health checks. /livez and /healthz only report that the process is up;
+	// /readyz also checks dependencies and returns 503 when critical ones are
+	// down. Each dependency check is bounded by the health check timeout.
+	r.GET("/healthz", RequestTimeoutMiddleware(getHealthCheckTimeout()), handleLive)
+	r.GET("/livez", RequestTimeoutMiddleware(getHealthCheckTimeout()), handleLive)
+	r.GET("/readyz", RequestTimeoutMiddleware(getHealthCheckTimeout()+time.Second), handleReady)
+
+	// Prometheus metrics
+	r.GET("/metrics", handleMetrics)
+
+	// Public API under /v1, plus the deprecated unversioned /api aliases
+	registerAPIRoutes(r.Group(apiV1Prefix))
+	registerAPIRoutes(r.Group(legacyAPIPrefix, DeprecationMiddleware()))
+	// With MULTI_TENANT=true, the /v1 API of each tenant under /t/<id>
+	if multiTenantEnabled() {
+		registerAPIRoutes(r.Group(tenantPathPrefix + apiV1Prefix))
+	}
+
+	// Operator endpoints (require ADMIN_API_TOKEN)
+	adminGroup := r.Group("/admin", AdminListenerMiddleware(), AdminAuthMiddleware())
+	adminGroup.GET("/cache/stats", handleCacheStats)
+	adminGroup.DELETE("/cache", handlePurgeCache)
+	adminGroup.DELETE("/cache/:key", handleDeleteCacheKey)
+	adminGroup.GET("/abuse", handleAbuseReport)
+	adminGroup.GET("/reputation", handleReputationReport)
+	adminGroup.GET("/reputation/:wallet", handleWalletReputation)
+	adminGroup.GET("/export/usage", handleExportUsage)
+	adminGroup.GET("/reconciliation", handleReconciliationReport)
+	adminGroup.GET("/stats", handleAdminStats)
+	adminGroup.GET("/audit", handleAuditLog)
+	adminGroup.GET("/audit/verify", handleAuditVerify)
+	adminGroup.GET("/ip-acl", handleGetIPACL)
+	adminGroup.PUT("/ip-acl", handlePutIPACL)
+	adminGroup.GET("/promo-codes", handleListPromoCodes)
+	adminGroup.PUT("/promo-codes/:code", handlePutPromoCode)
+	adminGroup.DELETE("/promo-codes/:code", handleDeletePromoCode)
+	adminGroup.GET("/tenants", handleListTenants)
+	adminGroup.GET("/tenants/:id", handleGetTenant)
+	adminGroup.PUT("/tenants/:id", handlePutTenant)
+	adminGroup.DELETE("/tenants/:id", handleDeleteTenant)
+	adminGroup.GET("/ratelimits", handleListRateLimits)
+	adminGroup.PUT("/ratelimits/overrides", handlePutRateLimitOverride)
+	adminGroup.DELETE("/ratelimits/overrides/:key", handleDeleteRateLimitOverride)
+	adminGroup.POST("/refunds", handleCreateRefund)
+	adminGroup.POST("/channels/:id/close", handleAdminCloseChannel)
+
+	return r
+}
 
-	// Receipt lookup endpoint
-	// Note: Rate limiting applies only if enabled globally via RATE_LIMIT_ENABLED=true
-	// Random 12-char receipt IDs (2^48 space) make brute-force enumeration impractical
-	r.GET("/api/receipts/:id", handleGetReceipt)
+// shutdownGateway stops srv from accepting new connections, waits up to
+// timeout for in-flight requests (tracked by TrackInFlightRequests) to
+// finish, then flushes the event stream and closes Redis and the store.
+func shutdownGateway(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	// Initialize receipt cleanup goroutine
-	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
-	defer func() {
-		cleanupCancel()
-		// Perform final cleanup on shutdown to prevent receipt leak
-		cleanupExpiredReceipts()
-		log.Println("Final receipt cleanup completed on shutdown")
-	}()
-	go startReceiptCleanup(cleanupCtx)
-	log.Println("Receipt cleanup goroutine started")
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if err := WaitForInFlightRequests(ctx); err != nil {
+		log.Printf("Shutdown deadline reached with %d requests still in flight", InFlightRequestCount())
+	} else {
+		log.Println("All in-flight requests completed")
+	}
+	if eventBus != nil {
+		eventBus.close(ctx)
 	}
 
-	log.Printf("Go Gateway running on port %s", port)
-	r.Run(":" + port)
+	if redisClient != nil {
+		if err := redisClient.Close(); err != nil {
+			log.Printf("error closing Redis: %v", err)
+		}
+	}
+	if dataStore != nil {
+		if err := dataStore.Close(); err != nil {
+			log.Printf("error closing the store: %v", err)
+		}
+	}
 }
 
-// handleSummarize handles POST /api/ai/summarize requests. It validates
+// handleSummarize handles POST /v1/ai/summarize requests. It validates
 // payment headers, calls the verifier service to validate the signature, and
-// forwards the text to the AI service. The handler respects context timeouts
-// applied by middleware and returns appropriate HTTP errors (402, 403, 504,
-// 500) to the client.
+// forwards the text to the AI service unless a cached summary exists. The
+// handler respects context timeouts applied by middleware and returns
+// appropriate HTTP errors (402, 403, 504, 500) to the client.
 func handleSummarize(c *gin.Context) {
+	start := time.Now()
 	signature := c.GetHeader("X-402-Signature")
 	nonce := c.GetHeader("X-402-Nonce")
+	// Requests paid from a payment channel carry a balance update instead,
+	// and those paid from a prepaid balance the wallet's signature of the
+	// nonce and body; both are checked here without the verifier
+	paidByChannel := c.GetHeader(channelHeader) != ""
+	paidByPrepaid := c.GetHeader(prepaidHeader) != ""
+	offChain := paidByChannel || paidByPrepaid
+	promo, ok := lookupPromoCode(c)
+	if !ok {
+		return
+	}
 
 	// 1. Payment Required
-	if signature == "" || nonce == "" {
-		paymentContext := createPaymentContext()
-		c.JSON(402, gin.H{
-			"error":          "Payment Required",
-			"message":        "Please sign the payment context",
-			"paymentContext": paymentContext,
-		})
+	if !paidByChannel && (nonce == "" || (!paidByPrepaid && signature == "")) {
+		p := newProblem(402, codePaymentRequired, "Payment Required", "Please sign the payment context")
+		// Quote the price of the text and model when the client sent them
+		var paymentContext PaymentContext
+		if quoteReq, ok := readQuoteRequest(c); ok {
+			quote, ok := quoteSummarize(c, paymentRoute(c), quoteReq, promo)
+			if !ok {
+				return
+			}
+			paymentContext = quote.PaymentContext
+			p.With("expires_at", quote.ExpiresAt).With("tokens", quote.Tokens).With("model", quote.Model)
+			if quote.SizeBand != "" {
+				p.With("size_band", quote.SizeBand)
+			}
+			if quote.OutputLanguage != "" {
+				p.With("output_language", quote.OutputLanguage)
+			}
+			if promo != nil {
+				p.With("promo_code", quote.PromoCode).With("discount", quote.Discount)
+			}
+		} else {
+			var expiresAt time.Time
+			paymentContext, expiresAt = createPaymentContext(c.Request.Context(), paymentRoute(c))
+			p.With("expires_at", expiresAt.UTC())
+			if promo != nil {
+				var discount string
+				paymentContext.Amount, discount = promo.apply(paymentContext.Amount)
+				p.With("promo_code", promo.Code).With("discount", discount)
+			}
+		}
+		if bands := advertisedSizeBands(c.Request.Context()); bands != nil {
+			p.With("size_bands", bands)
+		}
+		if name := recipientNameFor(c.Request.Context()); name != "" {
+			p.With("recipient_name", name)
+		}
+		if load := formatLoad(nonceLoad(paymentContext.Nonce)); load != "" {
+			p.With("load_multiplier", load)
+		}
+		abortWithProblem(c, p.With("paymentContext", paymentContext))
 		return
 	}
+	if requireIssuedNonces() && !offChain {
+		if reason := checkNonce(nonce, paymentRoute(c), time.Now()); reason != "" {
+			abortInvalidNonce(c, reason)
+			return
+		}
+	}
+
+	// Fail fast while the background poller reports the verifier down; the
+	// client hasn't been charged, so it can safely retry later.
+	if verifierHealth != nil && !offChain {
+		if health := verifierHealth.snapshot(); !health.Up {
+			c.Header("Retry-After", "5")
+			abortWithProblem(c, newProblem(503, codeVerifierUnavailable, "Verifier Unavailable",
+				"Payment verification is temporarily unavailable, please retry shortly"))
+			return
+		}
+	}
 
-	// Capture request body for receipt generation
-	// Limit request body to 10MB to prevent memory exhaustion attacks
-	maxBodySize := int64(10 * 1024 * 1024)
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
+	// Capture request body for receipt generation. ValidateSummarizeInput
+	// has already checked it when the route is mounted with that middleware.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSummarizeBodyBytes)
 
 	requestBody, err := c.GetRawData()
 	if err != nil {
 		log.Printf("error reading request body: %v", err)
 		// Return 413 if body exceeds size limit, 500 for other errors
 		if err.Error() == "http: request body too large" {
-			c.JSON(413, gin.H{"error": "Payload too large", "max_size": "10MB"})
+			abortWithProblem(c, newProblem(413, codePayloadTooLarge, "Payload Too Large", "Request body exceeds 10MB").
+				With("max_size", "10MB"))
 		} else {
-			c.JSON(500, gin.H{"error": "Failed to read request body"})
+			abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read request body", ""))
 		}
 		return
 	}
 	// Set body to NoBody since we've already read it into requestBody
-	// We'll use json.Unmarshal(requestBody, &req) later instead of c.BindJSON
+	// We'll use decodeSummarizeRequest(c, requestBody) later instead of c.BindJSON
 	c.Request.Body = http.NoBody
 
-	// 2. Verify Payment (Call Rust Service)
+	// 2. Parse request body, JSON or plain text; the price depends on the
+	// model and the text's size and token count
+	req, err := decodeSummarizeRequest(c, requestBody)
+	if err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	pricedModel, err := checkModelAllowed(req.Model)
+	if err != nil {
+		abortWithProblem(c, modelNotAllowedProblem(err.(*modelNotAllowedError)))
+		return
+	}
+	language, err := checkOutputLanguage(req.OutputLanguage)
+	if err != nil {
+		abortWithProblem(c, unsupportedLanguageProblem(err.(*unsupportedLanguageError)))
+		return
+	}
+	opts := summaryOptions{OutputLanguage: language, Generation: req.generationParams().clamp()}
+	tokens := countTokens(req.Text)
+	c.Header("X-Input-Tokens", strconv.Itoa(tokens))
+
+	// Fail fast while every provider for the model is tripped, before the
+	// verifier consumes the nonce. In cached-only brownout, requests whose
+	// summary is cached still go through.
+	if aiBreakers != nil {
+		if ok, retryAfter := aiBreakers.available(pricedModel); !ok {
+			if !servableInBrownout(c, pricedModel, req.Text, opts) {
+				brownoutRequestsTotal.Inc("rejected")
+				abortAIUnavailable(c, retryAfter)
+				return
+			}
+			brownoutRequestsTotal.Inc("cached")
+		}
+	}
+
+	// 3. Verify Payment (Call Rust Service)
 	paymentCtx := PaymentContext{
-		Recipient: getRecipientAddress(),
-		Token:     "USDC",
-		Amount:    getPaymentAmount(),
+		Recipient: recipientFor(c.Request.Context()),
+		Token:     getPaymentToken(),
+		Amount:    applyLoad(priceFor(c.Request.Context(), pricedModel, len(req.Text), tokens, opts), nonceLoad(nonce)),
 		Nonce:     nonce,
 		ChainID:   getChainID(),
 	}
-
-	verifyReq := VerifyRequest{
-		Context:   paymentCtx,
-		Signature: signature,
+	pluginReq := newPluginRequest(c, req, pricedModel, language, tokens, paymentCtx.Amount)
+	if !runPreVerifyHooks(c, pluginReq) {
+		return
 	}
-
-	verifyBody, err := json.Marshal(verifyReq)
+	paymentCtx.Amount = pluginReq.Price
+	var discount string
+	if promo != nil {
+		paymentCtx.Amount, discount = promo.apply(paymentCtx.Amount)
+	}
+	// The hash comes from the text received, so a signature made for any
+	// other text fails verification
+	if bindPaymentToBody() {
+		paymentCtx.BodyHash = paymentBodyHash(req.Text)
+	}
+	// When the client says what it signed, hold it to the requirement and
+	// verify the signature over its amount, which may exceed the price
+	declared, err := readDeclaredPayment(c)
 	if err != nil {
-		log.Printf("error marshaling verification request: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to create verification request"})
+		abortWithProblem(c, newProblem(400, codeInvalidPaymentHeader, "Bad Request", err.Error()))
 		return
 	}
-	verifierURL := os.Getenv("VERIFIER_URL")
-	if verifierURL == "" {
-		verifierURL = "http://127.0.0.1:3002"
+	if declared != nil {
+		if mismatch := checkDeclaredPayment(*declared, paymentCtx); mismatch != nil {
+			abortPaymentMismatch(c, mismatch, paymentCtx)
+			return
+		}
+		paymentCtx.Amount = declared.Amount
+	}
+
+	var verifyResp VerifyResponse
+	var charge *channelCharge
+	if paidByChannel {
+		if charge = chargeChannel(c, paymentCtx); charge == nil {
+			return
+		}
+		defer revertChannelChargeIfFailed(c, charge)
+		nonce, signature = charge.nonce(), c.GetHeader(channelSignatureHeader)
+		paymentCtx.Nonce = nonce
+		verifyResp = VerifyResponse{IsValid: true, RecoveredAddress: charge.sender}
+	} else if paidByPrepaid {
+		prepaid := chargePrepaid(c, paymentCtx, paymentBodyHash(string(requestBody)))
+		if prepaid == nil {
+			return
+		}
+		defer revertPrepaidChargeIfFailed(c, prepaid)
+		nonce, signature = prepaid.nonce, c.GetHeader("X-Wallet-Signature")
+		paymentCtx.Nonce = nonce
+		verifyResp = VerifyResponse{IsValid: true, RecoveredAddress: prepaid.wallet}
+	} else if verifyResp, ok = verifyPayment(c, paymentCtx, signature, req.Text); !ok {
+		return
 	}
-	// Call verifier with its own timeout
-	verifierCtx, verifierCancel := context.WithTimeout(c.Request.Context(), getVerifierTimeout())
-	defer verifierCancel()
 
-	vreq, err := http.NewRequestWithContext(verifierCtx, "POST", verifierURL+"/verify", bytes.NewBuffer(verifyBody))
-	if err != nil {
-		// If the request cannot be created, return 500
-		c.JSON(500, gin.H{"error": "Invalid verifier request", "details": err.Error()})
+	payment := Event{
+		RequestID: c.GetString(requestIDKey),
+		Nonce:     nonce,
+		Amount:    paymentCtx.Amount,
+		Token:     paymentCtx.Token,
+		ChainID:   paymentCtx.ChainID,
+		Route:     c.FullPath(),
+	}
+	if !verifyResp.IsValid {
+		payment.Type, payment.Reason = eventPaymentRejected, verifyResp.Error
+		emitEvent(payment)
+		abortWithProblem(c, newProblem(403, codeInvalidSignature, "Invalid Signature", verifyResp.Error))
+		return
+	}
+	// Channel and prepaid payments spend their own nonces, and escrow holds
+	// its nonce until the request is delivered so a failed one can retry
+	if !offChain && getSettlementMode() != settlementEscrow && !spendNonce(c, nonce) {
+		return
+	}
+	c.Set(verifiedPayerKey, strings.ToLower(verifyResp.RecoveredAddress))
+	// Wallets with a low reputation pay REPUTATION_SURCHARGE_PERCENT more,
+	// which is only known once the signer is verified
+	if !offChain && !checkReputationSurcharge(c, verifyResp.RecoveredAddress, pluginReq.Price, promo, paymentCtx) {
 		return
 	}
-	vreq.Header.Set("Content-Type", "application/json")
 
-	// Use http.DefaultClient and rely on verifierCtx for timeouts/cancellation.
-	resp, err := http.DefaultClient.Do(vreq)
+	// 4. Resolve the model and check the wallet is entitled to it, before
+	// the payment is settled, held or its promo code redeemed, so a refusal
+	// costs the client nothing
+	model, err := resolveModel(req.Model, verifyResp.RecoveredAddress)
 	if err != nil {
-		// If the verifier or parent context timed out, return Gateway Timeout
-		if errors.Is(err, context.DeadlineExceeded) || verifierCtx.Err() == context.DeadlineExceeded || c.Request.Context().Err() == context.DeadlineExceeded {
-			c.JSON(504, gin.H{"error": "Gateway Timeout", "message": "Verifier request timed out"})
+		var notEntitled *modelNotEntitledError
+		if errors.As(err, &notEntitled) {
+			abortWithProblem(c, newProblem(403, codeModelNotEntitled, "Model Not Entitled", notEntitled.Error()).
+				With("model", notEntitled.Model).
+				With("plan", notEntitled.Plan).
+				With("allowed_models", notEntitled.Allowed))
 			return
 		}
-		c.JSON(500, gin.H{"error": "Verification service unavailable"})
+		abortWithProblem(c, newProblem(500, codeModelResolutionFailed, "Failed to resolve model", err.Error()))
 		return
 	}
-	defer resp.Body.Close()
 
-	var verifyResp VerifyResponse
-	if err := json.NewDecoder(resp.Body).Decode(&verifyResp); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to decode verification response"})
+	// Scan for prompt injection now that the wallet is known, so flagged
+	// input counts against its abuse score
+	if reject, rules := checkInjection(c, verifyResp.RecoveredAddress, req.Text); reject {
+		abortWithProblem(c, newProblem(422, codePromptInjection, "Prompt Injection Detected",
+			"The text matches known prompt-injection patterns").
+			With("rules", rules))
 		return
 	}
 
-	if !verifyResp.IsValid {
-		c.JSON(403, gin.H{"error": "Invalid Signature", "details": verifyResp.Error})
+	// A wallet's own rate limit can only be applied once its signature is
+	// verified
+	if !allowWalletRequest(c, verifyResp.RecoveredAddress) {
 		return
 	}
-
-	// 3. Parse request body
-	var req SummarizeRequest
-	if err := json.Unmarshal(requestBody, &req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request body"})
+	walletTiers.observe(c.Request.Context(), verifyResp.RecoveredAddress)
+	// In escrow mode the payment is held until the response is delivered
+	// and released if the request fails, instead of being settled now. In
+	// facilitator mode the facilitator checks it can settle the payment
+	// now and settles it once the response is ready. Channel payments are
+	// settled together when the channel closes, and prepaid ones already
+	// were with the deposit.
+	settlement := SettlementRequest{Nonce: nonce, Wallet: strings.ToLower(verifyResp.RecoveredAddress), Signature: signature, PaymentContext: paymentCtx}
+	mode := getSettlementMode()
+	if paidByChannel {
+		mode = settlementChannel
+	} else if paidByPrepaid {
+		mode = settlementPrepaid
+	}
+	escrow, facilitated := mode == settlementEscrow, mode == settlementFacilitator
+	if facilitated && !verifyWithFacilitator(c, settlement) {
+		// A refusal counts against the wallet; an unreachable facilitator
+		// doesn't
+		if c.Writer.Status() == http.StatusPaymentRequired {
+			walletReputation.record(verifyResp.RecoveredAddress, signalFailedVerification)
+		}
 		return
 	}
-
-	// 4. Call AI Service
-	summary, err := callOpenRouter(c.Request.Context(), req.Text)
-	if err != nil {
-		// If the error was due to a timeout, return 504
-		if errors.Is(err, context.DeadlineExceeded) || c.Request.Context().Err() == context.DeadlineExceeded {
-			c.JSON(504, gin.H{"error": "Gateway Timeout", "message": "AI request timed out"})
+	if escrow {
+		if !holdPayment(c, nonce) {
 			return
 		}
-		c.JSON(500, gin.H{"error": "AI Service Failed", "details": err.Error()})
+		defer func() { finishEscrow(c, settlement) }()
+	}
+	// Cap the wallet's parallel requests so one client can't tie up the
+	// AI timeout budget and provider quota; checked before the payment is
+	// recorded, so a refused request leaves no trace to reconcile
+	maxConcurrent := getWalletMaxConcurrent()
+	releaseSlot, ok := walletConcurrency.acquire(verifyResp.RecoveredAddress, maxConcurrent)
+	if !ok {
+		walletReputation.record(verifyResp.RecoveredAddress, signalRateLimited)
+		abortWalletBusy(c, maxConcurrent)
+		return
+	}
+	defer releaseSlot()
+	// The code's use is counted only once the payment is known to be good
+	if promo != nil && !redeemPromoCode(c, promo) {
 		return
 	}
+	if mode != settlementChannel && mode != settlementPrepaid {
+		recordAuthorization(nonce, verifyResp.RecoveredAddress, paymentCtx.Amount)
+	}
+	if mode == settlementImmediate {
+		submitSettlement(settlement)
+		defer refundIfFailed(c, paymentCtx, verifyResp.RecoveredAddress)
+	}
+	payment.Wallet = strings.ToLower(verifyResp.RecoveredAddress)
+	payment.Type = eventPaymentVerified
+	emitEvent(payment)
+	notifyFirstPayment(c.Request.Context(), verifyResp.RecoveredAddress, nonce, paymentCtx.Amount)
+
+	// 5. Check response cache (the request is still paid; a hit only saves
+	// the upstream AI call). X-Cache-Bypass: true skips the lookup and the
+	// fresh result overwrites the cached entry.
+	cacheKey := getCacheKey(c.Request.Context(), model, req.Text, opts.cacheParams())
+	bypassCache := isCacheBypass(c)
+	if bypassCache {
+		cacheRequestsTotal.Inc("bypass")
+	}
+	var summary, provider string
+	var usage aiUsage
+	var cached *CachedResponse
+	var hit, stale bool
+	if !bypassCache {
+		cached, hit = getCachedResponse(c.Request.Context(), cacheKey, req.Text)
+	}
+	if hit {
+		slog.Debug("cache hit", "key", cacheKey, "request_id", c.GetString(requestIDKey), "stale", cached.isStale(time.Now()))
+		summary = cached.Result
+		// Stale-while-revalidate: answer now, refresh for the next caller
+		if stale = cached.isStale(time.Now()); stale {
+			refreshCacheInBackground(c.Request.Context(), cacheKey, model, req.Text, opts)
+			c.Header("X-Cache", "STALE")
+		} else {
+			c.Header("X-Cache", "HIT")
+		}
+		c.Header("X-Cache-Age", strconv.Itoa(int(time.Since(cached.CachedAt).Seconds())))
+	} else {
+		if bypassCache {
+			c.Header("X-Cache", "BYPASS")
+		} else {
+			c.Header("X-Cache", "MISS")
+		}
 
-	// 5. Generate cryptographic receipt
+		// 6. Call AI Service (concurrent identical requests share one call)
+		aiCtx, rec := withProviderRecorder(withPayer(c.Request.Context(), verifyResp.RecoveredAddress))
+		summary, err = fetchSummary(aiCtx, cacheKey, model, req.Text, opts)
+		if err != nil {
+			var flagged *moderationFlaggedError
+			var unscreened *moderationUnavailableError
+			if errors.As(err, &flagged) || errors.As(err, &unscreened) {
+				if flagged != nil {
+					walletReputation.record(verifyResp.RecoveredAddress, signalModerationFlag)
+				}
+				abortWithProblem(c, moderationProblem(err, nonce))
+				return
+			}
+			// If the error was due to a timeout, return 504
+			if errors.Is(err, context.DeadlineExceeded) || c.Request.Context().Err() == context.DeadlineExceeded {
+				abortWithProblem(c, newProblem(504, codeAITimeout, "Gateway Timeout", "AI request timed out"))
+				return
+			}
+			if errors.Is(err, errCircuitOpen) {
+				_, retryAfter := aiBreakers.available(model)
+				abortAIUnavailable(c, retryAfter)
+				return
+			}
+			abortWithProblem(c, newProblem(500, codeAIServiceFailed, "AI Service Failed", err.Error()))
+			return
+		}
+		provider = rec.Name()
+		// The mock provider's placeholder is no summary: failing the request
+		// refunds the payment, or releases it from escrow
+		if provider == "mock" {
+			abortAIUnavailable(c, 5*time.Second)
+			return
+		}
+		usage = rec.Usage()
+		c.Header("X-AI-Provider", provider)
+	}
+
+	var txHash string
+	if facilitated {
+		if txHash, ok = settleWithFacilitator(c, settlement); !ok {
+			return
+		}
+		trackFacilitatorSettlement(settlement, c.Request.URL.Path, txHash)
+	}
+
+	// 7. Generate cryptographic receipt
 	// NOTE: Response hashing is performed on the AI response body
 	// Large responses (>1MB) may cause slight delays during hashing
 	// Expected typical response size: <100KB for summaries
 	responseBody := []byte(summary) // Response body for hashing
-	receipt, err := GenerateReceipt(paymentCtx, verifyResp.RecoveredAddress, c.Request.URL.Path, requestBody, responseBody)
+	receipt, err := GenerateReceipt(paymentCtx, verifyResp.RecoveredAddress, c.Request.URL.Path, requestBody, responseBody, usage.details())
 	if err != nil {
 		log.Printf("error generating receipt: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to generate receipt", "details": err.Error()})
+		abortWithProblem(c, newProblem(500, codeReceiptFailed, "Failed to generate receipt", err.Error()))
 		return
 	}
 
-	// 6. Store receipt with TTL
+	settlement.ReceiptID = receipt.Receipt.ID
+
+	// 8. Store receipt with TTL
 	if err := storeReceipt(receipt, getReceiptTTL()); err != nil {
 		log.Printf("error storing receipt: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to store receipt"})
+		abortWithProblem(c, newProblem(500, codeReceiptFailed, "Failed to store receipt", ""))
 		return
 	}
-
-	// 7. Encode receipt for header
+	anchorReceipt(receipt)
+	trackRequestCost(nonce, provider, model, paymentCtx.Amount, usage)
+	entry := LedgerEntry{
+		ReceiptID:        receipt.Receipt.ID,
+		Time:             receipt.Receipt.Timestamp,
+		Wallet:           strings.ToLower(verifyResp.RecoveredAddress),
+		Route:            c.FullPath(),
+		Nonce:            nonce,
+		Amount:           paymentCtx.Amount,
+		Token:            paymentCtx.Token,
+		ChainID:          paymentCtx.ChainID,
+		Model:            model,
+		Provider:         provider,
+		PromptTokens:     usage.promptTokens,
+		CompletionTokens: usage.completionTokens,
+		LatencyMS:        time.Since(start).Milliseconds(),
+		CacheHit:         hit,
+		TxHash:           txHash,
+		CacheKey:         cacheKey,
+		Tenant:           tenantID(c.Request.Context()),
+	}
+	if promo != nil {
+		entry.PromoCode, entry.Discount = promo.Code, discount
+	}
+	if d := usage.details(); d != nil {
+		entry.ProviderCost = d.Cost
+	}
+	recordLedgerEntry(c.Request.Context(), entry)
+	served := payment
+	served.Type, served.Model, served.Provider, served.ReceiptID, served.LatencyMS = eventResponseServed, model, provider, entry.ReceiptID, entry.LatencyMS
+	emitEvent(served)
+	if hit {
+		served.Type = eventCacheHit
+		emitEvent(served)
+	}
+
+	// 9. Encode receipt for header
 	receiptJSON, err := json.Marshal(receipt)
 	if err != nil {
 		log.Printf("error marshaling receipt: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to encode receipt"})
+		abortWithProblem(c, newProblem(500, codeReceiptFailed, "Failed to encode receipt", ""))
 		return
 	}
 	receiptBase64 := base64.StdEncoding.EncodeToString(receiptJSON)
 
-	// 8. Add receipt to response
+	// 10. Add receipt to response
 	c.Header("X-402-Receipt", receiptBase64)
-	c.JSON(200, gin.H{
-		"result":  summary,
-		"receipt": receipt,
+	response := SummarizeResponse{Result: summary, Receipt: receipt, Stale: stale, Provider: provider, OutputLanguage: language}
+	if !opts.Generation.isZero() {
+		response.Generation = &opts.Generation
+	}
+	c.JSON(200, response)
+	runPostResponseHooks(c.Request.Context(), pluginReq, &PluginResponse{
+		Summary: summary, ReceiptID: entry.ReceiptID, Wallet: entry.Wallet, Amount: paymentCtx.Amount,
+		Provider: provider, CacheHit: hit, Latency: time.Since(start),
 	})
 }
 
-// createPaymentContext constructs a PaymentContext prefilled with the recipient address (from RECIPIENT_ADDRESS or a fallback), the USDC token, amount "0.001", a newly generated UUID nonce, and chain ID 8453.
-func createPaymentContext() PaymentContext {
+// verifyPayment has the verifier check the signature of paymentCtx. It
+// reports whether the verifier answered, and has aborted the request with
+// the matching problem otherwise; an invalid signature is left to the
+// caller.
+func verifyPayment(c *gin.Context, paymentCtx PaymentContext, signature, text string) (VerifyResponse, bool) {
+	verifyReq := VerifyRequest{
+		Context:   paymentCtx,
+		Signature: signature,
+	}
+
+	slog.Debug("verifying payment", "request_id", c.GetString(requestIDKey), "nonce", paymentCtx.Nonce,
+		"amount", paymentCtx.Amount, "signature", signature, "text", text)
+	// Call verifier with its own timeout
+	verifierCtx, verifierCancel := context.WithTimeout(c.Request.Context(), getVerifierTimeout())
+	defer verifierCancel()
+
+	verifyStart := time.Now()
+	verifyResp, verifyStatus, err := callVerifier(verifierCtx, verifyReq, verifierContextHeaders(c))
+	recordStage(c.Request.Context(), stageVerification, time.Since(verifyStart))
+	verifyLatency := time.Since(verifyStart).Milliseconds()
+	switch {
+	case errors.Is(err, errVerifierRequest):
+		// If the request cannot be created, return 500
+		abortWithProblem(c, newProblem(500, codeVerifierError, "Invalid verifier request", err.Error()))
+		return verifyResp, false
+	case errors.Is(err, errVerifierResponse):
+		slog.Info("verifier call", "request_id", c.GetString(requestIDKey), "nonce", paymentCtx.Nonce,
+			"status", verifyStatus, "latency_ms", verifyLatency, "valid", false)
+		abortWithProblem(c, newProblem(500, codeVerifierError, "Failed to decode verification response", ""))
+		return verifyResp, false
+	case err != nil:
+		slog.Warn("verifier call failed", "request_id", c.GetString(requestIDKey), "nonce", paymentCtx.Nonce,
+			"latency_ms", verifyLatency, "error", err)
+		// If the verifier or parent context timed out, return Gateway Timeout
+		if errors.Is(err, context.DeadlineExceeded) || verifierCtx.Err() == context.DeadlineExceeded || c.Request.Context().Err() == context.DeadlineExceeded {
+			abortWithProblem(c, newProblem(504, codeVerifierTimeout, "Gateway Timeout", "Verifier request timed out"))
+			return verifyResp, false
+		}
+		abortWithProblem(c, newProblem(500, codeVerifierError, "Verification service unavailable", ""))
+		return verifyResp, false
+	}
+	slog.Info("verifier call", "request_id", c.GetString(requestIDKey), "nonce", paymentCtx.Nonce,
+		"status", verifyStatus, "latency_ms", verifyLatency, "valid", verifyResp.IsValid)
+	return verifyResp, true
+}
+
+// createPaymentContext constructs a PaymentContext prefilled with the recipient address (from RECIPIENT_ADDRESS or a fallback), the PAYMENT_TOKEN (USDC), the PAYMENT_AMOUNT converted to the token and raised for the current load, a nonce issued for route, and chain ID 8453.
+// The recipient and amount are those of ctx's tenant, if any. It also returns when the nonce expires.
+func createPaymentContext(ctx context.Context, route string) (PaymentContext, time.Time) {
+	load := currentLoad()
+	if load > 100 {
+		loadPricedQuotesTotal.Inc()
+	}
+	nonce, expiresAt := issueNonce(route, load)
 	return PaymentContext{
-		Recipient: getRecipientAddress(),
-		Token:     "USDC",
-		Amount:    getPaymentAmount(),
-		Nonce:     uuid.New().String(),
+		Recipient: recipientFor(ctx),
+		Token:     getPaymentToken(),
+		Amount:    applyLoad(usdToToken(paymentAmountFor(ctx)), load),
+		Nonce:     nonce,
 		ChainID:   getChainID(),
-	}
+	}, expiresAt
 }
 
-// getRecipientAddress retrieves the recipient address from the RECIPIENT_ADDRESS environment variable.
-// If RECIPIENT_ADDRESS is unset, it logs a warning and returns the default address "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219".
+// bindPaymentToBody reports whether PAYMENT_BIND_BODY (default true) adds
+// the text's hash to the signed payment context. Turn it off only while
+// clients that sign the four-field context are being upgraded.
+func bindPaymentToBody() bool {
+	return strings.ToLower(os.Getenv("PAYMENT_BIND_BODY")) != "false"
+}
+
+// paymentBodyHash returns the 0x-prefixed keccak256 of text, as signed in
+// the bodyHash field.
+func paymentBodyHash(text string) string {
+	return "0x" + hex.EncodeToString(crypto.Keccak256([]byte(text)))
+}
+
+// defaultRecipientAddress receives payments when RECIPIENT_ADDRESS is unset.
+const defaultRecipientAddress = "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"
+
+// getRecipientAddress retrieves the recipient address from the RECIPIENT_ADDRESS environment variable,
+// or the address it resolves to when it is an ENS name.
+// If RECIPIENT_ADDRESS is unset, it logs a warning and returns defaultRecipientAddress.
 func getRecipientAddress() string {
+	if recipientENS != nil {
+		return recipientENS.current()
+	}
+	if appConfig != nil {
+		return appConfig.RecipientAddress
+	}
 	addr := os.Getenv("RECIPIENT_ADDRESS")
 	if addr == "" {
 		log.Println("Warning: RECIPIENT_ADDRESS not set, using default")
-		return "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"
+		return defaultRecipientAddress
 	}
 	return addr
 }
@@ -371,6 +1019,9 @@ func getRecipientAddress() string {
 // getPaymentAmount returns the payment amount from the PAYMENT_AMOUNT environment variable.
 // If unset, it defaults to "0.001".
 func getPaymentAmount() string {
+	if appConfig != nil {
+		return appConfig.PaymentAmount
+	}
 	amount := os.Getenv("PAYMENT_AMOUNT")
 	if amount == "" {
 		return "0.001"
@@ -381,6 +1032,9 @@ func getPaymentAmount() string {
 // getChainID returns the blockchain chain ID from the CHAIN_ID environment variable.
 // If unset or invalid, it defaults to 8453 (Base).
 func getChainID() int {
+	if appConfig != nil {
+		return appConfig.ChainID
+	}
 	chainIDStr := os.Getenv("CHAIN_ID")
 	if chainIDStr == "" {
 		return 8453
@@ -393,31 +1047,36 @@ func getChainID() int {
 	return chainID
 }
 
-// callOpenRouter sends the given text to the OpenRouter chat completions API
-// requesting a two-sentence summary and returns the generated summary.
-// It reads OPENROUTER_API_KEY for authorization and OPENROUTER_MODEL to select
-// the model (defaults to "z-ai/glm-4.5-air:free" if unset).
-func callOpenRouter(ctx context.Context, text string) (string, error) {
-	apiKey := os.Getenv("OPENROUTER_API_KEY")
-	model := os.Getenv("OPENROUTER_MODEL")
+// callAI asks the provider chain to summarize text with the summarize
+// prompt template, in opts.OutputLanguage if set, and returns the summary. An empty model selects the
+// default from getDefaultModel.
+func callAI(ctx context.Context, model, text string, opts summaryOptions) (string, error) {
+	prompt := promptFor("summarize").Render(promptData{Text: text, Language: outputLanguageNames[opts.OutputLanguage]})
+	return callAIPrompt(ctx, model, prompt)
+}
+
+// callOpenRouterPrompt sends prompt as a single user message to the
+// OpenRouter chat completions API and returns the reply content. It reads
+// OPENROUTER_API_KEY, or the tenant's own key, for authorization. The usage
+// block of the reply is recorded in ctx (see recordUsage).
+func callOpenRouterPrompt(ctx context.Context, model, prompt string) (string, error) {
+	apiKey := providerAPIKey(ctx, "openrouter")
 	if model == "" {
-		model = "z-ai/glm-4.5-air:free"
+		model = getDefaultModel()
 	}
 
-	prompt := fmt.Sprintf("Summarize this text in 2 sentences: %s", text)
-
-	reqBody, _ := json.Marshal(map[string]interface{}{
+	body := map[string]interface{}{
 		"model": model,
 		"messages": []map[string]string{
 			{"role": "user", "content": prompt},
 		},
-	})
-
-	openRouterURL := os.Getenv("OPENROUTER_URL")
-	if openRouterURL == "" {
-		openRouterURL = "https://openrouter.ai/api/v1/chat/completions"
+		// Ask for the call's cost in the usage block
+		"usage": map[string]bool{"include": true},
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", openRouterURL, bytes.NewBuffer(reqBody))
+	generationParamsFrom(ctx).applyTo(body)
+	reqBody, _ := json.Marshal(body)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", getOpenRouterURL(), bytes.NewBuffer(reqBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create OpenRouter request: %w", err)
 	}
@@ -433,12 +1092,19 @@ func callOpenRouter(ctx context.Context, text string) (string, error) {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", newProviderStatusError("openrouter", resp)
+	}
 
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", fmt.Errorf("failed to decode AI response: %w", err)
 	}
 
+	if usage, ok := result["usage"].(map[string]interface{}); ok {
+		recordUsage(ctx, openRouterUsage(usage))
+	}
+
 	choices, ok := result["choices"].([]interface{})
 	if !ok || len(choices) == 0 {
 		log.Printf("OpenRouter response: %+v", result)
@@ -463,10 +1129,6 @@ func callOpenRouter(ctx context.Context, text string) (string, error) {
 	return content, nil
 }
 
-func handleHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "service": "gateway"})
-}
-
 // Rate Limiting Functions
 
 // initRateLimiters creates rate limiters for each tier
@@ -493,37 +1155,85 @@ func initRateLimiters() map[string]RateLimiter {
 	}
 }
 
-// RateLimitMiddleware applies rate limiting to requests
+// RateLimitMiddleware applies rate limiting to requests. A tenant with its
+// own limit replaces the tiers for its requests, and an operator override
+// for the client's IP replaces both. With POW_CHALLENGE, anonymous clients
+// near their limit must solve a proof-of-work challenge. The limiters are
+// also listed by GET /admin/ratelimits.
 func RateLimitMiddleware(limiters map[string]RateLimiter) gin.HandlerFunc {
+	custom := newCustomRateLimiters(time.Duration(getEnvAsInt("RATE_LIMIT_CLEANUP_INTERVAL", 300)) * time.Second)
+	activeRateLimits = &rateLimitSet{tiers: limiters, custom: custom}
 	return func(c *gin.Context) {
 		// Determine rate limit key and tier
 		key := getRateLimitKey(c)
 		tier := selectRateLimitTier(c)
-		limiter := limiters[tier]
-
-		// Check if request is allowed
-		if !limiter.Allow(key) {
-			retryAfter := calculateRetryAfter(limiter, key)
-			c.Header("Retry-After", strconv.Itoa(retryAfter))
-			c.Header("X-RateLimit-Limit", strconv.Itoa(getLimitForTier(tier)))
-			c.Header("X-RateLimit-Remaining", "0")
-			c.Header("X-RateLimit-Reset", strconv.FormatInt(limiter.GetResetTime(key), 10))
-			c.JSON(429, gin.H{
-				"error":       "Too Many Requests",
-				"message":     "Rate limit exceeded. Please retry later.",
-				"retry_after": retryAfter,
-			})
-			c.Abort()
+		limiter, limit := limiters[tier], getLimitForTier(tier)
+		if t := tenantFrom(c.Request.Context()); t != nil && t.RateLimitRPM > 0 {
+			limiter, limit = custom.get(tenantRateLimitPrefix+t.ID, t.RateLimitRPM, t.rateLimitBurst()), t.RateLimitRPM
+		}
+		if o := rateLimitOverrides.lookup(ipOverridePrefix+c.ClientIP(), time.Now()); o != nil {
+			key, limiter, limit = o.Key, custom.get(o.Key, o.RPM, o.burst()), o.RPM
+		}
+
+		// Unsigned clients close to the anonymous limit prove work for
+		// their last requests before the hard 429
+		if powEnabled() && strings.HasPrefix(key, "ip:") && limiter == limiters["anonymous"] && !requireProofOfWork(c, limiter, key) {
+			return
+		}
+
+		if !applyRateLimit(c, limiter, key, limit) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Add rate limit headers to successful responses
-		c.Header("X-RateLimit-Limit", strconv.Itoa(getLimitForTier(tier)))
-		c.Header("X-RateLimit-Remaining", strconv.Itoa(limiter.GetRemaining(key)))
+// applyRateLimit takes a token from key's bucket in limiter and sets the
+// X-RateLimit headers. When the bucket is empty it answers 429
+// rate_limited and returns false.
+func applyRateLimit(c *gin.Context, limiter RateLimiter, key string, limit int) bool {
+	// Check if request is allowed
+	if !limiter.Allow(key) {
+		retryAfter := calculateRetryAfter(limiter, key)
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", "0")
 		c.Header("X-RateLimit-Reset", strconv.FormatInt(limiter.GetResetTime(key), 10))
+		abortWithProblem(c, newProblem(429, codeRateLimited, "Too Many Requests", "Rate limit exceeded. Please retry later.").
+			With("retry_after", retryAfter))
+		return false
+	}
 
-		c.Next()
+	// Add rate limit headers to successful responses
+	c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(limiter.GetRemaining(key)))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(limiter.GetResetTime(key), 10))
+	return true
+}
+
+// traceHeaders are the distributed-tracing headers passed on to the
+// verifier: W3C Trace Context and Baggage, and Zipkin B3.
+var traceHeaders = []string{
+	"traceparent", "tracestate", "baggage",
+	"b3", "X-B3-TraceId", "X-B3-SpanId", "X-B3-ParentSpanId", "X-B3-Sampled",
+}
+
+// verifierContextHeaders tags a verifier call with the request ID, the
+// client's trace headers and the rate-limit tier (X-Paygate-Tier), so the
+// verifier's logs can be joined with the gateway's. With gRPC they are sent
+// as metadata.
+func verifierContextHeaders(c *gin.Context) http.Header {
+	h := http.Header{}
+	if id := c.GetString(requestIDKey); id != "" {
+		h.Set("X-Request-ID", id)
+	}
+	for _, name := range traceHeaders {
+		if v := c.GetHeader(name); v != "" {
+			h.Set(name, v)
+		}
 	}
+	h.Set("X-Paygate-Tier", selectRateLimitTier(c))
+	return h
 }
 
 // getRateLimitKey determines the key for rate limiting (nonce/wallet > IP)
@@ -549,9 +1259,12 @@ func selectRateLimitTier(c *gin.Context) string {
 	nonce := c.GetHeader("X-402-Nonce")
 
 	if signature != "" && nonce != "" {
-		// Future: Check if user is verified/premium
-		// For now, all signed requests get standard tier
-		return "standard"
+		// Wallets promoted by their payment history get the verified
+		// tier; the rest, and requests without X-402-Payment to recover
+		// the signer from, get standard. A low reputation is only held
+		// against a wallet once its signature is verified, in
+		// allowWalletRequest
+		return walletTiers.tier(signedPayer(c))
 	}
 
 	// Unsigned requests get anonymous tier
@@ -585,6 +1298,9 @@ func getLimitForTier(tier string) int {
 
 // getRateLimitEnabled checks if rate limiting is enabled
 func getRateLimitEnabled() bool {
+	if appConfig != nil {
+		return appConfig.RateLimitEnabled
+	}
 	enabled := strings.ToLower(os.Getenv("RATE_LIMIT_ENABLED"))
 	return enabled == "true" || enabled == "1"
 }
@@ -653,20 +1369,29 @@ func cleanupExpiredReceipts() {
 	}
 }
 
-// storeReceipt stores a receipt with TTL
-// Returns error for future extensibility (Redis/Postgres implementations)
+// storeReceipt stores a receipt with TTL, in the store too when
+// DATABASE_URL is set, so it outlives a restart and any instance finds it
 func storeReceipt(receipt *SignedReceipt, ttl time.Duration) error {
 	// Validate receipt format before storage
 	if err := validateReceipt(receipt); err != nil {
 		return fmt.Errorf("invalid receipt format: %w", err)
 	}
 
+	expiresAt := time.Now().Add(ttl)
+	if dataStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := dataStore.SaveReceipt(ctx, receipt, expiresAt); err != nil {
+			return fmt.Errorf("saving receipt: %w", err)
+		}
+	}
+
 	receiptStoreMu.Lock()
 	defer receiptStoreMu.Unlock()
 
 	receiptStore[receipt.Receipt.ID] = &receiptEntry{
 		receipt:   receipt,
-		expiresAt: time.Now().Add(ttl),
+		expiresAt: expiresAt,
 	}
 
 	return nil
@@ -739,14 +1464,14 @@ func validateReceipt(receipt *SignedReceipt) error {
 	return nil
 }
 
-// getReceipt retrieves a receipt by ID
+// getReceipt retrieves a receipt by ID, from the store when this instance
+// didn't issue it or has restarted since
 func getReceipt(id string) (*SignedReceipt, bool) {
 	receiptStoreMu.RLock()
-	defer receiptStoreMu.RUnlock()
-
 	entry, exists := receiptStore[id]
+	receiptStoreMu.RUnlock()
 	if !exists {
-		return nil, false
+		return storedReceipt(id)
 	}
 
 	// Check if expired
@@ -757,33 +1482,79 @@ func getReceipt(id string) (*SignedReceipt, bool) {
 	return entry.receipt, true
 }
 
+// storedReceipt looks a receipt up in the store, if there is one.
+func storedReceipt(id string) (*SignedReceipt, bool) {
+	if dataStore == nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receipt, err := dataStore.Receipt(ctx, id)
+	if err != nil {
+		log.Printf("error reading receipt %s from the store: %v", id, err)
+	}
+	return receipt, receipt != nil
+}
+
 // getReceiptTTL returns configured TTL or default 24h
 func getReceiptTTL() time.Duration {
+	if appConfig != nil {
+		return appConfig.ReceiptTTL
+	}
 	ttlSeconds := getEnvAsInt("RECEIPT_TTL", 86400)
 	return time.Duration(ttlSeconds) * time.Second
 }
 
-// handleGetReceipt handles GET /api/receipts/:id
+// handleGetReceipt handles GET /v1/receipts/:id
 func handleGetReceipt(c *gin.Context) {
 	id := c.Param("id")
 
 	receipt, exists := getReceipt(id)
 	if !exists {
-		c.JSON(404, gin.H{
-			"error":   "Receipt not found",
-			"message": "Receipt may have expired or never existed",
-		})
+		abortWithProblem(c, newProblem(404, codeReceiptNotFound, "Receipt not found", "Receipt may have expired or never existed"))
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"receipt":           receipt.Receipt,
-		"signature":         receipt.Signature,
-		"server_public_key": receipt.ServerPublicKey,
-		"status":            "valid",
+	c.JSON(200, ReceiptLookupResponse{
+		Receipt:         receipt.Receipt,
+		Signature:       receipt.Signature,
+		ServerPublicKey: receipt.ServerPublicKey,
+		Status:          "valid",
 	})
 }
 
+// handleVerifyReceipt handles GET /v1/receipts/:id/verify. It checks the
+// stored receipt's signature against the gateway's key and returns the
+// canonical contents it covers. An invalid signature is still a 200, with
+// valid false and the reason.
+func handleVerifyReceipt(c *gin.Context) {
+	receipt, exists := getReceipt(c.Param("id"))
+	if !exists {
+		abortWithProblem(c, newProblem(404, codeReceiptNotFound, "Receipt not found", "Receipt may have expired or never existed"))
+		return
+	}
+
+	canonical, hash, err := receiptDigest(receipt.Receipt)
+	if err != nil {
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to encode receipt", ""))
+		return
+	}
+	response := ReceiptVerificationResponse{
+		Valid:           true,
+		Canonical:       string(canonical),
+		Digest:          hash.Hex(),
+		Receipt:         receipt.Receipt,
+		Signature:       receipt.Signature,
+		ServerPublicKey: receipt.ServerPublicKey,
+	}
+	response.SignerAddress, err = checkReceiptSignature(receipt)
+	if err != nil {
+		response.Valid = false
+		response.Reason = err.Error()
+	}
+	c.JSON(200, response)
+}
+
 // Server private key management
 var (
 	serverPrivateKey     *ecdsa.PrivateKey
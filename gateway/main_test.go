@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -9,10 +10,21 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"gateway/client"
+	"gateway/testsupport"
 
 	"github.com/gin-gonic/gin"
 )
 
+// TestMain lets the handler tests send fixed nonces; nonce_test.go covers
+// the checks on gateway-issued ones.
+func TestMain(m *testing.M) {
+	os.Setenv("NONCE_REQUIRE_ISSUED", "false")
+	os.Exit(m.Run())
+}
+
 func TestHandleSummarize_NoHeaders(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
@@ -35,8 +47,11 @@ func TestHandleSummarize_NoHeaders(t *testing.T) {
 		t.Fatalf("Failed to parse response JSON: %v", err)
 	}
 
-	if response["error"] != "Payment Required" {
-		t.Errorf("Expected error 'Payment Required', got '%v'", response["error"])
+	if response["code"] != "payment_required" || response["title"] != "Payment Required" {
+		t.Errorf("Expected payment_required problem, got %v", response)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/problem+json") {
+		t.Errorf("Expected problem+json content type, got %q", ct)
 	}
 
 	if response["paymentContext"] == nil {
@@ -108,8 +123,8 @@ func TestRateLimitMiddleware_AnonymousUser(t *testing.T) {
 	// Check 429 response body
 	var response map[string]interface{}
 	json.Unmarshal(w.Body.Bytes(), &response)
-	if response["error"] != "Too Many Requests" {
-		t.Errorf("Expected error 'Too Many Requests', got '%v'", response["error"])
+	if response["code"] != "rate_limited" || response["retry_after"] == nil {
+		t.Errorf("Expected rate_limited problem with retry_after, got %v", response)
 	}
 }
 
@@ -355,3 +370,160 @@ func TestRateLimitMiddleware_HeadersInResponse(t *testing.T) {
 		t.Error("Missing X-RateLimit-Reset header")
 	}
 }
+
+func TestClientSDK_AgainstGateway(t *testing.T) {
+	ensureTestServerKey(t)
+	signer, _ := client.NewPrivateKeySigner("380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc")
+
+	// Verifier that really checks the SDK's EIP-712 signature
+	verifier := testsupport.NewFakeVerifier(t)
+	verifier.VerifySignatures()
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Reply("sdk summary")
+
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("OPENROUTER_API_KEY", "test")
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	gin.SetMode(gin.TestMode)
+	gateway := httptest.NewServer(newRouter())
+	defer gateway.Close()
+
+	c := client.New(gateway.URL, client.WithSigner(signer))
+	res, err := c.Summarize(context.Background(), client.SummarizeRequest{Text: "client sdk integration text"})
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if res.Summary != "sdk summary" || res.RequestID == "" {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if res.Receipt == nil || res.Receipt.Receipt.Payment.Payer != signer.Address() {
+		t.Fatalf("Expected a receipt paid by %s, got %+v", signer.Address(), res.Receipt)
+	}
+	if err := res.Receipt.Verify(); err != nil {
+		t.Errorf("Expected the gateway receipt to verify: %v", err)
+	}
+
+	stored, err := c.GetReceipt(context.Background(), res.Receipt.Receipt.ID)
+	if err != nil || stored.Signature != res.Receipt.Signature {
+		t.Errorf("Expected GetReceipt to return the same receipt, got %v", err)
+	}
+	if _, err := c.GetReceipt(context.Background(), "rcpt_missing"); client.ErrorCode(err) != codeReceiptNotFound {
+		t.Errorf("Expected %s, got %v", codeReceiptNotFound, err)
+	}
+}
+
+func TestHandleSummarize_BindsPaymentToBody(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+	text := "Artificial intelligence is transforming software development."
+
+	send := func(nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"`+text+`"}`))
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	p := decodeProblem(t, send(""))
+	payment, _ := p["paymentContext"].(map[string]interface{})
+	if payment["bodyHash"] != client.BodyHash(text) {
+		t.Errorf("Expected the challenge to carry the text's hash, got %v", payment["bodyHash"])
+	}
+	send("n-bound-1")
+	if reqs := verifier.Requests(); len(reqs) != 1 || reqs[0].Context.BodyHash != client.BodyHash(text) {
+		t.Errorf("Expected the verifier to check the text's hash, got %+v", reqs)
+	}
+
+	t.Setenv("PAYMENT_BIND_BODY", "false")
+	p = decodeProblem(t, send(""))
+	if payment, _ := p["paymentContext"].(map[string]interface{}); payment["bodyHash"] != nil {
+		t.Errorf("Expected no bodyHash with PAYMENT_BIND_BODY=false, got %v", payment["bodyHash"])
+	}
+	send("n-bound-2")
+	if reqs := verifier.Requests(); len(reqs) != 2 || reqs[1].Context.BodyHash != "" {
+		t.Errorf("Expected the four-field context, got %+v", reqs)
+	}
+}
+
+func TestClientSDK_SignatureOnlyPaysForItsText(t *testing.T) {
+	ensureTestServerKey(t)
+	signer, _ := client.NewPrivateKeySigner("380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc")
+	verifier := testsupport.NewFakeVerifier(t)
+	verifier.VerifySignatures()
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+
+	// The challenge for the signed text, signed by the wallet
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"the text the wallet pays for"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var challenge struct {
+		PaymentContext client.PaymentContext `json:"paymentContext"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	signature, err := signer.SignPayment(context.Background(), challenge.PaymentContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The leaked signature replayed against another text
+	req, _ = http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"an attacker's text"}`))
+	req.Header.Set("X-402-Signature", signature)
+	req.Header.Set("X-402-Nonce", challenge.PaymentContext.Nonce)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp SummarizeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Receipt == nil {
+		t.Fatalf("Expected a summary paid by whoever the signature recovers to, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.EqualFold(resp.Receipt.Receipt.Payment.Payer, signer.Address()) {
+		t.Error("Expected a signature made for one text not to charge the wallet for another")
+	}
+}
+
+func TestHandleSummarize_ForwardsRequestContextToVerifier(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	registerAPIRoutes(r.Group(apiV1Prefix))
+
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"trace me"}`))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "n-trace")
+	req.Header.Set("X-Request-ID", "req-trace-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	headers := verifier.RequestHeaders()
+	if len(headers) != 1 {
+		t.Fatalf("Expected 1 verifier call, got %d", len(headers))
+	}
+	h := headers[0]
+	if h.Get("X-Request-ID") != "req-trace-1" || h.Get("traceparent") != req.Header.Get("traceparent") || h.Get("X-Paygate-Tier") != "standard" {
+		t.Errorf("Expected request ID, trace and tier headers, got %v", h)
+	}
+	if h.Get("tracestate") != "" {
+		t.Errorf("Expected absent trace headers not to be sent, got %q", h.Get("tracestate"))
+	}
+}
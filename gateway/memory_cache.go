@@ -0,0 +1,185 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryCache is the in-process L1 response cache. It sits in front of Redis
+// and keeps serving hot prompts when Redis is not configured or unreachable.
+// It is nil when disabled via CACHE_MEMORY_MAX_ENTRIES=0.
+var memoryCache *lruCache
+
+// lruCache is a size-bounded, TTL-aware least-recently-used cache.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List               // front = most recently used
+	items    map[string]*list.Element // key -> element holding *lruEntry
+}
+
+type lruEntry struct {
+	key       string
+	value     *CachedResponse
+	expiresAt time.Time
+}
+
+// newLRUCache creates an LRU holding at most capacity entries, each expiring
+// ttl after it was stored.
+func newLRUCache(capacity int, ttl time.Duration) *lruCache {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &lruCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// initMemoryCache builds the L1 cache from CACHE_MEMORY_MAX_ENTRIES (default
+// 1000) and CACHE_MEMORY_TTL_SECONDS (default 300, capped at the shared
+// CACHE_TTL_SECONDS so L1 never outlives Redis).
+func initMemoryCache() *lruCache {
+	maxEntries := getEnvAsInt("CACHE_MEMORY_MAX_ENTRIES", 1000)
+	if maxEntries <= 0 {
+		log.Println("In-memory response cache disabled")
+		return nil
+	}
+
+	ttl := time.Duration(getEnvAsInt("CACHE_MEMORY_TTL_SECONDS", 300)) * time.Second
+	if ttl <= 0 || ttl > getCacheTTL() {
+		ttl = getCacheTTL()
+	}
+
+	log.Printf("In-memory response cache enabled (max %d entries, ttl %s)", maxEntries, ttl)
+	return newLRUCache(maxEntries, ttl)
+}
+
+// Get returns the entry for key if present and not expired, marking it as
+// recently used. Expired entries are removed on access.
+func (c *lruCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set stores value under key, evicting the least recently used entry when
+// the cache is full.
+func (c *lruCache) Set(key string, value *CachedResponse) {
+	c.SetTTL(key, value, c.ttl)
+}
+
+// SetTTL is Set for an entry that expires after ttl, or the cache's own TTL
+// if that is shorter.
+func (c *lruCache) SetTTL(key string, value *CachedResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(min(ttl, c.ttl))
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Len returns the number of entries currently held (including any expired
+// entries not yet evicted).
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// removeElement drops el from both the list and the index. Caller holds mu.
+func (c *lruCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
+
+// Delete removes key and reports whether it was present.
+func (c *lruCache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.removeElement(el)
+	return true
+}
+
+// Clear removes every entry and returns how many were dropped.
+func (c *lruCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	return n
+}
+
+// PurgeBefore removes the entries cached before before and returns how many
+// were dropped.
+func (c *lruCache) PurgeBefore(before time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*lruEntry).value.CachedAt.Before(before) {
+			c.removeElement(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// TrimPrefix evicts the least recently used entries whose key starts with
+// prefix until at most limit remain, and returns the evicted keys.
+func (c *lruCache) TrimPrefix(prefix string, limit int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var evicted []string
+	n := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if key := el.Value.(*lruEntry).key; strings.HasPrefix(key, prefix) {
+			if n++; n > limit {
+				c.removeElement(el)
+				evicted = append(evicted, key)
+			}
+		}
+		el = next
+	}
+	return evicted
+}
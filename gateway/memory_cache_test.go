@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// setupTestMemoryCache installs a fresh L1 cache for the duration of the test.
+func setupTestMemoryCache(t *testing.T, capacity int, ttl time.Duration) *lruCache {
+	t.Helper()
+	prev := memoryCache
+	memoryCache = newLRUCache(capacity, ttl)
+	t.Cleanup(func() { memoryCache = prev })
+	return memoryCache
+}
+
+func TestLRUCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(2, time.Minute)
+
+	c.Set("a", &CachedResponse{Result: "A"})
+	c.Set("b", &CachedResponse{Result: "B"})
+
+	// Touch "a" so "b" becomes the eviction candidate
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("Expected 'a' to be present")
+	}
+	c.Set("c", &CachedResponse{Result: "C"})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected 'b' to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("Expected 'a' to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("Expected 'c' to be present")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestLRUCache_ExpiresEntries(t *testing.T) {
+	c := newLRUCache(10, 50*time.Millisecond)
+	c.Set("k", &CachedResponse{Result: "v"})
+
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("Expected fresh entry to be present")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := c.Get("k"); ok {
+		t.Error("Expected expired entry to be gone")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected expired entry to be removed, got %d entries", c.Len())
+	}
+}
+
+func TestLRUCache_SetOverwrites(t *testing.T) {
+	c := newLRUCache(10, time.Minute)
+	c.Set("k", &CachedResponse{Result: "old"})
+	c.Set("k", &CachedResponse{Result: "new"})
+
+	got, ok := c.Get("k")
+	if !ok || got.Result != "new" {
+		t.Errorf("Expected overwritten value 'new', got %+v", got)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected 1 entry, got %d", c.Len())
+	}
+}
+
+func TestInitMemoryCache(t *testing.T) {
+	t.Setenv("CACHE_MEMORY_MAX_ENTRIES", "0")
+	if initMemoryCache() != nil {
+		t.Error("Expected memory cache to be disabled with max entries 0")
+	}
+
+	t.Setenv("CACHE_MEMORY_MAX_ENTRIES", "5")
+	t.Setenv("CACHE_TTL_SECONDS", "60")
+	t.Setenv("CACHE_MEMORY_TTL_SECONDS", "600")
+	c := initMemoryCache()
+	if c == nil {
+		t.Fatal("Expected memory cache to be enabled")
+	}
+	if c.capacity != 5 {
+		t.Errorf("Expected capacity 5, got %d", c.capacity)
+	}
+	if c.ttl != 60*time.Second {
+		t.Errorf("Expected L1 TTL capped at CACHE_TTL_SECONDS (60s), got %v", c.ttl)
+	}
+}
+
+func TestCachedResponse_MemoryFallbackWithoutRedis(t *testing.T) {
+	prev := redisClient
+	redisClient = nil
+	t.Cleanup(func() { redisClient = prev })
+	setupTestMemoryCache(t, 10, time.Minute)
+	ctx := context.Background()
+
+	if err := setCachedResponse(ctx, "ai:summary:k", "text", "summary"); err != nil {
+		t.Fatalf("setCachedResponse failed: %v", err)
+	}
+	cached, ok := getCachedResponse(ctx, "ai:summary:k", "text")
+	if !ok || cached.Result != "summary" {
+		t.Fatalf("Expected in-memory hit without Redis, got %+v, %v", cached, ok)
+	}
+}
+
+func TestCachedResponse_PromotesRedisHitsToMemory(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+
+	// Populate Redis only
+	prev := memoryCache
+	memoryCache = nil
+	if err := setCachedResponse(ctx, "ai:summary:k", "text", "summary"); err != nil {
+		t.Fatalf("setCachedResponse failed: %v", err)
+	}
+	memoryCache = prev
+
+	l1 := setupTestMemoryCache(t, 10, time.Minute)
+	before := cacheTierHitsTotal.Value("redis")
+
+	if _, ok := getCachedResponse(ctx, "ai:summary:k", "text"); !ok {
+		t.Fatal("Expected Redis hit")
+	}
+	if cacheTierHitsTotal.Value("redis") != before+1 {
+		t.Error("Expected hit to be served by Redis")
+	}
+	if _, ok := l1.Get("ai:summary:k"); !ok {
+		t.Error("Expected Redis hit to be promoted into the memory cache")
+	}
+}
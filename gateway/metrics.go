@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricCounter is a minimal Prometheus-compatible counter with optional
+// labels. It avoids pulling in the full client library for the handful of
+// counters the gateway exposes.
+type metricCounter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64 // keyed by joined label values
+}
+
+// metric is anything handleMetrics can render.
+type metric interface {
+	writeTo(sb *strings.Builder)
+}
+
+var (
+	metricsMu       sync.RWMutex
+	metricsRegistry []metric
+)
+
+// newCounter registers a counter with the given label names.
+func newCounter(name, help string, labels ...string) *metricCounter {
+	c := &metricCounter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	metricsMu.Lock()
+	metricsRegistry = append(metricsRegistry, c)
+	metricsMu.Unlock()
+	return c
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *metricCounter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by delta.
+func (c *metricCounter) Add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current value for the given label values.
+func (c *metricCounter) Value(labelValues ...string) float64 {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+// writeTo renders the counter in the Prometheus text exposition format.
+func (c *metricCounter) writeTo(sb *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(sb, "# TYPE %s counter\n", c.name)
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(sb, "%s%s %g\n", c.name, formatLabels(c.labels, k), c.values[k])
+	}
+}
+
+// metricHistogram is a minimal Prometheus-compatible histogram with
+// optional labels and fixed upper bounds.
+type metricHistogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // ascending upper bounds; +Inf is implied
+	mu      sync.Mutex
+	series  map[string]*histogramSeries // keyed by joined label values
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// newHistogram registers a histogram with the given bucket upper bounds
+// and label names.
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricHistogram {
+	h := &metricHistogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	metricsMu.Lock()
+	metricsRegistry = append(metricsRegistry, h)
+	metricsMu.Unlock()
+	return h
+}
+
+// Observe records v for the given label values.
+func (h *metricHistogram) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+// Count returns how many values were observed for the given label values.
+func (h *metricHistogram) Count(labelValues ...string) uint64 {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+// writeTo renders the histogram in the Prometheus text exposition format.
+func (h *metricHistogram) writeTo(sb *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(sb, "# TYPE %s histogram\n", h.name)
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := h.series[k]
+		labels := formatLabels(h.labels, k)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", fmt.Sprintf("%g", bound)), cumulative)
+		}
+		fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(sb, "%s_sum%s %g\n", h.name, labels, s.sum)
+		fmt.Fprintf(sb, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+// withLabel adds name="value" to labels as rendered by formatLabels.
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf(`%s="%s"`, name, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// formatLabels renders label pairs as {a="x",b="y"}; empty when unlabeled.
+func formatLabels(names []string, key string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, v))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// handleMetrics handles GET /metrics in the Prometheus text format.
+func handleMetrics(c *gin.Context) {
+	var sb strings.Builder
+	metricsMu.RLock()
+	for _, m := range metricsRegistry {
+		m.writeTo(&sb)
+	}
+	metricsMu.RUnlock()
+
+	c.Data(200, "text/plain; version=0.0.4; charset=utf-8", []byte(sb.String()))
+}
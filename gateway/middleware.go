@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -11,8 +12,27 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
+// requestIDKey is the gin context key holding the request ID.
+const requestIDKey = "request_id"
+
+// RequestIDMiddleware tags each request with an ID, reusing a client-supplied
+// X-Request-ID when it is reasonably short, and echoes it in the response so
+// error reports can be matched to gateway logs.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		c.Set(requestIDKey, id)
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
 // bufferedWriter captures response writes in-memory so the middleware can
 // decide whether to send the real response or a timeout response without
 // racing with handler writes.
@@ -141,6 +161,9 @@ func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 		}
 		c.Request = c.Request.WithContext(ctx)
 
+		// Read before the handler goroutine starts so the timeout path does not
+		// race with handlers touching the context keys.
+		requestID := c.GetString(requestIDKey)
 		origWriter := c.Writer
 		bw := newBufferedWriter()
 		// replace the gin writer with a shim that uses bw and keeps orig writer
@@ -177,9 +200,12 @@ func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 			bw.mu.Lock()
 			bw.closed = true
 			bw.mu.Unlock()
-			origWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+			p := newProblem(504, codeRequestTimeout, "Gateway Timeout", "Request exceeded maximum allowed time")
+			p.RequestID = requestID
+			body, _ := json.Marshal(p)
+			origWriter.Header().Set("Content-Type", problemContentType)
 			origWriter.WriteHeader(504)
-			_, _ = origWriter.Write([]byte(`{"error":"Gateway Timeout","message":"Request exceeded maximum allowed time"}`))
+			_, _ = origWriter.Write(body)
 			return
 		}
 	}
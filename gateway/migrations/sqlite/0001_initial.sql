@@ -0,0 +1,43 @@
+-- Receipts, the usage ledger, background jobs and API keys.
+CREATE TABLE receipts (
+	id         TEXT PRIMARY KEY,
+	payer      TEXT NOT NULL,
+	receipt    TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
+CREATE INDEX receipts_expires_at ON receipts (expires_at);
+
+CREATE TABLE ledger_entries (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	time   BIGINT NOT NULL,
+	wallet TEXT NOT NULL,
+	nonce  TEXT NOT NULL,
+	type   TEXT NOT NULL,
+	entry  TEXT NOT NULL
+);
+CREATE INDEX ledger_entries_time ON ledger_entries (time);
+CREATE INDEX ledger_entries_wallet ON ledger_entries (wallet, time);
+CREATE INDEX ledger_entries_nonce ON ledger_entries (nonce);
+
+CREATE TABLE jobs (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind         TEXT NOT NULL,
+	payload      TEXT NOT NULL,
+	run_at       BIGINT NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	locked_until BIGINT NOT NULL DEFAULT 0,
+	last_error   TEXT NOT NULL DEFAULT '',
+	done_at      BIGINT NOT NULL DEFAULT 0,
+	created_at   BIGINT NOT NULL
+);
+CREATE INDEX jobs_ready ON jobs (kind, done_at, run_at);
+
+CREATE TABLE api_keys (
+	id         TEXT PRIMARY KEY,
+	key_hash   TEXT NOT NULL UNIQUE,
+	owner      TEXT NOT NULL,
+	name       TEXT NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL,
+	revoked_at BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelNotAllowedError is returned when a request names a model that is not
+// in ALLOWED_MODELS.
+type modelNotAllowedError struct {
+	Model   string
+	Allowed []string
+}
+
+func (e *modelNotAllowedError) Error() string {
+	return fmt.Sprintf("model %q is not offered by this gateway", e.Model)
+}
+
+// ModelInfo describes one selectable model and its price.
+type ModelInfo struct {
+	ID       string `json:"id" example:"openai/gpt-4o-mini"`
+	Price    string `json:"price" doc:"Payment token amount per request, or per 1,000 input tokens when per-token pricing is enabled; with size_bands pricing, the smallest band's price for the model" example:"0.002"`
+	PriceUSD string `json:"price_usd,omitempty" doc:"The configured USD price, when prices are set in USD and converted at the token's current price" example:"0.002"`
+	Default  bool   `json:"default,omitempty" doc:"Used when a request does not set model"`
+}
+
+// ModelsResponse is the body of GET /v1/models.
+type ModelsResponse struct {
+	Models    []ModelInfo `json:"models"`
+	Pricing   string      `json:"pricing" doc:"flat, size_bands or per_1k_tokens" example:"flat"`
+	SizeBands []SizeBand  `json:"size_bands,omitempty" doc:"Prices by the text's size with PRICE_SIZE_BANDS: the price of models without their own, or the minimum charge with per-token pricing"`
+}
+
+func modelNotAllowedProblem(e *modelNotAllowedError) *Problem {
+	return newProblem(422, codeModelNotAllowed, "Model Not Allowed", e.Error()).
+		With("model", e.Model).
+		With("allowed_models", e.Allowed)
+}
+
+// parseModelPrices parses ALLOWED_MODELS: "model=price,model=price". Model
+// IDs may contain ':' (e.g. "z-ai/glm-4.5-air:free"), so the price follows
+// the last '='. A model listed without a price uses the default price.
+func parseModelPrices(raw string) (map[string]string, error) {
+	prices := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, price := entry, ""
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			model, price = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+			r, ok := parseDecimal(price)
+			if !ok || r.Sign() <= 0 {
+				return nil, fmt.Errorf("%q is not a positive decimal price for model %q", price, model)
+			}
+		}
+		if model == "" {
+			return nil, fmt.Errorf("entry %q has no model", entry)
+		}
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+// getModelPrices returns the ALLOWED_MODELS allowlist with per-model prices
+// ("" for the default price). An empty map means any model is accepted.
+func getModelPrices() map[string]string {
+	if appConfig != nil {
+		return appConfig.ModelPrices
+	}
+	prices, err := parseModelPrices(os.Getenv("ALLOWED_MODELS"))
+	if err != nil {
+		log.Printf("Warning: Invalid ALLOWED_MODELS (%v), accepting any model", err)
+		return nil
+	}
+	return prices
+}
+
+// checkModelAllowed returns the model a request will run on (the requested
+// one, or the default) and whether it is offered. The default model is
+// always allowed.
+func checkModelAllowed(requested string) (string, error) {
+	model := requested
+	if model == "" {
+		model = getDefaultModel()
+	}
+	prices := getModelPrices()
+	if len(prices) == 0 || model == getDefaultModel() {
+		return model, nil
+	}
+	if _, ok := prices[model]; ok {
+		return model, nil
+	}
+	return "", &modelNotAllowedError{Model: model, Allowed: allowedModelIDs(prices)}
+}
+
+// allowedModelIDs returns the default model plus the allowlist, sorted.
+func allowedModelIDs(prices map[string]string) []string {
+	ids := []string{getDefaultModel()}
+	for id := range prices {
+		if id != getDefaultModel() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// modelBasePrice returns the price configured for model in ALLOWED_MODELS,
+// or "" to use the gateway-wide price.
+func modelBasePrice(model string) string {
+	return getModelPrices()[model]
+}
+
+// handleListModels handles GET /v1/models, listing the selectable models and
+// their prices so clients can choose before requesting a quote.
+func handleListModels(c *gin.Context) {
+	defaultPrice := paymentAmountFor(c.Request.Context())
+	band, banded := sizeBandFor(c.Request.Context(), 0)
+	if banded {
+		defaultPrice = band.Price
+	}
+	perK := pricePer1KTokensFor(c.Request.Context())
+	if perK != "" {
+		defaultPrice = perK
+	}
+
+	var models []ModelInfo
+	for _, id := range allowedModelIDs(getModelPrices()) {
+		price := modelBasePrice(id)
+		switch {
+		case price == "":
+			price = defaultPrice
+		case banded && perK == "":
+			price = modelBandPrice(c.Request.Context(), band, price)
+		}
+		info := ModelInfo{ID: id, Price: usdToToken(price), Default: id == getDefaultModel()}
+		if priceOracle != nil {
+			info.PriceUSD = price
+		}
+		models = append(models, info)
+	}
+	c.JSON(200, ModelsResponse{Models: models, Pricing: pricingScheme(c.Request.Context()), SizeBands: advertisedSizeBands(c.Request.Context())})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/testsupport"
+)
+
+func TestParseModelPrices(t *testing.T) {
+	prices, err := parseModelPrices(" z-ai/glm-4.5-air:free=0.001, openai/gpt-4o=0.02 ,plain/model")
+	if err != nil {
+		t.Fatalf("parseModelPrices failed: %v", err)
+	}
+	want := map[string]string{"z-ai/glm-4.5-air:free": "0.001", "openai/gpt-4o": "0.02", "plain/model": ""}
+	if len(prices) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, prices)
+	}
+	for model, price := range want {
+		if prices[model] != price {
+			t.Errorf("Expected %s=%q, got %q", model, price, prices[model])
+		}
+	}
+
+	for _, raw := range []string{"model=abc", "model=-1", "model=0", "=0.1", "model=1e-3"} {
+		if _, err := parseModelPrices(raw); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestCheckModelAllowed(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "default/model")
+
+	t.Setenv("ALLOWED_MODELS", "")
+	if model, err := checkModelAllowed("any/model"); err != nil || model != "any/model" {
+		t.Errorf("Expected any model without an allowlist, got %q, %v", model, err)
+	}
+
+	t.Setenv("ALLOWED_MODELS", "premium/model=0.01")
+	if model, err := checkModelAllowed(""); err != nil || model != "default/model" {
+		t.Errorf("Expected the default model to be allowed, got %q, %v", model, err)
+	}
+	if _, err := checkModelAllowed("premium/model"); err != nil {
+		t.Errorf("Expected listed model to be allowed, got %v", err)
+	}
+	_, err := checkModelAllowed("other/model")
+	var notAllowed *modelNotAllowedError
+	if !errors.As(err, &notAllowed) || strings.Join(notAllowed.Allowed, ",") != "default/model,premium/model" {
+		t.Errorf("Expected modelNotAllowedError listing both models, got %v", err)
+	}
+}
+
+func TestPriceFor_ModelPrices(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("ALLOWED_MODELS", "premium/model=0.01,cheap/model")
+
+	t.Setenv("PRICE_PER_1K_TOKENS", "")
+	if got := priceFor(context.Background(), "premium/model", 0, 5000, summaryOptions{}); got != "0.01" {
+		t.Errorf("Expected the model's flat price, got %s", got)
+	}
+	if got := priceFor(context.Background(), "cheap/model", 0, 5000, summaryOptions{}); got != "0.001" {
+		t.Errorf("Expected PAYMENT_AMOUNT for a model without a price, got %s", got)
+	}
+
+	t.Setenv("PRICE_PER_1K_TOKENS", "0.001")
+	if got := priceFor(context.Background(), "premium/model", 0, 5000, summaryOptions{}); got != "0.05" {
+		t.Errorf("Expected the model's per-1K price, got %s", got)
+	}
+	if got := priceFor(context.Background(), "cheap/model", 0, 5000, summaryOptions{}); got != "0.005" {
+		t.Errorf("Expected PRICE_PER_1K_TOKENS for a model without a price, got %s", got)
+	}
+}
+
+func TestHandleSummarize_ModelNotAllowed(t *testing.T) {
+	verifier := testsupport.NewFakeVerifier(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("ALLOWED_MODELS", "premium/model=0.01")
+	r := setupVersionedRouter()
+
+	for _, paid := range []bool{false, true} {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"hello","model":"other/model"}`))
+		if paid {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", "nonce-model")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != 422 {
+			t.Fatalf("paid=%v: expected 422, got %d: %s", paid, w.Code, w.Body.String())
+		}
+		if p := decodeProblem(t, w); p["code"] != codeModelNotAllowed || p["model"] != "other/model" {
+			t.Errorf("paid=%v: expected model_not_allowed for other/model, got %v", paid, p)
+		}
+	}
+	if verifier.Calls() != 0 {
+		t.Error("Expected a disallowed model never to reach the verifier")
+	}
+}
+
+func TestHandleSummarize_QuotesModelPrice(t *testing.T) {
+	t.Setenv("ALLOWED_MODELS", "premium/model=0.25")
+	t.Setenv("PRICE_PER_1K_TOKENS", "")
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"hello","model":"premium/model"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	p := decodeProblem(t, w)
+	payment, _ := p["paymentContext"].(map[string]interface{})
+	if p["model"] != "premium/model" || payment["amount"] != "0.25" {
+		t.Errorf("Expected a 0.25 quote for premium/model, got %v", p)
+	}
+}
+
+func TestHandleListModels(t *testing.T) {
+	t.Setenv("OPENROUTER_MODEL", "default/model")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_PER_1K_TOKENS", "")
+	t.Setenv("ALLOWED_MODELS", "premium/model=0.01")
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("GET", "/v1/models", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp ModelsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Pricing != "flat" || len(resp.Models) != 2 {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+	if m := resp.Models[0]; m.ID != "default/model" || m.Price != "0.001" || !m.Default {
+		t.Errorf("Unexpected default model entry %+v", m)
+	}
+	if m := resp.Models[1]; m.ID != "premium/model" || m.Price != "0.01" || m.Default {
+		t.Errorf("Unexpected premium model entry %+v", m)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// outputModerator screens AI output before it is served or cached. It is
+// nil unless MODERATION_KEYWORDS, MODERATION_RULES_FILE or
+// MODERATION_PROVIDER is set.
+var outputModerator *moderator
+
+var moderationChecksTotal = newCounter(
+	"gateway_moderation_checks_total",
+	"AI outputs screened by moderation, by outcome (pass, flagged, error).",
+	"outcome",
+)
+
+// moderationFlaggedError is returned for an output the moderator withheld.
+type moderationFlaggedError struct {
+	Source     string // "rules" or the moderation provider
+	Categories []string
+}
+
+func (e *moderationFlaggedError) Error() string {
+	return fmt.Sprintf("output flagged by %s moderation (%s)", e.Source, strings.Join(e.Categories, ", "))
+}
+
+// moderationUnavailableError is returned when the moderation provider could
+// not be reached and MODERATION_FAIL_OPEN is not set.
+type moderationUnavailableError struct {
+	Err error
+}
+
+func (e *moderationUnavailableError) Error() string {
+	return "moderation unavailable: " + e.Err.Error()
+}
+
+func (e *moderationUnavailableError) Unwrap() error { return e.Err }
+
+// moderator applies keyword and regex rules locally, then asks the
+// moderation provider, if one is configured.
+type moderator struct {
+	rules    []*regexp.Regexp
+	provider string
+	model    string
+	failOpen bool
+}
+
+// initModerator builds the moderator from the environment, or returns nil
+// when moderation is not configured. LoadConfig has already validated the
+// settings, so an error here is only logged.
+func initModerator() *moderator {
+	m, err := newModeratorFromEnv()
+	if err != nil {
+		log.Printf("Warning: Invalid moderation settings (%v), moderation disabled", err)
+		return nil
+	}
+	if m != nil {
+		log.Printf("Output moderation enabled (%d rules, provider %q)", len(m.rules), m.provider)
+	}
+	return m
+}
+
+// newModeratorFromEnv reads MODERATION_KEYWORDS (comma-separated,
+// case-insensitive whole words), MODERATION_RULES_FILE (one regular
+// expression per line, '#' starts a comment), MODERATION_PROVIDER ("openai")
+// with MODERATION_MODEL (default omni-moderation-latest), and
+// MODERATION_FAIL_OPEN.
+func newModeratorFromEnv() (*moderator, error) {
+	m := &moderator{
+		provider: strings.ToLower(strings.TrimSpace(os.Getenv("MODERATION_PROVIDER"))),
+		model:    os.Getenv("MODERATION_MODEL"),
+		failOpen: strings.ToLower(os.Getenv("MODERATION_FAIL_OPEN")) == "true",
+	}
+	if m.provider != "" && m.provider != "openai" {
+		return nil, fmt.Errorf("unknown MODERATION_PROVIDER %q (known: openai)", m.provider)
+	}
+	if m.model == "" {
+		m.model = "omni-moderation-latest"
+	}
+
+	for _, kw := range strings.Split(os.Getenv("MODERATION_KEYWORDS"), ",") {
+		if kw = strings.TrimSpace(kw); kw != "" {
+			m.rules = append(m.rules, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(kw)+`\b`))
+		}
+	}
+	if path := os.Getenv("MODERATION_RULES_FILE"); path != "" {
+		rules, err := loadModerationRules(path)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, rules...)
+	}
+
+	if len(m.rules) == 0 && m.provider == "" {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// loadModerationRules compiles the regular expressions in path.
+func loadModerationRules(path string) ([]*regexp.Regexp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("MODERATION_RULES_FILE: %w", err)
+	}
+	defer f.Close()
+
+	var rules []*regexp.Regexp
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("MODERATION_RULES_FILE line %d: %w", n, err)
+		}
+		rules = append(rules, re)
+	}
+	return rules, scanner.Err()
+}
+
+// Check returns a *moderationFlaggedError if output must not be served, a
+// *moderationUnavailableError if it could not be checked, or nil.
+func (m *moderator) Check(ctx context.Context, output string) error {
+	for _, re := range m.rules {
+		if re.MatchString(output) {
+			// The rule itself stays in the log; clients only learn that one matched
+			log.Printf("Moderation rule %q matched AI output", re.String())
+			moderationChecksTotal.Inc("flagged")
+			return &moderationFlaggedError{Source: "rules", Categories: []string{"custom_rule"}}
+		}
+	}
+	if m.provider == "openai" {
+		categories, err := m.checkOpenAI(ctx, output)
+		if err != nil {
+			moderationChecksTotal.Inc("error")
+			if m.failOpen {
+				log.Printf("Moderation failed (%v), serving output unchecked", err)
+				return nil
+			}
+			return &moderationUnavailableError{Err: err}
+		}
+		if len(categories) > 0 {
+			moderationChecksTotal.Inc("flagged")
+			return &moderationFlaggedError{Source: m.provider, Categories: categories}
+		}
+	}
+	moderationChecksTotal.Inc("pass")
+	return nil
+}
+
+// checkOpenAI calls the OpenAI moderations endpoint and returns the flagged
+// categories, sorted, or none if the output passed.
+func (m *moderator) checkOpenAI(ctx context.Context, output string) ([]string, error) {
+	reqBody, _ := json.Marshal(map[string]string{"model": m.model, "input": output})
+	req, err := http.NewRequestWithContext(ctx, "POST", getOpenAIBaseURL()+"/moderations", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, newProviderStatusError("openai moderation", resp)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+	var categories []string
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		for name, hit := range r.Categories {
+			if hit {
+				categories = append(categories, name)
+			}
+		}
+		if len(categories) == 0 {
+			categories = append(categories, "flagged")
+		}
+	}
+	sort.Strings(categories)
+	return categories, nil
+}
+
+// moderationProblem converts a moderation error into the response problem.
+// Either way the client paid for output it did not receive, so the problem
+// is marked refund-eligible and carries the payment nonce to claim it with.
+func moderationProblem(err error, nonce string) *Problem {
+	var flagged *moderationFlaggedError
+	if errors.As(err, &flagged) {
+		return newProblem(502, codeOutputFlagged, "Output Withheld",
+			"The AI output was withheld by content moderation").
+			With("categories", flagged.Categories).
+			With("refund_eligible", true).
+			With("nonce", nonce)
+	}
+	return newProblem(503, codeModerationUnavailable, "Moderation Unavailable",
+		"The AI output could not be screened and was withheld").
+		With("refund_eligible", true).
+		With("nonce", nonce)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func TestModerator_Rules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.txt")
+	os.WriteFile(rulesFile, []byte("# card numbers\n\\b\\d{4}-\\d{4}-\\d{4}-\\d{4}\\b\n\n"), 0o600)
+	t.Setenv("MODERATION_KEYWORDS", "forbidden, secret plan")
+	t.Setenv("MODERATION_RULES_FILE", rulesFile)
+	t.Setenv("MODERATION_PROVIDER", "")
+
+	m, err := newModeratorFromEnv()
+	if err != nil || m == nil {
+		t.Fatalf("newModeratorFromEnv failed: %v", err)
+	}
+	tests := []struct {
+		output  string
+		flagged bool
+	}{
+		{"A harmless summary.", false},
+		{"This is FORBIDDEN content.", true},
+		{"The Secret Plan was revealed.", true},
+		{"Unforbiddenness is not a word.", false},
+		{"Card 4111-1111-1111-1111 leaked.", true},
+	}
+	for _, tt := range tests {
+		err := m.Check(context.Background(), tt.output)
+		var flagged *moderationFlaggedError
+		if errors.As(err, &flagged) != tt.flagged {
+			t.Errorf("Check(%q) = %v, want flagged %v", tt.output, err, tt.flagged)
+		}
+	}
+}
+
+func TestNewModeratorFromEnv_Disabled(t *testing.T) {
+	t.Setenv("MODERATION_KEYWORDS", "")
+	t.Setenv("MODERATION_RULES_FILE", "")
+	t.Setenv("MODERATION_PROVIDER", "")
+	if m, err := newModeratorFromEnv(); m != nil || err != nil {
+		t.Errorf("Expected no moderator, got %v, %v", m, err)
+	}
+
+	t.Setenv("MODERATION_RULES_FILE", filepath.Join(t.TempDir(), "rules.txt"))
+	os.WriteFile(os.Getenv("MODERATION_RULES_FILE"), []byte("(unclosed\n"), 0o600)
+	if _, err := newModeratorFromEnv(); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected an invalid rule to be reported with its line, got %v", err)
+	}
+}
+
+func TestModerator_OpenAI(t *testing.T) {
+	reply := `{"results":[{"flagged":true,"categories":{"violence":true,"hate":false,"harassment":true}}]}`
+	status := 200
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/moderations" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	m := &moderator{provider: "openai", model: "omni-moderation-latest"}
+
+	var flagged *moderationFlaggedError
+	if err := m.Check(context.Background(), "output"); !errors.As(err, &flagged) || strings.Join(flagged.Categories, ",") != "harassment,violence" {
+		t.Errorf("Expected harassment and violence, got %v", err)
+	}
+
+	reply = `{"results":[{"flagged":false,"categories":{"violence":false}}]}`
+	if err := m.Check(context.Background(), "output"); err != nil {
+		t.Errorf("Expected a pass, got %v", err)
+	}
+
+	status, reply = 500, `{"error":{}}`
+	var unavailable *moderationUnavailableError
+	if err := m.Check(context.Background(), "output"); !errors.As(err, &unavailable) {
+		t.Errorf("Expected moderation to fail closed, got %v", err)
+	}
+	m.failOpen = true
+	if err := m.Check(context.Background(), "output"); err != nil {
+		t.Errorf("Expected MODERATION_FAIL_OPEN to serve the output, got %v", err)
+	}
+}
+
+func TestHandleSummarize_WithholdsFlaggedOutput(t *testing.T) {
+	setupTestReputation(t)
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Reply("Here is some forbidden output.")
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("MODERATION_KEYWORDS", "forbidden")
+	outputModerator = initModerator()
+	defer func() { outputModerator = nil }()
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"text that produces flagged output"}`))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "nonce-moderated")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 502 {
+		t.Fatalf("Expected 502, got %d: %s", w.Code, w.Body.String())
+	}
+	p := decodeProblem(t, w)
+	if p["code"] != codeOutputFlagged || p["refund_eligible"] != true || p["nonce"] != "nonce-moderated" {
+		t.Errorf("Unexpected problem %v", p)
+	}
+	if strings.Contains(w.Body.String(), "forbidden output") {
+		t.Error("Expected the flagged output not to be served")
+	}
+	if memoryCache.Len() != 0 {
+		t.Error("Expected the flagged output not to be cached")
+	}
+}
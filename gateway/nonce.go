@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Payment nonces are issued by the gateway instead of being chosen by the
+// client. A nonce reads n1.<expiry>.<random>.<mac>: the Unix time it
+// expires, 16 random bytes and an HMAC-SHA256 of both together with the
+// route it pays for, all base64url. The gateway can check a nonce without
+// storing it, on any instance sharing NONCE_SECRET.
+const nonceVersion = "n1"
+
+// loadNonceVersion nonces are issued while load pricing raises prices:
+// n2.<expiry>.<load>.<random>.<mac>, where load is the price multiplier in
+// percent the nonce was quoted at, covered by the MAC so the paid request
+// is priced the same.
+const loadNonceVersion = "n2"
+
+// summarizeRoute is the paid route's path below the /v1 and /api prefixes,
+// which nonces are bound to.
+const summarizeRoute = "/ai/summarize"
+
+// paidRoutes are the routes GET /v1/payment/challenge issues nonces for.
+var paidRoutes = []string{summarizeRoute}
+
+// nonceSpentPrefix marks the issued nonces verified payments used until
+// they expire, so each signature pays for one request.
+const nonceSpentPrefix = "nonce:spent:"
+
+var nonceSpent = newSpentSet(nonceSpentPrefix)
+
+var noncesRejectedTotal = newCounter(
+	"gateway_nonces_rejected_total",
+	"Paid requests refused because the gateway didn't issue their nonce for the route, by reason (malformed, invalid, expired, spent).",
+	"reason",
+)
+
+// PaymentChallenge is the response of GET /v1/payment/challenge.
+type PaymentChallenge struct {
+	PaymentContext PaymentContext `json:"paymentContext"`
+	ExpiresAt      time.Time      `json:"expires_at" doc:"When the nonce stops being accepted; sign and send the request before then"`
+	RecipientName  string         `json:"recipient_name,omitempty" doc:"ENS name paymentContext.recipient was resolved from, when RECIPIENT_ADDRESS is one" example:"paygate.eth"`
+	LoadMultiplier string         `json:"load_multiplier,omitempty" doc:"Factor the price was raised by for the gateway's current load, with LOAD_PRICING; it holds until expires_at" example:"1.5"`
+}
+
+// getNonceTTL returns NONCE_TTL_SECONDS, how long an issued nonce stays
+// valid (default 300).
+func getNonceTTL() time.Duration {
+	return time.Duration(getEnvAsInt("NONCE_TTL_SECONDS", 300)) * time.Second
+}
+
+// requireIssuedNonces reports whether NONCE_REQUIRE_ISSUED (default true)
+// refuses nonces the gateway didn't issue. Nonces are issued either way, so
+// it can be turned off while clients that make up their own are upgraded.
+func requireIssuedNonces() bool {
+	return strings.ToLower(os.Getenv("NONCE_REQUIRE_ISSUED")) != "false"
+}
+
+// generatedNonceKey signs nonces when NONCE_SECRET is unset. It only lives
+// as long as the process, so nonces don't survive a restart and aren't
+// accepted by other instances.
+var generatedNonceKey = sync.OnceValue(func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+})
+
+func nonceKey() []byte {
+	if secret := os.Getenv("NONCE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return generatedNonceKey()
+}
+
+// nonceMAC signs the version, route and the nonce's other fields.
+func nonceMAC(version, route string, fields ...string) string {
+	mac := hmac.New(sha256.New, nonceKey())
+	mac.Write([]byte(strings.Join(append([]string{version, route}, fields...), "|")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueNonce returns a new nonce for route and when it expires. A load
+// above 100 percent issues an n2 nonce carrying it, valid for the shorter
+// of NONCE_TTL_SECONDS and LOAD_PRICING_QUOTE_SECONDS.
+func issueNonce(route string, load int) (string, time.Time) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	random := base64.RawURLEncoding.EncodeToString(b)
+	ttl := getNonceTTL()
+	if load > 100 {
+		ttl = min(ttl, getLoadQuoteTTL())
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	if load > 100 {
+		pct := strconv.Itoa(load)
+		return strings.Join([]string{loadNonceVersion, expiry, pct, random, nonceMAC(loadNonceVersion, route, expiry, pct, random)}, "."), expiresAt
+	}
+	return strings.Join([]string{nonceVersion, expiry, random, nonceMAC(nonceVersion, route, expiry, random)}, "."), expiresAt
+}
+
+// checkNonce returns why nonce can't pay for route at now, or "" when the
+// gateway issued it for route and it hasn't expired.
+func checkNonce(nonce, route string, now time.Time) string {
+	parts := strings.Split(nonce, ".")
+	switch {
+	case len(parts) == 4 && parts[0] == nonceVersion:
+	case len(parts) == 5 && parts[0] == loadNonceVersion:
+		if _, err := strconv.Atoi(parts[2]); err != nil {
+			return "malformed"
+		}
+	default:
+		return "malformed"
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "malformed"
+	}
+	last := len(parts) - 1
+	if !hmac.Equal([]byte(parts[last]), []byte(nonceMAC(parts[0], route, parts[1:last]...))) {
+		return "invalid"
+	}
+	if now.Unix() >= expiry {
+		return "expired"
+	}
+	return ""
+}
+
+// nonceExpiry returns when an issued nonce expires, or false for a nonce
+// the gateway doesn't issue. It doesn't check the MAC.
+func nonceExpiry(nonce string) (time.Time, bool) {
+	parts := strings.Split(nonce, ".")
+	if len(parts) < 4 || (parts[0] != nonceVersion && parts[0] != loadNonceVersion) {
+		return time.Time{}, false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(expiry, 0), true
+}
+
+// spendNonce marks an issued nonce as used once its payment is verified.
+// It answers 403 invalid_nonce with reason "spent" and returns false when
+// the nonce already paid for a request. Nonces the gateway doesn't issue,
+// allowed while NONCE_REQUIRE_ISSUED is false, aren't tracked.
+func spendNonce(c *gin.Context, nonce string) bool {
+	expiresAt, ok := nonceExpiry(nonce)
+	if !ok {
+		return true
+	}
+	if !nonceSpent.spend(c.Request.Context(), nonce, expiresAt) {
+		abortInvalidNonce(c, "spent")
+		return false
+	}
+	return true
+}
+
+// nonceLoad returns the load multiplier in percent an n2 nonce was quoted
+// at, or 100 for any other nonce. Only trust it once checkNonce accepted
+// the nonce.
+func nonceLoad(nonce string) int {
+	parts := strings.Split(nonce, ".")
+	if len(parts) != 5 || parts[0] != loadNonceVersion {
+		return 100
+	}
+	load, err := strconv.Atoi(parts[2])
+	if err != nil || load < 100 {
+		return 100
+	}
+	return load
+}
+
+// paymentRoute returns the route c pays for: its path without the /v1 or
+// /api prefix, and the tenant prefix.
+func paymentRoute(c *gin.Context) string {
+	path := strings.TrimPrefix(c.FullPath(), tenantPathPrefix)
+	for _, prefix := range []string{apiV1Prefix, legacyAPIPrefix} {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			return rest
+		}
+	}
+	return path
+}
+
+// abortInvalidNonce answers 403 invalid_nonce. The client gets a fresh
+// nonce from the 402 challenge or GET /v1/payment/challenge.
+func abortInvalidNonce(c *gin.Context, reason string) {
+	noncesRejectedTotal.Inc(reason)
+	abortWithProblem(c, newProblem(403, codeInvalidNonce, "Forbidden",
+		"The nonce was not issued by this gateway for this route, has expired or was already spent; request a new payment challenge").
+		With("reason", reason))
+}
+
+// handlePaymentChallenge handles GET /v1/payment/challenge: it returns a
+// payment context with a freshly issued nonce for route (default
+// /ai/summarize), priced at the default amount. Per-token prices need the
+// text, so send it with an unpaid POST to get a priced challenge instead.
+func handlePaymentChallenge(c *gin.Context) {
+	route := c.DefaultQuery("route", summarizeRoute)
+	if !slices.Contains(paidRoutes, route) {
+		abortWithProblem(c, newProblem(400, codeInvalidQuery, "Bad Request", "route is not a paid route").
+			With("routes", paidRoutes))
+		return
+	}
+	paymentContext, expiresAt := createPaymentContext(c.Request.Context(), route)
+	c.JSON(200, PaymentChallenge{PaymentContext: paymentContext, ExpiresAt: expiresAt.UTC(), RecipientName: recipientNameFor(c.Request.Context()),
+		LoadMultiplier: formatLoad(nonceLoad(paymentContext.Nonce))})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func TestCheckNonce(t *testing.T) {
+	t.Setenv("NONCE_SECRET", strings.Repeat("s", 32))
+	t.Setenv("NONCE_TTL_SECONDS", "60")
+	nonce, expiresAt := issueNonce(summarizeRoute, 100)
+	now := time.Now()
+
+	if reason := checkNonce(nonce, summarizeRoute, now); reason != "" {
+		t.Fatalf("Expected an issued nonce to be accepted, got %s", reason)
+	}
+	if reason := checkNonce(nonce, "/other", now); reason != "invalid" {
+		t.Errorf("Expected a nonce for another route to be invalid, got %q", reason)
+	}
+	if reason := checkNonce(nonce, summarizeRoute, expiresAt); reason != "expired" {
+		t.Errorf("Expected the nonce to expire after NONCE_TTL_SECONDS, got %q", reason)
+	}
+
+	parts := strings.Split(nonce, ".")
+	parts[1] = "9999999999" // extend the expiry
+	if reason := checkNonce(strings.Join(parts, "."), summarizeRoute, now); reason != "invalid" {
+		t.Errorf("Expected a tampered nonce to be invalid, got %q", reason)
+	}
+	for _, bad := range []string{"", "550e8400-e29b-41d4-a716-446655440000", "n1.soon.x.y", "n2.1.x.y"} {
+		if reason := checkNonce(bad, summarizeRoute, now); reason != "malformed" {
+			t.Errorf("Expected %q to be malformed, got %q", bad, reason)
+		}
+	}
+
+	// Another gateway's nonces don't verify
+	t.Setenv("NONCE_SECRET", strings.Repeat("o", 32))
+	if reason := checkNonce(nonce, summarizeRoute, now); reason != "invalid" {
+		t.Errorf("Expected a nonce signed with another secret to be invalid, got %q", reason)
+	}
+}
+
+func TestHandlePaymentChallenge(t *testing.T) {
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("GET", "/v1/payment/challenge", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var challenge PaymentChallenge
+	if err := json.Unmarshal(w.Body.Bytes(), &challenge); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected a challenge, got %d: %s", w.Code, w.Body.String())
+	}
+	if reason := checkNonce(challenge.PaymentContext.Nonce, summarizeRoute, time.Now()); reason != "" {
+		t.Errorf("Expected a nonce issued for %s, got %s", summarizeRoute, reason)
+	}
+	if challenge.PaymentContext.Amount != getPaymentAmount() || challenge.ExpiresAt.Before(time.Now()) {
+		t.Errorf("Unexpected challenge %+v", challenge)
+	}
+
+	req, _ = http.NewRequest("GET", "/v1/payment/challenge?route=/receipts", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if p := decodeProblem(t, w); w.Code != http.StatusBadRequest || p["code"] != codeInvalidQuery {
+		t.Errorf("Expected 400 invalid_query for an unpaid route, got %d %v", w.Code, p)
+	}
+}
+
+func TestHandleSummarize_RequiresIssuedNonce(t *testing.T) {
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("NONCE_REQUIRE_ISSUED", "true")
+	r := setupVersionedRouter()
+
+	send := func(path, nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(`{"text":"pay with an issued nonce"}`))
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	rejected := noncesRejectedTotal.Value("malformed")
+	w := send("/v1/ai/summarize", "client-chosen-nonce")
+	if p := decodeProblem(t, w); w.Code != http.StatusForbidden || p["code"] != codeInvalidNonce || p["reason"] != "malformed" {
+		t.Fatalf("Expected 403 invalid_nonce, got %d %v", w.Code, p)
+	}
+	if verifier.Calls() != 0 || noncesRejectedTotal.Value("malformed")-rejected != 1 {
+		t.Error("Expected the nonce to be refused before the verifier, and counted")
+	}
+
+	// The 402 challenge hands out a nonce that works on both prefixes
+	p := decodeProblem(t, send("/v1/ai/summarize", ""))
+	payment, _ := p["paymentContext"].(map[string]interface{})
+	nonce, _ := payment["nonce"].(string)
+	if p["expires_at"] == nil {
+		t.Error("Expected the challenge to say when the nonce expires")
+	}
+	if w := send("/v1/ai/summarize", nonce); w.Code != http.StatusOK {
+		t.Errorf("Expected the issued nonce to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Each nonce pays for one request, on either prefix
+	spent := noncesRejectedTotal.Value("spent")
+	calls := verifier.Calls()
+	w = send("/api/ai/summarize", nonce)
+	if p := decodeProblem(t, w); w.Code != http.StatusForbidden || p["code"] != codeInvalidNonce || p["reason"] != "spent" {
+		t.Errorf("Expected a replayed nonce to get 403 spent, got %d %v", w.Code, p)
+	}
+	if noncesRejectedTotal.Value("spent")-spent != 1 || ai.Calls() != 1 || verifier.Calls() != calls+1 {
+		t.Errorf("Expected the replay counted and not summarized, got %d AI calls", ai.Calls())
+	}
+
+	p = decodeProblem(t, send("/api/ai/summarize", ""))
+	payment, _ = p["paymentContext"].(map[string]interface{})
+	nonce, _ = payment["nonce"].(string)
+	if w := send("/api/ai/summarize", nonce); w.Code != http.StatusOK {
+		t.Errorf("Expected an issued nonce on the legacy alias too, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ollamaProvider runs prompts on a self-hosted Ollama server, so a
+// deployment with AI_PROVIDER_CHAIN=ollama needs no external AI service.
+// It runs OLLAMA_MODEL unless MODEL_ROUTES sends it a model.
+type ollamaProvider struct{}
+
+// getOllamaURL returns OLLAMA_URL (default http://localhost:11434).
+func getOllamaURL() string {
+	if v := os.Getenv("OLLAMA_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return "http://localhost:11434"
+}
+
+// getOllamaModel returns OLLAMA_MODEL (default llama3.2).
+func getOllamaModel() string {
+	if v := os.Getenv("OLLAMA_MODEL"); v != "" {
+		return v
+	}
+	return "llama3.2"
+}
+
+// parseKeepAlive validates OLLAMA_KEEP_ALIVE, which Ollama accepts as a Go
+// duration ("10m") or a number of seconds (-1 keeps the model loaded).
+func parseKeepAlive(raw string) (interface{}, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(raw); err == nil {
+		return n, nil
+	}
+	if _, err := time.ParseDuration(raw); err != nil {
+		return nil, fmt.Errorf("%q is neither a duration nor a number of seconds", raw)
+	}
+	return raw, nil
+}
+
+// ollamaChatRequest is the body of POST /api/chat.
+type ollamaChatRequest struct {
+	Model     string                 `json:"model"`
+	Messages  []map[string]string    `json:"messages"`
+	Stream    bool                   `json:"stream"`
+	KeepAlive interface{}            `json:"keep_alive,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+}
+
+func (ollamaProvider) Complete(ctx context.Context, model, prompt string) (string, error) {
+	if model == "" {
+		model = getOllamaModel()
+	}
+	body := ollamaChatRequest{
+		Model:    model,
+		Messages: []map[string]string{{"role": "user", "content": prompt}},
+	}
+	// How long Ollama keeps the model in memory after the call; the first
+	// request after an unload pays the load time.
+	body.KeepAlive, _ = parseKeepAlive(os.Getenv("OLLAMA_KEEP_ALIVE"))
+	body.Options = make(map[string]interface{})
+	if numCtx := getEnvAsInt("OLLAMA_NUM_CTX", 0); numCtx > 0 {
+		body.Options["num_ctx"] = numCtx
+	}
+	// Ollama names the output cap num_predict
+	gen := generationParamsFrom(ctx)
+	if gen.Temperature != nil {
+		body.Options["temperature"] = *gen.Temperature
+	}
+	if gen.TopP != nil {
+		body.Options["top_p"] = *gen.TopP
+	}
+	if gen.MaxTokens != nil {
+		body.Options["num_predict"] = *gen.MaxTokens
+	}
+
+	reqBody, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", getOllamaURL()+"/api/chat", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Ollama request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", context.DeadlineExceeded
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", newProviderStatusError("ollama", resp)
+	}
+
+	var result struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode Ollama response: %w", err)
+	}
+	if result.Message.Content == "" {
+		return "", fmt.Errorf("invalid response from Ollama: missing content")
+	}
+	return result.Message.Content, nil
+}
+
+// checkOllama confirms the Ollama server is up and OLLAMA_MODEL has been
+// pulled, since a missing model only fails at request time otherwise.
+func checkOllama(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", getOllamaURL()+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("failed to decode model list: %w", err)
+	}
+	want := getOllamaModel()
+	for _, m := range tags.Models {
+		// "llama3.2" is stored as "llama3.2:latest"
+		if m.Name == want || m.Name == want+":latest" {
+			return nil
+		}
+	}
+	return fmt.Errorf("model %q is not pulled (run: ollama pull %s)", want, want)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOllamaProvider_SendsModelAndOptions(t *testing.T) {
+	var got ollamaChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"message":{"role":"assistant","content":"local summary"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("OLLAMA_URL", srv.URL+"/")
+	t.Setenv("OLLAMA_MODEL", "qwen2.5:7b")
+	t.Setenv("OLLAMA_KEEP_ALIVE", "30m")
+	t.Setenv("OLLAMA_NUM_CTX", "8192")
+
+	reply, err := ollamaProvider{}.Complete(context.Background(), "", "prompt text")
+	if err != nil || reply != "local summary" {
+		t.Fatalf("Expected local summary, got %q, %v", reply, err)
+	}
+	if got.Model != "qwen2.5:7b" || got.Stream || got.KeepAlive != "30m" || got.Options["num_ctx"] != float64(8192) {
+		t.Errorf("Unexpected request %+v", got)
+	}
+	if len(got.Messages) != 1 || got.Messages[0]["content"] != "prompt text" {
+		t.Errorf("Expected the prompt as a single user message, got %v", got.Messages)
+	}
+}
+
+func TestParseKeepAlive(t *testing.T) {
+	for raw, want := range map[string]interface{}{"": nil, "-1": -1, "300": 300, "5m": "5m"} {
+		if got, err := parseKeepAlive(raw); err != nil || got != want {
+			t.Errorf("parseKeepAlive(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	if _, err := parseKeepAlive("forever"); err == nil {
+		t.Error("Expected an invalid keep-alive to be rejected")
+	}
+}
+
+func TestCheckOllama(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[{"name":"llama3.2:latest"},{"name":"qwen2.5:7b"}]}`))
+	}))
+	defer srv.Close()
+	t.Setenv("OLLAMA_URL", srv.URL)
+
+	t.Setenv("OLLAMA_MODEL", "llama3.2")
+	if err := checkOllama(context.Background()); err != nil {
+		t.Errorf("Expected llama3.2 to match llama3.2:latest, got %v", err)
+	}
+	t.Setenv("OLLAMA_MODEL", "mistral")
+	if err := checkOllama(context.Background()); err == nil || !strings.Contains(err.Error(), "ollama pull mistral") {
+		t.Errorf("Expected a not-pulled error, got %v", err)
+	}
+}
+
+func TestLoadConfig_OllamaOnlyNeedsNoOpenRouterKey(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "")
+	t.Setenv("AI_PROVIDER_CHAIN", "ollama")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("Expected an Ollama-only chain to load without OPENROUTER_API_KEY, got %v", err)
+	}
+
+	t.Setenv("AI_PROVIDER_CHAIN", "ollama,openrouter")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected OPENROUTER_API_KEY to be required when openrouter is in the chain")
+	}
+}
+
+func TestReadinessChecks_OllamaCriticalWhenPrimary(t *testing.T) {
+	for chain, wantCritical := range map[string]bool{"ollama,mock": true, "openrouter,ollama": false} {
+		t.Setenv("AI_PROVIDER_CHAIN", chain)
+		for _, dc := range readinessChecks() {
+			if dc.name == "ollama" && (dc.check == nil || dc.critical != wantCritical) {
+				t.Errorf("%s: expected an enabled ollama check with critical=%v", chain, wantCritical)
+			}
+		}
+	}
+}
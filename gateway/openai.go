@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// openAIProvider calls the OpenAI chat completions API directly, for
+// customers with their own OpenAI contract. Summaries are returned whole, so
+// it requests non-streamed completions.
+type openAIProvider struct{}
+
+// getOpenAIBaseURL returns OPENAI_BASE_URL (default https://api.openai.com/v1).
+func getOpenAIBaseURL() string {
+	if v := os.Getenv("OPENAI_BASE_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return "https://api.openai.com/v1"
+}
+
+// chatCompletionResponse is the subset of an OpenAI-style chat completion
+// the gateway reads.
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// content returns the first choice's message, or an error naming provider.
+// The token usage, when reported, is recorded in ctx.
+func (r *chatCompletionResponse) content(ctx context.Context, provider string) (string, error) {
+	if r.Usage != nil {
+		recordUsage(ctx, aiUsage{calls: 1, promptTokens: r.Usage.PromptTokens, completionTokens: r.Usage.CompletionTokens})
+	}
+	if len(r.Choices) == 0 || r.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("invalid response from %s: no content", provider)
+	}
+	return r.Choices[0].Message.Content, nil
+}
+
+// Complete runs prompt on model, or OPENAI_MODEL (default gpt-4o-mini) when
+// model is empty. It authenticates with OPENAI_API_KEY, or the tenant's own
+// key, and, when set, OPENAI_ORG_ID.
+func (openAIProvider) Complete(ctx context.Context, model, prompt string) (string, error) {
+	if model == "" {
+		model = os.Getenv("OPENAI_MODEL")
+	}
+	if model == "" {
+		model = "gpt-4o-mini"
+	}
+
+	body := map[string]interface{}{
+		"model":    model,
+		"messages": []map[string]string{{"role": "user", "content": prompt}},
+	}
+	generationParamsFrom(ctx).applyTo(body)
+	reqBody, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", getOpenAIBaseURL()+"/chat/completions", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create OpenAI request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+providerAPIKey(ctx, "openai"))
+	req.Header.Set("Content-Type", "application/json")
+	if org := os.Getenv("OPENAI_ORG_ID"); org != "" {
+		req.Header.Set("OpenAI-Organization", org)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", context.DeadlineExceeded
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", newProviderStatusError("openai", resp)
+	}
+
+	var result chatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode OpenAI response: %w", err)
+	}
+	return result.content(ctx, "OpenAI")
+}
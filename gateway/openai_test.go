@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIProvider_Complete(t *testing.T) {
+	var gotAuth, gotOrg string
+	var gotBody map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		gotAuth, gotOrg = r.Header.Get("Authorization"), r.Header.Get("OpenAI-Organization")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"openai summary"}}]}`))
+	}))
+	defer srv.Close()
+	t.Setenv("OPENAI_BASE_URL", srv.URL+"/v1")
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_ORG_ID", "org-test")
+	t.Setenv("OPENAI_MODEL", "")
+
+	reply, err := openAIProvider{}.Complete(context.Background(), "", "prompt")
+	if err != nil || reply != "openai summary" {
+		t.Fatalf("Expected openai summary, got %q, %v", reply, err)
+	}
+	if gotAuth != "Bearer sk-test" || gotOrg != "org-test" {
+		t.Errorf("Unexpected auth headers %q, %q", gotAuth, gotOrg)
+	}
+	if gotBody["model"] != "gpt-4o-mini" {
+		t.Errorf("Expected the default model, got %v", gotBody["model"])
+	}
+}
+
+func TestOpenAIProvider_StatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"rate limited"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+
+	_, err := openAIProvider{}.Complete(context.Background(), "gpt-4o", "prompt")
+	if !isFailoverError(context.Background(), err) {
+		t.Errorf("Expected a 429 to be a failover error, got %v", err)
+	}
+}
@@ -0,0 +1,964 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// The OpenAPI document is generated from the request/response Go types and
+// the operation table below rather than maintained by hand, so field changes
+// show up in /openapi.json automatically. TestOpenAPISpecCoversRoutes fails
+// when a route is added without documenting it here.
+
+// apiOperation documents one route.
+type apiOperation struct {
+	Method      string
+	Path        string // OpenAPI style, e.g. /v1/receipts/{id}
+	Tag         string
+	Summary     string
+	Description string
+	Admin       bool // requires the ADMIN_API_TOKEN bearer token
+	Parameters  []apiParameter
+	RequestBody interface{} // zero value of the JSON request body type
+	PlainText   bool        // the body can also be the text itself, as text/plain
+	Responses   []apiResponse
+}
+
+type apiParameter struct {
+	Name        string
+	In          string // "path", "query" or "header"
+	Description string
+	Required    bool
+}
+
+// apiResponse documents one status code. Problem responses use the Problem
+// schema, extended with Body's members when Body is set.
+type apiResponse struct {
+	Status      int
+	Description string
+	Body        interface{}
+	ContentType string // defaults to application/json
+	Problem     bool
+	Headers     []string // keys of openAPIHeaders
+}
+
+// openAPIHeaders are the response headers referenced by apiResponse.Headers.
+var openAPIHeaders = map[string]string{
+	"X-RateLimit-Limit":     "Requests allowed per minute for the caller's tier (when RATE_LIMIT_ENABLED=true)",
+	"X-RateLimit-Remaining": "Requests left in the current window",
+	"X-RateLimit-Reset":     "Unix time when the window resets",
+	"Retry-After":           "Seconds to wait before retrying",
+	"X-402-Receipt":         "Base64-encoded JSON of the signed payment receipt",
+	"X-Cache":               "HIT, STALE, MISS or BYPASS",
+	"X-Input-Tokens":        "Input tokens counted in the request text, which the price is based on",
+	"X-Next-Cursor":         "Cursor of the next page; absent on the last page",
+	"X-AI-Provider":         "AI provider that produced the summary; absent for cache hits",
+	"X-Input-Flagged":       "Set to prompt_injection when INJECTION_DETECTION=flag and the text matched an injection rule",
+	"X-Cache-Age":           "Age in seconds of a cached summary",
+	"X-Request-ID":          "Request ID, also reported in error bodies",
+	"Deprecation":           "Set on the deprecated /api aliases (RFC 9745)",
+	"Sunset":                "Removal date of the deprecated /api aliases (RFC 8594), once announced",
+	"Link":                  "rel=\"successor-version\" link to the /v1 route",
+	"Idempotent-Replayed":   "true when the response is the one stored for the request's Idempotency-Key",
+	"X-PAYMENT-RESPONSE":    "Base64-encoded JSON of the facilitator's settlement (success, transaction, network, payer), with SETTLEMENT_MODE=facilitator",
+	"X-Token-Price-USD":     "USD price of the payment token the amounts were converted at, with PRICE_CURRENCY=usd",
+}
+
+var rateLimitHeaders = []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-Request-ID"}
+
+var paymentHeaderParams = []apiParameter{
+	{Name: "X-402-Signature", In: "header", Description: "EIP-712 signature of the payment context"},
+	{Name: "X-402-Nonce", In: "header", Description: "Nonce from the payment context, as issued by the gateway"},
+	{Name: "X-402-Payment", In: "header", Description: "Base64 JSON of the payment context that was signed. When sent, it must match the required payment, except for an amount that may exceed the price"},
+	{Name: "X-Cache-Bypass", In: "header", Description: "Set to true to skip the cache lookup; the fresh result still refreshes the cache"},
+}
+
+// openAPIOperations lists every documented route. The /api aliases of the
+// /v1 routes are derived from these.
+var openAPIOperations = []apiOperation{
+	{
+		Method: "POST", Path: "/v1/ai/summarize", Tag: "AI",
+		Summary:     "Summarize text",
+		Description: "Summarizes text after verifying an x402 payment. The body is a JSON request, or the text itself with Content-Type: text/plain and model and output_language in the query string, which lets large documents be streamed (chunked) without escaping them; both are priced, cached and limited by the same text. Without payment headers the gateway answers 402 with the payment context to sign, priced for the text when PRICE_PER_1K_TOKENS or PRICE_SIZE_BANDS is set. A 5xx answer after the payment was verified refunds it automatically; the refund is recorded in the usage ledger. With SETTLEMENT_MODE=escrow the payment is instead held until the response is delivered and released on failure, so the same signature can be retried. With SETTLEMENT_MODE=facilitator an x402 facilitator checks the payment before any work is done and settles it before the response is sent. With CHANNEL_DEPOSITS_FILE, a client that deposited into a payment channel can pay with X-402-Channel headers instead: each request signs the channel's new cumulative balance, which the gateway checks itself, and the final balance is settled when the channel closes; a failed request gives its amount back to the channel. With PREPAID_DEPOSITS, a wallet that topped up its prepaid balance with a direct transfer can pay with X-402-Prepaid instead, signing each request's issued nonce and body hash; a failed request gives its amount back to the balance.",
+		Parameters: append(paymentHeaderParams,
+			apiParameter{Name: "Content-Encoding", In: "header", Description: "gzip or deflate to send the body compressed; limits apply to the decompressed size"},
+			apiParameter{Name: "model", In: "query", Description: "With a text/plain body: the model, as in the JSON body's model"},
+			apiParameter{Name: "output_language", In: "query", Description: "With a text/plain body: the output language, as in the JSON body's output_language"},
+			apiParameter{Name: "Idempotency-Key", In: "header", Description: "Up to 255 printable ASCII characters. Paid retries with the same key and body within IDEMPOTENCY_TTL_SECONDS get the first successful response back without being charged again, when they carry the same payment headers or an X-402-Payment with a fresh nonce signed by the wallet that paid"},
+			apiParameter{Name: "X-402-Channel", In: "header", Description: "Payment channel ID (bytes32) to pay from instead of X-402-Signature and X-402-Nonce"},
+			apiParameter{Name: "X-402-Channel-Amount", In: "header", Description: "Cumulative amount owed on the channel after this request: at least the channel's spent amount plus the price, at most its deposit"},
+			apiParameter{Name: "X-402-Channel-Signature", In: "header", Description: "personal_sign by the channel's sender of \"MicroAI-Paygate channel <id> balance <amount>\""},
+			apiParameter{Name: "X-402-Prepaid", In: "header", Description: "Wallet whose prepaid balance pays for the request, instead of X-402-Signature; send X-402-Nonce and X-Wallet-Signature with it"},
+			apiParameter{Name: "X-Wallet-Signature", In: "header", Description: "With X-402-Prepaid: personal_sign by the wallet of \"MicroAI-Paygate prepaid payment from <wallet> nonce <nonce> body <keccak256 of the request body>\"; each nonce pays once"},
+			apiParameter{Name: "X-PoW-Challenge", In: "header", Description: "Challenge from a 428 proof_of_work_required answer, sent with X-PoW-Solution; each solves one request"},
+			apiParameter{Name: "X-PoW-Solution", In: "header", Description: "String of up to 64 characters whose sha256(challenge + \":\" + solution) has the challenge's difficulty in leading zero bits"},
+			apiParameter{Name: "X-Promo-Code", In: "header", Description: "Admin-managed promo code (case-insensitive). The challenge and the required payment are discounted, or waived to 0; the code's use is counted once the payment is verified"}),
+		RequestBody: SummarizeRequest{},
+		PlainText:   true,
+		Responses: []apiResponse{
+			{Status: 200, Description: "Summary and signed receipt", Body: SummarizeResponse{}, Headers: append([]string{"X-402-Receipt", "X-Input-Tokens", "X-AI-Provider", "X-Input-Flagged", "X-Cache", "X-Cache-Age", "Idempotent-Replayed", "X-PAYMENT-RESPONSE", "X-Token-Price-USD", "X-Prepaid-Balance"}, rateLimitHeaders...)},
+			{Status: 400, Description: "Request body is not valid JSON, a text/plain body declares a charset other than UTF-8, or the body is not valid data for its Content-Encoding (invalid_request_body), the Idempotency-Key is malformed (invalid_idempotency_key), X-402-Payment can't be decoded (invalid_payment_header), or X-Promo-Code is unknown, expired or used up (invalid_promo_code)", Problem: true, Body: struct {
+				PromoCode string `json:"promo_code,omitempty" doc:"invalid_promo_code: the code, upper case" example:"LAUNCH50"`
+				Reason    string `json:"reason,omitempty" doc:"invalid_promo_code: unknown, expired or exhausted" example:"exhausted"`
+			}{}},
+			{Status: 402, Description: "Payment required (payment_required). Send the request body with the challenge request to get the price for that text. Also returned, with a fresh payment context, when X-402-Payment signs less than the price, or a verified wallet under REPUTATION_LOW_SCORE paid less than the surcharged price (insufficient_payment), or a different recipient, token, chain, nonce or body hash (payment_mismatch). With SETTLEMENT_MODE=facilitator, the facilitator refused to verify or settle the payment (facilitator_rejected), with its reason as detail; nothing was charged. For channel payments, X-402-Channel-Amount doesn't cover the spent amount plus the price (channel_underpaid) or the deposit doesn't (channel_exhausted). For prepaid payments, the balance doesn't cover the price (prepaid_insufficient)", Problem: true, Body: struct {
+				PaymentContext PaymentContext `json:"paymentContext"`
+				Required       string         `json:"required,omitempty" doc:"insufficient_payment: the price of the request" example:"0.002"`
+				Paid           string         `json:"paid,omitempty" doc:"insufficient_payment: the amount that was signed" example:"0.001"`
+				Shortfall      string         `json:"shortfall,omitempty" doc:"insufficient_payment: how much is missing" example:"0.001"`
+				Field          string         `json:"field,omitempty" doc:"payment_mismatch: the signed field that differs" example:"recipient"`
+				Expected       string         `json:"expected,omitempty" doc:"payment_mismatch: the value this gateway requires"`
+				Got            string         `json:"got,omitempty" doc:"payment_mismatch: the value that was signed"`
+				ExpiresAt      time.Time      `json:"expires_at" doc:"When the nonce in paymentContext stops being accepted"`
+				Tokens         int            `json:"tokens,omitempty" doc:"Input tokens counted in the text, present when the body was sent" example:"412"`
+				SizeBand       string         `json:"size_band,omitempty" doc:"Size band the text was priced in, present when the body was sent and PRICE_SIZE_BANDS is set" example:"100KB"`
+				SizeBands      []SizeBand     `json:"size_bands,omitempty" doc:"Prices by the text's size, with PRICE_SIZE_BANDS"`
+				Model          string         `json:"model,omitempty" doc:"Model the quote is for, present when the body was sent"`
+				PromoCode      string         `json:"promo_code,omitempty" doc:"Promo code applied to paymentContext.amount, present when X-Promo-Code was sent" example:"LAUNCH50"`
+				Discount       string         `json:"discount,omitempty" doc:"Amount the promo code took off the price" example:"0.0005"`
+				RecipientName  string         `json:"recipient_name,omitempty" doc:"ENS name paymentContext.recipient was resolved from, when RECIPIENT_ADDRESS is one" example:"paygate.eth"`
+				LoadMultiplier string         `json:"load_multiplier,omitempty" doc:"Factor the price was raised by for the gateway's current load, with LOAD_PRICING; it holds until expires_at" example:"1.5"`
+				Spent          string         `json:"spent,omitempty" doc:"channel_underpaid, channel_exhausted: the channel's spent amount" example:"0.042"`
+				Deposit        string         `json:"deposit,omitempty" doc:"channel_exhausted: the channel's deposit" example:"5"`
+				Balance        string         `json:"balance,omitempty" doc:"prepaid_insufficient: the wallet's prepaid balance" example:"0.0004"`
+			}{}},
+			{Status: 401, Description: "X-402-Prepaid without the wallet's X-Wallet-Signature of the prepaid payment message (unauthorized); carries the message to sign", Problem: true, Body: struct {
+				Message string `json:"message,omitempty" example:"MicroAI-Paygate prepaid payment from 0x742d35cc6634c0532925a3b844bc454e4438f44e nonce n1.1760572800.abc.def body 0x1c8aff95..."`
+			}{}},
+			{Status: 403, Description: "Invalid signature (invalid_signature), a nonce the gateway didn't issue for this route, that has expired or that already paid for a request (invalid_nonce), the model is not in the wallet's plan (model_not_entitled), or the client address is blocked (ip_blocked)", Problem: true, Body: struct {
+				Reason        string   `json:"reason,omitempty" doc:"invalid_nonce: malformed, invalid, expired, spent, or used (escrow mode: the payment was already settled)" example:"expired"`
+				Model         string   `json:"model,omitempty"`
+				Plan          string   `json:"plan,omitempty"`
+				AllowedModels []string `json:"allowed_models,omitempty"`
+			}{}},
+			{Status: 404, Description: "No deposit is known for the X-402-Channel channel (channel_not_found)", Problem: true},
+			{Status: 409, Description: "The first request with this Idempotency-Key hasn't finished yet (idempotency_in_progress), or, with SETTLEMENT_MODE=escrow, a request paid with this nonce is still in progress (payment_held), or the payment channel is closed or expired (channel_closed)", Problem: true, Headers: []string{"Retry-After"}},
+			{Status: 413, Description: "Request body exceeds 10MB, after decompression (payload_too_large)", Problem: true},
+			{Status: 415, Description: "Content-Encoding other than gzip or deflate (unsupported_encoding)", Problem: true, Body: struct {
+				SupportedEncodings []string `json:"supported_encodings"`
+			}{}},
+			{Status: 422, Description: "The Idempotency-Key was already used with a different body or by another payer (idempotency_key_reused). Text is empty, not valid UTF-8, or over MAX_TEXT_CHARS / MAX_TEXT_TOKENS (invalid_text), the model is not in ALLOWED_MODELS (model_not_allowed), or output_language is not in SUPPORTED_OUTPUT_LANGUAGES (unsupported_language); checked before the payment is verified. With INJECTION_DETECTION=reject, text matching a prompt-injection rule (prompt_injection), checked after", Problem: true, Body: struct {
+				Constraint    string   `json:"constraint,omitempty" doc:"invalid_text: the failed constraint, one of non_empty, utf8, max_chars or max_tokens" example:"max_chars"`
+				Limit         int      `json:"limit,omitempty" doc:"invalid_text: the configured limit for max_chars and max_tokens"`
+				Model         string   `json:"model,omitempty" doc:"model_not_allowed: the requested model"`
+				AllowedModels []string `json:"allowed_models,omitempty" doc:"model_not_allowed: the models this gateway offers"`
+				Rules         []string `json:"rules,omitempty" doc:"prompt_injection: the rules the text matched" example:"ignore_instructions"`
+				Supported     []string `json:"supported_languages,omitempty" doc:"unsupported_language: the accepted output_language codes"`
+			}{}},
+			{Status: 428, Description: "With POW_CHALLENGE, an unsigned client is close to the anonymous rate limit (proof_of_work_required): solve the challenge and send it in X-PoW-Challenge and X-PoW-Solution with the next request", Problem: true, Body: struct {
+				Reason     string    `json:"reason" doc:"Why the request's solution wasn't accepted: missing, malformed, invalid, expired or spent" example:"missing"`
+				Challenge  string    `json:"challenge" doc:"Challenge to solve, bound to the client's IP"`
+				Difficulty int       `json:"difficulty" doc:"Leading zero bits sha256(challenge + \":\" + solution) needs" example:"18"`
+				Algorithm  string    `json:"algorithm" example:"sha256"`
+				ExpiresAt  time.Time `json:"expires_at" doc:"When the challenge stops being accepted"`
+			}{}},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited), or the wallet already has WALLET_MAX_CONCURRENT requests in flight (concurrency_limited); the signature can be retried", Problem: true, Headers: []string{"Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"}, Body: struct {
+				RetryAfter    int `json:"retry_after"`
+				MaxConcurrent int `json:"max_concurrent,omitempty" doc:"concurrency_limited: requests a wallet may have in flight"`
+			}{}},
+			{Status: 500, Description: "verifier_error, ai_service_failed, receipt_failed, model_resolution_failed or internal_error", Problem: true},
+			{Status: 502, Description: "The AI output was withheld by content moderation (output_flagged); the payment is refund-eligible. With SETTLEMENT_MODE=facilitator, the facilitator could not be reached (facilitator_error); nothing was charged", Problem: true, Body: struct {
+				Categories     []string `json:"categories,omitempty" doc:"Moderation categories that matched; custom_rule for MODERATION_KEYWORDS / MODERATION_RULES_FILE" example:"violence"`
+				RefundEligible bool     `json:"refund_eligible" doc:"The client paid for output it did not receive"`
+				Nonce          string   `json:"nonce" doc:"Nonce of the payment to refund"`
+			}{}},
+			{Status: 503, Description: "The verifier is marked down by the background health poller (verifier_unavailable), or every AI provider for the model is tripped by the circuit breaker (ai_unavailable, nonce not consumed), or only the mock provider answered (ai_unavailable, refund-eligible), or the moderation provider could not screen the output (moderation_unavailable, refund-eligible), or prices are in USD and no recent token price is known (price_unavailable)", Problem: true, Headers: []string{"Retry-After"}},
+			{Status: 504, Description: "The verifier (verifier_timeout), AI provider (ai_timeout) or whole request (request_timeout) timed out", Problem: true},
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/ai/summarize/quote", Tag: "AI",
+		Summary:     "Quote a summary",
+		Description: "Prices the request body as POST /v1/ai/summarize would, without verifying or charging anything or calling the AI provider, so clients can show the price before asking for a signature. The returned payment context carries a fresh nonce holding the price until expires_at; sign it and send it with the same body.",
+		Parameters: []apiParameter{
+			{Name: "Content-Encoding", In: "header", Description: "gzip or deflate to send the body compressed"},
+			{Name: "X-Promo-Code", In: "header", Description: "Promo code to price the request with; its use is only counted by the paid request"},
+			{Name: "model", In: "query", Description: "With a text/plain body: the model, as in the JSON body's model"},
+			{Name: "output_language", In: "query", Description: "With a text/plain body: the output language, as in the JSON body's output_language"},
+		},
+		RequestBody: SummarizeRequest{},
+		PlainText:   true,
+		Responses: []apiResponse{
+			{Status: 200, Description: "Price and payment context", Body: SummarizeQuote{}, Headers: append([]string{"X-Token-Price-USD"}, rateLimitHeaders...)},
+			{Status: 400, Description: "Request body is not valid JSON, a text/plain body declares a charset other than UTF-8 (invalid_request_body), or X-Promo-Code is unknown, expired or used up (invalid_promo_code)", Problem: true},
+			{Status: 413, Description: "Request body exceeds 10MB (payload_too_large)", Problem: true},
+			{Status: 422, Description: "Text is empty, not valid UTF-8, or over MAX_TEXT_CHARS / MAX_TEXT_TOKENS (invalid_text), the model is not in ALLOWED_MODELS (model_not_allowed), or output_language is not in SUPPORTED_OUTPUT_LANGUAGES (unsupported_language)", Problem: true},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+			{Status: 503, Description: "Prices are in USD and no recent token price is known (price_unavailable)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/payment/challenge", Tag: "AI",
+		Summary:     "Get a payment challenge",
+		Description: "Returns a payment context with a nonce issued by the gateway for the route, valid for NONCE_TTL_SECONDS, priced at the default amount. Paid requests must use a nonce from here or from a 402 challenge. With PRICE_PER_1K_TOKENS or PRICE_SIZE_BANDS, get the challenge by sending the text unpaid instead so it is priced for the text.",
+		Parameters:  []apiParameter{{Name: "route", In: "query", Description: "Paid route the nonce is for (default /ai/summarize)"}},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Payment context to sign", Body: PaymentChallenge{}, Headers: append([]string{"X-Token-Price-USD"}, rateLimitHeaders...)},
+			{Status: 400, Description: "route is not a paid route (invalid_query)", Problem: true, Body: struct {
+				Routes []string `json:"routes"`
+			}{}},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+			{Status: 503, Description: "Prices are in USD and no recent token price is known (price_unavailable)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/models", Tag: "AI",
+		Summary:     "List selectable models",
+		Description: "Models accepted in the summarize request's model field, with their prices. Any model is accepted when ALLOWED_MODELS is unset, in which case only the default is listed.",
+		Responses: []apiResponse{
+			{Status: 200, Description: "Models and prices", Body: ModelsResponse{}, Headers: append([]string{"X-Token-Price-USD"}, rateLimitHeaders...)},
+			{Status: 503, Description: "Prices are in USD and no recent token price is known (price_unavailable)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/receipts/{id}", Tag: "Receipts",
+		Summary:    "Look up a receipt",
+		Parameters: []apiParameter{{Name: "id", In: "path", Required: true, Description: "Receipt ID, e.g. rcpt_a1b2c3d4e5f6"}},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Signed receipt", Body: ReceiptLookupResponse{}, Headers: rateLimitHeaders},
+			{Status: 404, Description: "Receipt expired or never existed (receipt_not_found)", Problem: true},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/receipts/{id}/verify", Tag: "Receipts",
+		Summary: "Verify a receipt",
+		Description: "Checks the stored receipt's signature against the gateway's key and returns the canonical JSON it covers with its keccak256 digest, " +
+			"so third parties can confirm the response was paid for and served by this gateway. A bad signature is reported as valid: false with a reason.",
+		Parameters: []apiParameter{{Name: "id", In: "path", Required: true, Description: "Receipt ID, e.g. rcpt_a1b2c3d4e5f6"}},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Verification result", Body: ReceiptVerificationResponse{}, Headers: rateLimitHeaders},
+			{Status: 404, Description: "Receipt expired or never existed (receipt_not_found)", Problem: true},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/receipts/{id}/proof", Tag: "Receipts",
+		Summary: "Merkle inclusion proof of a receipt",
+		Description: "Receipts are batched into Merkle trees every RECEIPT_ANCHOR_INTERVAL_SECONDS and each root is published. " +
+			"The proof links the receipt's leaf to its batch root, so anyone holding the receipt can check it was part of the published batch.",
+		Parameters: []apiParameter{{Name: "id", In: "path", Required: true, Description: "Receipt ID, e.g. rcpt_a1b2c3d4e5f6"}},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Inclusion proof", Body: ReceiptProofResponse{}, Headers: rateLimitHeaders},
+			{Status: 404, Description: "Receipt unknown or expired (receipt_not_found), or not batched yet (proof_pending)", Problem: true, Headers: []string{"Retry-After"}},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+			{Status: 503, Description: "Anchoring is not enabled (anchoring_disabled)", Problem: true},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/payments/{nonce}", Tag: "Payments",
+		Summary: "Payment status",
+		Description: "What became of a payment, so payers can confirm the gateway collected it: whether it was verified, the settlement transaction " +
+			"from SETTLEMENT_FILE and, with ETH_RPC_URL, its confirmations. State moves from authorized (request in flight) to pending (served, " +
+			"not settled yet) to settled, or failed when the settlement reverted; refunded once the payment is returned.",
+		Parameters: []apiParameter{{Name: "nonce", In: "path", Required: true, Description: "Nonce of the payment"}},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Payment status", Body: PaymentStatus{}, Headers: rateLimitHeaders},
+			{Status: 404, Description: "The gateway has no record of the payment (payment_not_found)", Problem: true},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/channels/{id}", Tag: "Channels",
+		Summary: "Payment channel balance",
+		Description: "The channel's deposit, the cumulative amount of the last accepted balance update and what is left. " +
+			"Channels are registered from CHANNEL_DEPOSITS_FILE the first time they are used.",
+		Parameters: []apiParameter{{Name: "id", In: "path", Required: true, Description: "Channel ID (bytes32)"}},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Channel state", Body: ChannelResponse{}, Headers: rateLimitHeaders},
+			{Status: 404, Description: "No deposit is known for the channel, or channels are not enabled (channel_not_found)", Problem: true},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/channels/{id}/close", Tag: "Channels",
+		Summary: "Close a payment channel",
+		Description: "Closes the channel and queues its final balance, with the sender's signature of it, for settlement. " +
+			"Channels also close on their own once they expire. Closing a closed channel returns it unchanged.",
+		Parameters: []apiParameter{
+			{Name: "id", In: "path", Required: true, Description: "Channel ID (bytes32)"},
+			{Name: "X-Channel-Close-Signature", In: "header", Required: true, Description: "personal_sign by the channel's sender of \"MicroAI-Paygate close channel <id>\""},
+		},
+		Responses: []apiResponse{
+			{Status: 200, Description: "The closed channel", Body: ChannelResponse{}, Headers: rateLimitHeaders},
+			{Status: 401, Description: "Missing signature, or not signed by the sender (unauthorized)", Problem: true, Body: struct {
+				Message string `json:"message" doc:"The message to sign"`
+			}{}},
+			{Status: 404, Description: "No deposit is known for the channel (channel_not_found)", Problem: true},
+			{Status: 429, Description: "Rate limit exceeded (rate_limited)", Problem: true, Headers: []string{"Retry-After"}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/prepaid/{wallet}", Tag: "Payments",
+		Summary: "Prepaid balance of a wallet",
+		Description: "What is left of the wallet's prepaid balance, and how to top it up: transfer the token to deposit_address with memo appended to the " +
+			"transfer's calldata. Deposits are credited once PREPAID_CONFIRMATIONS blocks deep. Authenticate with the admin token, or sign the " +
+			"challenge from the 401 response with the wallet (personal_sign) and send X-Wallet-Signature and X-Wallet-Timestamp.",
+		Parameters: []apiParameter{
+			{Name: "wallet", In: "path", Required: true, Description: "Wallet address, or an ENS name when ETH_RPC_URL is set"},
+			{Name: "X-Wallet-Signature", In: "header", Description: "personal_sign signature of the challenge message"},
+			{Name: "X-Wallet-Timestamp", In: "header", Description: "Unix timestamp from the challenge"},
+		},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Prepaid balance", Body: PrepaidBalance{}, Headers: rateLimitHeaders},
+			{Status: 400, Description: "Malformed wallet (invalid_wallet)", Problem: true},
+			{Status: 401, Description: "Missing, expired or wrong signature (unauthorized); carries a fresh challenge to sign", Problem: true, Body: struct {
+				Challenge string `json:"challenge,omitempty" example:"MicroAI-Paygate prepaid access for 0x742d35cc6634c0532925a3b844bc454e4438f44e at 1760572800"`
+				Timestamp int64  `json:"timestamp,omitempty"`
+			}{}},
+			{Status: 404, Description: "Prepaid balances are not enabled (prepaid_disabled)", Problem: true},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/usage/{wallet}", Tag: "Usage",
+		Summary: "Usage and spend of a wallet",
+		Description: "Totals from the usage ledger over the window. Authenticate with the admin token, or sign the challenge from the 401 response " +
+			"with the wallet (personal_sign) and send X-Wallet-Signature and X-Wallet-Timestamp.",
+		Parameters: []apiParameter{
+			{Name: "wallet", In: "path", Required: true, Description: "Wallet address, or an ENS name when ETH_RPC_URL is set"},
+			{Name: "window", In: "query", Description: "1h, 24h, 7d, 30d (default), 90d or all"},
+			{Name: "X-Wallet-Signature", In: "header", Description: "personal_sign signature of the challenge message"},
+			{Name: "X-Wallet-Timestamp", In: "header", Description: "Unix timestamp from the challenge"},
+		},
+		Responses: []apiResponse{
+			{Status: 200, Description: "Usage totals", Body: WalletUsageResponse{}, Headers: rateLimitHeaders},
+			{Status: 400, Description: "Malformed wallet (invalid_wallet) or unknown window (invalid_window)", Problem: true},
+			{Status: 401, Description: "Missing, expired or wrong signature (unauthorized); carries a fresh challenge to sign", Problem: true, Body: struct {
+				Challenge string `json:"challenge,omitempty" example:"MicroAI-Paygate usage access for 0x742d35cc6634c0532925a3b844bc454e4438f44e at 1760572800"`
+				Timestamp int64  `json:"timestamp,omitempty"`
+			}{}},
+			{Status: 503, Description: "The usage ledger is not enabled (ledger_disabled)", Problem: true},
+		},
+	},
+	{
+		Method: "DELETE", Path: "/v1/privacy/{wallet}", Tag: "Usage",
+		Summary: "Erase a wallet's data",
+		Description: "Anonymizes the wallet's usage ledger entries (a Redis ledger deletes them) and deletes its stored receipts, the cached summaries " +
+			"its requests read or wrote and the stored Idempotency-Key responses carrying its receipts. Prepaid balances and channel deposits are kept. " +
+			"The erasure is recorded in the audit log under a hash of the wallet. Authenticate with the admin token, or sign the challenge from the " +
+			"401 response with the wallet (personal_sign) and send X-Wallet-Signature and X-Wallet-Timestamp.",
+		Parameters: []apiParameter{
+			{Name: "wallet", In: "path", Required: true, Description: "Wallet address, or an ENS name when ETH_RPC_URL is set"},
+			{Name: "X-Wallet-Signature", In: "header", Description: "personal_sign signature of the challenge message"},
+			{Name: "X-Wallet-Timestamp", In: "header", Description: "Unix timestamp from the challenge"},
+		},
+		Responses: []apiResponse{
+			{Status: 200, Description: "What was erased", Body: PrivacyErasure{}, Headers: rateLimitHeaders},
+			{Status: 400, Description: "Malformed wallet (invalid_wallet)", Problem: true},
+			{Status: 401, Description: "Missing, expired or wrong signature (unauthorized); carries a fresh challenge to sign", Problem: true, Body: struct {
+				Challenge string `json:"challenge,omitempty" example:"MicroAI-Paygate erase data of 0x742d35cc6634c0532925a3b844bc454e4438f44e at 1760572800"`
+				Timestamp int64  `json:"timestamp,omitempty"`
+			}{}},
+			{Status: 500, Description: "A step failed (internal_error), named in failed; what was erased before it stays erased, so repeat the request", Problem: true, Body: struct {
+				Failed string `json:"failed,omitempty" doc:"usage, cache, idempotency or receipts" example:"receipts"`
+			}{}},
+		},
+	},
+	{
+		Method: "GET", Path: "/healthz", Tag: "Health",
+		Summary:     "Liveness (legacy)",
+		Description: "Alias of /livez.",
+		Responses:   []apiResponse{{Status: 200, Description: "Gateway process is alive", Body: HealthResponse{}}},
+	},
+	{
+		Method: "GET", Path: "/livez", Tag: "Health",
+		Summary:     "Liveness probe",
+		Description: "Returns 200 while the gateway process is up. Does not check dependencies.",
+		Responses:   []apiResponse{{Status: 200, Description: "Gateway process is alive", Body: HealthResponse{}}},
+	},
+	{
+		Method: "GET", Path: "/readyz", Tag: "Health",
+		Summary:     "Readiness probe",
+		Description: "Checks the verifier (critical), Redis (if configured) and optionally the OpenRouter API key, and reports per-dependency status.",
+		Responses: []apiResponse{
+			{Status: 200, Description: "All critical dependencies are up", Body: ReadinessResponse{}},
+			{Status: 503, Description: "A critical dependency is down", Body: ReadinessResponse{}},
+		},
+	},
+	{
+		Method: "GET", Path: "/metrics", Tag: "Operations",
+		Summary:   "Prometheus metrics",
+		Responses: []apiResponse{{Status: 200, Description: "Prometheus text exposition format", Body: "", ContentType: "text/plain"}},
+	},
+	{
+		Method: "GET", Path: "/admin/cache/stats", Tag: "Admin", Admin: true,
+		Summary:   "Response cache statistics",
+		Responses: adminResponses(apiResponse{Status: 200, Description: "Hit/miss counters and Redis usage", Body: CacheStats{}}),
+	},
+	{
+		Method: "DELETE", Path: "/admin/cache", Tag: "Admin", Admin: true,
+		Summary: "Purge the response cache",
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Cache purged", Body: CachePurgeResponse{}},
+			apiResponse{Status: 500, Description: "Purge failed part way (cache_operation_failed)", Problem: true, Body: CachePurgeResponse{}},
+		),
+	},
+	{
+		Method: "DELETE", Path: "/admin/cache/{key}", Tag: "Admin", Admin: true,
+		Summary:    "Delete one cache entry",
+		Parameters: []apiParameter{{Name: "key", In: "path", Required: true, Description: "Full cache key or bare hash"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Entry deleted", Body: CacheDeleteResponse{}},
+			apiResponse{Status: 404, Description: "No such entry (cache_entry_not_found)", Problem: true},
+			apiResponse{Status: 500, Description: "Delete failed (cache_operation_failed)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/abuse", Tag: "Admin", Admin: true,
+		Summary:   "Wallets that submitted flagged input",
+		Responses: adminResponses(apiResponse{Status: 200, Description: "Abuse scores from prompt-injection detection, highest first", Body: AbuseReport{}}),
+	},
+	{
+		Method: "GET", Path: "/admin/reputation", Tag: "Admin", Admin: true,
+		Summary: "Wallet reputation scores",
+		Description: "Scores every wallet this instance has served or seen misbehave, lowest first, from its settled and refunded payments, facilitator refusals, " +
+			"rate-limit refusals, moderation flags and abuse score. Signals are kept per instance.",
+		Parameters: []apiParameter{{Name: "limit", In: "query", Description: "Most wallets returned, 1-1000 (default 100)"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Reputation scores, lowest first", Body: ReputationReport{}},
+			apiResponse{Status: 400, Description: "Malformed limit (invalid_query)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/reputation/{wallet}", Tag: "Admin", Admin: true,
+		Summary:    "One wallet's reputation",
+		Parameters: []apiParameter{{Name: "wallet", In: "path", Required: true, Description: "Wallet address, or ENS name when ETH_RPC_URL is set"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "The wallet's score and the signals behind it, with its payment history read from the ledger", Body: WalletReputation{}},
+			apiResponse{Status: 400, Description: "Not an address or resolvable ENS name (invalid_wallet)", Problem: true},
+			apiResponse{Status: 500, Description: "The ledger could not be read (internal_error)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/export/usage", Tag: "Admin", Admin: true,
+		Summary: "Export the usage ledger",
+		Description: "One page of ledger entries, oldest first, as CSV (with a header row) or JSON Lines of LedgerEntry. " +
+			"Pass X-Next-Cursor back as cursor until a page comes back without it.",
+		Parameters: []apiParameter{
+			{Name: "from", In: "query", Description: "Start of the range, RFC 3339 or YYYY-MM-DD"},
+			{Name: "to", In: "query", Description: "End of the range (exclusive), RFC 3339 or YYYY-MM-DD"},
+			{Name: "format", In: "query", Description: "csv (default) or jsonl"},
+			{Name: "limit", In: "query", Description: "Entries per page, 1-10000 (default 1000)"},
+			{Name: "cursor", In: "query", Description: "X-Next-Cursor of the previous page"},
+		},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Ledger entries", Body: "", ContentType: "text/csv", Headers: []string{"X-Next-Cursor"}},
+			apiResponse{Status: 400, Description: "Malformed from, to, format, limit or cursor (invalid_query)", Problem: true},
+			apiResponse{Status: 503, Description: "The usage ledger is not enabled (ledger_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/stats", Tag: "Admin", Admin: true,
+		Summary: "Revenue and traffic summary",
+		Description: "Rolled-up figures over the last 1h, 24h and 7d. Paid figures come from the usage ledger and cover every instance; " +
+			"traffic figures count this instance's public API responses.",
+		Responses: adminResponses(apiResponse{Status: 200, Description: "Figures per window", Body: AdminStatsResponse{}}),
+	},
+	{
+		Method: "GET", Path: "/admin/reconciliation", Tag: "Admin", Admin: true,
+		Summary:     "Payment reconciliation report",
+		Description: "Mismatches between verified payments, settled transfers and served responses from the last reconciliation run; runs it first when there is no report yet.",
+		Parameters:  []apiParameter{{Name: "refresh", In: "query", Description: "true to run the reconciliation now"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Reconciliation report", Body: ReconciliationReport{}},
+			apiResponse{Status: 503, Description: "Reconciliation is not enabled (reconciliation_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/audit", Tag: "Admin", Admin: true,
+		Summary:     "Query the audit log",
+		Description: "The most recent audit entries matching the filters, oldest first.",
+		Parameters: []apiParameter{
+			{Name: "action", In: "query", Description: "Only this action, e.g. cache_purge"},
+			{Name: "actor", In: "query", Description: "admin or system"},
+			{Name: "from", In: "query", Description: "Start of the range, RFC 3339 or YYYY-MM-DD"},
+			{Name: "to", In: "query", Description: "End of the range (exclusive), RFC 3339 or YYYY-MM-DD"},
+			{Name: "limit", In: "query", Description: "Entries to return, 1-1000 (default 100)"},
+		},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Audit entries", Body: AuditLogResponse{}},
+			apiResponse{Status: 400, Description: "Malformed from, to or limit (invalid_query)", Problem: true},
+			apiResponse{Status: 503, Description: "The audit log is not enabled (audit_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/audit/verify", Tag: "Admin", Admin: true,
+		Summary:     "Verify the audit log's hash chain",
+		Description: "Recomputes every entry's hash and link and reports the first entry that was modified, removed or reordered.",
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Verification result", Body: AuditVerifyResponse{}},
+			apiResponse{Status: 503, Description: "The audit log is not enabled (audit_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/ip-acl", Tag: "Admin", Admin: true,
+		Summary:   "IP access list",
+		Responses: adminResponses(apiResponse{Status: 200, Description: "Managed and environment entries", Body: IPACLResponse{}}),
+	},
+	{
+		Method: "PUT", Path: "/admin/ip-acl", Tag: "Admin", Admin: true,
+		Summary: "Replace the managed IP access list",
+		Description: "Replaces the managed allow and deny entries (IPs or CIDR ranges) and applies them at once; IP_ALLOWLIST and IP_DENYLIST stay. " +
+			"With IP_ACL_FILE the entries are saved there, so they survive restarts and reach every instance sharing the file.",
+		RequestBody: IPACLRules{},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Entries in force", Body: IPACLResponse{}},
+			apiResponse{Status: 400, Description: "Malformed body or entry (invalid_request_body)", Problem: true},
+			apiResponse{Status: 500, Description: "IP_ACL_FILE could not be written (internal_error)", Problem: true},
+		),
+	},
+	{
+		Method: "POST", Path: "/admin/refunds", Tag: "Admin", Admin: true,
+		Summary: "Refund a payment",
+		Description: "Records a refund of the payment with the nonce in the usage ledger and, with REFUND_QUEUE_FILE, queues the reverse transfer for the settler. " +
+			"The amount defaults to, and may not exceed, what the ledger says was charged; it is required when the request failed before being recorded.",
+		RequestBody: RefundRequest{},
+		Responses: adminResponses(
+			apiResponse{Status: 201, Description: "Refund recorded", Body: Refund{}},
+			apiResponse{Status: 400, Description: "Malformed body or amount (invalid_request_body), or wallet (invalid_wallet)", Problem: true},
+			apiResponse{Status: 409, Description: "The payment was already refunded (refund_exists)", Problem: true, Body: struct {
+				RefundID string `json:"refund_id" example:"rfnd_a1b2c3d4e5f6"`
+			}{}},
+			apiResponse{Status: 500, Description: "The ledger could not be read or written (internal_error)", Problem: true},
+			apiResponse{Status: 503, Description: "The usage ledger is not enabled (ledger_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "POST", Path: "/admin/channels/{id}/close", Tag: "Admin", Admin: true,
+		Summary:     "Close a payment channel",
+		Description: "Closes the channel without the sender's signature and queues its final balance for settlement.",
+		Parameters:  []apiParameter{{Name: "id", In: "path", Required: true, Description: "Channel ID (bytes32)"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "The closed channel", Body: ChannelResponse{}},
+			apiResponse{Status: 404, Description: "No deposit is known for the channel (channel_not_found)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/promo-codes", Tag: "Admin", Admin: true,
+		Summary:   "Promo codes",
+		Responses: adminResponses(apiResponse{Status: 200, Description: "Every promo code with its redemptions so far, by code", Body: PromoCodesResponse{}}),
+	},
+	{
+		Method: "PUT", Path: "/admin/promo-codes/{code}", Tag: "Admin", Admin: true,
+		Summary: "Create or replace a promo code",
+		Description: "Sets the code's discount (percent_off or amount_off), redemption cap and expiry. The code comes from the path and is stored upper case; " +
+			"redemptions already counted are kept. With REDIS_URL every instance shares the codes and their counts.",
+		Parameters:  []apiParameter{{Name: "code", In: "path", Required: true, Description: "1-64 letters, digits, '-' or '_'"}},
+		RequestBody: PromoCode{},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "The code as saved", Body: PromoCode{}},
+			apiResponse{Status: 400, Description: "Malformed body, code or discount (invalid_request_body)", Problem: true},
+			apiResponse{Status: 500, Description: "The code could not be saved (internal_error)", Problem: true},
+		),
+	},
+	{
+		Method: "DELETE", Path: "/admin/promo-codes/{code}", Tag: "Admin", Admin: true,
+		Summary:    "Delete a promo code",
+		Parameters: []apiParameter{{Name: "code", In: "path", Required: true, Description: "The code, in any case"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Code deleted", Body: PromoCodeDeleteResponse{}},
+			apiResponse{Status: 404, Description: "No such code (promo_code_not_found)", Problem: true},
+			apiResponse{Status: 500, Description: "The code could not be deleted (internal_error)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/tenants", Tag: "Admin", Admin: true,
+		Summary: "Tenants",
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Every tenant by ID, with provider keys masked", Body: TenantsResponse{}},
+			apiResponse{Status: 503, Description: "MULTI_TENANT is not enabled (multi_tenant_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/tenants/{id}", Tag: "Admin", Admin: true,
+		Summary:    "A tenant",
+		Parameters: []apiParameter{{Name: "id", In: "path", Required: true, Description: "Tenant ID"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "The tenant, with provider keys masked", Body: Tenant{}},
+			apiResponse{Status: 404, Description: "No such tenant (tenant_not_found)", Problem: true},
+			apiResponse{Status: 503, Description: "MULTI_TENANT is not enabled (multi_tenant_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "PUT", Path: "/admin/tenants/{id}", Tag: "Admin", Admin: true,
+		Summary: "Create or replace a tenant",
+		Description: "Sets the hosts the tenant is reached at (it is also reached at /t/{id}/v1), and its recipient, prices, rate limit and provider keys; " +
+			"settings left out use the gateway's. With REDIS_URL every instance serves the same tenants, picking up changes within TENANT_RELOAD_INTERVAL_SECONDS.",
+		Parameters:  []apiParameter{{Name: "id", In: "path", Required: true, Description: "1-63 lowercase letters, digits or '-'"}},
+		RequestBody: Tenant{},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "The tenant as saved, with provider keys masked", Body: Tenant{}},
+			apiResponse{Status: 400, Description: "Malformed body, ID, host, address, price or provider key (invalid_request_body)", Problem: true},
+			apiResponse{Status: 409, Description: "A host already belongs to another tenant (tenant_conflict)", Problem: true, Body: struct {
+				Host   string `json:"host" example:"search.paygate.example.com"`
+				Tenant string `json:"tenant" doc:"The tenant the host belongs to" example:"search"`
+			}{}},
+			apiResponse{Status: 500, Description: "The tenant could not be saved (internal_error)", Problem: true},
+			apiResponse{Status: 503, Description: "MULTI_TENANT is not enabled (multi_tenant_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "DELETE", Path: "/admin/tenants/{id}", Tag: "Admin", Admin: true,
+		Summary:    "Delete a tenant",
+		Parameters: []apiParameter{{Name: "id", In: "path", Required: true, Description: "Tenant ID"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Tenant deleted; its hosts are served with the gateway's settings again", Body: TenantDeleteResponse{}},
+			apiResponse{Status: 404, Description: "No such tenant (tenant_not_found)", Problem: true},
+			apiResponse{Status: 500, Description: "The tenant could not be deleted (internal_error)", Problem: true},
+			apiResponse{Status: 503, Description: "MULTI_TENANT is not enabled (multi_tenant_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "GET", Path: "/admin/ratelimits", Tag: "Admin", Admin: true,
+		Summary: "Rate limit buckets",
+		Description: "Lists this instance's active rate limit buckets, the emptiest first, with the tier that applies to each and how many requests it refused, " +
+			"to tell why a client gets 429s; and the overrides. Buckets are kept per instance, so ask each one behind a load balancer.",
+		Parameters: []apiParameter{
+			{Name: "key", In: "query", Description: "Only buckets whose key starts with this, e.g. ip:203.0.113.7 or wallet:0x..."},
+			{Name: "limit", In: "query", Description: "Most buckets returned, 1-1000 (default 100)"},
+		},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Buckets and overrides", Body: RateLimitsResponse{}},
+			apiResponse{Status: 400, Description: "Malformed limit (invalid_query)", Problem: true},
+			apiResponse{Status: 503, Description: "RATE_LIMIT_ENABLED is not set (rate_limiting_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "PUT", Path: "/admin/ratelimits/overrides", Tag: "Admin", Admin: true,
+		Summary: "Create or replace a rate limit override",
+		Description: "Gives one wallet or IP its own limit instead of its tier's. An IP override applies to every request from the IP; a wallet override to its paid requests, " +
+			"once the payment is verified. With REDIS_URL every instance applies it within RATE_LIMIT_OVERRIDE_RELOAD_INTERVAL_SECONDS.",
+		RequestBody: RateLimitOverride{},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "The override as saved", Body: RateLimitOverride{}},
+			apiResponse{Status: 400, Description: "Malformed body, wallet, IP or limit (invalid_request_body)", Problem: true},
+			apiResponse{Status: 500, Description: "The override could not be saved (internal_error)", Problem: true},
+			apiResponse{Status: 503, Description: "RATE_LIMIT_ENABLED is not set (rate_limiting_disabled)", Problem: true},
+		),
+	},
+	{
+		Method: "DELETE", Path: "/admin/ratelimits/overrides/{key}", Tag: "Admin", Admin: true,
+		Summary:    "Delete a rate limit override",
+		Parameters: []apiParameter{{Name: "key", In: "path", Required: true, Description: "wallet:<address> or ip:<address>"}},
+		Responses: adminResponses(
+			apiResponse{Status: 200, Description: "Override deleted; the wallet or IP gets its tier's limit again", Body: RateLimitOverrideDeleteResponse{}},
+			apiResponse{Status: 404, Description: "No such override (rate_limit_override_not_found)", Problem: true},
+			apiResponse{Status: 500, Description: "The override could not be deleted (internal_error)", Problem: true},
+			apiResponse{Status: 503, Description: "RATE_LIMIT_ENABLED is not set (rate_limiting_disabled)", Problem: true},
+		),
+	},
+}
+
+// adminResponses adds the auth failures shared by every admin route.
+func adminResponses(responses ...apiResponse) []apiResponse {
+	return append(responses,
+		apiResponse{Status: 401, Description: "Missing or wrong admin token (unauthorized)", Problem: true},
+		apiResponse{Status: 403, Description: "ADMIN_API_TOKEN is not set (admin_disabled)", Problem: true},
+	)
+}
+
+var (
+	openAPISpecOnce sync.Once
+	openAPISpecJSON []byte
+	openAPISpecYAML []byte
+)
+
+// buildOpenAPISpec assembles the OpenAPI 3.1 document.
+func buildOpenAPISpec() map[string]interface{} {
+	schemas := &schemaRegistry{schemas: map[string]interface{}{}}
+	problemRef := schemas.schemaFor(reflect.TypeOf(Problem{}))
+
+	paths := map[string]interface{}{}
+	addOperation := func(op apiOperation, deprecated bool) {
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = buildOperation(op, schemas, problemRef, deprecated)
+	}
+	for _, op := range openAPIOperations {
+		addOperation(op, false)
+		if strings.HasPrefix(op.Path, apiV1Prefix+"/") {
+			legacy := op
+			legacy.Path = legacyAPIPrefix + strings.TrimPrefix(op.Path, apiV1Prefix)
+			legacy.Description = strings.TrimSpace("Deprecated alias of " + op.Path + ". " + op.Description)
+			addOperation(legacy, true)
+
+			tenant := op
+			tenant.Path = "/t/{tenant}" + op.Path
+			tenant.Description = strings.TrimSpace(op.Path + " for a tenant, with MULTI_TENANT=true. " + op.Description)
+			tenant.Parameters = append([]apiParameter{{Name: "tenant", In: "path", Required: true, Description: "Tenant ID"}}, op.Parameters...)
+			addOperation(tenant, false)
+		}
+	}
+
+	headers := map[string]interface{}{}
+	for name, description := range openAPIHeaders {
+		headers[name] = map[string]interface{}{"description": description, "schema": map[string]interface{}{"type": "string"}}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "MicroAI Paygate API",
+			"version":     "1.0.0",
+			"description": "Pay-per-request AI endpoints using the x402 protocol. Every error is an RFC 7807 application/problem+json body; branch on its stable `code`.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.schemas,
+			"headers": headers,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "ADMIN_API_TOKEN"},
+			},
+		},
+	}
+}
+
+func buildOperation(op apiOperation, schemas *schemaRegistry, problemRef map[string]interface{}, deprecated bool) map[string]interface{} {
+	operation := map[string]interface{}{
+		"summary": op.Summary,
+		"tags":    []string{op.Tag},
+	}
+	if op.Description != "" {
+		operation["description"] = op.Description
+	}
+	if deprecated {
+		operation["deprecated"] = true
+	}
+	if op.Admin {
+		operation["security"] = []map[string][]string{{"adminToken": {}}}
+	}
+
+	if len(op.Parameters) > 0 {
+		var params []map[string]interface{}
+		for _, p := range op.Parameters {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.Required,
+				"description": p.Description,
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		operation["parameters"] = params
+	}
+
+	if op.RequestBody != nil {
+		content := map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemas.schemaFor(reflect.TypeOf(op.RequestBody))},
+		}
+		if op.PlainText {
+			content["text/plain"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "description": "The text to summarize, UTF-8; model and output_language go in the query string"}}
+		}
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content,
+		}
+	}
+
+	responses := map[string]interface{}{}
+	for _, resp := range op.Responses {
+		r := map[string]interface{}{"description": resp.Description}
+
+		var schema map[string]interface{}
+		contentType := resp.ContentType
+		switch {
+		case resp.Problem && resp.Body != nil:
+			schema = map[string]interface{}{"allOf": []interface{}{problemRef, schemas.schemaFor(reflect.TypeOf(resp.Body))}}
+			contentType = problemContentType
+		case resp.Problem:
+			schema = problemRef
+			contentType = problemContentType
+		case resp.Body != nil:
+			schema = schemas.schemaFor(reflect.TypeOf(resp.Body))
+		}
+		if schema != nil {
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			r["content"] = map[string]interface{}{contentType: map[string]interface{}{"schema": schema}}
+		}
+
+		respHeaders := resp.Headers
+		if deprecated {
+			respHeaders = append(append([]string{}, respHeaders...), "Deprecation", "Sunset", "Link")
+		}
+		if len(respHeaders) > 0 {
+			h := map[string]interface{}{}
+			for _, name := range respHeaders {
+				h[name] = map[string]interface{}{"$ref": "#/components/headers/" + name}
+			}
+			r["headers"] = h
+		}
+		responses[strconv.Itoa(resp.Status)] = r
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+// schemaRegistry converts Go types to JSON Schema, registering named structs
+// under components/schemas. Field names follow the json tags; the optional
+// doc and example tags become description and example.
+type schemaRegistry struct {
+	schemas map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (r *schemaRegistry) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": r.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": r.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.structSchema(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := r.schemas[name]; !ok {
+			r.schemas[name] = map[string]interface{}{} // placeholder for recursive types
+			r.schemas[name] = r.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (r *schemaRegistry) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := r.schemaFor(field.Type)
+		if doc := field.Tag.Get("doc"); doc != "" || field.Tag.Get("example") != "" {
+			// Copy so $ref schemas and shared primitives aren't mutated
+			annotated := make(map[string]interface{}, len(prop)+2)
+			for k, v := range prop {
+				annotated[k] = v
+			}
+			if doc != "" {
+				annotated["description"] = doc
+			}
+			if example := field.Tag.Get("example"); example != "" {
+				annotated["example"] = exampleValue(annotated["type"], example)
+			}
+			prop = annotated
+		}
+		properties[name] = prop
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// exampleValue converts an example tag to the field's JSON type.
+func exampleValue(schemaType interface{}, example string) interface{} {
+	switch schemaType {
+	case "integer":
+		if n, err := strconv.ParseInt(example, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(example, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(example); err == nil {
+			return b
+		}
+	}
+	return example
+}
+
+// openAPISpec returns the generated document as JSON and YAML, built once.
+func openAPISpec() (jsonSpec, yamlSpec []byte) {
+	openAPISpecOnce.Do(func() {
+		spec := buildOpenAPISpec()
+		openAPISpecJSON, _ = json.MarshalIndent(spec, "", "  ")
+		openAPISpecYAML, _ = yaml.Marshal(spec)
+	})
+	return openAPISpecJSON, openAPISpecYAML
+}
+
+// handleOpenAPIJSON handles GET /openapi.json.
+func handleOpenAPIJSON(c *gin.Context) {
+	spec, _ := openAPISpec()
+	c.Data(200, "application/json; charset=utf-8", spec)
+}
+
+// handleOpenAPIYAML handles GET /openapi.yaml, kept for existing links.
+func handleOpenAPIYAML(c *gin.Context) {
+	_, spec := openAPISpec()
+	c.Data(200, "application/yaml; charset=utf-8", spec)
+}
+
+// handleDocs serves Swagger UI for the generated spec.
+func handleDocs(c *gin.Context) {
+	c.Header("Content-Type", "text/html")
+	c.String(200, `
+<!DOCTYPE html>
+<html>
+<head>
+  <title>MicroAI Paygate Docs</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5.11.0/swagger-ui.css" />
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5.11.0/swagger-ui-bundle.js"></script>
+  <script>
+    SwaggerUIBundle({
+      url: '/openapi.json',
+      dom_id: '#swagger-ui'
+    });
+  </script>
+</body>
+</html>
+`)
+}
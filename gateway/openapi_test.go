@@ -1,29 +1,102 @@
 package main
 
 import (
-	"os"
-	"path/filepath"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
 )
 
-func TestOpenAPISpecMatchesRoutes(t *testing.T) {
-	specPath := filepath.Join(".", "openapi.yaml")
-	data, err := os.ReadFile(specPath)
-	if err != nil {
-		t.Fatalf("failed to read openapi.yaml: %v", err)
+// routesWithoutSpec are served but intentionally left out of the spec.
+var routesWithoutSpec = map[string]bool{
+	"GET /openapi.json": true,
+	"GET /openapi.yaml": true,
+	"GET /docs":         true,
+}
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	paths := buildOpenAPISpec()["paths"].(map[string]interface{})
+
+	param := regexp.MustCompile(`:(\w+)`)
+	for _, route := range newRouter().Routes() {
+		if routesWithoutSpec[route.Method+" "+route.Path] {
+			continue
+		}
+		path := param.ReplaceAllString(route.Path, "{$1}")
+		item, ok := paths[path].(map[string]interface{})
+		if !ok || item[strings.ToLower(route.Method)] == nil {
+			t.Errorf("OpenAPI spec missing %s %s", route.Method, path)
+		}
 	}
+}
 
-	spec := string(data)
+func TestOpenAPISpec_LegacyAliasesDeprecated(t *testing.T) {
+	paths := buildOpenAPISpec()["paths"].(map[string]interface{})
 
-	expectedPaths := []string{
-		"/healthz",
-		"/api/ai/summarize",
+	legacy := paths["/api/ai/summarize"].(map[string]interface{})["post"].(map[string]interface{})
+	if legacy["deprecated"] != true {
+		t.Error("Expected /api/ai/summarize to be marked deprecated")
+	}
+	current := paths["/v1/ai/summarize"].(map[string]interface{})["post"].(map[string]interface{})
+	if current["deprecated"] != nil {
+		t.Error("Expected /v1/ai/summarize not to be deprecated")
 	}
+}
 
-	for _, path := range expectedPaths {
-		if !strings.Contains(spec, path) {
-			t.Errorf("OpenAPI spec missing path: %s", path)
+func TestOpenAPISpec_SchemasFollowGoTypes(t *testing.T) {
+	schemas := buildOpenAPISpec()["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	payment := schemas["PaymentContext"].(map[string]interface{})["properties"].(map[string]interface{})
+	for _, field := range []string{"recipient", "token", "amount", "nonce", "chainId"} {
+		if payment[field] == nil {
+			t.Errorf("PaymentContext schema missing %s", field)
 		}
 	}
+	chainID := payment["chainId"].(map[string]interface{})
+	if chainID["type"] != "integer" || chainID["example"] != int64(8453) {
+		t.Errorf("Unexpected chainId schema: %v", chainID)
+	}
+
+	problem := schemas["Problem"].(map[string]interface{})
+	if problem["properties"].(map[string]interface{})["Extensions"] != nil {
+		t.Error("Expected json:\"-\" fields to be skipped")
+	}
+	if req := problem["required"].([]string); strings.Join(req, ",") != "type,code,title,status" {
+		t.Errorf("Unexpected Problem required fields: %v", req)
+	}
+
+	summarize := schemas["SummarizeRequest"].(map[string]interface{})
+	if req := summarize["required"].([]string); len(req) != 1 || req[0] != "text" {
+		t.Errorf("Expected only text to be required, got %v", req)
+	}
+}
+
+func TestOpenAPIHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/openapi.json", handleOpenAPIJSON)
+	r.GET("/openapi.yaml", handleOpenAPIYAML)
+
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var spec map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil || spec["openapi"] != "3.1.0" {
+		t.Fatalf("Expected a JSON OpenAPI 3.1 document, got %v", err)
+	}
+
+	req, _ = http.NewRequest("GET", "/openapi.yaml", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var yamlSpec map[string]interface{}
+	if err := yaml.Unmarshal(w.Body.Bytes(), &yamlSpec); err != nil || yamlSpec["openapi"] != "3.1.0" {
+		t.Fatalf("Expected a YAML OpenAPI 3.1 document, got %v", err)
+	}
 }
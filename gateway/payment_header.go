@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// paymentHeader carries the payment context the client signed, as base64
+// JSON, so the gateway can compare it with what the route requires instead
+// of only verifying the signature against its own reconstruction.
+const paymentHeader = "X-402-Payment"
+
+var paymentMismatchesTotal = newCounter(
+	"gateway_payment_mismatches_total",
+	"Paid requests refused because the signed payment in X-402-Payment didn't meet the route's requirement, by field (amount, recipient, token, chainId, nonce, bodyHash).",
+	"field",
+)
+
+// readDeclaredPayment decodes X-402-Payment, returning nil when the client
+// didn't send it.
+func readDeclaredPayment(c *gin.Context) (*PaymentContext, error) {
+	raw := c.GetHeader(paymentHeader)
+	if raw == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("X-402-Payment is not base64")
+	}
+	var declared PaymentContext
+	if err := json.Unmarshal(data, &declared); err != nil {
+		return nil, errors.New("X-402-Payment is not a JSON payment context")
+	}
+	if _, ok := parseDecimal(declared.Amount); !ok {
+		return nil, errors.New("X-402-Payment amount is not a decimal")
+	}
+	return &declared, nil
+}
+
+// paymentMismatch describes how a declared payment falls short of the
+// required one.
+type paymentMismatch struct {
+	Field    string
+	Expected string
+	Got      string
+}
+
+// checkDeclaredPayment compares the payment the client signed with the one
+// required. Every field must match except the amount, which may exceed
+// the price. Amounts are assumed to be valid decimals.
+func checkDeclaredPayment(declared, required PaymentContext) *paymentMismatch {
+	switch {
+	case !strings.EqualFold(declared.Recipient, required.Recipient):
+		return &paymentMismatch{"recipient", required.Recipient, declared.Recipient}
+	case declared.Token != required.Token:
+		return &paymentMismatch{"token", required.Token, declared.Token}
+	case declared.ChainID != required.ChainID:
+		return &paymentMismatch{"chainId", strconv.Itoa(required.ChainID), strconv.Itoa(declared.ChainID)}
+	case declared.Nonce != required.Nonce:
+		return &paymentMismatch{"nonce", required.Nonce, declared.Nonce}
+	case !strings.EqualFold(declared.BodyHash, required.BodyHash):
+		return &paymentMismatch{"bodyHash", required.BodyHash, declared.BodyHash}
+	}
+	paid, _ := new(big.Rat).SetString(declared.Amount)
+	price, _ := new(big.Rat).SetString(required.Amount)
+	if paid.Cmp(price) < 0 {
+		return &paymentMismatch{"amount", required.Amount, declared.Amount}
+	}
+	return nil
+}
+
+// abortPaymentMismatch answers 402 with a fresh payment context for the
+// required payment: insufficient_payment with the shortfall for an
+// underpayment, payment_mismatch naming the field otherwise.
+func abortPaymentMismatch(c *gin.Context, m *paymentMismatch, required PaymentContext) {
+	paymentMismatchesTotal.Inc(m.Field)
+	paymentContext, expiresAt := createPaymentContext(c.Request.Context(), paymentRoute(c))
+	paymentContext.Amount = required.Amount
+	paymentContext.BodyHash = required.BodyHash
+
+	var p *Problem
+	if m.Field == "amount" {
+		paid, _ := new(big.Rat).SetString(m.Got)
+		price, _ := new(big.Rat).SetString(m.Expected)
+		p = newProblem(402, codeInsufficientPayment, "Payment Required",
+			"The signed amount is less than the price of this request").
+			With("required", m.Expected).
+			With("paid", m.Got).
+			With("shortfall", formatAmount(new(big.Rat).Sub(price, paid)))
+	} else {
+		p = newProblem(402, codePaymentMismatch, "Payment Required",
+			"The signed payment doesn't match what this request requires").
+			With("field", m.Field).
+			With("expected", m.Expected).
+			With("got", m.Got)
+	}
+	abortWithProblem(c, p.With("expires_at", expiresAt.UTC()).With("paymentContext", paymentContext))
+}
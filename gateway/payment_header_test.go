@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/testsupport"
+)
+
+func TestCheckDeclaredPayment(t *testing.T) {
+	required := PaymentContext{Recipient: defaultRecipientAddress, Token: "USDC", Amount: "0.002", Nonce: "n", ChainID: 8453, BodyHash: "0xab"}
+	tests := []struct {
+		name  string
+		edit  func(p *PaymentContext)
+		field string
+	}{
+		{"exact", func(p *PaymentContext) {}, ""},
+		{"overpaid", func(p *PaymentContext) { p.Amount = "0.01" }, ""},
+		{"recipient case", func(p *PaymentContext) { p.Recipient = strings.ToLower(p.Recipient) }, ""},
+		{"underpaid", func(p *PaymentContext) { p.Amount = "0.0015" }, "amount"},
+		{"recipient", func(p *PaymentContext) { p.Recipient = "0x0000000000000000000000000000000000000001" }, "recipient"},
+		{"token", func(p *PaymentContext) { p.Token = "DAI" }, "token"},
+		{"chain", func(p *PaymentContext) { p.ChainID = 1 }, "chainId"},
+		{"nonce", func(p *PaymentContext) { p.Nonce = "other" }, "nonce"},
+		{"body hash", func(p *PaymentContext) { p.BodyHash = "" }, "bodyHash"},
+	}
+	for _, tt := range tests {
+		declared := required
+		tt.edit(&declared)
+		got := ""
+		if m := checkDeclaredPayment(declared, required); m != nil {
+			got = m.Field
+		}
+		if got != tt.field {
+			t.Errorf("%s: expected mismatch %q, got %q", tt.name, tt.field, got)
+		}
+	}
+}
+
+func TestHandleSummarize_DeclaredPayment(t *testing.T) {
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	r := setupVersionedRouter()
+	text := "a text priced at the flat amount"
+
+	send := func(nonce string, declared interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"`+text+`"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		switch d := declared.(type) {
+		case string:
+			req.Header.Set("X-402-Payment", d)
+		case PaymentContext:
+			data, _ := json.Marshal(d)
+			req.Header.Set("X-402-Payment", base64.StdEncoding.EncodeToString(data))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	signed := func(nonce, amount string) PaymentContext {
+		return PaymentContext{Recipient: defaultRecipientAddress, Token: "USDC", Amount: amount, Nonce: nonce, ChainID: 8453, BodyHash: paymentBodyHash(text)}
+	}
+
+	// Underpayment is refused with the shortfall before the verifier
+	underpaid := paymentMismatchesTotal.Value("amount")
+	w := send("n-under", signed("n-under", "0.0005"))
+	p := decodeProblem(t, w)
+	if w.Code != http.StatusPaymentRequired || p["code"] != codeInsufficientPayment || p["required"] != "0.002" || p["paid"] != "0.0005" || p["shortfall"] != "0.0015" {
+		t.Fatalf("Expected 402 insufficient_payment with the shortfall, got %d %v", w.Code, p)
+	}
+	if payment, _ := p["paymentContext"].(map[string]interface{}); payment["amount"] != "0.002" || payment["bodyHash"] != paymentBodyHash(text) {
+		t.Errorf("Expected a fresh context for the required payment, got %v", payment)
+	}
+	if verifier.Calls() != 0 || paymentMismatchesTotal.Value("amount")-underpaid != 1 {
+		t.Error("Expected the underpayment to be refused before the verifier, and counted")
+	}
+
+	// Another recipient names the field
+	wrong := signed("n-recipient", "0.002")
+	wrong.Recipient = "0x0000000000000000000000000000000000000001"
+	w = send("n-recipient", wrong)
+	if p := decodeProblem(t, w); w.Code != http.StatusPaymentRequired || p["code"] != codePaymentMismatch || p["field"] != "recipient" {
+		t.Errorf("Expected 402 payment_mismatch on recipient, got %d %v", w.Code, p)
+	}
+
+	// Overpaying is accepted and verified against the signed amount
+	if w := send("n-over", signed("n-over", "0.005")); w.Code != http.StatusOK {
+		t.Fatalf("Expected an overpayment to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if reqs := verifier.Requests(); len(reqs) != 1 || reqs[0].Context.Amount != "0.005" {
+		t.Errorf("Expected the verifier to check the signed amount, got %+v", reqs)
+	}
+
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte(`{"amount":"-1"}`))} {
+		if w := send("n-bad", bad); decodeProblem(t, w)["code"] != codeInvalidPaymentHeader {
+			t.Errorf("Expected invalid_payment_header for %q, got %d", bad, w.Code)
+		}
+	}
+	// Base-prefixed amounts big.Rat would read as numbers aren't decimals
+	for _, amount := range []string{"0x10", "0b1", "0o7"} {
+		if w := send("n-prefixed", signed("n-prefixed", amount)); decodeProblem(t, w)["code"] != codeInvalidPaymentHeader {
+			t.Errorf("Expected invalid_payment_header for amount %q, got %d", amount, w.Code)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Payment states, from first to last. A payment only moves forward, except
+// that a reorg can send a confirmed settlement back to pending and a
+// settlement transaction can fail. The settlement states are those of the
+// settlement tracker.
+const (
+	paymentAuthorized = "authorized" // verified; the request is in flight
+	paymentPending    = "pending"    // served and queued, or the settlement isn't mined yet
+	paymentSettled    = "settled"    // the transfer is recorded; its confirmations weren't checked
+	paymentConfirmed  = txConfirmed  // the transfer is mined
+	paymentFinalized  = txFinalized  // SETTLEMENT_CONFIRMATIONS blocks deep
+	paymentFailed     = txFailed     // the settlement transaction reverted or was dropped
+	paymentRefunded   = "refunded"   // returned to the wallet
+)
+
+// paymentRPCClient looks up settlement transactions through ETH_RPC_URL.
+var paymentRPCClient = &http.Client{Timeout: 10 * time.Second}
+
+// PaymentStatus is the body of GET /v1/payments/:nonce.
+type PaymentStatus struct {
+	Nonce          string     `json:"nonce"`
+	State          string     `json:"state" doc:"authorized, pending, settled, confirmed, finalized, failed or refunded" example:"finalized"`
+	Verified       bool       `json:"verified" doc:"The gateway verified the payment's signature"`
+	Wallet         string     `json:"wallet,omitempty" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Amount         string     `json:"amount,omitempty" doc:"Amount charged, in Token" example:"0.001"`
+	Token          string     `json:"token,omitempty" example:"USDC"`
+	ChainID        int        `json:"chain_id,omitempty" example:"8453"`
+	ReceiptID      string     `json:"receipt_id,omitempty" doc:"Receipt of the served request" example:"rcpt_a1b2c3d4e5f6"`
+	ServedAt       *time.Time `json:"served_at,omitempty"`
+	TxHash         string     `json:"tx_hash,omitempty" doc:"Settlement transaction, once the payment is settled"`
+	SettledAt      *time.Time `json:"settled_at,omitempty"`
+	BlockNumber    uint64     `json:"block_number,omitempty" doc:"Block the settlement was mined in, with ETH_RPC_URL" example:"24310512"`
+	Confirmations  *uint64    `json:"confirmations,omitempty" doc:"Blocks on top of the settlement, with ETH_RPC_URL; 0 while it is unmined" example:"12"`
+	FailureReason  string     `json:"failure_reason,omitempty" doc:"Why the settlement failed: reverted or dropped" example:"dropped"`
+	RefundID       string     `json:"refund_id,omitempty" example:"rfnd_a1b2c3d4e5f6"`
+	RefundedAmount string     `json:"refunded_amount,omitempty" doc:"Amount returned to the wallet, in Token" example:"0.001"`
+}
+
+// handleGetPayment handles GET /v1/payments/:nonce: what became of a
+// payment, from the gateway's records of it. The usage ledger gives the
+// charge, any refund and the settlement tracker's states, the reconciler
+// and the escrow store payments still in flight, SETTLEMENT_FILE the
+// settlement transaction and ETH_RPC_URL its confirmations. A nonce none of
+// them know is 404.
+func handleGetPayment(c *gin.Context) {
+	ctx := c.Request.Context()
+	nonce := c.Param("nonce")
+	status := PaymentStatus{Nonce: nonce}
+	found := false
+
+	if paymentReconciler != nil {
+		if a, ok := paymentReconciler.authorization(nonce); ok {
+			status.Wallet, status.Amount = strings.ToLower(a.Wallet), a.Amount
+			status.State, status.Verified, found = paymentAuthorized, true, true
+		}
+	}
+	if state, err := currentEscrowStore().state(ctx, nonce); err != nil {
+		log.Printf("error reading the escrow state of %s: %v", nonce, err)
+	} else if state != "" {
+		status.State, status.Verified, found = paymentAuthorized, true, true
+		if state == escrowSettled {
+			status.State = paymentPending
+		}
+	}
+
+	entries, err := paymentLedgerEntries(ctx, nonce)
+	if err != nil {
+		log.Printf("error reading the ledger for payment %s: %v", nonce, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read the ledger", err.Error()))
+		return
+	}
+	var tracked *LedgerEntry
+	for i, e := range entries {
+		found = true
+		if e.Type == ledgerTypeRefund {
+			status.RefundID, status.RefundedAmount = e.RefundID, e.Amount
+			continue
+		}
+		if e.Type == ledgerTypeSettlement {
+			tracked = &entries[i]
+			continue
+		}
+		served := e.Time
+		status.Wallet, status.Amount, status.Token, status.ChainID = e.Wallet, e.Amount, e.Token, e.ChainID
+		status.ReceiptID, status.ServedAt, status.TxHash = e.ReceiptID, &served, e.TxHash
+		status.State, status.Verified = paymentPending, true
+		if e.TxHash != "" {
+			status.State, status.SettledAt = paymentSettled, &served
+		}
+	}
+
+	if tracked != nil {
+		status.TxHash, status.State, status.BlockNumber = tracked.TxHash, tracked.SettlementState, tracked.BlockNumber
+		if tracked.SettlementState == txFailed {
+			status.FailureReason = tracked.Reason
+		}
+		if status.Wallet == "" {
+			status.Wallet, status.Amount = tracked.Wallet, tracked.Amount
+		}
+	}
+	if path := os.Getenv("SETTLEMENT_FILE"); path != "" {
+		transfer, err := fileSettlements{path: path}.find(nonce)
+		if err != nil {
+			log.Printf("error reading settlements for %s: %v", nonce, err)
+		} else if transfer != nil && (tracked == nil || transfer.Time.After(tracked.Time)) {
+			// A transfer newer than the tracker's last state replaced a
+			// dropped transaction
+			found = true
+			settled := transfer.Time
+			status.TxHash, status.SettledAt, status.State = transfer.TxHash, &settled, paymentSettled
+			status.BlockNumber, status.FailureReason = 0, ""
+			if status.Wallet == "" {
+				status.Wallet, status.Amount = strings.ToLower(transfer.Wallet), transfer.Amount
+			}
+		}
+	}
+	if !found {
+		abortWithProblem(c, newProblem(404, codePaymentNotFound, "Payment not found",
+			"The gateway has no record of a payment with this nonce"))
+		return
+	}
+
+	if status.TxHash != "" && status.State != paymentFailed {
+		confirmPayment(ctx, &status)
+	}
+	if status.RefundID != "" {
+		status.State = paymentRefunded
+	}
+	if status.Token == "" {
+		status.Token, status.ChainID = getPaymentToken(), getChainID()
+	}
+	c.JSON(200, status)
+}
+
+// paymentLedgerEntries returns the ledger's charge and refunds for nonce.
+// An issued nonce bounds the search to entries written after it was.
+func paymentLedgerEntries(ctx context.Context, nonce string) ([]LedgerEntry, error) {
+	if usageLedger == nil {
+		return nil, nil
+	}
+	var q ledgerQuery
+	if expiry, ok := nonceExpiry(nonce); ok {
+		q.Since = expiry.Add(-getNonceTTL())
+	}
+	entries, err := usageLedger.Entries(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	var matched []LedgerEntry
+	for _, e := range entries {
+		if e.Nonce == nonce {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+// confirmPayment fills in the block and confirmations of the settlement
+// transaction through ETH_RPC_URL. A transaction that isn't mined yet
+// leaves the payment pending and one that reverted marks it failed; a mined
+// one is confirmed, and finalized once SETTLEMENT_CONFIRMATIONS blocks
+// deep. Without ETH_RPC_URL, or when the node can't be reached, the state
+// is left as recorded.
+func confirmPayment(ctx context.Context, status *PaymentStatus) {
+	rpcURL := os.Getenv("ETH_RPC_URL")
+	if rpcURL == "" {
+		return
+	}
+	receipt, err := ethTransactionReceipt(ctx, paymentRPCClient, rpcURL, status.TxHash)
+	if err != nil {
+		log.Printf("error looking up settlement %s: %v", status.TxHash, err)
+		return
+	}
+	var confirmations uint64
+	if receipt == nil {
+		status.State = paymentPending
+	} else {
+		head, err := ethBlockNumber(ctx, paymentRPCClient, rpcURL)
+		if err != nil {
+			log.Printf("error reading the block number: %v", err)
+			return
+		}
+		status.BlockNumber = uint64(receipt.BlockNumber)
+		if head >= status.BlockNumber {
+			confirmations = head - status.BlockNumber + 1
+		}
+		switch {
+		case receipt.Status == 0:
+			status.State, status.FailureReason = paymentFailed, txReasonReverted
+		case confirmations >= getSettlementConfirmations():
+			status.State = paymentFinalized
+		default:
+			status.State = paymentConfirmed
+		}
+	}
+	status.Confirmations = &confirmations
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// fakeChainRPC answers eth_blockNumber with head and eth_getTransactionReceipt
+// from receipts, by transaction hash.
+func fakeChainRPC(t *testing.T, head uint64, receipts map[string]string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		result := json.RawMessage("null")
+		switch req.Method {
+		case "eth_blockNumber":
+			result, _ = json.Marshal(hexutil.EncodeUint64(head))
+		case "eth_getTransactionReceipt":
+			if receipt, ok := receipts[req.Params[0]]; ok {
+				result = json.RawMessage(receipt)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("ETH_RPC_URL", srv.URL)
+}
+
+func getPayment(t *testing.T, nonce string) (int, PaymentStatus) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/payments/"+nonce, nil)
+	setupVersionedRouter().ServeHTTP(w, req)
+	var status PaymentStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	return w.Code, status
+}
+
+func TestHandleGetPayment(t *testing.T) {
+	ledger := setupTestLedger(t)
+	ctx := context.Background()
+	hourAgo := time.Now().UTC().Add(-time.Hour)
+	for _, e := range []LedgerEntry{
+		{ReceiptID: "rcpt_settled", Nonce: "n-settled", Wallet: "0xaa", Amount: "0.001", Token: "USDC", ChainID: 8453, Time: hourAgo},
+		{ReceiptID: "rcpt_pending", Nonce: "n-pending", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+		{ReceiptID: "rcpt_reverted", Nonce: "n-reverted", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+		{ReceiptID: "rcpt_refunded", Nonce: "n-refunded", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+		{Type: ledgerTypeRefund, RefundID: "rfnd_1", Nonce: "n-refunded", Wallet: "0xaa", Amount: "0.0005", Time: hourAgo},
+	} {
+		ledger.Append(ctx, e)
+	}
+	settlements := filepath.Join(t.TempDir(), "settled.jsonl")
+	var lines []byte
+	for _, s := range []SettledTransfer{
+		{Nonce: "n-settled", TxHash: "0x01", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+		{Nonce: "n-reverted", TxHash: "0x02", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+	} {
+		line, _ := json.Marshal(s)
+		lines = append(append(lines, line...), '\n')
+	}
+	os.WriteFile(settlements, lines, 0o600)
+	t.Setenv("SETTLEMENT_FILE", settlements)
+	fakeChainRPC(t, 110, map[string]string{
+		"0x01": `{"blockNumber":"0x64","blockHash":"0xb1","status":"0x1"}`,
+		"0x02": `{"blockNumber":"0x64","blockHash":"0xb1","status":"0x0"}`,
+	})
+
+	code, status := getPayment(t, "n-settled")
+	if code != http.StatusOK || status.State != paymentConfirmed || !status.Verified || status.TxHash != "0x01" ||
+		status.ReceiptID != "rcpt_settled" || status.BlockNumber != 100 || status.Confirmations == nil || *status.Confirmations != 11 {
+		t.Errorf("Expected a confirmed payment with 11 confirmations, got %d %+v", code, status)
+	}
+	t.Setenv("SETTLEMENT_CONFIRMATIONS", "10")
+	if _, status = getPayment(t, "n-settled"); status.State != paymentFinalized {
+		t.Errorf("Expected a payment 11 blocks deep finalized, got %+v", status)
+	}
+	if _, status = getPayment(t, "n-pending"); status.State != paymentPending || status.TxHash != "" || status.Confirmations != nil {
+		t.Errorf("Expected a served, unsettled payment pending, got %+v", status)
+	}
+	if _, status = getPayment(t, "n-reverted"); status.State != paymentFailed || status.FailureReason != txReasonReverted {
+		t.Errorf("Expected a reverted settlement failed, got %+v", status)
+	}
+	if _, status = getPayment(t, "n-refunded"); status.State != paymentRefunded || status.RefundID != "rfnd_1" || status.RefundedAmount != "0.0005" {
+		t.Errorf("Expected the refund reported, got %+v", status)
+	}
+
+	// Verified, and the request still in flight
+	setupTestReconciler(t, nil)
+	recordAuthorization("n-in-flight", "0xAA", "0.001")
+	if _, status = getPayment(t, "n-in-flight"); status.State != paymentAuthorized || status.Wallet != "0xaa" {
+		t.Errorf("Expected an authorized payment, got %+v", status)
+	}
+
+	if code, _ := getPayment(t, "n-unknown"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown nonce, got %d", code)
+	}
+}
+
+func TestHandleGetPayment_EscrowHeld(t *testing.T) {
+	prev := memoryEscrow
+	memoryEscrow = &memoryEscrowStore{entries: make(map[string]memoryEscrowEntry)}
+	defer func() { memoryEscrow = prev }()
+	memoryEscrow.hold(context.Background(), "n-held", time.Minute)
+
+	if _, status := getPayment(t, "n-held"); status.State != paymentAuthorized || !status.Verified {
+		t.Errorf("Expected a held payment authorized, got %+v", status)
+	}
+	memoryEscrow.settle(context.Background(), "n-held", time.Minute)
+	if _, status := getPayment(t, "n-held"); status.State != paymentPending {
+		t.Errorf("Expected a delivered escrow payment pending, got %+v", status)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// piiRedactor masks personal data in prompts sent to third-party providers.
+// It is nil unless PII_REDACTION=true.
+var piiRedactor *redactor
+
+var piiRedactionsTotal = newCounter(
+	"gateway_pii_redactions_total",
+	"Personal data values masked before a prompt left for a third-party provider, by type.",
+	"type",
+)
+
+// localProviders run on infrastructure the operator controls, so prompts
+// sent to them are not redacted.
+var localProviders = map[string]bool{"ollama": true, "mock": true}
+
+// piiRule finds one type of personal data. valid, when set, rejects
+// candidates the pattern over-matches (e.g. digit runs that fail the Luhn
+// check).
+type piiRule struct {
+	Type    string
+	Pattern *regexp.Regexp
+	valid   func(string) bool
+}
+
+// builtinPIIRules are the types PII_REDACT_TYPES can select. Cards are
+// matched before phones so a card number is never half-masked as a phone.
+var builtinPIIRules = []piiRule{
+	{Type: "email", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{Type: "card", Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhnValid},
+	{Type: "phone", Pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)[ .-]?|\b\d{2,4}[ .-])\d{3,4}[ .-]?\d{3,4}\b`), valid: plausiblePhone},
+}
+
+// redactor replaces matches of its rules with numbered placeholders such as
+// [EMAIL_1] and, when restore is set, puts the values back into the reply.
+type redactor struct {
+	rules   []piiRule
+	restore bool
+}
+
+// initRedactor builds the redactor from PII_REDACTION, PII_REDACT_TYPES
+// (default "email,phone,card"), PII_RULES_FILE and PII_RESTORE (default
+// true). LoadConfig has already validated the settings, so an error here is
+// only logged.
+func initRedactor() *redactor {
+	r, err := newRedactorFromEnv()
+	if err != nil {
+		log.Printf("Warning: Invalid PII redaction settings (%v), redaction disabled", err)
+		return nil
+	}
+	if r != nil {
+		log.Printf("PII redaction enabled (%d rules, restore %v)", len(r.rules), r.restore)
+	}
+	return r
+}
+
+func newRedactorFromEnv() (*redactor, error) {
+	if strings.ToLower(os.Getenv("PII_REDACTION")) != "true" {
+		return nil, nil
+	}
+	r := &redactor{restore: strings.ToLower(os.Getenv("PII_RESTORE")) != "false"}
+
+	types := os.Getenv("PII_REDACT_TYPES")
+	if types == "" {
+		types = "email,phone,card"
+	}
+	wanted := make(map[string]bool)
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			wanted[t] = true
+		}
+	}
+	for _, rule := range builtinPIIRules {
+		if wanted[rule.Type] {
+			r.rules = append(r.rules, rule)
+			delete(wanted, rule.Type)
+		}
+	}
+	for t := range wanted {
+		return nil, fmt.Errorf("unknown PII_REDACT_TYPES entry %q (known: email, phone, card)", t)
+	}
+
+	if path := os.Getenv("PII_RULES_FILE"); path != "" {
+		rules, err := loadPIIRules(path)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rules...)
+	}
+	return r, nil
+}
+
+// loadPIIRules reads "type=regexp" lines from path ('#' starts a comment).
+// The type names the placeholder, e.g. "account=ACC-\d{8}" masks as
+// [ACCOUNT_1].
+func loadPIIRules(path string) ([]piiRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("PII_RULES_FILE: %w", err)
+	}
+	var rules []piiRule
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, pattern, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.IndexFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && r != '_' }) >= 0 {
+			return nil, fmt.Errorf("PII_RULES_FILE line %d: expected type=regexp with a type of letters and '_'", n+1)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("PII_RULES_FILE line %d: %w", n+1, err)
+		}
+		rules = append(rules, piiRule{Type: strings.ToLower(name), Pattern: re})
+	}
+	return rules, nil
+}
+
+// Redact returns text with personal data replaced by placeholders and a
+// function that restores the values in a reply. A value that occurs more
+// than once gets the same placeholder, so the model can still tell that two
+// mentions refer to the same person or account.
+func (r *redactor) Redact(text string) (string, func(string) string) {
+	placeholders := make(map[string]string) // value -> placeholder
+	var pairs []string                      // placeholder, value, ...
+	counts := make(map[string]int)
+
+	for _, rule := range r.rules {
+		text = rule.Pattern.ReplaceAllStringFunc(text, func(value string) string {
+			if rule.valid != nil && !rule.valid(value) {
+				return value
+			}
+			if p, ok := placeholders[value]; ok {
+				return p
+			}
+			counts[rule.Type]++
+			p := fmt.Sprintf("[%s_%d]", strings.ToUpper(rule.Type), counts[rule.Type])
+			placeholders[value] = p
+			pairs = append(pairs, p, value)
+			piiRedactionsTotal.Inc(rule.Type)
+			return p
+		})
+	}
+
+	if !r.restore || len(pairs) == 0 {
+		return text, func(reply string) string { return reply }
+	}
+	restorer := strings.NewReplacer(pairs...)
+	return text, restorer.Replace
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum used by
+// payment card numbers.
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// plausiblePhone rejects matches with too few or too many digits to be a
+// phone number, such as "2023-2024 12".
+func plausiblePhone(s string) bool {
+	digits := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gateway/testsupport"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	t.Setenv("PII_REDACTION", "true")
+	t.Setenv("PII_REDACT_TYPES", "")
+	t.Setenv("PII_RULES_FILE", "")
+	t.Setenv("PII_RESTORE", "")
+	r, err := newRedactorFromEnv()
+	if err != nil || r == nil {
+		t.Fatalf("newRedactorFromEnv failed: %v", err)
+	}
+
+	text := "Contact jane.doe@example.com or +1 (415) 555-0134. Card 4111 1111 1111 1111 was charged. " +
+		"Jane.Doe@example.com is on copy; invoice 1234567890123 covers 2023-2024."
+	redacted, restore := r.Redact(text)
+
+	for _, pii := range []string{"jane.doe@example.com", "555-0134", "4111 1111 1111 1111"} {
+		if strings.Contains(redacted, pii) {
+			t.Errorf("Expected %q to be redacted: %s", pii, redacted)
+		}
+	}
+	for _, want := range []string{"[EMAIL_1]", "[EMAIL_2]", "[PHONE_1]", "[CARD_1]", "1234567890123", "2023-2024"} {
+		if !strings.Contains(redacted, want) {
+			t.Errorf("Expected %q in %s", want, redacted)
+		}
+	}
+	if got := restore("Jane ([EMAIL_1], [PHONE_1]) paid with [CARD_1]."); got != "Jane (jane.doe@example.com, +1 (415) 555-0134) paid with 4111 1111 1111 1111." {
+		t.Errorf("Unexpected restored reply %q", got)
+	}
+
+	if twice, _ := r.Redact("a@b.io and a@b.io"); twice != "[EMAIL_1] and [EMAIL_1]" {
+		t.Errorf("Expected a repeated value to reuse its placeholder, got %q", twice)
+	}
+}
+
+func TestNewRedactorFromEnv(t *testing.T) {
+	t.Setenv("PII_REDACTION", "")
+	if r, err := newRedactorFromEnv(); r != nil || err != nil {
+		t.Errorf("Expected redaction off by default, got %v, %v", r, err)
+	}
+
+	t.Setenv("PII_REDACTION", "true")
+	t.Setenv("PII_REDACT_TYPES", "email,ssn")
+	if _, err := newRedactorFromEnv(); err == nil {
+		t.Error("Expected an unknown type to be rejected")
+	}
+
+	path := filepath.Join(t.TempDir(), "pii.rules")
+	os.WriteFile(path, []byte("# account numbers\naccount=ACC-\\d{8}\n"), 0o600)
+	t.Setenv("PII_REDACT_TYPES", "email")
+	t.Setenv("PII_RULES_FILE", path)
+	t.Setenv("PII_RESTORE", "false")
+	r, err := newRedactorFromEnv()
+	if err != nil {
+		t.Fatalf("newRedactorFromEnv failed: %v", err)
+	}
+	redacted, restore := r.Redact("ACC-12345678 belongs to x@y.org, call 415 555 0134")
+	if redacted != "[ACCOUNT_1] belongs to [EMAIL_1], call 415 555 0134" {
+		t.Errorf("Unexpected redaction %q", redacted)
+	}
+	if got := restore("[ACCOUNT_1]"); got != "[ACCOUNT_1]" {
+		t.Errorf("Expected PII_RESTORE=false to leave placeholders, got %q", got)
+	}
+}
+
+func TestCallAIPrompt_RedactsForThirdPartiesOnly(t *testing.T) {
+	t.Setenv("PII_REDACTION", "true")
+	piiRedactor = initRedactor()
+	defer func() { piiRedactor = nil }()
+
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.ReplyFunc(func(req testsupport.ChatRequest) (string, error) {
+		return "Summary mentioning [EMAIL_1].", nil
+	})
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+
+	reply, err := callAIPrompt(context.Background(), "", "Write to bob@example.com")
+	if err != nil {
+		t.Fatalf("callAIPrompt failed: %v", err)
+	}
+	if sent := ai.Requests()[0].Prompt(); strings.Contains(sent, "bob@example.com") {
+		t.Errorf("Expected the email redacted before leaving, sent %q", sent)
+	}
+	if reply != "Summary mentioning bob@example.com." {
+		t.Errorf("Expected the placeholder restored, got %q", reply)
+	}
+
+	var seen string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		seen = req.Messages[0]["content"]
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": map[string]string{"content": "ok"}})
+	}))
+	defer srv.Close()
+	t.Setenv("OLLAMA_URL", srv.URL)
+	t.Setenv("AI_PROVIDER_CHAIN", "ollama")
+	if _, err := callAIPrompt(context.Background(), "", "Write to bob@example.com"); err != nil {
+		t.Fatalf("callAIPrompt with ollama failed: %v", err)
+	}
+	if !strings.Contains(seen, "bob@example.com") {
+		t.Errorf("Expected the local provider to get the original text, got %q", seen)
+	}
+}
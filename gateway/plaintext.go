@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// plainTextBody reports whether c's body is the text itself
+// (Content-Type: text/plain) rather than a JSON SummarizeRequest.
+func plainTextBody(c *gin.Context) bool {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return err == nil && mediaType == "text/plain"
+}
+
+// decodeSummarizeRequest parses a summarize body. A text/plain body is the
+// text, with model and output_language taken from the query string, so a
+// client can stream a large document without escaping it into JSON; any
+// other body is a JSON SummarizeRequest as before. Either way the request
+// carries the same text, so it is priced, bound to the payment, cached and
+// limited the same.
+func decodeSummarizeRequest(c *gin.Context, body []byte) (SummarizeRequest, error) {
+	var req SummarizeRequest
+	if !plainTextBody(c) {
+		err := json.Unmarshal(body, &req)
+		return req, err
+	}
+	_, params, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if charset := strings.ToLower(params["charset"]); charset != "" && charset != "utf-8" && charset != "us-ascii" {
+		return req, fmt.Errorf("text/plain bodies must be UTF-8, not %s", charset)
+	}
+	req.Text = string(body)
+	req.Model = c.Query("model")
+	req.OutputLanguage = c.Query("output_language")
+	return req, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/testsupport"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDecodeSummarizeRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name, contentType, query, body string
+		want                           SummarizeRequest
+		wantErr                        bool
+	}{
+		{"json", "application/json", "?model=ignored", `{"text":"a \"quoted\" text","model":"m"}`, SummarizeRequest{Text: `a "quoted" text`, Model: "m"}, false},
+		{"no content type", "", "", `{"text":"hi"}`, SummarizeRequest{Text: "hi"}, false},
+		{"plain", "text/plain", "?model=m&output_language=es", `{"text":"not json"}`, SummarizeRequest{Text: `{"text":"not json"}`, Model: "m", OutputLanguage: "es"}, false},
+		{"plain utf-8", "Text/Plain; charset=UTF-8", "", "héllo\n", SummarizeRequest{Text: "héllo\n"}, false},
+		{"plain latin-1", "text/plain; charset=iso-8859-1", "", "hello", SummarizeRequest{}, true},
+		{"bad json", "application/json", "", "hello", SummarizeRequest{}, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("POST", "/v1/ai/summarize"+tt.query, nil)
+		c.Request.Header.Set("Content-Type", tt.contentType)
+		req, err := decodeSummarizeRequest(c, []byte(tt.body))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !tt.wantErr && (req.Text != tt.want.Text || req.Model != tt.want.Model || req.OutputLanguage != tt.want.OutputLanguage) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, req)
+		}
+	}
+}
+
+func TestHandleSummarize_PlainText(t *testing.T) {
+	setupTestRedis(t)
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Reply("plain summary")
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("MAX_TEXT_CHARS", "100")
+	r := setupVersionedRouter()
+	text := "A document sent as it is, with \"quotes\" and\nnewlines."
+
+	send := func(contentType string, body io.Reader) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", "nonce-plain")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A chunked body of unknown length
+	chunked := io.MultiReader(strings.NewReader(text[:20]), strings.NewReader(text[20:]))
+	w := send("text/plain; charset=utf-8", chunked)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "plain summary") || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("Expected the plain text summarized, got %d %s: %s", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if reqs := verifier.Requests(); len(reqs) != 1 || reqs[0].Context.BodyHash != paymentBodyHash(text) {
+		t.Errorf("Expected the payment bound to the raw text, got %+v", reqs)
+	}
+
+	// The same text as JSON shares the cache entry
+	w = send("application/json", strings.NewReader(`{"text":"A document sent as it is, with \"quotes\" and\nnewlines."}`))
+	if w.Code != 200 || w.Header().Get("X-Cache") != "HIT" || ai.Calls() != 1 {
+		t.Errorf("Expected a cache hit for the JSON body, got %d %s", w.Code, w.Header().Get("X-Cache"))
+	}
+
+	// Text limits apply to the plain body
+	w = send("text/plain", strings.NewReader(strings.Repeat("x", 101)))
+	if p := decodeProblem(t, w); w.Code != http.StatusUnprocessableEntity || p["constraint"] != "max_chars" {
+		t.Errorf("Expected 422 max_chars, got %d %v", w.Code, p)
+	}
+}
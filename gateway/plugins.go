@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The plugin registry lets forks and internal deployments add their own
+// logic by dropping a file into this package whose init function registers
+// it, instead of patching handleSummarize:
+//
+//	func init() {
+//		RegisterPreVerifyHook("corp-sso", func(ctx context.Context, req *PluginRequest) error {
+//			if req.Header.Get("X-Corp-Session") == "" {
+//				return &PluginError{Status: 401, Code: "sso_required", Title: "Unauthorized", Detail: "Sign in first"}
+//			}
+//			return nil
+//		})
+//	}
+
+// AIProvider completes a single-message prompt. An empty model selects the
+// provider's own default. Providers registered with RegisterProvider can be
+// named in AI_PROVIDER_CHAIN and MODEL_ROUTES like the built-in ones.
+type AIProvider = aiProvider
+
+// PluginRequest is what hooks see of a summarize request.
+type PluginRequest struct {
+	RequestID string
+	Route     string
+	ClientIP  string
+	// Header is the request's headers; hooks must not modify it.
+	Header         http.Header
+	Text           string
+	Model          string // the model the request is priced and served with
+	OutputLanguage string
+	Tokens         int
+	// Price is the amount the payment must cover, in the payment token,
+	// before any promo code discount. A pre-verify hook may change it.
+	Price string
+	// Quote is true when the request is only being priced, for the 402
+	// challenge or POST /v1/ai/summarize/quote, and nothing is paid yet.
+	Quote bool
+}
+
+// PluginResponse is what post-response hooks see of a served summary.
+type PluginResponse struct {
+	Summary   string
+	ReceiptID string
+	Wallet    string // the payer, lowercased
+	Amount    string // what was charged, in the payment token
+	Provider  string // "" when served from the cache
+	CacheHit  bool
+	Latency   time.Duration
+}
+
+// PluginError rejects a request from a pre-verify hook with its own
+// status and problem code. Any other error rejects it with 403
+// plugin_rejected.
+type PluginError struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+}
+
+func (e *PluginError) Error() string {
+	return e.Detail
+}
+
+// PreVerifyHook runs before a payment is verified, and when a request is
+// priced. It can reject the request by returning an error, or change
+// req.Price. A changed price must depend only on the request, so that the
+// quote a client signs matches what is verified.
+type PreVerifyHook func(ctx context.Context, req *PluginRequest) error
+
+// PostResponseHook runs after a summary has been served and paid for. It
+// runs in the background, so it can't change the response.
+type PostResponseHook func(ctx context.Context, req *PluginRequest, resp *PluginResponse)
+
+type namedHook[T any] struct {
+	name string
+	hook T
+}
+
+var (
+	pluginsMu         sync.RWMutex
+	preVerifyHooks    []namedHook[PreVerifyHook]
+	postResponseHooks []namedHook[PostResponseHook]
+)
+
+// RegisterPreVerifyHook adds a hook run, in registration order, before
+// every payment verification. It is meant to be called from an init
+// function.
+func RegisterPreVerifyHook(name string, hook PreVerifyHook) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	preVerifyHooks = append(preVerifyHooks, namedHook[PreVerifyHook]{name, hook})
+}
+
+// RegisterPostResponseHook adds a hook run, in registration order, after
+// every served summary. It is meant to be called from an init function.
+func RegisterPostResponseHook(name string, hook PostResponseHook) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	postResponseHooks = append(postResponseHooks, namedHook[PostResponseHook]{name, hook})
+}
+
+// RegisterProvider makes provider available under name. It must be called
+// from an init function, before the configuration naming it is validated,
+// and panics if name is empty or already taken.
+func RegisterProvider(name string, provider AIProvider) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || strings.ContainsAny(name, ",=:") {
+		panic(fmt.Sprintf("RegisterProvider: invalid provider name %q", name))
+	}
+	if _, taken := aiProviders[name]; taken {
+		panic(fmt.Sprintf("RegisterProvider: provider %q is already registered", name))
+	}
+	aiProviders[name] = provider
+}
+
+// newPluginRequest describes c's request for the hooks.
+func newPluginRequest(c *gin.Context, req SummarizeRequest, model, language string, tokens int, price string) *PluginRequest {
+	return &PluginRequest{
+		RequestID:      c.GetString(requestIDKey),
+		Route:          c.FullPath(),
+		ClientIP:       c.ClientIP(),
+		Header:         c.Request.Header,
+		Text:           req.Text,
+		Model:          model,
+		OutputLanguage: language,
+		Tokens:         tokens,
+		Price:          price,
+	}
+}
+
+// runPreVerifyHooks runs the pre-verify hooks on req. When one rejects it,
+// or leaves a price that isn't a non-negative amount, it has answered the
+// request and returns false. The price is rounded up to the token's
+// decimals.
+func runPreVerifyHooks(c *gin.Context, req *PluginRequest) bool {
+	pluginsMu.RLock()
+	hooks := preVerifyHooks
+	pluginsMu.RUnlock()
+	if len(hooks) == 0 {
+		return true
+	}
+
+	for _, h := range hooks {
+		err := h.hook(c.Request.Context(), req)
+		if err == nil {
+			continue
+		}
+		if pe, ok := err.(*PluginError); ok {
+			status, code, title := pe.Status, pe.Code, pe.Title
+			if status == 0 {
+				status = 403
+			}
+			if code == "" {
+				code = codePluginRejected
+			}
+			if title == "" {
+				title = http.StatusText(status)
+			}
+			abortWithProblem(c, newProblem(status, code, title, pe.Detail).With("plugin", h.name))
+			return false
+		}
+		abortWithProblem(c, newProblem(403, codePluginRejected, "Forbidden", err.Error()).With("plugin", h.name))
+		return false
+	}
+
+	price, ok := new(big.Rat).SetString(req.Price)
+	if !ok || price.Sign() < 0 {
+		log.Printf("error: plugin hooks left an invalid price %q", req.Price)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to price request", ""))
+		return false
+	}
+	req.Price = formatAmount(price)
+	return true
+}
+
+// runPostResponseHooks runs the post-response hooks in the background, on
+// a context that outlives the request.
+func runPostResponseHooks(ctx context.Context, req *PluginRequest, resp *PluginResponse) {
+	pluginsMu.RLock()
+	hooks := postResponseHooks
+	pluginsMu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, h := range hooks {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						log.Printf("error: post-response hook %s panicked: %v", h.name, r)
+					}
+				}()
+				h.hook(ctx, req, resp)
+			}()
+		}
+	}()
+}
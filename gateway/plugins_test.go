@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// setupTestPlugins clears the registered hooks when the test ends.
+func setupTestPlugins(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		pluginsMu.Lock()
+		preVerifyHooks, postResponseHooks = nil, nil
+		pluginsMu.Unlock()
+	})
+}
+
+func TestPlugins_PreVerifyHooks(t *testing.T) {
+	setupTestPlugins(t)
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	r := setupVersionedRouter()
+
+	var seen []*PluginRequest
+	RegisterPreVerifyHook("corp-sso", func(ctx context.Context, req *PluginRequest) error {
+		seen = append(seen, req)
+		switch req.Header.Get("X-Corp-Session") {
+		case "":
+			return &PluginError{Status: 401, Code: "sso_required", Detail: "Sign in first"}
+		case "revoked":
+			return errors.New("session revoked")
+		}
+		return nil
+	})
+	RegisterPreVerifyHook("corp-pricing", func(ctx context.Context, req *PluginRequest) error {
+		if req.Header.Get("X-Corp-Team") == "research" {
+			req.Price = "0.0005"
+		}
+		return nil
+	})
+
+	send := func(path, nonce string, header map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(`{"text":"Plugins see the request."}`))
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("/v1/ai/summarize", "n-plugin-1", nil)
+	if p := decodeProblem(t, w); w.Code != 401 || p["code"] != "sso_required" || p["title"] != "Unauthorized" || p["plugin"] != "corp-sso" {
+		t.Fatalf("Expected the hook's 401, got %d: %v", w.Code, p)
+	}
+	w = send("/v1/ai/summarize", "n-plugin-2", map[string]string{"X-Corp-Session": "revoked"})
+	if p := decodeProblem(t, w); w.Code != 403 || p["code"] != codePluginRejected || p["detail"] != "session revoked" {
+		t.Fatalf("Expected 403 plugin_rejected, got %d: %v", w.Code, p)
+	}
+	if len(verifier.Requests()) != 0 {
+		t.Error("Expected rejected requests never to reach the verifier")
+	}
+
+	// The repriced quote is what the verifier checks
+	team := map[string]string{"X-Corp-Session": "ok", "X-Corp-Team": "research"}
+	w = send("/v1/ai/summarize/quote", "", team)
+	var quote SummarizeQuote
+	json.Unmarshal(w.Body.Bytes(), &quote)
+	if w.Code != 200 || quote.Price != "0.0005" || !seen[len(seen)-1].Quote {
+		t.Fatalf("Expected a quote at the hook's price, got %d: %s", w.Code, w.Body.String())
+	}
+	if w = send("/v1/ai/summarize", "n-plugin-3", team); w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if reqs := verifier.Requests(); len(reqs) != 1 || reqs[0].Context.Amount != "0.0005" {
+		t.Errorf("Expected the payment verified at the hook's price, got %+v", reqs)
+	}
+	last := seen[len(seen)-1]
+	if last.Quote || last.Text != "Plugins see the request." || last.Route != "/v1/ai/summarize" || last.Tokens == 0 {
+		t.Errorf("Unexpected hook request %+v", last)
+	}
+}
+
+func TestPlugins_PostResponseHook(t *testing.T) {
+	setupTestPlugins(t)
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+
+	served := make(chan *PluginResponse, 2)
+	RegisterPostResponseHook("panics", func(ctx context.Context, req *PluginRequest, resp *PluginResponse) {
+		panic("boom")
+	})
+	RegisterPostResponseHook("billing-export", func(ctx context.Context, req *PluginRequest, resp *PluginResponse) {
+		served <- resp
+	})
+
+	for i, nonce := range []string{"n-post-1", "n-post-2"} {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"Exported after serving."}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var body SummarizeResponse
+		json.Unmarshal(w.Body.Bytes(), &body)
+
+		select {
+		case resp := <-served:
+			if resp.ReceiptID != body.Receipt.Receipt.ID || resp.Summary != body.Result || resp.CacheHit != (i == 1) || resp.Amount == "" {
+				t.Errorf("Request %d: unexpected hook response %+v", i, resp)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Request %d: expected the post-response hook to run after a panicking one", i)
+		}
+	}
+}
+
+type staticProvider struct{ reply string }
+
+func (p staticProvider) Complete(ctx context.Context, model, prompt string) (string, error) {
+	return p.reply, nil
+}
+
+func TestRegisterProvider(t *testing.T) {
+	RegisterProvider(" Corp-LLM ", staticProvider{"from the corporate model"})
+	t.Cleanup(func() { delete(aiProviders, "corp-llm") })
+	t.Setenv("AI_PROVIDER_CHAIN", "corp-llm,openrouter")
+
+	if chain, err := parseProviderChain("corp-llm,openrouter"); err != nil || chain[0] != "corp-llm" {
+		t.Fatalf("Expected the registered provider accepted in the chain, got %v, %v", chain, err)
+	}
+	ctx, rec := withProviderRecorder(context.Background())
+	if reply, err := callAIPrompt(ctx, "m", "prompt"); err != nil || reply != "from the corporate model" || rec.Name() != "corp-llm" {
+		t.Errorf("Expected the registered provider to answer, got %q, %v from %q", reply, err, rec.Name())
+	}
+
+	for _, name := range []string{"corp-llm", "openrouter", "", "a,b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected registering %q to panic", name)
+				}
+			}()
+			RegisterProvider(name, staticProvider{})
+		}()
+	}
+}
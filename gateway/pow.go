@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Proof-of-work challenges read p1.<expiry>.<difficulty>.<random>.<mac>,
+// signed like payment nonces but bound to the client's IP instead of a
+// route, so any instance sharing NONCE_SECRET can check a solution
+// without storing the challenge.
+const powVersion = "p1"
+
+// Headers a client sends a solved challenge in.
+const (
+	powChallengeHeader = "X-PoW-Challenge"
+	powSolutionHeader  = "X-PoW-Solution"
+)
+
+// powSpentPrefix marks solved challenges in Redis until they expire, so
+// each solution pays for one request.
+const powSpentPrefix = "pow:spent:"
+
+var powChallengesTotal = newCounter(
+	"gateway_pow_challenges_total",
+	"Anonymous requests past POW_SOFT_REMAINING, by outcome (issued, solved, invalid, expired, spent).",
+	"outcome",
+)
+
+// powEnabled reports whether POW_CHALLENGE=true asks anonymous clients
+// near their rate limit for proof of work.
+func powEnabled() bool {
+	return strings.ToLower(os.Getenv("POW_CHALLENGE")) == "true"
+}
+
+// powSoftRemaining returns POW_SOFT_REMAINING (default 2): once an
+// anonymous client's bucket has this many tokens or fewer left, each
+// request must carry a solved challenge.
+func powSoftRemaining() int {
+	return getEnvAsInt("POW_SOFT_REMAINING", 2)
+}
+
+// powDifficulty returns POW_DIFFICULTY (default 18), the leading zero bits
+// a solution's hash needs. Each bit doubles the expected work.
+func powDifficulty() int {
+	return getEnvAsInt("POW_DIFFICULTY", 18)
+}
+
+// getPowChallengeTTL returns POW_CHALLENGE_TTL_SECONDS (default 120), how
+// long a challenge can be solved and sent.
+func getPowChallengeTTL() time.Duration {
+	return time.Duration(getEnvAsInt("POW_CHALLENGE_TTL_SECONDS", 120)) * time.Second
+}
+
+// issuePowChallenge returns a challenge for ip at the current difficulty
+// and when it expires.
+func issuePowChallenge(ip string) (string, int, time.Time) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	random := base64.RawURLEncoding.EncodeToString(b)
+	difficulty := powDifficulty()
+	expiresAt := time.Now().Add(getPowChallengeTTL()).Truncate(time.Second)
+	expiry, bitsNeeded := strconv.FormatInt(expiresAt.Unix(), 10), strconv.Itoa(difficulty)
+	return strings.Join([]string{powVersion, expiry, bitsNeeded, random, nonceMAC(powVersion, ip, expiry, bitsNeeded, random)}, "."), difficulty, expiresAt
+}
+
+// checkPowSolution returns why solution doesn't solve challenge for ip at
+// now ("malformed", "invalid", "expired"), or "" with the challenge's
+// expiry when it does. It doesn't check whether the solution was used.
+func checkPowSolution(challenge, solution, ip string, now time.Time) (string, time.Time) {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 5 || parts[0] != powVersion || solution == "" || len(solution) > 64 {
+		return "malformed", time.Time{}
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "malformed", time.Time{}
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil || difficulty < 1 || difficulty > 256 {
+		return "malformed", time.Time{}
+	}
+	if !hmac.Equal([]byte(parts[4]), []byte(nonceMAC(powVersion, ip, parts[1:4]...))) {
+		return "invalid", time.Time{}
+	}
+	if now.Unix() >= expiry {
+		return "expired", time.Time{}
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) < difficulty {
+		return "invalid", time.Time{}
+	}
+	return "", time.Unix(expiry, 0)
+}
+
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// solvePowChallenge finds a solution for challenge by counting up; clients
+// do the same. It is used by tests and documents the algorithm.
+func solvePowChallenge(challenge string, difficulty int) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) >= difficulty {
+			return solution
+		}
+	}
+}
+
+// spentSet remembers single-use values, such as solved challenges, until
+// they expire: in Redis under prefix when it is configured, so a value
+// can't be replayed on another instance, and in memory otherwise.
+type spentSet struct {
+	prefix string
+	mu     sync.Mutex
+	spent  map[string]time.Time
+}
+
+func newSpentSet(prefix string) *spentSet {
+	return &spentSet{prefix: prefix, spent: make(map[string]time.Time)}
+}
+
+var powSpent = newSpentSet(powSpentPrefix)
+
+// spend marks challenge as used and reports whether it wasn't already. If
+// Redis fails the value is accepted: for a solution the rate limit still
+// holds, and whatever else it pays for needs Redis too.
+func (s *spentSet) spend(ctx context.Context, challenge string, expiresAt time.Time) bool {
+	if redisClient != nil {
+		ok, err := redisClient.SetNX(ctx, s.prefix+challenge, 1, time.Until(expiresAt)).Result()
+		if err != nil {
+			log.Printf("error recording %s%s as spent: %v", s.prefix, challenge, err)
+			return true
+		}
+		return ok
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, exp := range s.spent {
+		if now.After(exp) {
+			delete(s.spent, c)
+		}
+	}
+	if _, ok := s.spent[challenge]; ok {
+		return false
+	}
+	s.spent[challenge] = expiresAt
+	return true
+}
+
+// requireProofOfWork lets an anonymous request through while its bucket
+// has more than POW_SOFT_REMAINING tokens left, or when it carries an
+// unused solution to a challenge issued to its IP. Otherwise it answers
+// 428 proof_of_work_required with a fresh challenge and returns false;
+// no token is taken, and the hard 429 still applies once the bucket is
+// empty.
+func requireProofOfWork(c *gin.Context, limiter RateLimiter, key string) bool {
+	if limiter.GetRemaining(key) > powSoftRemaining() {
+		return true
+	}
+	ip := c.ClientIP()
+	reason := "missing"
+	if challenge := c.GetHeader(powChallengeHeader); challenge != "" {
+		var expiresAt time.Time
+		reason, expiresAt = checkPowSolution(challenge, c.GetHeader(powSolutionHeader), ip, time.Now())
+		if reason == "" && !powSpent.spend(c.Request.Context(), challenge, expiresAt) {
+			reason = "spent"
+		}
+		if reason == "" {
+			powChallengesTotal.Inc("solved")
+			return true
+		}
+		powChallengesTotal.Inc(reason)
+	}
+
+	challenge, difficulty, expiresAt := issuePowChallenge(ip)
+	powChallengesTotal.Inc("issued")
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(limiter.GetRemaining(key)))
+	abortWithProblem(c, newProblem(428, codeProofOfWorkRequired, "Proof of Work Required",
+		"Too many unauthenticated requests; solve the challenge and send it with the next request, or sign a payment").
+		With("reason", reason).
+		With("challenge", challenge).
+		With("difficulty", difficulty).
+		With("algorithm", "sha256").
+		With("expires_at", expiresAt.UTC()))
+	return false
+}
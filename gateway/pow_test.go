@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckPowSolution(t *testing.T) {
+	t.Setenv("POW_DIFFICULTY", "8")
+	now := time.Now()
+	challenge, difficulty, expiresAt := issuePowChallenge("203.0.113.7")
+	if difficulty != 8 || !expiresAt.After(now) {
+		t.Fatalf("Unexpected challenge difficulty %d, expiry %v", difficulty, expiresAt)
+	}
+	solution := solvePowChallenge(challenge, difficulty)
+
+	if reason, exp := checkPowSolution(challenge, solution, "203.0.113.7", now); reason != "" || !exp.Equal(expiresAt) {
+		t.Errorf("Expected the solution accepted, got %q", reason)
+	}
+	wrong := "x"
+	for leadingZeroBits(sha256.Sum256([]byte(challenge+":"+wrong))) >= difficulty {
+		wrong += "x"
+	}
+	tests := []struct {
+		name, challenge, solution, ip string
+		now                           time.Time
+		reason                        string
+	}{
+		{"other IP", challenge, solution, "203.0.113.8", now, "invalid"},
+		{"wrong solution", challenge, wrong, "203.0.113.7", now, "invalid"},
+		{"expired", challenge, solution, "203.0.113.7", expiresAt, "expired"},
+		{"no solution", challenge, "", "203.0.113.7", now, "malformed"},
+		{"not a challenge", "n1.1.2.3", solution, "203.0.113.7", now, "malformed"},
+	}
+	for _, tt := range tests {
+		if reason, _ := checkPowSolution(tt.challenge, tt.solution, tt.ip, tt.now); reason != tt.reason {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.reason, reason)
+		}
+	}
+}
+
+func TestRateLimitMiddleware_ProofOfWork(t *testing.T) {
+	t.Setenv("POW_CHALLENGE", "true")
+	t.Setenv("POW_DIFFICULTY", "8")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "1")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "4")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RateLimitMiddleware(initRateLimiters()))
+	r.GET("/test", func(c *gin.Context) { c.Status(200) })
+
+	send := func(challenge, solution string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "198.51.100.9:4000"
+		if challenge != "" {
+			req.Header.Set(powChallengeHeader, challenge)
+			req.Header.Set(powSolutionHeader, solution)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	challenged := func(w *httptest.ResponseRecorder, reason string) (string, string) {
+		t.Helper()
+		p := decodeProblem(t, w)
+		if w.Code != http.StatusPreconditionRequired || p["code"] != codeProofOfWorkRequired || p["reason"] != reason {
+			t.Fatalf("Expected 428 proof_of_work_required (%s), got %d %v", reason, w.Code, p)
+		}
+		challenge := p["challenge"].(string)
+		return challenge, solvePowChallenge(challenge, int(p["difficulty"].(float64)))
+	}
+
+	// Above POW_SOFT_REMAINING (2) requests need no work
+	for i := 0; i < 2; i++ {
+		if w := send("", ""); w.Code != 200 {
+			t.Fatalf("Expected request %d through, got %d", i+1, w.Code)
+		}
+	}
+	challenge, solution := challenged(send("", ""), "missing")
+	if w := send(challenge, solution); w.Code != 200 {
+		t.Fatalf("Expected the solved request through, got %d", w.Code)
+	}
+	challenge, solution = challenged(send(challenge, solution), "spent")
+	if w := send(challenge, solution); w.Code != 200 {
+		t.Fatalf("Expected the second solved request through, got %d", w.Code)
+	}
+	// Work doesn't lift the hard limit
+	challenge, solution = challenged(send("", ""), "missing")
+	if w := send(challenge, solution); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the hard 429 once the bucket is empty, got %d", w.Code)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// prepaidHeader names the wallet whose prepaid balance pays for a request,
+// in place of a payment signature the verifier checks. The wallet signs
+// prepaidPaymentMessage in X-Wallet-Signature instead.
+const prepaidHeader = "X-402-Prepaid"
+
+// Prepaid balances live in Redis when REDIS_URL is set, so every instance
+// spends the same balance: one key per wallet and a set of the credited
+// transfers.
+const (
+	prepaidKeyPrefix  = "prepaid:"
+	prepaidCreditsKey = "prepaid:credits"
+	// prepaidSpentPrefix marks the nonces prepaid payments used until they
+	// expire, so each signature pays for one request.
+	prepaidSpentPrefix = "prepaid:spent:"
+)
+
+var prepaidSpent = newSpentSet(prepaidSpentPrefix)
+
+var prepaidPaymentsTotal = newCounter(
+	"gateway_prepaid_payments_total",
+	"Requests paid from a prepaid balance, by outcome (accepted, rejected, reverted).",
+	"outcome",
+)
+
+// PrepaidBalance is the body of GET /v1/prepaid/:wallet.
+type PrepaidBalance struct {
+	Wallet         string `json:"wallet" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Balance        string `json:"balance" doc:"Amount left to spend, in Token" example:"4.958"`
+	Token          string `json:"token" example:"USDC"`
+	ChainID        int    `json:"chain_id" example:"8453"`
+	DepositAddress string `json:"deposit_address" doc:"Address to transfer Token to, with Memo appended to the transfer's calldata"`
+	Memo           string `json:"memo" doc:"Memo that credits a transfer, from any sender, to this wallet" example:"paygate:0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+}
+
+// prepaidEnabled reports whether PREPAID_DEPOSITS=true credits transfers
+// to the recipient to a prepaid balance that requests can be paid from.
+func prepaidEnabled() bool {
+	return strings.ToLower(os.Getenv("PREPAID_DEPOSITS")) == "true"
+}
+
+// getPrepaidAuthMaxAge returns PREPAID_AUTH_MAX_AGE_SECONDS (default 3600),
+// how long one signature of prepaidChallenge reads the balance.
+func getPrepaidAuthMaxAge() time.Duration {
+	return time.Duration(getEnvAsInt("PREPAID_AUTH_MAX_AGE_SECONDS", 3600)) * time.Second
+}
+
+// prepaidChallenge is the message a wallet signs (EIP-191 personal_sign) to
+// read its prepaid balance.
+func prepaidChallenge(wallet string, timestamp int64) string {
+	return fmt.Sprintf("MicroAI-Paygate prepaid access for %s at %d", strings.ToLower(wallet), timestamp)
+}
+
+// prepaidPaymentMessage is the message a wallet signs to pay for one
+// request from its prepaid balance. It names a nonce the gateway issued,
+// which is spent on first use, and the keccak256 of the request body, so
+// the signature can't be replayed or pay for a different request.
+func prepaidPaymentMessage(wallet, nonce, bodyHash string) string {
+	return fmt.Sprintf("MicroAI-Paygate prepaid payment from %s nonce %s body %s", strings.ToLower(wallet), nonce, bodyHash)
+}
+
+// errPrepaidInsufficient is returned by prepaidStore.debit when the balance
+// doesn't cover the amount.
+var errPrepaidInsufficient = errors.New("prepaid balance too low")
+
+// prepaidStore keeps each wallet's prepaid balance, as a decimal in Token.
+type prepaidStore interface {
+	// balance returns the wallet's balance, "0" when it has none.
+	balance(ctx context.Context, wallet string) (string, error)
+	// credit adds amount to the wallet's balance once per ref, and reports
+	// whether it did.
+	credit(ctx context.Context, wallet, amount, ref string) (bool, error)
+	// debit takes amount from the wallet's balance, or returns
+	// errPrepaidInsufficient with the balance unchanged.
+	debit(ctx context.Context, wallet, amount string) (string, error)
+}
+
+func currentPrepaidStore() prepaidStore {
+	if redisClient != nil {
+		return redisPrepaidStore{client: redisClient}
+	}
+	return memoryPrepaid
+}
+
+// addAmount returns balance plus delta, or an error when the result would
+// be negative.
+func addAmount(balance, delta string) (string, error) {
+	b, ok := new(big.Rat).SetString(balance)
+	if !ok {
+		return "", fmt.Errorf("invalid balance %q", balance)
+	}
+	d, ok := new(big.Rat).SetString(delta)
+	if !ok {
+		return "", fmt.Errorf("invalid amount %q", delta)
+	}
+	if b.Add(b, d).Sign() < 0 {
+		return "", errPrepaidInsufficient
+	}
+	return formatAmount(b), nil
+}
+
+type memoryPrepaidStore struct {
+	mu       sync.Mutex
+	balances map[string]string
+	credited map[string]bool
+}
+
+var memoryPrepaid = &memoryPrepaidStore{balances: make(map[string]string), credited: make(map[string]bool)}
+
+func (s *memoryPrepaidStore) balance(_ context.Context, wallet string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.balances[strings.ToLower(wallet)]; ok {
+		return b, nil
+	}
+	return "0", nil
+}
+
+func (s *memoryPrepaidStore) credit(_ context.Context, wallet, amount, ref string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.credited[ref] {
+		return false, nil
+	}
+	wallet = strings.ToLower(wallet)
+	b, ok := s.balances[wallet]
+	if !ok {
+		b = "0"
+	}
+	updated, err := addAmount(b, amount)
+	if err != nil {
+		return false, err
+	}
+	s.balances[wallet], s.credited[ref] = updated, true
+	return true, nil
+}
+
+func (s *memoryPrepaidStore) debit(_ context.Context, wallet, amount string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wallet = strings.ToLower(wallet)
+	b, ok := s.balances[wallet]
+	if !ok {
+		b = "0"
+	}
+	updated, err := addAmount(b, "-"+amount)
+	if err != nil {
+		return b, err
+	}
+	s.balances[wallet] = updated
+	return updated, nil
+}
+
+type redisPrepaidStore struct {
+	client *redis.Client
+}
+
+func (s redisPrepaidStore) balance(ctx context.Context, wallet string) (string, error) {
+	b, err := s.client.Get(ctx, prepaidKeyPrefix+strings.ToLower(wallet)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "0", nil
+	}
+	return b, err
+}
+
+// update applies fn to the wallet's balance, retrying when another instance
+// changed it in between, so two requests can never spend the same balance.
+// A non-empty ref is recorded as credited in the same transaction, and
+// reported false when it already was.
+func (s redisPrepaidStore) update(ctx context.Context, wallet, ref string, fn func(string) (string, error)) (string, bool, error) {
+	key := prepaidKeyPrefix + strings.ToLower(wallet)
+	for attempt := 0; attempt < 10; attempt++ {
+		var updated string
+		applied := false
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			if ref != "" {
+				if seen, err := tx.SIsMember(ctx, prepaidCreditsKey, ref).Result(); err != nil || seen {
+					return err
+				}
+			}
+			b, err := tx.Get(ctx, key).Result()
+			if errors.Is(err, redis.Nil) {
+				b = "0"
+			} else if err != nil {
+				return err
+			}
+			if updated, err = fn(b); err != nil {
+				updated = b
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, updated, 0)
+				if ref != "" {
+					pipe.SAdd(ctx, prepaidCreditsKey, ref)
+				}
+				return nil
+			})
+			applied = err == nil
+			return err
+		}, key, prepaidCreditsKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return updated, applied, err
+		}
+	}
+	return "", false, errors.New("prepaid balance update kept conflicting")
+}
+
+func (s redisPrepaidStore) credit(ctx context.Context, wallet, amount, ref string) (bool, error) {
+	_, applied, err := s.update(ctx, wallet, ref, func(b string) (string, error) { return addAmount(b, amount) })
+	return applied, err
+}
+
+func (s redisPrepaidStore) debit(ctx context.Context, wallet, amount string) (string, error) {
+	b, _, err := s.update(ctx, wallet, "", func(b string) (string, error) { return addAmount(b, "-"+amount) })
+	return b, err
+}
+
+// prepaidCharge is the price of one request taken from a prepaid balance.
+type prepaidCharge struct {
+	wallet string
+	amount string
+	nonce  string // identifies the payment in receipts and the ledger
+}
+
+// chargePrepaid takes the price from the balance of the wallet named by
+// X-402-Prepaid, once the wallet's signature of prepaidPaymentMessage for
+// paymentCtx's nonce and bodyHash checks out and the nonce is unused. No
+// verifier call is made. It has aborted the request when it returns nil.
+func chargePrepaid(c *gin.Context, paymentCtx PaymentContext, bodyHash string) *prepaidCharge {
+	reject := func(p *Problem) *prepaidCharge {
+		prepaidPaymentsTotal.Inc("rejected")
+		abortWithProblem(c, p)
+		return nil
+	}
+	if !prepaidEnabled() {
+		return reject(newProblem(400, codeInvalidPaymentHeader, "Bad Request", "Prepaid balances are not enabled (PREPAID_DEPOSITS)"))
+	}
+	wallet := strings.ToLower(c.GetHeader(prepaidHeader))
+	if !strings.HasPrefix(wallet, "0x") || !common.IsHexAddress(wallet) {
+		return reject(newProblem(400, codeInvalidPaymentHeader, "Bad Request", prepaidHeader+" must be a 0x-prefixed wallet address"))
+	}
+	nonce := paymentCtx.Nonce
+	if reason := checkNonce(nonce, paymentRoute(c), time.Now()); reason != "" {
+		prepaidPaymentsTotal.Inc("rejected")
+		abortInvalidNonce(c, reason)
+		return nil
+	}
+	message := prepaidPaymentMessage(wallet, nonce, bodyHash)
+	signer, err := recoverPersonalSigner(message, c.GetHeader("X-Wallet-Signature"))
+	if err != nil || !strings.EqualFold(signer, wallet) {
+		return reject(newProblem(401, codeUnauthorized, "Unauthorized",
+			"X-Wallet-Signature must be the wallet's personal_sign of the prepaid payment message").
+			With("message", message))
+	}
+	expiresAt, _ := nonceExpiry(nonce)
+	if !prepaidSpent.spend(c.Request.Context(), nonce, expiresAt) {
+		prepaidPaymentsTotal.Inc("rejected")
+		abortInvalidNonce(c, "spent")
+		return nil
+	}
+
+	balance, err := currentPrepaidStore().debit(c.Request.Context(), wallet, paymentCtx.Amount)
+	if errors.Is(err, errPrepaidInsufficient) {
+		return reject(newProblem(402, codePrepaidInsufficient, "Payment Required", "The prepaid balance does not cover this request").
+			With("balance", balance).With("required", paymentCtx.Amount))
+	}
+	if err != nil {
+		log.Printf("error charging the prepaid balance of %s: %v", wallet, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to charge the prepaid balance", ""))
+		return nil
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	prepaidPaymentsTotal.Inc("accepted")
+	c.Header("X-Prepaid-Balance", balance)
+	return &prepaidCharge{wallet: wallet, amount: paymentCtx.Amount, nonce: "prepaid:" + hex.EncodeToString(b)}
+}
+
+// revertPrepaidChargeIfFailed gives the price back when the request failed.
+// Run it deferred after chargePrepaid.
+func revertPrepaidChargeIfFailed(c *gin.Context, charge *prepaidCharge) {
+	if c.Writer.Status() == 200 && c.Request.Context().Err() == nil {
+		return
+	}
+	if _, err := currentPrepaidStore().credit(context.WithoutCancel(c.Request.Context()), charge.wallet, charge.amount, charge.nonce); err != nil {
+		log.Printf("error returning %s to the prepaid balance of %s: %v", charge.amount, charge.wallet, err)
+		return
+	}
+	prepaidPaymentsTotal.Inc("reverted")
+}
+
+// handleGetPrepaidBalance handles GET /v1/prepaid/:wallet, the wallet's
+// balance and how to top it up. It needs the wallet's signature of
+// prepaidChallenge, or the admin token.
+func handleGetPrepaidBalance(c *gin.Context) {
+	if !prepaidEnabled() {
+		abortWithProblem(c, newProblem(404, codePrepaidDisabled, "Not Found", "Prepaid balances are not enabled (PREPAID_DEPOSITS)"))
+		return
+	}
+	wallet, ok := resolveWalletParam(c, c.Param("wallet"))
+	if !ok {
+		return
+	}
+	if !isAdminRequest(c) && !checkSignedChallenge(c, wallet, prepaidChallenge, getPrepaidAuthMaxAge(), ", or use the admin token") {
+		return
+	}
+	balance, err := currentPrepaidStore().balance(c.Request.Context(), wallet)
+	if err != nil {
+		log.Printf("error reading the prepaid balance of %s: %v", wallet, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read the prepaid balance", ""))
+		return
+	}
+	c.JSON(200, PrepaidBalance{
+		Wallet: wallet, Balance: balance, Token: getPaymentToken(), ChainID: getChainID(),
+		DepositAddress: getRecipientAddress(), Memo: getPrepaidMemo() + ":" + wallet,
+	})
+}
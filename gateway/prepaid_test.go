@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testDepositToken = "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913"
+
+// setupPrepaid turns prepaid balances on with an empty in-memory store.
+func setupPrepaid(t *testing.T) {
+	t.Setenv("PREPAID_DEPOSITS", "true")
+	prev := memoryPrepaid
+	memoryPrepaid = &memoryPrepaidStore{balances: make(map[string]string), credited: make(map[string]bool)}
+	t.Cleanup(func() { memoryPrepaid = prev })
+}
+
+// transferCalldata is transfer(to, amount) with memo appended.
+func transferCalldata(to string, amount int64, memo string) string {
+	data := append([]byte{}, transferSelector...)
+	data = append(data, common.LeftPadBytes(common.HexToAddress(to).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(amount).Bytes(), 32)...)
+	return hexutil.Encode(append(data, memo...))
+}
+
+func TestTransferMemo(t *testing.T) {
+	recipient := "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"
+	input, _ := hexutil.Decode(transferCalldata(recipient, 1, "paygate"))
+	if memo := transferMemo(input); memo != "paygate" {
+		t.Errorf("Expected the appended memo, got %q", memo)
+	}
+	input, _ = hexutil.Decode(transferCalldata(recipient, 1, ""))
+	if memo := transferMemo(input); memo != "" {
+		t.Errorf("Expected no memo on a plain transfer, got %q", memo)
+	}
+
+	sender := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	other := "0x00000000000000000000000000000000000000aa"
+	tests := []struct {
+		memo, want string
+		ok         bool
+	}{
+		{"paygate", strings.ToLower(sender), true},
+		{"paygate:" + other, other, true},
+		{"paygate:not-a-wallet", "", false},
+		{"something else", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := depositWallet(tt.memo, "paygate", sender); got != tt.want || ok != tt.ok {
+			t.Errorf("depositWallet(%q) = %q, %v; want %q, %v", tt.memo, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDepositWatcher_CreditsMemoTransfers(t *testing.T) {
+	setupPrepaid(t)
+	recipient := getRecipientAddress()
+	sender := "0x742d35cc6634c0532925a3b844bc454e4438f44e"
+	other := "0x00000000000000000000000000000000000000aa"
+	txs := map[string]string{
+		"0x01": transferCalldata(recipient, 5_000_000, "paygate"),
+		"0x02": transferCalldata(recipient, 1_000_000, ""), // a settled payment
+		"0x03": transferCalldata(recipient, 250_000, "paygate:"+other),
+	}
+	var ranges [][2]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var result interface{}
+		switch req.Method {
+		case "eth_blockNumber":
+			result = "0x6e" // 110
+		case "eth_getLogs":
+			var filter struct {
+				FromBlock string `json:"fromBlock"`
+				ToBlock   string `json:"toBlock"`
+			}
+			json.Unmarshal(req.Params[0], &filter)
+			ranges = append(ranges, [2]string{filter.FromBlock, filter.ToBlock})
+			var logs []map[string]interface{}
+			for _, tx := range []struct {
+				hash   string
+				amount int64
+			}{{"0x01", 5_000_000}, {"0x02", 1_000_000}, {"0x03", 250_000}} {
+				logs = append(logs, map[string]interface{}{
+					"transactionHash": tx.hash, "logIndex": "0x0",
+					"topics": []string{transferTopic, common.BytesToHash(common.HexToAddress(sender).Bytes()).Hex(), common.BytesToHash(common.HexToAddress(recipient).Bytes()).Hex()},
+					"data":   hexutil.Encode(common.LeftPadBytes(big.NewInt(tx.amount).Bytes(), 32)),
+				})
+			}
+			result = logs
+		case "eth_getTransactionByHash":
+			var hash string
+			json.Unmarshal(req.Params[0], &hash)
+			result = map[string]string{"hash": hash, "input": txs[hash]}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	defer srv.Close()
+	t.Setenv("ETH_RPC_URL", srv.URL)
+	t.Setenv("PREPAID_START_BLOCK", "100")
+	t.Setenv("PREPAID_CONFIRMATIONS", "5")
+
+	w := initDepositWatcher()
+	if w == nil {
+		t.Fatal("Expected a watcher for Base USDC")
+	}
+	if err := w.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0] != [2]string{"0x64", "0x69"} || w.next != 106 {
+		t.Errorf("Expected blocks 100-105 scanned, got %v, next %d", ranges, w.next)
+	}
+	store := currentPrepaidStore()
+	if b, _ := store.balance(context.Background(), sender); b != "5" {
+		t.Errorf("Expected the sender credited 5, got %s", b)
+	}
+	if b, _ := store.balance(context.Background(), other); b != "0.25" {
+		t.Errorf("Expected the memo's wallet credited 0.25, got %s", b)
+	}
+
+	// Scanning the same blocks again credits nothing twice
+	w.next = 100
+	w.poll(context.Background())
+	if b, _ := store.balance(context.Background(), sender); b != "5" {
+		t.Errorf("Expected a rescanned deposit credited once, got %s", b)
+	}
+}
+
+// prepaidPayment returns the headers that pay for body from wallet's
+// prepaid balance, signed by key with a freshly issued nonce.
+func prepaidPayment(t *testing.T, key *ecdsa.PrivateKey, wallet, body string) http.Header {
+	t.Helper()
+	nonce, _ := issueNonce(summarizeRoute, 100)
+	header := http.Header{}
+	header.Set(prepaidHeader, wallet)
+	header.Set("X-402-Nonce", nonce)
+	header.Set("X-Wallet-Signature", personalSign(t, key, prepaidPaymentMessage(wallet, nonce, paymentBodyHash(body))))
+	return header
+}
+
+// sendPrepaid posts body to summarize with header.
+func sendPrepaid(r http.Handler, header http.Header, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(body))
+	req.Header = header.Clone()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// sendPrepaidPayment posts a summarize request paid from key's prepaid
+// balance.
+func sendPrepaidPayment(t *testing.T, r http.Handler, key *ecdsa.PrivateKey, text string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := `{"text":"` + text + `"}`
+	return sendPrepaid(r, prepaidPayment(t, key, crypto.PubkeyToAddress(key.PublicKey).Hex(), body), body)
+}
+
+func TestHandleSummarize_PaidFromPrepaidBalance(t *testing.T) {
+	ai, _, queued := setupSettlementTest(t, settlementImmediate)
+	setupPrepaid(t)
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	ai.Reply("a summary")
+	r := setupVersionedRouter()
+	key := mustGenerateKey(t)
+	wallet := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	store := currentPrepaidStore()
+	store.credit(context.Background(), wallet, "0.0015", "0xdeposit:0")
+
+	body := `{"text":"paid from a prepaid balance"}`
+	header := prepaidPayment(t, key, wallet, body)
+	w := sendPrepaid(r, header, body)
+	if w.Code != http.StatusOK || w.Header().Get("X-Prepaid-Balance") != "0.0005" {
+		t.Fatalf("Expected 200 with 0.0005 left, got %d %q: %s", w.Code, w.Header().Get("X-Prepaid-Balance"), w.Body.String())
+	}
+	if len(queued()) != 0 {
+		t.Error("Expected nothing queued for settlement: the deposit was the settlement")
+	}
+
+	// The signature pays for one request: a replay is refused
+	w = sendPrepaid(r, header, body)
+	if p := decodeProblem(t, w); w.Code != http.StatusForbidden || p["reason"] != "spent" {
+		t.Errorf("Expected 403 for a replayed signature, got %d %v", w.Code, p)
+	}
+	if b, _ := store.balance(context.Background(), wallet); b != "0.0005" {
+		t.Errorf("Expected the replay not charged, got %s", b)
+	}
+	// Nor does it pay for another body
+	header = prepaidPayment(t, key, wallet, body)
+	if w := sendPrepaid(r, header, `{"text":"a different text"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a signature over another body, got %d", w.Code)
+	}
+	// Without a nonce the client gets the payment challenge
+	header.Del("X-402-Nonce")
+	if w := sendPrepaid(r, header, body); w.Code != http.StatusPaymentRequired || decodeProblem(t, w)["paymentContext"] == nil {
+		t.Errorf("Expected 402 with a payment context, got %d", w.Code)
+	}
+
+	w = sendPrepaidPayment(t, r, key, "paid from a prepaid balance")
+	if p := decodeProblem(t, w); w.Code != http.StatusPaymentRequired || p["code"] != codePrepaidInsufficient || p["balance"] != "0.0005" {
+		t.Errorf("Expected 402 prepaid_insufficient, got %d %v", w.Code, p)
+	}
+
+	// Another wallet's signature doesn't spend the balance
+	body = `{"text":"not my balance"}`
+	if w := sendPrepaid(r, prepaidPayment(t, mustGenerateKey(t), wallet, body), body); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for another wallet's signature, got %d", w.Code)
+	}
+
+	// A failed request gives the price back
+	store.credit(context.Background(), wallet, "0.0005", "0xdeposit2:0")
+	ai.FailWith(500, `{"error":{"message":"boom"}}`)
+	if w := sendPrepaidPayment(t, r, key, "paid from a prepaid balance"); w.Code == http.StatusOK {
+		t.Fatal("Expected the AI failure to fail the request")
+	}
+	if b, _ := store.balance(context.Background(), wallet); b != "0.001" {
+		t.Errorf("Expected the failed request's charge returned, got %s", b)
+	}
+	// Invalid text is refused before the balance is touched
+	if w := sendPrepaidPayment(t, r, key, "   "); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for whitespace-only text, got %d", w.Code)
+	}
+	if b, _ := store.balance(context.Background(), wallet); b != "0.001" {
+		t.Errorf("Expected the invalid request not to be charged, got %s", b)
+	}
+}
+
+func TestHandleGetPrepaidBalance(t *testing.T) {
+	setupPrepaid(t)
+	r := setupVersionedRouter()
+	key := mustGenerateKey(t)
+	wallet := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	currentPrepaidStore().credit(context.Background(), wallet, "2", "0xdeposit:0")
+
+	get := func(sign bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/v1/prepaid/"+wallet, nil)
+		if sign {
+			now := time.Now().Unix()
+			req.Header.Set("X-Wallet-Signature", personalSign(t, key, prepaidChallenge(wallet, now)))
+			req.Header.Set("X-Wallet-Timestamp", strconv.FormatInt(now, 10))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := get(false); w.Code != http.StatusUnauthorized || !strings.Contains(decodeProblem(t, w)["challenge"].(string), "prepaid access") {
+		t.Fatalf("Expected 401 with a prepaid challenge, got %d", w.Code)
+	}
+	w := get(true)
+	var balance PrepaidBalance
+	json.Unmarshal(w.Body.Bytes(), &balance)
+	if w.Code != http.StatusOK || balance.Balance != "2" || balance.Memo != "paygate:"+strings.ToLower(wallet) {
+		t.Errorf("Expected a balance of 2 with the wallet's memo, got %d %+v", w.Code, balance)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/redis/go-redis/v9"
+)
+
+// prepaidCursorKey keeps the next block to scan in Redis, so a restarted
+// gateway picks up where it left off.
+const prepaidCursorKey = "prepaid:watcher:next"
+
+// maxLogRange caps the blocks asked for in one eth_getLogs call; public
+// nodes refuse larger ranges.
+const maxLogRange = 2000
+
+// transferTopic is the ERC-20 Transfer(address,address,uint256) event.
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)")).Hex()
+
+// transferSelector starts the calldata of ERC-20 transfer(address,uint256).
+var transferSelector = crypto.Keccak256([]byte("transfer(address,uint256)"))[:4]
+
+var prepaidDepositsTotal = newCounter(
+	"gateway_prepaid_deposits_total",
+	"Token transfers to the recipient seen by the deposit watcher, by outcome (credited, duplicate, no_memo, error).",
+	"outcome",
+)
+
+// depositWatcher credits prepaid balances from token transfers to the
+// recipient whose calldata ends in the deposit memo. It polls eth_getLogs
+// over ETH_RPC_URL, a confirmed block range at a time.
+type depositWatcher struct {
+	rpcURL        string
+	token         string // token contract
+	recipient     string
+	memo          string
+	confirmations uint64
+	interval      time.Duration
+	httpClient    *http.Client
+	next          uint64 // first block not scanned yet; 0 before the first poll
+}
+
+// prepaidWatcher is nil unless PREPAID_DEPOSITS=true.
+var prepaidWatcher *depositWatcher
+
+// getPrepaidMemo returns PREPAID_MEMO (default "paygate"), what a transfer's
+// calldata must end with to be credited.
+func getPrepaidMemo() string {
+	if memo := os.Getenv("PREPAID_MEMO"); memo != "" {
+		return memo
+	}
+	return "paygate"
+}
+
+// initDepositWatcher builds the watcher from ETH_RPC_URL, the token
+// contract of CHAIN_ID (FACILITATOR_ASSET, or the known USDC one),
+// PREPAID_CONFIRMATIONS (default 5) and PREPAID_POLL_SECONDS (default 15).
+func initDepositWatcher() *depositWatcher {
+	if !prepaidEnabled() {
+		return nil
+	}
+	_, token := chainNetwork(getChainID())
+	rpcURL := os.Getenv("ETH_RPC_URL")
+	if rpcURL == "" || token == "" {
+		log.Println("Warning: Prepaid deposits won't be credited: they need ETH_RPC_URL and the token contract (FACILITATOR_ASSET)")
+		return nil
+	}
+	return &depositWatcher{
+		rpcURL:        rpcURL,
+		token:         token,
+		recipient:     getRecipientAddress(),
+		memo:          getPrepaidMemo(),
+		confirmations: uint64(getEnvAsInt("PREPAID_CONFIRMATIONS", 5)),
+		interval:      time.Duration(getEnvAsInt("PREPAID_POLL_SECONDS", 15)) * time.Second,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// start polls every interval until ctx is done.
+func (w *depositWatcher) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			if err := w.poll(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("error watching for prepaid deposits: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("Watching %s transfers to %s for prepaid deposits every %s", w.token, w.recipient, w.interval)
+}
+
+// poll credits the deposits in the blocks confirmed since the last poll. A
+// failure leaves the range to the next poll; credits already made are not
+// repeated.
+func (w *depositWatcher) poll(ctx context.Context) error {
+	head, err := ethBlockNumber(ctx, w.httpClient, w.rpcURL)
+	if err != nil {
+		return err
+	}
+	if head < w.confirmations {
+		return nil
+	}
+	confirmed := head - w.confirmations
+	if w.next == 0 {
+		w.next = w.startBlock(ctx, confirmed)
+	}
+	for w.next <= confirmed {
+		to := min(confirmed, w.next+maxLogRange-1)
+		logs, err := w.transfers(ctx, w.next, to)
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			if err := w.credit(ctx, l); err != nil {
+				prepaidDepositsTotal.Inc("error")
+				return err
+			}
+		}
+		w.next = to + 1
+		if redisClient != nil {
+			if err := redisClient.Set(ctx, prepaidCursorKey, w.next, 0).Err(); err != nil {
+				log.Printf("error saving the deposit watcher's block: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// startBlock is where the first poll starts: the block saved in Redis, else
+// PREPAID_START_BLOCK, else the latest confirmed block.
+func (w *depositWatcher) startBlock(ctx context.Context, confirmed uint64) uint64 {
+	if redisClient != nil {
+		next, err := redisClient.Get(ctx, prepaidCursorKey).Uint64()
+		if err == nil && next > 0 {
+			return next
+		}
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("error reading the deposit watcher's block: %v", err)
+		}
+	}
+	if start, err := strconv.ParseUint(os.Getenv("PREPAID_START_BLOCK"), 10, 64); err == nil && start > 0 {
+		return start
+	}
+	return max(confirmed, 1)
+}
+
+// transferLog is the part of an eth_getLogs entry the watcher reads.
+type transferLog struct {
+	TxHash   string         `json:"transactionHash"`
+	LogIndex hexutil.Uint64 `json:"logIndex"`
+	Topics   []string       `json:"topics"`
+	Data     hexutil.Bytes  `json:"data"`
+	Removed  bool           `json:"removed"`
+}
+
+// transfers returns the token's Transfer logs to the recipient in blocks
+// [from, to].
+func (w *depositWatcher) transfers(ctx context.Context, from, to uint64) ([]transferLog, error) {
+	filter := map[string]interface{}{
+		"address":   w.token,
+		"fromBlock": hexutil.EncodeUint64(from),
+		"toBlock":   hexutil.EncodeUint64(to),
+		"topics":    []interface{}{transferTopic, nil, common.BytesToHash(common.HexToAddress(w.recipient).Bytes()).Hex()},
+	}
+	var logs []transferLog
+	err := ethRPC(ctx, w.httpClient, w.rpcURL, "eth_getLogs", []interface{}{filter}, &logs)
+	return logs, err
+}
+
+// credit adds a transfer to the prepaid balance its memo names: the
+// sender's for the bare memo, or the wallet after it ("paygate:0x...").
+// Transfers without the memo, such as settled payments, are skipped.
+func (w *depositWatcher) credit(ctx context.Context, l transferLog) error {
+	if l.Removed || len(l.Topics) != 3 || len(l.Data) != 32 {
+		return nil
+	}
+	var tx struct {
+		Input hexutil.Bytes `json:"input"`
+	}
+	if err := ethRPC(ctx, w.httpClient, w.rpcURL, "eth_getTransactionByHash", []interface{}{l.TxHash}, &tx); err != nil {
+		return err
+	}
+	wallet, ok := depositWallet(transferMemo(tx.Input), w.memo, common.HexToAddress(l.Topics[1]).Hex())
+	if !ok {
+		prepaidDepositsTotal.Inc("no_memo")
+		return nil
+	}
+
+	decimals := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(getTokenDecimals())), nil)
+	amount := formatAmount(new(big.Rat).SetFrac(new(big.Int).SetBytes(l.Data), decimals))
+	credited, err := currentPrepaidStore().credit(ctx, wallet, amount, l.TxHash+":"+strconv.FormatUint(uint64(l.LogIndex), 10))
+	if err != nil {
+		return err
+	}
+	if !credited {
+		prepaidDepositsTotal.Inc("duplicate")
+		return nil
+	}
+	prepaidDepositsTotal.Inc("credited")
+	emitEvent(Event{Type: eventPrepaidCredited, Time: time.Now().UTC(), Wallet: wallet, Amount: amount,
+		Token: getPaymentToken(), ChainID: getChainID(), TxHash: l.TxHash})
+	log.Printf("Credited %s %s to the prepaid balance of %s from %s", amount, getPaymentToken(), wallet, l.TxHash)
+	return nil
+}
+
+// transferMemo returns the text appended to the calldata of a direct
+// transfer(address,uint256) call, or "" for any other call.
+func transferMemo(input []byte) string {
+	if len(input) <= 68 || !bytes.Equal(input[:4], transferSelector) {
+		return ""
+	}
+	memo := bytes.TrimRight(input[68:], "\x00")
+	if !utf8.Valid(memo) {
+		return ""
+	}
+	return strings.TrimSpace(string(memo))
+}
+
+// depositWallet returns the wallet memo credits: sender for the bare
+// prefix, the address after "prefix:" otherwise.
+func depositWallet(memo, prefix, sender string) (string, bool) {
+	if memo == prefix {
+		return strings.ToLower(sender), true
+	}
+	wallet, ok := strings.CutPrefix(memo, prefix+":")
+	if !ok || !strings.HasPrefix(wallet, "0x") || !common.IsHexAddress(wallet) {
+		return "", false
+	}
+	return strings.ToLower(wallet), true
+}
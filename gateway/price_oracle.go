@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// Price currencies: prices configured in token units (the default), or in
+// USD and converted at the token's current price.
+const (
+	priceCurrencyToken = "token"
+	priceCurrencyUSD   = "usd"
+)
+
+// Price sources for PRICE_ORACLE.
+const (
+	priceOracleChainlink = "chainlink"
+	priceOracleHTTP      = "http"
+)
+
+// Selectors of the Chainlink aggregator calls.
+var (
+	chainlinkLatestRoundSelector = crypto.Keccak256([]byte("latestRoundData()"))[:4]
+	chainlinkDecimalsSelector    = crypto.Keccak256([]byte("decimals()"))[:4]
+)
+
+var priceOracleFetchesTotal = newCounter(
+	"gateway_price_oracle_fetches_total",
+	"Token price lookups from the price oracle, by outcome (success, error).",
+	"outcome",
+)
+
+// errPriceUnavailable means no token price recent enough to charge with is
+// known.
+var errPriceUnavailable = errors.New("no recent token price")
+
+// priceOracle converts USD prices to token amounts. It is nil unless
+// PRICE_CURRENCY=usd.
+var priceOracle *tokenPriceOracle
+
+// getPriceCurrency returns PRICE_CURRENCY: token (default) or usd.
+func getPriceCurrency() string {
+	if strings.ToLower(os.Getenv("PRICE_CURRENCY")) == priceCurrencyUSD {
+		return priceCurrencyUSD
+	}
+	return priceCurrencyToken
+}
+
+// getPaymentToken returns PAYMENT_TOKEN (default USDC), the symbol of the
+// token payments are made in.
+func getPaymentToken() string {
+	if token := os.Getenv("PAYMENT_TOKEN"); token != "" {
+		return token
+	}
+	return "USDC"
+}
+
+// getTokenDecimals returns PAYMENT_TOKEN_DECIMALS (default 6), the precision
+// amounts are rounded up to.
+func getTokenDecimals() int {
+	return getEnvAsInt("PAYMENT_TOKEN_DECIMALS", defaultTokenDecimals)
+}
+
+// priceSource fetches the token's price in USD and when it was last
+// updated at the source.
+type priceSource interface {
+	fetch(ctx context.Context) (usd *big.Rat, updatedAt time.Time, err error)
+}
+
+// chainlinkPriceSource reads a Chainlink aggregator (TOKEN / USD feed)
+// through a JSON-RPC endpoint.
+type chainlinkPriceSource struct {
+	rpcURL     string
+	feed       string
+	httpClient *http.Client
+}
+
+func (s *chainlinkPriceSource) fetch(ctx context.Context) (*big.Rat, time.Time, error) {
+	out, err := ethCall(ctx, s.httpClient, s.rpcURL, s.feed, chainlinkDecimalsSelector)
+	if err != nil || len(out) < 32 {
+		return nil, time.Time{}, fmt.Errorf("decimals(): %v", err)
+	}
+	decimals := new(big.Int).SetBytes(out[:32]).Int64()
+	out, err = ethCall(ctx, s.httpClient, s.rpcURL, s.feed, chainlinkLatestRoundSelector)
+	if err != nil || len(out) < 5*32 {
+		return nil, time.Time{}, fmt.Errorf("latestRoundData(): %v", err)
+	}
+	// (roundId, answer, startedAt, updatedAt, answeredInRound); a price
+	// feed's answer is never negative
+	answer := new(big.Int).SetBytes(out[32:64])
+	updatedAt := time.Unix(new(big.Int).SetBytes(out[96:128]).Int64(), 0)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(decimals), nil)
+	return new(big.Rat).SetFrac(answer, scale), updatedAt, nil
+}
+
+// httpPriceSource GETs a JSON document such as
+// {"price": "3012.55", "updated_at": "2026-01-02T03:04:05Z"}, where
+// updated_at may also be Unix seconds or left out.
+type httpPriceSource struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (s *httpPriceSource) fetch(ctx context.Context) (*big.Rat, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("price oracle returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var doc struct {
+		Price     json.Number     `json:"price"`
+		UpdatedAt json.RawMessage `json:"updated_at"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, time.Time{}, fmt.Errorf("malformed price: %w", err)
+	}
+	price, ok := new(big.Rat).SetString(doc.Price.String())
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("malformed price %q", doc.Price)
+	}
+	updatedAt := time.Now()
+	if raw := strings.Trim(string(doc.UpdatedAt), `"`); raw != "" && raw != "null" {
+		if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			updatedAt = time.Unix(secs, 0)
+		} else if updatedAt, err = time.Parse(time.RFC3339, raw); err != nil {
+			return nil, time.Time{}, fmt.Errorf("malformed updated_at %q", raw)
+		}
+	}
+	return price, updatedAt, nil
+}
+
+type tokenPriceOracle struct {
+	source   priceSource
+	cacheTTL time.Duration
+	maxAge   time.Duration
+
+	mu        sync.Mutex
+	price     *big.Rat
+	updatedAt time.Time
+	fetchedAt time.Time
+}
+
+// initPriceOracle sets up USD pricing when PRICE_CURRENCY=usd, reading the
+// token's price from PRICE_ORACLE (chainlink: the PRICE_FEED_ADDRESS
+// aggregator through PRICE_ORACLE_URL or ETH_RPC_URL; http: PRICE_ORACLE_URL).
+// Prices are cached for PRICE_CACHE_SECONDS (default 60) and not used once
+// older than PRICE_MAX_AGE_SECONDS (default 3600). LoadConfig has already
+// validated the settings.
+func initPriceOracle() *tokenPriceOracle {
+	if getPriceCurrency() != priceCurrencyUSD {
+		return nil
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	var source priceSource
+	switch strings.ToLower(os.Getenv("PRICE_ORACLE")) {
+	case priceOracleHTTP:
+		source = &httpPriceSource{url: os.Getenv("PRICE_ORACLE_URL"), httpClient: client}
+	default:
+		rpcURL := os.Getenv("PRICE_ORACLE_URL")
+		if rpcURL == "" {
+			rpcURL = os.Getenv("ETH_RPC_URL")
+		}
+		source = &chainlinkPriceSource{rpcURL: rpcURL, feed: os.Getenv("PRICE_FEED_ADDRESS"), httpClient: client}
+	}
+	return newTokenPriceOracle(source,
+		time.Duration(getEnvAsInt("PRICE_CACHE_SECONDS", 60))*time.Second,
+		time.Duration(getEnvAsInt("PRICE_MAX_AGE_SECONDS", 3600))*time.Second)
+}
+
+func newTokenPriceOracle(source priceSource, cacheTTL, maxAge time.Duration) *tokenPriceOracle {
+	return &tokenPriceOracle{source: source, cacheTTL: cacheTTL, maxAge: maxAge}
+}
+
+// current returns the token's USD price, fetching it when the cached one is
+// older than the cache TTL. A failed fetch falls back to the cached price
+// while it is within the max age; past that, errPriceUnavailable is
+// returned rather than charging at a stale price.
+func (o *tokenPriceOracle) current(ctx context.Context) (*big.Rat, error) {
+	o.mu.Lock()
+	if o.price != nil && time.Since(o.fetchedAt) < o.cacheTTL && time.Since(o.updatedAt) < o.maxAge {
+		defer o.mu.Unlock()
+		return o.price, nil
+	}
+	o.mu.Unlock()
+
+	// Fetch without the lock, so a slow oracle holds up only the requests
+	// that need a new price
+	price, updatedAt, err := o.source.fetch(ctx)
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case err != nil:
+		priceOracleFetchesTotal.Inc("error")
+		log.Printf("error fetching the token price: %v", err)
+	case price.Sign() <= 0:
+		priceOracleFetchesTotal.Inc("error")
+		log.Printf("price oracle returned a non-positive price %s", price.FloatString(8))
+	default:
+		priceOracleFetchesTotal.Inc("success")
+		o.price, o.updatedAt, o.fetchedAt = price, updatedAt, now
+	}
+	if o.price == nil || now.Sub(o.updatedAt) >= o.maxAge {
+		return nil, errPriceUnavailable
+	}
+	return o.price, nil
+}
+
+// start refreshes the price every cache TTL until ctx is done, so requests
+// rarely wait for the oracle.
+func (o *tokenPriceOracle) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(o.cacheTTL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				o.current(ctx)
+			}
+		}
+	}()
+}
+
+// latest returns the cached price without fetching, for conversions after
+// PriceOracleMiddleware made sure there is a recent one.
+func (o *tokenPriceOracle) latest() (*big.Rat, time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.price, o.updatedAt
+}
+
+// usdToToken converts a USD amount to the token amount charged for it,
+// rounded up. In token pricing, or before any price is known, the amount
+// is returned as it is.
+func usdToToken(amount string) string {
+	if priceOracle == nil {
+		return amount
+	}
+	price, _ := priceOracle.latest()
+	usd, ok := new(big.Rat).SetString(amount)
+	if price == nil || !ok {
+		return amount
+	}
+	return formatAmount(usd.Quo(usd, price))
+}
+
+// PriceOracleMiddleware answers 503 price_unavailable on priced routes when
+// USD prices can't be converted because no recent token price is known. It
+// sets X-Token-Price-USD to the price used.
+func PriceOracleMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if priceOracle == nil {
+			c.Next()
+			return
+		}
+		price, err := priceOracle.current(c.Request.Context())
+		if err != nil {
+			c.Header("Retry-After", "30")
+			abortWithProblem(c, newProblem(503, codePriceUnavailable, "Price Unavailable",
+				"Prices are set in USD and the token's price could not be read recently enough; retry shortly"))
+			return
+		}
+		c.Header("X-Token-Price-USD", price.FloatString(8))
+		c.Next()
+	}
+}
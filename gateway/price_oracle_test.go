@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// fakePriceSource returns price, updated at updatedAt, or err.
+type fakePriceSource struct {
+	mu        sync.Mutex
+	price     string
+	updatedAt time.Time
+	err       error
+	fetches   int
+}
+
+func (s *fakePriceSource) fetch(ctx context.Context) (*big.Rat, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	if s.err != nil {
+		return nil, time.Time{}, s.err
+	}
+	price, _ := new(big.Rat).SetString(s.price)
+	return price, s.updatedAt, nil
+}
+
+// withPriceOracle installs an oracle reading source for the test.
+func withPriceOracle(t *testing.T, source priceSource) *tokenPriceOracle {
+	prev := priceOracle
+	priceOracle = newTokenPriceOracle(source, time.Minute, time.Hour)
+	t.Cleanup(func() { priceOracle = prev })
+	return priceOracle
+}
+
+func TestTokenPriceOracle_CachesAndRefusesStalePrices(t *testing.T) {
+	source := &fakePriceSource{price: "2000", updatedAt: time.Now()}
+	oracle := newTokenPriceOracle(source, time.Minute, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if price, err := oracle.current(ctx); err != nil || price.FloatString(0) != "2000" {
+			t.Fatalf("Expected 2000, got %v, %v", price, err)
+		}
+	}
+	if source.fetches != 1 {
+		t.Errorf("Expected the second lookup from the cache, got %d fetches", source.fetches)
+	}
+
+	// A failed fetch keeps the last price while it is recent enough
+	oracle.cacheTTL = 0
+	source.err = errors.New("oracle down")
+	if price, err := oracle.current(ctx); err != nil || price.FloatString(0) != "2000" {
+		t.Errorf("Expected the cached price while the oracle is down, got %v, %v", price, err)
+	}
+
+	// ...but not past the max age
+	oracle.updatedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := oracle.current(ctx); !errors.Is(err, errPriceUnavailable) {
+		t.Errorf("Expected errPriceUnavailable for a stale price, got %v", err)
+	}
+
+	// A source reporting an old update is stale too
+	source.err, source.updatedAt = nil, time.Now().Add(-2*time.Hour)
+	if _, err := oracle.current(ctx); !errors.Is(err, errPriceUnavailable) {
+		t.Errorf("Expected errPriceUnavailable for an old answer, got %v", err)
+	}
+}
+
+func TestUSDToToken(t *testing.T) {
+	if got := usdToToken("0.001"); got != "0.001" {
+		t.Errorf("Expected amounts unchanged in token pricing, got %s", got)
+	}
+
+	oracle := withPriceOracle(t, &fakePriceSource{price: "2500", updatedAt: time.Now()})
+	if got := usdToToken("0.001"); got != "0.001" {
+		t.Errorf("Expected amounts unchanged before a price is known, got %s", got)
+	}
+	oracle.current(context.Background())
+	t.Setenv("PAYMENT_TOKEN_DECIMALS", "18")
+	if got := usdToToken("5"); got != "0.002" {
+		t.Errorf("Expected $5 at $2500 to be 0.002, got %s", got)
+	}
+	// Rounded up to the token's decimals
+	t.Setenv("PAYMENT_TOKEN_DECIMALS", "6")
+	if got := usdToToken("0.001"); got != "0.000001" {
+		t.Errorf("Expected $0.001 at $2500 rounded up to 0.000001, got %s", got)
+	}
+}
+
+func TestHTTPPriceSource(t *testing.T) {
+	body := `{"price": "3012.5", "updated_at": 1767323045}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	source := &httpPriceSource{url: srv.URL, httpClient: srv.Client()}
+
+	price, updatedAt, err := source.fetch(context.Background())
+	if err != nil || price.FloatString(1) != "3012.5" || updatedAt.Unix() != 1767323045 {
+		t.Fatalf("Expected 3012.5 at 1767323045, got %v at %v, %v", price, updatedAt, err)
+	}
+	body = `{"price": 1.0001, "updated_at": "2026-01-02T03:04:05Z"}`
+	if price, updatedAt, err = source.fetch(context.Background()); err != nil || price.FloatString(4) != "1.0001" || updatedAt.Year() != 2026 {
+		t.Errorf("Expected a numeric price and an RFC 3339 time, got %v at %v, %v", price, updatedAt, err)
+	}
+	body = `{"price": "n/a"}`
+	if _, _, err = source.fetch(context.Background()); err == nil {
+		t.Error("Expected an error for a malformed price")
+	}
+}
+
+func TestChainlinkPriceSource(t *testing.T) {
+	const feed = "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419"
+	updated := time.Now().Add(-time.Minute).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var call struct{ To, Data string }
+		json.Unmarshal(req.Params[0], &call)
+		data, _ := hexutil.Decode(call.Data)
+		var result []byte
+		switch {
+		case !strings.EqualFold(call.To, feed):
+		case bytes.Equal(data, chainlinkDecimalsSelector):
+			result = common.BigToHash(big.NewInt(8)).Bytes()
+		case bytes.Equal(data, chainlinkLatestRoundSelector):
+			for _, word := range []int64{7, 250012345678, updated, updated, 7} {
+				result = append(result, common.BigToHash(big.NewInt(word)).Bytes()...)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": hexutil.Encode(result)})
+	}))
+	defer srv.Close()
+
+	source := &chainlinkPriceSource{rpcURL: srv.URL, feed: feed, httpClient: srv.Client()}
+	price, updatedAt, err := source.fetch(context.Background())
+	if err != nil || price.FloatString(8) != "2500.12345678" || updatedAt.Unix() != updated {
+		t.Fatalf("Expected 2500.12345678 at %d, got %v at %v, %v", updated, price, updatedAt, err)
+	}
+}
+
+func TestPriceOracleMiddleware(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "5")
+	t.Setenv("PAYMENT_TOKEN", "WETH")
+	t.Setenv("PAYMENT_TOKEN_DECIMALS", "18")
+	source := &fakePriceSource{price: "2500", updatedAt: time.Now()}
+	withPriceOracle(t, source)
+	r := setupVersionedRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/payment/challenge", nil)
+	r.ServeHTTP(w, req)
+	var challenge PaymentChallenge
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	if w.Code != http.StatusOK || challenge.PaymentContext.Amount != "0.002" || challenge.PaymentContext.Token != "WETH" {
+		t.Fatalf("Expected $5 converted to 0.002 WETH, got %d %+v", w.Code, challenge.PaymentContext)
+	}
+	if got := w.Header().Get("X-Token-Price-USD"); got != "2500.00000000" {
+		t.Errorf("Expected the price used in X-Token-Price-USD, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/models", nil)
+	r.ServeHTTP(w, req)
+	var models ModelsResponse
+	json.Unmarshal(w.Body.Bytes(), &models)
+	if len(models.Models) == 0 || models.Models[0].Price != "0.002" || models.Models[0].PriceUSD != "5" {
+		t.Errorf("Expected converted model prices with the USD price, got %s", w.Body.String())
+	}
+
+	// No recent price: 503 rather than charging at a stale one
+	source.err = errors.New("oracle down")
+	priceOracle.cacheTTL = 0
+	priceOracle.updatedAt = time.Now().Add(-2 * time.Hour)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/payment/challenge", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || decodeProblem(t, w)["code"] != codePriceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 price_unavailable, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLoadConfig_PriceCurrency(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("PRICE_CURRENCY", "usd")
+	t.Setenv("PRICE_ORACLE", "")
+	t.Setenv("PRICE_FEED_ADDRESS", "")
+	t.Setenv("PRICE_ORACLE_URL", "")
+	t.Setenv("ETH_RPC_URL", "")
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "PRICE_FEED_ADDRESS") || !strings.Contains(err.Error(), "ETH_RPC_URL") {
+		t.Errorf("Expected chainlink to require a feed and an RPC URL, got %v", err)
+	}
+	t.Setenv("PRICE_FEED_ADDRESS", "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419")
+	t.Setenv("ETH_RPC_URL", "https://rpc.example.com")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("Expected a chainlink oracle accepted, got %v", err)
+	}
+	t.Setenv("PRICE_ORACLE", "http")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "PRICE_ORACLE_URL") {
+		t.Errorf("Expected the http oracle to require PRICE_ORACLE_URL, got %v", err)
+	}
+	t.Setenv("PRICE_CURRENCY", "eur")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "PRICE_CURRENCY") {
+		t.Errorf("Expected an unknown currency rejected, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultTokenDecimals is the precision prices are rounded up to unless
+// PAYMENT_TOKEN_DECIMALS says otherwise; USDC has 6.
+const defaultTokenDecimals = 6
+
+// getPricePer1KTokens returns PRICE_PER_1K_TOKENS, or "" for flat pricing.
+func getPricePer1KTokens() string {
+	if appConfig != nil {
+		return appConfig.PricePer1KTokens
+	}
+	return strings.TrimSpace(os.Getenv("PRICE_PER_1K_TOKENS"))
+}
+
+// priceFor returns the amount to charge ctx's request for running model on
+// a text of size bytes and tokens input tokens. With PRICE_PER_1K_TOKENS set
+// the price is proportional to the token count, rounded up to the token's
+// decimals, and PAYMENT_AMOUNT is the minimum charge; otherwise every
+// request costs PAYMENT_AMOUNT. PRICE_SIZE_BANDS replaces PAYMENT_AMOUNT
+// with the price of the text's size band. A price set for model in
+// ALLOWED_MODELS replaces PRICE_PER_1K_TOKENS or the flat price
+// respectively. A translated summary
+// (opts.OutputLanguage) adds OUTPUT_LANGUAGE_SURCHARGE. With
+// PRICE_CURRENCY=usd these are all USD, and the sum is converted to the
+// token at its current price. A tenant's own prices replace
+// PAYMENT_AMOUNT and PRICE_PER_1K_TOKENS.
+func priceFor(ctx context.Context, model string, size, tokens int, opts summaryOptions) string {
+	return usdToToken(withLanguageSurcharge(basePriceFor(ctx, model, size, tokens), opts))
+}
+
+// pricingScheme returns how requests are priced: "flat", "size_bands"
+// with PRICE_SIZE_BANDS, or "per_1k_tokens" with PRICE_PER_1K_TOKENS.
+func pricingScheme(ctx context.Context) string {
+	if pricePer1KTokensFor(ctx) != "" {
+		return "per_1k_tokens"
+	}
+	if len(sizeBandsFor(ctx)) > 0 {
+		return "size_bands"
+	}
+	return "flat"
+}
+
+// basePriceFor is priceFor before surcharges.
+func basePriceFor(ctx context.Context, model string, size, tokens int) string {
+	base := paymentAmountFor(ctx)
+	band, banded := sizeBandFor(ctx, size)
+	if banded {
+		base = band.Price
+	}
+	modelPrice := modelBasePrice(model)
+
+	perKRaw := pricePer1KTokensFor(ctx)
+	if perKRaw == "" {
+		switch {
+		case modelPrice == "":
+			return base
+		case banded:
+			return modelBandPrice(ctx, band, modelPrice)
+		}
+		return modelPrice
+	}
+	if modelPrice != "" {
+		perKRaw = modelPrice
+	}
+	perK, ok := new(big.Rat).SetString(perKRaw)
+	if !ok {
+		return base
+	}
+	price := new(big.Rat).Mul(perK, big.NewRat(int64(tokens), 1000))
+	if min, ok := new(big.Rat).SetString(base); ok && price.Cmp(min) < 0 {
+		return base
+	}
+	return formatAmount(price)
+}
+
+// modelBandPrice is the flat price of a text in band for a model with its
+// own price in ALLOWED_MODELS: the band's price scaled by the model's price
+// over PAYMENT_AMOUNT, so the model's larger texts still cost more.
+func modelBandPrice(ctx context.Context, band SizeBand, modelPrice string) string {
+	bandPrice, ok1 := parseDecimal(band.Price)
+	price, ok2 := parseDecimal(modelPrice)
+	def, ok3 := parseDecimal(paymentAmountFor(ctx))
+	if !ok1 || !ok2 || !ok3 || def.Sign() == 0 {
+		return modelPrice
+	}
+	return formatAmount(bandPrice.Mul(bandPrice, price).Quo(bandPrice, def))
+}
+
+// formatAmount renders amount as a decimal rounded up to the token's
+// decimals, without trailing zeros ("0.0015", "2").
+func formatAmount(amount *big.Rat) string {
+	decimals := getTokenDecimals()
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	scaled := new(big.Rat).Mul(amount, new(big.Rat).SetInt(scale))
+	units, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() > 0 {
+		units.Add(units, big.NewInt(1))
+	}
+
+	s := new(big.Rat).SetFrac(units, scale).FloatString(decimals)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// decimalPattern matches a plain decimal amount such as "0.001". big.Rat
+// also accepts signs, fractions, exponents and base prefixes ("0x10"),
+// which the verifier and contract would read differently.
+var decimalPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// parseDecimal parses a plain decimal amount, reporting false for anything
+// else.
+func parseDecimal(raw string) (*big.Rat, bool) {
+	if !decimalPattern.MatchString(raw) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(raw)
+}
+
+// readQuoteRequest returns the body of an unpaid summarize request so the
+// 402 challenge can be priced for its text and model. ok is false when there
+// is no usable body, in which case the challenge quotes the default price.
+func readQuoteRequest(c *gin.Context) (req SummarizeRequest, ok bool) {
+	if c.Request.Body == nil {
+		return req, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSummarizeBodyBytes))
+	if err != nil || len(body) == 0 {
+		return req, false
+	}
+	if req, err = decodeSummarizeRequest(c, body); err != nil || req.Text == "" {
+		return req, false
+	}
+	return req, true
+}
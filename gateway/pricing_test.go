@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/testsupport"
+)
+
+func TestPriceForTokens(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+
+	t.Setenv("PRICE_PER_1K_TOKENS", "")
+	if got := priceFor(context.Background(), "", 0, 1_000_000, summaryOptions{}); got != "0.001" {
+		t.Errorf("Expected flat price without PRICE_PER_1K_TOKENS, got %s", got)
+	}
+
+	t.Setenv("PRICE_PER_1K_TOKENS", "0.002")
+	tests := []struct {
+		tokens int
+		want   string
+	}{
+		{0, "0.001"},    // minimum charge
+		{400, "0.001"},  // 0.0008 is below the minimum
+		{1000, "0.002"}, // proportional
+		{1234, "0.002468"},
+		{1_500_000, "3"},
+	}
+	for _, tt := range tests {
+		if got := priceFor(context.Background(), "", 0, tt.tokens, summaryOptions{}); got != tt.want {
+			t.Errorf("priceFor(%d) = %s, want %s", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAmount_RoundsUp(t *testing.T) {
+	if got := formatAmount(big.NewRat(1, 3_000_000)); got != "0.000001" {
+		t.Errorf("Expected sub-micro amounts to round up, got %s", got)
+	}
+	if got := formatAmount(big.NewRat(5, 2)); got != "2.5" {
+		t.Errorf("Expected trailing zeros trimmed, got %s", got)
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	for _, raw := range []string{"0", "2", "0.001", "10.50"} {
+		if _, ok := parseDecimal(raw); !ok {
+			t.Errorf("Expected %q to be a decimal", raw)
+		}
+	}
+	for _, raw := range []string{"", "-1", "+1", ".5", "1.", "1/2", "1e3", "0x10", "0b1", "0o7", " 1"} {
+		if _, ok := parseDecimal(raw); ok {
+			t.Errorf("Expected %q to be refused", raw)
+		}
+	}
+}
+
+func TestHandleSummarize_QuotesTokensIn402(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_PER_1K_TOKENS", "1")
+	r := setupVersionedRouter()
+
+	text := strings.Repeat("Hello world ", 1000) // 2000 tokens
+	body, _ := json.Marshal(SummarizeRequest{Text: text})
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	p := decodeProblem(t, w)
+	if p["tokens"] != float64(2000) {
+		t.Errorf("Expected 2000 tokens in the quote, got %v", p["tokens"])
+	}
+	payment, _ := p["paymentContext"].(map[string]interface{})
+	if payment["amount"] != "2" {
+		t.Errorf("Expected amount 2 for 2000 tokens at 1 per 1K, got %v", payment["amount"])
+	}
+}
+
+func TestHandleSummarize_VerifiesTokenPrice(t *testing.T) {
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_PER_1K_TOKENS", "1")
+	r := setupVersionedRouter()
+
+	body, _ := json.Marshal(SummarizeRequest{Text: strings.Repeat("Hello world ", 1000)})
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(string(body)))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "nonce-tokens")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Input-Tokens"); got != "2000" {
+		t.Errorf("Expected X-Input-Tokens 2000, got %q", got)
+	}
+	reqs := verifier.Requests()
+	if len(reqs) != 1 || reqs[0].Context.Amount != "2" {
+		t.Errorf("Expected the verifier to check amount 2, got %+v", reqs)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PrivacyErasure is the body of DELETE /v1/privacy/:wallet: what was
+// removed or anonymized.
+type PrivacyErasure struct {
+	Wallet             string    `json:"wallet" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	UsageEntries       int64     `json:"usage_entries" doc:"Usage ledger entries anonymized (deleted from a Redis ledger)"`
+	Receipts           int64     `json:"receipts" doc:"Stored receipts deleted"`
+	CachedSummaries    int64     `json:"cached_summaries" doc:"Cached summaries of the wallet's texts deleted"`
+	IdempotencyRecords int64     `json:"idempotency_records" doc:"Stored responses for Idempotency-Key replays deleted"`
+	ErasedAt           time.Time `json:"erased_at"`
+}
+
+// privacyErasureChallenge is the message a wallet signs (EIP-191
+// personal_sign) to erase its own data. It differs from the usage
+// challenge, so a signature given to read usage can't erase anything.
+func privacyErasureChallenge(wallet string, timestamp int64) string {
+	return fmt.Sprintf("MicroAI-Paygate erase data of %s at %d", strings.ToLower(wallet), timestamp)
+}
+
+// handleEraseWalletData handles DELETE /v1/privacy/:wallet. It anonymizes
+// the wallet's usage ledger entries and deletes its receipts, the cached
+// summaries its requests read or wrote (traced through the ledger's cache
+// keys) and the stored idempotent responses carrying its receipts. Money
+// the gateway still owes or holds for the wallet, prepaid balances and
+// channel deposits, is kept. The caller must hold the admin token or sign
+// the erasure challenge with the wallet. Every erasure, complete or not,
+// is recorded in the audit log under a hash of the wallet, and repeating
+// one only finds what is left.
+func handleEraseWalletData(c *gin.Context) {
+	wallet, ok := resolveWalletParam(c, c.Param("wallet"))
+	if !ok {
+		return
+	}
+	admin := isAdminRequest(c)
+	if !admin && !checkSignedChallenge(c, wallet, privacyErasureChallenge, getUsageAuthMaxAge(), ", or use the admin token") {
+		return
+	}
+
+	ctx := c.Request.Context()
+	erasure := PrivacyErasure{Wallet: wallet, ErasedAt: time.Now().UTC()}
+	step, err := eraseWalletData(ctx, wallet, &erasure)
+
+	actor := "wallet"
+	if admin {
+		actor = "admin"
+	}
+	details := map[string]string{
+		"wallet_hash":         hashText(wallet),
+		"usage_entries":       strconv.FormatInt(erasure.UsageEntries, 10),
+		"receipts":            strconv.FormatInt(erasure.Receipts, 10),
+		"cached_summaries":    strconv.FormatInt(erasure.CachedSummaries, 10),
+		"idempotency_records": strconv.FormatInt(erasure.IdempotencyRecords, 10),
+	}
+	status := 200
+	if err != nil {
+		status = 500
+		details["failed"] = step
+	}
+	recordAudit(ctx, AuditEntry{
+		Action: auditPrivacyErasure, Actor: actor, RemoteIP: c.ClientIP(), RequestID: c.GetString(requestIDKey),
+		Method: c.Request.Method, Path: c.FullPath(), Status: status, Details: details,
+	})
+
+	if err != nil {
+		log.Printf("error erasing the data of %s (%s): %v", wallet, step, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to erase wallet data",
+			"Part of the data may have been erased; repeat the request to finish").With("failed", step))
+		return
+	}
+	c.JSON(200, erasure)
+}
+
+// eraseWalletData erases wallet's data into erasure, in an order that keeps
+// a retry able to finish: the cache keys are read from the ledger before
+// its entries are anonymized. On failure it returns the step that failed.
+func eraseWalletData(ctx context.Context, wallet string, erasure *PrivacyErasure) (string, error) {
+	if usageLedger != nil {
+		entries, err := usageLedger.Entries(ctx, ledgerQuery{Wallet: wallet})
+		if err != nil {
+			return "usage", err
+		}
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			if e.CacheKey == "" || seen[e.CacheKey] {
+				continue
+			}
+			seen[e.CacheKey] = true
+			found, err := deleteCachedResponse(ctx, e.CacheKey)
+			if err != nil {
+				return "cache", err
+			}
+			if found {
+				erasure.CachedSummaries++
+			}
+		}
+	}
+
+	// Stored responses embed the receipt, whose payer is the wallet
+	needle := []byte(strings.ToLower(wallet))
+	n, err := currentIdempotencyStore().purge(ctx, func(rec idempotencyRecord) bool {
+		return bytes.Contains(bytes.ToLower(rec.Body), needle)
+	})
+	erasure.IdempotencyRecords = n
+	if err != nil {
+		return "idempotency", err
+	}
+
+	erasure.Receipts = eraseReceipts(wallet)
+	if dataStore != nil {
+		n, err := dataStore.EraseReceipts(ctx, wallet)
+		erasure.Receipts += n
+		if err != nil {
+			return "receipts", err
+		}
+	}
+
+	if usageLedger != nil {
+		n, err := usageLedger.Erase(ctx, wallet)
+		erasure.UsageEntries = n
+		if err != nil {
+			return "usage", err
+		}
+	}
+	return "", nil
+}
+
+// eraseReceipts removes the receipts paid by wallet from this instance's
+// receipt store.
+func eraseReceipts(wallet string) int64 {
+	receiptStoreMu.Lock()
+	defer receiptStoreMu.Unlock()
+	var n int64
+	for id, entry := range receiptStore {
+		if strings.EqualFold(entry.receipt.Receipt.Payment.Payer, wallet) {
+			delete(receiptStore, id)
+			n++
+		}
+	}
+	return n
+}
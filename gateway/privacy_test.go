@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestHandleEraseWalletData(t *testing.T) {
+	setupTestMemoryCache(t, 10, time.Hour)
+	ledger := setupTestLedger(t)
+	audit := setupTestAuditLog(t)
+	r := setupVersionedRouter()
+	ctx := context.Background()
+
+	key, _ := crypto.GenerateKey()
+	wallet := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	lower := strings.ToLower(wallet)
+	other := "0x0000000000000000000000000000000000000001"
+
+	ledger.Append(ctx, LedgerEntry{ReceiptID: "rcpt_mine", Time: time.Now(), Wallet: lower, Amount: "0.001", TxHash: "0xtx", CacheKey: "ai:summary:v3:mine"})
+	ledger.Append(ctx, LedgerEntry{ReceiptID: "rcpt_other", Time: time.Now(), Wallet: other, Amount: "0.002", CacheKey: "ai:summary:v3:other"})
+	memoryCache.Set("ai:summary:v3:mine", &CachedResponse{Result: "mine", CachedAt: time.Now()})
+	memoryCache.Set("ai:summary:v3:other", &CachedResponse{Result: "other", CachedAt: time.Now()})
+	for id, payer := range map[string]string{"rcpt_mine": wallet, "rcpt_other": other} {
+		receipt := &SignedReceipt{Receipt: Receipt{ID: id, Timestamp: time.Now()}}
+		receipt.Receipt.Payment.Payer = payer
+		receiptStoreMu.Lock()
+		receiptStore[id] = &receiptEntry{receipt: receipt, expiresAt: time.Now().Add(time.Hour)}
+		receiptStoreMu.Unlock()
+	}
+	t.Cleanup(func() {
+		receiptStoreMu.Lock()
+		delete(receiptStore, "rcpt_other")
+		receiptStoreMu.Unlock()
+	})
+	memoryIdempotency.save(ctx, "idem-mine", idempotencyRecord{Status: 200, Body: []byte(`{"receipt":{"payment":{"payer":"` + wallet + `"}}}`)}, time.Hour)
+	t.Cleanup(func() { memoryIdempotency.release(ctx, "idem-mine") })
+
+	erase := func(header map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", "/v1/privacy/"+wallet, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := erase(nil)
+	if w.Code != 401 || decodeProblem(t, w)["challenge"] != privacyErasureChallenge(wallet, int64(decodeProblem(t, w)["timestamp"].(float64))) {
+		t.Fatalf("Expected 401 with the erasure challenge, got %d: %s", w.Code, w.Body.String())
+	}
+	// A signature given to read usage doesn't erase anything
+	now := time.Now().Unix()
+	usageSig := personalSign(t, key, usageChallenge(wallet, now))
+	if w := erase(map[string]string{"X-Wallet-Signature": usageSig, "X-Wallet-Timestamp": strconv.FormatInt(now, 10)}); w.Code != 401 {
+		t.Fatalf("Expected the usage signature refused, got %d", w.Code)
+	}
+
+	sig := personalSign(t, key, privacyErasureChallenge(wallet, now))
+	w = erase(map[string]string{"X-Wallet-Signature": sig, "X-Wallet-Timestamp": strconv.FormatInt(now, 10)})
+	var resp PrivacyErasure
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Wallet != lower || resp.UsageEntries != 1 || resp.Receipts != 1 || resp.CachedSummaries != 1 || resp.IdempotencyRecords != 1 {
+		t.Fatalf("Expected one of each erased, got %d %+v", w.Code, resp)
+	}
+
+	entries, _ := ledger.Entries(ctx, ledgerQuery{})
+	if len(entries) != 2 || entries[0].Wallet != "" || entries[0].TxHash != "" || entries[0].CacheKey != "" || entries[0].Amount != "0.001" {
+		t.Errorf("Expected the wallet's entry anonymized with its amount kept, got %+v", entries[0])
+	}
+	if entries[1].Wallet != other {
+		t.Errorf("Expected another wallet's entry untouched, got %+v", entries[1])
+	}
+	if _, ok := getReceipt("rcpt_mine"); ok {
+		t.Error("Expected the wallet's receipt deleted")
+	}
+	if _, ok := getReceipt("rcpt_other"); !ok {
+		t.Error("Expected another wallet's receipt kept")
+	}
+	if _, ok := memoryCache.Get("ai:summary:v3:mine"); ok {
+		t.Error("Expected the wallet's cached summary deleted")
+	}
+	if _, ok := memoryCache.Get("ai:summary:v3:other"); !ok {
+		t.Error("Expected another wallet's cached summary kept")
+	}
+
+	logged, _ := audit.Entries(ctx)
+	last := logged[len(logged)-1]
+	if last.Action != auditPrivacyErasure || last.Actor != "wallet" || last.Details["wallet_hash"] != hashText(lower) || last.Details["usage_entries"] != "1" {
+		t.Errorf("Expected the erasure audited under the wallet's hash, got %+v", last)
+	}
+	if strings.Contains(strings.ToLower(last.Path), lower[2:]) {
+		t.Error("Expected no plain wallet address in the audit entry")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problemContentType is the RFC 7807 media type used for every error body.
+const problemContentType = "application/problem+json"
+
+// problemCodeKey is the gin context key holding the code of the problem the
+// request was answered with.
+const problemCodeKey = "problem_code"
+
+// problemTypePrefix prefixes a problem's code to form its type URI.
+const problemTypePrefix = "urn:microai-paygate:problem:"
+
+// Stable error codes. Clients branch on these, so never rename one; add a new
+// code instead.
+const (
+	codePaymentRequired           = "payment_required"
+	codeInsufficientPayment       = "insufficient_payment"
+	codePaymentMismatch           = "payment_mismatch"
+	codeInvalidPaymentHeader      = "invalid_payment_header"
+	codeInvalidSignature          = "invalid_signature"
+	codeInvalidNonce              = "invalid_nonce"
+	codeInvalidPromoCode          = "invalid_promo_code"
+	codePaymentHeld               = "payment_held"
+	codeChannelNotFound           = "channel_not_found"
+	codeChannelClosed             = "channel_closed"
+	codeChannelUnderpaid          = "channel_underpaid"
+	codeChannelExhausted          = "channel_exhausted"
+	codePrepaidInsufficient       = "prepaid_insufficient"
+	codePrepaidDisabled           = "prepaid_disabled"
+	codePriceUnavailable          = "price_unavailable"
+	codeFacilitatorRejected       = "facilitator_rejected"
+	codeFacilitatorError          = "facilitator_error"
+	codeModelNotEntitled          = "model_not_entitled"
+	codeModelNotAllowed           = "model_not_allowed"
+	codeUnsupportedLanguage       = "unsupported_language"
+	codeModelResolutionFailed     = "model_resolution_failed"
+	codeInvalidRequestBody        = "invalid_request_body"
+	codeInvalidText               = "invalid_text"
+	codePromptInjection           = "prompt_injection"
+	codePayloadTooLarge           = "payload_too_large"
+	codeUnsupportedEncoding       = "unsupported_encoding"
+	codeInvalidIdempotencyKey     = "invalid_idempotency_key"
+	codeIdempotencyKeyReused      = "idempotency_key_reused"
+	codeIdempotencyInProgress     = "idempotency_in_progress"
+	codeVerifierUnavailable       = "verifier_unavailable"
+	codeVerifierTimeout           = "verifier_timeout"
+	codePluginRejected            = "plugin_rejected"
+	codeVerifierError             = "verifier_error"
+	codeAITimeout                 = "ai_timeout"
+	codeAIServiceFailed           = "ai_service_failed"
+	codeAIUnavailable             = "ai_unavailable"
+	codeOutputFlagged             = "output_flagged"
+	codeModerationUnavailable     = "moderation_unavailable"
+	codeReceiptFailed             = "receipt_failed"
+	codeReceiptNotFound           = "receipt_not_found"
+	codePaymentNotFound           = "payment_not_found"
+	codeProofPending              = "proof_pending"
+	codeAnchoringDisabled         = "anchoring_disabled"
+	codeRateLimited               = "rate_limited"
+	codeConcurrencyLimited        = "concurrency_limited"
+	codeRequestTimeout            = "request_timeout"
+	codeAdminDisabled             = "admin_disabled"
+	codeUnauthorized              = "unauthorized"
+	codeIPBlocked                 = "ip_blocked"
+	codeCacheEntryNotFound        = "cache_entry_not_found"
+	codePromoCodeNotFound         = "promo_code_not_found"
+	codeTenantNotFound            = "tenant_not_found"
+	codeTenantConflict            = "tenant_conflict"
+	codeMultiTenantDisabled       = "multi_tenant_disabled"
+	codeRateLimitOverrideNotFound = "rate_limit_override_not_found"
+	codeRateLimitingDisabled      = "rate_limiting_disabled"
+	codeProofOfWorkRequired       = "proof_of_work_required"
+	codeCacheOperationFailed      = "cache_operation_failed"
+	codeInvalidWallet             = "invalid_wallet"
+	codeInvalidWindow             = "invalid_window"
+	codeLedgerDisabled            = "ledger_disabled"
+	codeRefundExists              = "refund_exists"
+	codeInvalidQuery              = "invalid_query"
+	codeReconcileDisabled         = "reconciliation_disabled"
+	codeAuditDisabled             = "audit_disabled"
+	codeNotFound                  = "not_found"
+	codeMethodNotAllowed          = "method_not_allowed"
+	codeInternalError             = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details body. Extensions carries
+// problem-specific members such as paymentContext or retry_after, which are
+// emitted alongside the standard ones.
+type Problem struct {
+	Type       string                 `json:"type" doc:"URI identifying the problem type, derived from code" example:"urn:microai-paygate:problem:payment_required"`
+	Code       string                 `json:"code" doc:"Stable machine-readable error code; branch on this" example:"payment_required"`
+	Title      string                 `json:"title" example:"Payment Required"`
+	Status     int                    `json:"status" example:"402"`
+	Detail     string                 `json:"detail,omitempty" example:"Please sign the payment context"`
+	RequestID  string                 `json:"request_id,omitempty" doc:"Matches the X-Request-ID response header"`
+	Extensions map[string]interface{} `json:"-"`
+}
+
+func newProblem(status int, code, title, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypePrefix + code,
+		Code:   code,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// With adds an extension member and returns p for chaining.
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	body := make(map[string]interface{}, len(p.Extensions)+6)
+	for k, v := range p.Extensions {
+		body[k] = v
+	}
+	body["type"] = p.Type
+	body["code"] = p.Code
+	body["title"] = p.Title
+	body["status"] = p.Status
+	if p.Detail != "" {
+		body["detail"] = p.Detail
+	}
+	if p.RequestID != "" {
+		body["request_id"] = p.RequestID
+	}
+	return json.Marshal(body)
+}
+
+// abortWithProblem writes p as application/problem+json, tagged with the
+// request ID, and aborts the handler chain.
+func abortWithProblem(c *gin.Context, p *Problem) {
+	p.RequestID = c.GetString(requestIDKey)
+	c.Set(problemCodeKey, p.Code)
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// handleNoRoute and handleNoMethod replace gin's plain-text 404/405 bodies.
+func handleNoRoute(c *gin.Context) {
+	abortWithProblem(c, newProblem(404, codeNotFound, "Not Found", "No route matches "+c.Request.URL.Path))
+}
+
+func handleNoMethod(c *gin.Context) {
+	abortWithProblem(c, newProblem(405, codeMethodNotAllowed, "Method Not Allowed", c.Request.Method+" is not supported on "+c.Request.URL.Path))
+}
+
+// recoverWithProblem is the gin recovery handler; the panic has already been
+// logged with a stack trace by gin.
+func recoverWithProblem(c *gin.Context, _ interface{}) {
+	abortWithProblem(c, newProblem(500, codeInternalError, "Internal Server Error", ""))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, problemContentType) {
+		t.Errorf("Expected Content-Type %s, got %q", problemContentType, ct)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to parse problem body: %v", err)
+	}
+	return body
+}
+
+func TestProblem_MarshalJSON(t *testing.T) {
+	p := newProblem(429, codeRateLimited, "Too Many Requests", "slow down").With("retry_after", 3)
+	p.RequestID = "req-1"
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]interface{}
+	json.Unmarshal(data, &body)
+
+	want := map[string]interface{}{
+		"type":        "urn:microai-paygate:problem:rate_limited",
+		"code":        "rate_limited",
+		"title":       "Too Many Requests",
+		"status":      float64(429),
+		"detail":      "slow down",
+		"request_id":  "req-1",
+		"retry_after": float64(3),
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, body[k])
+		}
+	}
+
+	// Extensions cannot shadow standard members, and empty optional members are omitted
+	data, _ = json.Marshal(newProblem(500, codeInternalError, "Internal Server Error", "").With("code", "other"))
+	if strings.Contains(string(data), "detail") || !strings.Contains(string(data), `"code":"internal_error"`) {
+		t.Errorf("Unexpected body: %s", data)
+	}
+}
+
+func TestAbortWithProblem_IncludesRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.GET("/fail", func(c *gin.Context) {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", ""))
+	})
+
+	req, _ := http.NewRequest("GET", "/fail", nil)
+	req.Header.Set("X-Request-ID", "client-id-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	body := decodeProblem(t, w)
+	if body["request_id"] != "client-id-123" || w.Header().Get("X-Request-ID") != "client-id-123" {
+		t.Errorf("Expected the client request ID to be echoed, got %v / %q", body["request_id"], w.Header().Get("X-Request-ID"))
+	}
+
+	// Without a client ID one is generated
+	req, _ = http.NewRequest("GET", "/fail", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if id := w.Header().Get("X-Request-ID"); id == "" || decodeProblem(t, w)["request_id"] != id {
+		t.Errorf("Expected a generated request ID in header and body, got %q", id)
+	}
+}
+
+func TestRequestTimeoutMiddleware_ProblemBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIDMiddleware(), RequestTimeoutMiddleware(20*time.Millisecond))
+	r.GET("/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	req, _ := http.NewRequest("GET", "/slow", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	body := decodeProblem(t, w)
+	if w.Code != 504 || body["code"] != codeRequestTimeout || body["request_id"] == nil {
+		t.Errorf("Expected request_timeout problem, got %d: %v", w.Code, body)
+	}
+}
+
+func TestNoRouteAndNoMethod_Problems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(handleNoRoute)
+	r.NoMethod(handleNoMethod)
+	r.POST("/api/ai/summarize", handleSummarize)
+
+	req, _ := http.NewRequest("GET", "/nope", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if body := decodeProblem(t, w); w.Code != 404 || body["code"] != codeNotFound {
+		t.Errorf("Expected not_found problem, got %d: %v", w.Code, body)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/ai/summarize", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if body := decodeProblem(t, w); w.Code != 405 || body["code"] != codeMethodNotAllowed {
+		t.Errorf("Expected method_not_allowed problem, got %d: %v", w.Code, body)
+	}
+}
+
+func TestRecoverWithProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.CustomRecoveryWithWriter(nil, recoverWithProblem))
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if body := decodeProblem(t, w); w.Code != 500 || body["code"] != codeInternalError {
+		t.Errorf("Expected internal_error problem, got %d: %v", w.Code, body)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// promoCodeHeader names the promo code a paid request redeems.
+const promoCodeHeader = "X-Promo-Code"
+
+// Promo codes live in Redis when REDIS_URL is set, so every instance shares
+// the definitions and the usage counts: definitions in the promoCodesKey
+// hash, uses in a counter per code.
+const (
+	promoCodesKey      = "promo:codes"
+	promoUsesKeyPrefix = "promo:uses:"
+)
+
+// promoCodePattern is the shape of a code; codes are case-insensitive and
+// stored upper case.
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{1,64}$`)
+
+var promoRedemptionsTotal = newCounter(
+	"gateway_promo_redemptions_total",
+	"Requests carrying X-Promo-Code, by outcome (redeemed, unknown, expired, exhausted).",
+	"outcome",
+)
+
+// PromoCode is an admin-managed discount. Exactly one of PercentOff and
+// AmountOff is set; a discount that reaches the price waives it.
+type PromoCode struct {
+	Code       string     `json:"code" example:"LAUNCH50"`
+	PercentOff int        `json:"percent_off,omitempty" doc:"Percentage taken off the price, 1-100; 100 waives it" example:"50"`
+	AmountOff  string     `json:"amount_off,omitempty" doc:"Amount taken off the price, in USDC" example:"0.0005"`
+	MaxUses    int        `json:"max_uses,omitempty" doc:"Redemptions allowed; 0 for no cap" example:"1000"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" doc:"When the code stops being accepted; unset for never"`
+	Uses       int        `json:"uses" doc:"Paid requests that have redeemed the code"`
+}
+
+// PromoCodesResponse is the body of GET /admin/promo-codes.
+type PromoCodesResponse struct {
+	Codes []PromoCode `json:"codes"`
+}
+
+// PromoCodeDeleteResponse is the body of DELETE /admin/promo-codes/:code.
+type PromoCodeDeleteResponse struct {
+	Status string `json:"status" example:"deleted"`
+	Code   string `json:"code" example:"LAUNCH50"`
+}
+
+// normalizePromoCode returns code trimmed and upper case.
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validate checks a definition received through the admin API.
+func (p PromoCode) validate() error {
+	if !promoCodePattern.MatchString(p.Code) {
+		return errors.New("code must be 1-64 letters, digits, '-' or '_'")
+	}
+	if p.MaxUses < 0 {
+		return errors.New("max_uses must not be negative")
+	}
+	switch {
+	case p.PercentOff != 0 && p.AmountOff != "":
+		return errors.New("set either percent_off or amount_off, not both")
+	case p.PercentOff != 0:
+		if p.PercentOff < 1 || p.PercentOff > 100 {
+			return errors.New("percent_off must be between 1 and 100")
+		}
+	case p.AmountOff != "":
+		if amount, ok := parseDecimal(p.AmountOff); !ok || amount.Sign() <= 0 {
+			return errors.New("amount_off must be a positive decimal")
+		}
+	default:
+		return errors.New("set percent_off or amount_off")
+	}
+	return nil
+}
+
+// unavailable returns why p can't be redeemed at now, or "" when it can.
+func (p PromoCode) unavailable(now time.Time) string {
+	switch {
+	case p.ExpiresAt != nil && !now.Before(*p.ExpiresAt):
+		return "expired"
+	case p.MaxUses > 0 && p.Uses >= p.MaxUses:
+		return "exhausted"
+	}
+	return ""
+}
+
+// apply returns price after the discount and the amount taken off. Amounts
+// are assumed to be valid decimals; the result never goes below zero.
+func (p PromoCode) apply(price string) (amount, discount string) {
+	full, _ := new(big.Rat).SetString(price)
+	off := new(big.Rat)
+	if p.PercentOff != 0 {
+		off.Mul(full, big.NewRat(int64(p.PercentOff), 100))
+	} else {
+		off.SetString(p.AmountOff)
+	}
+	if off.Cmp(full) > 0 {
+		off.Set(full)
+	}
+	// Round the discounted price up, like every other price, and report
+	// what was actually taken off
+	amount = formatAmount(new(big.Rat).Sub(full, off))
+	charged, _ := new(big.Rat).SetString(amount)
+	return amount, formatAmount(new(big.Rat).Sub(full, charged))
+}
+
+// errPromoUnavailable is returned by promoStore.redeem for a code that has
+// expired or used up its redemptions.
+type errPromoUnavailable struct{ reason string }
+
+func (e *errPromoUnavailable) Error() string { return "promo code " + e.reason }
+
+// promoStore keeps promo codes and counts their redemptions.
+type promoStore interface {
+	list(ctx context.Context) ([]PromoCode, error)
+	// get returns the code, or nil when it isn't defined.
+	get(ctx context.Context, code string) (*PromoCode, error)
+	// put creates or replaces a definition, keeping its uses.
+	put(ctx context.Context, p PromoCode) error
+	// remove deletes a code and its uses, reporting whether it existed.
+	remove(ctx context.Context, code string) (bool, error)
+	// redeem counts one use of code, failing with *errPromoUnavailable
+	// when it has expired or reached MaxUses.
+	redeem(ctx context.Context, code string, now time.Time) error
+}
+
+func currentPromoStore() promoStore {
+	if redisClient != nil {
+		return redisPromoStore{client: redisClient}
+	}
+	return memoryPromoCodes
+}
+
+type memoryPromoStore struct {
+	mu    sync.Mutex
+	codes map[string]PromoCode
+}
+
+var memoryPromoCodes = &memoryPromoStore{codes: make(map[string]PromoCode)}
+
+func (s *memoryPromoStore) list(context.Context) ([]PromoCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	codes := make([]PromoCode, 0, len(s.codes))
+	for _, p := range s.codes {
+		codes = append(codes, p)
+	}
+	return codes, nil
+}
+
+func (s *memoryPromoStore) get(_ context.Context, code string) (*PromoCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.codes[code]; ok {
+		return &p, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryPromoStore) put(_ context.Context, p PromoCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Uses = s.codes[p.Code].Uses
+	s.codes[p.Code] = p
+	return nil
+}
+
+func (s *memoryPromoStore) remove(_ context.Context, code string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.codes[code]
+	delete(s.codes, code)
+	return ok, nil
+}
+
+func (s *memoryPromoStore) redeem(_ context.Context, code string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.codes[code]
+	if !ok {
+		return &errPromoUnavailable{"unknown"}
+	}
+	if reason := p.unavailable(now); reason != "" {
+		return &errPromoUnavailable{reason}
+	}
+	p.Uses++
+	s.codes[code] = p
+	return nil
+}
+
+type redisPromoStore struct {
+	client *redis.Client
+}
+
+func (s redisPromoStore) list(ctx context.Context) ([]PromoCode, error) {
+	defs, err := s.client.HGetAll(ctx, promoCodesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]PromoCode, 0, len(defs))
+	for code := range defs {
+		p, err := s.get(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			codes = append(codes, *p)
+		}
+	}
+	return codes, nil
+}
+
+func (s redisPromoStore) get(ctx context.Context, code string) (*PromoCode, error) {
+	raw, err := s.client.HGet(ctx, promoCodesKey, code).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p PromoCode
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, err
+	}
+	uses, err := s.client.Get(ctx, promoUsesKeyPrefix+code).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	p.Uses = uses
+	return &p, nil
+}
+
+func (s redisPromoStore) put(ctx context.Context, p PromoCode) error {
+	p.Uses = 0 // counted separately
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, promoCodesKey, p.Code, data).Err()
+}
+
+func (s redisPromoStore) remove(ctx context.Context, code string) (bool, error) {
+	n, err := s.client.HDel(ctx, promoCodesKey, code).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, s.client.Del(ctx, promoUsesKeyPrefix+code).Err()
+}
+
+// redeem counts the use first and takes it back when it went over the cap,
+// so concurrent redemptions on several instances never exceed MaxUses.
+func (s redisPromoStore) redeem(ctx context.Context, code string, now time.Time) error {
+	p, err := s.get(ctx, code)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return &errPromoUnavailable{"unknown"}
+	}
+	if reason := p.unavailable(now); reason != "" {
+		return &errPromoUnavailable{reason}
+	}
+	uses, err := s.client.Incr(ctx, promoUsesKeyPrefix+code).Result()
+	if err != nil {
+		return err
+	}
+	if p.MaxUses > 0 && uses > int64(p.MaxUses) {
+		s.client.Decr(ctx, promoUsesKeyPrefix+code)
+		return &errPromoUnavailable{"exhausted"}
+	}
+	return nil
+}
+
+// lookupPromoCode returns the code named by X-Promo-Code, nil when the
+// header is absent. A code that can't be redeemed answers 400
+// invalid_promo_code and returns ok false.
+func lookupPromoCode(c *gin.Context) (promo *PromoCode, ok bool) {
+	raw := c.GetHeader(promoCodeHeader)
+	if raw == "" {
+		return nil, true
+	}
+	code := normalizePromoCode(raw)
+	reason := "unknown"
+	if promoCodePattern.MatchString(code) {
+		p, err := currentPromoStore().get(c.Request.Context(), code)
+		if err != nil {
+			log.Printf("error looking up promo code %s: %v", code, err)
+			abortWithProblem(c, newProblem(500, codeInternalError, "Failed to look up the promo code", ""))
+			return nil, false
+		}
+		if p != nil {
+			if reason = p.unavailable(time.Now()); reason == "" {
+				return p, true
+			}
+		}
+	}
+	abortInvalidPromoCode(c, code, reason)
+	return nil, false
+}
+
+// abortInvalidPromoCode answers 400 invalid_promo_code with why code can't
+// be redeemed (unknown, expired, exhausted). The client can retry without
+// the header to pay the full price.
+func abortInvalidPromoCode(c *gin.Context, code, reason string) {
+	promoRedemptionsTotal.Inc(reason)
+	abortWithProblem(c, newProblem(400, codeInvalidPromoCode, "Bad Request",
+		fmt.Sprintf("Promo code %s can't be redeemed", strconv.Quote(code))).
+		With("promo_code", code).
+		With("reason", reason))
+}
+
+// redeemPromoCode counts a use of promo once the payment is verified,
+// answering 400 invalid_promo_code when another request took the last one
+// or the code expired in the meantime.
+func redeemPromoCode(c *gin.Context, promo *PromoCode) bool {
+	err := currentPromoStore().redeem(c.Request.Context(), promo.Code, time.Now())
+	var unavailable *errPromoUnavailable
+	switch {
+	case errors.As(err, &unavailable):
+		abortInvalidPromoCode(c, promo.Code, unavailable.reason)
+		return false
+	case err != nil:
+		log.Printf("error redeeming promo code %s: %v", promo.Code, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to redeem the promo code", ""))
+		return false
+	}
+	promoRedemptionsTotal.Inc("redeemed")
+	return true
+}
+
+// handleListPromoCodes handles GET /admin/promo-codes.
+func handleListPromoCodes(c *gin.Context) {
+	codes, err := currentPromoStore().list(c.Request.Context())
+	if err != nil {
+		log.Printf("error listing promo codes: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to list promo codes", err.Error()))
+		return
+	}
+	slices.SortFunc(codes, func(a, b PromoCode) int { return strings.Compare(a.Code, b.Code) })
+	c.JSON(200, PromoCodesResponse{Codes: codes})
+}
+
+// handlePutPromoCode handles PUT /admin/promo-codes/:code, creating the code
+// or replacing its terms. Redemptions already counted stay.
+func handlePutPromoCode(c *gin.Context) {
+	var p PromoCode
+	if err := json.NewDecoder(c.Request.Body).Decode(&p); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	p.Code = normalizePromoCode(c.Param("code"))
+	if err := p.validate(); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	store := currentPromoStore()
+	if err := store.put(c.Request.Context(), p); err != nil {
+		log.Printf("error saving promo code %s: %v", p.Code, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to save the promo code", err.Error()))
+		return
+	}
+	saved, err := store.get(c.Request.Context(), p.Code)
+	if err != nil || saved == nil {
+		saved = &p
+	}
+	details := map[string]string{"code": p.Code, "max_uses": strconv.Itoa(p.MaxUses)}
+	if p.PercentOff != 0 {
+		details["percent_off"] = strconv.Itoa(p.PercentOff)
+	} else {
+		details["amount_off"] = p.AmountOff
+	}
+	if p.ExpiresAt != nil {
+		details["expires_at"] = p.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	auditAdminAction(c, auditPromoCodeUpdated, details)
+	log.Printf("Admin saved promo code %s", p.Code)
+	c.JSON(200, saved)
+}
+
+// handleDeletePromoCode handles DELETE /admin/promo-codes/:code.
+func handleDeletePromoCode(c *gin.Context) {
+	code := normalizePromoCode(c.Param("code"))
+	existed, err := currentPromoStore().remove(c.Request.Context(), code)
+	if err != nil {
+		log.Printf("error deleting promo code %s: %v", code, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to delete the promo code", err.Error()))
+		return
+	}
+	if !existed {
+		abortWithProblem(c, newProblem(404, codePromoCodeNotFound, "Not Found", "No such promo code").
+			With("promo_code", code))
+		return
+	}
+	auditAdminAction(c, auditPromoCodeDeleted, map[string]string{"code": code})
+	log.Printf("Admin deleted promo code %s", code)
+	c.JSON(200, PromoCodeDeleteResponse{Status: "deleted", Code: code})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// setupTestPromoCodes empties the in-memory promo codes for the test.
+func setupTestPromoCodes(t *testing.T, codes ...PromoCode) {
+	t.Helper()
+	prev := memoryPromoCodes
+	memoryPromoCodes = &memoryPromoStore{codes: make(map[string]PromoCode)}
+	t.Cleanup(func() { memoryPromoCodes = prev })
+	for _, p := range codes {
+		memoryPromoCodes.put(t.Context(), p)
+	}
+}
+
+func TestPromoCode_Apply(t *testing.T) {
+	tests := []struct {
+		promo            PromoCode
+		price            string
+		amount, discount string
+	}{
+		{PromoCode{PercentOff: 50}, "0.001", "0.0005", "0.0005"},
+		{PromoCode{PercentOff: 100}, "0.001", "0", "0.001"},
+		{PromoCode{AmountOff: "0.0004"}, "0.001", "0.0006", "0.0004"},
+		{PromoCode{AmountOff: "5"}, "0.001", "0", "0.001"},
+		// The discounted price is rounded up to whole micro-units
+		{PromoCode{PercentOff: 50}, "0.000001", "0.000001", "0"},
+	}
+	for _, tt := range tests {
+		if amount, discount := tt.promo.apply(tt.price); amount != tt.amount || discount != tt.discount {
+			t.Errorf("%+v on %s: expected %s off to %s, got %s off to %s", tt.promo, tt.price, tt.discount, tt.amount, discount, amount)
+		}
+	}
+}
+
+func TestPromoStores_RedeemRespectsCapAndExpiry(t *testing.T) {
+	setupTestPromoCodes(t)
+	setupTestRedis(t)
+	past := time.Now().Add(-time.Minute)
+	for name, store := range map[string]promoStore{"memory": memoryPromoCodes, "redis": redisPromoStore{client: redisClient}} {
+		ctx := t.Context()
+		store.put(ctx, PromoCode{Code: "TWICE", PercentOff: 10, MaxUses: 2})
+		store.put(ctx, PromoCode{Code: "OLD", PercentOff: 10, ExpiresAt: &past})
+
+		for i := 0; i < 2; i++ {
+			if err := store.redeem(ctx, "TWICE", time.Now()); err != nil {
+				t.Fatalf("%s: redemption %d: %v", name, i+1, err)
+			}
+		}
+		if err := store.redeem(ctx, "TWICE", time.Now()); err == nil || err.Error() != "promo code exhausted" {
+			t.Errorf("%s: expected the third redemption to be refused, got %v", name, err)
+		}
+		// New terms keep the count
+		store.put(ctx, PromoCode{Code: "TWICE", PercentOff: 20, MaxUses: 3})
+		if p, _ := store.get(ctx, "TWICE"); p == nil || p.Uses != 2 || p.PercentOff != 20 {
+			t.Errorf("%s: expected the uses to survive an update, got %+v", name, p)
+		}
+		if err := store.redeem(ctx, "OLD", time.Now()); err == nil || err.Error() != "promo code expired" {
+			t.Errorf("%s: expected an expired code to be refused, got %v", name, err)
+		}
+		if err := store.redeem(ctx, "NONE", time.Now()); err == nil || err.Error() != "promo code unknown" {
+			t.Errorf("%s: expected an unknown code to be refused, got %v", name, err)
+		}
+		if existed, _ := store.remove(ctx, "TWICE"); !existed {
+			t.Errorf("%s: expected the code to be removed", name)
+		}
+		if codes, _ := store.list(ctx); len(codes) != 1 {
+			t.Errorf("%s: expected one code left, got %+v", name, codes)
+		}
+	}
+}
+
+func TestAdminPromoCodes(t *testing.T) {
+	setupTestPromoCodes(t)
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("PUT", "/admin/promo-codes/launch50", `{"percent_off":50,"max_uses":100}`)
+	var saved PromoCode
+	if err := json.Unmarshal(w.Body.Bytes(), &saved); err != nil || w.Code != http.StatusOK || saved.Code != "LAUNCH50" {
+		t.Fatalf("Expected the code to be saved upper case, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, body := range []string{`{}`, `{"percent_off":101}`, `{"percent_off":10,"amount_off":"0.1"}`, `{"amount_off":"-1"}`, `{"amount_off":"0x1"}`, `not json`} {
+		if w := send("PUT", "/admin/promo-codes/BAD", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w = send("GET", "/admin/promo-codes", "")
+	var list PromoCodesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Codes) != 1 || list.Codes[0].MaxUses != 100 {
+		t.Errorf("Expected the saved code to be listed, got %s", w.Body.String())
+	}
+
+	if w := send("DELETE", "/admin/promo-codes/Launch50", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the code to be deleted in any case, got %d", w.Code)
+	}
+	if w := send("DELETE", "/admin/promo-codes/LAUNCH50", ""); decodeProblem(t, w)["code"] != codePromoCodeNotFound {
+		t.Errorf("Expected promo_code_not_found, got %d", w.Code)
+	}
+}
+
+func TestHandleSummarize_PromoCode(t *testing.T) {
+	ensureTestServerKey(t)
+	ledger := setupTestLedger(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	setupTestPromoCodes(t,
+		PromoCode{Code: "HALF", PercentOff: 50, MaxUses: 1},
+		PromoCode{Code: "FREE", PercentOff: 100},
+	)
+	r := setupVersionedRouter()
+	text := "a text bought with a promo code"
+
+	send := func(promo, nonce, amount string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"`+text+`"}`))
+		req.Header.Set("X-Promo-Code", promo)
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+			data, _ := json.Marshal(PaymentContext{Recipient: defaultRecipientAddress, Token: "USDC", Amount: amount, Nonce: nonce, ChainID: 8453, BodyHash: paymentBodyHash(text)})
+			req.Header.Set("X-402-Payment", base64.StdEncoding.EncodeToString(data))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The challenge is discounted
+	p := decodeProblem(t, send("half", "", ""))
+	if payment, _ := p["paymentContext"].(map[string]interface{}); payment["amount"] != "0.001" || p["promo_code"] != "HALF" || p["discount"] != "0.001" {
+		t.Fatalf("Expected a discounted challenge, got %v", p)
+	}
+
+	// Paying the discounted price redeems the code and records it
+	if w := send("half", "n-promo-1", "0.001"); w.Code != http.StatusOK {
+		t.Fatalf("Expected the discounted payment to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	entries, _ := ledger.Entries(t.Context(), ledgerQuery{})
+	if len(entries) != 1 || entries[0].Amount != "0.001" || entries[0].PromoCode != "HALF" || entries[0].Discount != "0.001" {
+		t.Errorf("Expected the redemption in the ledger, got %+v", entries)
+	}
+
+	// The cap is reached
+	w := send("HALF", "n-promo-2", "0.001")
+	if p := decodeProblem(t, w); w.Code != http.StatusBadRequest || p["code"] != codeInvalidPromoCode || p["reason"] != "exhausted" {
+		t.Errorf("Expected 400 invalid_promo_code once used up, got %d %v", w.Code, p)
+	}
+	if w := send("NOPE", "", ""); decodeProblem(t, w)["reason"] != "unknown" {
+		t.Errorf("Expected an unknown code to be refused, got %d", w.Code)
+	}
+
+	// A waived request is signed for 0
+	calls := verifier.Calls()
+	if w := send("free", "n-promo-3", "0"); w.Code != http.StatusOK {
+		t.Fatalf("Expected the waived request to be served, got %d: %s", w.Code, w.Body.String())
+	}
+	if reqs := verifier.Requests(); verifier.Calls()-calls != 1 || reqs[len(reqs)-1].Context.Amount != "0" {
+		t.Errorf("Expected the wallet to sign for 0, got %+v", reqs)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// promptEndpoints are the uses a template can be selected for, each with
+// PROMPT_TEMPLATE_<ENDPOINT>: "summarize" builds the summarize request's
+// prompt (and the final step of a chunked summary), "chunk" the per-chunk
+// prompt of a chunked summary.
+var promptEndpoints = []string{"summarize", "chunk"}
+
+// promptTemplate is a named, versioned prompt. Text is a text/template
+// rendered with promptData. Bump Version whenever Text changes: the
+// summarize template's ID is part of the cache key, so results produced
+// with the old prompt are then no longer served.
+type promptTemplate struct {
+	Name    string
+	Version int
+	Text    string
+	builtin bool
+	tmpl    *template.Template
+}
+
+// promptData is what templates can refer to: {{.Text}}, {{.Language}} (the
+// output language's English name, or "") and for chunk templates {{.Part}}
+// and {{.Parts}}.
+type promptData struct {
+	Text     string
+	Language string
+	Part     int
+	Parts    int
+}
+
+// builtinPromptTemplates are used unless PROMPT_TEMPLATES_FILE overrides
+// them by name.
+var builtinPromptTemplates = map[string]*promptTemplate{
+	"summarize": mustPromptTemplate("summarize", 1, "Summarize this text in 2 sentences: {{.Text}}"),
+	"chunk": mustPromptTemplate("chunk", 1, "This is part {{.Part}} of {{.Parts}} of a longer document. "+
+		"Summarize this part in a few sentences, keeping key facts, names and numbers: {{.Text}}"),
+}
+
+func mustPromptTemplate(name string, version int, text string) *promptTemplate {
+	t, err := newPromptTemplate(name, version, text)
+	if err != nil {
+		panic(err)
+	}
+	t.builtin = true
+	return t
+}
+
+func newPromptTemplate(name string, version int, text string) (*promptTemplate, error) {
+	if version < 1 {
+		return nil, fmt.Errorf("template %q: version must be at least 1", name)
+	}
+	if !strings.Contains(text, ".Text") {
+		return nil, fmt.Errorf("template %q does not include {{.Text}}", name)
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", name, err)
+	}
+	// Render once so a reference to an unknown field fails at startup
+	if err := tmpl.Execute(new(strings.Builder), promptData{}); err != nil {
+		return nil, fmt.Errorf("template %q: %w", name, err)
+	}
+	return &promptTemplate{Name: name, Version: version, Text: text, tmpl: tmpl}, nil
+}
+
+// ID identifies the template and its version, e.g. "summarize@1".
+func (t *promptTemplate) ID() string {
+	return fmt.Sprintf("%s@%d", t.Name, t.Version)
+}
+
+// Render returns the prompt for data. When an output language is requested
+// and the template doesn't place {{.Language}} itself, an instruction to
+// write in that language is appended.
+func (t *promptTemplate) Render(data promptData) string {
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, data); err != nil {
+		// Templates are test-rendered when loaded, so this can't happen for
+		// well-formed data; fall back to sending the text unadorned.
+		log.Printf("error rendering prompt template %s: %v", t.ID(), err)
+		return data.Text
+	}
+	if data.Language != "" && !strings.Contains(t.Text, ".Language") {
+		fmt.Fprintf(&sb, "\n\nWrite the summary in %s, whatever the language of the text.", data.Language)
+	}
+	return sb.String()
+}
+
+// promptTemplateSet is the templates by name plus the one selected for each
+// endpoint.
+type promptTemplateSet struct {
+	byName     map[string]*promptTemplate
+	byEndpoint map[string]*promptTemplate
+}
+
+// loadPromptTemplates merges PROMPT_TEMPLATES_FILE over the built-in
+// templates and resolves PROMPT_TEMPLATE_<ENDPOINT> (default: the template
+// named after the endpoint). The file is YAML or TOML:
+//
+//	brief:
+//	  version: 2
+//	  text: "Summarize this text in one sentence: {{.Text}}"
+func loadPromptTemplates() (*promptTemplateSet, error) {
+	set := &promptTemplateSet{
+		byName:     maps.Clone(builtinPromptTemplates),
+		byEndpoint: make(map[string]*promptTemplate),
+	}
+
+	if path := os.Getenv("PROMPT_TEMPLATES_FILE"); path != "" {
+		defs, err := readPromptTemplatesFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("PROMPT_TEMPLATES_FILE: %w", err)
+		}
+		for name, def := range defs {
+			t, err := newPromptTemplate(name, def.Version, def.Text)
+			if err != nil {
+				return nil, fmt.Errorf("PROMPT_TEMPLATES_FILE: %w", err)
+			}
+			set.byName[name] = t
+		}
+	}
+
+	for _, endpoint := range promptEndpoints {
+		name := os.Getenv("PROMPT_TEMPLATE_" + strings.ToUpper(endpoint))
+		if name == "" {
+			name = endpoint
+		}
+		t, ok := set.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("PROMPT_TEMPLATE_%s: unknown template %q (known: %s)",
+				strings.ToUpper(endpoint), name, strings.Join(slices.Sorted(maps.Keys(set.byName)), ", "))
+		}
+		set.byEndpoint[endpoint] = t
+	}
+	return set, nil
+}
+
+// promptTemplateDef is one entry of PROMPT_TEMPLATES_FILE.
+type promptTemplateDef struct {
+	Version int    `yaml:"version" toml:"version"`
+	Text    string `yaml:"text" toml:"text"`
+}
+
+func readPromptTemplatesFile(path string) (map[string]promptTemplateDef, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs map[string]promptTemplateDef
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &defs)
+	case ".toml":
+		err = toml.Unmarshal(data, &defs)
+	default:
+		return nil, fmt.Errorf("unsupported file type %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return defs, nil
+}
+
+// getPromptTemplates returns the loaded templates. Before LoadConfig has run
+// (in tests) they are loaded from the environment on each call, falling
+// back to the built-ins if that fails.
+func getPromptTemplates() *promptTemplateSet {
+	if appConfig != nil && appConfig.PromptTemplates != nil {
+		return appConfig.PromptTemplates
+	}
+	set, err := loadPromptTemplates()
+	if err != nil {
+		log.Printf("Warning: Invalid prompt templates (%v), using the built-in templates", err)
+		set = &promptTemplateSet{byName: builtinPromptTemplates, byEndpoint: make(map[string]*promptTemplate)}
+		for _, endpoint := range promptEndpoints {
+			set.byEndpoint[endpoint] = builtinPromptTemplates[endpoint]
+		}
+	}
+	return set
+}
+
+// promptFor returns the template selected for endpoint.
+func promptFor(endpoint string) *promptTemplate {
+	return getPromptTemplates().byEndpoint[endpoint]
+}
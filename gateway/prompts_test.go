@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func writePromptTemplates(t *testing.T, name, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROMPT_TEMPLATES_FILE", path)
+}
+
+func TestPromptTemplates_Builtin(t *testing.T) {
+	t.Setenv("PROMPT_TEMPLATES_FILE", "")
+	if got := promptFor("summarize").Render(promptData{Text: "hello"}); got != "Summarize this text in 2 sentences: hello" {
+		t.Errorf("Unexpected summarize prompt %q", got)
+	}
+	if got := promptFor("chunk").Render(promptData{Text: "x", Part: 2, Parts: 3}); !strings.HasPrefix(got, "This is part 2 of 3 ") {
+		t.Errorf("Unexpected chunk prompt %q", got)
+	}
+	if id := promptFor("summarize").ID(); id != "summarize@1" {
+		t.Errorf("Expected summarize@1, got %s", id)
+	}
+}
+
+func TestPromptTemplates_FileAndSelection(t *testing.T) {
+	writePromptTemplates(t, "prompts.yaml", `
+brief:
+  version: 3
+  text: "Summarize in one sentence: {{.Text}}"
+`)
+	base := getCacheKey(context.Background(), "m", "text", nil)
+
+	t.Setenv("PROMPT_TEMPLATE_SUMMARIZE", "brief")
+	tmpl := promptFor("summarize")
+	if tmpl.ID() != "brief@3" || tmpl.Render(promptData{Text: "doc"}) != "Summarize in one sentence: doc" {
+		t.Errorf("Unexpected template %s: %q", tmpl.ID(), tmpl.Render(promptData{Text: "doc"}))
+	}
+	if getCacheKey(context.Background(), "m", "text", nil) == base {
+		t.Error("Expected the selected template to change the cache key")
+	}
+
+	t.Setenv("PROMPT_TEMPLATE_SUMMARIZE", "missing")
+	if _, err := loadPromptTemplates(); err == nil || !strings.Contains(err.Error(), "brief, chunk, summarize") {
+		t.Errorf("Expected an unknown template to list the known ones, got %v", err)
+	}
+}
+
+func TestPromptTemplates_TOMLOverridesBuiltin(t *testing.T) {
+	writePromptTemplates(t, "prompts.toml", `
+[summarize]
+version = 2
+text = "TL;DR: {{.Text}}"
+`)
+	tmpl := promptFor("summarize")
+	if tmpl.ID() != "summarize@2" || tmpl.builtin {
+		t.Errorf("Expected the file to replace the built-in summarize template, got %s", tmpl.ID())
+	}
+}
+
+func TestLoadPromptTemplates_Invalid(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"no text", "a:\n  version: 1\n  text: \"Summarize\"\n", "does not include {{.Text}}"},
+		{"no version", "a:\n  text: \"{{.Text}}\"\n", "version must be at least 1"},
+		{"unknown field", "a:\n  version: 1\n  text: \"{{.Text}} {{.Tone}}\"\n", "Tone"},
+		{"syntax", "a:\n  version: 1\n  text: \"{{.Text\"\n", "template \"a\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writePromptTemplates(t, "prompts.yml", tt.content)
+			if _, err := loadPromptTemplates(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCallAI_UsesSelectedTemplate(t *testing.T) {
+	writePromptTemplates(t, "prompts.yaml", "bullets:\n  version: 1\n  text: \"List the key points of: {{.Text}}\"\n")
+	t.Setenv("PROMPT_TEMPLATE_SUMMARIZE", "bullets")
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+
+	if _, err := callAI(context.Background(), "", "the article", summaryOptions{}); err != nil {
+		t.Fatalf("callAI failed: %v", err)
+	}
+	if got := ai.Requests()[0].Prompt(); got != "List the key points of: the article" {
+		t.Errorf("Expected the selected template, sent %q", got)
+	}
+
+	b := newMicroBatcher(10*time.Millisecond, 4, 500)
+	if b.eligible("short") {
+		t.Error("Expected no batching with a custom summarize template")
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aiProvider completes a single-message prompt. An empty model selects the
+// provider's own default.
+type aiProvider interface {
+	Complete(ctx context.Context, model, prompt string) (string, error)
+}
+
+// aiProviders are the providers AI_PROVIDER_CHAIN and MODEL_ROUTES may name.
+var aiProviders = map[string]aiProvider{
+	"openrouter": openRouterProvider{},
+	"openai":     openAIProvider{},
+	"anthropic":  anthropicProvider{},
+	"azure":      azureOpenAIProvider{},
+	"ollama":     ollamaProvider{},
+	"mock":       mockProvider{},
+}
+
+// providerAPIKeys are the credentials each provider needs, checked at
+// startup for every provider in the chain or the routing table.
+var providerAPIKeys = map[string]string{
+	"openrouter": "OPENROUTER_API_KEY",
+	"openai":     "OPENAI_API_KEY",
+	"anthropic":  "ANTHROPIC_API_KEY",
+	"azure":      "AZURE_OPENAI_API_KEY",
+}
+
+// modelRoute sends a model to a specific provider, optionally under the
+// provider's own name for it.
+type modelRoute struct {
+	Provider string
+	Model    string
+}
+
+// providerAttempt is one provider to try and the model to ask it for.
+type providerAttempt struct {
+	provider string
+	model    string
+}
+
+var aiProviderRequestsTotal = newCounter(
+	"gateway_ai_provider_requests_total",
+	"Upstream AI calls by provider and outcome (success, failover, error, circuit_open).",
+	"provider", "outcome",
+)
+
+// providerStatusError is returned when a provider answers with a non-2xx
+// status.
+type providerStatusError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	// RetryAfter is the provider's Retry-After header, if it sent one.
+	RetryAfter time.Duration
+}
+
+func (e *providerStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// newProviderStatusError reads a short excerpt of resp's body for the error.
+func newProviderStatusError(provider string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &providerStatusError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// chainIncludes reports whether provider is in the provider chain.
+func chainIncludes(provider string) bool {
+	return slices.Contains(getProviderChain(), provider)
+}
+
+// getProviderChain returns AI_PROVIDER_CHAIN (default "openrouter"), the
+// providers tried in order for every AI call.
+func getProviderChain() []string {
+	if appConfig != nil {
+		return appConfig.AIProviderChain
+	}
+	chain, err := parseProviderChain(os.Getenv("AI_PROVIDER_CHAIN"))
+	if err != nil {
+		log.Printf("Warning: Invalid AI_PROVIDER_CHAIN (%v), using openrouter", err)
+		return []string{"openrouter"}
+	}
+	return chain
+}
+
+// parseProviderChain parses a comma-separated list of provider names.
+func parseProviderChain(raw string) ([]string, error) {
+	var chain []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := aiProviders[name]; !ok {
+			return nil, fmt.Errorf("unknown provider %q", name)
+		}
+		chain = append(chain, name)
+	}
+	if len(chain) == 0 {
+		return []string{"openrouter"}, nil
+	}
+	return chain, nil
+}
+
+// parseModelRoutes parses MODEL_ROUTES:
+// "model=provider[:upstream-model],...". The model is split at the last '='
+// because model IDs may contain ':'; upstream-model defaults to the model.
+func parseModelRoutes(raw string) (map[string]modelRoute, error) {
+	routes := make(map[string]modelRoute)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("entry %q is not model=provider", entry)
+		}
+		model := strings.TrimSpace(entry[:i])
+		provider, upstream, _ := strings.Cut(strings.TrimSpace(entry[i+1:]), ":")
+		provider = strings.ToLower(provider)
+		if _, ok := aiProviders[provider]; !ok {
+			return nil, fmt.Errorf("unknown provider %q for model %q", provider, model)
+		}
+		if upstream == "" {
+			upstream = model
+		}
+		routes[model] = modelRoute{Provider: provider, Model: upstream}
+	}
+	return routes, nil
+}
+
+// getModelRoutes returns the MODEL_ROUTES routing table.
+func getModelRoutes() map[string]modelRoute {
+	if appConfig != nil {
+		return appConfig.ModelRoutes
+	}
+	routes, err := parseModelRoutes(os.Getenv("MODEL_ROUTES"))
+	if err != nil {
+		log.Printf("Warning: Invalid MODEL_ROUTES (%v), ignoring", err)
+		return nil
+	}
+	return routes
+}
+
+// providerAttempts lists the providers to try for model. A routed model goes
+// to its provider first and then fails over along the chain. Only OpenRouter
+// understands the gateway's model IDs, so the other chain providers are
+// asked for their own default model.
+func providerAttempts(model string) []providerAttempt {
+	var attempts []providerAttempt
+	route, routed := getModelRoutes()[model]
+	if routed {
+		attempts = append(attempts, providerAttempt{route.Provider, route.Model})
+	}
+	for _, name := range getProviderChain() {
+		if routed && name == route.Provider {
+			continue
+		}
+		attempt := providerAttempt{provider: name}
+		if name == "openrouter" {
+			attempt.model = model
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts
+}
+
+// callAIPrompt sends prompt to the first provider for model, retrying
+// transient errors on it first (see completeWithRetry). When it still
+// fails with a 5xx, a 429, a timeout or a connection error, the next
+// provider is tried within the caller's remaining deadline; each attempt
+// but the last gets an equal share of what is left, so a hung provider
+// still leaves time for its fallbacks. Providers whose circuit breaker is
+// open are skipped; errCircuitOpen is returned when none is left. The provider that answered is
+// recorded in ctx (see withProviderRecorder). With PII redaction enabled,
+// third-party providers get a redacted prompt and their reply has the
+// placeholders restored.
+func callAIPrompt(ctx context.Context, model, prompt string) (string, error) {
+	attempts := providerAttempts(model)
+	if aiBreakers != nil {
+		if attempts = aiBreakers.filter(attempts); len(attempts) == 0 {
+			return "", errCircuitOpen
+		}
+	}
+	// Redacted lazily, so a chain of local providers never pays for it
+	var redacted string
+	var restore func(string) string
+
+	var lastErr error
+	for i, attempt := range attempts {
+		name := attempt.provider
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok && i < len(attempts)-1 {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(attempts)-i))
+		}
+		var reply string
+		var err error
+		start := time.Now()
+		if piiRedactor == nil || localProviders[name] {
+			reply, err = completeWithRetry(attemptCtx, name, attempt.model, prompt)
+		} else {
+			if restore == nil {
+				redacted, restore = piiRedactor.Redact(prompt)
+			}
+			reply, err = completeWithRetry(attemptCtx, name, attempt.model, redacted)
+			reply = restore(reply)
+		}
+		cancel()
+		recordStage(ctx, stageProvider, time.Since(start))
+		if aiBreakers != nil {
+			aiBreakers.record(ctx, name, err, time.Since(start))
+		}
+		if err == nil {
+			providerLatency.observe(time.Since(start))
+			aiProviderRequestsTotal.Inc(name, "success")
+			recordProvider(ctx, name)
+			return reply, nil
+		}
+
+		lastErr = err
+		if i == len(attempts)-1 || !isFailoverError(ctx, err) {
+			aiProviderRequestsTotal.Inc(name, "error")
+			break
+		}
+		aiProviderRequestsTotal.Inc(name, "failover")
+		log.Printf("AI provider %s failed (%v), failing over to %s", name, err, attempts[i+1].provider)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", context.DeadlineExceeded
+	}
+	return "", lastErr
+}
+
+// isFailoverError reports whether err from a provider should be retried on
+// the next one. Nothing is retried once the caller's own context is done.
+func isFailoverError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *providerStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &urlErr)
+}
+
+type providerRecorderKey struct{}
+
+// providerRecorder captures which provider served the AI calls made with a
+// context, and the usage they reported, so the handler can report both
+// without threading them through every layer (cache, batcher, chunker).
+type providerRecorder struct {
+	mu    sync.Mutex
+	name  string
+	usage aiUsage
+}
+
+// withProviderRecorder returns a context whose AI calls are recorded in the
+// returned recorder.
+func withProviderRecorder(ctx context.Context) (context.Context, *providerRecorder) {
+	rec := &providerRecorder{}
+	return context.WithValue(ctx, providerRecorderKey{}, rec), rec
+}
+
+// recordProvider notes name on ctx's recorder, if any. The last call wins,
+// which for chunked summaries is the final reduce step.
+func recordProvider(ctx context.Context, name string) {
+	if rec, ok := ctx.Value(providerRecorderKey{}).(*providerRecorder); ok && name != "" {
+		rec.mu.Lock()
+		rec.name = name
+		rec.mu.Unlock()
+	}
+}
+
+// Name returns the recorded provider, or "" if no AI call was made.
+func (r *providerRecorder) Name() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.name
+}
+
+type openRouterProvider struct{}
+
+func (openRouterProvider) Complete(ctx context.Context, model, prompt string) (string, error) {
+	return callOpenRouterPrompt(ctx, model, prompt)
+}
+
+// mockProvider answers without calling anything, as a last resort that
+// keeps the product responding (clearly labelled) during an outage, and for
+// local development.
+type mockProvider struct{}
+
+func (mockProvider) Complete(context.Context, string, string) (string, error) {
+	return "Summary unavailable: the AI providers are temporarily unreachable, so this is a placeholder response.", nil
+}
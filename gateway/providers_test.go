@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// setupFakeOllama serves /api/chat with a fixed reply.
+func setupFakeOllama(t *testing.T, content string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": map[string]string{"content": content}})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("OLLAMA_URL", srv.URL)
+}
+
+func TestParseProviderChain(t *testing.T) {
+	chain, err := parseProviderChain(" OpenRouter, ollama ,mock")
+	if err != nil || strings.Join(chain, ",") != "openrouter,ollama,mock" {
+		t.Errorf("Expected openrouter,ollama,mock, got %v, %v", chain, err)
+	}
+	if chain, _ := parseProviderChain(""); strings.Join(chain, ",") != "openrouter" {
+		t.Errorf("Expected openrouter by default, got %v", chain)
+	}
+	if _, err := parseProviderChain("openrouter,bogus"); err == nil {
+		t.Error("Expected an unknown provider to be rejected")
+	}
+}
+
+func TestCallAIPrompt_FailsOver(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantReply  string
+		wantServer string
+		wantErr    bool
+	}{
+		{"5xx fails over", 503, "ollama reply", "ollama", false},
+		{"429 fails over", 429, "ollama reply", "ollama", false},
+		{"4xx does not fail over", 400, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ai := testsupport.NewFakeOpenRouter(t)
+			ai.FailWith(tt.status, `{"error":{"message":"upstream"}}`)
+			t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+			setupFakeOllama(t, "ollama reply")
+			t.Setenv("AI_PROVIDER_CHAIN", "openrouter,ollama")
+
+			ctx, rec := withProviderRecorder(context.Background())
+			reply, err := callAIPrompt(ctx, "", "prompt")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if reply != tt.wantReply || rec.Name() != tt.wantServer {
+				t.Errorf("Expected %q from %q, got %q from %q", tt.wantReply, tt.wantServer, reply, rec.Name())
+			}
+		})
+	}
+}
+
+func TestCallAIPrompt_FailsOverOnTimeoutWithinDeadline(t *testing.T) {
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Delay(5 * time.Second)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter,mock")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx, rec := withProviderRecorder(ctx)
+
+	start := time.Now()
+	if _, err := callAIPrompt(ctx, "", "prompt"); err != nil {
+		t.Fatalf("Expected the mock provider to answer, got %v", err)
+	}
+	if rec.Name() != "mock" {
+		t.Errorf("Expected mock to serve the request, got %q", rec.Name())
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Expected failover after about half the deadline, took %s", elapsed)
+	}
+}
+
+func TestHandleSummarize_MockPlaceholderFails(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.FailWith(502, "bad gateway")
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter,mock")
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"provider outage text"}`))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "nonce-provider")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if p := decodeProblem(t, w); w.Code != http.StatusServiceUnavailable || p["code"] != codeAIUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected the placeholder to fail as 503 ai_unavailable, got %d %v", w.Code, p)
+	}
+	if strings.Contains(w.Body.String(), "Summary unavailable") {
+		t.Error("Expected the placeholder not to be served")
+	}
+	if memoryCache.Len() != 0 {
+		t.Error("Expected the mock placeholder not to be cached")
+	}
+}
+
+func TestParseModelRoutes(t *testing.T) {
+	routes, err := parseModelRoutes("openai/gpt-4o=openai:gpt-4o, anthropic/claude-3.5-sonnet=anthropic:claude-3-5-sonnet-latest, local:big=ollama:llama3.1:70b, gpt-4o-mini=OpenAI")
+	if err != nil {
+		t.Fatalf("parseModelRoutes failed: %v", err)
+	}
+	want := map[string]modelRoute{
+		"openai/gpt-4o":               {"openai", "gpt-4o"},
+		"anthropic/claude-3.5-sonnet": {"anthropic", "claude-3-5-sonnet-latest"},
+		"local:big":                   {"ollama", "llama3.1:70b"},
+		"gpt-4o-mini":                 {"openai", "gpt-4o-mini"},
+	}
+	for model, route := range want {
+		if routes[model] != route {
+			t.Errorf("Expected %s -> %+v, got %+v", model, route, routes[model])
+		}
+	}
+	for _, raw := range []string{"model=bogus", "no-provider", "=openai"} {
+		if _, err := parseModelRoutes(raw); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestProviderAttempts(t *testing.T) {
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter,ollama")
+	t.Setenv("MODEL_ROUTES", "openai/gpt-4o=openai:gpt-4o")
+
+	got := providerAttempts("openai/gpt-4o")
+	want := []providerAttempt{{"openai", "gpt-4o"}, {"openrouter", "openai/gpt-4o"}, {"ollama", ""}}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Attempt %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	if got := providerAttempts("other/model"); len(got) != 2 || got[0] != (providerAttempt{"openrouter", "other/model"}) {
+		t.Errorf("Expected unrouted models to follow the chain, got %v", got)
+	}
+}
+
+func TestLoadConfig_RoutedProviderNeedsKey(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test")
+	t.Setenv("AI_PROVIDER_CHAIN", "")
+	t.Setenv("MODEL_ROUTES", "claude=anthropic")
+	t.Setenv("ANTHROPIC_API_KEY", "")
+
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "ANTHROPIC_API_KEY is required") {
+		t.Errorf("Expected ANTHROPIC_API_KEY to be required, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SummarizeQuote is the body of POST /v1/ai/summarize/quote: the price of a
+// request body, and the payment context to sign for it.
+type SummarizeQuote struct {
+	Price          string         `json:"price" doc:"What the request costs, in token; the same as paymentContext.amount" example:"0.0015"`
+	Token          string         `json:"token" example:"USDC"`
+	Tokens         int            `json:"tokens" doc:"Input tokens counted in the text, which per-token prices are based on" example:"412"`
+	Model          string         `json:"model" doc:"Model the price is for" example:"openai/gpt-4o-mini"`
+	OutputLanguage string         `json:"output_language,omitempty" doc:"Output language the price includes the surcharge for" example:"es"`
+	Bytes          int            `json:"bytes" doc:"Size of the text in bytes of UTF-8, which size bands are based on" example:"2048"`
+	SizeBand       string         `json:"size_band,omitempty" doc:"Size band the price is for, with size_bands pricing" example:"100KB"`
+	Pricing        string         `json:"pricing" doc:"flat, size_bands or per_1k_tokens" example:"flat"`
+	PromoCode      string         `json:"promo_code,omitempty" doc:"Promo code applied to the price, present when X-Promo-Code was sent" example:"LAUNCH50"`
+	Discount       string         `json:"discount,omitempty" doc:"Amount the promo code took off the price" example:"0.0005"`
+	LoadMultiplier string         `json:"load_multiplier,omitempty" doc:"Factor the price was raised by for the gateway's current load, with LOAD_PRICING" example:"1.5"`
+	Chains         []ChainOption  `json:"chains" doc:"Chains the payment can be made on"`
+	PaymentContext PaymentContext `json:"paymentContext" doc:"The payment to sign for this body; its nonce holds the price until expires_at"`
+	ExpiresAt      time.Time      `json:"expires_at" doc:"When the nonce in paymentContext stops being accepted"`
+	RecipientName  string         `json:"recipient_name,omitempty" doc:"ENS name paymentContext.recipient was resolved from, when RECIPIENT_ADDRESS is one" example:"paygate.eth"`
+}
+
+// ChainOption is a chain a payment can be made on.
+type ChainOption struct {
+	ChainID   int    `json:"chain_id" example:"8453"`
+	Network   string `json:"network" doc:"x402 network name" example:"base"`
+	Token     string `json:"token" example:"USDC"`
+	Asset     string `json:"asset,omitempty" doc:"Token contract, when known" example:"0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913"`
+	Recipient string `json:"recipient" doc:"Address payments are made to" example:"0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"`
+}
+
+// paymentChains returns the chains ctx's request can be paid on. The
+// gateway takes payments on CHAIN_ID only.
+func paymentChains(ctx context.Context) []ChainOption {
+	chainID := getChainID()
+	network, asset := chainNetwork(chainID)
+	return []ChainOption{{ChainID: chainID, Network: network, Token: getPaymentToken(), Asset: asset, Recipient: recipientFor(ctx)}}
+}
+
+// quoteSummarize prices req for route: a payment context with a fresh
+// nonce, priced for the model and the text's size and token count, raised
+// for the load and discounted by promo. When the model or output language isn't
+// offered it has answered 422 and returns false.
+func quoteSummarize(c *gin.Context, route string, req SummarizeRequest, promo *PromoCode) (SummarizeQuote, bool) {
+	model, err := checkModelAllowed(req.Model)
+	if err != nil {
+		abortWithProblem(c, modelNotAllowedProblem(err.(*modelNotAllowedError)))
+		return SummarizeQuote{}, false
+	}
+	language, err := checkOutputLanguage(req.OutputLanguage)
+	if err != nil {
+		abortWithProblem(c, unsupportedLanguageProblem(err.(*unsupportedLanguageError)))
+		return SummarizeQuote{}, false
+	}
+	ctx := c.Request.Context()
+	paymentContext, expiresAt := createPaymentContext(ctx, route)
+	tokens := countTokens(req.Text)
+	load := nonceLoad(paymentContext.Nonce)
+	paymentContext.Amount = applyLoad(priceFor(ctx, model, len(req.Text), tokens, summaryOptions{OutputLanguage: language}), load)
+	pluginReq := newPluginRequest(c, req, model, language, tokens, paymentContext.Amount)
+	pluginReq.Quote = true
+	if !runPreVerifyHooks(c, pluginReq) {
+		return SummarizeQuote{}, false
+	}
+	paymentContext.Amount = pluginReq.Price
+	if bindPaymentToBody() {
+		paymentContext.BodyHash = paymentBodyHash(req.Text)
+	}
+	quote := SummarizeQuote{
+		Token:          paymentContext.Token,
+		Tokens:         tokens,
+		Bytes:          len(req.Text),
+		Model:          model,
+		OutputLanguage: language,
+		Pricing:        pricingScheme(ctx),
+		LoadMultiplier: formatLoad(load),
+		ExpiresAt:      expiresAt.UTC(),
+		RecipientName:  recipientNameFor(ctx),
+	}
+	if band, ok := sizeBandFor(ctx, len(req.Text)); ok && (modelBasePrice(model) == "" || pricePer1KTokensFor(ctx) != "") {
+		quote.SizeBand = band.Below
+	}
+	if promo != nil {
+		paymentContext.Amount, quote.Discount = promo.apply(paymentContext.Amount)
+		quote.PromoCode = promo.Code
+	}
+	quote.Price, quote.PaymentContext = paymentContext.Amount, paymentContext
+	return quote, true
+}
+
+// handleSummarizeQuote handles POST /v1/ai/summarize/quote: it prices the
+// body as POST /v1/ai/summarize would, without verifying or charging
+// anything, so clients can show the price before asking for a signature.
+// The payment context it returns can be signed and sent with the same body.
+func handleSummarizeQuote(c *gin.Context) {
+	promo, ok := lookupPromoCode(c)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSummarizeBodyBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			abortWithProblem(c, newProblem(413, codePayloadTooLarge, "Payload Too Large", "Request body exceeds 10MB").
+				With("max_size", "10MB"))
+		} else {
+			abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read request body", ""))
+		}
+		return
+	}
+	req, err := decodeSummarizeRequest(c, body)
+	if err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	if v := validateText(req.Text); v != nil {
+		rejectText(c, v)
+		return
+	}
+	quote, ok := quoteSummarize(c, summarizeRoute, req, promo)
+	if !ok {
+		return
+	}
+	quote.Chains = paymentChains(c.Request.Context())
+	c.JSON(200, quote)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func TestHandleSummarizeQuote(t *testing.T) {
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("CHAIN_ID", "8453")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_PER_1K_TOKENS", "0.002")
+	r := setupVersionedRouter()
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)
+	body := `{"text":` + strconv.Quote(text) + `}`
+
+	quote := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := quote("/v1/ai/summarize/quote", body)
+	var q SummarizeQuote
+	if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected a quote, got %d: %s", w.Code, w.Body.String())
+	}
+	tokens := countTokens(text)
+	if q.Tokens != tokens || q.Pricing != "per_1k_tokens" || q.Price != priceFor(context.Background(), q.Model, len(text), tokens, summaryOptions{}) || q.Price != q.PaymentContext.Amount {
+		t.Errorf("Expected the per-token price for %d tokens, got %+v", tokens, q)
+	}
+	if len(q.Chains) != 1 || q.Chains[0].ChainID != 8453 || q.Chains[0].Network != "base" || q.Chains[0].Token != "USDC" || q.Chains[0].Asset == "" {
+		t.Errorf("Expected Base USDC as the chain option, got %+v", q.Chains)
+	}
+	if checkNonce(q.PaymentContext.Nonce, summarizeRoute, time.Now()) != "" || q.PaymentContext.BodyHash != paymentBodyHash(text) {
+		t.Errorf("Expected a payment context ready to sign, got %+v", q.PaymentContext)
+	}
+	if verifier.Calls() != 0 || len(ai.Requests()) != 0 {
+		t.Error("Expected a quote to call neither the verifier nor the AI provider")
+	}
+
+	// The quoted context pays for the same body
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(body))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", q.PaymentContext.Nonce)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || verifier.Requests()[0].Context.Amount != q.Price {
+		t.Errorf("Expected the request paid at the quoted price, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := quote("/api/ai/summarize/quote", body); w.Code != http.StatusOK {
+		t.Errorf("Expected the quote on the legacy alias, got %d", w.Code)
+	}
+	if w := quote("/v1/ai/summarize/quote", `{"text":"  "}`); w.Code != http.StatusUnprocessableEntity || decodeProblem(t, w)["code"] != codeInvalidText {
+		t.Errorf("Expected 422 invalid_text for blank text, got %d", w.Code)
+	}
+	if w := quote("/v1/ai/summarize/quote", `{"text":`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for malformed JSON, got %d", w.Code)
+	}
+}
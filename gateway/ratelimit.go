@@ -16,12 +16,28 @@ type RateLimiter interface {
 	GetRemaining(key string) int
 	// GetResetTime returns the Unix timestamp when the bucket will be fully refilled
 	GetResetTime(key string) int64
+	// Buckets returns the state of every active bucket
+	Buckets() []RateLimitBucket
+}
+
+// RateLimitBucket is the state of one client's bucket, for
+// GET /admin/ratelimits.
+type RateLimitBucket struct {
+	Key      string    `json:"key" doc:"ip:<address>, nonce:<hash> for signed requests, or wallet:<address> for a wallet override" example:"ip:203.0.113.7"`
+	Tier     string    `json:"tier" doc:"anonymous, standard or verified; tenant:<id> for a tenant's own limit; override for an operator override" example:"anonymous"`
+	Tokens   int       `json:"tokens" doc:"Requests the client may make right now" example:"0"`
+	Limit    int       `json:"limit" doc:"Requests per minute" example:"10"`
+	Burst    int       `json:"burst" example:"5"`
+	Reset    int64     `json:"reset" doc:"Unix time when the bucket is full again" example:"1767225600"`
+	Rejected int       `json:"rejected" doc:"Requests refused with 429 since the bucket was created" example:"12"`
+	LastSeen time.Time `json:"last_seen"`
 }
 
 // bucket represents a single token bucket for a user/IP
 type bucket struct {
 	tokens    float64   // Current number of tokens
 	lastCheck time.Time // Last time tokens were refilled
+	rejected  int       // Requests refused since the bucket was created
 	mu        sync.Mutex
 }
 
@@ -95,6 +111,7 @@ func (tb *TokenBucket) AllowN(key string, n int) bool {
 		return true
 	}
 
+	b.rejected++
 	return false
 }
 
@@ -142,6 +159,31 @@ func (tb *TokenBucket) GetResetTime(key string) int64 {
 	return resetTime.Unix()
 }
 
+// Buckets returns the state of every bucket not yet cleaned up, with Tier
+// left for the caller to fill in.
+func (tb *TokenBucket) Buckets() []RateLimitBucket {
+	now := time.Now()
+	var buckets []RateLimitBucket
+	tb.buckets.Range(func(key, value interface{}) bool {
+		b := value.(*bucket)
+		b.mu.Lock()
+		tokens := math.Min(float64(tb.burst), b.tokens+now.Sub(b.lastCheck).Seconds()*tb.rate)
+		state := RateLimitBucket{
+			Key:      key.(string),
+			Tokens:   int(math.Floor(tokens)),
+			Limit:    int(math.Round(tb.rate * 60)),
+			Burst:    tb.burst,
+			Reset:    now.Add(time.Duration((float64(tb.burst) - tokens) / tb.rate * float64(time.Second))).Unix(),
+			Rejected: b.rejected,
+			LastSeen: b.lastCheck,
+		}
+		b.mu.Unlock()
+		buckets = append(buckets, state)
+		return true
+	})
+	return buckets
+}
+
 // cleanup runs in a background goroutine to remove stale buckets
 // This prevents memory leaks from inactive users
 func (tb *TokenBucket) Stop() {
@@ -172,3 +214,60 @@ func (tb *TokenBucket) cleanup() {
 		}
 	}
 }
+
+// customRateLimiters holds a token bucket per named limit that replaces
+// the tiers, such as a tenant's or an operator override, rebuilt when the
+// limit changes.
+type customRateLimiters struct {
+	cleanupTTL time.Duration
+
+	mu       sync.Mutex
+	limiters map[string]*customRateLimiter
+}
+
+type customRateLimiter struct {
+	rpm, burst int
+	bucket     *TokenBucket
+}
+
+func newCustomRateLimiters(cleanupTTL time.Duration) *customRateLimiters {
+	return &customRateLimiters{cleanupTTL: cleanupTTL, limiters: make(map[string]*customRateLimiter)}
+}
+
+// get returns the limiter named name, allowing rpm requests per minute.
+func (l *customRateLimiters) get(name string, rpm, burst int) RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.limiters[name]
+	if current == nil || current.rpm != rpm || current.burst != burst {
+		if current != nil {
+			current.bucket.Stop()
+		}
+		current = &customRateLimiter{rpm: rpm, burst: burst, bucket: NewTokenBucket(rpm, burst, l.cleanupTTL)}
+		l.limiters[name] = current
+	}
+	return current.bucket
+}
+
+// remove drops the limiter named name, if any.
+func (l *customRateLimiters) remove(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if current := l.limiters[name]; current != nil {
+		current.bucket.Stop()
+		delete(l.limiters, name)
+	}
+}
+
+// each calls fn with every limiter by name.
+func (l *customRateLimiters) each(fn func(name string, limiter RateLimiter)) {
+	l.mu.Lock()
+	limiters := make(map[string]RateLimiter, len(l.limiters))
+	for name, current := range l.limiters {
+		limiters[name] = current.bucket
+	}
+	l.mu.Unlock()
+	for name, limiter := range limiters {
+		fn(name, limiter)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Rate limit overrides live in the rateLimitOverridesKey hash when
+// REDIS_URL is set, so every instance applies the same ones.
+const rateLimitOverridesKey = "ratelimit:overrides"
+
+// Overrides are keyed like the buckets they replace; tenant limiters are
+// named after the tenant.
+const (
+	ipOverridePrefix      = "ip:"
+	walletOverridePrefix  = "wallet:"
+	tenantRateLimitPrefix = "tenant:"
+)
+
+// rateLimitSet is what the rate-limit middleware of the running router
+// limits with.
+type rateLimitSet struct {
+	tiers  map[string]RateLimiter
+	custom *customRateLimiters
+}
+
+// activeRateLimits is nil while rate limiting is disabled.
+var activeRateLimits *rateLimitSet
+
+// buckets returns every bucket whose key starts with prefix, labelled
+// with its tier, the emptiest first.
+func (s *rateLimitSet) buckets(prefix string) []RateLimitBucket {
+	var buckets []RateLimitBucket
+	add := func(tier string, limiter RateLimiter) {
+		for _, b := range limiter.Buckets() {
+			if strings.HasPrefix(b.Key, prefix) {
+				b.Tier = tier
+				buckets = append(buckets, b)
+			}
+		}
+	}
+	for tier, limiter := range s.tiers {
+		add(tier, limiter)
+	}
+	s.custom.each(func(name string, limiter RateLimiter) {
+		if strings.HasPrefix(name, tenantRateLimitPrefix) {
+			add(name, limiter)
+		} else {
+			add("override", limiter)
+		}
+	})
+	slices.SortFunc(buckets, func(a, b RateLimitBucket) int {
+		return cmp.Or(cmp.Compare(a.Tokens, b.Tokens), strings.Compare(a.Key, b.Key), strings.Compare(a.Tier, b.Tier))
+	})
+	return buckets
+}
+
+// RateLimitOverride is an operator's limit for one wallet or IP, replacing
+// its tier (and tenant) limit.
+type RateLimitOverride struct {
+	Key       string     `json:"key" doc:"wallet:<address> or ip:<address>, set from wallet or ip" example:"wallet:0x2caf48b4ba1c58721a85dfada5ac01c2dfa62219"`
+	Wallet    string     `json:"wallet,omitempty" doc:"Wallet whose paid requests get the limit, once their payment is verified; set either wallet or ip" example:"0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"`
+	IP        string     `json:"ip,omitempty" doc:"Client IP whose requests get the limit" example:"203.0.113.7"`
+	RPM       int        `json:"rpm" doc:"Requests per minute" example:"600"`
+	Burst     int        `json:"burst,omitempty" doc:"Burst allowed; defaults to rpm" example:"100"`
+	Reason    string     `json:"reason,omitempty" doc:"Why the override was set, for whoever finds it later" example:"Ticket 4821: batch import"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" doc:"When the override stops applying; unset for never"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// RateLimitsResponse is the body of GET /admin/ratelimits.
+type RateLimitsResponse struct {
+	Buckets   []RateLimitBucket   `json:"buckets"`
+	Overrides []RateLimitOverride `json:"overrides"`
+}
+
+// RateLimitOverrideDeleteResponse is the body of
+// DELETE /admin/ratelimits/overrides/:key.
+type RateLimitOverrideDeleteResponse struct {
+	Status string `json:"status" example:"deleted"`
+	Key    string `json:"key" example:"ip:203.0.113.7"`
+}
+
+// normalize validates an override received through the admin API and
+// sets its Key.
+func (o *RateLimitOverride) normalize() error {
+	switch {
+	case (o.Wallet == "") == (o.IP == ""):
+		return errors.New("set either wallet or ip")
+	case o.Wallet != "":
+		if !strings.HasPrefix(o.Wallet, "0x") || !common.IsHexAddress(o.Wallet) {
+			return errors.New("wallet must be a 0x-prefixed address")
+		}
+		o.Key = walletOverridePrefix + strings.ToLower(o.Wallet)
+	default:
+		addr, err := netip.ParseAddr(o.IP)
+		if err != nil {
+			return errors.New("ip must be an IPv4 or IPv6 address")
+		}
+		o.IP = addr.Unmap().String()
+		o.Key = ipOverridePrefix + o.IP
+	}
+	if o.RPM < 1 || o.Burst < 0 {
+		return errors.New("rpm must be at least 1 and burst must not be negative")
+	}
+	return nil
+}
+
+// burst returns the override's burst, which defaults to its rpm.
+func (o *RateLimitOverride) burst() int {
+	if o.Burst == 0 {
+		return o.RPM
+	}
+	return o.Burst
+}
+
+// rateLimitOverrideStore keeps the overrides by key.
+type rateLimitOverrideStore interface {
+	list(ctx context.Context) ([]RateLimitOverride, error)
+	put(ctx context.Context, o RateLimitOverride) error
+	// remove deletes an override, reporting whether it existed.
+	remove(ctx context.Context, key string) (bool, error)
+}
+
+func currentRateLimitOverrideStore() rateLimitOverrideStore {
+	if redisClient != nil {
+		return redisRateLimitOverrideStore{client: redisClient}
+	}
+	return memoryRateLimitOverrides
+}
+
+type memoryRateLimitOverrideStore struct {
+	mu        sync.Mutex
+	overrides map[string]RateLimitOverride
+}
+
+var memoryRateLimitOverrides = &memoryRateLimitOverrideStore{overrides: make(map[string]RateLimitOverride)}
+
+func (s *memoryRateLimitOverrideStore) list(context.Context) ([]RateLimitOverride, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	overrides := make([]RateLimitOverride, 0, len(s.overrides))
+	for _, o := range s.overrides {
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+func (s *memoryRateLimitOverrideStore) put(_ context.Context, o RateLimitOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[o.Key] = o
+	return nil
+}
+
+func (s *memoryRateLimitOverrideStore) remove(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.overrides[key]
+	delete(s.overrides, key)
+	return ok, nil
+}
+
+type redisRateLimitOverrideStore struct {
+	client *redis.Client
+}
+
+func (s redisRateLimitOverrideStore) list(ctx context.Context) ([]RateLimitOverride, error) {
+	raw, err := s.client.HGetAll(ctx, rateLimitOverridesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	overrides := make([]RateLimitOverride, 0, len(raw))
+	for key, data := range raw {
+		var o RateLimitOverride
+		if err := json.Unmarshal([]byte(data), &o); err != nil {
+			return nil, fmt.Errorf("override %s: %w", key, err)
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+func (s redisRateLimitOverrideStore) put(ctx context.Context, o RateLimitOverride) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, rateLimitOverridesKey, o.Key, data).Err()
+}
+
+func (s redisRateLimitOverrideStore) remove(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.HDel(ctx, rateLimitOverridesKey, key).Result()
+	return n > 0, err
+}
+
+// rateLimitOverrideSet looks overrides up for every request, from a
+// snapshot of the store. With Redis the snapshot is reloaded every
+// RATE_LIMIT_OVERRIDE_RELOAD_INTERVAL_SECONDS (default 10), so every
+// instance follows changes made through any of them.
+type rateLimitOverrideSet struct {
+	mu        sync.RWMutex
+	overrides map[string]*RateLimitOverride
+}
+
+var rateLimitOverrides = &rateLimitOverrideSet{}
+
+// reload replaces the snapshot with the store's overrides.
+func (s *rateLimitOverrideSet) reload(ctx context.Context) error {
+	overrides, err := currentRateLimitOverrideStore().list(ctx)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]*RateLimitOverride, len(overrides))
+	for _, o := range overrides {
+		byKey[o.Key] = &o
+	}
+	s.mu.Lock()
+	s.overrides = byKey
+	s.mu.Unlock()
+	return nil
+}
+
+// lookup returns the override for key in force at now, or nil.
+func (s *rateLimitOverrideSet) lookup(key string, now time.Time) *RateLimitOverride {
+	s.mu.RLock()
+	o := s.overrides[key]
+	s.mu.RUnlock()
+	if o == nil || (o.ExpiresAt != nil && !now.Before(*o.ExpiresAt)) {
+		return nil
+	}
+	return o
+}
+
+// start reloads the snapshot in the background when the overrides are
+// shared through Redis.
+func (s *rateLimitOverrideSet) start(ctx context.Context) {
+	if redisClient == nil {
+		return
+	}
+	interval := time.Duration(getEnvAsInt("RATE_LIMIT_OVERRIDE_RELOAD_INTERVAL_SECONDS", 10)) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.reload(ctx); err != nil {
+					log.Printf("Warning: rate limit override reload failed, keeping the current overrides: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// allowWalletRequest applies wallet's override, if it has one, or the
+// anonymous tier when it has a low reputation, to a paid request whose
+// payment was verified. Like RateLimitMiddleware it answers
+// 429 rate_limited and returns false when the wallet is over its limit.
+func allowWalletRequest(c *gin.Context, wallet string) bool {
+	if activeRateLimits == nil {
+		return true
+	}
+	o := rateLimitOverrides.lookup(walletOverridePrefix+strings.ToLower(wallet), time.Now())
+	if o == nil {
+		return allowLowReputation(c, wallet)
+	}
+	if !applyRateLimit(c, activeRateLimits.custom.get(o.Key, o.RPM, o.burst()), o.Key, o.RPM) {
+		walletReputation.record(wallet, signalRateLimited)
+		return false
+	}
+	return true
+}
+
+// requireRateLimiting answers 503 rate_limiting_disabled unless
+// RATE_LIMIT_ENABLED=true.
+func requireRateLimiting(c *gin.Context) bool {
+	if activeRateLimits == nil {
+		abortWithProblem(c, newProblem(503, codeRateLimitingDisabled, "Service Unavailable", "Rate limiting is not enabled (RATE_LIMIT_ENABLED)"))
+		return false
+	}
+	return true
+}
+
+// handleListRateLimits handles GET /admin/ratelimits: this instance's
+// active buckets, optionally those whose key starts with ?key=, and the
+// overrides.
+func handleListRateLimits(c *gin.Context) {
+	if !requireRateLimiting(c) {
+		return
+	}
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > 1000 {
+			abortInvalidQuery(c, "limit", "limit must be an integer from 1 to 1000")
+			return
+		}
+	}
+	overrides, err := currentRateLimitOverrideStore().list(c.Request.Context())
+	if err != nil {
+		log.Printf("error listing rate limit overrides: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to list rate limit overrides", err.Error()))
+		return
+	}
+	slices.SortFunc(overrides, func(a, b RateLimitOverride) int { return strings.Compare(a.Key, b.Key) })
+
+	buckets := activeRateLimits.buckets(c.Query("key"))
+	if len(buckets) > limit {
+		buckets = buckets[:limit]
+	}
+	if buckets == nil {
+		buckets = []RateLimitBucket{}
+	}
+	c.JSON(200, RateLimitsResponse{Buckets: buckets, Overrides: overrides})
+}
+
+// handlePutRateLimitOverride handles PUT /admin/ratelimits/overrides,
+// creating or replacing the override for a wallet or IP.
+func handlePutRateLimitOverride(c *gin.Context) {
+	if !requireRateLimiting(c) {
+		return
+	}
+	var o RateLimitOverride
+	if err := json.NewDecoder(c.Request.Body).Decode(&o); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	if err := o.normalize(); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	o.UpdatedAt = time.Now().UTC()
+
+	ctx := c.Request.Context()
+	if err := currentRateLimitOverrideStore().put(ctx, o); err != nil {
+		log.Printf("error saving rate limit override %s: %v", o.Key, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to save the rate limit override", err.Error()))
+		return
+	}
+	if err := rateLimitOverrides.reload(ctx); err != nil {
+		log.Printf("Warning: rate limit override reload failed: %v", err)
+	}
+	details := map[string]string{"key": o.Key, "rpm": strconv.Itoa(o.RPM), "burst": strconv.Itoa(o.burst()), "reason": o.Reason}
+	if o.ExpiresAt != nil {
+		details["expires_at"] = o.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	auditAdminAction(c, auditRateLimitOverrideUpdated, details)
+	log.Printf("Admin set rate limit override %s (%d rpm)", o.Key, o.RPM)
+	c.JSON(200, o)
+}
+
+// handleDeleteRateLimitOverride handles
+// DELETE /admin/ratelimits/overrides/:key; the wallet or IP goes back to
+// its tier's limit.
+func handleDeleteRateLimitOverride(c *gin.Context) {
+	if !requireRateLimiting(c) {
+		return
+	}
+	key := c.Param("key")
+	ctx := c.Request.Context()
+	found, err := currentRateLimitOverrideStore().remove(ctx, key)
+	if err != nil {
+		log.Printf("error deleting rate limit override %s: %v", key, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to delete the rate limit override", err.Error()))
+		return
+	}
+	if !found {
+		abortWithProblem(c, newProblem(404, codeRateLimitOverrideNotFound, "Not Found", "No rate limit override for "+key).
+			With("key", key))
+		return
+	}
+	if err := rateLimitOverrides.reload(ctx); err != nil {
+		log.Printf("Warning: rate limit override reload failed: %v", err)
+	}
+	activeRateLimits.custom.remove(key)
+	auditAdminAction(c, auditRateLimitOverrideDeleted, map[string]string{"key": key})
+	log.Printf("Admin deleted rate limit override %s", key)
+	c.JSON(200, RateLimitOverrideDeleteResponse{Status: "deleted", Key: key})
+}
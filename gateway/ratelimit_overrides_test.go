@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupTestRateLimitOverrides starts the test with no overrides, in
+// memory, and restores the rate-limit state afterwards.
+func setupTestRateLimitOverrides(t *testing.T) {
+	t.Helper()
+	prevStore, prevSet, prevActive := memoryRateLimitOverrides, rateLimitOverrides, activeRateLimits
+	memoryRateLimitOverrides = &memoryRateLimitOverrideStore{overrides: make(map[string]RateLimitOverride)}
+	rateLimitOverrides = &rateLimitOverrideSet{}
+	t.Cleanup(func() {
+		memoryRateLimitOverrides, rateLimitOverrides, activeRateLimits = prevStore, prevSet, prevActive
+	})
+}
+
+func TestAdminRateLimits_IPOverride(t *testing.T) {
+	setupTestRateLimitOverrides(t)
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "1")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "1")
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	gin.SetMode(gin.TestMode)
+	api := gin.New()
+	api.Use(RateLimitMiddleware(initRateLimiters()))
+	api.GET("/test", func(c *gin.Context) { c.Status(200) })
+	admin := setupAdminRouter()
+
+	get := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "203.0.113.7:4000"
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+	list := func() RateLimitsResponse {
+		t.Helper()
+		w := send("GET", "/admin/ratelimits?key=ip:203.0.113.7", "")
+		var resp RateLimitsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 200 {
+			t.Fatalf("Expected the buckets, got %d: %s", w.Code, w.Body.String())
+		}
+		return resp
+	}
+
+	get()
+	if w := get(); w.Code != 429 {
+		t.Fatalf("Expected the anonymous burst used up, got %d", w.Code)
+	}
+	resp := list()
+	if len(resp.Buckets) != 1 || resp.Buckets[0].Tier != "anonymous" || resp.Buckets[0].Tokens != 0 || resp.Buckets[0].Rejected != 1 || resp.Buckets[0].Limit != 1 {
+		t.Errorf("Expected the client's empty anonymous bucket with one refusal, got %+v", resp.Buckets)
+	}
+
+	w := send("PUT", "/admin/ratelimits/overrides", `{"ip":"203.0.113.7","rpm":100,"reason":"load test"}`)
+	var saved RateLimitOverride
+	if err := json.Unmarshal(w.Body.Bytes(), &saved); err != nil || w.Code != 200 || saved.Key != "ip:203.0.113.7" {
+		t.Fatalf("Expected the override saved, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := get(); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "100" {
+		t.Errorf("Expected the override's limit, got %d with limit %s", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+	resp = list()
+	if len(resp.Overrides) != 1 || len(resp.Buckets) != 2 || resp.Buckets[1].Tier != "override" || resp.Buckets[1].Tokens != 99 {
+		t.Errorf("Expected the override and its bucket listed, got %+v", resp)
+	}
+
+	for _, body := range []string{`{"rpm":10}`, `{"ip":"203.0.113.7","wallet":"0x00000000000000000000000000000000000000aB","rpm":10}`,
+		`{"ip":"not-an-ip","rpm":10}`, `{"wallet":"0x123","rpm":10}`, `{"ip":"203.0.113.7"}`, `{"ip":"203.0.113.7","rpm":10,"burst":-1}`} {
+		if w := send("PUT", "/admin/ratelimits/overrides", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	if w := send("DELETE", "/admin/ratelimits/overrides/ip:203.0.113.7", ""); w.Code != 200 {
+		t.Fatalf("Expected the override deleted, got %d", w.Code)
+	}
+	if w := get(); w.Code != 429 {
+		t.Errorf("Expected the client back on its empty anonymous bucket, got %d", w.Code)
+	}
+	if w := send("DELETE", "/admin/ratelimits/overrides/ip:203.0.113.7", ""); decodeProblem(t, w)["code"] != codeRateLimitOverrideNotFound {
+		t.Errorf("Expected rate_limit_override_not_found, got %d", w.Code)
+	}
+
+	activeRateLimits = nil
+	if w := send("GET", "/admin/ratelimits", ""); decodeProblem(t, w)["code"] != codeRateLimitingDisabled {
+		t.Errorf("Expected rate_limiting_disabled without rate limiting, got %d", w.Code)
+	}
+}
+
+func TestHandleSummarize_WalletOverride(t *testing.T) {
+	setupTestReputation(t)
+	setupTestRateLimitOverrides(t)
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	activeRateLimits = &rateLimitSet{tiers: initRateLimiters(), custom: newCustomRateLimiters(time.Minute)}
+	memoryRateLimitOverrides.put(t.Context(), RateLimitOverride{Key: "wallet:" + strings.ToLower(testsupport.DefaultPayer), RPM: 1})
+	rateLimitOverrides.reload(t.Context())
+	r := setupVersionedRouter()
+
+	send := func(nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"a wallet with its own limit"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := send("n-override-1"); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Fatalf("Expected the wallet's limit, got %d with limit %s", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+	w := send("n-override-2")
+	if p := decodeProblem(t, w); w.Code != 429 || p["code"] != codeRateLimited {
+		t.Errorf("Expected 429 rate_limited once the wallet's limit is used up, got %d %v", w.Code, p)
+	}
+	if n := len(ai.Requests()); n != 1 {
+		t.Errorf("Expected the refused request not to reach the provider, got %d calls", n)
+	}
+}
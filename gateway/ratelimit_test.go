@@ -255,3 +255,27 @@ func BenchmarkTokenBucketConcurrent(b *testing.B) {
 		}
 	})
 }
+
+// TestTokenBucketBuckets tests the bucket listing for the admin API
+func TestTokenBucketBuckets(t *testing.T) {
+	tb := NewTokenBucket(60, 2, 5*time.Minute)
+	defer stopCleanup(tb)
+
+	for i := 0; i < 3; i++ {
+		tb.Allow("busy")
+	}
+	tb.Allow("quiet")
+
+	buckets := tb.Buckets()
+	if len(buckets) != 2 {
+		t.Fatalf("Expected 2 buckets, got %d", len(buckets))
+	}
+	for _, b := range buckets {
+		if b.Key == "busy" && (b.Tokens != 0 || b.Rejected != 1 || b.Limit != 60 || b.Burst != 2 || b.Reset <= time.Now().Unix()) {
+			t.Errorf("Unexpected state of the busy bucket: %+v", b)
+		}
+		if b.Key == "quiet" && (b.Tokens != 1 || b.Rejected != 0) {
+			t.Errorf("Unexpected state of the quiet bucket: %+v", b)
+		}
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -33,9 +36,18 @@ type PaymentDetails struct {
 
 // ServiceDetails contains service-related information
 type ServiceDetails struct {
-	Endpoint     string `json:"endpoint"`
-	RequestHash  string `json:"request_hash"`
-	ResponseHash string `json:"response_hash"`
+	Endpoint     string        `json:"endpoint"`
+	RequestHash  string        `json:"request_hash"`
+	ResponseHash string        `json:"response_hash"`
+	Usage        *UsageDetails `json:"usage,omitempty"`
+}
+
+// UsageDetails is what the upstream AI calls behind a receipt consumed, as
+// reported by the provider. It is absent for cache hits.
+type UsageDetails struct {
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Cost             string `json:"cost,omitempty"` // USD, when the provider reports it
 }
 
 // SignedReceipt contains the receipt and its cryptographic signature
@@ -45,8 +57,9 @@ type SignedReceipt struct {
 	ServerPublicKey string  `json:"server_public_key"`
 }
 
-// GenerateReceipt creates a new receipt for a successful payment
-func GenerateReceipt(payment PaymentContext, payer string, endpoint string, reqBody, respBody []byte) (*SignedReceipt, error) {
+// GenerateReceipt creates a new receipt for a successful payment. usage may
+// be nil when no AI call was made.
+func GenerateReceipt(payment PaymentContext, payer string, endpoint string, reqBody, respBody []byte, usage *UsageDetails) (*SignedReceipt, error) {
 	receiptID, err := generateReceiptID()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate receipt ID: %w", err)
@@ -68,6 +81,7 @@ func GenerateReceipt(payment PaymentContext, payer string, endpoint string, reqB
 			Endpoint:     endpoint,
 			RequestHash:  hashData(reqBody),
 			ResponseHash: hashData(respBody),
+			Usage:        usage,
 		},
 	}
 
@@ -105,16 +119,11 @@ func signReceipt(receipt Receipt) (*SignedReceipt, error) {
 		return nil, fmt.Errorf("failed to load server private key: %w", err)
 	}
 
-	// Serialize receipt deterministically
-	// json.Marshal outputs struct fields in their declaration order
-	receiptBytes, err := json.Marshal(receipt)
+	_, hash, err := receiptDigest(receipt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal receipt: %w", err)
+		return nil, err
 	}
 
-	// Hash the receipt using Keccak256 (Ethereum-compatible)
-	hash := crypto.Keccak256Hash(receiptBytes)
-
 	// Sign the hash using ECDSA
 	// SECURITY: crypto.Sign uses constant-time operations from go-ethereum's secp256k1 implementation
 	// This prevents timing attacks that could leak private key information
@@ -133,3 +142,46 @@ func signReceipt(receipt Receipt) (*SignedReceipt, error) {
 		ServerPublicKey: "0x" + hex.EncodeToString(publicKeyBytes),
 	}, nil
 }
+
+// receiptDigest returns the canonical JSON of receipt, which is what the
+// signature covers, and its Keccak256 hash (Ethereum-compatible).
+func receiptDigest(receipt Receipt) ([]byte, common.Hash, error) {
+	// json.Marshal outputs struct fields in their declaration order
+	receiptBytes, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, common.Hash{}, fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+	return receiptBytes, crypto.Keccak256Hash(receiptBytes), nil
+}
+
+// checkReceiptSignature checks that receipt was signed by this gateway: the
+// signature must recover to ServerPublicKey over the canonical receipt, and
+// ServerPublicKey must be the gateway's own key. It returns the signer's
+// address along with the reason the receipt is invalid, if it is.
+func checkReceiptSignature(receipt *SignedReceipt) (string, error) {
+	_, hash, err := receiptDigest(receipt.Receipt)
+	if err != nil {
+		return "", err
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(receipt.Signature, "0x"))
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return "", errors.New("signature is not 65 hex bytes")
+	}
+	pub, err := crypto.SigToPub(hash.Bytes(), sig)
+	if err != nil {
+		return "", fmt.Errorf("cannot recover signer: %w", err)
+	}
+	signer := crypto.PubkeyToAddress(*pub).Hex()
+	if !strings.EqualFold("0x"+hex.EncodeToString(crypto.FromECDSAPub(pub)), receipt.ServerPublicKey) {
+		return signer, errors.New("signature does not match server_public_key")
+	}
+
+	privateKey, err := getServerPrivateKey()
+	if err != nil {
+		return signer, fmt.Errorf("failed to load server private key: %w", err)
+	}
+	if crypto.PubkeyToAddress(privateKey.PublicKey).Hex() != signer {
+		return signer, errors.New("receipt was not signed by this gateway's key")
+	}
+	return signer, nil
+}
@@ -4,11 +4,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
 )
 
 func TestGenerateReceiptID(t *testing.T) {
@@ -329,7 +332,7 @@ func TestReceiptFullFlowIntegration(t *testing.T) {
 	responseBody := []byte(`This is a test AI response summary.`)
 
 	// Step 2: Generate receipt (simulates what happens in handleSummarize)
-	receipt, err := GenerateReceipt(paymentCtx, payer, endpoint, requestBody, responseBody)
+	receipt, err := GenerateReceipt(paymentCtx, payer, endpoint, requestBody, responseBody, nil)
 	if err != nil {
 		t.Fatalf("Failed to generate receipt: %v", err)
 	}
@@ -407,7 +410,7 @@ func TestReceiptFullFlowIntegration(t *testing.T) {
 
 	// Step 6: Verify expiration behavior
 	// Store a receipt with very short TTL
-	shortTTLReceipt, err := GenerateReceipt(paymentCtx, payer, endpoint, requestBody, responseBody)
+	shortTTLReceipt, err := GenerateReceipt(paymentCtx, payer, endpoint, requestBody, responseBody, nil)
 	if err != nil {
 		t.Fatalf("Failed to generate short TTL receipt: %v", err)
 	}
@@ -455,3 +458,65 @@ func TestReceiptFullFlowIntegration(t *testing.T) {
 	t.Logf("  - Expiration working correctly")
 	t.Logf("  - Validation working correctly")
 }
+
+func TestHandleVerifyReceipt(t *testing.T) {
+	ensureTestServerKey(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/v1/receipts/:id/verify", handleVerifyReceipt)
+
+	payment := PaymentContext{Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", Token: "USDC", Amount: "0.001", Nonce: "verify-nonce", ChainID: 8453}
+	receipt, err := GenerateReceipt(payment, "0x742d35Cc6634C0532925a3b844Bc9e7595f8fE21", "/v1/ai/summarize", []byte("in"), []byte("out"), nil)
+	if err != nil {
+		t.Fatalf("GenerateReceipt() failed: %v", err)
+	}
+	if err := storeReceipt(receipt, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(id string) (int, ReceiptVerificationResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/receipts/"+id+"/verify", nil)
+		r.ServeHTTP(w, req)
+		var body ReceiptVerificationResponse
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	code, body := verify(receipt.Receipt.ID)
+	if code != 200 || !body.Valid || body.Reason != "" {
+		t.Fatalf("Expected a valid receipt, got %d %+v", code, body)
+	}
+	if body.SignerAddress != crypto.PubkeyToAddress(serverPrivateKey.PublicKey).Hex() {
+		t.Errorf("Expected the gateway's address as signer, got %s", body.SignerAddress)
+	}
+	canonical, _ := json.Marshal(receipt.Receipt)
+	if body.Canonical != string(canonical) || body.Digest != crypto.Keccak256Hash(canonical).Hex() {
+		t.Errorf("Canonical contents don't match the signed receipt: %s %s", body.Canonical, body.Digest)
+	}
+
+	// A receipt signed with another key is reported as invalid
+	otherKey, _ := crypto.GenerateKey()
+	forged := *receipt
+	forged.Receipt.ID = "rcpt_forged000000"
+	_, hash, _ := receiptDigest(forged.Receipt)
+	sig, _ := crypto.Sign(hash.Bytes(), otherKey)
+	forged.Signature = "0x" + hex.EncodeToString(sig)
+	forged.ServerPublicKey = "0x" + hex.EncodeToString(crypto.FromECDSAPub(&otherKey.PublicKey))
+	storeReceipt(&forged, time.Hour)
+	if code, body := verify(forged.Receipt.ID); code != 200 || body.Valid || !strings.Contains(body.Reason, "not signed by this gateway") {
+		t.Errorf("Expected a foreign signature to be invalid, got %d %+v", code, body)
+	}
+
+	// So is a receipt whose contents no longer match its signature
+	tampered := *receipt
+	tampered.Receipt.ID = "rcpt_tampered0000"
+	storeReceipt(&tampered, time.Hour)
+	if _, body := verify(tampered.Receipt.ID); body.Valid {
+		t.Error("Expected a tampered receipt to be invalid")
+	}
+
+	if code, _ := verify("rcpt_missing00000"); code != 404 {
+		t.Errorf("Expected 404 for an unknown receipt, got %d", code)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// paymentReconciler periodically cross-checks verified payments, settled
+// transfers and served responses. It is nil unless RECONCILIATION=true.
+var paymentReconciler *reconciler
+
+var reconciliationMismatchesTotal = newCounter(
+	"gateway_reconciliation_mismatches_total",
+	"Payment mismatches found by the reconciliation job, by kind (served_unsettled, settled_unserved, authorized_unserved, amount_mismatch). Each mismatch is counted once.",
+	"kind",
+)
+
+var reconciliationRunsTotal = newCounter(
+	"gateway_reconciliation_runs_total",
+	"Reconciliation runs by outcome (success, error).",
+	"outcome",
+)
+
+// Mismatch kinds.
+const (
+	mismatchServedUnsettled    = "served_unsettled"
+	mismatchSettledUnserved    = "settled_unserved"
+	mismatchAuthorizedUnserved = "authorized_unserved"
+	mismatchAmount             = "amount_mismatch"
+)
+
+// SettledTransfer is an on-chain payment, matched to a request by its nonce.
+type SettledTransfer struct {
+	Nonce  string    `json:"nonce"`
+	TxHash string    `json:"tx_hash"`
+	Wallet string    `json:"wallet"`
+	Amount string    `json:"amount" example:"0.001"`
+	Time   time.Time `json:"time"`
+}
+
+// settlementSource lists the transfers settled in [since, until).
+type settlementSource interface {
+	Settled(ctx context.Context, since, until time.Time) ([]SettledTransfer, error)
+}
+
+// fileSettlements reads settled transfers from a JSON Lines file written by
+// whatever settles the payments (a facilitator, an indexer or a batch job).
+type fileSettlements struct {
+	path string
+}
+
+// find returns the transfer settling nonce, or nil when there is none yet.
+func (s fileSettlements) find(nonce string) (*SettledTransfer, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var found *SettledTransfer
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var t SettledTransfer
+		if err := json.Unmarshal(scanner.Bytes(), &t); err == nil && t.Nonce == nonce {
+			found = &t
+		}
+	}
+	return found, scanner.Err()
+}
+
+func (s fileSettlements) Settled(_ context.Context, since, until time.Time) ([]SettledTransfer, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var transfers []SettledTransfer
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var t SettledTransfer
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil || t.Nonce == "" {
+			continue
+		}
+		if !t.Time.Before(since) && t.Time.Before(until) {
+			transfers = append(transfers, t)
+		}
+	}
+	return transfers, scanner.Err()
+}
+
+// paymentAuthorization is a payment the verifier accepted.
+type paymentAuthorization struct {
+	Wallet string
+	Amount string
+	Time   time.Time
+}
+
+// ReconciliationMismatch is one payment whose records disagree.
+type ReconciliationMismatch struct {
+	Kind          string    `json:"kind" example:"served_unsettled"`
+	Nonce         string    `json:"nonce"`
+	Wallet        string    `json:"wallet"`
+	Amount        string    `json:"amount" doc:"USDC charged, or settled for settled_unserved"`
+	SettledAmount string    `json:"settled_amount,omitempty" doc:"Amount of the transfer, for amount_mismatch"`
+	Time          time.Time `json:"time"`
+	ReceiptID     string    `json:"receipt_id,omitempty"`
+	TxHash        string    `json:"tx_hash,omitempty"`
+}
+
+// ReconciliationReport is the body of GET /admin/reconciliation.
+type ReconciliationReport struct {
+	GeneratedAt       time.Time                `json:"generated_at"`
+	Since             time.Time                `json:"since"`
+	Until             time.Time                `json:"until" doc:"Records newer than the grace period are not checked yet"`
+	Authorized        int                      `json:"authorized" doc:"Payments verified by this instance in the window"`
+	Served            int                      `json:"served" doc:"Paid responses in the usage ledger"`
+	Settled           int                      `json:"settled" doc:"Settled transfers; 0 when settlement is not checked"`
+	SettlementChecked bool                     `json:"settlement_checked"`
+	Mismatches        []ReconciliationMismatch `json:"mismatches"`
+}
+
+// reconciler compares three records of each payment: the authorizations the
+// verifier accepted (kept in memory, per instance), the responses served
+// (the usage ledger) and the transfers settled (settlementSource, optional).
+type reconciler struct {
+	settlements settlementSource // nil: settlement is not checked
+	window      time.Duration
+	grace       time.Duration
+
+	mu             sync.Mutex
+	authorizations map[string]paymentAuthorization // by nonce
+	counted        map[string]time.Time            // mismatches already counted, by kind+nonce
+	announced      map[string]time.Time            // settled nonces already sent as settlement_completed events
+	last           *ReconciliationReport
+}
+
+// initReconciler builds the reconciler from RECONCILIATION (default off),
+// RECONCILE_WINDOW_HOURS (default 24), RECONCILE_GRACE_SECONDS (default
+// 600) and SETTLEMENT_FILE. It needs the usage ledger.
+func initReconciler() *reconciler {
+	if strings.ToLower(os.Getenv("RECONCILIATION")) != "true" {
+		return nil
+	}
+	if usageLedger == nil {
+		log.Println("Warning: Reconciliation disabled: it needs the usage ledger (LEDGER_BACKEND)")
+		return nil
+	}
+	r := newReconciler(
+		time.Duration(getEnvAsInt("RECONCILE_WINDOW_HOURS", 24))*time.Hour,
+		time.Duration(getEnvAsInt("RECONCILE_GRACE_SECONDS", 600))*time.Second,
+	)
+	if path := os.Getenv("SETTLEMENT_FILE"); path != "" {
+		r.settlements = fileSettlements{path: path}
+	} else {
+		log.Println("Warning: SETTLEMENT_FILE not set, reconciliation won't check settlement")
+	}
+	return r
+}
+
+func newReconciler(window, grace time.Duration) *reconciler {
+	return &reconciler{
+		window:         window,
+		grace:          grace,
+		authorizations: make(map[string]paymentAuthorization),
+		counted:        make(map[string]time.Time),
+		announced:      make(map[string]time.Time),
+	}
+}
+
+// start runs the reconciliation every RECONCILE_INTERVAL_SECONDS (default
+// 900) until ctx is done.
+func (r *reconciler) start(ctx context.Context) {
+	interval := time.Duration(getEnvAsInt("RECONCILE_INTERVAL_SECONDS", 900)) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.run(ctx)
+			}
+		}
+	}()
+	log.Printf("Payment reconciliation every %s over the last %s", interval, r.window)
+}
+
+// recordAuthorization notes a payment the verifier accepted.
+func recordAuthorization(nonce, wallet, amount string) {
+	if paymentReconciler == nil {
+		return
+	}
+	paymentReconciler.mu.Lock()
+	paymentReconciler.authorizations[nonce] = paymentAuthorization{Wallet: strings.ToLower(wallet), Amount: amount, Time: time.Now().UTC()}
+	paymentReconciler.mu.Unlock()
+}
+
+// authorization returns the payment the verifier accepted for nonce on this
+// instance, if it is still in the reconciliation window.
+func (r *reconciler) authorization(nonce string) (paymentAuthorization, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.authorizations[nonce]
+	return a, ok
+}
+
+// discardAuthorization forgets a payment that was never taken, such as one
+// released from escrow.
+func discardAuthorization(nonce string) {
+	if paymentReconciler == nil {
+		return
+	}
+	paymentReconciler.mu.Lock()
+	delete(paymentReconciler.authorizations, nonce)
+	paymentReconciler.mu.Unlock()
+}
+
+// run reconciles the window ending a grace period ago, so requests still in
+// flight and transfers not yet settled aren't flagged, and keeps the report
+// for GET /admin/reconciliation.
+func (r *reconciler) run(ctx context.Context) (*ReconciliationReport, error) {
+	now := time.Now().UTC()
+	report := &ReconciliationReport{GeneratedAt: now, Since: now.Add(-r.window), Until: now.Add(-r.grace), Mismatches: []ReconciliationMismatch{}}
+
+	// A payment near the window's start may have been served or settled
+	// just before it, so the lookups reach back one grace period further
+	served, err := usageLedger.Entries(ctx, ledgerQuery{Since: report.Since.Add(-r.grace), Until: now})
+	if err != nil {
+		reconciliationRunsTotal.Inc("error")
+		log.Printf("Reconciliation failed reading the ledger: %v", err)
+		return nil, err
+	}
+	// A refunded payment is accounted for whether or not it was served
+	servedByNonce := make(map[string]LedgerEntry, len(served))
+	refunded := make(map[string]bool)
+	for _, e := range served {
+		if e.Type == ledgerTypeRefund {
+			refunded[e.Nonce] = true
+			continue
+		}
+		if e.Type == ledgerTypeSettlement {
+			continue
+		}
+		servedByNonce[e.Nonce] = e
+	}
+
+	var settledByNonce map[string]SettledTransfer
+	if r.settlements != nil {
+		transfers, err := r.settlements.Settled(ctx, report.Since.Add(-r.grace), now)
+		if err != nil {
+			reconciliationRunsTotal.Inc("error")
+			log.Printf("Reconciliation failed reading settlements: %v", err)
+			return nil, err
+		}
+		report.SettlementChecked = true
+		settledByNonce = make(map[string]SettledTransfer, len(transfers))
+		for _, t := range transfers {
+			settledByNonce[t.Nonce] = t
+		}
+	}
+	inRange := func(t time.Time) bool { return !t.Before(report.Since) && t.Before(report.Until) }
+
+	for _, e := range served {
+		if !inRange(e.Time) || e.Type == ledgerTypeRefund || e.Type == ledgerTypeSettlement {
+			continue
+		}
+		report.Served++
+		if settledByNonce == nil {
+			continue
+		}
+		t, ok := settledByNonce[e.Nonce]
+		switch {
+		case !ok:
+			report.add(ReconciliationMismatch{Kind: mismatchServedUnsettled, Nonce: e.Nonce, Wallet: e.Wallet, Amount: e.Amount, Time: e.Time, ReceiptID: e.ReceiptID})
+		case !sameAmount(e.Amount, t.Amount):
+			report.add(ReconciliationMismatch{Kind: mismatchAmount, Nonce: e.Nonce, Wallet: e.Wallet, Amount: e.Amount, SettledAmount: t.Amount, Time: e.Time, ReceiptID: e.ReceiptID, TxHash: t.TxHash})
+		}
+	}
+	for nonce, t := range settledByNonce {
+		if !inRange(t.Time) {
+			continue
+		}
+		report.Settled++
+		if _, ok := servedByNonce[nonce]; !ok && !refunded[nonce] {
+			report.add(ReconciliationMismatch{Kind: mismatchSettledUnserved, Nonce: nonce, Wallet: t.Wallet, Amount: t.Amount, Time: t.Time, TxHash: t.TxHash})
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for nonce, a := range r.authorizations {
+		if a.Time.Before(report.Since) {
+			delete(r.authorizations, nonce)
+			continue
+		}
+		if !inRange(a.Time) {
+			continue
+		}
+		report.Authorized++
+		if _, ok := servedByNonce[nonce]; !ok && !refunded[nonce] {
+			report.add(ReconciliationMismatch{Kind: mismatchAuthorizedUnserved, Nonce: nonce, Wallet: a.Wallet, Amount: a.Amount, Time: a.Time})
+		}
+	}
+
+	sort.Slice(report.Mismatches, func(i, j int) bool { return report.Mismatches[i].Time.Before(report.Mismatches[j].Time) })
+	for _, m := range report.Mismatches {
+		if key := m.Kind + "\xff" + m.Nonce; r.counted[key].IsZero() {
+			r.counted[key] = m.Time
+			reconciliationMismatchesTotal.Inc(m.Kind)
+			if m.Kind == mismatchServedUnsettled || m.Kind == mismatchAmount {
+				notifyWebhooks(ctx, webhookSettlementFailed, map[string]interface{}{
+					"reason": m.Kind, "nonce": m.Nonce, "wallet": m.Wallet, "amount": m.Amount,
+					"settled_amount": m.SettledAmount, "receipt_id": m.ReceiptID, "tx_hash": m.TxHash, "served_at": m.Time,
+				})
+			}
+		}
+	}
+	for key, t := range r.counted {
+		// Mismatches that left the window can't be reported again
+		if t.Before(report.Since) {
+			delete(r.counted, key)
+		}
+	}
+	for nonce, t := range settledByNonce {
+		if r.announced[nonce].IsZero() {
+			r.announced[nonce] = t.Time
+			emitEvent(Event{Type: eventSettlementCompleted, Time: t.Time, Nonce: nonce, Wallet: t.Wallet, Amount: t.Amount, TxHash: t.TxHash, ReceiptID: servedByNonce[nonce].ReceiptID})
+		}
+	}
+	for nonce, t := range r.announced {
+		if t.Before(report.Since.Add(-r.grace)) {
+			delete(r.announced, nonce)
+		}
+	}
+	r.last = report
+	reconciliationRunsTotal.Inc("success")
+	if len(report.Mismatches) > 0 {
+		log.Printf("Reconciliation found %d mismatches (served %d, settled %d, authorized %d)",
+			len(report.Mismatches), report.Served, report.Settled, report.Authorized)
+	}
+	return report, nil
+}
+
+func (rep *ReconciliationReport) add(m ReconciliationMismatch) {
+	rep.Mismatches = append(rep.Mismatches, m)
+}
+
+// sameAmount compares two decimal amounts numerically, so "0.0010" matches
+// "0.001".
+func sameAmount(a, b string) bool {
+	x, okX := new(big.Rat).SetString(a)
+	y, okY := new(big.Rat).SetString(b)
+	return okX && okY && x.Cmp(y) == 0
+}
+
+// handleReconciliationReport handles GET /admin/reconciliation, returning
+// the last report, or running the job now when there is none yet or with
+// ?refresh=true.
+func handleReconciliationReport(c *gin.Context) {
+	if paymentReconciler == nil {
+		abortWithProblem(c, newProblem(503, codeReconcileDisabled, "Reconciliation Disabled",
+			"Payment reconciliation is not enabled (RECONCILIATION, LEDGER_BACKEND)"))
+		return
+	}
+	paymentReconciler.mu.Lock()
+	report := paymentReconciler.last
+	paymentReconciler.mu.Unlock()
+	if report == nil || c.Query("refresh") == "true" {
+		var err error
+		if report, err = paymentReconciler.run(c.Request.Context()); err != nil {
+			abortWithProblem(c, newProblem(500, codeInternalError, "Reconciliation failed", err.Error()))
+			return
+		}
+	}
+	c.JSON(200, report)
+}
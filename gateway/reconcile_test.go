@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSettlements is a settlementSource with fixed transfers.
+type fakeSettlements []SettledTransfer
+
+func (f fakeSettlements) Settled(_ context.Context, since, until time.Time) ([]SettledTransfer, error) {
+	var out []SettledTransfer
+	for _, t := range f {
+		if !t.Time.Before(since) && t.Time.Before(until) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func setupTestReconciler(t *testing.T, settled fakeSettlements) *reconciler {
+	t.Helper()
+	r := newReconciler(24*time.Hour, 10*time.Minute)
+	if settled != nil {
+		r.settlements = settled
+	}
+	prev := paymentReconciler
+	paymentReconciler = r
+	t.Cleanup(func() { paymentReconciler = prev })
+	return r
+}
+
+func TestReconciler_FlagsMismatches(t *testing.T) {
+	ledger := setupTestLedger(t)
+	hourAgo := time.Now().UTC().Add(-time.Hour)
+	ctx := context.Background()
+	for _, e := range []LedgerEntry{
+		{ReceiptID: "rcpt_ok", Nonce: "n-ok", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+		{ReceiptID: "rcpt_unsettled", Nonce: "n-unsettled", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+		{ReceiptID: "rcpt_short", Nonce: "n-short", Wallet: "0xbb", Amount: "0.002", Time: hourAgo},
+		// Too recent to check yet
+		{ReceiptID: "rcpt_new", Nonce: "n-new", Wallet: "0xaa", Amount: "0.001", Time: time.Now().UTC()},
+		// Failed and refunded, so accounted for
+		{Type: ledgerTypeRefund, RefundID: "rfnd_1", Nonce: "n-refunded", Wallet: "0xaa", Amount: "0.001", Time: hourAgo},
+	} {
+		ledger.Append(ctx, e)
+	}
+	r := setupTestReconciler(t, fakeSettlements{
+		{Nonce: "n-ok", TxHash: "0x01", Amount: "0.0010", Time: hourAgo},
+		{Nonce: "n-short", TxHash: "0x02", Amount: "0.001", Time: hourAgo},
+		{Nonce: "n-orphan", TxHash: "0x03", Wallet: "0xcc", Amount: "0.001", Time: hourAgo},
+	})
+	for _, nonce := range []string{"n-ok", "n-failed", "n-refunded"} {
+		recordAuthorization(nonce, "0xAA", "0.001")
+		// Backdate past the grace period
+		a := r.authorizations[nonce]
+		a.Time = hourAgo
+		r.authorizations[nonce] = a
+	}
+
+	report, err := r.run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Served != 3 || report.Settled != 3 || report.Authorized != 3 || !report.SettlementChecked {
+		t.Errorf("Unexpected counts %+v", report)
+	}
+	kinds := map[string]string{}
+	for _, m := range report.Mismatches {
+		kinds[m.Nonce] = m.Kind
+	}
+	want := map[string]string{
+		"n-unsettled": mismatchServedUnsettled,
+		"n-short":     mismatchAmount,
+		"n-orphan":    mismatchSettledUnserved,
+		"n-failed":    mismatchAuthorizedUnserved,
+	}
+	if len(kinds) != len(want) {
+		t.Errorf("Expected %d mismatches, got %v", len(want), kinds)
+	}
+	for nonce, kind := range want {
+		if kinds[nonce] != kind {
+			t.Errorf("Expected %s to be %s, got %q", nonce, kind, kinds[nonce])
+		}
+	}
+
+	// A mismatch seen again on the next run is only counted once
+	before := reconciliationMismatchesTotal.Value(mismatchServedUnsettled)
+	r.run(ctx)
+	if got := reconciliationMismatchesTotal.Value(mismatchServedUnsettled); got != before {
+		t.Errorf("Expected the mismatch counter to stay at %v, got %v", before, got)
+	}
+}
+
+func TestReconciler_WithoutSettlementSource(t *testing.T) {
+	setupTestLedger(t).Append(context.Background(), LedgerEntry{Nonce: "n-1", Amount: "0.001", Time: time.Now().UTC().Add(-time.Hour)})
+	r := setupTestReconciler(t, nil)
+
+	report, err := r.run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SettlementChecked || len(report.Mismatches) != 0 || report.Served != 1 {
+		t.Errorf("Expected only served requests to be counted, got %+v", report)
+	}
+}
+
+func TestFileSettlements(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settled.jsonl")
+	now := time.Now().UTC()
+	var data []byte
+	for _, tr := range []SettledTransfer{{Nonce: "n-1", Time: now.Add(-time.Hour)}, {Nonce: "n-2", Time: now.Add(-48 * time.Hour)}} {
+		line, _ := json.Marshal(tr)
+		data = append(append(data, line...), '\n')
+	}
+	data = append(data, "not json\n"...)
+	os.WriteFile(path, data, 0o600)
+
+	got, err := fileSettlements{path: path}.Settled(context.Background(), now.Add(-24*time.Hour), now)
+	if err != nil || len(got) != 1 || got[0].Nonce != "n-1" {
+		t.Errorf("Expected only n-1 in range, got %+v, %v", got, err)
+	}
+}
+
+func TestHandleReconciliationReport(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+
+	if w := adminRequest(r, "GET", "/admin/reconciliation", "secret"); w.Code != 503 || decodeProblem(t, w)["code"] != codeReconcileDisabled {
+		t.Errorf("Expected 503 reconciliation_disabled, got %d", w.Code)
+	}
+
+	setupTestLedger(t)
+	setupTestReconciler(t, fakeSettlements{})
+	w := adminRequest(r, "GET", "/admin/reconciliation", "secret")
+	var report ReconciliationReport
+	if w.Code != 200 || json.Unmarshal(w.Body.Bytes(), &report) != nil || report.Mismatches == nil {
+		t.Errorf("Expected a report, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refundQueue receives the reverse transfers to send on-chain. It is nil
+// unless REFUND_QUEUE_FILE is set.
+var refundQueue *jsonLinesQueue
+
+var refundsTotal = newCounter(
+	"gateway_refunds_total",
+	"Refunds issued, by source (automatic, admin) and outcome (recorded, error).",
+	"source", "outcome",
+)
+
+// Refund returns a payment to the wallet that made it.
+type Refund struct {
+	ID        string    `json:"refund_id" example:"rfnd_a1b2c3d4e5f6"`
+	Time      time.Time `json:"time"`
+	Nonce     string    `json:"nonce" doc:"Nonce of the refunded payment"`
+	Wallet    string    `json:"wallet" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Amount    string    `json:"amount" doc:"Amount returned, in Token" example:"0.001"`
+	Token     string    `json:"token" example:"USDC"`
+	ChainID   int       `json:"chain_id" example:"8453"`
+	Route     string    `json:"route,omitempty" example:"/v1/ai/summarize"`
+	ReceiptID string    `json:"receipt_id,omitempty" doc:"Receipt of the refunded request, when it was served"`
+	Reason    string    `json:"reason" example:"ai_service_failed"`
+	Queued    bool      `json:"queued" doc:"A reverse transfer was queued in REFUND_QUEUE_FILE"`
+}
+
+// RefundRequest is the body of POST /admin/refunds.
+type RefundRequest struct {
+	Nonce  string `json:"nonce" doc:"Nonce of the payment to refund"`
+	Wallet string `json:"wallet" doc:"Wallet that paid, or its ENS name when ETH_RPC_URL is set" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Amount string `json:"amount,omitempty" doc:"Amount to return; defaults to the amount charged. Required when the ledger has no entry for the payment" example:"0.001"`
+	Reason string `json:"reason,omitempty" doc:"Recorded with the refund" example:"customer_request"`
+}
+
+// ledgerEntry returns the refund as a ledger entry.
+func (r Refund) ledgerEntry() LedgerEntry {
+	return LedgerEntry{
+		Type: ledgerTypeRefund, RefundID: r.ID, ReceiptID: r.ReceiptID, Time: r.Time, Wallet: r.Wallet, Route: r.Route,
+		Nonce: r.Nonce, Amount: r.Amount, Token: r.Token, ChainID: r.ChainID, Reason: r.Reason,
+	}
+}
+
+func generateRefundID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "rfnd_" + hex.EncodeToString(b)
+}
+
+// initRefundQueue opens REFUND_QUEUE_FILE, where refunds are appended for
+// whatever settles the payments (the writer of SETTLEMENT_FILE) to send. A
+// failure is only logged: refunds are still recorded in the ledger and can
+// be settled from there.
+func initRefundQueue() *jsonLinesQueue {
+	return openJSONLinesQueue("REFUND_QUEUE_FILE", "refund transfers")
+}
+
+// issueRefund records r in the ledger and queues its reverse transfer. A
+// ledger failure is returned; a queue failure only logged, since the ledger
+// entry is enough to settle the refund by hand.
+func issueRefund(ctx context.Context, r Refund, source string) (Refund, error) {
+	r.ID, r.Time = generateRefundID(), time.Now().UTC()
+	if usageLedger != nil {
+		if err := usageLedger.Append(context.WithoutCancel(ctx), r.ledgerEntry()); err != nil {
+			refundsTotal.Inc(source, "error")
+			return r, err
+		}
+	}
+	if refundQueue != nil {
+		if err := refundQueue.push(r); err != nil {
+			log.Printf("error queuing refund %s for %s: %v", r.ID, r.Nonce, err)
+		} else {
+			r.Queued = true
+		}
+	}
+	refundsTotal.Inc(source, "recorded")
+	emitEvent(Event{Type: eventRefundIssued, Time: r.Time, Nonce: r.Nonce, Wallet: r.Wallet, Amount: r.Amount,
+		Token: r.Token, ChainID: r.ChainID, Route: r.Route, ReceiptID: r.ReceiptID, Reason: r.Reason})
+	log.Printf("Refund %s of %s %s to %s for %s (%s)", r.ID, r.Amount, r.Token, r.Wallet, r.Nonce, r.Reason)
+	return r, nil
+}
+
+// refundIfFailed refunds a verified payment when the request was answered
+// with a server error, such as a failed or timed-out AI call, so the client
+// doesn't pay for a response it never got. Run it deferred once the payment
+// is verified; errors the client caused (4xx) are left to POST
+// /admin/refunds. In escrow mode a failed payment is never settled, so there
+// is nothing to refund.
+func refundIfFailed(c *gin.Context, payment PaymentContext, wallet string) {
+	// Escrow and facilitator modes never charge a failed request
+	if c.Writer.Status() < 500 || getSettlementMode() != settlementImmediate {
+		return
+	}
+	if amount, ok := new(big.Rat).SetString(payment.Amount); !ok || amount.Sign() == 0 {
+		return
+	}
+	_, err := issueRefund(c.Request.Context(), Refund{
+		Nonce: payment.Nonce, Wallet: strings.ToLower(wallet), Amount: payment.Amount, Token: payment.Token,
+		ChainID: payment.ChainID, Route: c.FullPath(), Reason: c.GetString(problemCodeKey),
+	}, "automatic")
+	if err != nil {
+		ledgerWriteErrorsTotal.Inc()
+		log.Printf("error recording the refund of %s: %v", payment.Nonce, err)
+	}
+}
+
+// handleCreateRefund handles POST /admin/refunds, refunding a payment by its
+// nonce. The charge is looked up in the ledger to default and cap the
+// amount; a payment that was refunded already is refused with 409.
+func handleCreateRefund(c *gin.Context) {
+	if usageLedger == nil {
+		abortWithProblem(c, newProblem(503, codeLedgerDisabled, "Refunds Unavailable", "The usage ledger is not enabled (LEDGER_BACKEND)"))
+		return
+	}
+	var req RefundRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	if req.Nonce == "" {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", "nonce is required"))
+		return
+	}
+	wallet, ok := resolveWalletParam(c, req.Wallet)
+	if !ok {
+		return
+	}
+
+	entries, err := usageLedger.Entries(c.Request.Context(), ledgerQuery{Wallet: wallet})
+	if err != nil {
+		log.Printf("error reading the ledger for a refund: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read the ledger", err.Error()))
+		return
+	}
+	var charge *LedgerEntry
+	for i, e := range entries {
+		if e.Nonce != req.Nonce || e.Type == ledgerTypeSettlement {
+			continue
+		}
+		if e.Type == ledgerTypeRefund {
+			abortWithProblem(c, newProblem(409, codeRefundExists, "Conflict", "The payment has already been refunded").
+				With("refund_id", e.RefundID))
+			return
+		}
+		charge = &entries[i]
+	}
+
+	refund := Refund{Nonce: req.Nonce, Wallet: wallet, Amount: req.Amount, Token: getPaymentToken(), ChainID: getChainID(), Reason: req.Reason}
+	if refund.Reason == "" {
+		refund.Reason = "admin"
+	}
+	if charge != nil {
+		refund.Token, refund.ChainID, refund.Route, refund.ReceiptID = charge.Token, charge.ChainID, charge.Route, charge.ReceiptID
+		if refund.Token == "" {
+			refund.Token = getPaymentToken()
+		}
+		if refund.Amount == "" {
+			refund.Amount = charge.Amount
+		}
+	}
+	if err := checkRefundAmount(refund.Amount, charge); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+
+	refund, err = issueRefund(c.Request.Context(), refund, "admin")
+	if err != nil {
+		log.Printf("error recording refund for %s: %v", req.Nonce, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to record the refund", err.Error()))
+		return
+	}
+	auditAdminAction(c, auditRefundIssued, map[string]string{
+		"refund_id": refund.ID, "nonce": refund.Nonce, "wallet": refund.Wallet, "amount": refund.Amount, "reason": refund.Reason,
+	})
+	c.JSON(201, refund)
+}
+
+// checkRefundAmount requires a positive decimal no larger than the charge,
+// when there is one.
+func checkRefundAmount(amount string, charge *LedgerEntry) error {
+	if amount == "" {
+		return fmt.Errorf("amount is required: the ledger has no charge for this nonce and wallet")
+	}
+	refund, ok := parseDecimal(amount)
+	if !ok || refund.Sign() <= 0 {
+		return fmt.Errorf("amount must be a positive decimal")
+	}
+	if charge != nil {
+		if charged, ok := new(big.Rat).SetString(charge.Amount); ok && refund.Cmp(charged) > 0 {
+			return fmt.Errorf("amount exceeds the %s charged", charge.Amount)
+		}
+	}
+	return nil
+}
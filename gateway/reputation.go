@@ -0,0 +1,302 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var reputationSignalsTotal = newCounter(
+	"gateway_reputation_signals_total",
+	"Negative reputation signals recorded against wallets, by signal (failed_verification, rate_limited, moderation_flag).",
+	"signal",
+)
+
+var reputationSurchargesTotal = newCounter(
+	"gateway_reputation_surcharges_total",
+	"Paid requests priced with REPUTATION_SURCHARGE_PERCENT because their wallet's reputation was under REPUTATION_LOW_SCORE.",
+)
+
+// Signals recorded against a wallet's reputation.
+const (
+	signalFailedVerification = "failed_verification"
+	signalRateLimited        = "rate_limited"
+	signalModerationFlag     = "moderation_flag"
+)
+
+// A wallet starts at reputationNeutral and gains a point per settled
+// payment, up to reputationMaxPaymentBonus. Each signal takes off its
+// penalty; rate-limit refusals are capped, since a busy honest client hits
+// them too, and the refund rate takes off up to maxRefundRatePenalty. The
+// score is clamped to 0-100.
+const (
+	reputationNeutral         = 50
+	reputationMaxPaymentBonus = 50
+	failedVerificationPenalty = 10
+	rateLimitedPenalty        = 2
+	maxRateLimitedPenalty     = 20
+	moderationFlagPenalty     = 15
+	abusePointPenalty         = 10
+	maxRefundRatePenalty      = 40
+)
+
+// maxReputationRecords bounds the signal table; the least recently seen
+// wallet is dropped first.
+const maxReputationRecords = 10000
+
+// lowReputationKeyPrefix keys a low-reputation wallet's bucket in the
+// anonymous tier's limiter.
+const lowReputationKeyPrefix = "reputation:"
+
+// reputationLowScore returns REPUTATION_LOW_SCORE (default 20). Verified
+// payments from a wallet scoring under it get the anonymous rate limit
+// tier and, with REPUTATION_SURCHARGE_PERCENT, a higher price; 0 turns
+// both off.
+func reputationLowScore() int {
+	return getEnvAsInt("REPUTATION_LOW_SCORE", 20)
+}
+
+// reputationSurchargePercent returns REPUTATION_SURCHARGE_PERCENT, added to
+// the price for wallets under REPUTATION_LOW_SCORE (default 0).
+func reputationSurchargePercent() int {
+	return getEnvAsInt("REPUTATION_SURCHARGE_PERCENT", 0)
+}
+
+// reputationWindow returns REPUTATION_WINDOW_HOURS (default 24): a wallet's
+// signals are forgotten once it has had none for this long.
+func reputationWindow() time.Duration {
+	return time.Duration(getEnvAsInt("REPUTATION_WINDOW_HOURS", 24)) * time.Hour
+}
+
+// reputationSignals are one wallet's negative signals.
+type reputationSignals struct {
+	failedVerifications int
+	rateLimited         int
+	moderationFlags     int
+	lastSeen            time.Time
+}
+
+// WalletReputation is one wallet's entry in GET /admin/reputation.
+type WalletReputation struct {
+	Wallet              string     `json:"wallet" example:"0x742d35cc6634c0532925a3b844bc454e4438f44e"`
+	Score               int        `json:"score" doc:"0-100; new wallets start at 50" example:"62"`
+	Tier                string     `json:"tier" doc:"Rate limit tier the wallet's signed requests get" example:"standard"`
+	Low                 bool       `json:"low" doc:"Under REPUTATION_LOW_SCORE: restricted to the anonymous tier and surcharged"`
+	Payments            int        `json:"payments" doc:"Settled payments in the ledger, neither refunded nor failed"`
+	Refunds             int        `json:"refunds" doc:"Refunded payments in the ledger"`
+	FailedVerifications int        `json:"failed_verifications" doc:"Verified payments the facilitator refused"`
+	RateLimited         int        `json:"rate_limited" doc:"Requests refused by a rate or concurrency limit"`
+	ModerationFlags     int        `json:"moderation_flags" doc:"Summaries withheld by output moderation"`
+	AbuseScore          int        `json:"abuse_score" doc:"Prompt-injection matches, as in GET /admin/abuse"`
+	LastSeen            *time.Time `json:"last_seen,omitempty" doc:"Last negative signal"`
+}
+
+// ReputationReport is the body of GET /admin/reputation.
+type ReputationReport struct {
+	Wallets []WalletReputation `json:"wallets"`
+}
+
+// reputationTracker holds wallets' negative signals. Like the abuse scores
+// it is kept in memory per gateway instance and resets on restart; payment
+// history comes from the ledger instead.
+type reputationTracker struct {
+	mu      sync.Mutex
+	records map[string]*reputationSignals
+}
+
+var walletReputation = newReputationTracker()
+
+func newReputationTracker() *reputationTracker {
+	return &reputationTracker{records: make(map[string]*reputationSignals)}
+}
+
+// record counts one signal against wallet; unknown wallets are ignored.
+// Only record signals against a wallet whose signature was verified, so
+// nobody can spend another wallet's reputation.
+func (t *reputationTracker) record(wallet, signal string) {
+	if wallet == "" {
+		return
+	}
+	wallet = strings.ToLower(wallet)
+	now := time.Now()
+	reputationSignalsTotal.Inc(signal)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.records[wallet]
+	if ok && now.Sub(rec.lastSeen) > reputationWindow() {
+		rec, ok = nil, false
+	}
+	if !ok {
+		if _, exists := t.records[wallet]; !exists && len(t.records) >= maxReputationRecords {
+			t.evictOldestLocked()
+		}
+		rec = &reputationSignals{}
+		t.records[wallet] = rec
+	}
+	switch signal {
+	case signalFailedVerification:
+		rec.failedVerifications++
+	case signalRateLimited:
+		rec.rateLimited++
+	case signalModerationFlag:
+		rec.moderationFlags++
+	}
+	rec.lastSeen = now
+}
+
+func (t *reputationTracker) evictOldestLocked() {
+	var oldest string
+	for wallet, rec := range t.records {
+		if oldest == "" || rec.lastSeen.Before(t.records[oldest].lastSeen) {
+			oldest = wallet
+		}
+	}
+	delete(t.records, oldest)
+}
+
+// signals returns wallet's signals, or false when it has none within the
+// window.
+func (t *reputationTracker) signals(wallet string) (reputationSignals, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.records[strings.ToLower(wallet)]
+	if !ok || time.Since(rec.lastSeen) > reputationWindow() {
+		return reputationSignals{}, false
+	}
+	return *rec, true
+}
+
+// wallets returns the wallets with signals.
+func (t *reputationTracker) wallets() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Collect(maps.Keys(t.records))
+}
+
+// reputationOf scores wallet from the signals and abuse score held by this
+// instance and the payment history cached from the ledger.
+func reputationOf(wallet string) WalletReputation {
+	wallet = strings.ToLower(wallet)
+	rep := WalletReputation{Wallet: wallet, AbuseScore: abuseScores.score(wallet)}
+	rep.Payments, rep.Refunds = walletTiers.history(wallet)
+	if s, ok := walletReputation.signals(wallet); ok {
+		rep.FailedVerifications, rep.RateLimited, rep.ModerationFlags = s.failedVerifications, s.rateLimited, s.moderationFlags
+		lastSeen := s.lastSeen
+		rep.LastSeen = &lastSeen
+	}
+
+	score := reputationNeutral + min(rep.Payments, reputationMaxPaymentBonus)
+	score -= rep.FailedVerifications * failedVerificationPenalty
+	score -= min(rep.RateLimited*rateLimitedPenalty, maxRateLimitedPenalty)
+	score -= rep.ModerationFlags * moderationFlagPenalty
+	score -= rep.AbuseScore * abusePointPenalty
+	if paid := rep.Payments + rep.Refunds; paid > 0 {
+		score -= rep.Refunds * maxRefundRatePenalty / paid
+	}
+	rep.Score = max(0, min(100, score))
+
+	threshold := reputationLowScore()
+	rep.Low = threshold > 0 && rep.Score < threshold
+	rep.Tier = walletTiers.tier(wallet)
+	if rep.Low {
+		rep.Tier = "anonymous"
+	}
+	return rep
+}
+
+// lowReputation reports whether wallet scores under REPUTATION_LOW_SCORE.
+func lowReputation(wallet string) bool {
+	return wallet != "" && reputationLowScore() > 0 && reputationOf(wallet).Low
+}
+
+// checkReputationSurcharge holds a verified wallet with a low reputation
+// to price raised by REPUTATION_SURCHARGE_PERCENT, less promo's discount.
+// The signer isn't known before verification, so the 402 challenge quotes
+// the regular price; a payment of less than the surcharged one gets 402
+// insufficient_payment with the amount to sign, and false is returned.
+func checkReputationSurcharge(c *gin.Context, wallet, price string, promo *PromoCode, paid PaymentContext) bool {
+	percent := reputationSurchargePercent()
+	if percent <= 0 || !lowReputation(wallet) {
+		return true
+	}
+	reputationSurchargesTotal.Inc()
+	required := paid
+	required.Amount = applyLoad(price, 100+percent)
+	if promo != nil {
+		required.Amount, _ = promo.apply(required.Amount)
+	}
+	if mismatch := checkDeclaredPayment(paid, required); mismatch != nil {
+		abortPaymentMismatch(c, mismatch, required)
+		return false
+	}
+	return true
+}
+
+// allowLowReputation holds a wallet with a low reputation to the anonymous
+// tier's limit, in a bucket of its own so fresh nonces don't escape it. It
+// answers 429 and returns false when the bucket is empty.
+func allowLowReputation(c *gin.Context, wallet string) bool {
+	if !lowReputation(wallet) {
+		return true
+	}
+	key := lowReputationKeyPrefix + strings.ToLower(wallet)
+	if !applyRateLimit(c, activeRateLimits.tiers["anonymous"], key, getLimitForTier("anonymous")) {
+		walletReputation.record(wallet, signalRateLimited)
+		return false
+	}
+	return true
+}
+
+// handleReputationReport handles GET /admin/reputation, listing the wallets
+// this instance knows anything about, lowest score first.
+func handleReputationReport(c *gin.Context) {
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > 1000 {
+			abortInvalidQuery(c, "limit", "limit must be an integer from 1 to 1000")
+			return
+		}
+	}
+	wallets := slices.Concat(walletReputation.wallets(), walletTiers.wallets())
+	for _, rec := range abuseScores.report() {
+		wallets = append(wallets, rec.Wallet)
+	}
+	slices.Sort(wallets)
+	wallets = slices.Compact(wallets)
+
+	reps := make([]WalletReputation, 0, len(wallets))
+	for _, wallet := range wallets {
+		reps = append(reps, reputationOf(wallet))
+	}
+	slices.SortFunc(reps, func(a, b WalletReputation) int {
+		return cmp.Or(cmp.Compare(a.Score, b.Score), strings.Compare(a.Wallet, b.Wallet))
+	})
+	if len(reps) > limit {
+		reps = reps[:limit]
+	}
+	c.JSON(200, ReputationReport{Wallets: reps})
+}
+
+// handleWalletReputation handles GET /admin/reputation/:wallet. The payment
+// history is read from the ledger first, so it is current even for a
+// wallet this instance hasn't served.
+func handleWalletReputation(c *gin.Context) {
+	wallet, ok := resolveWalletParam(c, c.Param("wallet"))
+	if !ok {
+		return
+	}
+	if usageLedger != nil {
+		if err := walletTiers.refresh(c.Request.Context(), wallet); err != nil {
+			abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read the ledger", err.Error()))
+			return
+		}
+	}
+	c.JSON(200, reputationOf(wallet))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupTestReputation starts the test with no signals, payment history or
+// abuse scores, and restores them afterwards.
+func setupTestReputation(t *testing.T) {
+	t.Helper()
+	prevRep, prevTiers, prevAbuse := walletReputation, walletTiers, abuseScores
+	walletReputation, walletTiers, abuseScores = newReputationTracker(), newWalletTierCache(), newAbuseTracker()
+	t.Cleanup(func() { walletReputation, walletTiers, abuseScores = prevRep, prevTiers, prevAbuse })
+}
+
+func TestReputationOf(t *testing.T) {
+	setupTestReputation(t)
+	ledger := setupTestLedger(t)
+	const wallet = "0x00000000000000000000000000000000000000ab"
+
+	if rep := reputationOf(wallet); rep.Score != reputationNeutral || rep.Low || rep.Tier != "standard" {
+		t.Errorf("Expected a new wallet to be neutral, got %+v", rep)
+	}
+
+	for _, nonce := range []string{"n1", "n2", "n3", "n4"} {
+		ledger.Append(context.Background(), LedgerEntry{Wallet: wallet, Nonce: nonce, Time: time.Now()})
+	}
+	ledger.Append(context.Background(), LedgerEntry{Wallet: wallet, Nonce: "n4", Type: ledgerTypeRefund, Time: time.Now()})
+	walletTiers.refresh(context.Background(), wallet)
+	// 50 + 3 payments - 40 * 1/4 refunded
+	if rep := reputationOf(wallet); rep.Score != 43 || rep.Payments != 3 || rep.Refunds != 1 {
+		t.Errorf("Expected 43 from the payment history, got %+v", rep)
+	}
+
+	for range 20 {
+		walletReputation.record(wallet, signalRateLimited)
+	}
+	walletReputation.record(wallet, signalModerationFlag)
+	// Rate-limit refusals are capped at 20 points
+	if rep := reputationOf(wallet); rep.Score != 8 || rep.RateLimited != 20 || rep.ModerationFlags != 1 || !rep.Low || rep.Tier != "anonymous" {
+		t.Errorf("Expected a low score of 8, got %+v", rep)
+	}
+
+	t.Setenv("REPUTATION_WINDOW_HOURS", "1")
+	walletReputation.records[wallet].lastSeen = time.Now().Add(-2 * time.Hour)
+	if rep := reputationOf(wallet); rep.Score != 43 || rep.LastSeen != nil {
+		t.Errorf("Expected signals outside the window to be forgotten, got %+v", rep)
+	}
+}
+
+func TestRateLimitMiddleware_IgnoresUnverifiedReputation(t *testing.T) {
+	setupTestReputation(t)
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RATE_LIMIT_STANDARD_RPM", "1")
+	t.Setenv("RATE_LIMIT_STANDARD_BURST", "1")
+	r := gin.New()
+	r.Use(RateLimitMiddleware(initRateLimiters()))
+	r.GET("/test", func(c *gin.Context) { c.Status(200) })
+
+	// Anyone can replay a wallet's signed X-402-Payment, so it neither
+	// demotes the request nor costs the wallet reputation
+	payment := PaymentContext{Recipient: defaultRecipientAddress, Token: "USDC", Amount: "0.001", Nonce: "n-rep", ChainID: 8453}
+	wallet, signature, declared := signTestPayment(t, testWalletKey, payment)
+	for range 4 {
+		walletReputation.record(wallet, signalModerationFlag)
+	}
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-402-Signature", signature)
+		req.Header.Set("X-402-Nonce", payment.Nonce)
+		req.Header.Set(paymentHeader, declared)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := send(); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Errorf("Expected the standard tier, got %d with limit %s", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+	if w := send(); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", w.Code)
+	}
+	if rep := reputationOf(wallet); rep.RateLimited != 0 {
+		t.Errorf("Expected no signal recorded before verification, got %+v", rep)
+	}
+}
+
+func TestAllowWalletRequest_LowReputation(t *testing.T) {
+	setupTestReputation(t)
+	setupTestRateLimitOverrides(t)
+	gin.SetMode(gin.TestMode)
+	activeRateLimits = &rateLimitSet{tiers: initRateLimiters(), custom: newCustomRateLimiters(time.Minute)}
+	const wallet = "0x00000000000000000000000000000000000000cd"
+	allow := func() (bool, string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/v1/ai/summarize", nil)
+		return allowWalletRequest(c, wallet), w.Header().Get("X-RateLimit-Limit")
+	}
+
+	if ok, limit := allow(); !ok || limit != "" {
+		t.Errorf("Expected a neutral wallet let through without a limit of its own, got %v %q", ok, limit)
+	}
+	for range 4 {
+		walletReputation.record(wallet, signalModerationFlag)
+	}
+	if ok, limit := allow(); !ok || limit != "10" {
+		t.Errorf("Expected the anonymous tier for a low reputation, got %v %q", ok, limit)
+	}
+	t.Setenv("REPUTATION_LOW_SCORE", "0")
+	if ok, limit := allow(); !ok || limit != "" {
+		t.Errorf("Expected no restriction with REPUTATION_LOW_SCORE=0, got %v %q", ok, limit)
+	}
+}
+
+func TestHandleSummarize_ReputationSurcharge(t *testing.T) {
+	setupTestReputation(t)
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	verifier.VerifySignatures()
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("PAYMENT_AMOUNT", "0.002")
+	t.Setenv("REPUTATION_SURCHARGE_PERCENT", "50")
+	r := setupVersionedRouter()
+	text := "a text from a wallet with a poor record"
+
+	send := func(key, amount, nonce string, declare bool) *httptest.ResponseRecorder {
+		payment := PaymentContext{Recipient: defaultRecipientAddress, Token: "USDC", Amount: amount, Nonce: nonce, ChainID: 8453, BodyHash: paymentBodyHash(text)}
+		_, signature, declared := signTestPayment(t, key, payment)
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"`+text+`"}`))
+		req.Header.Set("X-402-Signature", signature)
+		req.Header.Set("X-402-Nonce", payment.Nonce)
+		if declare {
+			req.Header.Set(paymentHeader, declared)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	wallet, _, _ := signTestPayment(t, testWalletKey, PaymentContext{Recipient: defaultRecipientAddress})
+	for range 3 {
+		walletReputation.record(wallet, signalModerationFlag)
+	}
+	surcharges := reputationSurchargesTotal.Value()
+	// The wallet is known once its signature verifies, with or without
+	// X-402-Payment
+	w := send(testWalletKey, "0.002", "n-surcharge-1", false)
+	if p := decodeProblem(t, w); w.Code != http.StatusPaymentRequired || p["code"] != codeInsufficientPayment || p["required"] != "0.003" {
+		t.Fatalf("Expected 402 insufficient_payment for the surcharged price, got %d %v", w.Code, p)
+	}
+	if verifier.Calls() != 1 || ai.Calls() != 0 || reputationSurchargesTotal.Value()-surcharges != 1 {
+		t.Error("Expected the surcharge counted and the request refused after verification, before any work")
+	}
+	if w := send(testWalletKey, "0.003", "n-surcharge-2", true); w.Code != http.StatusOK {
+		t.Errorf("Expected the surcharged price accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Another wallet pays the regular price
+	if w := send("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318", "0.002", "n-surcharge-3", true); w.Code != http.StatusOK {
+		t.Errorf("Expected the regular price accepted, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminReputation(t *testing.T) {
+	setupTestReputation(t)
+	setupTestLedger(t)
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	const good, bad = "0x00000000000000000000000000000000000000aa", "0x00000000000000000000000000000000000000bb"
+	usageLedger.Append(context.Background(), LedgerEntry{Wallet: good, Nonce: "n1", Time: time.Now()})
+	walletReputation.record(bad, signalFailedVerification)
+	abuseScores.record(bad, []string{"ignore_instructions"})
+
+	w := adminRequest(r, "GET", "/admin/reputation/"+good, "secret")
+	var rep WalletReputation
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil || w.Code != 200 || rep.Score != 51 || rep.Payments != 1 {
+		t.Fatalf("Expected the wallet's score from the ledger, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = adminRequest(r, "GET", "/admin/reputation", "secret")
+	var report ReputationReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != 200 {
+		t.Fatalf("Expected the report, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(report.Wallets) != 2 || report.Wallets[0].Wallet != bad || report.Wallets[0].Score != 30 || report.Wallets[0].AbuseScore != 1 || report.Wallets[1].Wallet != good {
+		t.Errorf("Expected both wallets, lowest score first, got %+v", report.Wallets)
+	}
+
+	if w := adminRequest(r, "GET", "/admin/reputation/not-a-wallet", "secret"); decodeProblem(t, w)["code"] != codeInvalidWallet {
+		t.Errorf("Expected invalid_wallet, got %d", w.Code)
+	}
+	if w := adminRequest(r, "GET", "/admin/reputation?limit=0", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var compressedRequestsTotal = newCounter(
+	"gateway_compressed_requests_total",
+	"Request bodies received with a Content-Encoding, by encoding and outcome (ok, invalid, too_large).",
+	"encoding", "outcome")
+
+// DecompressRequestBody accepts request bodies sent with Content-Encoding
+// gzip or deflate, so clients can upload large documents compressed. The
+// body is inflated here, up to limit bytes of decompressed data, and the
+// request then looks uncompressed to the handlers: the size limits, input
+// validation and receipt hashes all see the decompressed body. Other
+// encodings are rejected with 415.
+func DecompressRequestBody(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			c.Next()
+			return
+		}
+
+		var (
+			r   io.ReadCloser
+			err error
+		)
+		switch encoding {
+		case "gzip", "x-gzip":
+			encoding = "gzip"
+			r, err = gzip.NewReader(c.Request.Body)
+		case "deflate":
+			r, err = zlib.NewReader(c.Request.Body)
+		default:
+			abortWithProblem(c, newProblem(415, codeUnsupportedEncoding, "Unsupported Media Type",
+				"Content-Encoding "+encoding+" is not supported").With("supported_encodings", []string{"gzip", "deflate"}))
+			return
+		}
+		var body []byte
+		if err == nil {
+			body, err = io.ReadAll(io.LimitReader(r, limit+1))
+			r.Close()
+		}
+		switch {
+		case err != nil:
+			compressedRequestsTotal.Inc(encoding, "invalid")
+			abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body",
+				"Body is not valid "+encoding+" data: "+err.Error()))
+			return
+		case int64(len(body)) > limit:
+			compressedRequestsTotal.Inc(encoding, "too_large")
+			size := fmt.Sprintf("%dMB", limit>>20)
+			abortWithProblem(c, newProblem(413, codePayloadTooLarge, "Payload Too Large", "Decompressed request body exceeds "+size).
+				With("max_size", size))
+			return
+		}
+		compressedRequestsTotal.Inc(encoding, "ok")
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		c.Request.Header.Del("Content-Encoding")
+		c.Next()
+	}
+}
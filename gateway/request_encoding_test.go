@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser = gzip.NewWriter(&buf)
+	if encoding == "deflate" {
+		w = zlib.NewWriter(&buf)
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecompressRequestBody_PaidSummary(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+
+	for i, encoding := range []string{"gzip", "deflate"} {
+		body := compress(t, encoding, []byte(`{"text":"A long document sent compressed `+encoding+`"}`))
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", encoding)
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", "n-compressed-"+encoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", encoding, w.Code, w.Body.String())
+		}
+		if reqs := ai.Requests(); len(reqs) != i+1 || !strings.Contains(reqs[i].Prompt(), "sent compressed "+encoding) {
+			t.Errorf("%s: expected the decompressed text to reach the provider", encoding)
+		}
+	}
+}
+
+func TestDecompressRequestBody_QuotesCompressedChallenge(t *testing.T) {
+	r := setupVersionedRouter()
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", bytes.NewReader(compress(t, "gzip", []byte(`{"text":"one two three"}`))))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if p := decodeProblem(t, w); w.Code != 402 || p["tokens"] == nil {
+		t.Errorf("Expected a 402 quote for the decompressed text, got %d %v", w.Code, p)
+	}
+}
+
+func TestDecompressRequestBody_Rejects(t *testing.T) {
+	verifier := testsupport.NewFakeVerifier(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	r := setupVersionedRouter()
+
+	// 11MB of JSON compresses to a few KB
+	bomb := compress(t, "gzip", []byte(`{"text":"`+strings.Repeat("a", 11*1024*1024)+`"}`))
+	tests := []struct {
+		name, encoding string
+		body           []byte
+		status         int
+		code           string
+	}{
+		{"over the limit once inflated", "gzip", bomb, 413, codePayloadTooLarge},
+		{"corrupt", "gzip", []byte("not gzip at all"), 400, codeInvalidRequestBody},
+		{"truncated", "deflate", compress(t, "deflate", []byte(`{"text":"hello"}`))[:8], 400, codeInvalidRequestBody},
+		{"unsupported", "br", []byte(`{"text":"hello"}`), 415, codeUnsupportedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/v1/ai/summarize", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", tt.encoding)
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", "n-rejected")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status || decodeProblem(t, w)["code"] != tt.code {
+				t.Errorf("Expected %d %s, got %d: %s", tt.status, tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+	if n := verifier.Calls(); n != 0 {
+		t.Errorf("Expected rejected bodies never to reach the verifier, got %d calls", n)
+	}
+}
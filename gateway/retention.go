@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// Data classes with a retention window of their own.
+const (
+	retentionRequestHashes = "request_hashes" // idempotency records: request fingerprints and replayable responses
+	retentionReceipts      = "receipts"
+	retentionUsage         = "usage" // usage ledger entries
+	retentionCache         = "cache" // cached summaries
+)
+
+// retentionClasses lists the data classes in purge order, with the setting
+// holding each one's window in hours.
+var retentionClasses = []struct{ class, env string }{
+	{retentionRequestHashes, "RETENTION_REQUEST_HASHES_HOURS"},
+	{retentionReceipts, "RETENTION_RECEIPTS_HOURS"},
+	{retentionUsage, "RETENTION_USAGE_HOURS"},
+	{retentionCache, "RETENTION_CACHE_HOURS"},
+}
+
+var (
+	retentionPurgedTotal = newCounter(
+		"gateway_retention_purged_total",
+		"Records removed by the retention purge, by data class (request_hashes, receipts, usage, cache).",
+		"class",
+	)
+	retentionPurgeErrorsTotal = newCounter(
+		"gateway_retention_purge_errors_total",
+		"Retention purges of a data class that failed.",
+		"class",
+	)
+)
+
+// retentionPurger deletes data older than its class's window. It is nil
+// unless a RETENTION_*_HOURS setting is set.
+var retentionPurger *retentionScheduler
+
+// retentionScheduler purges each data class with a window on a fixed
+// interval. The purges are idempotent, so every instance runs its own: the
+// in-memory stores are per instance anyway, and Redis and the store simply
+// find nothing left to remove after the first.
+type retentionScheduler struct {
+	windows  map[string]time.Duration
+	interval time.Duration
+}
+
+// initRetention reads the retention windows. A class without one keeps its
+// data for as long as the feature that stores it does.
+func initRetention() *retentionScheduler {
+	windows := make(map[string]time.Duration)
+	for _, c := range retentionClasses {
+		if hours := getEnvAsInt(c.env, 0); hours > 0 {
+			windows[c.class] = time.Duration(hours) * time.Hour
+		}
+	}
+	if len(windows) == 0 {
+		return nil
+	}
+	return &retentionScheduler{
+		windows:  windows,
+		interval: time.Duration(getEnvAsInt("RETENTION_PURGE_INTERVAL_SECONDS", 3600)) * time.Second,
+	}
+}
+
+// start purges once right away, so a shortened window applies without
+// waiting for the first tick, and then every RETENTION_PURGE_INTERVAL_SECONDS
+// (default 3600) until ctx is done.
+func (r *retentionScheduler) start(ctx context.Context) {
+	go func() {
+		r.run(ctx)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.run(ctx)
+			}
+		}
+	}()
+	var windows []string
+	for _, c := range retentionClasses {
+		if window, ok := r.windows[c.class]; ok {
+			windows = append(windows, c.class+" "+window.String())
+		}
+	}
+	log.Printf("Retention purge every %s: %s", r.interval, strings.Join(windows, ", "))
+}
+
+// run purges every class with a window once and returns how many records
+// each lost. A failed class is logged and counted, and doesn't stop the
+// others.
+func (r *retentionScheduler) run(ctx context.Context) map[string]int64 {
+	now := time.Now()
+	purged := make(map[string]int64, len(r.windows))
+	for _, c := range retentionClasses {
+		window, ok := r.windows[c.class]
+		if !ok {
+			continue
+		}
+		n, err := purgeRetentionClass(ctx, c.class, now.Add(-window))
+		purged[c.class] = n
+		if n > 0 {
+			retentionPurgedTotal.Add(float64(n), c.class)
+			log.Printf("Retention purge removed %d %s records older than %s", n, c.class, window)
+		}
+		if err != nil {
+			retentionPurgeErrorsTotal.Inc(c.class)
+			log.Printf("Retention purge of %s failed: %v", c.class, err)
+		}
+	}
+	return purged
+}
+
+// purgeRetentionClass removes class's records from before before wherever
+// they are kept, and returns how many it removed.
+func purgeRetentionClass(ctx context.Context, class string, before time.Time) (int64, error) {
+	switch class {
+	case retentionRequestHashes:
+		return currentIdempotencyStore().purge(ctx, func(rec idempotencyRecord) bool {
+			return rec.StoredAt.Before(before)
+		})
+	case retentionReceipts:
+		n := purgeReceiptsBefore(before)
+		if dataStore == nil {
+			return n, nil
+		}
+		stored, err := dataStore.PurgeReceipts(ctx, before)
+		return n + stored, err
+	case retentionUsage:
+		if usageLedger == nil {
+			return 0, nil
+		}
+		return usageLedger.Purge(ctx, before)
+	case retentionCache:
+		return purgeCacheBefore(ctx, before)
+	}
+	return 0, nil
+}
+
+// purgeReceiptsBefore removes the receipts issued before before from this
+// instance's receipt store.
+func purgeReceiptsBefore(before time.Time) int64 {
+	receiptStoreMu.Lock()
+	defer receiptStoreMu.Unlock()
+	var n int64
+	for id, entry := range receiptStore {
+		if entry.receipt.Receipt.Timestamp.Before(before) {
+			delete(receiptStore, id)
+			n++
+		}
+	}
+	return n
+}
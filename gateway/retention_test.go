@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInitRetention(t *testing.T) {
+	if initRetention() != nil {
+		t.Error("Expected no purge without a retention window")
+	}
+	t.Setenv("RETENTION_RECEIPTS_HOURS", "720")
+	r := initRetention()
+	if r == nil || r.windows[retentionReceipts] != 720*time.Hour || len(r.windows) != 1 || r.interval != time.Hour {
+		t.Errorf("Expected only receipts purged, hourly, got %+v", r)
+	}
+}
+
+func TestRetention_PurgesEachClass(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Hour)
+	mr := setupTestRedis(t)
+	ledger := setupTestLedger(t)
+	ctx := context.Background()
+	old, recent := time.Now().Add(-48*time.Hour).UTC(), time.Now().UTC()
+
+	for id, issued := range map[string]time.Time{"rcpt_old": old, "rcpt_recent": recent} {
+		r := &SignedReceipt{Receipt: Receipt{ID: id, Timestamp: issued}}
+		receiptStoreMu.Lock()
+		receiptStore[id] = &receiptEntry{receipt: r, expiresAt: time.Now().Add(time.Hour)}
+		receiptStoreMu.Unlock()
+	}
+	t.Cleanup(func() {
+		receiptStoreMu.Lock()
+		delete(receiptStore, "rcpt_recent")
+		receiptStoreMu.Unlock()
+	})
+	store := currentIdempotencyStore()
+	store.save(ctx, "idem-old", idempotencyRecord{Fingerprint: "f1", Status: 200, StoredAt: old}, time.Hour)
+	store.save(ctx, "idem-recent", idempotencyRecord{Fingerprint: "f2", Status: 200, StoredAt: recent}, time.Hour)
+	ledger.Append(ctx, LedgerEntry{ReceiptID: "rcpt_old", Time: old})
+	ledger.Append(ctx, LedgerEntry{ReceiptID: "rcpt_recent", Time: recent})
+	for key, cachedAt := range map[string]time.Time{"old": old, "recent": recent} {
+		cached := &CachedResponse{Result: key, CachedAt: cachedAt}
+		memoryCache.Set(currentCacheKeyPrefix()+key, cached)
+		data, _ := encodeCachedResponse(currentCacheKeyPrefix()+key, cached)
+		mr.Set(currentCacheKeyPrefix()+key, string(data))
+	}
+
+	r := &retentionScheduler{windows: map[string]time.Duration{
+		retentionRequestHashes: 24 * time.Hour,
+		retentionReceipts:      24 * time.Hour,
+		retentionUsage:         24 * time.Hour,
+		retentionCache:         24 * time.Hour,
+	}}
+	before := retentionPurgedTotal.Value(retentionCache)
+	purged := r.run(ctx)
+	want := map[string]int64{retentionRequestHashes: 1, retentionReceipts: 1, retentionUsage: 1, retentionCache: 2}
+	for class, n := range want {
+		if purged[class] != n {
+			t.Errorf("Expected %d %s records purged, got %d", n, class, purged[class])
+		}
+	}
+	if got := retentionPurgedTotal.Value(retentionCache) - before; got != 2 {
+		t.Errorf("Expected 2 cache purges counted, got %v", got)
+	}
+
+	if _, ok := getReceipt("rcpt_old"); ok {
+		t.Error("Expected the old receipt gone")
+	}
+	if _, ok := getReceipt("rcpt_recent"); !ok {
+		t.Error("Expected the recent receipt kept")
+	}
+	if mr.Exists(idempotencyKeyPrefix+"idem-old") || !mr.Exists(idempotencyKeyPrefix+"idem-recent") {
+		t.Error("Expected only the old idempotency record purged")
+	}
+	if entries, _ := ledger.Entries(ctx, ledgerQuery{}); len(entries) != 1 || entries[0].ReceiptID != "rcpt_recent" {
+		t.Errorf("Expected only the recent ledger entry kept, got %+v", entries)
+	}
+	if _, ok := memoryCache.Get(currentCacheKeyPrefix() + "old"); ok || mr.Exists(currentCacheKeyPrefix()+"old") {
+		t.Error("Expected the old summary purged from both tiers")
+	}
+	if !mr.Exists(currentCacheKeyPrefix() + "recent") {
+		t.Error("Expected the recent summary kept")
+	}
+}
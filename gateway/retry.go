@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var aiProviderRetriesTotal = newCounter(
+	"gateway_ai_provider_retries_total",
+	"Upstream AI calls retried on the same provider after a transient error.",
+	"provider",
+)
+
+// getAIMaxRetries returns AI_MAX_RETRIES (default 2), how many times a
+// provider call is retried before failing over; 0 disables retries.
+func getAIMaxRetries() int {
+	return getEnvAsInt("AI_MAX_RETRIES", 2)
+}
+
+// getAIRetryBaseDelay returns AI_RETRY_BASE_DELAY_MS (default 200), the
+// backoff before the first retry. It doubles for each further retry.
+func getAIRetryBaseDelay() time.Duration {
+	return time.Duration(getEnvAsInt("AI_RETRY_BASE_DELAY_MS", 200)) * time.Millisecond
+}
+
+// getAIRetryMaxDelay returns AI_RETRY_MAX_DELAY_MS (default 5000), the cap on
+// a single backoff, including one asked for by Retry-After.
+func getAIRetryMaxDelay() time.Duration {
+	return time.Duration(getEnvAsInt("AI_RETRY_MAX_DELAY_MS", 5000)) * time.Millisecond
+}
+
+// completeWithRetry calls provider name, retrying transient failures (see
+// isRetryableError) up to AI_MAX_RETRIES times. Backoff is exponential with
+// full jitter, or the provider's Retry-After when that is longer. A retry
+// whose wait would not leave time before ctx's deadline is not attempted:
+// the error is returned so the caller can fail over instead.
+func completeWithRetry(ctx context.Context, name, model, prompt string) (string, error) {
+	provider := aiProviders[name]
+	maxRetries := getAIMaxRetries()
+	for retry := 0; ; retry++ {
+		reply, err := provider.Complete(ctx, model, prompt)
+		if err == nil || retry >= maxRetries || !isRetryableError(ctx, err) {
+			return reply, err
+		}
+
+		wait := retryBackoff(retry, retryAfterOf(err))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return "", err
+		}
+		aiProviderRetriesTotal.Inc(name)
+		log.Printf("AI provider %s failed (%v), retrying in %s", name, err, wait.Round(time.Millisecond))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", err
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryableError reports whether err is worth retrying on the same
+// provider: a 429 or 5xx, or a connection that failed or was reset. Timeouts
+// are not retried because the attempt's deadline is already spent.
+func isRetryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *providerStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return !urlErr.Timeout()
+	}
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryBackoff returns the wait before retry number retry+1: a random
+// duration up to AI_RETRY_BASE_DELAY_MS * 2^retry, or retryAfter if longer,
+// capped at AI_RETRY_MAX_DELAY_MS.
+func retryBackoff(retry int, retryAfter time.Duration) time.Duration {
+	maxDelay := getAIRetryMaxDelay()
+	ceiling := min(getAIRetryBaseDelay()<<retry, maxDelay)
+	wait := time.Duration(0)
+	if ceiling > 0 {
+		wait = rand.N(ceiling) + 1
+	}
+	return min(max(wait, retryAfter), maxDelay)
+}
+
+// retryAfterOf returns the Retry-After a provider sent with err, or 0.
+func retryAfterOf(err error) time.Duration {
+	var statusErr *providerStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date. Anything else, or a time in the past, yields 0.
+func parseRetryAfter(raw string) time.Duration {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(raw); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(raw); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
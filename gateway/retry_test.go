@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"429", &providerStatusError{StatusCode: 429}, true},
+		{"503", &providerStatusError{StatusCode: 503}, true},
+		{"400", &providerStatusError{StatusCode: 400}, false},
+		{"connection refused", &url.Error{Op: "Post", URL: "http://x", Err: errors.New("connection refused")}, true},
+		{"timeout", context.DeadlineExceeded, false},
+		{"decode error", errors.New("failed to decode AI response"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableError(ctx, tt.err); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("Expected 3s, got %s", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)); got < 8*time.Second || got > 10*time.Second {
+		t.Errorf("Expected about 10s from an HTTP date, got %s", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("Expected 0 for garbage, got %s", got)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	t.Setenv("AI_RETRY_BASE_DELAY_MS", "100")
+	t.Setenv("AI_RETRY_MAX_DELAY_MS", "1000")
+	for retry := 0; retry < 6; retry++ {
+		ceiling := min(100*time.Millisecond<<retry, time.Second)
+		if got := retryBackoff(retry, 0); got <= 0 || got > ceiling {
+			t.Errorf("Retry %d: expected a wait in (0, %s], got %s", retry, ceiling, got)
+		}
+	}
+	if got := retryBackoff(0, 700*time.Millisecond); got != 700*time.Millisecond {
+		t.Errorf("Expected Retry-After to win over a shorter backoff, got %s", got)
+	}
+	if got := retryBackoff(0, time.Minute); got != time.Second {
+		t.Errorf("Expected Retry-After capped at the max delay, got %s", got)
+	}
+}
+
+func TestCallAIPrompt_RetriesTransientError(t *testing.T) {
+	ai := testsupport.NewFakeOpenRouter(t)
+	var calls atomic.Int32
+	ai.ReplyFunc(func(testsupport.ChatRequest) (string, error) {
+		if calls.Add(1) == 1 {
+			return "", errors.New("blip")
+		}
+		return "second time lucky", nil
+	})
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("AI_RETRY_BASE_DELAY_MS", "10")
+
+	reply, err := callAIPrompt(context.Background(), "", "prompt")
+	if err != nil || reply != "second time lucky" {
+		t.Fatalf("Expected the retry to succeed, got %q, %v", reply, err)
+	}
+	if ai.Calls() != 2 {
+		t.Errorf("Expected 2 calls, got %d", ai.Calls())
+	}
+}
+
+func TestCompleteWithRetry_HonorsRetryAfterAndDeadline(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(429)
+	}))
+	defer srv.Close()
+	t.Setenv("OPENROUTER_URL", srv.URL)
+	t.Setenv("AI_RETRY_BASE_DELAY_MS", "10")
+
+	// Retry-After 1s does not fit in a 500ms deadline: give up at once
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := completeWithRetry(ctx, "openrouter", "", "prompt")
+	var statusErr *providerStatusError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != time.Second {
+		t.Fatalf("Expected the 429 with its Retry-After, got %v", err)
+	}
+	if calls.Load() != 1 || time.Since(start) > 250*time.Millisecond {
+		t.Errorf("Expected one call and no wait, got %d calls in %s", calls.Load(), time.Since(start))
+	}
+
+	// Without a deadline the retries wait out Retry-After
+	calls.Store(0)
+	t.Setenv("AI_MAX_RETRIES", "1")
+	start = time.Now()
+	completeWithRetry(context.Background(), "openrouter", "", "prompt")
+	if calls.Load() != 2 || time.Since(start) < time.Second {
+		t.Errorf("Expected a retry after 1s, got %d calls in %s", calls.Load(), time.Since(start))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ethRPC calls method on the JSON-RPC endpoint at rpcURL and decodes the
+// result into out.
+func ethRPC(ctx context.Context, client *http.Client, rpcURL, method string, params []interface{}, out interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", rpcURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("RPC returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	var result struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("malformed RPC response: %w", err)
+	}
+	if result.Error != nil {
+		return fmt.Errorf("RPC error: %s", result.Error.Message)
+	}
+	if err := json.Unmarshal(result.Result, out); err != nil {
+		return fmt.Errorf("malformed %s result: %w", method, err)
+	}
+	return nil
+}
+
+// ethCall makes an eth_call to the contract at to against the latest block
+// of the JSON-RPC endpoint at rpcURL, returning the raw result.
+func ethCall(ctx context.Context, client *http.Client, rpcURL, to string, data []byte) ([]byte, error) {
+	var out hexutil.Bytes
+	params := []interface{}{map[string]string{"to": to, "data": hexutil.Encode(data)}, "latest"}
+	if err := ethRPC(ctx, client, rpcURL, "eth_call", params, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ethBlockNumber returns the number of the latest block.
+func ethBlockNumber(ctx context.Context, client *http.Client, rpcURL string) (uint64, error) {
+	var n hexutil.Uint64
+	err := ethRPC(ctx, client, rpcURL, "eth_blockNumber", []interface{}{}, &n)
+	return uint64(n), err
+}
+
+// txReceipt is the part of a transaction receipt the gateway reads.
+type txReceipt struct {
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	BlockHash   string         `json:"blockHash"`
+	Status      hexutil.Uint64 `json:"status"`
+}
+
+// ethTransactionReceipt returns the receipt of txHash, or nil while the
+// transaction isn't mined.
+func ethTransactionReceipt(ctx context.Context, client *http.Client, rpcURL, txHash string) (*txReceipt, error) {
+	var receipt *txReceipt
+	err := ethRPC(ctx, client, rpcURL, "eth_getTransactionReceipt", []interface{}{txHash}, &receipt)
+	return receipt, err
+}
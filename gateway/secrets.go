@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretURIPrefix marks a setting whose value must be fetched from a secrets
+// backend instead of being used literally, e.g.
+//
+//	OPENROUTER_API_KEY=secret://vault/secret/data/paygate#openrouter_api_key
+//	REDIS_PASSWORD=secret://aws/prod/paygate/redis#password
+const secretURIPrefix = "secret://"
+
+var (
+	// secretRefs remembers which env vars were secret references so they can
+	// be re-resolved when secrets are rotated.
+	secretRefs   = make(map[string]string)
+	secretRefsMu sync.Mutex
+)
+
+// secretRef is a parsed secret:// URI.
+type secretRef struct {
+	Backend string // "vault" or "aws"
+	Path    string // Vault path or AWS secret id
+	Field   string // key inside the secret; empty means the whole value
+}
+
+func parseSecretURI(uri string) (secretRef, error) {
+	rest, ok := strings.CutPrefix(uri, secretURIPrefix)
+	if !ok {
+		return secretRef{}, fmt.Errorf("%q is not a secret:// URI", uri)
+	}
+	rest, field, _ := strings.Cut(rest, "#")
+	backend, path, _ := strings.Cut(rest, "/")
+	if path == "" {
+		return secretRef{}, fmt.Errorf("%q is missing a secret path", uri)
+	}
+	switch backend {
+	case "vault", "aws":
+	default:
+		return secretRef{}, fmt.Errorf("%q: unknown secrets backend %q (use vault or aws)", uri, backend)
+	}
+	return secretRef{Backend: backend, Path: path, Field: field}, nil
+}
+
+// resolveSecrets replaces every environment variable holding a secret://
+// reference with the fetched value. It runs at startup before the config is
+// validated, and again on each rotation tick for the references it saw.
+func resolveSecrets(ctx context.Context) error {
+	secretRefsMu.Lock()
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(value, secretURIPrefix) {
+			secretRefs[name] = value
+		}
+	}
+	refs := make(map[string]string, len(secretRefs))
+	for name, uri := range secretRefs {
+		refs[name] = uri
+	}
+	secretRefsMu.Unlock()
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems, rotated []string
+	for _, name := range names {
+		value, err := fetchSecret(ctx, refs[name])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if current := os.Getenv(name); current != value && current != refs[name] {
+			rotated = append(rotated, name)
+		}
+		os.Setenv(name, value)
+	}
+	if len(rotated) > 0 {
+		recordAudit(ctx, AuditEntry{Action: auditSecretsRotated, Actor: "system", Details: map[string]string{"names": strings.Join(rotated, ",")}})
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	if len(names) > 0 {
+		log.Printf("Resolved %d secrets from secrets backend", len(names))
+	}
+	return nil
+}
+
+// startSecretRotation re-resolves secret references every
+// SECRETS_REFRESH_INTERVAL_SECONDS (disabled when 0, the default). Settings
+// read per request, such as OPENROUTER_API_KEY and REDIS_PASSWORD, pick up
+// the new values without a restart. Failures keep the previous values.
+func startSecretRotation(ctx context.Context) {
+	interval := time.Duration(getEnvAsInt("SECRETS_REFRESH_INTERVAL_SECONDS", 0)) * time.Second
+	secretRefsMu.Lock()
+	n := len(secretRefs)
+	secretRefsMu.Unlock()
+	if interval <= 0 || n == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := resolveSecrets(ctx); err != nil {
+					log.Printf("secret rotation failed, keeping previous values: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Secret rotation enabled (every %s)", interval)
+}
+
+// fetchSecret resolves a single secret:// URI.
+func fetchSecret(ctx context.Context, uri string) (string, error) {
+	ref, err := parseSecretURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	switch ref.Backend {
+	case "vault":
+		return fetchVaultSecret(ctx, ref)
+	default:
+		return fetchAWSSecret(ctx, ref)
+	}
+}
+
+// fetchVaultSecret reads ref.Path from Vault (VAULT_ADDR, VAULT_TOKEN and
+// optional VAULT_NAMESPACE). Both KV v2 (data.data) and KV v1 (data) layouts
+// are understood.
+func fetchVaultSecret(ctx context.Context, ref secretRef) (string, error) {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set to use vault secrets")
+	}
+	if ref.Field == "" {
+		return "", fmt.Errorf("vault secrets need a #field")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", addr+"/v1/"+strings.TrimLeft(ref.Path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	body, err := doSecretRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("vault: invalid response: %w", err)
+	}
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested // KV v2
+	}
+	value, ok := data[ref.Field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault: field %q not found at %s", ref.Field, ref.Path)
+	}
+	return value, nil
+}
+
+// fetchAWSSecret calls Secrets Manager GetSecretValue for ref.Path using
+// AWS_REGION and static credentials from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and optional AWS_SESSION_TOKEN. With a #field the
+// secret string is parsed as JSON and that key returned.
+func fetchAWSSecret(ctx context.Context, ref secretRef) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if region == "" || accessKey == "" || secretKey == "" {
+		return "", fmt.Errorf("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to use aws secrets")
+	}
+
+	endpoint := os.Getenv("AWS_SECRETS_MANAGER_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("aws: invalid endpoint: %w", err)
+	}
+
+	payload, _ := json.Marshal(map[string]string{"SecretId": ref.Path})
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAWSRequestV4(req, payload, accessKey, secretKey, region, "secretsmanager", time.Now().UTC())
+
+	body, err := doSecretRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("aws: %w", err)
+	}
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("aws: invalid response: %w", err)
+	}
+	if ref.Field == "" {
+		return resp.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("aws: secret %s is not JSON, cannot select #%s", ref.Path, ref.Field)
+	}
+	value, ok := fields[ref.Field].(string)
+	if !ok {
+		return "", fmt.Errorf("aws: field %q not found in %s", ref.Field, ref.Path)
+	}
+	return value, nil
+}
+
+func doSecretRequest(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// signAWSRequestV4 adds an AWS Signature Version 4 Authorization header.
+func signAWSRequestV4(req *http.Request, payload []byte, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
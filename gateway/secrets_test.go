@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearSecretRefs isolates the package-level reference registry per test.
+func clearSecretRefs(t *testing.T) {
+	t.Helper()
+	secretRefsMu.Lock()
+	secretRefs = make(map[string]string)
+	secretRefsMu.Unlock()
+	t.Cleanup(func() {
+		secretRefsMu.Lock()
+		secretRefs = make(map[string]string)
+		secretRefsMu.Unlock()
+	})
+}
+
+func TestParseSecretURI(t *testing.T) {
+	ref, err := parseSecretURI("secret://vault/secret/data/paygate#api_key")
+	if err != nil || ref.Backend != "vault" || ref.Path != "secret/data/paygate" || ref.Field != "api_key" {
+		t.Errorf("Unexpected parse: %+v, %v", ref, err)
+	}
+
+	for _, uri := range []string{"secret://gcp/x#y", "secret://vault", "vault/x#y"} {
+		if _, err := parseSecretURI(uri); err == nil {
+			t.Errorf("Expected error for %q", uri)
+		}
+	}
+}
+
+func TestResolveSecrets_Vault(t *testing.T) {
+	clearSecretRefs(t)
+	version := "v1"
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.URL.Path != "/v1/secret/data/paygate" {
+			w.WriteHeader(403)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"api_key":"key-` + version + `"}}}`))
+	}))
+	defer vault.Close()
+
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	t.Setenv("TEST_SECRET_API_KEY", "secret://vault/secret/data/paygate#api_key")
+
+	if err := resolveSecrets(context.Background()); err != nil {
+		t.Fatalf("resolveSecrets failed: %v", err)
+	}
+	if got := os.Getenv("TEST_SECRET_API_KEY"); got != "key-v1" {
+		t.Fatalf("Expected resolved secret, got %q", got)
+	}
+
+	// Rotation re-resolves the remembered reference even though the env
+	// var now holds the plain value.
+	version = "v2"
+	if err := resolveSecrets(context.Background()); err != nil {
+		t.Fatalf("resolveSecrets failed: %v", err)
+	}
+	if got := os.Getenv("TEST_SECRET_API_KEY"); got != "key-v2" {
+		t.Errorf("Expected rotated secret, got %q", got)
+	}
+}
+
+func TestResolveSecrets_ReportsFailures(t *testing.T) {
+	clearSecretRefs(t)
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("TEST_SECRET_A", "secret://vault/secret/a#x")
+	t.Setenv("TEST_SECRET_B", "secret://nope/b")
+
+	err := resolveSecrets(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "TEST_SECRET_A") || !strings.Contains(err.Error(), "TEST_SECRET_B") {
+		t.Errorf("Expected both failures reported, got %v", err)
+	}
+}
+
+func TestFetchSecret_AWS(t *testing.T) {
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.Contains(auth, "Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") ||
+			!strings.Contains(string(body), `"SecretId":"prod/paygate"`) {
+			w.WriteHeader(400)
+			return
+		}
+		w.Write([]byte(`{"SecretString":"{\"redis_password\":\"hunter2\"}"}`))
+	}))
+	defer aws.Close()
+
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_SECRETS_MANAGER_ENDPOINT", aws.URL)
+
+	value, err := fetchSecret(context.Background(), "secret://aws/prod/paygate#redis_password")
+	if err != nil || value != "hunter2" {
+		t.Errorf("Expected hunter2, got %q, %v", value, err)
+	}
+}
+
+// Known-answer test from the AWS SigV4 test suite ("get-vanilla").
+func TestSignAWSRequestV4_KnownAnswer(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSRequestV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Unexpected signature:\n got %s\nwant %s", got, want)
+	}
+}
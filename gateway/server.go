@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var connectionsRejectedTotal = newCounter(
+	"gateway_connections_rejected_total",
+	"Connections closed on accept because a connection limit was reached, by limit (total, per_ip).",
+	"limit",
+)
+
+// configureServerLimits applies the timeouts and header limit to srv, so
+// slow or idle clients can't hold connections open indefinitely:
+//
+//   - HTTP_READ_HEADER_TIMEOUT_SECONDS: time to send the request headers,
+//     including the TLS handshake (default 10)
+//   - HTTP_READ_TIMEOUT_SECONDS: time to send the whole request, body
+//     included (default 60)
+//   - HTTP_WRITE_TIMEOUT_SECONDS: time from the end of the headers to the
+//     end of the response (default REQUEST_TIMEOUT_SECONDS + 10, so the
+//     request timeout's 504 still gets out)
+//   - HTTP_IDLE_TIMEOUT_SECONDS: how long a keep-alive connection may wait
+//     for its next request (default 120)
+//   - HTTP_MAX_HEADER_BYTES: largest request header block (default 64KB)
+func configureServerLimits(srv *http.Server) {
+	configureHeaderLimits(srv)
+	srv.ReadTimeout = time.Duration(getEnvAsInt("HTTP_READ_TIMEOUT_SECONDS", 60)) * time.Second
+	srv.WriteTimeout = getWriteTimeout()
+}
+
+// configureHeaderLimits applies the header, idle and header size limits to
+// srv. The debug and gRPC servers only get these, since profiles and calls
+// may legitimately take longer than the write timeout.
+func configureHeaderLimits(srv *http.Server) {
+	srv.ReadHeaderTimeout = time.Duration(getEnvAsInt("HTTP_READ_HEADER_TIMEOUT_SECONDS", 10)) * time.Second
+	srv.IdleTimeout = time.Duration(getEnvAsInt("HTTP_IDLE_TIMEOUT_SECONDS", 120)) * time.Second
+	srv.MaxHeaderBytes = getEnvAsInt("HTTP_MAX_HEADER_BYTES", 64<<10)
+}
+
+// getWriteTimeout returns HTTP_WRITE_TIMEOUT_SECONDS, by default 10 seconds
+// more than the request timeout.
+func getWriteTimeout() time.Duration {
+	if seconds := getEnvAsInt("HTTP_WRITE_TIMEOUT_SECONDS", 0); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return getRequestTimeout() + 10*time.Second
+}
+
+// connLimiter counts open connections across listeners, in total and per
+// client IP.
+type connLimiter struct {
+	max, perIP int // 0 means unlimited
+
+	mu   sync.Mutex
+	open int
+	byIP map[string]int
+}
+
+// acquire reserves a connection slot for ip ("" for Unix sockets, which
+// only count towards the total), returning the limit that was hit if none
+// is free.
+func (l *connLimiter) acquire(ip string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.open >= l.max {
+		return "total"
+	}
+	if ip != "" && l.perIP > 0 && l.byIP[ip] >= l.perIP {
+		return "per_ip"
+	}
+	l.open++
+	if ip != "" {
+		l.byIP[ip]++
+	}
+	return ""
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.open--
+	if ip != "" {
+		if l.byIP[ip]--; l.byIP[ip] <= 0 {
+			delete(l.byIP, ip)
+		}
+	}
+}
+
+// limitListener closes accepted connections over the limits straight away.
+type limitListener struct {
+	net.Listener
+	limiter *connLimiter
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ll.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		var ip string
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			ip = addr.IP.String()
+		}
+		if limit := ll.limiter.acquire(ip); limit != "" {
+			connectionsRejectedTotal.Inc(limit)
+			conn.Close()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: sync.OnceFunc(func() { ll.limiter.release(ip) })}, nil
+	}
+}
+
+// limitedConn gives its slot back when closed.
+type limitedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+// limitConnections caps the connections open on listeners, together:
+// HTTP_MAX_CONNECTIONS in total (default 10000) and
+// HTTP_MAX_CONNECTIONS_PER_IP per client address (default 0, unlimited,
+// since behind a load balancer every connection comes from its address).
+// 0 disables a limit. Call it before wrapTLS so rejected connections
+// don't cost a handshake.
+func limitConnections(listeners []net.Listener) {
+	limiter := &connLimiter{
+		max:   getEnvAsInt("HTTP_MAX_CONNECTIONS", 10000),
+		perIP: getEnvAsInt("HTTP_MAX_CONNECTIONS_PER_IP", 0),
+		byIP:  make(map[string]int),
+	}
+	if limiter.max == 0 && limiter.perIP == 0 {
+		return
+	}
+	for i, l := range listeners {
+		listeners[i] = &limitListener{Listener: l, limiter: limiter}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigureServerLimits(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT_SECONDS", "20")
+	srv := &http.Server{}
+	configureServerLimits(srv)
+	if srv.ReadHeaderTimeout != 10*time.Second || srv.ReadTimeout != time.Minute || srv.IdleTimeout != 2*time.Minute || srv.MaxHeaderBytes != 64<<10 {
+		t.Errorf("Unexpected defaults: %+v", srv)
+	}
+	if srv.WriteTimeout != 30*time.Second {
+		t.Errorf("Expected the write timeout to follow the request timeout, got %v", srv.WriteTimeout)
+	}
+
+	t.Setenv("HTTP_WRITE_TIMEOUT_SECONDS", "90")
+	configureServerLimits(srv)
+	if srv.WriteTimeout != 90*time.Second {
+		t.Errorf("Expected HTTP_WRITE_TIMEOUT_SECONDS, got %v", srv.WriteTimeout)
+	}
+}
+
+func TestServerLimits_SlowHeadersDropped(t *testing.T) {
+	t.Setenv("HTTP_READ_HEADER_TIMEOUT_SECONDS", "1")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	configureServerLimits(srv)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Headers trickle in and are never finished
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the connection to be closed after the header timeout, took %v", elapsed)
+	}
+}
+
+func TestLimitConnections(t *testing.T) {
+	t.Setenv("HTTP_MAX_CONNECTIONS", "3")
+	t.Setenv("HTTP_MAX_CONNECTIONS_PER_IP", "2")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners := []net.Listener{l}
+	limitConnections(listeners)
+	defer listeners[0].Close()
+
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := listeners[0].Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	perIP := connectionsRejectedTotal.Value("per_ip")
+
+	var clients []net.Conn
+	for range 3 {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+	// The third connection from 127.0.0.1 is closed on accept
+	clients[2].SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := clients[2].Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the connection over the per-IP limit to be closed, got %v", err)
+	}
+	if got := connectionsRejectedTotal.Value("per_ip") - perIP; got != 1 {
+		t.Errorf("Expected 1 per_ip rejection, got %v", got)
+	}
+
+	// Closing a connection frees its slot
+	(<-accepted).Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(2 * time.Second):
+		t.Error("Expected a freed slot to be reused")
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	l := &connLimiter{max: 2, perIP: 1, byIP: make(map[string]int)}
+	if l.acquire("10.0.0.1") != "" || l.acquire("10.0.0.1") != "per_ip" {
+		t.Error("Expected the per-IP limit to apply")
+	}
+	if l.acquire("") != "" || l.acquire("10.0.0.2") != "total" {
+		t.Error("Expected Unix sockets to count towards the total")
+	}
+	l.release("10.0.0.1")
+	if l.acquire("10.0.0.2") != "" || len(l.byIP) != 1 {
+		t.Errorf("Expected released slots to be reused, got %v", l.byIP)
+	}
+}
+
+func TestLoadConfig_ServerLimits(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("REQUEST_TIMEOUT_SECONDS", "60")
+	t.Setenv("HTTP_WRITE_TIMEOUT_SECONDS", "30")
+	t.Setenv("HTTP_MAX_HEADER_BYTES", "100")
+	t.Setenv("HTTP_MAX_CONNECTIONS_PER_IP", "-1")
+
+	_, err := LoadConfig()
+	for _, key := range []string{"HTTP_WRITE_TIMEOUT_SECONDS: must exceed", "HTTP_MAX_HEADER_BYTES", "HTTP_MAX_CONNECTIONS_PER_IP"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected a %s problem, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// settlementQueue receives the verified payment authorizations to settle
+// on-chain. It is nil unless SETTLEMENT_QUEUE_FILE is set.
+var settlementQueue *jsonLinesQueue
+
+// Settlement modes.
+const (
+	settlementImmediate   = "immediate"
+	settlementEscrow      = "escrow"
+	settlementFacilitator = "facilitator"
+	// settlementChannel is not a SETTLEMENT_MODE: requests paid from a
+	// payment channel settle when the channel closes, whatever the mode.
+	settlementChannel = "channel"
+	// settlementPrepaid is not a SETTLEMENT_MODE either: requests paid from
+	// a prepaid balance were settled when the deposit was made.
+	settlementPrepaid = "prepaid"
+)
+
+// escrowKeyPrefix namespaces held and settled nonces in Redis.
+const escrowKeyPrefix = "escrow:"
+
+var escrowAuthorizationsTotal = newCounter(
+	"gateway_escrow_authorizations_total",
+	"Payment authorizations in escrow mode, by outcome (settled, released, duplicate).",
+	"outcome",
+)
+
+// getSettlementMode returns SETTLEMENT_MODE: immediate (default) submits a
+// payment for settlement as soon as it is verified; escrow holds it until
+// the response has been delivered and discards it if the request fails;
+// facilitator has an x402 facilitator settle it before the response is sent.
+func getSettlementMode() string {
+	switch mode := strings.ToLower(os.Getenv("SETTLEMENT_MODE")); mode {
+	case settlementEscrow, settlementFacilitator:
+		return mode
+	}
+	return settlementImmediate
+}
+
+// SettlementRequest is a verified payment authorization handed to whatever
+// settles the payments: everything it needs to submit the signed transfer.
+type SettlementRequest struct {
+	Nonce          string         `json:"nonce"`
+	Wallet         string         `json:"wallet"`
+	Signature      string         `json:"signature"`
+	PaymentContext PaymentContext `json:"payment_context"`
+	ReceiptID      string         `json:"receipt_id,omitempty" doc:"Receipt of the served request; set in escrow mode"`
+	ChannelID      string         `json:"channel_id,omitempty" doc:"Payment channel being closed; Signature is the sender's signature of its final balance"`
+	ReplacesTx     string         `json:"replaces_tx,omitempty" doc:"Settlement transaction that was dropped; the payment is queued again to replace it"`
+	Time           time.Time      `json:"time"`
+}
+
+// jsonLinesQueue appends one JSON object per line to a file that another
+// process consumes.
+type jsonLinesQueue struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// openJSONLinesQueue opens the file named by env, or returns nil when it is
+// unset or can't be opened (logged as a warning).
+func openJSONLinesQueue(env, what string) *jsonLinesQueue {
+	path := os.Getenv(env)
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Warning: %s disabled: %v", what, err)
+		return nil
+	}
+	log.Printf("Queuing %s to %s", what, path)
+	return &jsonLinesQueue{path: path, f: f}
+}
+
+func (q *jsonLinesQueue) push(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, err = q.f.Write(append(line, '\n'))
+	return err
+}
+
+// submitSettlement queues a verified payment for settlement, if a queue is
+// configured. A write failure is logged; reconciliation will flag the
+// payment as served but unsettled.
+func submitSettlement(r SettlementRequest) {
+	if settlementQueue == nil {
+		return
+	}
+	r.Time = time.Now().UTC()
+	if err := settlementQueue.push(r); err != nil {
+		log.Printf("error queuing settlement for %s: %v", r.Nonce, err)
+		return
+	}
+	trackSettlementRequest(r)
+}
+
+// Escrow states of a nonce.
+const (
+	escrowHeld    = "held"
+	escrowSettled = "settled"
+)
+
+// escrowStore remembers which nonces are held or settled, in Redis when
+// REDIS_URL is set so every instance sees them, in memory otherwise.
+type escrowStore interface {
+	// hold marks nonce held unless it already has a state, which it
+	// returns.
+	hold(ctx context.Context, nonce string, ttl time.Duration) (string, error)
+	settle(ctx context.Context, nonce string, ttl time.Duration) error
+	release(ctx context.Context, nonce string) error
+	// state returns the nonce's state, or "" when it has none.
+	state(ctx context.Context, nonce string) (string, error)
+}
+
+func currentEscrowStore() escrowStore {
+	if redisClient != nil {
+		return redisEscrowStore{client: redisClient}
+	}
+	return memoryEscrow
+}
+
+type memoryEscrowEntry struct {
+	state     string
+	expiresAt time.Time
+}
+
+type memoryEscrowStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEscrowEntry
+	lastSweep time.Time
+}
+
+var memoryEscrow = &memoryEscrowStore{entries: make(map[string]memoryEscrowEntry)}
+
+func (s *memoryEscrowStore) hold(_ context.Context, nonce string, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if e, ok := s.entries[nonce]; ok && now.Before(e.expiresAt) {
+		return e.state, nil
+	}
+	s.entries[nonce] = memoryEscrowEntry{state: escrowHeld, expiresAt: now.Add(ttl)}
+	return "", nil
+}
+
+func (s *memoryEscrowStore) settle(_ context.Context, nonce string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[nonce] = memoryEscrowEntry{state: escrowSettled, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryEscrowStore) release(_ context.Context, nonce string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, nonce)
+	return nil
+}
+
+func (s *memoryEscrowStore) state(_ context.Context, nonce string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[nonce]; ok && time.Now().Before(e.expiresAt) {
+		return e.state, nil
+	}
+	return "", nil
+}
+
+type redisEscrowStore struct {
+	client *redis.Client
+}
+
+func (s redisEscrowStore) hold(ctx context.Context, nonce string, ttl time.Duration) (string, error) {
+	ok, err := s.client.SetNX(ctx, escrowKeyPrefix+nonce, escrowHeld, ttl).Result()
+	if err != nil || ok {
+		return "", err
+	}
+	state, err := s.client.Get(ctx, escrowKeyPrefix+nonce).Result()
+	if errors.Is(err, redis.Nil) {
+		// Released between the two calls; the caller may retry
+		return escrowHeld, nil
+	}
+	return state, err
+}
+
+func (s redisEscrowStore) settle(ctx context.Context, nonce string, ttl time.Duration) error {
+	return s.client.Set(ctx, escrowKeyPrefix+nonce, escrowSettled, ttl).Err()
+}
+
+func (s redisEscrowStore) release(ctx context.Context, nonce string) error {
+	return s.client.Del(ctx, escrowKeyPrefix+nonce).Err()
+}
+
+func (s redisEscrowStore) state(ctx context.Context, nonce string) (string, error) {
+	state, err := s.client.Get(ctx, escrowKeyPrefix+nonce).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return state, err
+}
+
+// escrowTTL is how long a nonce's state is kept: as long as the nonce can
+// be used, or a day when NONCE_REQUIRE_ISSUED=false lets nonces live forever.
+func escrowTTL() time.Duration {
+	if requireIssuedNonces() {
+		return getNonceTTL()
+	}
+	return 24 * time.Hour
+}
+
+// holdPayment puts a verified payment in escrow for the length of the
+// request. A nonce already held by a request in flight gets 409
+// payment_held; one already settled gets 403 invalid_nonce (reason used).
+func holdPayment(c *gin.Context, nonce string) bool {
+	state, err := currentEscrowStore().hold(c.Request.Context(), nonce, escrowTTL())
+	if err != nil {
+		log.Printf("error holding payment %s in escrow: %v", nonce, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to hold the payment", ""))
+		return false
+	}
+	switch state {
+	case "":
+		return true
+	case escrowHeld:
+		escrowAuthorizationsTotal.Inc("duplicate")
+		c.Header("Retry-After", "1")
+		abortWithProblem(c, newProblem(409, codePaymentHeld, "Conflict",
+			"A request paid with this nonce is still in progress").
+			With("retry_after", 1))
+	default:
+		escrowAuthorizationsTotal.Inc("duplicate")
+		abortInvalidNonce(c, "used")
+	}
+	return false
+}
+
+// finishEscrow settles a held payment once the response has been delivered
+// and releases it otherwise, so the client isn't charged for a failed
+// request and can retry with the same signature. Run it deferred after
+// holdPayment.
+func finishEscrow(c *gin.Context, r SettlementRequest) {
+	ctx := context.WithoutCancel(c.Request.Context())
+	store := currentEscrowStore()
+	if c.Writer.Status() != 200 || c.Request.Context().Err() != nil {
+		if err := store.release(ctx, r.Nonce); err != nil {
+			log.Printf("error releasing escrowed payment %s: %v", r.Nonce, err)
+		}
+		discardAuthorization(r.Nonce)
+		escrowAuthorizationsTotal.Inc("released")
+		return
+	}
+	if err := store.settle(ctx, r.Nonce, escrowTTL()); err != nil {
+		log.Printf("error marking escrowed payment %s settled: %v", r.Nonce, err)
+	}
+	submitSettlement(r)
+	escrowAuthorizationsTotal.Inc("settled")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+// setupSettlementTest wires a summarize router to fake backends, with the
+// settlement queue in a temp file, and returns a sender for paid requests
+// and a reader for the queue.
+func setupSettlementTest(t *testing.T, mode string) (*testsupport.FakeOpenRouter, func(nonce string) *httptest.ResponseRecorder, func() []SettlementRequest) {
+	t.Helper()
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("SETTLEMENT_MODE", mode)
+	path := filepath.Join(t.TempDir(), "settle-queue.jsonl")
+	t.Setenv("SETTLEMENT_QUEUE_FILE", path)
+	prevQueue, prevEscrow := settlementQueue, memoryEscrow
+	settlementQueue = openJSONLinesQueue("SETTLEMENT_QUEUE_FILE", "payments for settlement")
+	memoryEscrow = &memoryEscrowStore{entries: make(map[string]memoryEscrowEntry)}
+	t.Cleanup(func() {
+		settlementQueue.f.Close()
+		settlementQueue, memoryEscrow = prevQueue, prevEscrow
+	})
+	r := setupVersionedRouter()
+
+	send := func(nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"text paid for in `+mode+` mode"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	queued := func() []SettlementRequest {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var reqs []SettlementRequest
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r SettlementRequest
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			reqs = append(reqs, r)
+		}
+		return reqs
+	}
+	return ai, send, queued
+}
+
+func TestEscrow_SettlesOnlyDeliveredResponses(t *testing.T) {
+	ai, send, queued := setupSettlementTest(t, settlementEscrow)
+	ledger := setupTestLedger(t)
+
+	// A failed request is released: not queued, not refunded
+	ai.FailWith(http.StatusInternalServerError, `{"error":"upstream down"}`)
+	released := escrowAuthorizationsTotal.Value("released")
+	if w := send("n-escrow-1"); w.Code < 500 {
+		t.Fatalf("Expected the AI failure to be a server error, got %d", w.Code)
+	}
+	if len(queued()) != 0 || escrowAuthorizationsTotal.Value("released")-released != 1 {
+		t.Fatal("Expected the failed payment to be released without settlement")
+	}
+	if entries, _ := ledger.Entries(t.Context(), ledgerQuery{}); len(entries) != 0 {
+		t.Errorf("Expected nothing to refund, got %+v", entries)
+	}
+
+	// The same signature works once the AI recovers, and is settled
+	ai.Reply("a summary")
+	w := send("n-escrow-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the released nonce to be usable again, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp SummarizeResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if reqs := queued(); len(reqs) != 1 || reqs[0].Nonce != "n-escrow-1" || reqs[0].Signature != "0xsig" || reqs[0].ReceiptID != resp.Receipt.Receipt.ID {
+		t.Errorf("Expected the delivered payment to be queued with its receipt, got %+v", reqs)
+	}
+
+	// A settled nonce can't pay again
+	if p := decodeProblem(t, send("n-escrow-1")); p["code"] != codeInvalidNonce || p["reason"] != "used" {
+		t.Errorf("Expected a settled nonce to be refused, got %v", p)
+	}
+}
+
+func TestEscrow_ReleasesMockPlaceholder(t *testing.T) {
+	ai, send, queued := setupSettlementTest(t, settlementEscrow)
+	ledger := setupTestLedger(t)
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter,mock")
+	ai.FailWith(http.StatusBadGateway, `{"error":"upstream down"}`)
+
+	released := escrowAuthorizationsTotal.Value("released")
+	if w := send("n-escrow-mock"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the placeholder to fail as 503, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(queued()) != 0 || escrowAuthorizationsTotal.Value("released")-released != 1 {
+		t.Error("Expected the payment to be released without settlement")
+	}
+	if entries, _ := ledger.Entries(t.Context(), ledgerQuery{}); len(entries) != 0 {
+		t.Errorf("Expected nothing charged or refunded, got %+v", entries)
+	}
+}
+
+func TestEscrow_HeldNonceConflicts(t *testing.T) {
+	_, send, _ := setupSettlementTest(t, settlementEscrow)
+	memoryEscrow.hold(t.Context(), "n-held", time.Minute)
+
+	w := send("n-held")
+	if p := decodeProblem(t, w); w.Code != http.StatusConflict || p["code"] != codePaymentHeld || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 409 payment_held, got %d %v", w.Code, p)
+	}
+}
+
+func TestImmediateSettlement_QueuesOnVerification(t *testing.T) {
+	ai, send, queued := setupSettlementTest(t, settlementImmediate)
+	ai.FailWith(http.StatusInternalServerError, `{"error":"upstream down"}`)
+
+	send("n-immediate-1")
+	if reqs := queued(); len(reqs) != 1 || reqs[0].Nonce != "n-immediate-1" || reqs[0].ReceiptID != "" {
+		t.Errorf("Expected the payment to be queued once verified, got %+v", reqs)
+	}
+}
+
+func TestImmediateSettlement_NotQueuedForRefusedRequests(t *testing.T) {
+	_, send, queued := setupSettlementTest(t, settlementImmediate)
+	t.Setenv("MODEL_ENTITLEMENTS", "free:free/model")
+	t.Setenv("WALLET_PLANS", "")
+	t.Setenv("DEFAULT_PLAN", "")
+
+	// The refusal comes before settlement, so there is nothing to refund
+	if w := send("n-unentitled"); w.Code != http.StatusForbidden || decodeProblem(t, w)["code"] != codeModelNotEntitled {
+		t.Fatalf("Expected 403 model_not_entitled, got %d", w.Code)
+	}
+	if reqs := queued(); len(reqs) != 0 {
+		t.Errorf("Expected the refused payment not to be queued, got %+v", reqs)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of a settlement transaction, recorded in the usage ledger as
+// entries of type settlement. A transaction only moves forward, except
+// that a reorg can send a confirmed one back to pending.
+const (
+	txPending   = "pending"   // submitted, not mined yet
+	txConfirmed = "confirmed" // mined, not SETTLEMENT_CONFIRMATIONS blocks deep yet
+	txFinalized = "finalized" // SETTLEMENT_CONFIRMATIONS blocks deep; no longer tracked
+	txFailed    = "failed"    // reverted, or dropped and not submitted again
+)
+
+// Reasons recorded with a settlement state change.
+const (
+	txReasonReverted    = "reverted"
+	txReasonDropped     = "dropped"
+	txReasonReorg       = "reorg"
+	txReasonResubmitted = "resubmitted"
+)
+
+// trackWindow is how far back the ledger and SETTLEMENT_FILE are read for
+// transactions to track.
+const trackWindow = 24 * time.Hour
+
+// settlementTracker is nil unless SETTLEMENT_TRACKING=true.
+var settlementTracker *confirmationTracker
+
+var settlementTransitionsTotal = newCounter(
+	"gateway_settlement_transitions_total",
+	"Settlement transaction state changes recorded by the tracker, by state (pending, confirmed, finalized, failed).",
+	"state",
+)
+
+var settlementReorgsTotal = newCounter(
+	"gateway_settlement_reorgs_total",
+	"Confirmed settlement transactions whose block was reorganized out of the chain.",
+)
+
+// trackedTx is a settlement transaction that isn't final yet.
+type trackedTx struct {
+	nonce       string
+	txHash      string
+	wallet      string
+	amount      string
+	state       string
+	blockNumber uint64
+	blockHash   string    // empty until the tracker has seen the receipt
+	since       time.Time // when txHash was submitted, for the drop timeout
+	resubmits   int
+}
+
+// resubmission is what submitting a payment's settlement again takes.
+type resubmission struct {
+	request  SettlementRequest
+	resource string // route the payment was for, when a facilitator settled it
+	at       time.Time
+}
+
+// confirmationTracker follows the settlement transactions in the ledger
+// (settled by a facilitator) and in SETTLEMENT_FILE until they are final,
+// polling ETH_RPC_URL for their receipts. A mined transaction is confirmed,
+// and finalized once SETTLEMENT_CONFIRMATIONS blocks deep. A confirmed one
+// whose block is reorganized away goes back to pending, one that reverted
+// has failed, and one not mined within SETTLEMENT_DROP_SECONDS is submitted
+// again, up to SETTLEMENT_MAX_RESUBMITS times, or failed. Each change is
+// appended to the usage ledger, which is also where a restarted gateway
+// picks the states up from.
+type confirmationTracker struct {
+	rpcURL       string
+	depth        uint64
+	dropAfter    time.Duration
+	maxResubmits int
+	interval     time.Duration
+	settlements  settlementSource // nil without SETTLEMENT_FILE
+	httpClient   *http.Client
+
+	mu            sync.Mutex
+	tracked       map[string]*trackedTx   // by nonce
+	resubmissions map[string]resubmission // by nonce; kept by this instance only
+}
+
+// getSettlementConfirmations returns SETTLEMENT_CONFIRMATIONS (default 12),
+// how many blocks deep a settlement transaction is final.
+func getSettlementConfirmations() uint64 {
+	return uint64(getEnvAsInt("SETTLEMENT_CONFIRMATIONS", 12))
+}
+
+// initConfirmationTracker builds the tracker from SETTLEMENT_TRACKING
+// (default off), SETTLEMENT_CONFIRMATIONS, SETTLEMENT_DROP_SECONDS (default
+// 900), SETTLEMENT_MAX_RESUBMITS (default 1) and
+// SETTLEMENT_TRACK_INTERVAL_SECONDS (default 30). It needs ETH_RPC_URL and
+// the usage ledger.
+func initConfirmationTracker() *confirmationTracker {
+	if strings.ToLower(os.Getenv("SETTLEMENT_TRACKING")) != "true" {
+		return nil
+	}
+	rpcURL := os.Getenv("ETH_RPC_URL")
+	if rpcURL == "" || usageLedger == nil {
+		log.Println("Warning: Settlement tracking disabled: it needs ETH_RPC_URL and the usage ledger (LEDGER_BACKEND)")
+		return nil
+	}
+	t := &confirmationTracker{
+		rpcURL:        rpcURL,
+		depth:         getSettlementConfirmations(),
+		dropAfter:     time.Duration(getEnvAsInt("SETTLEMENT_DROP_SECONDS", 900)) * time.Second,
+		maxResubmits:  getEnvAsInt("SETTLEMENT_MAX_RESUBMITS", 1),
+		interval:      time.Duration(getEnvAsInt("SETTLEMENT_TRACK_INTERVAL_SECONDS", 30)) * time.Second,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		tracked:       make(map[string]*trackedTx),
+		resubmissions: make(map[string]resubmission),
+	}
+	if path := os.Getenv("SETTLEMENT_FILE"); path != "" {
+		t.settlements = fileSettlements{path: path}
+	}
+	return t
+}
+
+// start polls every interval until ctx is done.
+func (t *confirmationTracker) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := t.poll(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("error tracking settlements: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Tracking settlement transactions to %d confirmations every %s", t.depth, t.interval)
+}
+
+// trackSettlementRequest keeps a payment queued for settlement, so the
+// tracker can queue it again if its transaction is dropped.
+func trackSettlementRequest(r SettlementRequest) {
+	if settlementTracker == nil {
+		return
+	}
+	settlementTracker.mu.Lock()
+	settlementTracker.resubmissions[r.Nonce] = resubmission{request: r, at: time.Now().UTC()}
+	settlementTracker.mu.Unlock()
+}
+
+// trackFacilitatorSettlement starts tracking the transaction a facilitator
+// submitted for r, paying for resource.
+func trackFacilitatorSettlement(r SettlementRequest, resource, txHash string) {
+	if settlementTracker == nil || txHash == "" {
+		return
+	}
+	now := time.Now().UTC()
+	settlementTracker.mu.Lock()
+	defer settlementTracker.mu.Unlock()
+	settlementTracker.resubmissions[r.Nonce] = resubmission{request: r, resource: resource, at: now}
+	if _, ok := settlementTracker.tracked[r.Nonce]; !ok {
+		settlementTracker.tracked[r.Nonce] = &trackedTx{nonce: r.Nonce, txHash: txHash, wallet: strings.ToLower(r.Wallet),
+			amount: r.PaymentContext.Amount, state: txPending, since: now}
+	}
+}
+
+// poll picks up new settlement transactions and checks each tracked one
+// against the chain head.
+func (t *confirmationTracker) poll(ctx context.Context) error {
+	if err := t.discover(ctx); err != nil {
+		return err
+	}
+	head, err := ethBlockNumber(ctx, t.httpClient, t.rpcURL)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	txs := make([]*trackedTx, 0, len(t.tracked))
+	for _, tx := range t.tracked {
+		txs = append(txs, tx)
+	}
+	t.mu.Unlock()
+	for _, tx := range txs {
+		if err := t.check(ctx, tx, head); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discover tracks each payment's latest settlement transaction in the
+// ledger and SETTLEMENT_FILE, unless the ledger has it final already. The
+// state the ledger last recorded for it is where tracking resumes.
+func (t *confirmationTracker) discover(ctx context.Context) error {
+	now := time.Now().UTC()
+	since := now.Add(-trackWindow)
+	entries, err := usageLedger.Entries(ctx, ledgerQuery{Since: since})
+	if err != nil {
+		return err
+	}
+	latest := make(map[string]SettledTransfer)
+	consider := func(s SettledTransfer) {
+		if prev, ok := latest[s.Nonce]; !ok || !s.Time.Before(prev.Time) {
+			latest[s.Nonce] = s
+		}
+	}
+	recorded := make(map[string]LedgerEntry)
+	resubmits := make(map[string]int)
+	for _, e := range entries {
+		switch {
+		case e.Type == ledgerTypeSettlement:
+			recorded[e.Nonce] = e
+			if e.Reason == txReasonResubmitted {
+				resubmits[e.Nonce]++
+			}
+			consider(SettledTransfer{Nonce: e.Nonce, TxHash: e.TxHash, Wallet: e.Wallet, Amount: e.Amount, Time: e.Time})
+		case e.Type == "" && e.TxHash != "":
+			consider(SettledTransfer{Nonce: e.Nonce, TxHash: e.TxHash, Wallet: e.Wallet, Amount: e.Amount, Time: e.Time})
+		}
+	}
+	if t.settlements != nil {
+		transfers, err := t.settlements.Settled(ctx, since, now.Add(time.Minute))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, s := range transfers {
+			consider(s)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for nonce, s := range latest {
+		if tx, ok := t.tracked[nonce]; ok && tx.txHash == s.TxHash {
+			continue
+		}
+		tx := &trackedTx{nonce: nonce, txHash: s.TxHash, wallet: strings.ToLower(s.Wallet), amount: s.Amount,
+			state: txPending, since: s.Time, resubmits: resubmits[nonce]}
+		if e, ok := recorded[nonce]; ok && e.TxHash == s.TxHash {
+			if e.SettlementState == txFinalized || e.SettlementState == txFailed {
+				continue
+			}
+			tx.state, tx.blockNumber = e.SettlementState, e.BlockNumber
+		}
+		t.tracked[nonce] = tx
+	}
+	for nonce, r := range t.resubmissions {
+		if r.at.Before(since) {
+			delete(t.resubmissions, nonce)
+		}
+	}
+	return nil
+}
+
+// check moves tx along from its receipt, if the state changed.
+func (t *confirmationTracker) check(ctx context.Context, tx *trackedTx, head uint64) error {
+	receipt, err := ethTransactionReceipt(ctx, t.httpClient, t.rpcURL, tx.txHash)
+	if err != nil {
+		return err
+	}
+	switch {
+	case receipt == nil && tx.state == txConfirmed:
+		// Its block was reorganized away; it may still be mined again
+		settlementReorgsTotal.Inc()
+		tx.blockNumber, tx.blockHash, tx.since = 0, "", time.Now().UTC()
+		t.record(ctx, tx, txPending, txReasonReorg)
+	case receipt == nil:
+		if time.Since(tx.since) > t.dropAfter {
+			t.dropped(ctx, tx)
+		}
+	case receipt.Status == 0:
+		tx.blockNumber = uint64(receipt.BlockNumber)
+		t.record(ctx, tx, txFailed, txReasonReverted)
+	default:
+		reason := ""
+		if tx.blockHash != "" && tx.blockHash != receipt.BlockHash {
+			// Mined again in another block after a reorg
+			settlementReorgsTotal.Inc()
+			reason = txReasonReorg
+		}
+		tx.blockNumber, tx.blockHash = uint64(receipt.BlockNumber), receipt.BlockHash
+		var confirmations uint64
+		if head >= tx.blockNumber {
+			confirmations = head - tx.blockNumber + 1
+		}
+		switch {
+		case confirmations >= t.depth:
+			t.record(ctx, tx, txFinalized, reason)
+		case tx.state != txConfirmed || reason != "":
+			t.record(ctx, tx, txConfirmed, reason)
+		}
+	}
+	return nil
+}
+
+// dropped submits a settlement that was never mined again: to the
+// facilitator that settled it, or to SETTLEMENT_QUEUE_FILE with
+// replaces_tx set. It fails the settlement when this instance doesn't have
+// the payment's request (it was made before a restart, or elsewhere) or it
+// was submitted again SETTLEMENT_MAX_RESUBMITS times already.
+func (t *confirmationTracker) dropped(ctx context.Context, tx *trackedTx) {
+	t.mu.Lock()
+	r, ok := t.resubmissions[tx.nonce]
+	t.mu.Unlock()
+	if !ok || tx.resubmits >= t.maxResubmits {
+		t.record(ctx, tx, txFailed, txReasonDropped)
+		return
+	}
+	tx.resubmits++
+	tx.since = time.Now().UTC()
+	if r.resource != "" {
+		resp, err := facilitatorSettle(ctx, r.request, r.resource)
+		if err != nil {
+			log.Printf("error submitting the settlement of %s again: %v", tx.nonce, err)
+			t.record(ctx, tx, txFailed, txReasonDropped)
+			return
+		}
+		tx.txHash = resp.Transaction
+	} else {
+		// The settler writes the new transaction to SETTLEMENT_FILE, where
+		// discover picks it up
+		r.request.ReplacesTx = tx.txHash
+		submitSettlement(r.request)
+	}
+	log.Printf("Settlement %s of %s was dropped; submitted it again", tx.txHash, tx.nonce)
+	t.record(ctx, tx, txPending, txReasonResubmitted)
+}
+
+// record moves tx to state and appends the change to the ledger. A final
+// state stops the tracking; a failed one is sent to the settlement.failed
+// webhooks.
+func (t *confirmationTracker) record(ctx context.Context, tx *trackedTx, state, reason string) {
+	tx.state = state
+	settlementTransitionsTotal.Inc(state)
+	now := time.Now().UTC()
+	recordLedgerEntry(ctx, LedgerEntry{
+		Type: ledgerTypeSettlement, Time: now, Nonce: tx.nonce, Wallet: tx.wallet, Amount: tx.amount,
+		Token: getPaymentToken(), ChainID: getChainID(), TxHash: tx.txHash,
+		SettlementState: state, BlockNumber: tx.blockNumber, Reason: reason,
+	})
+	if state != txFinalized && state != txFailed {
+		return
+	}
+	t.mu.Lock()
+	if t.tracked[tx.nonce] == tx {
+		delete(t.tracked, tx.nonce)
+		delete(t.resubmissions, tx.nonce)
+	}
+	t.mu.Unlock()
+
+	event := Event{Type: eventSettlementFinalized, Time: now, Nonce: tx.nonce, Wallet: tx.wallet, Amount: tx.amount,
+		Token: getPaymentToken(), ChainID: getChainID(), TxHash: tx.txHash, Reason: reason}
+	if state == txFailed {
+		event.Type = eventSettlementFailed
+		log.Printf("Settlement %s of %s failed: %s", tx.txHash, tx.nonce, reason)
+		notifyWebhooks(ctx, webhookSettlementFailed, map[string]interface{}{
+			"reason": reason, "nonce": tx.nonce, "wallet": tx.wallet, "amount": tx.amount, "tx_hash": tx.txHash,
+		})
+	}
+	emitEvent(event)
+}
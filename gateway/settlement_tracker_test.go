@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// fakeReorgChain answers eth_blockNumber with *head and
+// eth_getTransactionReceipt from receipts, both changeable between polls.
+func fakeReorgChain(t *testing.T, head *uint64, receipts map[string]string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		result := json.RawMessage("null")
+		switch req.Method {
+		case "eth_blockNumber":
+			result, _ = json.Marshal(hexutil.EncodeUint64(*head))
+		case "eth_getTransactionReceipt":
+			if receipt, ok := receipts[req.Params[0]]; ok {
+				result = json.RawMessage(receipt)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("ETH_RPC_URL", srv.URL)
+}
+
+// setupTestTracker installs a confirmation tracker over a test ledger.
+func setupTestTracker(t *testing.T) (*confirmationTracker, *fileLedger) {
+	t.Helper()
+	ledger := setupTestLedger(t)
+	t.Setenv("SETTLEMENT_TRACKING", "true")
+	tracker := initConfirmationTracker()
+	if tracker == nil {
+		t.Fatal("Expected a tracker with ETH_RPC_URL and the ledger")
+	}
+	prev := settlementTracker
+	settlementTracker = tracker
+	t.Cleanup(func() { settlementTracker = prev })
+	return tracker, ledger
+}
+
+// settlementStates returns the states the ledger recorded for nonce, with
+// their reasons.
+func settlementStates(t *testing.T, ledger *fileLedger, nonce string) []string {
+	t.Helper()
+	entries, _ := ledger.Entries(context.Background(), ledgerQuery{})
+	var states []string
+	for _, e := range entries {
+		if e.Type == ledgerTypeSettlement && e.Nonce == nonce {
+			states = append(states, e.SettlementState+"/"+e.Reason)
+		}
+	}
+	return states
+}
+
+func TestConfirmationTracker_FollowsReorgs(t *testing.T) {
+	head := uint64(105)
+	receipts := map[string]string{"0x0a": `{"blockNumber":"0x64","blockHash":"0xb1","status":"0x1"}`}
+	fakeReorgChain(t, &head, receipts)
+	tracker, ledger := setupTestTracker(t)
+	ctx := context.Background()
+	ledger.Append(ctx, LedgerEntry{ReceiptID: "rcpt_1", Nonce: "n-1", Wallet: "0xaa", Amount: "0.001", TxHash: "0x0a", Time: time.Now().UTC()})
+
+	tracker.poll(ctx)
+	// The block is reorganized away, then the transaction mined in another
+	delete(receipts, "0x0a")
+	reorgs := settlementReorgsTotal.Value()
+	tracker.poll(ctx)
+	if settlementReorgsTotal.Value()-reorgs != 1 {
+		t.Error("Expected the reorg counted")
+	}
+	receipts["0x0a"] = `{"blockNumber":"0x65","blockHash":"0xb2","status":"0x1"}`
+	head = 120
+	tracker.poll(ctx)
+
+	want := []string{"confirmed/", "pending/reorg", "finalized/"}
+	if got := settlementStates(t, ledger, "n-1"); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if _, status := getPayment(t, "n-1"); status.State != paymentFinalized || status.BlockNumber != 101 {
+		t.Errorf("Expected the payment finalized in block 101, got %+v", status)
+	}
+
+	// A restarted gateway doesn't follow a finalized settlement again
+	restarted := initConfirmationTracker()
+	restarted.poll(ctx)
+	if len(restarted.tracked) != 0 || len(settlementStates(t, ledger, "n-1")) != 3 {
+		t.Errorf("Expected nothing tracked after finality, got %v", restarted.tracked)
+	}
+}
+
+func TestConfirmationTracker_ResubmitsDroppedTransactions(t *testing.T) {
+	_, _, queued := setupSettlementTest(t, settlementImmediate)
+	head := uint64(200)
+	fakeReorgChain(t, &head, map[string]string{})
+	settlements := filepath.Join(t.TempDir(), "settled.jsonl")
+	line, _ := json.Marshal(SettledTransfer{Nonce: "n-2", TxHash: "0x0b", Wallet: "0xaa", Amount: "0.001", Time: time.Now().UTC().Add(-time.Hour)})
+	os.WriteFile(settlements, append(line, '\n'), 0o600)
+	t.Setenv("SETTLEMENT_FILE", settlements)
+	tracker, ledger := setupTestTracker(t)
+	ctx := context.Background()
+	submitSettlement(SettlementRequest{Nonce: "n-2", Wallet: "0xaa", Signature: "0xsig"})
+
+	tracker.poll(ctx)
+	requests := queued()
+	if len(requests) != 2 || requests[1].Nonce != "n-2" || requests[1].ReplacesTx != "0x0b" {
+		t.Fatalf("Expected the dropped settlement queued again, got %+v", requests)
+	}
+
+	// Never mined again either: SETTLEMENT_MAX_RESUBMITS is 1
+	tracker.dropAfter = 0
+	tracker.poll(ctx)
+	want := []string{"pending/resubmitted", "failed/dropped"}
+	if got := settlementStates(t, ledger, "n-2"); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if _, status := getPayment(t, "n-2"); status.State != paymentFailed || status.FailureReason != txReasonDropped {
+		t.Errorf("Expected the payment failed as dropped, got %+v", status)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// inFlightRequests tracks requests whose handler chain is still running
+	// so shutdown can wait for them to finish.
+	inFlightRequests sync.WaitGroup
+	inFlightCount    atomic.Int64
+)
+
+// TrackInFlightRequests registers each request with inFlightRequests for the
+// duration of the handler chain. Register it before the other middleware so
+// the whole chain is covered.
+func TrackInFlightRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inFlightRequests.Add(1)
+		inFlightCount.Add(1)
+		defer func() {
+			inFlightCount.Add(-1)
+			inFlightRequests.Done()
+		}()
+		c.Next()
+	}
+}
+
+// WaitForInFlightRequests blocks until every tracked request has finished or
+// ctx is done, in which case it returns ctx.Err().
+func WaitForInFlightRequests(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		inFlightRequests.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// InFlightRequestCount returns the number of requests currently being handled.
+func InFlightRequestCount() int64 {
+	return inFlightCount.Load()
+}
+
+// getShutdownTimeout returns how long to drain in-flight requests on
+// SIGTERM/SIGINT, from SHUTDOWN_TIMEOUT_SECONDS (default 30).
+func getShutdownTimeout() time.Duration {
+	return getPositiveTimeout("SHUTDOWN_TIMEOUT_SECONDS", 30)
+}
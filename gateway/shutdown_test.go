@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWaitForInFlightRequests_WaitsForHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(TrackInFlightRequests())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(200, "done")
+	})
+
+	go r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	<-started
+
+	if InFlightRequestCount() != 1 {
+		t.Errorf("Expected 1 in-flight request, got %d", InFlightRequestCount())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := WaitForInFlightRequests(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline while request is running, got %v", err)
+	}
+
+	close(release)
+	if err := WaitForInFlightRequests(context.Background()); err != nil {
+		t.Fatalf("Expected wait to finish, got %v", err)
+	}
+	if InFlightRequestCount() != 0 {
+		t.Errorf("Expected 0 in-flight requests, got %d", InFlightRequestCount())
+	}
+}
+
+func TestShutdownGateway_DrainsServer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(TrackInFlightRequests())
+	started := make(chan struct{})
+	r.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		c.String(200, "done")
+	})
+
+	ts := httptest.NewUnstartedServer(r)
+	ts.Start()
+	defer ts.Close()
+
+	result := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/slow")
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+	<-started
+
+	shutdownGateway(ts.Config, time.Second)
+
+	if code := <-result; code != 200 {
+		t.Errorf("Expected in-flight request to complete with 200, got %d", code)
+	}
+	if _, err := http.Get(ts.URL + "/slow"); err == nil {
+		t.Error("Expected new connections to be refused after shutdown")
+	}
+}
+
+func TestGetShutdownTimeout(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT_SECONDS", "")
+	if getShutdownTimeout() != 30*time.Second {
+		t.Errorf("Expected default 30s, got %v", getShutdownTimeout())
+	}
+	t.Setenv("SHUTDOWN_TIMEOUT_SECONDS", "5")
+	if getShutdownTimeout() != 5*time.Second {
+		t.Errorf("Expected 5s, got %v", getShutdownTimeout())
+	}
+}
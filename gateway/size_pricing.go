@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SizeBand is one PRICE_SIZE_BANDS tier: texts shorter than Bytes cost
+// Price, unless a smaller band takes them first.
+type SizeBand struct {
+	Below string `json:"below" doc:"Upper bound as configured" example:"100KB"`
+	Bytes int    `json:"bytes" doc:"Upper bound in bytes of UTF-8 text; texts this long fall in the next band" example:"102400"`
+	Price string `json:"price" doc:"Payment token amount for texts in the band, or their minimum charge with per-token pricing" example:"0.005"`
+}
+
+// sizeUnits are the suffixes a band's size can have, in bytes.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int
+}{{"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// parseSizeBands parses PRICE_SIZE_BANDS, a comma-separated list of
+// size=price ("1KB=0.001,100KB=0.005,1MB=0.02") in ascending size. Sizes
+// are whole numbers of B, KB or MB (1024-based).
+func parseSizeBands(raw string) ([]SizeBand, error) {
+	var bands []SizeBand
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		size, price, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q is not size=price", entry)
+		}
+		size, price = strings.ToUpper(strings.TrimSpace(size)), strings.TrimSpace(price)
+		bytes, err := parseBandSize(size)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := parseDecimal(price)
+		if !ok || r.Sign() <= 0 {
+			return nil, fmt.Errorf("%q is not a positive decimal price for %s", price, size)
+		}
+		if n := len(bands); n > 0 && bytes <= bands[n-1].Bytes {
+			return nil, fmt.Errorf("%s must be larger than %s before it", size, bands[n-1].Below)
+		}
+		bands = append(bands, SizeBand{Below: size, Bytes: bytes, Price: price})
+	}
+	return bands, nil
+}
+
+func parseBandSize(size string) (int, error) {
+	for _, unit := range sizeUnits {
+		if digits, ok := strings.CutSuffix(size, unit.suffix); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(digits))
+			if err != nil || n <= 0 || n > 1<<30/unit.bytes {
+				break
+			}
+			return n * unit.bytes, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a size such as 512B, 100KB or 1MB", size)
+}
+
+// getSizeBands returns the PRICE_SIZE_BANDS tiers, or nil when the price
+// doesn't depend on the text's size.
+func getSizeBands() []SizeBand {
+	if appConfig != nil {
+		return appConfig.SizeBands
+	}
+	bands, err := parseSizeBands(os.Getenv("PRICE_SIZE_BANDS"))
+	if err != nil {
+		log.Printf("Warning: Invalid PRICE_SIZE_BANDS (%v), ignoring it", err)
+		return nil
+	}
+	return bands
+}
+
+// sizeBandsFor returns the size bands that apply to ctx's request. A
+// tenant with its own payment_amount isn't priced by size.
+func sizeBandsFor(ctx context.Context) []SizeBand {
+	if t := tenantFrom(ctx); t != nil && t.PaymentAmount != "" {
+		return nil
+	}
+	return getSizeBands()
+}
+
+// sizeBandFor returns the band a text of size bytes falls in: the first
+// one it is shorter than, or the last for texts beyond every band.
+func sizeBandFor(ctx context.Context, size int) (SizeBand, bool) {
+	bands := sizeBandsFor(ctx)
+	if len(bands) == 0 {
+		return SizeBand{}, false
+	}
+	for _, band := range bands {
+		if size < band.Bytes {
+			return band, true
+		}
+	}
+	return bands[len(bands)-1], true
+}
+
+// advertisedSizeBands returns the size bands of ctx's request with their
+// prices in the payment token, for clients to see before they are quoted.
+func advertisedSizeBands(ctx context.Context) []SizeBand {
+	bands := sizeBandsFor(ctx)
+	if len(bands) == 0 {
+		return nil
+	}
+	advertised := make([]SizeBand, len(bands))
+	for i, band := range bands {
+		band.Price = usdToToken(band.Price)
+		advertised[i] = band
+	}
+	return advertised
+}
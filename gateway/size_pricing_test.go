@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/testsupport"
+)
+
+func TestParseSizeBands(t *testing.T) {
+	bands, err := parseSizeBands(" 512b=0.0005, 1KB=0.001 ,100KB=0.005,1MB=0.02")
+	if err != nil {
+		t.Fatalf("parseSizeBands failed: %v", err)
+	}
+	want := []SizeBand{{"512B", 512, "0.0005"}, {"1KB", 1024, "0.001"}, {"100KB", 102400, "0.005"}, {"1MB", 1 << 20, "0.02"}}
+	if len(bands) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, bands)
+	}
+	for i := range want {
+		if bands[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], bands[i])
+		}
+	}
+
+	for _, raw := range []string{"1KB", "1KB=abc", "1KB=0", "1KB=1e-3", "KB=0.1", "1GB=0.1", "0KB=0.1", "1MB=0.1,1KB=0.2", "1KB=0.1,1024B=0.2"} {
+		if _, err := parseSizeBands(raw); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestPriceForSize(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_SIZE_BANDS", "1KB=0.001,100KB=0.005,1MB=0.02")
+
+	t.Setenv("PRICE_PER_1K_TOKENS", "")
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, "0.001"},
+		{1023, "0.001"},
+		{1024, "0.005"}, // bands are exclusive of their bound
+		{500 << 10, "0.02"},
+		{5 << 20, "0.02"}, // beyond the last band
+	}
+	for _, tt := range tests {
+		if got := priceFor(context.Background(), "", tt.size, 0, summaryOptions{}); got != tt.want {
+			t.Errorf("priceFor(%d bytes) = %s, want %s", tt.size, got, tt.want)
+		}
+	}
+	if got := pricingScheme(context.Background()); got != "size_bands" {
+		t.Errorf("Expected the size_bands scheme, got %s", got)
+	}
+
+	// With per-token pricing the band is the minimum charge
+	t.Setenv("PRICE_PER_1K_TOKENS", "0.002")
+	if got := priceFor(context.Background(), "", 200<<10, 1000, summaryOptions{}); got != "0.02" {
+		t.Errorf("Expected the band's minimum charge, got %s", got)
+	}
+	if got := priceFor(context.Background(), "", 200<<10, 50000, summaryOptions{}); got != "0.1" {
+		t.Errorf("Expected the per-token price above the band's minimum, got %s", got)
+	}
+}
+
+func TestPriceForSize_PricedModel(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_SIZE_BANDS", "1KB=0.001,100KB=0.005,1MB=0.02")
+	t.Setenv("PRICE_PER_1K_TOKENS", "")
+	t.Setenv("ALLOWED_MODELS", "openai/gpt-4o-mini,anthropic/claude-3.5-sonnet=0.004")
+
+	// A model's own price scales the bands instead of replacing them
+	tests := []struct {
+		model string
+		size  int
+		want  string
+	}{
+		{"openai/gpt-4o-mini", 5 << 20, "0.02"},
+		{"anthropic/claude-3.5-sonnet", 10, "0.004"},
+		{"anthropic/claude-3.5-sonnet", 1024, "0.02"},
+		{"anthropic/claude-3.5-sonnet", 5 << 20, "0.08"},
+	}
+	for _, tt := range tests {
+		if got := priceFor(context.Background(), tt.model, tt.size, 0, summaryOptions{}); got != tt.want {
+			t.Errorf("priceFor(%s, %d bytes) = %s, want %s", tt.model, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestHandleSummarize_SizeBands(t *testing.T) {
+	ensureTestServerKey(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	t.Setenv("PRICE_SIZE_BANDS", "1KB=0.001,100KB=0.005")
+	r := setupVersionedRouter()
+	text := strings.Repeat("A document well over a kilobyte long. ", 100)
+	body, _ := json.Marshal(SummarizeRequest{Text: text})
+
+	send := func(nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(string(body)))
+		if nonce != "" {
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", nonce)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	p := decodeProblem(t, send(""))
+	payment, _ := p["paymentContext"].(map[string]interface{})
+	if p["size_band"] != "100KB" || payment["amount"] != "0.005" {
+		t.Errorf("Expected the 100KB band's price in the 402, got %v", p)
+	}
+	if bands, _ := p["size_bands"].([]interface{}); len(bands) != 2 {
+		t.Errorf("Expected both bands advertised, got %v", p["size_bands"])
+	}
+
+	if w := send("nonce-size-band"); w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if reqs := verifier.Requests(); len(reqs) != 1 || reqs[0].Context.Amount != "0.005" {
+		t.Errorf("Expected the verifier to check the band's price, got %+v", reqs)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Stages of a request whose time is broken out of its total latency.
+const (
+	stageVerification = "verification" // verifier or facilitator check of the payment
+	stageCache        = "cache"        // response cache lookup
+	stageQueue        = "queue"        // waiting in a micro-batch before dispatch
+	stageProvider     = "provider"     // upstream AI calls, summed across chunks and failovers
+)
+
+// requestStages lists the stages in the order they are logged.
+var requestStages = []string{stageVerification, stageCache, stageQueue, stageProvider}
+
+var requestStageSeconds = newHistogram(
+	"gateway_request_stage_seconds",
+	"Time a request spent in each stage (verification, cache, queue, provider), for requests that reached it.",
+	[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	"stage",
+)
+
+type stageTimingsKey struct{}
+
+// stageTimings adds up the time one request spends in each stage. Like the
+// providerRecorder it travels on the context, so the layers that do the
+// work (verifier client, cache, batcher, providers) can report it without
+// threading it through every call.
+type stageTimings struct {
+	mu     sync.Mutex
+	stages map[string]time.Duration
+}
+
+// withStageTimings returns a context whose stages are recorded in the
+// returned timings.
+func withStageTimings(ctx context.Context) (context.Context, *stageTimings) {
+	t := &stageTimings{stages: make(map[string]time.Duration)}
+	return context.WithValue(ctx, stageTimingsKey{}, t), t
+}
+
+// recordStage adds d to stage on ctx's timings, if any. Work done on a
+// detached context, such as a micro-batch call, is attributed by the
+// caller that waited for it.
+func recordStage(ctx context.Context, stage string, d time.Duration) {
+	if t, ok := ctx.Value(stageTimingsKey{}).(*stageTimings); ok {
+		t.mu.Lock()
+		t.stages[stage] += d
+		t.mu.Unlock()
+	}
+}
+
+// finish observes each recorded stage in requestStageSeconds and returns
+// them as <stage>_ms log attributes.
+func (t *stageTimings) finish() []slog.Attr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var attrs []slog.Attr
+	for _, stage := range requestStages {
+		d, ok := t.stages[stage]
+		if !ok {
+			continue
+		}
+		requestStageSeconds.Observe(d.Seconds(), stage)
+		attrs = append(attrs, slog.Int64(stage+"_ms", d.Milliseconds()))
+	}
+	return attrs
+}
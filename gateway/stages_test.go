@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricHistogram(t *testing.T) {
+	h := &metricHistogram{name: "test_seconds", help: "Test.", labels: []string{"stage"}, buckets: []float64{0.1, 1}, series: make(map[string]*histogramSeries)}
+	h.Observe(0.05, "cache")
+	h.Observe(0.1, "cache")
+	h.Observe(0.5, "cache")
+	h.Observe(3, "cache")
+
+	var sb strings.Builder
+	h.writeTo(&sb)
+	for _, want := range []string{
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{stage="cache",le="0.1"} 2`,
+		`test_seconds_bucket{stage="cache",le="1"} 3`,
+		`test_seconds_bucket{stage="cache",le="+Inf"} 4`,
+		`test_seconds_sum{stage="cache"} 3.65`,
+		`test_seconds_count{stage="cache"} 4`,
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("Expected %q in\n%s", want, sb.String())
+		}
+	}
+	if h.Count("cache") != 4 || h.Count("provider") != 0 {
+		t.Errorf("Unexpected counts %d, %d", h.Count("cache"), h.Count("provider"))
+	}
+}
+
+func TestRequestLogger_StageBreakdown(t *testing.T) {
+	restoreLoggers(t)
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestLogger())
+	registerAPIRoutes(r.Group(apiV1Prefix))
+
+	before := map[string]uint64{}
+	for _, stage := range requestStages {
+		before[stage] = requestStageSeconds.Count(stage)
+	}
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"Stages of a paid request are timed."}`))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "n-stages-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var line map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		json.Unmarshal([]byte(raw), &line)
+		if line["msg"] == "request" {
+			break
+		}
+	}
+	for _, field := range []string{"verification_ms", "cache_ms", "provider_ms"} {
+		if _, ok := line[field].(float64); !ok {
+			t.Errorf("Expected %s in the access log, got %v", field, line)
+		}
+	}
+	if _, ok := line["queue_ms"]; ok {
+		t.Errorf("Expected no queue_ms without micro-batching, got %v", line)
+	}
+	for _, stage := range []string{stageVerification, stageCache, stageProvider} {
+		if got := requestStageSeconds.Count(stage) - before[stage]; got != 1 {
+			t.Errorf("Expected one %s observation, got %d", stage, got)
+		}
+	}
+	if requestStageSeconds.Count(stageQueue) != before[stageQueue] {
+		t.Error("Expected no queue observation")
+	}
+}
+
+func TestMicroBatcher_RecordsQueueWait(t *testing.T) {
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+
+	b := newMicroBatcher(30*time.Millisecond, 8, 500)
+	ctx, stages := withStageTimings(withPayer(context.Background(), "0xpayer"))
+	if _, _, err := b.Summarize(ctx, "m", "text"); err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if queued := stages.stages[stageQueue]; queued < 25*time.Millisecond {
+		t.Errorf("Expected the batch window counted as queue wait, got %s", queued)
+	}
+	if _, ok := stages.stages[stageProvider]; !ok {
+		t.Error("Expected the detached batch call counted as provider time")
+	}
+}
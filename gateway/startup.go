@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Backoff between rounds of startup dependency probes.
+const (
+	startupBaseDelay = 250 * time.Millisecond
+	startupMaxDelay  = 5 * time.Second
+)
+
+// startupDependency is a dependency the gateway can wait for before it
+// starts serving.
+type startupDependency struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// waitForDepsEnabled reports whether WAIT_FOR_DEPS=true, which holds
+// startup until the verifier and Redis answer.
+func waitForDepsEnabled() bool {
+	return strings.ToLower(os.Getenv("WAIT_FOR_DEPS")) == "true"
+}
+
+// startupDependencies returns the verifier and, when REDIS_URL is set,
+// Redis. Redis is probed with a client of its own because initRedis only
+// tries once and falls back to the in-memory cache for good.
+func startupDependencies() []startupDependency {
+	deps := []startupDependency{{name: "verifier", check: checkVerifierHealth}}
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		// A malformed URL is reported by initRedis
+		if opts, err := redisOptionsFromEnv(redisURL); err == nil {
+			deps = append(deps, startupDependency{name: "redis", check: func(ctx context.Context) error {
+				client := redis.NewClient(opts)
+				defer client.Close()
+				return client.Ping(ctx).Err()
+			}})
+		}
+	}
+	return deps
+}
+
+// waitForStartupDependencies waits up to WAIT_FOR_DEPS_TIMEOUT_SECONDS
+// (default 60) for the startup dependencies to answer, so that under
+// docker-compose the gateway doesn't bind its port and serve errors while
+// the verifier and Redis are still starting.
+func waitForStartupDependencies(ctx context.Context) error {
+	timeout := time.Duration(getEnvAsInt("WAIT_FOR_DEPS_TIMEOUT_SECONDS", 60)) * time.Second
+	return waitForDependencies(ctx, startupDependencies(), timeout, startupBaseDelay, startupMaxDelay)
+}
+
+// waitForDependencies probes the dependencies still down, each bounded by
+// the health check timeout, until all have answered. Rounds are spaced by
+// a delay doubling from baseDelay up to maxDelay. After timeout it returns
+// the dependencies that never answered and their last errors.
+func waitForDependencies(ctx context.Context, deps []startupDependency, timeout, baseDelay, maxDelay time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	pending := make(map[string]error, len(deps))
+	for _, dep := range deps {
+		pending[dep.name] = nil
+	}
+
+	delay := baseDelay
+	for attempt := 1; ; attempt++ {
+		for _, dep := range deps {
+			if _, waiting := pending[dep.name]; !waiting {
+				continue
+			}
+			checkCtx, checkCancel := context.WithTimeout(ctx, getHealthCheckTimeout())
+			err := dep.check(checkCtx)
+			checkCancel()
+			if err == nil {
+				delete(pending, dep.name)
+				log.Printf("Startup dependency %s is up after %s", dep.name, time.Since(start).Round(time.Millisecond))
+				continue
+			}
+			pending[dep.name] = err
+			log.Printf("Warning: waiting for %s (attempt %d, retrying in %s): %v", dep.name, attempt, delay, err)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			down := make([]string, 0, len(pending))
+			for name, err := range pending {
+				down = append(down, fmt.Sprintf("%s (%v)", name, err))
+			}
+			sort.Strings(down)
+			return fmt.Errorf("still unreachable after %s: %s", timeout, strings.Join(down, ", "))
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForDependencies_RetriesUntilUp(t *testing.T) {
+	var verifierCalls, redisCalls atomic.Int32
+	deps := []startupDependency{
+		{name: "verifier", check: func(ctx context.Context) error {
+			if verifierCalls.Add(1) < 3 {
+				return errors.New("connection refused")
+			}
+			return nil
+		}},
+		{name: "redis", check: func(ctx context.Context) error {
+			redisCalls.Add(1)
+			return nil
+		}},
+	}
+
+	start := time.Now()
+	if err := waitForDependencies(context.Background(), deps, time.Second, 10*time.Millisecond, 15*time.Millisecond); err != nil {
+		t.Fatalf("Expected the dependencies to come up, got %v", err)
+	}
+	if verifierCalls.Load() != 3 || redisCalls.Load() != 1 {
+		t.Errorf("Expected 3 verifier probes and 1 Redis probe, got %d and %d", verifierCalls.Load(), redisCalls.Load())
+	}
+	// 10ms, then 15ms (capped)
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("Expected backoff between rounds, took %s", elapsed)
+	}
+}
+
+func TestWaitForDependencies_GivesUpAtDeadline(t *testing.T) {
+	deps := []startupDependency{
+		{name: "verifier", check: func(ctx context.Context) error { return nil }},
+		{name: "redis", check: func(ctx context.Context) error { return errors.New("connection refused") }},
+	}
+	start := time.Now()
+	err := waitForDependencies(context.Background(), deps, 50*time.Millisecond, 10*time.Millisecond, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "redis (connection refused)") || strings.Contains(err.Error(), "verifier") {
+		t.Fatalf("Expected only Redis reported down, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected to give up at the deadline, took %s", elapsed)
+	}
+}
+
+func TestStartupDependencies(t *testing.T) {
+	mr := setupTestRedis(t)
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(404)
+		}
+	}))
+	defer verifier.Close()
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+	t.Setenv("WAIT_FOR_DEPS_TIMEOUT_SECONDS", "1")
+
+	deps := startupDependencies()
+	if len(deps) != 2 || deps[0].name != "verifier" || deps[1].name != "redis" {
+		t.Fatalf("Expected the verifier and Redis, got %+v", deps)
+	}
+	if err := waitForStartupDependencies(context.Background()); err != nil {
+		t.Errorf("Expected both reachable, got %v", err)
+	}
+
+	t.Setenv("REDIS_URL", "")
+	if deps := startupDependencies(); len(deps) != 1 {
+		t.Errorf("Expected only the verifier without REDIS_URL, got %+v", deps)
+	}
+}
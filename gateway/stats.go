@@ -0,0 +1,214 @@
+package main
+
+import (
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statsWindows are the windows GET /admin/stats reports, shortest first.
+var statsWindows = []struct {
+	name string
+	d    time.Duration
+}{{"1h", time.Hour}, {"24h", 24 * time.Hour}, {"7d", 7 * 24 * time.Hour}}
+
+// apiTraffic counts responses of the public API for the error rates in GET
+// /admin/stats.
+var apiTraffic = newTrafficCounter(7 * 24 * time.Hour)
+
+// AdminStatsResponse is the body of GET /admin/stats, keyed by window (1h,
+// 24h, 7d).
+type AdminStatsResponse struct {
+	GeneratedAt time.Time              `json:"generated_at"`
+	Windows     map[string]WindowStats `json:"windows"`
+}
+
+// WindowStats are the figures for one window.
+type WindowStats struct {
+	Since   time.Time    `json:"since"`
+	Paid    *PaidStats   `json:"paid,omitempty" doc:"Paid requests from the usage ledger; absent when it is disabled"`
+	Traffic TrafficStats `json:"traffic"`
+}
+
+// PaidStats roll up the usage ledger.
+type PaidStats struct {
+	Requests      int           `json:"requests"`
+	UniqueWallets int           `json:"unique_wallets"`
+	Revenue       []RevenueLine `json:"revenue" doc:"Amount charged per token and chain"`
+	CacheHitRate  float64       `json:"cache_hit_rate" doc:"Share of paid requests served from the cache"`
+	ProviderSpend string        `json:"provider_spend" doc:"Upstream AI cost in USD, as reported by the providers"`
+}
+
+// RevenueLine is the revenue in one token on one chain.
+type RevenueLine struct {
+	Token   string `json:"token" example:"USDC"`
+	ChainID int    `json:"chain_id" example:"8453"`
+	Amount  string `json:"amount" example:"1.234"`
+}
+
+// TrafficStats count the public API's responses on this instance.
+type TrafficStats struct {
+	Responses       int     `json:"responses"`
+	PaymentRequired int     `json:"payment_required" doc:"402 responses, the first step of every paid request"`
+	ClientErrors    int     `json:"client_errors" doc:"Other 4xx responses"`
+	ServerErrors    int     `json:"server_errors" doc:"5xx responses"`
+	ClientErrorRate float64 `json:"client_error_rate"`
+	ServerErrorRate float64 `json:"server_error_rate"`
+}
+
+// trafficCounter keeps per-minute response counts in a ring covering its
+// retention.
+type trafficCounter struct {
+	mu      sync.Mutex
+	buckets []trafficBucket
+}
+
+type trafficBucket struct {
+	minute int64 // Unix minute the counts belong to
+	TrafficStats
+}
+
+func newTrafficCounter(retention time.Duration) *trafficCounter {
+	return &trafficCounter{buckets: make([]trafficBucket, int(retention/time.Minute))}
+}
+
+// record counts a response with status at now.
+func (t *trafficCounter) record(now time.Time, status int) {
+	minute := now.Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[minute%int64(len(t.buckets))]
+	if b.minute != minute {
+		*b = trafficBucket{minute: minute}
+	}
+	b.Responses++
+	switch {
+	case status == 402:
+		b.PaymentRequired++
+	case status >= 500:
+		b.ServerErrors++
+	case status >= 400:
+		b.ClientErrors++
+	}
+}
+
+// since sums the counts from since's minute on.
+func (t *trafficCounter) since(since time.Time) TrafficStats {
+	from := since.Unix() / 60
+	var s TrafficStats
+	t.mu.Lock()
+	for _, b := range t.buckets {
+		if b.minute >= from && b.Responses > 0 {
+			s.Responses += b.Responses
+			s.PaymentRequired += b.PaymentRequired
+			s.ClientErrors += b.ClientErrors
+			s.ServerErrors += b.ServerErrors
+		}
+	}
+	t.mu.Unlock()
+	if s.Responses > 0 {
+		s.ClientErrorRate = float64(s.ClientErrors) / float64(s.Responses)
+		s.ServerErrorRate = float64(s.ServerErrors) / float64(s.Responses)
+	}
+	return s
+}
+
+// RecordAPITraffic counts the responses of the public API (/v1 and /api) in
+// apiTraffic. Register it before RequestTimeoutMiddleware, which swaps the
+// writer, so the status sent to the client is the one counted.
+func RecordAPITraffic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !strings.HasPrefix(path, apiV1Prefix+"/") && !strings.HasPrefix(path, legacyAPIPrefix+"/") {
+			c.Next()
+			return
+		}
+		w := c.Writer
+		c.Next()
+		apiTraffic.record(time.Now(), w.Status())
+	}
+}
+
+// handleAdminStats handles GET /admin/stats. Paid figures come from the usage
+// ledger and cover every instance; traffic figures are this instance's.
+func handleAdminStats(c *gin.Context) {
+	now := time.Now().UTC()
+	resp := AdminStatsResponse{GeneratedAt: now, Windows: make(map[string]WindowStats, len(statsWindows))}
+
+	var entries []LedgerEntry
+	if usageLedger != nil {
+		var err error
+		longest := statsWindows[len(statsWindows)-1].d
+		if entries, err = usageLedger.Entries(c.Request.Context(), ledgerQuery{Since: now.Add(-longest)}); err != nil {
+			log.Printf("error reading ledger for stats: %v", err)
+			abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read usage ledger", ""))
+			return
+		}
+	}
+
+	for _, w := range statsWindows {
+		since := now.Add(-w.d)
+		stats := WindowStats{Since: since, Traffic: apiTraffic.since(since)}
+		if usageLedger != nil {
+			stats.Paid = summarizePaid(entries, since)
+		}
+		resp.Windows[w.name] = stats
+	}
+	c.JSON(200, resp)
+}
+
+// summarizePaid rolls up the entries from since on.
+func summarizePaid(entries []LedgerEntry, since time.Time) *PaidStats {
+	type revenueKey struct {
+		token string
+		chain int
+	}
+	paid := &PaidStats{Revenue: []RevenueLine{}}
+	wallets := make(map[string]bool)
+	revenue := make(map[revenueKey]*big.Rat)
+	spend := new(big.Rat)
+	hits := 0
+	for _, e := range entries {
+		// Revenue counts charges; refunds are reported by the ledger export
+		if e.Time.Before(since) || e.Type == ledgerTypeRefund || e.Type == ledgerTypeSettlement {
+			continue
+		}
+		paid.Requests++
+		wallets[strings.ToLower(e.Wallet)] = true
+		if e.CacheHit {
+			hits++
+		}
+		// Entries written before the token was recorded were all USDC
+		key := revenueKey{e.Token, e.ChainID}
+		if key.token == "" {
+			key.token = "USDC"
+		}
+		if amount, ok := new(big.Rat).SetString(e.Amount); ok {
+			if revenue[key] == nil {
+				revenue[key] = new(big.Rat)
+			}
+			revenue[key].Add(revenue[key], amount)
+		}
+		if cost, ok := new(big.Rat).SetString(e.ProviderCost); ok && e.ProviderCost != "" {
+			spend.Add(spend, cost)
+		}
+	}
+	paid.UniqueWallets = len(wallets)
+	if paid.Requests > 0 {
+		paid.CacheHitRate = float64(hits) / float64(paid.Requests)
+	}
+	paid.ProviderSpend = formatUSD(spend)
+	for key, amount := range revenue {
+		paid.Revenue = append(paid.Revenue, RevenueLine{Token: key.token, ChainID: key.chain, Amount: formatAmount(amount)})
+	}
+	sort.Slice(paid.Revenue, func(i, j int) bool {
+		a, b := paid.Revenue[i], paid.Revenue[j]
+		return a.Token < b.Token || a.Token == b.Token && a.ChainID < b.ChainID
+	})
+	return paid
+}
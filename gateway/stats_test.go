@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrafficCounter(t *testing.T) {
+	tc := newTrafficCounter(time.Hour)
+	now := time.Now()
+	tc.record(now.Add(-2*time.Hour), 500) // overwritten: same ring slot as now
+	for _, status := range []int{200, 200, 402, 404, 500} {
+		tc.record(now, status)
+	}
+	tc.record(now.Add(-30*time.Minute), 200)
+
+	got := tc.since(now.Add(-10 * time.Minute))
+	if got.Responses != 5 || got.PaymentRequired != 1 || got.ClientErrors != 1 || got.ServerErrors != 1 {
+		t.Errorf("Unexpected counts %+v", got)
+	}
+	if got.ServerErrorRate != 0.2 {
+		t.Errorf("Expected a 0.2 server error rate, got %v", got.ServerErrorRate)
+	}
+	if all := tc.since(now.Add(-time.Hour)); all.Responses != 6 {
+		t.Errorf("Expected 6 responses in the hour, got %d", all.Responses)
+	}
+}
+
+func TestRecordAPITraffic_CountsOnlyAPIRoutes(t *testing.T) {
+	prev := apiTraffic
+	apiTraffic = newTrafficCounter(time.Hour)
+	t.Cleanup(func() { apiTraffic = prev })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RecordAPITraffic())
+	r.GET("/v1/models", func(c *gin.Context) { c.Status(503) })
+	r.GET("/healthz", func(c *gin.Context) { c.Status(200) })
+	for _, path := range []string{"/v1/models", "/healthz"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := apiTraffic.since(time.Now().Add(-time.Minute)); got.Responses != 1 || got.ServerErrors != 1 {
+		t.Errorf("Expected only the API response to be counted, got %+v", got)
+	}
+}
+
+func TestSummarizePaid(t *testing.T) {
+	now := time.Now()
+	paid := summarizePaid([]LedgerEntry{
+		{Time: now.Add(-2 * time.Hour), Wallet: "0xaa", Amount: "5"},
+		{Time: now, Wallet: "0xAA", Amount: "0.001", Token: "USDC", ChainID: 8453, ProviderCost: "0.0002"},
+		{Time: now, Wallet: "0xbb", Amount: "0.002", Token: "USDC", ChainID: 8453, CacheHit: true},
+		{Time: now, Wallet: "0xbb", Amount: "0.003", Token: "USDC", ChainID: 84532},
+	}, now.Add(-time.Hour))
+
+	if paid.Requests != 3 || paid.UniqueWallets != 2 || paid.ProviderSpend != "0.0002" {
+		t.Errorf("Unexpected totals %+v", paid)
+	}
+	if paid.CacheHitRate < 0.33 || paid.CacheHitRate > 0.34 {
+		t.Errorf("Expected a 1/3 cache hit rate, got %v", paid.CacheHitRate)
+	}
+	want := []RevenueLine{{"USDC", 8453, "0.003"}, {"USDC", 84532, "0.003"}}
+	if len(paid.Revenue) != 2 || paid.Revenue[0] != want[0] || paid.Revenue[1] != want[1] {
+		t.Errorf("Expected revenue %v, got %v", want, paid.Revenue)
+	}
+}
+
+func TestHandleAdminStats(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+
+	w := adminRequest(r, "GET", "/admin/stats", "secret")
+	var resp AdminStatsResponse
+	if w.Code != 200 || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("Expected stats, got %d %s", w.Code, w.Body.String())
+	}
+	if len(resp.Windows) != 3 || resp.Windows["24h"].Paid != nil {
+		t.Errorf("Expected 3 windows without paid figures while the ledger is off, got %+v", resp.Windows)
+	}
+
+	setupTestLedger(t).Append(context.Background(), LedgerEntry{Time: time.Now().Add(-3 * time.Hour), Wallet: "0xaa", Amount: "0.001"})
+	w = adminRequest(r, "GET", "/admin/stats", "secret")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Windows["1h"].Paid.Requests != 0 || resp.Windows["24h"].Paid.Requests != 1 || resp.Windows["7d"].Paid.Requests != 1 {
+		t.Errorf("Expected the entry in the 24h and 7d windows only, got %+v", resp.Windows)
+	}
+}
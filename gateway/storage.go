@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // registers the "postgres" driver
+)
+
+// dataStore is the SQL database selected by DATABASE_URL. It is nil unless
+// DATABASE_URL is set.
+var dataStore Store
+
+// Store is the persistence layer shared by the features that need durable
+// state, instead of each keeping its own Redis keys or files: receipts,
+// the usage ledger (LEDGER_BACKEND=sql), background jobs and API keys. Its
+// schema is versioned by the migrations embedded in the binary.
+type Store interface {
+	ledgerStore
+
+	// SaveReceipt keeps r until expiresAt.
+	SaveReceipt(ctx context.Context, r *SignedReceipt, expiresAt time.Time) error
+	// Receipt returns the receipt with id, or nil when there is none or it
+	// expired.
+	Receipt(ctx context.Context, id string) (*SignedReceipt, error)
+	// PurgeReceipts removes the receipts issued before before, and any that
+	// expired, returning how many it removed.
+	PurgeReceipts(ctx context.Context, before time.Time) (int64, error)
+	// EraseReceipts removes payer's receipts and returns how many it
+	// removed.
+	EraseReceipts(ctx context.Context, payer string) (int64, error)
+
+	// EnqueueJob schedules a job of kind to run at runAt and returns its ID.
+	EnqueueJob(ctx context.Context, kind string, payload []byte, runAt time.Time) (int64, error)
+	// ClaimJob leases the longest-due job of kind for lease, or returns nil
+	// when none is due. A job neither completed nor failed before its lease
+	// runs out is claimed again.
+	ClaimJob(ctx context.Context, kind string, lease time.Duration) (*Job, error)
+	// CompleteJob marks a claimed job done.
+	CompleteJob(ctx context.Context, id int64) error
+	// FailJob records why a claimed job failed and runs it again at retryAt.
+	FailJob(ctx context.Context, id int64, jobErr error, retryAt time.Time) error
+
+	// CreateAPIKey stores k, looked up by the hash of its secret
+	// (apiKeyHash).
+	CreateAPIKey(ctx context.Context, k APIKey, hash string) error
+	// APIKeyByHash returns the unrevoked key whose secret hashes to hash, or
+	// nil.
+	APIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+
+	Close() error
+}
+
+// Job is a unit of background work claimed from the store.
+type Job struct {
+	ID       int64
+	Kind     string
+	Payload  []byte
+	Attempts int // including this one
+	RunAt    time.Time
+}
+
+// APIKey identifies a caller by a secret whose hash is stored, never the
+// secret itself.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner" doc:"Wallet or team the key belongs to"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiKeyHash returns the hex SHA-256 of an API key secret, as stored.
+func apiKeyHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SQL dialects, each with its own migrations.
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+)
+
+// sqliteDriver names the database/sql driver for SQLite. It is only
+// registered in cgo builds (storage_sqlite.go); the Docker image is built
+// without cgo and supports Postgres only.
+var sqliteDriver string
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migration is one embedded schema change, named NNNN_description.sql.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// sqlStore implements Store over SQLite or Postgres. Queries are written
+// with Postgres placeholders ($1) and rebound for SQLite.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// parseDatabaseURL splits DATABASE_URL into the dialect and the driver's
+// data source name: postgres://... (or postgresql://) is passed to Postgres
+// as is, sqlite:path or sqlite:///abs/path opens a SQLite file.
+func parseDatabaseURL(raw string) (dialect, dsn string, err error) {
+	switch {
+	case strings.HasPrefix(raw, "postgres://"), strings.HasPrefix(raw, "postgresql://"):
+		return dialectPostgres, raw, nil
+	case strings.HasPrefix(raw, "sqlite:"):
+		file := strings.TrimPrefix(strings.TrimPrefix(raw, "sqlite:"), "//")
+		if file == "" {
+			return "", "", errors.New("sqlite: URL has no file path")
+		}
+		return dialectSQLite, file, nil
+	}
+	return "", "", errors.New("must start with postgres:// or sqlite:")
+}
+
+// openStore connects to the database at databaseURL. It doesn't migrate.
+func openStore(databaseURL string) (*sqlStore, error) {
+	dialect, dsn, err := parseDatabaseURL(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	driver := "postgres"
+	if dialect == dialectSQLite {
+		if sqliteDriver == "" {
+			return nil, errors.New("SQLite needs a gateway built with cgo; use Postgres")
+		}
+		driver, dsn = sqliteDriver, dsn+"?_busy_timeout=5000&_foreign_keys=on"
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == dialectSQLite {
+		// One writer at a time; a single connection avoids busy errors
+		db.SetMaxOpenConns(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: dialect}, nil
+}
+
+// initStore opens DATABASE_URL, if set, and checks its schema is current.
+// Pending migrations are applied with DATABASE_AUTO_MIGRATE=true and are an
+// error otherwise, so a schema change is never made by surprise: run
+// "gateway migrate" first.
+func initStore() (*sqlStore, error) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		return nil, nil
+	}
+	s, err := openStore(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if strings.ToLower(os.Getenv("DATABASE_AUTO_MIGRATE")) == "true" {
+		applied, err := s.migrate(ctx)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.version, m.name)
+		}
+	} else if pending, err := s.pendingMigrations(ctx); err != nil {
+		s.Close()
+		return nil, err
+	} else if len(pending) > 0 {
+		s.Close()
+		return nil, fmt.Errorf("%d migrations pending (run \"gateway migrate\" or set DATABASE_AUTO_MIGRATE=true)", len(pending))
+	}
+	log.Printf("Store connected (%s)", s.dialect)
+	return s, nil
+}
+
+// runMigrate implements "gateway migrate" (apply pending migrations) and
+// "gateway migrate status", returning the exit code.
+func runMigrate(args []string, stdout io.Writer) int {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		fmt.Fprintln(stdout, "[Error] DATABASE_URL is not set")
+		return 1
+	}
+	s, err := openStore(databaseURL)
+	if err != nil {
+		fmt.Fprintf(stdout, "[Error] Failed to open DATABASE_URL: %v\n", err)
+		return 1
+	}
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch {
+	case len(args) == 0:
+		applied, err := s.migrate(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(stdout, "[OK] Applied %04d_%s\n", m.version, m.name)
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "[Error] Migration failed: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(stdout, "[OK] Schema is up to date")
+		}
+	case len(args) == 1 && args[0] == "status":
+		all, err := s.migrations()
+		if err != nil {
+			fmt.Fprintf(stdout, "[Error] %v\n", err)
+			return 1
+		}
+		done, err := s.appliedMigrations(ctx)
+		if err != nil {
+			fmt.Fprintf(stdout, "[Error] %v\n", err)
+			return 1
+		}
+		for _, m := range all {
+			state := "pending"
+			if done[m.version] {
+				state = "applied"
+			}
+			fmt.Fprintf(stdout, "%04d_%s\t%s\n", m.version, m.name, state)
+		}
+	default:
+		fmt.Fprintln(stdout, "Usage: gateway migrate [status]")
+		return 2
+	}
+	return 0
+}
+
+// migrations returns the dialect's embedded migrations, oldest first.
+func (s *sqlStore) migrations() ([]migration, error) {
+	dir := path.Join("migrations", s.dialect)
+	files, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var all []migration
+	for _, f := range files {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(f.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || !strings.HasSuffix(f.Name(), ".sql") {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", f.Name())
+		}
+		body, err := migrationFiles.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, migration{version: version, name: name, sql: string(body)})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].version < all[j].version })
+	return all, nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations,
+// creating the table on first use.
+func (s *sqlStore) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+func (s *sqlStore) pendingMigrations(ctx context.Context) ([]migration, error) {
+	all, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []migration
+	for _, m := range all {
+		if !applied[m.version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrate applies the pending migrations in order, each in a transaction
+// with its schema_migrations row, and returns those it applied. On
+// Postgres an advisory lock keeps two gateways from applying the same one.
+func (s *sqlStore) migrate(ctx context.Context) ([]migration, error) {
+	pending, err := s.pendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []migration
+	for _, m := range pending {
+		done, err := s.apply(ctx, m)
+		if err != nil {
+			return applied, fmt.Errorf("%04d_%s: %w", m.version, m.name, err)
+		}
+		if done {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// apply runs one migration, or reports false when another gateway got to it
+// first.
+func (s *sqlStore) apply(ctx context.Context, m migration) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if s.dialect == dialectPostgres {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(402402)`); err != nil {
+			return false, err
+		}
+	}
+	var seen int
+	err = tx.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = $1`), m.version).Scan(&seen)
+	if err != nil || seen > 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`),
+		m.version, m.name, time.Now().Unix()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// rebind turns $N placeholders into SQLite's ?N, which binds by number just
+// the same.
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect == dialectSQLite {
+		return strings.ReplaceAll(query, "$", "?")
+	}
+	return query
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) Append(ctx context.Context, e LedgerEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.rebind(`INSERT INTO ledger_entries (time, wallet, nonce, type, entry) VALUES ($1, $2, $3, $4, $5)`),
+		e.Time.UnixNano(), strings.ToLower(e.Wallet), e.Nonce, e.Type, string(data))
+	return err
+}
+
+// ledgerWhere builds the WHERE clause selecting q's entries after row
+// after.
+func ledgerWhere(q ledgerQuery, after int64) (string, []interface{}) {
+	conds, args := []string{"id > $1"}, []interface{}{after}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if q.Wallet != "" {
+		add("wallet = $%d", strings.ToLower(q.Wallet))
+	}
+	if !q.Since.IsZero() {
+		add("time >= $%d", q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		add("time < $%d", q.Until.UnixNano())
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// scanLedger decodes the rows' id and entry columns, returning the last id
+// read.
+func scanLedger(rows *sql.Rows) ([]LedgerEntry, int64, error) {
+	defer rows.Close()
+	var entries []LedgerEntry
+	var last int64
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&last, &data); err != nil {
+			return nil, 0, err
+		}
+		var e LedgerEntry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			log.Printf("ledger entry %d is corrupt: %v", last, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, last, rows.Err()
+}
+
+func (s *sqlStore) Entries(ctx context.Context, q ledgerQuery) ([]LedgerEntry, error) {
+	where, args := ledgerWhere(q, 0)
+	query := `SELECT id, entry FROM ledger_entries` + where + ` ORDER BY id`
+	if q.Limit > 0 {
+		// The most recent q.Limit, still returned oldest first
+		query = `SELECT id, entry FROM (SELECT id, entry FROM ledger_entries` + where +
+			` ORDER BY id DESC LIMIT ` + strconv.Itoa(q.Limit) + `) recent ORDER BY id`
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := scanLedger(rows)
+	return entries, err
+}
+
+// Page continues after the row ID in cursor.
+func (s *sqlStore) Page(ctx context.Context, q ledgerQuery, cursor string, limit int) ([]LedgerEntry, string, error) {
+	var after int64
+	if cursor != "" {
+		var err error
+		if after, err = strconv.ParseInt(cursor, 10, 64); err != nil || after < 0 {
+			return nil, "", errInvalidCursor
+		}
+	}
+	where, args := ledgerWhere(q, after)
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, entry FROM ledger_entries`+where+` ORDER BY id LIMIT `+strconv.Itoa(limit)), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	entries, last, err := scanLedger(rows)
+	if err != nil || len(entries) < limit {
+		return entries, "", err
+	}
+	return entries, strconv.FormatInt(last, 10), nil
+}
+
+func (s *sqlStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM ledger_entries WHERE time < $1`), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Erase rewrites wallet's rows in one transaction.
+func (s *sqlStore) Erase(ctx context.Context, wallet string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, s.rebind(`SELECT id, entry FROM ledger_entries WHERE wallet = $1`), strings.ToLower(wallet))
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		id    int64
+		entry LedgerEntry
+	}
+	var erased []row
+	for rows.Next() {
+		var r row
+		var data string
+		if err := rows.Scan(&r.id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if json.Unmarshal([]byte(data), &r.entry) == nil {
+			erased = append(erased, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, r := range erased {
+		r.entry.anonymize()
+		data, err := json.Marshal(r.entry)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind(`UPDATE ledger_entries SET wallet = '', entry = $1 WHERE id = $2`), string(data), r.id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(erased)), tx.Commit()
+}
+
+func (s *sqlStore) SaveReceipt(ctx context.Context, r *SignedReceipt, expiresAt time.Time) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.rebind(`INSERT INTO receipts (id, payer, receipt, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET receipt = excluded.receipt, expires_at = excluded.expires_at`),
+		r.Receipt.ID, strings.ToLower(r.Receipt.Payment.Payer), string(data), r.Receipt.Timestamp.UnixNano(), expiresAt.UnixNano())
+	return err
+}
+
+func (s *sqlStore) Receipt(ctx context.Context, id string) (*SignedReceipt, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT receipt FROM receipts WHERE id = $1 AND expires_at > $2`), id, time.Now().UnixNano()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r SignedReceipt
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *sqlStore) PurgeReceipts(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM receipts WHERE created_at < $1 OR expires_at <= $2`),
+		before.UnixNano(), time.Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) EraseReceipts(ctx context.Context, payer string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM receipts WHERE payer = $1`), strings.ToLower(payer))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) EnqueueJob(ctx context.Context, kind string, payload []byte, runAt time.Time) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO jobs (kind, payload, run_at, created_at) VALUES ($1, $2, $3, $4) RETURNING id`),
+		kind, string(payload), runAt.UnixNano(), time.Now().UnixNano()).Scan(&id)
+	return id, err
+}
+
+func (s *sqlStore) ClaimJob(ctx context.Context, kind string, lease time.Duration) (*Job, error) {
+	now := time.Now()
+	// The lock check is repeated outside the subquery, so of two gateways
+	// racing for the same job only one updates it
+	row := s.db.QueryRowContext(ctx, s.rebind(`UPDATE jobs SET locked_until = $1, attempts = attempts + 1
+		WHERE id = (SELECT id FROM jobs WHERE kind = $2 AND done_at = 0 AND run_at <= $3 AND locked_until <= $3 ORDER BY run_at, id LIMIT 1)
+		AND locked_until <= $3
+		RETURNING id, kind, payload, attempts, run_at`), now.Add(lease).UnixNano(), kind, now.UnixNano())
+	var j Job
+	var payload string
+	var runAt int64
+	err := row.Scan(&j.ID, &j.Kind, &payload, &j.Attempts, &runAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j.Payload, j.RunAt = []byte(payload), time.Unix(0, runAt).UTC()
+	return &j, nil
+}
+
+func (s *sqlStore) CompleteJob(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE jobs SET done_at = $1, locked_until = 0 WHERE id = $2`), time.Now().UnixNano(), id)
+	return err
+}
+
+func (s *sqlStore) FailJob(ctx context.Context, id int64, jobErr error, retryAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE jobs SET last_error = $1, run_at = $2, locked_until = 0 WHERE id = $3`),
+		jobErr.Error(), retryAt.UnixNano(), id)
+	return err
+}
+
+func (s *sqlStore) CreateAPIKey(ctx context.Context, k APIKey, hash string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO api_keys (id, key_hash, owner, name, created_at) VALUES ($1, $2, $3, $4, $5)`),
+		k.ID, hash, k.Owner, k.Name, k.CreatedAt.UnixNano())
+	return err
+}
+
+func (s *sqlStore) APIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	var k APIKey
+	var created int64
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT id, owner, name, created_at FROM api_keys WHERE key_hash = $1 AND revoked_at = 0`), hash).
+		Scan(&k.ID, &k.Owner, &k.Name, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.CreatedAt = time.Unix(0, created).UTC()
+	return &k, nil
+}
+
+func (s *sqlStore) RevokeAPIKey(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at = 0`), time.Now().UnixNano(), id)
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build cgo
+
+package main
+
+import _ "github.com/mattn/go-sqlite3" // registers the "sqlite3" driver
+
+func init() {
+	sqliteDriver = "sqlite3"
+}
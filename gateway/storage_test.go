@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupTestStore opens a migrated SQLite store in a temp dir. SQLite needs a
+// cgo build, so the test is skipped without one.
+func setupTestStore(t *testing.T) *sqlStore {
+	t.Helper()
+	if sqliteDriver == "" {
+		t.Skip("SQLite needs a cgo build")
+	}
+	s, err := openStore("sqlite:" + filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if _, err := s.migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseDatabaseURL(t *testing.T) {
+	tests := []struct {
+		url, dialect, dsn string
+		ok                bool
+	}{
+		{"postgres://paygate@db:5432/paygate?sslmode=disable", dialectPostgres, "postgres://paygate@db:5432/paygate?sslmode=disable", true},
+		{"postgresql://db/paygate", dialectPostgres, "postgresql://db/paygate", true},
+		{"sqlite:///var/lib/paygate/gateway.db", dialectSQLite, "/var/lib/paygate/gateway.db", true},
+		{"sqlite:gateway.db", dialectSQLite, "gateway.db", true},
+		{"sqlite:", "", "", false},
+		{"mysql://db/paygate", "", "", false},
+	}
+	for _, tt := range tests {
+		dialect, dsn, err := parseDatabaseURL(tt.url)
+		if dialect != tt.dialect || dsn != tt.dsn || (err == nil) != tt.ok {
+			t.Errorf("parseDatabaseURL(%q) = %q, %q, %v", tt.url, dialect, dsn, err)
+		}
+	}
+}
+
+func TestStore_Migrations(t *testing.T) {
+	if sqliteDriver == "" {
+		t.Skip("SQLite needs a cgo build")
+	}
+	url := "sqlite:" + filepath.Join(t.TempDir(), "gateway.db")
+	t.Setenv("DATABASE_URL", url)
+	if _, err := initStore(); err == nil || !strings.Contains(err.Error(), "gateway migrate") {
+		t.Fatalf("Expected pending migrations refused, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if code := runMigrate(nil, &out); code != 0 || !strings.Contains(out.String(), "Applied 0001_initial") {
+		t.Fatalf("Expected the initial migration applied, got %d: %s", code, out.String())
+	}
+	out.Reset()
+	if code := runMigrate(nil, &out); code != 0 || !strings.Contains(out.String(), "up to date") {
+		t.Errorf("Expected nothing left to apply, got %d: %s", code, out.String())
+	}
+	out.Reset()
+	runMigrate([]string{"status"}, &out)
+	if !strings.Contains(out.String(), "0001_initial\tapplied") {
+		t.Errorf("Expected the status listed, got %s", out.String())
+	}
+
+	s, err := initStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+func TestStore_Receipts(t *testing.T) {
+	s := setupTestStore(t)
+	ctx := context.Background()
+	r := &SignedReceipt{Receipt: Receipt{ID: "rcpt_stored", Timestamp: time.Now().UTC()}, Signature: "0xsig"}
+	r.Receipt.Payment.Payer = "0xAA"
+	if err := s.SaveReceipt(ctx, r, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Receipt(ctx, "rcpt_stored"); err != nil || got == nil || got.Signature != "0xsig" {
+		t.Errorf("Expected the receipt back, got %+v, %v", got, err)
+	}
+	s.SaveReceipt(ctx, r, time.Now().Add(-time.Second))
+	if got, _ := s.Receipt(ctx, "rcpt_stored"); got != nil {
+		t.Error("Expected an expired receipt not returned")
+	}
+
+	// A receipt issued before a restart is still found
+	prev := dataStore
+	dataStore = s
+	defer func() { dataStore = prev }()
+	s.SaveReceipt(ctx, r, time.Now().Add(time.Hour))
+	if got, ok := getReceipt("rcpt_stored"); !ok || got.Receipt.ID != "rcpt_stored" {
+		t.Errorf("Expected getReceipt to fall back to the store, got %+v", got)
+	}
+}
+
+func TestStore_Jobs(t *testing.T) {
+	s := setupTestStore(t)
+	ctx := context.Background()
+	id, err := s.EnqueueJob(ctx, "purge", []byte(`{"class":"receipts"}`), time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnqueueJob(ctx, "purge", nil, time.Now().Add(time.Hour))
+
+	job, err := s.ClaimJob(ctx, "purge", time.Minute)
+	if err != nil || job == nil || job.ID != id || string(job.Payload) != `{"class":"receipts"}` || job.Attempts != 1 {
+		t.Fatalf("Expected the due job claimed, got %+v, %v", job, err)
+	}
+	if again, _ := s.ClaimJob(ctx, "purge", time.Minute); again != nil {
+		t.Errorf("Expected a leased job not claimed twice, got %+v", again)
+	}
+
+	s.FailJob(ctx, id, errors.New("boom"), time.Now().Add(-time.Second))
+	if job, _ = s.ClaimJob(ctx, "purge", time.Minute); job == nil || job.Attempts != 2 {
+		t.Fatalf("Expected the failed job retried, got %+v", job)
+	}
+	s.CompleteJob(ctx, id)
+	if job, _ = s.ClaimJob(ctx, "purge", time.Minute); job != nil {
+		t.Errorf("Expected nothing due after completion, got %+v", job)
+	}
+}
+
+func TestStore_APIKeys(t *testing.T) {
+	s := setupTestStore(t)
+	ctx := context.Background()
+	key := APIKey{ID: "key_1", Owner: "team-a", Name: "ci", CreatedAt: time.Now().UTC()}
+	if err := s.CreateAPIKey(ctx, key, apiKeyHash("pk_secret")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.APIKeyByHash(ctx, apiKeyHash("pk_secret")); err != nil || got == nil || got.Owner != "team-a" {
+		t.Errorf("Expected the key found by its secret's hash, got %+v, %v", got, err)
+	}
+	if got, _ := s.APIKeyByHash(ctx, apiKeyHash("pk_other")); got != nil {
+		t.Errorf("Expected an unknown secret not found, got %+v", got)
+	}
+	s.RevokeAPIKey(ctx, "key_1")
+	if got, _ := s.APIKeyByHash(ctx, apiKeyHash("pk_secret")); got != nil {
+		t.Error("Expected a revoked key not found")
+	}
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// With MULTI_TENANT=true one gateway serves several products. A request
+// belongs to the tenant whose hosts include its Host header, or to the one
+// named in its path (/t/<id>/v1/...); it is then paid to the tenant's
+// recipient, priced, rate limited and summarized with the tenant's own
+// settings and provider keys, and cached apart from every other tenant.
+// Requests that belong to no tenant are served with the gateway's settings.
+
+// tenantPathPrefix mounts the /v1 API once more for tenants reached by path.
+const tenantPathPrefix = "/t/:tenant"
+
+// Tenants live in Redis when REDIS_URL is set, so every instance serves the
+// same ones: definitions in the tenantsKey hash, by ID.
+const tenantsKey = "tenants"
+
+// maskedKeyPrefix starts a provider key as the admin API shows it. A key
+// sent back masked keeps the stored one.
+const maskedKeyPrefix = "****"
+
+// tenantIDPattern is the shape of a tenant ID, which appears in paths and
+// cache keys.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// tenantHostPattern is the shape of a hostname a tenant is reached at.
+var tenantHostPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?$`)
+
+// tenantProviderKeys are the providers a tenant can bring its own API key
+// for, and the variable holding the gateway's key.
+var tenantProviderKeys = map[string]string{
+	"openrouter": "OPENROUTER_API_KEY",
+	"openai":     "OPENAI_API_KEY",
+	"anthropic":  "ANTHROPIC_API_KEY",
+	"azure":      "AZURE_OPENAI_API_KEY",
+}
+
+var tenantRequestsTotal = newCounter(
+	"gateway_tenant_requests_total",
+	"Requests that belonged to a tenant, by tenant, with MULTI_TENANT=true.",
+	"tenant",
+)
+
+// Tenant is an admin-managed product served by the gateway. Settings left
+// empty fall back to the gateway's own.
+type Tenant struct {
+	ID               string            `json:"id" example:"search"`
+	Name             string            `json:"name,omitempty" example:"Search team"`
+	Hosts            []string          `json:"hosts,omitempty" doc:"Hostnames whose requests belong to the tenant; each belongs to one tenant at most" example:"search.paygate.example.com"`
+	RecipientAddress string            `json:"recipient_address,omitempty" doc:"Address the tenant's payments are made to, instead of RECIPIENT_ADDRESS" example:"0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219"`
+	PaymentAmount    string            `json:"payment_amount,omitempty" doc:"Replaces PAYMENT_AMOUNT for the tenant's requests" example:"0.002"`
+	PricePer1KTokens string            `json:"price_per_1k_tokens,omitempty" doc:"Replaces PRICE_PER_1K_TOKENS for the tenant's requests" example:"0.0005"`
+	RateLimitRPM     int               `json:"rate_limit_rpm,omitempty" doc:"Requests per minute per client, replacing the rate limit tiers for the tenant's requests; 0 keeps the tiers" example:"120"`
+	RateLimitBurst   int               `json:"rate_limit_burst,omitempty" doc:"Burst allowed on top of rate_limit_rpm; defaults to rate_limit_rpm" example:"20"`
+	CacheTTLSeconds  int               `json:"cache_ttl_seconds,omitempty" doc:"How long the tenant's cached summaries stay fresh, instead of CACHE_TTL_SECONDS" example:"600"`
+	CacheMaxEntries  int               `json:"cache_max_entries,omitempty" doc:"Most cached summaries the tenant may hold; the oldest are evicted beyond it. 0 is unlimited" example:"5000"`
+	ProviderKeys     map[string]string `json:"provider_keys,omitempty" doc:"API keys by provider (openrouter, openai, anthropic, azure) for the tenant's AI calls, instead of the gateway's. Shown masked; a masked key sent back keeps the stored one"`
+}
+
+// TenantsResponse is the body of GET /admin/tenants.
+type TenantsResponse struct {
+	Tenants []Tenant `json:"tenants"`
+}
+
+// TenantDeleteResponse is the body of DELETE /admin/tenants/:id.
+type TenantDeleteResponse struct {
+	Status string `json:"status" example:"deleted"`
+	ID     string `json:"id" example:"search"`
+}
+
+// multiTenantEnabled reports whether MULTI_TENANT=true.
+func multiTenantEnabled() bool {
+	return strings.ToLower(os.Getenv("MULTI_TENANT")) == "true"
+}
+
+// validate checks a definition received through the admin API, normalizing
+// its hosts.
+func (t *Tenant) validate() error {
+	if !tenantIDPattern.MatchString(t.ID) {
+		return errors.New("id must be 1-63 lowercase letters, digits or '-', starting with a letter or digit")
+	}
+	for i, host := range t.Hosts {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if !tenantHostPattern.MatchString(host) {
+			return fmt.Errorf("%q is not a hostname", t.Hosts[i])
+		}
+		t.Hosts[i] = host
+	}
+	slices.Sort(t.Hosts)
+	t.Hosts = slices.Compact(t.Hosts)
+	if t.RecipientAddress != "" && (!strings.HasPrefix(t.RecipientAddress, "0x") || !common.IsHexAddress(t.RecipientAddress)) {
+		return errors.New("recipient_address must be a 0x-prefixed address")
+	}
+	for field, amount := range map[string]string{"payment_amount": t.PaymentAmount, "price_per_1k_tokens": t.PricePer1KTokens} {
+		if amount == "" {
+			continue
+		}
+		if r, ok := parseDecimal(amount); !ok || r.Sign() <= 0 {
+			return fmt.Errorf("%s must be a positive decimal", field)
+		}
+	}
+	switch {
+	case t.RateLimitRPM < 0 || t.RateLimitBurst < 0:
+		return errors.New("rate_limit_rpm and rate_limit_burst must not be negative")
+	case t.RateLimitBurst > 0 && t.RateLimitRPM == 0:
+		return errors.New("rate_limit_burst needs rate_limit_rpm")
+	case t.CacheTTLSeconds < 0 || t.CacheMaxEntries < 0:
+		return errors.New("cache_ttl_seconds and cache_max_entries must not be negative")
+	}
+	for provider, key := range t.ProviderKeys {
+		if _, ok := tenantProviderKeys[provider]; !ok {
+			return fmt.Errorf("provider_keys: unknown provider %q (openrouter, openai, anthropic or azure)", provider)
+		}
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("provider_keys: key for %s is empty", provider)
+		}
+	}
+	return nil
+}
+
+// masked returns t with its provider keys masked, as the admin API shows it.
+func (t Tenant) masked() Tenant {
+	if len(t.ProviderKeys) == 0 {
+		return t
+	}
+	keys := make(map[string]string, len(t.ProviderKeys))
+	for provider, key := range t.ProviderKeys {
+		if len(key) > 12 {
+			keys[provider] = maskedKeyPrefix + key[len(key)-4:]
+		} else {
+			keys[provider] = maskedKeyPrefix
+		}
+	}
+	t.ProviderKeys = keys
+	return t
+}
+
+// tenantStore keeps tenant definitions.
+type tenantStore interface {
+	list(ctx context.Context) ([]Tenant, error)
+	// get returns the tenant, or nil when it isn't defined.
+	get(ctx context.Context, id string) (*Tenant, error)
+	// put creates or replaces a definition.
+	put(ctx context.Context, t Tenant) error
+	// remove deletes a tenant, reporting whether it existed.
+	remove(ctx context.Context, id string) (bool, error)
+}
+
+func currentTenantStore() tenantStore {
+	if redisClient != nil {
+		return redisTenantStore{client: redisClient}
+	}
+	return memoryTenants
+}
+
+type memoryTenantStore struct {
+	mu      sync.Mutex
+	tenants map[string]Tenant
+}
+
+var memoryTenants = &memoryTenantStore{tenants: make(map[string]Tenant)}
+
+func (s *memoryTenantStore) list(context.Context) ([]Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenants := make([]Tenant, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+func (s *memoryTenantStore) get(_ context.Context, id string) (*Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tenants[id]; ok {
+		return &t, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryTenantStore) put(_ context.Context, t Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants[t.ID] = t
+	return nil
+}
+
+func (s *memoryTenantStore) remove(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tenants[id]
+	delete(s.tenants, id)
+	return ok, nil
+}
+
+type redisTenantStore struct {
+	client *redis.Client
+}
+
+func (s redisTenantStore) list(ctx context.Context) ([]Tenant, error) {
+	defs, err := s.client.HGetAll(ctx, tenantsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	tenants := make([]Tenant, 0, len(defs))
+	for id, raw := range defs {
+		var t Tenant
+		if err := json.Unmarshal([]byte(raw), &t); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", id, err)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+func (s redisTenantStore) get(ctx context.Context, id string) (*Tenant, error) {
+	raw, err := s.client.HGet(ctx, tenantsKey, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t Tenant
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s redisTenantStore) put(ctx context.Context, t Tenant) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, tenantsKey, t.ID, data).Err()
+}
+
+func (s redisTenantStore) remove(ctx context.Context, id string) (bool, error) {
+	n, err := s.client.HDel(ctx, tenantsKey, id).Result()
+	return n > 0, err
+}
+
+// tenantDirectory looks tenants up by ID and host for every request, from
+// a snapshot of the store. With Redis the snapshot is reloaded every
+// TENANT_RELOAD_INTERVAL_SECONDS (default 10), so every instance follows
+// changes made through any of them.
+type tenantDirectory struct {
+	mu     sync.RWMutex
+	byID   map[string]*Tenant
+	byHost map[string]*Tenant
+}
+
+// tenantDir is nil unless MULTI_TENANT=true.
+var tenantDir *tenantDirectory
+
+// initTenants loads the tenants when MULTI_TENANT=true. It must run after
+// initRedis so they are read from the shared store.
+func initTenants(ctx context.Context) *tenantDirectory {
+	if !multiTenantEnabled() {
+		return nil
+	}
+	d := &tenantDirectory{}
+	if err := d.reload(ctx); err != nil {
+		log.Printf("Warning: failed to load tenants, starting with none: %v", err)
+	}
+	log.Printf("Multi-tenant mode enabled (%d tenants)", len(d.byID))
+	return d
+}
+
+// reload replaces the snapshot with the store's tenants.
+func (d *tenantDirectory) reload(ctx context.Context) error {
+	tenants, err := currentTenantStore().list(ctx)
+	if err != nil {
+		return err
+	}
+	d.set(tenants)
+	return nil
+}
+
+func (d *tenantDirectory) set(tenants []Tenant) {
+	byID := make(map[string]*Tenant, len(tenants))
+	byHost := make(map[string]*Tenant)
+	for _, t := range tenants {
+		byID[t.ID] = &t
+		for _, host := range t.Hosts {
+			byHost[host] = &t
+		}
+	}
+	d.mu.Lock()
+	d.byID, d.byHost = byID, byHost
+	d.mu.Unlock()
+}
+
+// lookup returns the tenant with id, or nil.
+func (d *tenantDirectory) lookup(id string) *Tenant {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.byID[id]
+}
+
+// forHost returns the tenant reached at host (a Host header, with or
+// without a port), or nil.
+func (d *tenantDirectory) forHost(host string) *Tenant {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.byHost[host]
+}
+
+// start reloads the snapshot in the background while other instances may
+// change the tenants, that is with Redis.
+func (d *tenantDirectory) start(ctx context.Context) {
+	if redisClient == nil {
+		return
+	}
+	interval := time.Duration(getEnvAsInt("TENANT_RELOAD_INTERVAL_SECONDS", 10)) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.reload(ctx); err != nil {
+					log.Printf("Warning: tenant reload failed, keeping the current tenants: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+type tenantContextKey struct{}
+
+// withTenant returns ctx carrying t.
+func withTenant(ctx context.Context, t *Tenant) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// tenantFrom returns the tenant ctx's request belongs to, or nil.
+func tenantFrom(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantContextKey{}).(*Tenant)
+	return t
+}
+
+// TenantMiddleware attaches the request's tenant to its context: the one
+// named by the /t/<id> path, else the one reached at its Host. A path
+// naming no tenant answers 404 tenant_not_found.
+func TenantMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var t *Tenant
+		if id := c.Param("tenant"); id != "" {
+			if t = tenantDir.lookup(id); t == nil {
+				abortWithProblem(c, newProblem(404, codeTenantNotFound, "Not Found", "No such tenant").
+					With("tenant", id))
+				return
+			}
+		} else {
+			t = tenantDir.forHost(c.Request.Host)
+		}
+		if t != nil {
+			tenantRequestsTotal.Inc(t.ID)
+			c.Request = c.Request.WithContext(withTenant(c.Request.Context(), t))
+		}
+		c.Next()
+	}
+}
+
+// tenantID returns the ID of ctx's tenant, or "".
+func tenantID(ctx context.Context) string {
+	if t := tenantFrom(ctx); t != nil {
+		return t.ID
+	}
+	return ""
+}
+
+// recipientFor returns the address ctx's request is paid to.
+func recipientFor(ctx context.Context) string {
+	if t := tenantFrom(ctx); t != nil && t.RecipientAddress != "" {
+		return t.RecipientAddress
+	}
+	return getRecipientAddress()
+}
+
+// recipientNameFor returns the ENS name recipientFor was resolved from, if
+// any; a tenant's own recipient is always a plain address.
+func recipientNameFor(ctx context.Context) string {
+	if t := tenantFrom(ctx); t != nil && t.RecipientAddress != "" {
+		return ""
+	}
+	return getRecipientName()
+}
+
+// paymentAmountFor returns the flat price, or the minimum charge, of ctx's
+// request.
+func paymentAmountFor(ctx context.Context) string {
+	if t := tenantFrom(ctx); t != nil && t.PaymentAmount != "" {
+		return t.PaymentAmount
+	}
+	return getPaymentAmount()
+}
+
+// pricePer1KTokensFor returns the per-token price of ctx's request, or ""
+// for flat pricing.
+func pricePer1KTokensFor(ctx context.Context) string {
+	if t := tenantFrom(ctx); t != nil && t.PricePer1KTokens != "" {
+		return t.PricePer1KTokens
+	}
+	return getPricePer1KTokens()
+}
+
+// providerAPIKey returns the API key for provider on ctx's calls: the
+// tenant's own, or the gateway's.
+func providerAPIKey(ctx context.Context, provider string) string {
+	if t := tenantFrom(ctx); t != nil {
+		if key := t.ProviderKeys[provider]; key != "" {
+			return key
+		}
+	}
+	return os.Getenv(tenantProviderKeys[provider])
+}
+
+// cacheTTLFor returns how long ctx's cached summaries stay fresh: the
+// tenant's cache_ttl_seconds, or CACHE_TTL_SECONDS.
+func cacheTTLFor(ctx context.Context) time.Duration {
+	if t := tenantFrom(ctx); t != nil && t.CacheTTLSeconds > 0 {
+		return time.Duration(t.CacheTTLSeconds) * time.Second
+	}
+	return getCacheTTL()
+}
+
+// rateLimitBurst returns the tenant's burst, which defaults to its rpm.
+func (t *Tenant) rateLimitBurst() int {
+	if t.RateLimitBurst == 0 {
+		return t.RateLimitRPM
+	}
+	return t.RateLimitBurst
+}
+
+// requireMultiTenant answers 503 multi_tenant_disabled unless
+// MULTI_TENANT=true.
+func requireMultiTenant(c *gin.Context) bool {
+	if tenantDir == nil {
+		abortWithProblem(c, newProblem(503, codeMultiTenantDisabled, "Service Unavailable", "Multi-tenant mode is not enabled (MULTI_TENANT)"))
+		return false
+	}
+	return true
+}
+
+// handleListTenants handles GET /admin/tenants.
+func handleListTenants(c *gin.Context) {
+	if !requireMultiTenant(c) {
+		return
+	}
+	tenants, err := currentTenantStore().list(c.Request.Context())
+	if err != nil {
+		log.Printf("error listing tenants: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to list tenants", err.Error()))
+		return
+	}
+	slices.SortFunc(tenants, func(a, b Tenant) int { return strings.Compare(a.ID, b.ID) })
+	for i := range tenants {
+		tenants[i] = tenants[i].masked()
+	}
+	c.JSON(200, TenantsResponse{Tenants: tenants})
+}
+
+// handleGetTenant handles GET /admin/tenants/:id.
+func handleGetTenant(c *gin.Context) {
+	if !requireMultiTenant(c) {
+		return
+	}
+	id := c.Param("id")
+	t, err := currentTenantStore().get(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("error looking up tenant %s: %v", id, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to look up the tenant", err.Error()))
+		return
+	}
+	if t == nil {
+		abortWithProblem(c, newProblem(404, codeTenantNotFound, "Not Found", "No such tenant").With("tenant", id))
+		return
+	}
+	c.JSON(200, t.masked())
+}
+
+// handlePutTenant handles PUT /admin/tenants/:id, creating the tenant or
+// replacing its definition. Provider keys sent back masked keep their
+// stored value.
+func handlePutTenant(c *gin.Context) {
+	if !requireMultiTenant(c) {
+		return
+	}
+	var t Tenant
+	if err := json.NewDecoder(c.Request.Body).Decode(&t); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+	t.ID = c.Param("id")
+	if err := t.validate(); err != nil {
+		abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+		return
+	}
+
+	ctx := c.Request.Context()
+	store := currentTenantStore()
+	tenants, err := store.list(ctx)
+	if err != nil {
+		log.Printf("error listing tenants: %v", err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to save the tenant", err.Error()))
+		return
+	}
+	var existing *Tenant
+	for i, other := range tenants {
+		if other.ID == t.ID {
+			existing = &tenants[i]
+			continue
+		}
+		for _, host := range t.Hosts {
+			if slices.Contains(other.Hosts, host) {
+				abortWithProblem(c, newProblem(409, codeTenantConflict, "Conflict",
+					fmt.Sprintf("Host %s already belongs to tenant %s", host, other.ID)).
+					With("host", host).
+					With("tenant", other.ID))
+				return
+			}
+		}
+	}
+	for provider, key := range t.ProviderKeys {
+		if !strings.HasPrefix(key, maskedKeyPrefix) {
+			continue
+		}
+		if existing == nil || existing.ProviderKeys[provider] == "" {
+			abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body",
+				fmt.Sprintf("provider_keys: %s is masked but no key is stored for it", provider)))
+			return
+		}
+		t.ProviderKeys[provider] = existing.ProviderKeys[provider]
+	}
+
+	if err := store.put(ctx, t); err != nil {
+		log.Printf("error saving tenant %s: %v", t.ID, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to save the tenant", err.Error()))
+		return
+	}
+	if err := tenantDir.reload(ctx); err != nil {
+		log.Printf("Warning: tenant reload failed: %v", err)
+	}
+	providers := make([]string, 0, len(t.ProviderKeys))
+	for provider := range t.ProviderKeys {
+		providers = append(providers, provider)
+	}
+	slices.Sort(providers)
+	auditAdminAction(c, auditTenantUpdated, map[string]string{
+		"tenant": t.ID, "hosts": strings.Join(t.Hosts, ","), "recipient_address": t.RecipientAddress,
+		"payment_amount": t.PaymentAmount, "price_per_1k_tokens": t.PricePer1KTokens,
+		"rate_limit_rpm": strconv.Itoa(t.RateLimitRPM), "provider_keys": strings.Join(providers, ","),
+	})
+	log.Printf("Admin saved tenant %s", t.ID)
+	c.JSON(200, t.masked())
+}
+
+// handleDeleteTenant handles DELETE /admin/tenants/:id. The tenant's hosts
+// and path stop being served as the tenant; its cached summaries age out.
+func handleDeleteTenant(c *gin.Context) {
+	if !requireMultiTenant(c) {
+		return
+	}
+	id := c.Param("id")
+	existed, err := currentTenantStore().remove(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("error deleting tenant %s: %v", id, err)
+		abortWithProblem(c, newProblem(500, codeInternalError, "Failed to delete the tenant", err.Error()))
+		return
+	}
+	if !existed {
+		abortWithProblem(c, newProblem(404, codeTenantNotFound, "Not Found", "No such tenant").With("tenant", id))
+		return
+	}
+	if err := tenantDir.reload(c.Request.Context()); err != nil {
+		log.Printf("Warning: tenant reload failed: %v", err)
+	}
+	auditAdminAction(c, auditTenantDeleted, map[string]string{"tenant": id})
+	log.Printf("Admin deleted tenant %s", id)
+	c.JSON(200, TenantDeleteResponse{Status: "deleted", ID: id})
+}
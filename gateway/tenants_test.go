@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tenantRecipient = "0x1111111111111111111111111111111111111111"
+
+// setupTestTenants enables multi-tenant mode with tenants in the in-memory
+// store.
+func setupTestTenants(t *testing.T, tenants ...Tenant) {
+	t.Helper()
+	t.Setenv("MULTI_TENANT", "true")
+	prevStore, prevDir := memoryTenants, tenantDir
+	memoryTenants = &memoryTenantStore{tenants: make(map[string]Tenant)}
+	for _, tenant := range tenants {
+		memoryTenants.put(t.Context(), tenant)
+	}
+	tenantDir = initTenants(t.Context())
+	t.Cleanup(func() { memoryTenants, tenantDir = prevStore, prevDir })
+}
+
+// setupTenantRouter serves the /v1 API by host and under /t/<id>.
+func setupTenantRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(TenantMiddleware())
+	registerAPIRoutes(r.Group(apiV1Prefix))
+	registerAPIRoutes(r.Group(tenantPathPrefix + apiV1Prefix))
+	return r
+}
+
+func TestTenantMiddleware_ResolvesByHostAndPath(t *testing.T) {
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	setupTestTenants(t, Tenant{ID: "search", Hosts: []string{"search.example.com"}, RecipientAddress: tenantRecipient, PaymentAmount: "0.005"})
+	r := setupTenantRouter()
+
+	challenge := func(host, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tt := range []struct{ host, path string }{
+		{"Search.Example.com:443", "/v1/payment/challenge"},
+		{"other.example.com", "/t/search/v1/payment/challenge"},
+	} {
+		w := challenge(tt.host, tt.path)
+		var resp PaymentChallenge
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != 200 || resp.PaymentContext.Recipient != tenantRecipient || resp.PaymentContext.Amount != "0.005" {
+			t.Errorf("%s%s: expected the tenant's recipient and price, got %d %s", tt.host, tt.path, w.Code, w.Body.String())
+		}
+	}
+
+	w := challenge("other.example.com", "/v1/payment/challenge")
+	var resp PaymentChallenge
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.PaymentContext.Recipient != getRecipientAddress() || resp.PaymentContext.Amount != "0.001" {
+		t.Errorf("Expected the gateway's settings outside any tenant, got %s", w.Body.String())
+	}
+
+	w = challenge("other.example.com", "/t/nope/v1/payment/challenge")
+	if p := decodeProblem(t, w); w.Code != 404 || p["code"] != codeTenantNotFound || p["tenant"] != "nope" {
+		t.Errorf("Expected 404 tenant_not_found, got %d %v", w.Code, p)
+	}
+}
+
+func TestHandleSummarize_Tenant(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Hour)
+	ledger := setupTestLedger(t)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("OPENROUTER_API_KEY", "gateway-key")
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	t.Setenv("PAYMENT_AMOUNT", "0.001")
+	setupTestTenants(t, Tenant{
+		ID: "search", RecipientAddress: tenantRecipient, PaymentAmount: "0.002",
+		ProviderKeys: map[string]string{"openrouter": "search-team-key"},
+	})
+	r := setupTenantRouter()
+
+	send := func(path, nonce string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(`{"text":"a text two tenants both summarize"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("/t/search/v1/ai/summarize", "n-tenant-1")
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ctx := verifier.Requests()[0].Context; ctx.Recipient != tenantRecipient || ctx.Amount != "0.002" {
+		t.Errorf("Expected the payment verified against the tenant's recipient and price, got %+v", ctx)
+	}
+	if key := ai.Requests()[0].APIKey; key != "search-team-key" {
+		t.Errorf("Expected the tenant's OpenRouter key, got %q", key)
+	}
+	entries, _ := ledger.Entries(t.Context(), ledgerQuery{})
+	if len(entries) != 1 || entries[0].Tenant != "search" || !strings.HasPrefix(entries[0].CacheKey, "ai:summary:v4:search:") {
+		t.Errorf("Expected the entry attributed to the tenant, got %+v", entries)
+	}
+
+	// The same text outside the tenant is neither served from its cache
+	// nor summarized with its key
+	w = send("/v1/ai/summarize", "n-tenant-2")
+	if w.Code != 200 || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected a cache miss outside the tenant, got %d %s", w.Code, w.Header().Get("X-Cache"))
+	}
+	if key := ai.Requests()[1].APIKey; key != "gateway-key" {
+		t.Errorf("Expected the gateway's OpenRouter key, got %q", key)
+	}
+	if w := send("/t/search/v1/ai/summarize", "n-tenant-3"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected the tenant's own entry to be hit, got %s", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestRateLimitMiddleware_TenantLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ANONYMOUS_RPM", "60")
+	t.Setenv("RATE_LIMIT_ANONYMOUS_BURST", "5")
+	setupTestTenants(t, Tenant{ID: "tight", Hosts: []string{"tight.example.com"}, RateLimitRPM: 1})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(TenantMiddleware(), RateLimitMiddleware(initRateLimiters()))
+	r.GET("/test", func(c *gin.Context) { c.Status(200) })
+
+	get := func(host string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := get("tight.example.com"); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Fatalf("Expected the tenant's limit, got %d with limit %s", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+	if w := get("tight.example.com"); w.Code != 429 {
+		t.Errorf("Expected the tenant's burst of 1 to be used up, got %d", w.Code)
+	}
+	if w := get("other.example.com"); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "60" {
+		t.Errorf("Expected the anonymous tier outside the tenant, got %d with limit %s", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestAdminTenants(t *testing.T) {
+	setupTestTenants(t)
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+	r := setupAdminRouter()
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("PUT", "/admin/tenants/search", `{"hosts":["Search.Example.com"],"payment_amount":"0.002","provider_keys":{"openrouter":"sk-or-v1-abcdef123456"}}`)
+	var saved Tenant
+	if err := json.Unmarshal(w.Body.Bytes(), &saved); err != nil || w.Code != 200 || saved.Hosts[0] != "search.example.com" || saved.ProviderKeys["openrouter"] != "****3456" {
+		t.Fatalf("Expected the tenant saved with its key masked, got %d: %s", w.Code, w.Body.String())
+	}
+	if tenantDir.forHost("search.example.com") == nil {
+		t.Error("Expected the new host to be served at once")
+	}
+
+	// Sending the masked key back keeps the stored one
+	if w := send("PUT", "/admin/tenants/search", `{"hosts":["search.example.com"],"provider_keys":{"openrouter":"****3456"}}`); w.Code != 200 {
+		t.Fatalf("Expected the update to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+	if key := providerAPIKey(withTenant(context.Background(), tenantDir.lookup("search")), "openrouter"); key != "sk-or-v1-abcdef123456" {
+		t.Errorf("Expected the stored key kept, got %q", key)
+	}
+
+	for _, body := range []string{`{"recipient_address":"0x123"}`, `{"payment_amount":"free"}`, `{"hosts":["https://x"]}`,
+		`{"provider_keys":{"mock":"k"}}`, `{"rate_limit_burst":5}`, `not json`} {
+		if w := send("PUT", "/admin/tenants/other", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+	if w := send("PUT", "/admin/tenants/Bad_ID", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed ID, got %d", w.Code)
+	}
+	w = send("PUT", "/admin/tenants/other", `{"hosts":["search.example.com"]}`)
+	if p := decodeProblem(t, w); w.Code != http.StatusConflict || p["code"] != codeTenantConflict || p["tenant"] != "search" {
+		t.Errorf("Expected 409 tenant_conflict for a taken host, got %d %v", w.Code, p)
+	}
+
+	w = send("GET", "/admin/tenants", "")
+	var list TenantsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Tenants) != 1 || strings.Contains(w.Body.String(), "abcdef") {
+		t.Errorf("Expected the tenant listed without its key, got %s", w.Body.String())
+	}
+
+	if w := send("DELETE", "/admin/tenants/search", ""); w.Code != 200 {
+		t.Errorf("Expected the tenant deleted, got %d", w.Code)
+	}
+	if tenantDir.lookup("search") != nil {
+		t.Error("Expected the deleted tenant to stop being served")
+	}
+	if w := send("GET", "/admin/tenants/search", ""); decodeProblem(t, w)["code"] != codeTenantNotFound {
+		t.Errorf("Expected tenant_not_found, got %d", w.Code)
+	}
+
+	tenantDir = nil
+	if w := send("GET", "/admin/tenants", ""); decodeProblem(t, w)["code"] != codeMultiTenantDisabled {
+		t.Errorf("Expected multi_tenant_disabled without MULTI_TENANT, got %d", w.Code)
+	}
+}
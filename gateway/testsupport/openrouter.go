@@ -0,0 +1,184 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ChatRequest is the part of an OpenRouter chat completion request the
+// gateway sends.
+type ChatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	MaxTokens   *int     `json:"max_tokens"`
+
+	// APIKey is the bearer token the request carried.
+	APIKey string `json:"-"`
+}
+
+// Prompt returns the content of the last message.
+func (r ChatRequest) Prompt() string {
+	if len(r.Messages) == 0 {
+		return ""
+	}
+	return r.Messages[len(r.Messages)-1].Content
+}
+
+// FakeOpenRouter is a programmable OpenRouter API. Point OPENROUTER_URL at
+// CompletionsURL(); OPENROUTER_KEY_URL may point at KeyURL(). By default it
+// replies "fake summary" to every request.
+type FakeOpenRouter struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	reply    func(ChatRequest) (string, error)
+	status   int
+	body     string
+	delay    time.Duration
+	usage    map[string]interface{}
+	requests []ChatRequest
+}
+
+// NewFakeOpenRouter starts a fake OpenRouter that is closed when t ends.
+func NewFakeOpenRouter(t testing.TB) *FakeOpenRouter {
+	p := &FakeOpenRouter{}
+	p.Reply("fake summary")
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// CompletionsURL is the chat completions endpoint, for OPENROUTER_URL.
+func (p *FakeOpenRouter) CompletionsURL() string {
+	return p.URL + "/api/v1/chat/completions"
+}
+
+// KeyURL is the API key endpoint, for OPENROUTER_KEY_URL.
+func (p *FakeOpenRouter) KeyURL() string {
+	return p.URL + "/api/v1/key"
+}
+
+// Reply answers every completion with content.
+func (p *FakeOpenRouter) Reply(content string) {
+	p.ReplyFunc(func(ChatRequest) (string, error) { return content, nil })
+}
+
+// ReplyFunc computes each completion from the request; returning an error
+// answers 500 with the error message.
+func (p *FakeOpenRouter) ReplyFunc(fn func(ChatRequest) (string, error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reply, p.status, p.body = fn, 0, ""
+}
+
+// FailWith answers every completion with status and a raw body.
+func (p *FakeOpenRouter) FailWith(status int, body string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status, p.body = status, body
+}
+
+// Usage adds a usage block with the given token counts and USD cost to
+// every completion.
+func (p *FakeOpenRouter) Usage(promptTokens, completionTokens int, cost float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.usage = map[string]interface{}{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
+		"cost":              cost,
+	}
+}
+
+// Delay makes every response wait d (or until the client gives up), to
+// exercise AI timeouts.
+func (p *FakeOpenRouter) Delay(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delay = d
+}
+
+// Requests returns the completion requests received so far.
+func (p *FakeOpenRouter) Requests() []ChatRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ChatRequest(nil), p.requests...)
+}
+
+// Calls returns how many completion requests were received.
+func (p *FakeOpenRouter) Calls() int {
+	return len(p.Requests())
+}
+
+func (p *FakeOpenRouter) serve(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	reply, status, body, delay, usage := p.reply, p.status, p.body, p.delay, p.usage
+	p.mu.Unlock()
+
+	if r.Method == "GET" && r.URL.Path == "/api/v1/key" {
+		if trimBearer(r.Header.Get("Authorization")) == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		w.Write([]byte(`{"data":{"label":"fake"}}`))
+		return
+	}
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.APIKey = trimBearer(r.Header.Get("Authorization"))
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+
+	if !wait(r, delay) {
+		return
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+		return
+	}
+
+	content, err := reply(req)
+	if err != nil {
+		body, _ := json.Marshal(map[string]interface{}{"error": map[string]string{"message": err.Error()}})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(body)
+		return
+	}
+	completion := ChatCompletion(content)
+	if usage != nil {
+		completion["usage"] = usage
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completion)
+}
+
+// ChatCompletion builds an OpenRouter completion body with content as the
+// only choice, for tests that write their own handlers.
+func ChatCompletion(content string) map[string]interface{} {
+	return map[string]interface{}{
+		"choices": []map[string]interface{}{
+			{"message": map[string]string{"role": "assistant", "content": content}},
+		},
+	}
+}
+
+// trimBearer returns the token from an Authorization header.
+func trimBearer(header string) string {
+	return strings.TrimPrefix(header, "Bearer ")
+}
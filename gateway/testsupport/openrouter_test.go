@@ -0,0 +1,78 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postChat(t *testing.T, url, prompt string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url, strings.NewReader(`{"model":"m","messages":[{"role":"user","content":"`+prompt+`"}]}`))
+	req.Header.Set("Authorization", "Bearer key-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func content(body map[string]interface{}) string {
+	choices, _ := body["choices"].([]interface{})
+	if len(choices) == 0 {
+		return ""
+	}
+	return choices[0].(map[string]interface{})["message"].(map[string]interface{})["content"].(string)
+}
+
+func TestFakeOpenRouter_Replies(t *testing.T) {
+	p := NewFakeOpenRouter(t)
+
+	if _, body := postChat(t, p.CompletionsURL(), "hi"); content(body) != "fake summary" {
+		t.Errorf("Expected default reply, got %v", body)
+	}
+
+	p.ReplyFunc(func(req ChatRequest) (string, error) {
+		if req.Prompt() == "boom" {
+			return "", errors.New("provider exploded")
+		}
+		return "echo: " + req.Prompt(), nil
+	})
+	if _, body := postChat(t, p.CompletionsURL(), "hello"); content(body) != "echo: hello" {
+		t.Errorf("Expected echo reply, got %v", body)
+	}
+	if status, _ := postChat(t, p.CompletionsURL(), "boom"); status != 500 {
+		t.Errorf("Expected 500 from a failing reply func, got %d", status)
+	}
+
+	p.FailWith(429, `{"error":{"message":"rate limited"}}`)
+	if status, _ := postChat(t, p.CompletionsURL(), "x"); status != 429 {
+		t.Errorf("Expected 429, got %d", status)
+	}
+
+	reqs := p.Requests()
+	if p.Calls() != 4 || reqs[0].Model != "m" || reqs[0].APIKey != "key-1" {
+		t.Errorf("Unexpected recorded requests %+v", reqs)
+	}
+}
+
+func TestFakeOpenRouter_DelayAndKeyEndpoint(t *testing.T) {
+	p := NewFakeOpenRouter(t)
+
+	resp, _ := http.Get(p.KeyURL())
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected 401 without a key, got %d", resp.StatusCode)
+	}
+
+	p.Delay(time.Second)
+	hc := &http.Client{Timeout: 50 * time.Millisecond}
+	if _, err := hc.Post(p.CompletionsURL(), "application/json", strings.NewReader(`{}`)); err == nil {
+		t.Error("Expected the delayed response to time out")
+	}
+}
@@ -0,0 +1,197 @@
+// Package testsupport provides httptest-based stand-ins for the services the
+// gateway depends on: the signature verifier and the OpenRouter chat
+// completions API. Tests point VERIFIER_URL and OPENROUTER_URL at them
+// instead of running the Rust verifier or calling the network.
+package testsupport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/client"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// VerifyRequest and VerifyResponse mirror the verifier's /verify API.
+type VerifyRequest struct {
+	Context   client.PaymentContext `json:"context"`
+	Signature string                `json:"signature"`
+}
+
+type VerifyResponse struct {
+	IsValid          bool   `json:"is_valid"`
+	RecoveredAddress string `json:"recovered_address"`
+	Error            string `json:"error"`
+}
+
+// FakeVerifier is a programmable verifier. By default it accepts every
+// signature as coming from DefaultPayer.
+type FakeVerifier struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	respond  func(VerifyRequest) (int, VerifyResponse)
+	delay    time.Duration
+	healthy  bool
+	requests []VerifyRequest
+	headers  []http.Header
+}
+
+// DefaultPayer is the address FakeVerifier reports for accepted signatures.
+const DefaultPayer = "0x00000000000000000000000000000000000000aB"
+
+// NewFakeVerifier starts a fake verifier that is closed when t ends.
+func NewFakeVerifier(t testing.TB) *FakeVerifier {
+	v := &FakeVerifier{healthy: true}
+	v.AcceptAll(DefaultPayer)
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	t.Cleanup(v.Close)
+	return v
+}
+
+// AcceptAll reports every signature as valid, recovered to payer.
+func (v *FakeVerifier) AcceptAll(payer string) {
+	v.setRespond(func(VerifyRequest) (int, VerifyResponse) {
+		return http.StatusOK, VerifyResponse{IsValid: true, RecoveredAddress: payer}
+	})
+}
+
+// RejectAll reports every signature as invalid with reason.
+func (v *FakeVerifier) RejectAll(reason string) {
+	v.setRespond(func(VerifyRequest) (int, VerifyResponse) {
+		return http.StatusOK, VerifyResponse{Error: reason}
+	})
+}
+
+// VerifySignatures checks signatures for real, recovering the EIP-712 signer
+// like the Rust verifier does.
+func (v *FakeVerifier) VerifySignatures() {
+	v.setRespond(func(req VerifyRequest) (int, VerifyResponse) {
+		digest, err := client.PaymentDigest(req.Context)
+		if err != nil {
+			return http.StatusBadRequest, VerifyResponse{Error: err.Error()}
+		}
+		sig, err := hexutil.Decode(req.Signature)
+		if err != nil || len(sig) != 65 {
+			return http.StatusBadRequest, VerifyResponse{Error: "invalid signature format"}
+		}
+		sig = append([]byte(nil), sig...)
+		if sig[64] >= 27 {
+			sig[64] -= 27
+		}
+		pub, err := crypto.SigToPub(digest, sig)
+		if err != nil {
+			return http.StatusOK, VerifyResponse{Error: err.Error()}
+		}
+		return http.StatusOK, VerifyResponse{IsValid: true, RecoveredAddress: crypto.PubkeyToAddress(*pub).Hex()}
+	})
+}
+
+// FailWith answers /verify with status and an empty body, as a crashed or
+// misconfigured verifier would.
+func (v *FakeVerifier) FailWith(status int) {
+	v.setRespond(func(VerifyRequest) (int, VerifyResponse) { return status, VerifyResponse{} })
+}
+
+// RespondWith installs a custom responder.
+func (v *FakeVerifier) RespondWith(fn func(VerifyRequest) (int, VerifyResponse)) {
+	v.setRespond(fn)
+}
+
+// Delay makes every response wait d (or until the client gives up), to
+// exercise verifier timeouts.
+func (v *FakeVerifier) Delay(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.delay = d
+}
+
+// SetHealthy controls whether /health returns 200 or 503.
+func (v *FakeVerifier) SetHealthy(healthy bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.healthy = healthy
+}
+
+// Requests returns the /verify requests received so far.
+func (v *FakeVerifier) Requests() []VerifyRequest {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]VerifyRequest(nil), v.requests...)
+}
+
+// RequestHeaders returns the headers of the /verify requests received so
+// far.
+func (v *FakeVerifier) RequestHeaders() []http.Header {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]http.Header(nil), v.headers...)
+}
+
+// Calls returns how many /verify requests were received.
+func (v *FakeVerifier) Calls() int {
+	return len(v.Requests())
+}
+
+func (v *FakeVerifier) setRespond(fn func(VerifyRequest) (int, VerifyResponse)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.respond = fn
+}
+
+func (v *FakeVerifier) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	respond, delay, healthy := v.respond, v.delay, v.healthy
+	v.mu.Unlock()
+
+	if !wait(r, delay) {
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/health":
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	case r.Method == "POST" && r.URL.Path == "/verify":
+		var req VerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v.mu.Lock()
+		v.requests = append(v.requests, req)
+		v.headers = append(v.headers, r.Header.Clone())
+		v.mu.Unlock()
+
+		status, resp := respond(req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK || status == http.StatusBadRequest {
+			json.NewEncoder(w).Encode(resp)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// wait sleeps for d unless the request is cancelled first, reporting whether
+// the handler should still answer.
+func wait(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
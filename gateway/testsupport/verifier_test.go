@@ -0,0 +1,84 @@
+package testsupport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gateway/client"
+)
+
+func postVerify(t *testing.T, url string, req VerifyRequest) (int, VerifyResponse) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(url+"/verify", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out VerifyResponse
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+func TestFakeVerifier_Modes(t *testing.T) {
+	v := NewFakeVerifier(t)
+	req := VerifyRequest{Signature: "0xsig"}
+
+	if _, resp := postVerify(t, v.URL, req); !resp.IsValid || resp.RecoveredAddress != DefaultPayer {
+		t.Errorf("Expected default accept, got %+v", resp)
+	}
+
+	v.RejectAll("bad signature")
+	if _, resp := postVerify(t, v.URL, req); resp.IsValid || resp.Error != "bad signature" {
+		t.Errorf("Expected reject, got %+v", resp)
+	}
+
+	v.FailWith(http.StatusBadGateway)
+	if status, _ := postVerify(t, v.URL, req); status != http.StatusBadGateway {
+		t.Errorf("Expected 502, got %d", status)
+	}
+
+	if v.Calls() != 3 || v.Requests()[0].Signature != "0xsig" {
+		t.Errorf("Expected 3 recorded requests, got %+v", v.Requests())
+	}
+}
+
+func TestFakeVerifier_VerifySignatures(t *testing.T) {
+	v := NewFakeVerifier(t)
+	v.VerifySignatures()
+
+	signer, _ := client.NewPrivateKeySigner("380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc")
+	payment := client.PaymentContext{Recipient: "0x2cAF48b4BA1C58721a85dFADa5aC01C2DFa62219", Token: "USDC", Amount: "0.001", Nonce: "n", ChainID: 8453}
+	sig, _ := signer.SignPayment(context.Background(), payment)
+
+	if _, resp := postVerify(t, v.URL, VerifyRequest{Context: payment, Signature: sig}); !resp.IsValid || resp.RecoveredAddress != signer.Address() {
+		t.Errorf("Expected signature to recover to %s, got %+v", signer.Address(), resp)
+	}
+	if status, _ := postVerify(t, v.URL, VerifyRequest{Context: payment, Signature: "0x1234"}); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed signature, got %d", status)
+	}
+}
+
+func TestFakeVerifier_HealthAndDelay(t *testing.T) {
+	v := NewFakeVerifier(t)
+
+	resp, _ := http.Get(v.URL + "/health")
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected healthy, got %d", resp.StatusCode)
+	}
+	v.SetHealthy(false)
+	resp, _ = http.Get(v.URL + "/health")
+	if resp.StatusCode != 503 {
+		t.Errorf("Expected unhealthy, got %d", resp.StatusCode)
+	}
+
+	v.Delay(time.Second)
+	hc := &http.Client{Timeout: 50 * time.Millisecond}
+	if _, err := hc.Get(v.URL + "/health"); err == nil {
+		t.Error("Expected the delayed response to time out")
+	}
+}
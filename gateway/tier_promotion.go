@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signedPayerKey caches the wallet recovered from a request's signature on
+// the gin context; the tier is selected by the rate limiter and again for
+// the verifier's headers.
+const signedPayerKey = "signed_payer"
+
+// maxWalletTiers bounds the tier cache. Entries are only added for wallets
+// whose payment the verifier accepted, and expired ones are dropped first.
+const maxWalletTiers = 100000
+
+var tierPromotionsTotal = newCounter(
+	"gateway_tier_promotions_total",
+	"Wallets whose settled payments reached TIER_PROMOTION_PAYMENTS when their tier was refreshed from the ledger.",
+)
+
+// tierPromotionPayments returns TIER_PROMOTION_PAYMENTS, the settled
+// payments that promote a wallet to the verified tier; 0 (default) turns
+// promotion off.
+func tierPromotionPayments() int {
+	return getEnvAsInt("TIER_PROMOTION_PAYMENTS", 0)
+}
+
+// tierDemotionAbuseScore returns TIER_DEMOTION_ABUSE_SCORE (default 1), the
+// abuse score at which a promoted wallet drops back to the standard tier.
+func tierDemotionAbuseScore() int {
+	return getEnvAsInt("TIER_DEMOTION_ABUSE_SCORE", 1)
+}
+
+// signedPayer returns the wallet that signed the payment context declared
+// in X-402-Payment, or "" when the request doesn't declare one or the
+// signature doesn't recover. It runs before the verifier, so the address
+// is only trusted as far as its signature: a forged context recovers an
+// unrelated address with no payment history.
+func signedPayer(c *gin.Context) string {
+	if v, ok := c.Get(signedPayerKey); ok {
+		return v.(string)
+	}
+	var payer string
+	if declared, err := readDeclaredPayment(c); err == nil && declared != nil {
+		digest, err := client.PaymentDigest(client.PaymentContext{
+			Recipient: declared.Recipient, Token: declared.Token, Amount: declared.Amount,
+			Nonce: declared.Nonce, ChainID: declared.ChainID, BodyHash: declared.BodyHash,
+		})
+		if err == nil && declared.Nonce == c.GetHeader("X-402-Nonce") {
+			payer, _ = recoverSigner(digest, c.GetHeader("X-402-Signature"))
+		}
+	}
+	c.Set(signedPayerKey, payer)
+	return payer
+}
+
+type walletTierEntry struct {
+	payments int
+	refunds  int
+	expires  time.Time
+	loading  bool
+}
+
+// walletTierCache holds each paying wallet's count of settled and refunded
+// payments, read from the ledger at most every TIER_CACHE_TTL_SECONDS
+// (default 300). Abuse scores are checked on every lookup instead, so a flagged wallet
+// is demoted at once.
+type walletTierCache struct {
+	mu      sync.Mutex
+	entries map[string]*walletTierEntry
+}
+
+var walletTiers = newWalletTierCache()
+
+func newWalletTierCache() *walletTierCache {
+	return &walletTierCache{entries: make(map[string]*walletTierEntry)}
+}
+
+// tier returns "verified" for a wallet with enough settled payments and an
+// abuse score under the demotion threshold, and "standard" otherwise,
+// including for wallets not cached yet.
+func (w *walletTierCache) tier(wallet string) string {
+	threshold := tierPromotionPayments()
+	if threshold <= 0 || wallet == "" {
+		return "standard"
+	}
+	wallet = strings.ToLower(wallet)
+	payments, _ := w.history(wallet)
+	if payments < threshold || abuseScores.score(wallet) >= tierDemotionAbuseScore() {
+		return "standard"
+	}
+	return "verified"
+}
+
+// history returns wallet's cached counts of settled and refunded payments,
+// zero when it isn't cached yet.
+func (w *walletTierCache) history(wallet string) (payments, refunds int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e, ok := w.entries[strings.ToLower(wallet)]; ok {
+		return e.payments, e.refunds
+	}
+	return 0, 0
+}
+
+// wallets returns the cached wallets.
+func (w *walletTierCache) wallets() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Collect(maps.Keys(w.entries))
+}
+
+// observe refreshes wallet's entry in the background when it is missing or
+// stale. It is called once the verifier has accepted the wallet's
+// signature, so the ledger is only read for wallets that really pay. The
+// counts feed both promotion and the wallet's reputation.
+func (w *walletTierCache) observe(ctx context.Context, wallet string) {
+	if usageLedger == nil || wallet == "" {
+		return
+	}
+	wallet = strings.ToLower(wallet)
+	now := time.Now()
+	w.mu.Lock()
+	e, ok := w.entries[wallet]
+	if ok && (e.loading || now.Before(e.expires)) {
+		w.mu.Unlock()
+		return
+	}
+	if !ok {
+		if len(w.entries) >= maxWalletTiers {
+			w.evictExpiredLocked(now)
+		}
+		if len(w.entries) >= maxWalletTiers {
+			w.mu.Unlock()
+			return
+		}
+		e = &walletTierEntry{}
+		w.entries[wallet] = e
+	}
+	e.loading = true
+	w.mu.Unlock()
+
+	go func() {
+		if err := w.refresh(context.WithoutCancel(ctx), wallet); err != nil {
+			log.Printf("error reading the ledger for the tier of %s: %v", wallet, err)
+		}
+	}()
+}
+
+// refresh counts wallet's settled and refunded payments in the ledger. On
+// error the previous counts are kept and the next observation retries.
+func (w *walletTierCache) refresh(ctx context.Context, wallet string) error {
+	wallet = strings.ToLower(wallet)
+	entries, err := usageLedger.Entries(ctx, ledgerQuery{Wallet: wallet})
+	payments, refunds := settledPayments(entries)
+	ttl := time.Duration(getEnvAsInt("TIER_CACHE_TTL_SECONDS", 300)) * time.Second
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e, ok := w.entries[wallet]
+	if !ok {
+		e = &walletTierEntry{}
+		w.entries[wallet] = e
+	}
+	e.loading = false
+	if err != nil {
+		return err
+	}
+	if threshold := tierPromotionPayments(); threshold > 0 && e.payments < threshold && payments >= threshold {
+		tierPromotionsTotal.Inc()
+		log.Printf("Wallet %s promoted to the verified tier after %d settled payments", wallet, payments)
+	}
+	e.payments, e.refunds, e.expires = payments, refunds, time.Now().Add(ttl)
+	return nil
+}
+
+func (w *walletTierCache) evictExpiredLocked(now time.Time) {
+	for wallet, e := range w.entries {
+		if !e.loading && now.After(e.expires) {
+			delete(w.entries, wallet)
+		}
+	}
+}
+
+// settledPayments counts the paid requests in entries that were neither
+// refunded nor failed to settle on-chain, and those that were refunded.
+// Entries are oldest first, so the last settlement state recorded for a
+// payment is the current one.
+func settledPayments(entries []LedgerEntry) (settled, refunded int) {
+	type payment struct{ paid, refunded, failed bool }
+	byNonce := make(map[string]*payment)
+	for _, e := range entries {
+		p, ok := byNonce[e.Nonce]
+		if !ok {
+			p = &payment{}
+			byNonce[e.Nonce] = p
+		}
+		switch e.Type {
+		case ledgerTypeRefund:
+			p.refunded = true
+		case ledgerTypeSettlement:
+			p.failed = e.SettlementState == txFailed
+		default:
+			p.paid = true
+		}
+	}
+	for _, p := range byNonce {
+		switch {
+		case p.paid && p.refunded:
+			refunded++
+		case p.paid && !p.failed:
+			settled++
+		}
+	}
+	return settled, refunded
+}
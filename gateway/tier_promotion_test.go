@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gateway/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testWalletKey is a throwaway key for tests that need a real signature.
+const testWalletKey = "380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc"
+
+// signTestPayment signs payment with key, returning the wallet, the
+// signature and the X-402-Payment header declaring the payment.
+func signTestPayment(t *testing.T, key string, payment PaymentContext) (wallet, signature, header string) {
+	t.Helper()
+	signer, err := client.NewPrivateKeySigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err = signer.SignPayment(context.Background(), client.PaymentContext{
+		Recipient: payment.Recipient, Token: payment.Token, Amount: payment.Amount,
+		Nonce: payment.Nonce, ChainID: payment.ChainID, BodyHash: payment.BodyHash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(payment)
+	return signer.Address(), signature, base64.StdEncoding.EncodeToString(data)
+}
+
+func TestSettledPayments(t *testing.T) {
+	entries := []LedgerEntry{
+		{Nonce: "a"}, {Nonce: "b"}, {Nonce: "c"}, {Nonce: "d"},
+		{Nonce: "b", Type: ledgerTypeRefund},
+		{Nonce: "c", Type: ledgerTypeSettlement, SettlementState: txFailed},
+		{Nonce: "d", Type: ledgerTypeSettlement, SettlementState: txFailed},
+		{Nonce: "d", Type: ledgerTypeSettlement, SettlementState: txConfirmed},
+		{Nonce: "e", Type: ledgerTypeSettlement, SettlementState: txConfirmed},
+	}
+	if settled, refunded := settledPayments(entries); settled != 2 || refunded != 1 {
+		t.Errorf("Expected 2 payments neither refunded nor failed (a, d) and 1 refunded (b), got %d and %d", settled, refunded)
+	}
+}
+
+func TestRateLimitMiddleware_PromotedWallet(t *testing.T) {
+	ledger := setupTestLedger(t)
+	prevTiers, prevAbuse := walletTiers, abuseScores
+	walletTiers, abuseScores = newWalletTierCache(), newAbuseTracker()
+	t.Cleanup(func() { walletTiers, abuseScores = prevTiers, prevAbuse })
+	t.Setenv("TIER_PROMOTION_PAYMENTS", "2")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RateLimitMiddleware(initRateLimiters()))
+	r.GET("/test", func(c *gin.Context) { c.Status(200) })
+
+	payment := PaymentContext{Recipient: defaultRecipientAddress, Token: "USDC", Amount: "0.001", Nonce: "n-tier", ChainID: 8453}
+	wallet, signature, declared := signTestPayment(t, testWalletKey, payment)
+	limit := func(signature string) string {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-402-Signature", signature)
+		req.Header.Set("X-402-Nonce", payment.Nonce)
+		req.Header.Set(paymentHeader, declared)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header().Get("X-RateLimit-Limit")
+	}
+
+	ledger.Append(context.Background(), LedgerEntry{Wallet: wallet, Nonce: "n1", Time: time.Now()})
+	walletTiers.refresh(context.Background(), wallet)
+	if got := limit(signature); got != "60" {
+		t.Errorf("Expected the standard tier below the threshold, got limit %s", got)
+	}
+
+	ledger.Append(context.Background(), LedgerEntry{Wallet: wallet, Nonce: "n2", Time: time.Now()})
+	walletTiers.refresh(context.Background(), wallet)
+	if got := limit(signature); got != "120" {
+		t.Errorf("Expected the verified tier after 2 settled payments, got limit %s", got)
+	}
+	forged := []byte(signature)
+	forged[10] ^= 1
+	if got := limit(string(forged)); got != "60" {
+		t.Errorf("Expected another signature not to borrow the wallet's tier, got limit %s", got)
+	}
+
+	abuseScores.record(wallet, []string{"ignore_instructions"})
+	if got := limit(signature); got != "60" {
+		t.Errorf("Expected the flagged wallet demoted, got limit %s", got)
+	}
+}
@@ -40,7 +40,7 @@ func TestRequestTimeoutMiddleware_AbortsOnTimeout(t *testing.T) {
 	}
 }
 
-func TestCallOpenRouter_RespectsContextTimeout(t *testing.T) {
+func TestCallAI_RespectsContextTimeout(t *testing.T) {
 	// Mock server that responds slowly
 	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(2 * time.Second)
@@ -55,9 +55,9 @@ func TestCallOpenRouter_RespectsContextTimeout(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
-	_, err := callOpenRouter(ctx, "hello")
+	_, err := callAI(ctx, "", "hello", summaryOptions{})
 	if err == nil {
-		t.Fatalf("Expected timeout error from callOpenRouter, got nil")
+		t.Fatalf("Expected timeout error from callAI, got nil")
 	}
 
 	if !errors.Is(err, context.DeadlineExceeded) {
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certReloader serves a certificate and key from files and reloads them
+// when either file changes, so renewed certificates (certbot,
+// cert-manager) are picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is the tls.Config hook returning the current certificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate is the tls.Config hook for client certificates.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.GetCertificate(nil)
+}
+
+// reloadIfChanged loads the pair when either file is newer than the loaded
+// one, reporting whether it did. On error the previous certificate stays.
+func (r *certReloader) reloadIfChanged() (bool, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && !latest.After(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, latest
+	r.mu.Unlock()
+	return true, nil
+}
+
+// watch checks the files every interval until ctx is done.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if reloaded, err := r.reloadIfChanged(); err != nil {
+				log.Printf("Warning: TLS certificate reload failed, keeping the current one: %v", err)
+			} else if reloaded {
+				log.Printf("Reloaded TLS certificate from %s", r.certFile)
+			}
+		}
+	}
+}
+
+// setupTLS returns the TLS configuration for the TCP listeners, or nil to
+// serve plain HTTP. Settings:
+//
+//   - TLS_CERT_FILE, TLS_KEY_FILE: PEM certificate chain and key, reloaded
+//     when they change (checked every TLS_RELOAD_INTERVAL_SECONDS, default 30)
+//   - TLS_AUTOCERT_DOMAINS: obtain certificates for these domains from
+//     Let's Encrypt instead, cached in TLS_AUTOCERT_CACHE_DIR (default
+//     autocert-cache), with TLS_AUTOCERT_EMAIL as the ACME contact. The
+//     HTTP-01 challenge is answered on TLS_AUTOCERT_HTTP_ADDR (default :80),
+//     which redirects everything else to HTTPS; the returned server must be
+//     shut down by the caller
+//
+// LoadConfig has already validated the settings.
+func setupTLS(ctx context.Context) (*tls.Config, *http.Server, error) {
+	if domains := splitList(os.Getenv("TLS_AUTOCERT_DOMAINS")); len(domains) > 0 {
+		cacheDir := os.Getenv("TLS_AUTOCERT_CACHE_DIR")
+		if cacheDir == "" {
+			cacheDir = "autocert-cache"
+		}
+		addr := os.Getenv("TLS_AUTOCERT_HTTP_ADDR")
+		if addr == "" {
+			addr = ":80"
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      os.Getenv("TLS_AUTOCERT_EMAIL"),
+		}
+		challenge := &http.Server{Addr: addr, Handler: m.HTTPHandler(nil), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			log.Printf("ACME HTTP-01 challenges and HTTPS redirects on %s", addr)
+			if err := challenge.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("ACME challenge server error: %v", err)
+			}
+		}()
+		log.Printf("TLS certificates from Let's Encrypt for %v", domains)
+		cfg := m.TLSConfig()
+		cfg.MinVersion = tls.VersionTLS12
+		if !http2Enabled() {
+			cfg.NextProtos = slices.DeleteFunc(cfg.NextProtos, func(p string) bool { return p == "h2" })
+		}
+		return cfg, challenge, nil
+	}
+
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" {
+		return nil, nil, nil
+	}
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("TLS certificate: %w", err)
+	}
+	go reloader.watch(ctx, time.Duration(getEnvAsInt("TLS_RELOAD_INTERVAL_SECONDS", 30))*time.Second)
+	log.Printf("TLS enabled with %s (reloaded on change)", certFile)
+	cfg := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"},
+	}
+	if http2Enabled() {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return cfg, nil, nil
+}
+
+// wrapTLS serves TLS on the TCP listeners; Unix sockets stay plain since
+// they never leave the host.
+func wrapTLS(cfg *tls.Config, addrs []listenAddr, listeners []net.Listener) {
+	for i, l := range listeners {
+		if addrs[i].network == "tcp" {
+			listeners[i] = tls.NewListener(l, cfg)
+		}
+	}
+}
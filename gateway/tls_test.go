@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 named cn to
+// cert.pem and key.pem in dir, returning the paths.
+func writeTestCert(t *testing.T, dir, cn string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestCertReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := r.reloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Expected no reload for unchanged files, got %v %v", reloaded, err)
+	}
+
+	writeTestCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if reloaded, err := r.reloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("Expected a reload, got %v %v", reloaded, err)
+	}
+	cert, _ := r.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "second" {
+		t.Errorf("Expected the new certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	later := future.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	if _, err := r.reloadIfChanged(); err == nil {
+		t.Error("Expected a broken key to fail the reload")
+	}
+	if cert2, _ := r.GetCertificate(nil); cert2 != cert {
+		t.Error("Expected the previous certificate to stay after a failed reload")
+	}
+}
+
+func TestSetupTLS_ServesHTTPS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "gateway")
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg, acmeSrv, err := setupTLS(ctx)
+	if err != nil || cfg == nil || acmeSrv != nil {
+		t.Fatalf("Expected a file-based TLS config, got %v %v %v", cfg, acmeSrv, err)
+	}
+	addrs := []listenAddr{{network: "tcp", address: "127.0.0.1:0"}}
+	listeners, err := openListeners(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapTLS(cfg, addrs, listeners)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Proto)) })}
+	serveListeners(srv, addrs, listeners, make(chan error, 1))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pemBytes, _ := os.ReadFile(certFile)
+	pool.AppendCertsFromPEM(pemBytes)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}}
+	resp, err := client.Get("https://" + listeners[0].Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Expected an HTTPS response, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2 over TLS, got %s", resp.Proto)
+	}
+}
+
+func TestLoadConfig_TLSSettings(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("TLS_CERT_FILE", "/nonexistent/cert.pem")
+	t.Setenv("TLS_KEY_FILE", "")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "must be set together") {
+		t.Errorf("Expected a pairing problem, got %v", err)
+	}
+	t.Setenv("TLS_KEY_FILE", "/nonexistent/key.pem")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "TLS_CERT_FILE:") {
+		t.Errorf("Expected an unreadable certificate problem, got %v", err)
+	}
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "gateway")
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	t.Setenv("TLS_AUTOCERT_DOMAINS", "paygate.example.com")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "TLS_AUTOCERT_DOMAINS") {
+		t.Errorf("Expected autocert and files to conflict, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// countTokens approximates how many tokens a cl100k-style BPE tokenizer
+// (used by the GPT-4 family and close to most OpenRouter models) produces
+// for text. It follows the same pre-tokenization: a word with its leading
+// space, digit groups of up to three, runs of punctuation and line breaks
+// each start a token. Long words are split as BPE would split rare words,
+// and CJK characters count one token each. The result is within a few
+// percent for English prose, which is enough for limits and pricing without
+// shipping merge tables.
+func countTokens(text string) int {
+	tokens := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case isCJK(r):
+			tokens++
+			i += size
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			n := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if isCJK(r) || !(unicode.IsLetter(r) || unicode.IsMark(r)) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += wordTokens(n)
+		case unicode.IsDigit(r):
+			n := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsDigit(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += (n + 2) / 3
+		case r == '\n' || r == '\r':
+			// A run of line breaks is a single token.
+			for i < len(text) && (text[i] == '\n' || text[i] == '\r') {
+				i++
+			}
+			tokens++
+		case unicode.IsSpace(r):
+			// A single space is merged into the following word; longer runs
+			// of indentation cost a token.
+			n := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if r == '\n' || r == '\r' || !unicode.IsSpace(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			if n > 1 {
+				tokens++
+			}
+		default:
+			// Punctuation and symbols: common runs like "..." or "--" merge,
+			// so count one token per two characters.
+			n := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || isCJK(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += (n + 1) / 2
+		}
+	}
+	return tokens
+}
+
+// wordTokens estimates the tokens in a word of n letters: common words up
+// to six letters are a single token and longer ones split into pieces of
+// about four letters.
+func wordTokens(n int) int {
+	if n <= 6 {
+		return 1
+	}
+	return 1 + (n-3)/4
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
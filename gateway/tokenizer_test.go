@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"Hello world", 2},
+		{"Hello, world!", 4},
+		{"The quick brown fox jumps over the lazy dog.", 10},
+		{"internationalization", 5},
+		{"1234567", 3},
+		{"line one\n\nline two", 5},
+		{"你好世界", 4},
+	}
+	for _, tt := range tests {
+		if got := countTokens(tt.text); got != tt.want {
+			t.Errorf("countTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCountTokens_ScalesWithLength(t *testing.T) {
+	sentence := "Artificial intelligence is transforming how software is built and shipped. "
+	one := countTokens(sentence)
+	if got := countTokens(strings.Repeat(sentence, 100)); got != 100*one {
+		t.Errorf("Expected %d tokens for 100 sentences, got %d", 100*one, got)
+	}
+}
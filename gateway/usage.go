@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+)
+
+var aiTokensTotal = newCounter(
+	"gateway_ai_tokens_total",
+	"Tokens consumed by upstream AI calls as reported by the provider, by provider and type (prompt, completion).",
+	"provider", "type",
+)
+
+var aiCostUSDTotal = newCounter(
+	"gateway_ai_cost_usd_total",
+	"Upstream AI cost in USD as reported by the provider (OpenRouter only).",
+	"provider",
+)
+
+var chargedUSDCTotal = newCounter(
+	"gateway_charged_usdc_total",
+	"USDC charged for paid summarize requests, by the provider that served them (cache for cache hits).",
+	"provider",
+)
+
+// aiUsage accumulates what the upstream calls for one request consumed.
+type aiUsage struct {
+	calls            int
+	promptTokens     int
+	completionTokens int
+	cost             float64
+	costReported     bool
+}
+
+// add returns u plus o.
+func (u aiUsage) add(o aiUsage) aiUsage {
+	return aiUsage{
+		calls:            u.calls + o.calls,
+		promptTokens:     u.promptTokens + o.promptTokens,
+		completionTokens: u.completionTokens + o.completionTokens,
+		cost:             u.cost + o.cost,
+		costReported:     u.costReported || o.costReported,
+	}
+}
+
+// details returns u for the receipt, or nil when no usage was reported.
+func (u aiUsage) details() *UsageDetails {
+	if u.calls == 0 {
+		return nil
+	}
+	d := &UsageDetails{PromptTokens: u.promptTokens, CompletionTokens: u.completionTokens}
+	if u.costReported {
+		d.Cost = strconv.FormatFloat(u.cost, 'f', -1, 64)
+	}
+	return d
+}
+
+// recordUsage adds usage reported by a provider to ctx's recorder, if any.
+// Chunked summaries make several calls, so usage adds up.
+func recordUsage(ctx context.Context, u aiUsage) {
+	if rec, ok := ctx.Value(providerRecorderKey{}).(*providerRecorder); ok {
+		rec.mu.Lock()
+		rec.usage = rec.usage.add(u)
+		rec.mu.Unlock()
+	}
+}
+
+// Usage returns the usage recorded so far.
+func (r *providerRecorder) Usage() aiUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usage
+}
+
+// openRouterUsage reads the usage block of an OpenRouter completion. cost
+// is only present because the request asks for usage accounting.
+func openRouterUsage(raw map[string]interface{}) aiUsage {
+	u := aiUsage{calls: 1}
+	if v, ok := raw["prompt_tokens"].(float64); ok {
+		u.promptTokens = int(v)
+	}
+	if v, ok := raw["completion_tokens"].(float64); ok {
+		u.completionTokens = int(v)
+	}
+	if v, ok := raw["cost"].(float64); ok {
+		u.cost, u.costReported = v, true
+	}
+	return u
+}
+
+// trackRequestCost logs and counts what a paid request cost upstream next to
+// what it was charged, so price points can be checked for profitability.
+// provider is "" for cache hits, which cost nothing upstream.
+func trackRequestCost(nonce, provider, model, charged string, u aiUsage) {
+	if provider == "" {
+		provider = "cache"
+	}
+	if amount, err := strconv.ParseFloat(charged, 64); err == nil {
+		chargedUSDCTotal.Add(amount, provider)
+	}
+	if u.calls == 0 {
+		return
+	}
+	aiTokensTotal.Add(float64(u.promptTokens), provider, "prompt")
+	aiTokensTotal.Add(float64(u.completionTokens), provider, "completion")
+	cost := "unknown"
+	if u.costReported {
+		aiCostUSDTotal.Add(u.cost, provider)
+		cost = strconv.FormatFloat(u.cost, 'f', -1, 64)
+	}
+	log.Printf("AI usage nonce=%s provider=%s model=%s prompt_tokens=%d completion_tokens=%d cost_usd=%s charged_usdc=%s",
+		nonce, provider, model, u.promptTokens, u.completionTokens, cost, charged)
+}
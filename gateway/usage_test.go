@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/testsupport"
+)
+
+func TestOpenRouterUsage_RecordedAndSummed(t *testing.T) {
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Usage(120, 30, 0.00015)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+
+	ctx, rec := withProviderRecorder(context.Background())
+	for i := 0; i < 2; i++ {
+		if _, err := callAIPrompt(ctx, "", "prompt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := rec.Usage().details()
+	if d == nil || d.PromptTokens != 240 || d.CompletionTokens != 60 || d.Cost != "0.0003" {
+		t.Errorf("Expected the usage of both calls summed, got %+v", d)
+	}
+}
+
+func TestUsageDetails_NoCalls(t *testing.T) {
+	if (aiUsage{}).details() != nil {
+		t.Error("Expected no usage details without AI calls")
+	}
+	d := aiUsage{calls: 1, promptTokens: 5}.details()
+	if d == nil || d.Cost != "" {
+		t.Errorf("Expected tokens without a cost, got %+v", d)
+	}
+}
+
+func TestHandleSummarize_UsageInReceipt(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	verifier := testsupport.NewFakeVerifier(t)
+	ai := testsupport.NewFakeOpenRouter(t)
+	ai.Usage(50, 20, 0.00007)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("OPENROUTER_URL", ai.CompletionsURL())
+	t.Setenv("AI_PROVIDER_CHAIN", "openrouter")
+	r := setupVersionedRouter()
+
+	send := func(nonce string) SummarizeResponse {
+		req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"usage tracked text"}`))
+		req.Header.Set("X-402-Signature", "0xsig")
+		req.Header.Set("X-402-Nonce", nonce)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp SummarizeResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	costBefore := aiCostUSDTotal.Value("openrouter")
+	resp := send("n-usage-1")
+	usage := resp.Receipt.Receipt.Service.Usage
+	if usage == nil || usage.PromptTokens != 50 || usage.CompletionTokens != 20 || usage.Cost != "0.00007" {
+		t.Fatalf("Expected the provider usage in the receipt, got %+v", usage)
+	}
+	if got := aiCostUSDTotal.Value("openrouter") - costBefore; got < 0.000069 || got > 0.000071 {
+		t.Errorf("Expected the cost counted in metrics, got %v", got)
+	}
+
+	if resp := send("n-usage-2"); resp.Receipt.Receipt.Service.Usage != nil {
+		t.Errorf("Expected no usage for a cache hit, got %+v", resp.Receipt.Receipt.Service.Usage)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSummarizeBodyBytes caps the summarize request body to prevent memory
+// exhaustion attacks.
+const maxSummarizeBodyBytes = 10 * 1024 * 1024
+
+var inputRejectionsTotal = newCounter(
+	"gateway_input_rejections_total",
+	"Summarize requests rejected by input validation before payment verification.",
+	"constraint")
+
+// textViolation describes which input constraint a text failed.
+type textViolation struct {
+	Constraint string
+	Limit      int
+	Detail     string
+}
+
+// getMaxTextChars returns MAX_TEXT_CHARS (default 200000; 0 disables).
+func getMaxTextChars() int {
+	return getEnvAsInt("MAX_TEXT_CHARS", 200000)
+}
+
+// getMaxTextTokens returns MAX_TEXT_TOKENS (default 0, disabled).
+func getMaxTextTokens() int {
+	return getEnvAsInt("MAX_TEXT_TOKENS", 0)
+}
+
+// validateText returns the first constraint text violates, or nil.
+func validateText(text string) *textViolation {
+	if strings.TrimSpace(text) == "" {
+		return &textViolation{Constraint: "non_empty", Detail: "text must not be empty or whitespace-only"}
+	}
+	if max := getMaxTextChars(); max > 0 {
+		if n := utf8.RuneCountInString(text); n > max {
+			return &textViolation{Constraint: "max_chars", Limit: max,
+				Detail: fmt.Sprintf("text is %d characters, the limit is %d", n, max)}
+		}
+	}
+	if max := getMaxTextTokens(); max > 0 {
+		if n := countTokens(text); n > max {
+			return &textViolation{Constraint: "max_tokens", Limit: max,
+				Detail: fmt.Sprintf("text is %d tokens, the limit is %d", n, max)}
+		}
+	}
+	return nil
+}
+
+// ValidateSummarizeInput rejects malformed summarize bodies before any paid
+// work happens, so bad input no longer consumes a nonce and a verifier
+// round-trip or a prepaid or channel charge. Requests without payment
+// headers pass through untouched: they only get the 402 challenge, which
+// costs nothing. The body is restored for the handler.
+func ValidateSummarizeInput() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signed := c.GetHeader("X-402-Signature") != "" && c.GetHeader("X-402-Nonce") != ""
+		if !signed && c.GetHeader(channelHeader) == "" && c.GetHeader(prepaidHeader) == "" {
+			c.Next()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSummarizeBodyBytes)
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				abortWithProblem(c, newProblem(413, codePayloadTooLarge, "Payload Too Large", "Request body exceeds 10MB").
+					With("max_size", "10MB"))
+			} else {
+				abortWithProblem(c, newProblem(500, codeInternalError, "Failed to read request body", ""))
+			}
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// encoding/json silently replaces invalid UTF-8 with U+FFFD, so the
+		// raw bytes have to be checked before decoding.
+		if !utf8.Valid(body) {
+			rejectText(c, &textViolation{Constraint: "utf8", Detail: "request body is not valid UTF-8"})
+			return
+		}
+
+		req, err := decodeSummarizeRequest(c, body)
+		if err != nil {
+			abortWithProblem(c, newProblem(400, codeInvalidRequestBody, "Invalid request body", err.Error()))
+			return
+		}
+		if v := validateText(req.Text); v != nil {
+			rejectText(c, v)
+			return
+		}
+		c.Next()
+	}
+}
+
+func rejectText(c *gin.Context, v *textViolation) {
+	inputRejectionsTotal.Inc(v.Constraint)
+	p := newProblem(422, codeInvalidText, "Invalid Text", v.Detail).With("constraint", v.Constraint)
+	if v.Limit > 0 {
+		p.With("limit", v.Limit)
+	}
+	abortWithProblem(c, p)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gateway/testsupport"
+)
+
+func TestValidateText(t *testing.T) {
+	t.Setenv("MAX_TEXT_CHARS", "10")
+	t.Setenv("MAX_TEXT_TOKENS", "")
+
+	tests := []struct {
+		text       string
+		constraint string
+	}{
+		{"", "non_empty"},
+		{" \n\t ", "non_empty"},
+		{"short", ""},
+		{"ééééééééé", ""}, // 9 characters, 18 bytes
+		{"eleven char", "max_chars"},
+	}
+	for _, tt := range tests {
+		v := validateText(tt.text)
+		got := ""
+		if v != nil {
+			got = v.Constraint
+		}
+		if got != tt.constraint {
+			t.Errorf("validateText(%q) = %q, want %q", tt.text, got, tt.constraint)
+		}
+	}
+
+	t.Setenv("MAX_TEXT_CHARS", "0")
+	t.Setenv("MAX_TEXT_TOKENS", "2")
+	if v := validateText("one two three four"); v == nil || v.Constraint != "max_tokens" || v.Limit != 2 {
+		t.Errorf("Expected max_tokens violation with limit 2, got %+v", v)
+	}
+}
+
+func TestValidateSummarizeInput_RejectsBeforeVerifier(t *testing.T) {
+	verifier := testsupport.NewFakeVerifier(t)
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("MAX_TEXT_CHARS", "20")
+	r := setupVersionedRouter()
+
+	tests := []struct {
+		name       string
+		body       string
+		status     int
+		constraint string
+	}{
+		{"empty", `{"text":""}`, 422, "non_empty"},
+		{"whitespace", `{"text":"   "}`, 422, "non_empty"},
+		{"too long", `{"text":"` + strings.Repeat("a", 21) + `"}`, 422, "max_chars"},
+		{"invalid utf8", "{\"text\":\"\xff\xfe\"}", 422, "utf8"},
+		{"invalid json", `{"text":`, 400, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(tt.body))
+			req.Header.Set("X-402-Signature", "0xsig")
+			req.Header.Set("X-402-Nonce", "nonce-1")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			p := decodeProblem(t, w)
+			if tt.constraint != "" && (p["code"] != codeInvalidText || p["constraint"] != tt.constraint) {
+				t.Errorf("Expected %s/%s, got %v", codeInvalidText, tt.constraint, p)
+			}
+		})
+	}
+
+	if n := verifier.Calls(); n != 0 {
+		t.Errorf("Expected invalid input never to reach the verifier, got %d calls", n)
+	}
+}
+
+func TestValidateSummarizeInput_SkipsChallengeRequests(t *testing.T) {
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":""}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 402 {
+		t.Errorf("Expected the 402 challenge without payment headers, got %d", w.Code)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcVerifyMethod is the path of Verifier.Verify in
+// proto/paygate/verifier/v1/verifier.proto.
+const grpcVerifyMethod = "/paygate.verifier.v1.Verifier/Verify"
+
+var (
+	// errVerifierRequest means the call could not be built.
+	errVerifierRequest = errors.New("invalid verifier request")
+	// errVerifierResponse means the verifier answered with something that
+	// isn't a verification result.
+	errVerifierResponse = errors.New("malformed verifier response")
+)
+
+// getVerifierProtocol returns VERIFIER_PROTOCOL: http (default) or grpc.
+func getVerifierProtocol() string {
+	if strings.ToLower(os.Getenv("VERIFIER_PROTOCOL")) == "grpc" {
+		return "grpc"
+	}
+	return "http"
+}
+
+// getVerifierGRPCURL returns VERIFIER_GRPC_URL, the verifier's gRPC
+// endpoint. http:// URLs use HTTP/2 without TLS (h2c).
+func getVerifierGRPCURL() string {
+	if v := os.Getenv("VERIFIER_GRPC_URL"); v != "" {
+		return v
+	}
+	return "http://127.0.0.1:50051"
+}
+
+// callVerifier asks the verifier to check req over VERIFIER_PROTOCOL,
+// sending headers along (as gRPC metadata with grpc). It returns the
+// verifier's answer and status: the HTTP status code, or the gRPC status
+// code with grpc. Errors wrap errVerifierRequest, errVerifierResponse or
+// the transport error; timeouts match context.DeadlineExceeded.
+func callVerifier(ctx context.Context, req VerifyRequest, headers http.Header) (VerifyResponse, int, error) {
+	if getVerifierProtocol() == "grpc" {
+		return grpcVerify(ctx, req, headers)
+	}
+	return httpVerify(ctx, req, headers)
+}
+
+// httpVerify calls the verifier's POST /verify with a JSON body.
+func httpVerify(ctx context.Context, req VerifyRequest, headers http.Header) (VerifyResponse, int, error) {
+	var verifyResp VerifyResponse
+	body, err := json.Marshal(req)
+	if err != nil {
+		return verifyResp, 0, fmt.Errorf("%w: %v", errVerifierRequest, err)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, "POST", getVerifierURL()+"/verify", bytes.NewReader(body))
+	if err != nil {
+		return verifyResp, 0, fmt.Errorf("%w: %v", errVerifierRequest, err)
+	}
+	for name, values := range headers {
+		hreq.Header[name] = values
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	// Rely on ctx for timeouts/cancellation.
+	resp, err := verifierHTTPClient.Do(hreq)
+	if err != nil {
+		return verifyResp, 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&verifyResp); err != nil {
+		return verifyResp, resp.StatusCode, fmt.Errorf("%w: %v", errVerifierResponse, err)
+	}
+	return verifyResp, resp.StatusCode, nil
+}
+
+// verifierHTTPClient makes the HTTP calls to the verifier, /verify and
+// /health. initVerifierTLS replaces it to add mutual TLS.
+var verifierHTTPClient = http.DefaultClient
+
+// grpcClient speaks HTTP/2 only: with prior knowledge (h2c) to http://
+// verifiers and over TLS to https:// ones.
+var grpcClient = &http.Client{Transport: newGRPCTransport(nil)}
+
+func newGRPCTransport(tlsConfig *tls.Config) *http.Transport {
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Transport{Protocols: &protocols, TLSClientConfig: tlsConfig}
+}
+
+// initVerifierTLS secures the verifier connection so a compromised network
+// segment can't answer in the verifier's place:
+//
+//   - VERIFIER_TLS_CA_FILE: PEM CA the verifier's certificate must chain to;
+//     the system roots are not trusted for the verifier once it is set
+//   - VERIFIER_TLS_CERT_FILE, VERIFIER_TLS_KEY_FILE: client certificate
+//     presented to the verifier, reloaded when the files change
+//   - VERIFIER_TLS_SERVER_NAME: name expected in the verifier's certificate
+//     (default: the host of VERIFIER_URL)
+//
+// Both the HTTP and gRPC clients use it. LoadConfig has already required
+// https:// verifier URLs when any of these are set.
+func initVerifierTLS(ctx context.Context) error {
+	caFile, certFile := os.Getenv("VERIFIER_TLS_CA_FILE"), os.Getenv("VERIFIER_TLS_CERT_FILE")
+	if caFile == "" && certFile == "" {
+		return nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: os.Getenv("VERIFIER_TLS_SERVER_NAME")}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return fmt.Errorf("VERIFIER_TLS_CA_FILE: %w", err)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" {
+		reloader, err := newCertReloader(certFile, os.Getenv("VERIFIER_TLS_KEY_FILE"))
+		if err != nil {
+			return fmt.Errorf("VERIFIER_TLS_CERT_FILE: %w", err)
+		}
+		go reloader.watch(ctx, time.Duration(getEnvAsInt("TLS_RELOAD_INTERVAL_SECONDS", 30))*time.Second)
+		cfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	verifierHTTPClient = &http.Client{Transport: transport}
+	grpcClient = &http.Client{Transport: newGRPCTransport(cfg)}
+	log.Printf("Verifier TLS enabled (pinned CA: %t, client certificate: %t)", caFile != "", certFile != "")
+	return nil
+}
+
+// loadCertPool reads a PEM bundle into a pool of its own.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// grpcStatusError is a non-OK gRPC status returned by the verifier.
+type grpcStatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *grpcStatusError) Error() string {
+	return fmt.Sprintf("verifier returned gRPC status %d: %s", e.Code, e.Message)
+}
+
+// Is lets a DEADLINE_EXCEEDED status be handled like a local timeout.
+func (e *grpcStatusError) Is(target error) bool {
+	return target == context.DeadlineExceeded && e.Code == grpcDeadlineExceeded
+}
+
+// grpcVerify calls Verifier.Verify with a hand-encoded unary gRPC request,
+// which keeps the gateway free of the full gRPC runtime.
+func grpcVerify(ctx context.Context, req VerifyRequest, headers http.Header) (VerifyResponse, int, error) {
+	var verifyResp VerifyResponse
+	frame := grpcFrame(marshalVerifyRequest(req))
+	hreq, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(getVerifierGRPCURL(), "/")+grpcVerifyMethod, bytes.NewReader(frame))
+	if err != nil {
+		return verifyResp, 0, fmt.Errorf("%w: %v", errVerifierRequest, err)
+	}
+	for name, values := range headers {
+		hreq.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	hreq.Header.Set("Content-Type", "application/grpc+proto")
+	hreq.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		hreq.Header.Set("Grpc-Timeout", strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)+"m")
+	}
+
+	resp, err := grpcClient.Do(hreq)
+	if err != nil {
+		return verifyResp, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return verifyResp, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return verifyResp, 0, fmt.Errorf("%w: HTTP status %d", errVerifierResponse, resp.StatusCode)
+	}
+
+	// Errors may come as a headers-only response without trailers
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return verifyResp, 0, fmt.Errorf("%w: missing grpc-status", errVerifierResponse)
+	}
+	if code != grpcOK {
+		message, _ = url.PathUnescape(message)
+		return verifyResp, code, &grpcStatusError{Code: code, Message: message}
+	}
+
+	msg, err := grpcUnframe(body)
+	if err == nil {
+		verifyResp, err = unmarshalVerifyResponse(msg)
+	}
+	if err != nil {
+		return verifyResp, code, fmt.Errorf("%w: %v", errVerifierResponse, err)
+	}
+	return verifyResp, code, nil
+}
+
+// marshalVerifyRequest encodes req as a paygate.verifier.v1.VerifyRequest.
+func marshalVerifyRequest(req VerifyRequest) []byte {
+	var pc []byte
+	pc = appendProtoString(pc, 1, req.Context.Recipient)
+	pc = appendProtoString(pc, 2, req.Context.Token)
+	pc = appendProtoString(pc, 3, req.Context.Amount)
+	pc = appendProtoString(pc, 4, req.Context.Nonce)
+	if req.Context.ChainID != 0 {
+		pc = protowire.AppendTag(pc, 5, protowire.VarintType)
+		pc = protowire.AppendVarint(pc, uint64(req.Context.ChainID))
+	}
+	pc = appendProtoString(pc, 6, req.Context.BodyHash)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, pc)
+	return appendProtoString(b, 2, req.Signature)
+}
+
+// unmarshalVerifyResponse decodes a paygate.verifier.v1.VerifyResponse,
+// skipping fields it doesn't know.
+func unmarshalVerifyResponse(b []byte) (VerifyResponse, error) {
+	var resp VerifyResponse
+	err := rangeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			resp.IsValid = v != 0
+			return n
+		case num == 2 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			resp.RecoveredAddress = s
+			return n
+		case num == 3 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			resp.Error = s
+			return n
+		}
+		return 0
+	})
+	return resp, err
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeGRPCVerifier serves Verifier.Verify over h2c, answering every call
+// with resp, or with status when it is non-zero.
+type fakeGRPCVerifier struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	resp     VerifyResponse
+	status   int
+	requests []VerifyRequest
+	headers  []http.Header
+}
+
+func newFakeGRPCVerifier(t *testing.T, resp VerifyResponse) *fakeGRPCVerifier {
+	v := &fakeGRPCVerifier{resp: resp}
+	v.Server = httptest.NewUnstartedServer(http.HandlerFunc(v.serve))
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	v.Config.Protocols = &protocols
+	v.Start()
+	t.Cleanup(v.Close)
+	t.Setenv("VERIFIER_PROTOCOL", "grpc")
+	t.Setenv("VERIFIER_GRPC_URL", v.URL)
+	return v
+}
+
+func (v *fakeGRPCVerifier) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if r.ProtoMajor != 2 || r.URL.Path != grpcVerifyMethod || r.Header.Get("Content-Type") != "application/grpc+proto" || len(body) < 5 {
+		http.Error(w, "not a gRPC call", http.StatusBadRequest)
+		return
+	}
+	v.mu.Lock()
+	v.requests = append(v.requests, decodeTestVerifyRequest(body[5:]))
+	v.headers = append(v.headers, r.Header.Clone())
+	resp, status := v.resp, v.status
+	v.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if status != 0 {
+		w.Header().Set("Grpc-Status", strconv.Itoa(status))
+		w.Header().Set("Grpc-Message", "verifier%20overloaded")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	var msg []byte
+	if resp.IsValid {
+		msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, 1)
+	}
+	msg = appendProtoString(msg, 2, resp.RecoveredAddress)
+	msg = appendProtoString(msg, 3, resp.Error)
+	msg = appendProtoString(msg, 9, "ignored")
+	w.Write(grpcFrame(msg))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// decodeTestVerifyRequest decodes the fields the gateway sends.
+func decodeTestVerifyRequest(b []byte) VerifyRequest {
+	var req VerifyRequest
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		if num == 1 && typ == protowire.BytesType {
+			pc, n := protowire.ConsumeBytes(b)
+			b = b[n:]
+			for len(pc) > 0 {
+				num, typ, n := protowire.ConsumeTag(pc)
+				pc = pc[n:]
+				if typ == protowire.VarintType {
+					v, n := protowire.ConsumeVarint(pc)
+					req.Context.ChainID, pc = int(v), pc[n:]
+					continue
+				}
+				s, n := protowire.ConsumeString(pc)
+				pc = pc[n:]
+				switch num {
+				case 1:
+					req.Context.Recipient = s
+				case 2:
+					req.Context.Token = s
+				case 3:
+					req.Context.Amount = s
+				case 4:
+					req.Context.Nonce = s
+				}
+			}
+			continue
+		}
+		s, n := protowire.ConsumeString(b)
+		req.Signature, b = s, b[n:]
+	}
+	return req
+}
+
+func TestGRPCVerify_RoundTrip(t *testing.T) {
+	v := newFakeGRPCVerifier(t, VerifyResponse{IsValid: true, RecoveredAddress: "0xabc"})
+	req := VerifyRequest{
+		Context:   PaymentContext{Recipient: "0xrecipient", Token: "USDC", Amount: "0.001", Nonce: "n-1", ChainID: 8453},
+		Signature: "0xsig",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	resp, status, err := callVerifier(ctx, req, http.Header{"X-Request-Id": {"req-1"}})
+	if err != nil || status != 0 || !resp.IsValid || resp.RecoveredAddress != "0xabc" {
+		t.Fatalf("Expected a valid answer, got %+v %d %v", resp, status, err)
+	}
+	if len(v.requests) != 1 || v.requests[0] != req {
+		t.Errorf("Expected the request to round-trip, got %+v", v.requests)
+	}
+	if h := v.headers[0]; h.Get("X-Request-Id") != "req-1" || !strings.HasSuffix(h.Get("Grpc-Timeout"), "m") {
+		t.Errorf("Expected request ID metadata and a deadline, got %v", h)
+	}
+}
+
+func TestGRPCVerify_ErrorStatus(t *testing.T) {
+	v := newFakeGRPCVerifier(t, VerifyResponse{})
+	v.status = 14
+
+	_, status, err := callVerifier(context.Background(), VerifyRequest{}, nil)
+	var statusErr *grpcStatusError
+	if !errors.As(err, &statusErr) || status != 14 || statusErr.Message != "verifier overloaded" {
+		t.Fatalf("Expected UNAVAILABLE, got %d %v", status, err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected UNAVAILABLE not to count as a timeout")
+	}
+
+	v.status = grpcDeadlineExceeded
+	if _, _, err := callVerifier(context.Background(), VerifyRequest{}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DEADLINE_EXCEEDED to count as a timeout, got %v", err)
+	}
+}
+
+func TestHandleSummarize_GRPCVerifier(t *testing.T) {
+	ensureTestServerKey(t)
+	setupTestMemoryCache(t, 10, time.Minute)
+	v := newFakeGRPCVerifier(t, VerifyResponse{Error: "bad signature"})
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"hello"}`))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "n-grpc")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden || decodeProblem(t, w)["code"] != codeInvalidSignature {
+		t.Fatalf("Expected the gRPC verifier's rejection, got %d %s", w.Code, w.Body.String())
+	}
+	if len(v.requests) != 1 || v.requests[0].Context.Nonce != "n-grpc" {
+		t.Errorf("Expected one gRPC call for the nonce, got %+v", v.requests)
+	}
+
+	v.status = 14
+	req, _ = http.NewRequest("POST", "/v1/ai/summarize", strings.NewReader(`{"text":"hello"}`))
+	req.Header.Set("X-402-Signature", "0xsig")
+	req.Header.Set("X-402-Nonce", "n-grpc-2")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || decodeProblem(t, w)["code"] != codeVerifierError {
+		t.Errorf("Expected 500 verifier_error for UNAVAILABLE, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLoadConfig_VerifierProtocol(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("VERIFIER_PROTOCOL", "grpc")
+	t.Setenv("VERIFIER_GRPC_URL", "127.0.0.1:50051")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "VERIFIER_GRPC_URL") {
+		t.Errorf("Expected a VERIFIER_GRPC_URL problem, got %v", err)
+	}
+	t.Setenv("VERIFIER_PROTOCOL", "thrift")
+	t.Setenv("VERIFIER_GRPC_URL", "")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "VERIFIER_PROTOCOL") {
+		t.Errorf("Expected a VERIFIER_PROTOCOL problem, got %v", err)
+	}
+}
+
+// setupVerifierTLS runs initVerifierTLS, restoring the plain clients when
+// the test ends.
+func setupVerifierTLS(t *testing.T) error {
+	t.Helper()
+	prevHTTP, prevGRPC := verifierHTTPClient, grpcClient
+	t.Cleanup(func() { verifierHTTPClient, grpcClient = prevHTTP, prevGRPC })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return initVerifierTLS(ctx)
+}
+
+func TestInitVerifierTLS_MutualAuth(t *testing.T) {
+	serverCert, serverKey := writeTestCert(t, t.TempDir(), "verifier")
+	clientCert, clientKey := writeTestCert(t, t.TempDir(), "gateway")
+	clientCAs, _ := loadCertPool(clientCert)
+	pair, _ := tls.LoadX509KeyPair(serverCert, serverKey)
+
+	var peer string
+	verifier := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.Write([]byte(`{"is_valid":true,"recovered_address":"0xabc"}`))
+	}))
+	verifier.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	verifier.StartTLS()
+	defer verifier.Close()
+	t.Setenv("VERIFIER_URL", verifier.URL)
+
+	t.Setenv("VERIFIER_TLS_CA_FILE", serverCert)
+	t.Setenv("VERIFIER_TLS_CERT_FILE", clientCert)
+	t.Setenv("VERIFIER_TLS_KEY_FILE", clientKey)
+	if err := setupVerifierTLS(t); err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err := callVerifier(context.Background(), VerifyRequest{}, nil)
+	if err != nil || !resp.IsValid || peer != "gateway" {
+		t.Fatalf("Expected a verified answer over mTLS, got %+v %v (peer %q)", resp, err, peer)
+	}
+	if err := checkVerifierHealth(context.Background()); err != nil {
+		t.Errorf("Expected the health check to use mTLS too, got %v", err)
+	}
+
+	// Without a client certificate the verifier refuses the connection
+	t.Setenv("VERIFIER_TLS_CERT_FILE", "")
+	if err := setupVerifierTLS(t); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := callVerifier(context.Background(), VerifyRequest{}, nil); err == nil {
+		t.Error("Expected the call without a client certificate to fail")
+	}
+
+	// A verifier whose certificate doesn't chain to the pinned CA is refused
+	otherCA, _ := writeTestCert(t, t.TempDir(), "other")
+	t.Setenv("VERIFIER_TLS_CA_FILE", otherCA)
+	t.Setenv("VERIFIER_TLS_CERT_FILE", clientCert)
+	if err := setupVerifierTLS(t); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := callVerifier(context.Background(), VerifyRequest{}, nil); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Expected an untrusted verifier to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfig_VerifierTLS(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+	t.Setenv("VERIFIER_URL", "http://verifier:3002")
+	t.Setenv("VERIFIER_TLS_CA_FILE", "/nonexistent/ca.pem")
+	t.Setenv("VERIFIER_TLS_CERT_FILE", "/nonexistent/cert.pem")
+
+	_, err := LoadConfig()
+	for _, want := range []string{"VERIFIER_TLS_CA_FILE:", "must be set together", "VERIFIER_URL: must be an https URL"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+}
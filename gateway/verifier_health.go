@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// verifierHealth caches the verifier's /health status as seen by the
+// background poller so requests can fail fast during an outage instead of
+// each waiting out the verifier timeout. It is nil when polling is disabled.
+var verifierHealth *verifierHealthStatus
+
+type verifierHealthStatus struct {
+	mu                  sync.RWMutex
+	failureThreshold    int
+	consecutiveFailures int
+	lastChecked         time.Time
+	lastError           string
+}
+
+// verifierHealthSnapshot is a point-in-time copy of the cached status.
+type verifierHealthSnapshot struct {
+	Up                  bool
+	ConsecutiveFailures int
+	LastChecked         time.Time
+	LastError           string
+}
+
+func newVerifierHealthStatus(failureThreshold int) *verifierHealthStatus {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &verifierHealthStatus{failureThreshold: failureThreshold}
+}
+
+// record stores the outcome of one poll.
+func (s *verifierHealthStatus) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastChecked = time.Now()
+	if err == nil {
+		if s.consecutiveFailures >= s.failureThreshold {
+			log.Println("Verifier is reachable again")
+		}
+		s.consecutiveFailures = 0
+		s.lastError = ""
+		return
+	}
+
+	s.consecutiveFailures++
+	s.lastError = err.Error()
+	if s.consecutiveFailures == s.failureThreshold {
+		log.Printf("Verifier marked unavailable after %d failed health checks: %v", s.consecutiveFailures, err)
+	}
+}
+
+// snapshot returns the cached status. The verifier counts as up until it has
+// failed failureThreshold polls in a row, so a single blip is tolerated.
+func (s *verifierHealthStatus) snapshot() verifierHealthSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return verifierHealthSnapshot{
+		Up:                  s.consecutiveFailures < s.failureThreshold,
+		ConsecutiveFailures: s.consecutiveFailures,
+		LastChecked:         s.lastChecked,
+		LastError:           s.lastError,
+	}
+}
+
+// startVerifierHealthPoller polls the verifier every
+// VERIFIER_HEALTH_POLL_INTERVAL_SECONDS (default 10; 0 disables) and marks it
+// unavailable after VERIFIER_HEALTH_FAILURE_THRESHOLD consecutive failures
+// (default 2). It returns the status it keeps up to date, or nil.
+func startVerifierHealthPoller(ctx context.Context) *verifierHealthStatus {
+	interval := time.Duration(getEnvAsInt("VERIFIER_HEALTH_POLL_INTERVAL_SECONDS", 10)) * time.Second
+	if interval <= 0 {
+		log.Println("Verifier health polling disabled")
+		return nil
+	}
+
+	status := newVerifierHealthStatus(getEnvAsInt("VERIFIER_HEALTH_FAILURE_THRESHOLD", 2))
+	poll := func() {
+		checkCtx, cancel := context.WithTimeout(ctx, getHealthCheckTimeout())
+		defer cancel()
+		status.record(checkVerifierHealth(checkCtx))
+	}
+
+	poll()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				poll()
+			}
+		}
+	}()
+
+	log.Printf("Verifier health polling every %s", interval)
+	return status
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupVerifierHealth installs a cached verifier status for the test.
+func setupVerifierHealth(t *testing.T, status *verifierHealthStatus) {
+	t.Helper()
+	prev := verifierHealth
+	verifierHealth = status
+	t.Cleanup(func() { verifierHealth = prev })
+}
+
+func TestVerifierHealthStatus_FailureThreshold(t *testing.T) {
+	s := newVerifierHealthStatus(2)
+	if !s.snapshot().Up {
+		t.Fatal("Expected verifier up before any poll")
+	}
+
+	s.record(errors.New("connection refused"))
+	if !s.snapshot().Up {
+		t.Error("Expected a single failure to be tolerated")
+	}
+
+	s.record(errors.New("connection refused"))
+	snap := s.snapshot()
+	if snap.Up || snap.ConsecutiveFailures != 2 || snap.LastError != "connection refused" {
+		t.Errorf("Expected verifier down after 2 failures, got %+v", snap)
+	}
+
+	s.record(nil)
+	if snap := s.snapshot(); !snap.Up || snap.LastError != "" {
+		t.Errorf("Expected recovery after a successful poll, got %+v", snap)
+	}
+}
+
+func TestStartVerifierHealthPoller(t *testing.T) {
+	healthy := false
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(503)
+		}
+	}))
+	defer verifier.Close()
+
+	t.Setenv("VERIFIER_URL", verifier.URL)
+	t.Setenv("VERIFIER_HEALTH_FAILURE_THRESHOLD", "1")
+
+	t.Setenv("VERIFIER_HEALTH_POLL_INTERVAL_SECONDS", "0")
+	if startVerifierHealthPoller(context.Background()) != nil {
+		t.Error("Expected polling disabled with interval 0")
+	}
+
+	t.Setenv("VERIFIER_HEALTH_POLL_INTERVAL_SECONDS", "60")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := startVerifierHealthPoller(ctx)
+	if status == nil || status.snapshot().Up {
+		t.Fatal("Expected the initial poll to mark the verifier down")
+	}
+}
+
+func TestHandleSummarize_FailsFastWhenVerifierDown(t *testing.T) {
+	status := newVerifierHealthStatus(1)
+	status.record(errors.New("dial tcp: connection refused"))
+	setupVerifierHealth(t, status)
+
+	verifierCalled := false
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifierCalled = true
+	}))
+	defer verifier.Close()
+	t.Setenv("VERIFIER_URL", verifier.URL)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/ai/summarize", handleSummarize)
+
+	req, _ := http.NewRequest("POST", "/api/ai/summarize", strings.NewReader(`{"text":"hi"}`))
+	req.Header.Set("X-402-Signature", "sig")
+	req.Header.Set("X-402-Nonce", "nonce")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 503 || !strings.Contains(w.Body.String(), "Verifier Unavailable") {
+		t.Errorf("Expected 503 Verifier Unavailable, got %d: %s", w.Code, w.Body.String())
+	}
+	if verifierCalled {
+		t.Error("Expected no verifier call while it is marked down")
+	}
+}
+
+func TestHandleReady_UsesCachedVerifierStatus(t *testing.T) {
+	status := newVerifierHealthStatus(1)
+	status.record(errors.New("timeout"))
+	setupVerifierHealth(t, status)
+	t.Setenv("VERIFIER_URL", "http://127.0.0.1:1")
+	t.Setenv("READINESS_CHECK_OPENROUTER", "")
+
+	code, checks := readyzRequest(t)
+	if code != 503 || !strings.Contains(checks["verifier"].Error, "timeout") {
+		t.Errorf("Expected 503 from cached status, got %d: %+v", code, checks)
+	}
+
+	status.record(nil)
+	if code, _ := readyzRequest(t); code != 200 {
+		t.Errorf("Expected 200 once cached status recovers, got %d", code)
+	}
+}
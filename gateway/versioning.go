@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// apiV1Prefix is the versioned API root; the legacy unversioned routes
+	// live under legacyAPIPrefix.
+	apiV1Prefix     = "/v1"
+	legacyAPIPrefix = "/api"
+)
+
+// legacyAPIDeprecatedAt is when the unversioned /api routes were deprecated
+// in favour of /v1, reported in the Deprecation header (RFC 9745).
+var legacyAPIDeprecatedAt = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// registerAPIRoutes registers the public API on g. It is called once for
+// /v1 and once for the legacy /api prefix so both serve the same handlers.
+func registerAPIRoutes(g *gin.RouterGroup) {
+	// AI endpoints with AI-specific timeout (30s). Compressed bodies are
+	// inflated and input is validated before the handler so bad text never
+	// reaches the verifier. Retries carrying an Idempotency-Key are answered
+	// from the stored response before anything is verified or charged.
+	g.POST("/ai/summarize", RequestTimeoutMiddleware(getAITimeout()), DecompressRequestBody(maxSummarizeBodyBytes), IdempotencyMiddleware(), PriceOracleMiddleware(), ValidateSummarizeInput(), handleSummarize)
+
+	// Price of a body, with a payment context to sign for it, without doing
+	// the work
+	g.POST("/ai/summarize/quote", DecompressRequestBody(maxSummarizeBodyBytes), PriceOracleMiddleware(), handleSummarizeQuote)
+
+	// Payment context with a gateway-issued nonce, for clients that sign
+	// before sending the request
+	g.GET("/payment/challenge", PriceOracleMiddleware(), handlePaymentChallenge)
+
+	// Selectable models and their prices
+	g.GET("/models", PriceOracleMiddleware(), handleListModels)
+
+	// Receipt lookup endpoint
+	// Note: Rate limiting applies only if enabled globally via RATE_LIMIT_ENABLED=true
+	// Random 12-char receipt IDs (2^48 space) make brute-force enumeration impractical
+	g.GET("/receipts/:id", handleGetReceipt)
+	// Signature check and canonical contents, for third parties
+	g.GET("/receipts/:id/verify", handleVerifyReceipt)
+	// Merkle inclusion proof against the batch's anchored root
+	g.GET("/receipts/:id/proof", handleReceiptProof)
+
+	// What became of a payment: verification, settlement and refund
+	g.GET("/payments/:nonce", handleGetPayment)
+
+	// Prepaid balance credited from direct transfers (wallet signature or
+	// admin token)
+	g.GET("/prepaid/:wallet", handleGetPrepaidBalance)
+
+	// Payment channel balance, and closing by the sender
+	g.GET("/channels/:id", handleGetChannel)
+	g.POST("/channels/:id/close", handleCloseChannel)
+
+	// Per-wallet usage from the ledger (wallet signature or admin token)
+	g.GET("/usage/:wallet", handleWalletUsage)
+
+	// Erasure of a wallet's stored data (wallet signature or admin token)
+	g.DELETE("/privacy/:wallet", handleEraseWalletData)
+}
+
+// getLegacyAPISunset returns when the legacy routes will be removed, from
+// LEGACY_API_SUNSET (an RFC 3339 date or timestamp). The zero time means no
+// date has been announced.
+func getLegacyAPISunset() time.Time {
+	if appConfig != nil {
+		return appConfig.LegacyAPISunset
+	}
+	sunset, _ := parseSunset(os.Getenv("LEGACY_API_SUNSET"))
+	return sunset
+}
+
+// parseSunset accepts either 2027-06-30 or a full RFC 3339 timestamp.
+func parseSunset(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// DeprecationMiddleware marks responses on the legacy unversioned routes
+// with Deprecation (RFC 9745), Sunset (RFC 8594, when LEGACY_API_SUNSET is
+// set) and a Link to the /v1 successor, so integrators can find and migrate
+// the calls before the aliases are removed.
+func DeprecationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "@"+strconv.FormatInt(legacyAPIDeprecatedAt.Unix(), 10))
+		if sunset := getLegacyAPISunset(); !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		successor := apiV1Prefix + strings.TrimPrefix(c.Request.URL.Path, legacyAPIPrefix)
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
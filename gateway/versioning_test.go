@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupVersionedRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerAPIRoutes(r.Group(apiV1Prefix))
+	registerAPIRoutes(r.Group(legacyAPIPrefix, DeprecationMiddleware()))
+	return r
+}
+
+func TestVersionedRoutes_ServeSameHandlers(t *testing.T) {
+	r := setupVersionedRouter()
+
+	for _, path := range []string{"/v1/ai/summarize", "/api/ai/summarize"} {
+		req, _ := http.NewRequest("POST", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != 402 {
+			t.Errorf("%s: expected 402, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestDeprecationMiddleware_LegacyOnly(t *testing.T) {
+	t.Setenv("LEGACY_API_SUNSET", "")
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("GET", "/v1/receipts/rcpt_missing", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Deprecation") != "" || w.Header().Get("Link") != "" {
+		t.Errorf("Expected no deprecation headers on /v1, got %v", w.Header())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/receipts/rcpt_missing", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("Deprecation"); got != "@1792108800" {
+		t.Errorf("Expected Deprecation @1792108800, got %q", got)
+	}
+	if got := w.Header().Get("Link"); got != `</v1/receipts/rcpt_missing>; rel="successor-version"` {
+		t.Errorf("Unexpected Link header %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "" {
+		t.Errorf("Expected no Sunset without LEGACY_API_SUNSET, got %q", got)
+	}
+}
+
+func TestDeprecationMiddleware_Sunset(t *testing.T) {
+	t.Setenv("LEGACY_API_SUNSET", "2027-06-30")
+	r := setupVersionedRouter()
+
+	req, _ := http.NewRequest("POST", "/api/ai/summarize", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("Sunset"); got != "Wed, 30 Jun 2027 00:00:00 GMT" {
+		t.Errorf("Unexpected Sunset header %q", got)
+	}
+}
+
+func TestLoadConfig_LegacyAPISunset(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test-key")
+
+	t.Setenv("LEGACY_API_SUNSET", "2027-06-30T12:00:00Z")
+	cfg, err := LoadConfig()
+	if err != nil || cfg.LegacyAPISunset.Hour() != 12 {
+		t.Errorf("Expected RFC 3339 sunset to load, got %v, %v", cfg, err)
+	}
+
+	t.Setenv("LEGACY_API_SUNSET", "next summer")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected an invalid LEGACY_API_SUNSET to be rejected")
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// operatorWebhooks notifies operators of payment lifecycle events. It is nil
+// unless WEBHOOK_URLS is set.
+var operatorWebhooks *webhookNotifier
+
+var webhookDeliveriesTotal = newCounter(
+	"gateway_webhook_deliveries_total",
+	"Webhook delivery attempts by event type and outcome (success, retry, failed).",
+	"type", "outcome",
+)
+
+// Webhook event types.
+const (
+	webhookWalletFirstPayment = "wallet.first_payment"
+	webhookSettlementFailed   = "settlement.failed"
+	webhookCircuitOpened      = "provider.circuit_opened"
+	webhookCircuitClosed      = "provider.circuit_closed"
+)
+
+// webhookEventTypes are the types WEBHOOK_EVENTS may name.
+var webhookEventTypes = []string{webhookWalletFirstPayment, webhookSettlementFailed, webhookCircuitOpened, webhookCircuitClosed}
+
+// WebhookPayload is the JSON body POSTed to each webhook URL.
+type WebhookPayload struct {
+	ID   string                 `json:"id"`
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// webhookDelivery is one payload waiting in the outbox for one URL.
+type webhookDelivery struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Type        string    `json:"type"`
+	Body        []byte    `json:"body"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// webhookOutbox stores deliveries until they succeed or give up, so a
+// receiver that is down doesn't lose notifications.
+type webhookOutbox interface {
+	Add(ctx context.Context, d webhookDelivery) error
+	// Claim returns up to limit deliveries that are due and hides them
+	// from other workers for lease.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]webhookDelivery, error)
+	// Reschedule stores d's new attempt count and next attempt time.
+	Reschedule(ctx context.Context, d webhookDelivery) error
+	Remove(ctx context.Context, id string) error
+}
+
+// webhookNotifier signs payloads, queues them in the outbox and delivers
+// them with retries from a background worker.
+type webhookNotifier struct {
+	urls        []string
+	secret      []byte
+	events      map[string]bool
+	maxAttempts int
+	outbox      webhookOutbox
+	client      *http.Client
+
+	// wallets that have paid, to spot first payments; Redis when available
+	mu          sync.Mutex
+	seenWallets map[string]bool
+}
+
+// initWebhooks builds the notifier from WEBHOOK_URLS (comma-separated),
+// WEBHOOK_SECRET (HMAC key for the X-Paygate-Signature header),
+// WEBHOOK_EVENTS (default: all types) and WEBHOOK_MAX_ATTEMPTS (default 8).
+// The outbox is kept in Redis when it is configured, so pending deliveries
+// survive restarts and are shared across instances, and in memory
+// otherwise. LoadConfig has already validated the settings.
+func initWebhooks() *webhookNotifier {
+	urls := splitList(os.Getenv("WEBHOOK_URLS"))
+	if len(urls) == 0 {
+		return nil
+	}
+	events, _ := parseWebhookEvents(os.Getenv("WEBHOOK_EVENTS"))
+	var outbox webhookOutbox = newMemoryOutbox()
+	if redisClient != nil {
+		outbox = &redisOutbox{client: redisClient}
+	}
+	log.Printf("Webhooks enabled for %d URLs", len(urls))
+	return &webhookNotifier{
+		urls:        urls,
+		secret:      []byte(os.Getenv("WEBHOOK_SECRET")),
+		events:      events,
+		maxAttempts: getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		outbox:      outbox,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		seenWallets: make(map[string]bool),
+	}
+}
+
+// splitList splits a comma-separated setting, dropping blanks.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseWebhookEvents parses WEBHOOK_EVENTS; empty selects every type.
+func parseWebhookEvents(raw string) (map[string]bool, error) {
+	events := make(map[string]bool)
+	for _, name := range splitList(raw) {
+		if !slices.Contains(webhookEventTypes, name) {
+			return nil, fmt.Errorf("WEBHOOK_EVENTS: unknown event %q (want %s)", name, strings.Join(webhookEventTypes, ", "))
+		}
+		events[name] = true
+	}
+	if len(events) == 0 {
+		for _, name := range webhookEventTypes {
+			events[name] = true
+		}
+	}
+	return events, nil
+}
+
+// notifyWebhooks queues a webhook of type eventType for every URL, if
+// webhooks are enabled and subscribed to the type.
+func notifyWebhooks(ctx context.Context, eventType string, data map[string]interface{}) {
+	if operatorWebhooks != nil {
+		operatorWebhooks.notify(ctx, eventType, data)
+	}
+}
+
+func (n *webhookNotifier) notify(ctx context.Context, eventType string, data map[string]interface{}) {
+	if !n.events[eventType] {
+		return
+	}
+	body, err := json.Marshal(WebhookPayload{ID: uuid.New().String(), Type: eventType, Time: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("error encoding %s webhook: %v", eventType, err)
+		return
+	}
+	for _, url := range n.urls {
+		d := webhookDelivery{ID: uuid.New().String(), URL: url, Type: eventType, Body: body, NextAttempt: time.Now()}
+		if err := n.outbox.Add(context.WithoutCancel(ctx), d); err != nil {
+			log.Printf("error queueing %s webhook for %s: %v", eventType, url, err)
+		}
+	}
+}
+
+// notifyFirstPayment sends wallet.first_payment the first time wallet pays.
+func notifyFirstPayment(ctx context.Context, wallet, nonce, amount string) {
+	if operatorWebhooks == nil || !operatorWebhooks.events[webhookWalletFirstPayment] {
+		return
+	}
+	wallet = strings.ToLower(wallet)
+	if !operatorWebhooks.markWalletSeen(ctx, wallet) {
+		return
+	}
+	notifyWebhooks(ctx, webhookWalletFirstPayment, map[string]interface{}{"wallet": wallet, "nonce": nonce, "amount": amount})
+}
+
+// markWalletSeen records wallet and reports whether it was new.
+func (n *webhookNotifier) markWalletSeen(ctx context.Context, wallet string) bool {
+	if redisClient != nil {
+		added, err := redisClient.SAdd(ctx, "webhooks:wallets", wallet).Result()
+		if err == nil {
+			return added == 1
+		}
+		log.Printf("error checking wallet %s for first payment: %v", wallet, err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seenWallets[wallet] {
+		return false
+	}
+	n.seenWallets[wallet] = true
+	return true
+}
+
+// start delivers due webhooks every second until ctx is done.
+func (n *webhookNotifier) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n.deliverDue(ctx)
+			}
+		}
+	}()
+}
+
+// deliverDue sends every due delivery once.
+func (n *webhookNotifier) deliverDue(ctx context.Context) {
+	due, err := n.outbox.Claim(ctx, time.Now(), time.Minute, 50)
+	if err != nil {
+		log.Printf("error reading webhook outbox: %v", err)
+		return
+	}
+	for _, d := range due {
+		n.attempt(ctx, d)
+	}
+}
+
+// attempt POSTs d once. A 2xx removes it from the outbox; anything else is
+// retried with exponential backoff (or the receiver's Retry-After) until
+// WEBHOOK_MAX_ATTEMPTS is reached.
+func (n *webhookNotifier) attempt(ctx context.Context, d webhookDelivery) {
+	retryAfter, err := n.post(ctx, d)
+	if err == nil {
+		webhookDeliveriesTotal.Inc(d.Type, "success")
+		n.outbox.Remove(ctx, d.ID)
+		return
+	}
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= n.maxAttempts {
+		webhookDeliveriesTotal.Inc(d.Type, "failed")
+		log.Printf("Giving up on %s webhook %s to %s after %d attempts: %v", d.Type, d.ID, d.URL, d.Attempts, err)
+		n.outbox.Remove(ctx, d.ID)
+		return
+	}
+	webhookDeliveriesTotal.Inc(d.Type, "retry")
+	d.NextAttempt = time.Now().Add(max(webhookBackoff(d.Attempts), retryAfter))
+	if err := n.outbox.Reschedule(ctx, d); err != nil {
+		log.Printf("error rescheduling webhook %s: %v", d.ID, err)
+	}
+}
+
+// webhookBackoff returns the wait after the given number of failed
+// attempts: 10s doubling per attempt up to an hour, less up to half of it
+// at random so retries from an outage don't arrive together.
+func webhookBackoff(attempts int) time.Duration {
+	d := min(10*time.Second<<min(attempts-1, 16), time.Hour)
+	return d - rand.N(d/2+1)
+}
+
+// post sends d, signed, returning the receiver's Retry-After on failure.
+func (n *webhookNotifier) post(ctx context.Context, d webhookDelivery) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Paygate-Event", d.Type)
+	req.Header.Set("X-Paygate-Delivery", d.ID)
+	if len(n.secret) > 0 {
+		req.Header.Set("X-Paygate-Signature", signWebhook(n.secret, time.Now().Unix(), d.Body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return 0, nil
+}
+
+// signWebhook returns the X-Paygate-Signature header value
+// "t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">". Receivers recompute
+// it and reject old timestamps to stop replays.
+func signWebhook(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// memoryOutbox keeps deliveries in memory; they are lost on restart.
+type memoryOutbox struct {
+	mu         sync.Mutex
+	deliveries map[string]webhookDelivery
+	leased     map[string]time.Time
+}
+
+func newMemoryOutbox() *memoryOutbox {
+	return &memoryOutbox{deliveries: make(map[string]webhookDelivery), leased: make(map[string]time.Time)}
+}
+
+func (o *memoryOutbox) Add(_ context.Context, d webhookDelivery) error {
+	o.mu.Lock()
+	o.deliveries[d.ID] = d
+	o.mu.Unlock()
+	return nil
+}
+
+func (o *memoryOutbox) Claim(_ context.Context, now time.Time, lease time.Duration, limit int) ([]webhookDelivery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var due []webhookDelivery
+	for id, d := range o.deliveries {
+		if d.NextAttempt.After(now) || o.leased[id].After(now) {
+			continue
+		}
+		due = append(due, d)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for _, d := range due {
+		o.leased[d.ID] = now.Add(lease)
+	}
+	return due, nil
+}
+
+func (o *memoryOutbox) Reschedule(_ context.Context, d webhookDelivery) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.deliveries[d.ID] = d
+	delete(o.leased, d.ID)
+	return nil
+}
+
+func (o *memoryOutbox) Remove(_ context.Context, id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.deliveries, id)
+	delete(o.leased, id)
+	return nil
+}
+
+// redisOutbox keeps deliveries in the webhooks:outbox hash, scheduled by
+// the webhooks:due sorted set (score: next attempt in Unix milliseconds).
+type redisOutbox struct {
+	client *redis.Client
+}
+
+const (
+	redisOutboxKey = "webhooks:outbox"
+	redisDueKey    = "webhooks:due"
+)
+
+// claimDueScript pushes due IDs past the lease in one step, so instances
+// sharing the outbox never claim the same delivery.
+var claimDueScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, id in ipairs(ids) do
+  redis.call('ZADD', KEYS[1], ARGV[2], id)
+end
+return ids
+`)
+
+func (o *redisOutbox) Add(ctx context.Context, d webhookDelivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = o.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisOutboxKey, d.ID, data)
+		pipe.ZAdd(ctx, redisDueKey, redis.Z{Score: float64(d.NextAttempt.UnixMilli()), Member: d.ID})
+		return nil
+	})
+	return err
+}
+
+func (o *redisOutbox) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]webhookDelivery, error) {
+	ids, err := claimDueScript.Run(ctx, o.client, []string{redisDueKey},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), limit).StringSlice()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	raws, err := o.client.HMGet(ctx, redisOutboxKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var due []webhookDelivery
+	for i, raw := range raws {
+		s, ok := raw.(string)
+		var d webhookDelivery
+		if !ok || json.Unmarshal([]byte(s), &d) != nil {
+			// Removed meanwhile, or corrupt; nothing left to send
+			o.client.ZRem(ctx, redisDueKey, ids[i])
+			continue
+		}
+		due = append(due, d)
+	}
+	return due, nil
+}
+
+func (o *redisOutbox) Reschedule(ctx context.Context, d webhookDelivery) error {
+	return o.Add(ctx, d)
+}
+
+func (o *redisOutbox) Remove(ctx context.Context, id string) error {
+	_, err := o.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, redisOutboxKey, id)
+		pipe.ZRem(ctx, redisDueKey, id)
+		return nil
+	})
+	return err
+}
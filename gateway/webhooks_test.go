@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setupTestWebhooks installs a notifier that posts to url with an in-memory
+// outbox.
+func setupTestWebhooks(t *testing.T, url string) *webhookNotifier {
+	t.Helper()
+	events, _ := parseWebhookEvents("")
+	n := &webhookNotifier{
+		urls:        []string{url},
+		secret:      []byte("whsec"),
+		events:      events,
+		maxAttempts: 3,
+		outbox:      newMemoryOutbox(),
+		client:      &http.Client{Timeout: time.Second},
+		seenWallets: make(map[string]bool),
+	}
+	prev := operatorWebhooks
+	operatorWebhooks = n
+	t.Cleanup(func() { operatorWebhooks = prev })
+	return n
+}
+
+// dueNow claims every queued delivery as if its backoff had passed.
+func dueNow(t *testing.T, n *webhookNotifier) []webhookDelivery {
+	t.Helper()
+	due, err := n.outbox.Claim(context.Background(), time.Now().Add(2*time.Hour), time.Minute, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return due
+}
+
+func TestWebhookNotifier_SignsAndDelivers(t *testing.T) {
+	var mu sync.Mutex
+	var got []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got, bodies = append(got, r), append(bodies, body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	n := setupTestWebhooks(t, srv.URL)
+
+	notifyWebhooks(context.Background(), webhookCircuitOpened, map[string]interface{}{"provider": "openrouter"})
+	n.deliverDue(context.Background())
+
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 delivery, got %d", len(got))
+	}
+	r, body := got[0], bodies[0]
+	if r.Header.Get("X-Paygate-Event") != webhookCircuitOpened || r.Header.Get("X-Paygate-Delivery") == "" {
+		t.Errorf("Unexpected headers %v", r.Header)
+	}
+	sig := r.Header.Get("X-Paygate-Signature")
+	ts, _ := strconv.ParseInt(strings.TrimPrefix(strings.Split(sig, ",")[0], "t="), 10, 64)
+	if sig != signWebhook([]byte("whsec"), ts, body) || time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Errorf("Signature %q doesn't match the body", sig)
+	}
+	var payload WebhookPayload
+	if json.Unmarshal(body, &payload) != nil || payload.Type != webhookCircuitOpened || payload.Data["provider"] != "openrouter" || payload.ID == "" {
+		t.Errorf("Unexpected payload %s", body)
+	}
+	if due := dueNow(t, n); len(due) != 0 {
+		t.Errorf("Expected the delivered webhook to leave the outbox, %d remain", len(due))
+	}
+}
+
+func TestWebhookNotifier_RetriesThenGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(503)
+	}))
+	defer srv.Close()
+	n := setupTestWebhooks(t, srv.URL)
+	before := webhookDeliveriesTotal.Value(webhookSettlementFailed, "failed")
+
+	notifyWebhooks(context.Background(), webhookSettlementFailed, map[string]interface{}{"nonce": "n-1"})
+	n.deliverDue(context.Background())
+	due := dueNow(t, n)
+	if len(due) != 1 || due[0].Attempts != 1 || !due[0].NextAttempt.After(time.Now()) {
+		t.Fatalf("Expected the failed delivery to be rescheduled, got %+v", due)
+	}
+	n.attempt(context.Background(), due[0])
+	n.deliverDue(context.Background())
+	if calls.Load() != 2 {
+		t.Fatalf("Expected the rescheduled delivery to wait for its backoff, got %d calls", calls.Load())
+	}
+	n.attempt(context.Background(), dueNow(t, n)[0])
+
+	if calls.Load() != 3 || webhookDeliveriesTotal.Value(webhookSettlementFailed, "failed")-before != 1 {
+		t.Errorf("Expected to give up after 3 attempts, got %d calls", calls.Load())
+	}
+	if due := dueNow(t, n); len(due) != 0 {
+		t.Errorf("Expected the abandoned delivery to leave the outbox, %d remain", len(due))
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 10 * time.Second, 3: 40 * time.Second, 50: time.Hour} {
+		if got := webhookBackoff(attempts); got > want || got < want/2 {
+			t.Errorf("webhookBackoff(%d) = %s, want between %s and %s", attempts, got, want/2, want)
+		}
+	}
+}
+
+func TestNotifyFirstPayment_OncePerWallet(t *testing.T) {
+	setupTestRedis(t)
+	n := setupTestWebhooks(t, "http://127.0.0.1:1")
+	notifyFirstPayment(context.Background(), "0xAA", "n-1", "0.001")
+	notifyFirstPayment(context.Background(), "0xaa", "n-2", "0.001")
+	notifyFirstPayment(context.Background(), "0xbb", "n-3", "0.001")
+
+	due := dueNow(t, n)
+	if len(due) != 2 {
+		t.Fatalf("Expected one webhook per new wallet, got %d", len(due))
+	}
+	for _, d := range due {
+		if d.Type != webhookWalletFirstPayment {
+			t.Errorf("Unexpected type %s", d.Type)
+		}
+	}
+}
+
+func TestParseWebhookEvents(t *testing.T) {
+	events, err := parseWebhookEvents("settlement.failed, provider.circuit_opened")
+	if err != nil || len(events) != 2 || !events[webhookSettlementFailed] {
+		t.Errorf("Unexpected events %v: %v", events, err)
+	}
+	if _, err := parseWebhookEvents("payment.refunded"); err == nil {
+		t.Error("Expected an unknown event to be rejected")
+	}
+}
+
+func TestRedisOutbox(t *testing.T) {
+	setupTestRedis(t)
+	o := &redisOutbox{client: redisClient}
+	ctx := context.Background()
+	now := time.Now()
+	o.Add(ctx, webhookDelivery{ID: "a", URL: "http://a", Body: []byte(`{}`), NextAttempt: now})
+	o.Add(ctx, webhookDelivery{ID: "b", URL: "http://b", NextAttempt: now.Add(time.Hour)})
+
+	due, err := o.Claim(ctx, now, time.Minute, 10)
+	if err != nil || len(due) != 1 || due[0].ID != "a" || string(due[0].Body) != `{}` {
+		t.Fatalf("Expected only a to be due, got %+v: %v", due, err)
+	}
+	if again, _ := o.Claim(ctx, now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("Expected a claimed delivery to be leased, got %+v", again)
+	}
+	if later, _ := o.Claim(ctx, now.Add(2*time.Minute), time.Minute, 10); len(later) != 1 {
+		t.Errorf("Expected an expired lease to be claimable again, got %+v", later)
+	}
+
+	o.Remove(ctx, "a")
+	if n, _ := redisClient.HLen(ctx, redisOutboxKey).Result(); n != 1 {
+		t.Errorf("Expected 1 delivery left, got %d", n)
+	}
+}
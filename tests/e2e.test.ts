@@ -8,9 +8,43 @@ const VERIFIER_URL = "http://localhost:3002";
 const PRIVATE_KEY = "0x0123456789012345678901234567890123456789012345678901234567890123";
 const wallet = new ethers.Wallet(PRIVATE_KEY);
 
+// Signs a payment context the way the web client does, including the
+// bodyHash the gateway binds the payment to
+async function signPayment(paymentContext: any): Promise<string> {
+  const domain = {
+    name: "MicroAI Paygate",
+    version: "1",
+    chainId: paymentContext.chainId,
+    verifyingContract: ethers.ZeroAddress,
+  };
+
+  const types = {
+    Payment: [
+      { name: "recipient", type: "address" },
+      { name: "token", type: "string" },
+      { name: "amount", type: "string" },
+      { name: "nonce", type: "string" },
+    ],
+  };
+
+  const value: Record<string, string> = {
+    recipient: paymentContext.recipient,
+    token: paymentContext.token,
+    amount: paymentContext.amount,
+    nonce: paymentContext.nonce,
+  };
+
+  if (paymentContext.bodyHash) {
+    types.Payment.push({ name: "bodyHash", type: "bytes32" });
+    value.bodyHash = paymentContext.bodyHash;
+  }
+
+  return wallet.signTypedData(domain, types, value);
+}
+
 describe("MicroAI Paygate E2E Flow", () => {
   it("should return 402 Payment Required initially", async () => {
-    const res = await fetch(`${GATEWAY_URL}/api/ai/summarize`, {
+    const res = await fetch(`${GATEWAY_URL}/v1/ai/summarize`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
       body: JSON.stringify({ text: "Hello world" }),
@@ -18,56 +52,37 @@ describe("MicroAI Paygate E2E Flow", () => {
 
     expect(res.status).toBe(402);
     const data = await res.json() as any;
-    expect(data.error).toBe("Payment Required");
+    expect(data.code).toBe("payment_required");
     expect(data.paymentContext).toBeDefined();
     expect(data.paymentContext.nonce).toBeDefined();
   });
 
   it("should accept a valid signature and return result", async () => {
+    const text = "This is a test text to summarize.";
+
     // 1. Get Nonce
-    const initRes = await fetch(`${GATEWAY_URL}/api/ai/summarize`, {
+    const initRes = await fetch(`${GATEWAY_URL}/v1/ai/summarize`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ text: "Hello world" }),
+      body: JSON.stringify({ text }),
     });
     const initData = await initRes.json() as any;
     const { paymentContext } = initData;
+    expect(paymentContext.bodyHash).toBe(ethers.id(text));
 
     // 2. Sign Data
-    const domain = {
-      name: "MicroAI Paygate",
-      version: "1",
-      chainId: paymentContext.chainId,
-      verifyingContract: ethers.ZeroAddress,
-    };
-
-    const types = {
-      Payment: [
-        { name: "recipient", type: "address" },
-        { name: "token", type: "string" },
-        { name: "amount", type: "string" },
-        { name: "nonce", type: "string" },
-      ],
-    };
-
-    const value = {
-      recipient: paymentContext.recipient,
-      token: paymentContext.token,
-      amount: paymentContext.amount,
-      nonce: paymentContext.nonce,
-    };
-
-    const signature = await wallet.signTypedData(domain, types, value);
+    const signature = await signPayment(paymentContext);
 
     // 3. Send Signed Request
-    const res = await fetch(`${GATEWAY_URL}/api/ai/summarize`, {
+    const res = await fetch(`${GATEWAY_URL}/v1/ai/summarize`, {
       method: "POST",
       headers: {
         "Content-Type": "application/json",
         "X-402-Signature": signature,
         "X-402-Nonce": paymentContext.nonce,
+        "X-402-Payment": btoa(JSON.stringify(paymentContext)),
       },
-      body: JSON.stringify({ text: "This is a test text to summarize." }),
+      body: JSON.stringify({ text }),
     });
 
     // Note: It might fail if OpenRouter key is invalid, but we expect at least not 402/403
@@ -85,4 +100,40 @@ describe("MicroAI Paygate E2E Flow", () => {
     const data = await res.json() as any;
     expect(data.result).toBeDefined();
   }, 30000);
+
+  it("should not charge the signer when the signature is replayed on another text", async () => {
+    const initRes = await fetch(`${GATEWAY_URL}/v1/ai/summarize`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ text: "The text the wallet agreed to pay for." }),
+    });
+    const { paymentContext } = await initRes.json() as any;
+    const signature = await signPayment(paymentContext);
+
+    const replay = (headers: Record<string, string>) =>
+      fetch(`${GATEWAY_URL}/v1/ai/summarize`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-402-Signature": signature,
+          "X-402-Nonce": paymentContext.nonce,
+          ...headers,
+        },
+        body: JSON.stringify({ text: "Some other text the attacker wants summarized." }),
+      });
+
+    // Declaring the signed context exposes the mismatch
+    const declared = await replay({ "X-402-Payment": btoa(JSON.stringify(paymentContext)) });
+    expect(declared.status).toBe(402);
+    const problem = await declared.json() as any;
+    expect(problem.code).toBe("payment_mismatch");
+    expect(problem.field).toBe("bodyHash");
+
+    // Without it the signature recovers to some other address, never the signer's
+    const res = await replay({});
+    if (res.status === 200) {
+      const data = await res.json() as any;
+      expect(data.receipt.receipt.payment.payer.toLowerCase()).not.toBe(wallet.address.toLowerCase());
+    }
+  }, 30000);
 });
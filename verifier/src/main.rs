@@ -1,6 +1,6 @@
 use axum::{
     extract::Json,
-    http::StatusCode,
+    http::{HeaderMap, StatusCode},
     routing::{get, post},
     Router,
 };
@@ -42,6 +42,9 @@ struct PaymentContext {
     nonce: String,
     #[serde(rename = "chainId")]
     chain_id: u64,
+    // Set by the gateway when payments are bound to the request text
+    #[serde(rename = "bodyHash", default)]
+    body_hash: Option<String>,
 }
 
 #[derive(Serialize)]
@@ -52,11 +55,16 @@ struct VerifyResponse {
 }
 
 async fn verify_signature(
+    headers: HeaderMap,
     Json(payload): Json<VerifyRequest>,
 ) -> (StatusCode, Json<VerifyResponse>) {
+    // Set by the gateway so these lines can be joined with its logs
+    let request_id = header_value(&headers, "x-request-id");
+    let traceparent = header_value(&headers, "traceparent");
+    let tier = header_value(&headers, "x-paygate-tier");
     println!(
-        "Received verification request for nonce: {}",
-        payload.context.nonce
+        "Received verification request for nonce: {} request_id={} traceparent={} tier={}",
+        payload.context.nonce, request_id, traceparent, tier
     );
     // Construct the EIP-712 Typed Data
     // Note: In a real production app, we should use the proper EIP-712 struct definitions with ethers-rs macros.
@@ -70,23 +78,34 @@ async fn verify_signature(
     });
 
     // Types
-    let types = serde_json::json!({
-        "Payment": [
-            { "name": "recipient", "type": "address" },
-            { "name": "token", "type": "string" },
-            { "name": "amount", "type": "string" },
-            { "name": "nonce", "type": "string" }
-        ]
-    });
+    let mut fields = vec![
+        serde_json::json!({ "name": "recipient", "type": "address" }),
+        serde_json::json!({ "name": "token", "type": "string" }),
+        serde_json::json!({ "name": "amount", "type": "string" }),
+        serde_json::json!({ "name": "nonce", "type": "string" }),
+    ];
 
     // Value
-    let value = serde_json::json!({
+    let mut value = serde_json::json!({
         "recipient": payload.context.recipient,
         "token": payload.context.token,
         "amount": payload.context.amount,
         "nonce": payload.context.nonce
     });
 
+    // A bound payment signs the text's hash too, so the signature is only
+    // valid for the text the gateway received
+    if let Some(body_hash) = payload
+        .context
+        .body_hash
+        .as_deref()
+        .filter(|h| !h.is_empty())
+    {
+        fields.push(serde_json::json!({ "name": "bodyHash", "type": "bytes32" }));
+        value["bodyHash"] = serde_json::Value::from(body_hash);
+    }
+    let types = serde_json::json!({ "Payment": fields });
+
     let typed_data = serde_json::json!({
         "domain": domain,
         "types": types,
@@ -127,7 +146,10 @@ async fn verify_signature(
     // Verify
     match signature.recover_typed_data(&typed_data) {
         Ok(address) => {
-            println!("Signature valid! Recovered: {:?}", address);
+            println!(
+                "Signature valid! Recovered: {:?} request_id={}",
+                address, request_id
+            );
             (
                 StatusCode::OK,
                 Json(VerifyResponse {
@@ -138,7 +160,7 @@ async fn verify_signature(
             )
         }
         Err(e) => {
-            println!("Verification failed: {}", e);
+            println!("Verification failed: {} request_id={}", e, request_id);
             (
                 StatusCode::OK,
                 Json(VerifyResponse {
@@ -151,6 +173,14 @@ async fn verify_signature(
     }
 }
 
+/// Returns a header as a string, or "-" when it is absent or not ASCII.
+fn header_value<'a>(headers: &'a HeaderMap, name: &str) -> &'a str {
+    headers
+        .get(name)
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or("-")
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -208,11 +238,12 @@ mod tests {
                 amount: "100".to_string(),
                 nonce: "unique-nonce-123".to_string(),
                 chain_id: 1,
+                body_hash: None,
             },
             signature: signature_str,
         };
 
-        let (status, Json(response)) = verify_signature(Json(req)).await;
+        let (status, Json(response)) = verify_signature(HeaderMap::new(), Json(req)).await;
 
         assert_eq!(status, StatusCode::OK);
         assert!(response.is_valid);
@@ -228,11 +259,85 @@ mod tests {
                 amount: "100".to_string(),
                 nonce: "nonce".to_string(),
                 chain_id: 1,
+                body_hash: None,
             },
             signature: "0x1234567890".to_string(),
         };
 
-        let (status, _) = verify_signature(Json(req)).await;
+        let (status, _) = verify_signature(HeaderMap::new(), Json(req)).await;
         assert_eq!(status, StatusCode::BAD_REQUEST);
     }
+
+    #[tokio::test]
+    async fn test_verify_signature_bound_to_body_hash() {
+        let wallet: LocalWallet =
+            "380eb0f3d505f087e438eca80bc4df9a7faa24f868e69fc0440261a0fc0567dc"
+                .parse()
+                .unwrap();
+        let body_hash = format!(
+            "0x{}",
+            hex::encode(ethers::utils::keccak256("the signed text"))
+        );
+
+        let json_typed_data = serde_json::json!({
+            "domain": {
+                "name": "MicroAI Paygate",
+                "version": "1",
+                "chainId": 1,
+                "verifyingContract": "0x0000000000000000000000000000000000000000"
+            },
+            "types": {
+                "EIP712Domain": [
+                    { "name": "name", "type": "string" },
+                    { "name": "version", "type": "string" },
+                    { "name": "chainId", "type": "uint256" },
+                    { "name": "verifyingContract", "type": "address" }
+                ],
+                "Payment": [
+                    { "name": "recipient", "type": "address" },
+                    { "name": "token", "type": "string" },
+                    { "name": "amount", "type": "string" },
+                    { "name": "nonce", "type": "string" },
+                    { "name": "bodyHash", "type": "bytes32" }
+                ]
+            },
+            "primaryType": "Payment",
+            "message": {
+                "recipient": "0x1234567890123456789012345678901234567890",
+                "token": "USDC",
+                "amount": "100",
+                "nonce": "unique-nonce-456",
+                "bodyHash": body_hash
+            }
+        });
+        let typed_data: TypedData = serde_json::from_value(json_typed_data).unwrap();
+        let signature = wallet.sign_typed_data(&typed_data).await.unwrap();
+        let signature_str = format!("0x{}", hex::encode(signature.to_vec()));
+
+        let request = |hash: String| VerifyRequest {
+            context: PaymentContext {
+                recipient: "0x1234567890123456789012345678901234567890".to_string(),
+                token: "USDC".to_string(),
+                amount: "100".to_string(),
+                nonce: "unique-nonce-456".to_string(),
+                chain_id: 1,
+                body_hash: Some(hash),
+            },
+            signature: signature_str.clone(),
+        };
+        let expected = format!("{:?}", wallet.address());
+
+        let (_, Json(response)) =
+            verify_signature(HeaderMap::new(), Json(request(body_hash))).await;
+        assert_eq!(response.recovered_address, Some(expected.clone()));
+
+        // The same signature presented for another text recovers someone else
+        let other_hash = format!(
+            "0x{}",
+            hex::encode(ethers::utils::keccak256("another text"))
+        );
+        let (_, Json(response)) =
+            verify_signature(HeaderMap::new(), Json(request(other_hash))).await;
+        assert_ne!(response.recovered_address, Some(expected));
+    }
 }
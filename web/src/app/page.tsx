@@ -16,6 +16,7 @@ type PaymentContext = {
   amount: string;
   nonce: string;
   chainId: number;
+  bodyHash?: string;
 };
 
 export default function Home() {
@@ -32,7 +33,7 @@ export default function Home() {
 
     try {
       // 1. Initial Request
-      let response = await fetch("http://localhost:3000/api/ai/summarize", {
+      let response = await fetch("http://localhost:3000/v1/ai/summarize", {
         method: "POST",
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify({ text: input }),
@@ -98,24 +99,31 @@ export default function Home() {
           ],
         };
 
-        const value = {
+        const value: Record<string, string> = {
           recipient: paymentContext.recipient,
           token: paymentContext.token,
           amount: paymentContext.amount,
           nonce: paymentContext.nonce,
         };
 
+        // The gateway binds the payment to this exact text
+        if (paymentContext.bodyHash) {
+          types.Payment.push({ name: "bodyHash", type: "bytes32" });
+          value.bodyHash = paymentContext.bodyHash;
+        }
+
         setStatus("Please sign the transaction in your wallet...");
         const signature = await signer.signTypedData(domain, types, value);
 
         // 4. Retry Request
         setStatus("Signature received. Retrying request...");
-        response = await fetch("http://localhost:3000/api/ai/summarize", {
+        response = await fetch("http://localhost:3000/v1/ai/summarize", {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
             "X-402-Signature": signature,
             "X-402-Nonce": paymentContext.nonce,
+            "X-402-Payment": btoa(JSON.stringify(paymentContext)),
           },
           body: JSON.stringify({ text: input }),
         });
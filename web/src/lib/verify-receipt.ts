@@ -20,10 +20,17 @@ export interface PaymentDetails {
   nonce: string;
 }
 
+export interface UsageDetails {
+  prompt_tokens: number;
+  completion_tokens: number;
+  cost?: string;
+}
+
 export interface ServiceDetails {
   endpoint: string;
   request_hash: string;
   response_hash: string;
+  usage?: UsageDetails;
 }
 
 export interface Receipt {
@@ -48,7 +55,7 @@ export interface SignedReceipt {
  * 
  * @example
  * ```typescript
- * const response = await fetch('/api/ai/summarize', { ...headers... });
+ * const response = await fetch('/v1/ai/summarize', { ...headers... });
  * const data = await response.json();
  * const isValid = await verifyReceipt(data.receipt);
  * console.log(`Receipt valid: ${isValid}`);
@@ -137,7 +144,7 @@ export async function fetchReceipt(
   gatewayUrl: string = 'http://localhost:3000'
 ): Promise<SignedReceipt | null> {
   try {
-    const response = await fetch(`${gatewayUrl}/api/receipts/${receiptId}`);
+    const response = await fetch(`${gatewayUrl}/v1/receipts/${receiptId}`);
     
     if (response.status === 404) {
       return null;